@@ -0,0 +1,346 @@
+// Package cdc streams committed writes from a triff database to external
+// systems. Events are first appended to a local journal and then delivered
+// to the configured sink in batches; the journal offset of the last
+// delivered batch is checkpointed, so delivery is at-least-once across
+// restarts and sink outages.
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+const (
+	journalFile    = "events.log"
+	checkpointFile = "checkpoint"
+
+	// compactSize is the journal size above which it is truncated once
+	// every event in it has been delivered
+	compactSize = 64 * 1024 * 1024
+
+	maxBackoff = 30 * time.Second
+)
+
+// Capture records database changes and ships them to a sink
+type Capture struct {
+	db     *core.Database
+	config core.CDCConfig
+	sink   Sink
+	logger *utils.Logger
+
+	journal  *os.File
+	position int64 // Journal offset up to which events were delivered
+
+	pending    []core.ChangeEvent
+	journaling int           // Events being written to the journal
+	flushTime  time.Duration // How long the last journal write took
+	stopped    bool
+	mu         sync.Mutex
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Status reports the state of a capture
+type Status struct {
+	Sink      string `json:"sink"`
+	Position  int64  `json:"position"`  // Journal bytes delivered
+	Journaled int64  `json:"journaled"` // Journal bytes written
+	Pending   int    `json:"pending"`   // Events not yet journaled
+}
+
+// New creates a capture for the sink described by config
+func New(db *core.Database, config core.CDCConfig, logger *utils.Logger) (*Capture, error) {
+	sink, err := NewSink(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{
+		db:     db,
+		config: config,
+		sink:   sink,
+		logger: logger,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start opens the journal, subscribes to database changes and begins
+// delivering events, including any left undelivered by a previous run
+func (c *Capture) Start() error {
+	if err := os.MkdirAll(c.config.Journal, 0755); err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(filepath.Join(c.config.Journal, journalFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.journal = journal
+	c.position = c.readCheckpoint()
+
+	c.db.OnChange(c.capture)
+	c.db.RegisterWriteQueue("cdc", c)
+	go c.run()
+	c.logger.Info(fmt.Sprintf("CDC streaming changes to %s sink", c.config.Sink))
+	return nil
+}
+
+// Stop journals buffered events, makes a last delivery attempt and closes
+// the sink. Events that could not be delivered stay in the journal.
+func (c *Capture) Stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	c.mu.Unlock()
+
+	if c.journal == nil {
+		// Never started
+		return c.sink.Close()
+	}
+	close(c.stop)
+	<-c.done
+	if err := c.sink.Close(); err != nil {
+		c.journal.Close()
+		return err
+	}
+	return c.journal.Close()
+}
+
+// Status returns delivery progress
+func (c *Capture) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := Status{Sink: c.config.Sink, Position: c.position, Pending: len(c.pending)}
+	if info, err := c.journal.Stat(); err == nil {
+		status.Journaled = info.Size()
+	}
+	return status
+}
+
+// QueueDepth is the number of events not yet in the journal, for write
+// backpressure
+func (c *Capture) QueueDepth() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending) + c.journaling
+}
+
+// FlushLatency is how long the last journal write and sync took
+func (c *Capture) FlushLatency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flushTime
+}
+
+// capture is the database change listener. It runs under the database
+// lock, so it only buffers the event.
+func (c *Capture) capture(event core.ChangeEvent) {
+	if event.Op != core.ChangeFlush && !c.matches(event.Key) {
+		return
+	}
+
+	c.mu.Lock()
+	if !c.stopped {
+		c.pending = append(c.pending, event)
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Capture) matches(key string) bool {
+	if len(c.config.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.config.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// run journals and delivers events until stopped, backing off while the
+// sink is failing
+func (c *Capture) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	backoff := time.Duration(0)
+	var retryAt time.Time
+	for {
+		select {
+		case <-c.stop:
+			c.writeJournal()
+			c.deliver()
+			return
+		case <-c.notify:
+		case <-ticker.C:
+		}
+
+		c.writeJournal()
+		if time.Now().Before(retryAt) {
+			continue
+		}
+		if err := c.deliver(); err != nil {
+			if backoff == 0 {
+				backoff = time.Second
+			} else if backoff < maxBackoff {
+				backoff *= 2
+			}
+			retryAt = time.Now().Add(backoff)
+			c.logger.Warn(fmt.Sprintf("cdc: delivery to %s failed, retrying in %s: %v", c.config.Sink, backoff, err))
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// writeJournal appends buffered events to the journal
+func (c *Capture) writeJournal() {
+	c.mu.Lock()
+	events := c.pending
+	c.pending = nil
+	c.journaling = len(events)
+	c.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	start := time.Now()
+	defer func() {
+		c.mu.Lock()
+		c.journaling = 0
+		c.flushTime = time.Since(start)
+		c.mu.Unlock()
+	}()
+
+	writer := bufio.NewWriter(c.journal)
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			c.logger.Error(fmt.Sprintf("cdc: dropping event for key %s: %v", event.Key, err))
+			continue
+		}
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		c.logger.Error(fmt.Sprintf("cdc: writing journal: %v", err))
+		return
+	}
+	c.journal.Sync()
+}
+
+// deliver sends journaled events after the checkpoint to the sink in
+// batches, advancing the checkpoint after each acknowledged batch
+func (c *Capture) deliver() error {
+	for {
+		batch, size, err := c.readBatch()
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return c.compact()
+		}
+		if err := c.sink.Send(batch); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.position += size
+		c.mu.Unlock()
+		if err := c.writeCheckpoint(); err != nil {
+			return err
+		}
+	}
+}
+
+// readBatch reads up to BatchSize complete events after the checkpoint
+func (c *Capture) readBatch() ([][]byte, int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(c.journal, c.position, 1<<62))
+	var batch [][]byte
+	var size int64
+	for len(batch) < c.config.BatchSize {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Ignore a partially written trailing line
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+		batch = append(batch, line[:len(line)-1])
+	}
+	return batch, size, nil
+}
+
+// compact truncates a large journal once everything in it was delivered
+func (c *Capture) compact() error {
+	info, err := c.journal.Stat()
+	if err != nil || info.Size() < compactSize || c.position < info.Size() {
+		return err
+	}
+	if err := c.journal.Truncate(0); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.position = 0
+	c.mu.Unlock()
+	return c.writeCheckpoint()
+}
+
+func (c *Capture) readCheckpoint() int64 {
+	data, err := os.ReadFile(filepath.Join(c.config.Journal, checkpointFile))
+	if err != nil {
+		return 0
+	}
+	position, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || position < 0 {
+		return 0
+	}
+	// A checkpoint past the end means the journal was truncated
+	if info, err := c.journal.Stat(); err == nil && position > info.Size() {
+		return 0
+	}
+	return position
+}
+
+// writeCheckpoint persists the delivered position atomically
+func (c *Capture) writeCheckpoint() error {
+	c.mu.Lock()
+	position := c.position
+	c.mu.Unlock()
+
+	path := filepath.Join(c.config.Journal, checkpointFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(position, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
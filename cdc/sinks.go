@@ -0,0 +1,211 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// Sink delivers batches of JSON-encoded change events. Send must only
+// return nil once the whole batch has been accepted by the destination.
+type Sink interface {
+	Send(events [][]byte) error
+	Close() error
+}
+
+// NewSink creates the sink selected by config.Sink
+func NewSink(config core.CDCConfig) (Sink, error) {
+	switch config.Sink {
+	case "webhook":
+		return &webhookSink{url: config.URL, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case "kafka":
+		return &kafkaSink{
+			url:    strings.TrimSuffix(config.URL, "/") + "/topics/" + url.PathEscape(config.Topic),
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	case "nats":
+		return &natsSink{address: strings.TrimPrefix(config.URL, "nats://"), subject: config.Topic}, nil
+	case "file":
+		return &fileSink{path: config.URL}, nil
+	}
+	return nil, fmt.Errorf("unknown cdc sink: %s", config.Sink)
+}
+
+// webhookSink POSTs each batch as a JSON array
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Send(events [][]byte) error {
+	body := append([]byte{'['}, bytes.Join(events, []byte{','})...)
+	body = append(body, ']')
+	return postBatch(s.client, s.url, "application/json", body)
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+// kafkaSink produces to a topic through the Confluent REST Proxy (v2 API),
+// keying records by the changed key so a key's events stay ordered
+type kafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *kafkaSink) Send(events [][]byte) error {
+	type record struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	records := make([]record, len(events))
+	for i, event := range events {
+		var header struct {
+			Key string `json:"key"`
+		}
+		json.Unmarshal(event, &header)
+		records[i] = record{Key: header.Key, Value: event}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	return postBatch(s.client, s.url, "application/vnd.kafka.json.v2+json", body)
+}
+
+func (s *kafkaSink) Close() error {
+	return nil
+}
+
+func postBatch(client *http.Client, url, contentType string, body []byte) error {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return nil
+}
+
+// natsSink publishes each event to a subject using the NATS text protocol.
+// A PING after the batch is answered only once the server has processed
+// every PUB before it, which acknowledges the batch.
+type natsSink struct {
+	address string
+	subject string
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func (s *natsSink) Send(events [][]byte) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", s.subject, len(event))
+		buf.Write(event)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.Close()
+		return err
+	}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			s.Close()
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			return nil
+		case strings.HasPrefix(line, "PING"):
+			s.conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			s.Close()
+			return fmt.Errorf("nats: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// The server greets with INFO before accepting CONNECT
+	info, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting from %s", s.address)
+	}
+	if _, err := conn.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"name":"triff-cdc"}` + "\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.reader = conn, reader
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
+
+// fileSink appends events as JSON lines to a local file
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Send(events [][]byte) error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		buf.Write(event)
+		buf.WriteByte('\n')
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned when the server replies with a nil bulk or array
+var ErrNil = errors.New("triff: nil reply")
+
+// ErrNullEntry is returned by Get for a key holding a null entry, a miss
+// cached with SetNull, where a key that doesn't exist gets ErrNil
+var ErrNullEntry = errors.New("triff: null entry")
+
+// nilArray is the reply that comes with ErrNil for a nil array, which GET
+// answers for a null entry
+type nilArray struct{}
+
+// ServerError is an error reply (-ERR ...) sent by the server
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// Options configures a client connection
+type Options struct {
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+
+	// Password is sent with AUTH on connecting, for admin commands on
+	// servers with security.password set
+	Password string
+
+	// TLS connects over TLS, for servers with security.tls_cert set
+	TLS *tls.Config
+}
+
+// Client is a connection to a triff TCP server. It is safe for concurrent
+// use; commands are serialized over the single connection.
+type Client struct {
+	addr    string
+	options Options
+	conn    net.Conn
+	reader  *bufio.Reader
+	mu      sync.Mutex
+}
+
+// Dial connects to a triff server at addr (host:port)
+func Dial(addr string) (*Client, error) {
+	return DialWithOptions(addr, Options{DialTimeout: 5 * time.Second})
+}
+
+// DialWithOptions connects to a triff server with custom timeouts
+func DialWithOptions(addr string, options Options) (*Client, error) {
+	var conn net.Conn
+	var err error
+	if options.TLS != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: options.DialTimeout}, "tcp", addr, options.TLS)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, options.DialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		addr:    addr,
+		options: options,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+	}
+	if options.Password != "" {
+		if _, err := client.Do("AUTH", options.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns the decoded reply: string for status and
+// bulk replies, int64 for integers and []interface{} for arrays
+func (c *Client) Do(args ...string) (interface{}, error) {
+	if err := checkArgs(args); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.options.ReadTimeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.options.ReadTimeout))
+	}
+
+	if _, err := c.conn.Write(commandLine(args)); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// checkArgs rejects empty commands
+func checkArgs(args []string) error {
+	if len(args) == 0 {
+		return errors.New("triff: empty command")
+	}
+	return nil
+}
+
+// commandLine encodes a command as an inline command line, quoting the
+// arguments that are empty or contain spaces, quotes or control characters
+func commandLine(args []string) []byte {
+	var line []byte
+	for i, arg := range args {
+		if i > 0 {
+			line = append(line, ' ')
+		}
+		line = appendArg(line, arg)
+	}
+	return append(line, '\r', '\n')
+}
+
+func appendArg(line []byte, arg string) []byte {
+	plain := arg != ""
+	for i := 0; i < len(arg) && plain; i++ {
+		c := arg[i]
+		plain = c > ' ' && c != 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return append(line, arg...)
+	}
+	line = append(line, '"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; {
+		case c == '"' || c == '\\':
+			line = append(line, '\\', c)
+		case c == '\n':
+			line = append(line, '\\', 'n')
+		case c == '\r':
+			line = append(line, '\\', 'r')
+		case c < ' ' || c == 0x7f:
+			line = append(line, fmt.Sprintf("\\x%02x", c)...)
+		default:
+			line = append(line, c)
+		}
+	}
+	return append(line, '"')
+}
+
+// Ping checks that the server is reachable
+func (c *Client) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Get returns the string value of key, ErrNil if it does not exist or
+// ErrNullEntry if it holds a null entry
+func (c *Client) Get(key string) (string, error) {
+	return getReply(c.Do("GET", key))
+}
+
+// SetNull stores a null entry for key that expires after ttl, caching
+// that the record it stands for doesn't exist
+func (c *Client) SetNull(key string, ttl time.Duration) error {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	_, err := c.Do("SETNULL", key, strconv.FormatInt(seconds, 10))
+	return err
+}
+
+// FetchResult is what Fetch found. Found is false for a missing key. A
+// Token grants the caller the computation of the value, which it must
+// pass to Fill or Abandon before the lock runs out.
+type FetchResult struct {
+	Value string
+	Found bool
+	Stale bool
+	Token string
+}
+
+// Fetch reads key like Get, but when it is missing only one caller at a
+// time gets a Token to compute it; the others wait up to timeout (0 for
+// as long as it takes) for that caller's Fill, or are served the stale
+// value if there is one. It returns ErrNullEntry for a null entry.
+func (c *Client) Fetch(key string, lock, timeout time.Duration) (FetchResult, error) {
+	args := []string{"FETCH", key, strconv.FormatInt(lock.Milliseconds(), 10)}
+	if timeout > 0 {
+		args = append(args, "TIMEOUT", strconv.FormatInt(timeout.Milliseconds(), 10))
+	}
+	reply, err := c.Do(args...)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 3 {
+		return FetchResult{}, fmt.Errorf("triff: unexpected FETCH reply %v", reply)
+	}
+	var result FetchResult
+	result.Value, result.Found = items[0].(string)
+	result.Stale = items[1] == int64(1)
+	result.Token, _ = items[2].(string)
+	// A miss always comes with a token, so no value and no token is a
+	// null entry
+	if !result.Found && result.Token == "" {
+		return FetchResult{}, ErrNullEntry
+	}
+	return result, nil
+}
+
+// Fill stores the value computed under a Fetch token with an optional TTL
+// (0 for none). It returns false if the token expired and the computation
+// passed to another caller.
+func (c *Client) Fill(key, token, value string, ttl time.Duration) (bool, error) {
+	args := []string{"FILL", key, token, value}
+	if ttl > 0 {
+		seconds := int64((ttl + time.Second - 1) / time.Second)
+		args = append(args, "EX", strconv.FormatInt(seconds, 10))
+	}
+	filled, err := Int(c.Do(args...))
+	return filled == 1, err
+}
+
+// Abandon gives up a Fetch token without storing a value, so a caller
+// waiting for the key gets to compute it at once
+func (c *Client) Abandon(key, token string) (bool, error) {
+	abandoned, err := Int(c.Do("ABANDON", key, token))
+	return abandoned == 1, err
+}
+
+// Set stores a string value with an optional TTL (0 for none)
+func (c *Client) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(args...)
+	return err
+}
+
+// Del removes keys and returns how many existed
+func (c *Client) Del(keys ...string) (int64, error) {
+	return Int(c.Do(append([]string{"DEL"}, keys...)...))
+}
+
+// readReply decodes one reply from the connection
+func (c *Client) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("triff: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, ServerError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("triff: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("triff: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nilArray{}, ErrNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := c.readReply()
+			if err == ErrNil {
+				item = nil
+			} else if err != nil {
+				if _, ok := err.(ServerError); !ok {
+					return nil, err
+				}
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("triff: unexpected reply %q", line)
+}
+
+func (c *Client) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// String converts a reply to a string
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	}
+	return "", fmt.Errorf("triff: unexpected reply type %T", reply)
+}
+
+// getReply converts a GET reply to a string, telling a null entry apart
+// from a missing key
+func getReply(reply interface{}, err error) (string, error) {
+	if _, null := reply.(nilArray); null {
+		return "", ErrNullEntry
+	}
+	return String(reply, err)
+}
+
+// Int converts a reply to an int64
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("triff: unexpected reply type %T", reply)
+}
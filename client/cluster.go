@@ -0,0 +1,449 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoint is returned when no endpoint that could serve a command is
+// healthy
+var ErrNoEndpoint = errors.New("triff: no healthy endpoint")
+
+// readCommands are routed to replicas. Everything else, including commands
+// the client doesn't know, goes to the primary.
+var readCommands = map[string]bool{
+	"GET":         true,
+	"MGET":        true,
+	"GETSTALE":    true,
+	"GETREV":      true,
+	"VERSION":     true,
+	"EXISTS":      true,
+	"KEYS":        true,
+	"SCAN":        true,
+	"DBSIZE":      true,
+	"TTL":         true,
+	"STRLEN":      true,
+	"GETRANGE":    true,
+	"HGET":        true,
+	"HGETALL":     true,
+	"HRANDFIELD":  true,
+	"FIND":        true,
+	"QUERY":       true,
+	"IDX.LIST":    true,
+	"FT.LIST":     true,
+	"FT.SEARCH":   true,
+	"LBRANK":      true,
+	"LBTOP":       true,
+	"ZRANGEBYLEX": true,
+	"CRANGE":      true,
+	"QSTATS":      true,
+	"BF.EXISTS":   true,
+	"BF.MEXISTS":  true,
+	"BF.INFO":     true,
+	"CMS.QUERY":   true,
+	"CF.EXISTS":   true,
+	"CF.MEXISTS":  true,
+	"CF.COUNT":    true,
+	"PN.GET":      true,
+	"OR.MEMBERS":  true,
+	"OR.ISMEMBER": true,
+	"CRDT.STATE":  true,
+}
+
+// ClusterOptions configures a cluster of triff servers
+type ClusterOptions struct {
+	Options
+
+	// Endpoints lists the servers (host:port), primary first and then the
+	// replicas in the order they take over as primary
+	Endpoints []string
+
+	HealthInterval   time.Duration // Between health checks, 1s if zero
+	FailureThreshold int           // Consecutive failures that mark an endpoint down, 3 if zero
+
+	// OnFailover is called when writes move to another endpoint
+	OnFailover func(from, to string)
+}
+
+// EndpointStatus describes one endpoint of a cluster
+type EndpointStatus struct {
+	Addr     string
+	Primary  bool
+	Healthy  bool
+	Failures int // Consecutive failed commands and health checks
+}
+
+// endpoint is one server of a cluster. client is nil while disconnected.
+type endpoint struct {
+	addr     string
+	client   *Client
+	healthy  bool
+	failures int
+}
+
+// Cluster routes commands over several triff servers: writes to the
+// primary, reads round-robin to healthy replicas. Endpoints are checked in
+// the background; when the primary fails, the first healthy endpoint in
+// configuration order becomes the primary and stays so until it fails in
+// turn. Triff does not replicate by itself, so the servers must be kept in
+// sync externally, and reads from replicas may lag behind writes.
+type Cluster struct {
+	options   ClusterOptions
+	endpoints []*endpoint
+	primary   int
+	next      uint64 // Round-robin position for reads
+	mu        sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// DialCluster connects to the endpoints and starts health checking. It
+// fails only if no endpoint is reachable; the others are retried by the
+// health checks.
+func DialCluster(options ClusterOptions) (*Cluster, error) {
+	if len(options.Endpoints) == 0 {
+		return nil, errors.New("triff: cluster needs at least one endpoint")
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = 5 * time.Second
+	}
+	if options.HealthInterval <= 0 {
+		options.HealthInterval = time.Second
+	}
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = 3
+	}
+
+	c := &Cluster{
+		options: options,
+		primary: -1,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i, addr := range options.Endpoints {
+		ep := &endpoint{addr: addr, failures: options.FailureThreshold}
+		if client, err := DialWithOptions(addr, options.Options); err == nil {
+			ep.client, ep.healthy, ep.failures = client, true, 0
+			if c.primary < 0 {
+				c.primary = i
+			}
+		}
+		c.endpoints = append(c.endpoints, ep)
+	}
+	if c.primary < 0 {
+		return nil, fmt.Errorf("%w: none of %d endpoints reachable", ErrNoEndpoint, len(options.Endpoints))
+	}
+
+	go c.healthLoop()
+	return c, nil
+}
+
+// Close stops health checking and closes every connection
+func (c *Cluster) Close() error {
+	close(c.stop)
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ep := range c.endpoints {
+		if ep.client != nil {
+			ep.client.Close()
+			ep.client = nil
+		}
+	}
+	return nil
+}
+
+// Do sends a command to the endpoint it is routed to. Reads that fail on a
+// broken connection are retried on the next healthy endpoint; writes are
+// not retried, since the server may have applied them.
+func (c *Cluster) Do(args ...string) (interface{}, error) {
+	if err := checkArgs(args); err != nil {
+		return nil, err
+	}
+
+	if !readCommands[strings.ToUpper(args[0])] {
+		ep, err := c.primaryEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		return c.send(ep, args)
+	}
+
+	err := ErrNoEndpoint
+	for _, ep := range c.readEndpoints() {
+		reply, sendErr := c.send(ep, args)
+		if !isConnError(sendErr) {
+			return reply, sendErr
+		}
+		err = sendErr
+	}
+	return nil, err
+}
+
+// Primary returns the address writes currently go to
+func (c *Cluster) Primary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.endpoints[c.primary].addr
+}
+
+// Status reports every endpoint in configuration order
+func (c *Cluster) Status() []EndpointStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make([]EndpointStatus, len(c.endpoints))
+	for i, ep := range c.endpoints {
+		statuses[i] = EndpointStatus{
+			Addr:     ep.addr,
+			Primary:  i == c.primary,
+			Healthy:  ep.healthy,
+			Failures: ep.failures,
+		}
+	}
+	return statuses
+}
+
+// Ping checks that the primary is reachable
+func (c *Cluster) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Get returns the string value of key from a replica, ErrNil if it does
+// not exist or ErrNullEntry if it holds a null entry
+func (c *Cluster) Get(key string) (string, error) {
+	return getReply(c.Do("GET", key))
+}
+
+// Set stores a string value on the primary with an optional TTL (0 for
+// none)
+func (c *Cluster) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(args...)
+	return err
+}
+
+// Del removes keys on the primary and returns how many existed
+func (c *Cluster) Del(keys ...string) (int64, error) {
+	return Int(c.Do(append([]string{"DEL"}, keys...)...))
+}
+
+// NewMutex creates a lock on key, held on the primary
+func (c *Cluster) NewMutex(key string, ttl time.Duration) *Mutex {
+	return c.NewMutexWithOptions(key, ttl, DefaultLockOptions)
+}
+
+// NewMutexWithOptions creates a lock on the primary with custom retry
+// behaviour
+func (c *Cluster) NewMutexWithOptions(key string, ttl time.Duration, options LockOptions) *Mutex {
+	return &Mutex{
+		client:  c,
+		key:     key,
+		ttl:     ttl,
+		options: options,
+	}
+}
+
+// primaryEndpoint returns the endpoint writes go to
+func (c *Cluster) primaryEndpoint() (*endpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ep := c.endpoints[c.primary]
+	if !ep.healthy {
+		return nil, fmt.Errorf("%w: primary %s is down", ErrNoEndpoint, ep.addr)
+	}
+	return ep, nil
+}
+
+// readEndpoints returns the healthy replicas in round-robin order, with
+// the primary last as a fallback
+func (c *Cluster) readEndpoints() []*endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.endpoints)
+	c.next++
+	start := int(c.next % uint64(n))
+	candidates := make([]*endpoint, 0, n)
+	for i := 0; i < n; i++ {
+		index := (start + i) % n
+		if index != c.primary && c.endpoints[index].healthy {
+			candidates = append(candidates, c.endpoints[index])
+		}
+	}
+	if primary := c.endpoints[c.primary]; primary.healthy {
+		candidates = append(candidates, primary)
+	}
+	return candidates
+}
+
+// send runs a command on one endpoint, reconnecting if needed, and records
+// whether the endpoint answered
+func (c *Cluster) send(ep *endpoint, args []string) (interface{}, error) {
+	client, err := c.connect(ep)
+	if err != nil {
+		c.failed(ep, nil)
+		return nil, err
+	}
+	reply, err := client.Do(args...)
+	if isConnError(err) {
+		c.failed(ep, client)
+	} else {
+		c.answered(ep)
+	}
+	return reply, err
+}
+
+// connect returns the endpoint's connection, dialing a new one if it was
+// dropped
+func (c *Cluster) connect(ep *endpoint) (*Client, error) {
+	c.mu.Lock()
+	client := ep.client
+	c.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := DialWithOptions(ep.addr, c.options.Options)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ep.client != nil {
+		// Another caller reconnected first
+		client.Close()
+		return ep.client, nil
+	}
+	ep.client = client
+	return client, nil
+}
+
+// answered marks an endpoint healthy after it replied, taking over as
+// primary if the primary is down
+func (c *Cluster) answered(ep *endpoint) {
+	c.mu.Lock()
+	ep.failures = 0
+	ep.healthy = true
+	from, to, moved := c.failover()
+	c.mu.Unlock()
+
+	c.notifyFailover(from, to, moved)
+}
+
+// failed drops a broken connection and counts the failure, failing over
+// once the primary has failed FailureThreshold times in a row
+func (c *Cluster) failed(ep *endpoint, client *Client) {
+	c.mu.Lock()
+	if client != nil && ep.client == client {
+		ep.client.Close()
+		ep.client = nil
+	}
+	ep.failures++
+	if ep.failures >= c.options.FailureThreshold {
+		ep.healthy = false
+	}
+	from, to, moved := c.failover()
+	c.mu.Unlock()
+
+	c.notifyFailover(from, to, moved)
+}
+
+// failover moves writes to the first healthy endpoint if the primary is
+// down. Callers must hold the lock.
+func (c *Cluster) failover() (from, to string, moved bool) {
+	if c.endpoints[c.primary].healthy {
+		return "", "", false
+	}
+	for i, ep := range c.endpoints {
+		if ep.healthy {
+			from = c.endpoints[c.primary].addr
+			c.primary = i
+			return from, ep.addr, true
+		}
+	}
+	return "", "", false
+}
+
+func (c *Cluster) notifyFailover(from, to string, moved bool) {
+	if moved && c.options.OnFailover != nil {
+		c.options.OnFailover(from, to)
+	}
+}
+
+// healthLoop pings every endpoint each HealthInterval until Close
+func (c *Cluster) healthLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.options.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for _, ep := range c.endpoints {
+			wg.Add(1)
+			go func(ep *endpoint) {
+				defer wg.Done()
+				c.check(ep)
+			}(ep)
+		}
+		wg.Wait()
+	}
+}
+
+// check pings one endpoint, giving up after the read timeout or, without
+// one, the health interval
+func (c *Cluster) check(ep *endpoint) {
+	client, err := c.connect(ep)
+	if err != nil {
+		c.failed(ep, nil)
+		return
+	}
+
+	timeout := c.options.ReadTimeout
+	if timeout <= 0 {
+		timeout = c.options.HealthInterval
+	}
+	client.mu.Lock()
+	client.conn.SetDeadline(time.Now().Add(timeout))
+	_, err = client.conn.Write([]byte("PING\r\n"))
+	if err == nil {
+		_, err = client.readReply()
+	}
+	client.conn.SetDeadline(time.Time{})
+	client.mu.Unlock()
+
+	if err != nil {
+		c.failed(ep, client)
+		return
+	}
+	c.answered(ep)
+}
+
+// isConnError reports whether err means the connection is unusable, as
+// opposed to an error reply or a nil value
+func isConnError(err error) bool {
+	if err == nil || err == ErrNil {
+		return false
+	}
+	var serverErr ServerError
+	return !errors.As(err, &serverErr)
+}
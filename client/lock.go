@@ -0,0 +1,185 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrLockNotObtained is returned when the lock is held by someone else
+	// after all retries are exhausted
+	ErrLockNotObtained = errors.New("triff: lock not obtained")
+	// ErrLockNotHeld is returned when releasing or extending a lock that
+	// expired or was taken over by another owner
+	ErrLockNotHeld = errors.New("triff: lock not held")
+)
+
+// LockOptions tunes lock acquisition
+type LockOptions struct {
+	RetryCount  int           // attempts after the first one
+	RetryDelay  time.Duration // base delay between attempts, randomized up to 2x
+	DriftFactor float64       // clock drift allowance as a fraction of the TTL
+}
+
+// DefaultLockOptions mirrors the defaults of common Redlock implementations
+var DefaultLockOptions = LockOptions{
+	RetryCount:  32,
+	RetryDelay:  100 * time.Millisecond,
+	DriftFactor: 0.01,
+}
+
+// doer sends commands; both Client and Cluster implement it
+type doer interface {
+	Do(args ...string) (interface{}, error)
+}
+
+// Mutex is a lock held on a single triff node following the Redlock
+// pattern: a random owner token, acquisition with an expiry, and release
+// or extension only by the owner. The lock is only valid until Until();
+// callers must finish their critical section before then.
+type Mutex struct {
+	client  doer
+	key     string
+	ttl     time.Duration
+	options LockOptions
+	token   string
+	until   time.Time
+}
+
+// NewMutex creates a lock on key that expires after ttl unless extended.
+// The server stores expirations with second granularity.
+func (c *Client) NewMutex(key string, ttl time.Duration) *Mutex {
+	return c.NewMutexWithOptions(key, ttl, DefaultLockOptions)
+}
+
+// NewMutexWithOptions creates a lock with custom retry behaviour
+func (c *Client) NewMutexWithOptions(key string, ttl time.Duration, options LockOptions) *Mutex {
+	return &Mutex{
+		client:  c,
+		key:     key,
+		ttl:     ttl,
+		options: options,
+	}
+}
+
+// Lock acquires the lock, retrying with randomized backoff
+func (m *Mutex) Lock() error {
+	for attempt := 0; ; attempt++ {
+		err := m.TryLock()
+		if err != ErrLockNotObtained || attempt >= m.options.RetryCount {
+			return err
+		}
+		time.Sleep(m.retryDelay())
+	}
+}
+
+// TryLock makes a single acquisition attempt
+func (m *Mutex) TryLock() error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	ok, err := Int(m.client.Do("LOCK", m.key, token, strconv.FormatInt(m.ttl.Milliseconds(), 10)))
+	if err != nil {
+		return err
+	}
+	if ok != 1 {
+		return ErrLockNotObtained
+	}
+
+	until, valid := m.validity(start)
+	if !valid {
+		// Acquisition took longer than the lock lives, give it back
+		m.client.Do("UNLOCK", m.key, token)
+		return ErrLockNotObtained
+	}
+
+	m.token = token
+	m.until = until
+	return nil
+}
+
+// Unlock releases the lock if this Mutex still owns it
+func (m *Mutex) Unlock() error {
+	if m.token == "" {
+		return ErrLockNotHeld
+	}
+
+	ok, err := Int(m.client.Do("UNLOCK", m.key, m.token))
+	m.token = ""
+	m.until = time.Time{}
+	if err != nil {
+		return err
+	}
+	if ok != 1 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend resets the lock TTL if this Mutex still owns it
+func (m *Mutex) Extend() error {
+	if m.token == "" {
+		return ErrLockNotHeld
+	}
+
+	start := time.Now()
+	ok, err := Int(m.client.Do("LOCKEXTEND", m.key, m.token, strconv.FormatInt(m.ttl.Milliseconds(), 10)))
+	if err != nil {
+		return err
+	}
+	if ok != 1 {
+		m.token = ""
+		m.until = time.Time{}
+		return ErrLockNotHeld
+	}
+
+	m.until, _ = m.validity(start)
+	return nil
+}
+
+// Token returns the owner token of the held lock, or "" if not held
+func (m *Mutex) Token() string {
+	return m.token
+}
+
+// Until returns the time after which the lock must be considered lost
+func (m *Mutex) Until() time.Time {
+	return m.until
+}
+
+// validity computes how long the lock can be trusted, discounting the
+// round trip and clock drift
+func (m *Mutex) validity(start time.Time) (time.Time, bool) {
+	drift := time.Duration(float64(m.ttl)*m.options.DriftFactor) + 2*time.Millisecond
+	remaining := m.ttl - time.Since(start) - drift
+	if remaining <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(remaining), true
+}
+
+func (m *Mutex) retryDelay() time.Duration {
+	if m.options.RetryDelay <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(m.options.RetryDelay)))
+	if err != nil {
+		return m.options.RetryDelay
+	}
+	return m.options.RetryDelay + time.Duration(n.Int64())
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
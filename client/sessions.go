@@ -0,0 +1,255 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session doesn't exist or has expired
+var ErrSessionNotFound = errors.New("triff: session not found")
+
+// Session is a web session stored in triff
+type Session struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id,omitempty"`
+	Values    map[string]string `json:"data"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at,omitempty"`
+
+	// Name is the cookie name the session was loaded from
+	Name string `json:"-"`
+	// IsNew is true until the session has been saved for the first time
+	IsNew bool `json:"-"`
+	// MaxAge in seconds; set it negative before Save to destroy the session
+	MaxAge int `json:"-"`
+}
+
+// CookieOptions controls the session cookie written by SessionStore.Save
+type CookieOptions struct {
+	Path     string
+	Domain   string
+	MaxAge   int // Seconds, also used as the session TTL
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// SessionStore keeps web sessions in triff through the REST sessions API.
+// Its Get/New/Save methods follow the gorilla/sessions Store contract, so
+// an adapter for that package only needs to copy values between the two
+// session types.
+type SessionStore struct {
+	baseURL string
+	http    *http.Client
+	Options CookieOptions
+}
+
+// NewSessionStore creates a store for the triff HTTP server at baseURL,
+// e.g. http://localhost:8080
+func NewSessionStore(baseURL string) *SessionStore {
+	return &SessionStore{
+		baseURL: baseURL + "/api/v1/sessions",
+		http:    &http.Client{Timeout: 5 * time.Second},
+		Options: CookieOptions{
+			Path:     "/",
+			MaxAge:   86400,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Get returns the session named by the request cookie, or a new one when
+// the cookie is missing or the session has expired
+func (ss *SessionStore) Get(r *http.Request, name string) (*Session, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ss.New(r, name)
+	}
+	session, err := ss.Load(r.Context(), cookie.Value)
+	if err == ErrSessionNotFound {
+		return ss.New(r, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Name = name
+	session.MaxAge = ss.Options.MaxAge
+	return session, nil
+}
+
+// New returns an unsaved session
+func (ss *SessionStore) New(r *http.Request, name string) (*Session, error) {
+	return &Session{
+		Name:   name,
+		Values: make(map[string]string),
+		IsNew:  true,
+		MaxAge: ss.Options.MaxAge,
+	}, nil
+}
+
+// Save stores the session and writes its cookie. A negative MaxAge
+// destroys the session and clears the cookie.
+func (ss *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	ctx := r.Context()
+
+	if session.MaxAge < 0 {
+		if session.ID != "" {
+			if err := ss.Destroy(ctx, session.ID); err != nil && err != ErrSessionNotFound {
+				return err
+			}
+		}
+		http.SetCookie(w, ss.cookie(session.Name, "", -1))
+		return nil
+	}
+
+	ttl := int64(session.MaxAge)
+	if ttl == 0 {
+		ttl = int64(ss.Options.MaxAge)
+	}
+
+	var saved *Session
+	var err error
+	if session.IsNew || session.ID == "" {
+		saved, err = ss.Create(ctx, session.UserID, session.Values, ttl)
+	} else {
+		saved, err = ss.update(ctx, session.ID, session.Values)
+		if err == nil {
+			saved, err = ss.Touch(ctx, session.ID, ttl)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	session.ID = saved.ID
+	session.CreatedAt = saved.CreatedAt
+	session.ExpiresAt = saved.ExpiresAt
+	session.IsNew = false
+	http.SetCookie(w, ss.cookie(session.Name, session.ID, session.MaxAge))
+	return nil
+}
+
+// Create stores a new session for userID
+func (ss *SessionStore) Create(ctx context.Context, userID string, values map[string]string, ttl int64) (*Session, error) {
+	var session Session
+	err := ss.do(ctx, "POST", ss.baseURL, map[string]interface{}{
+		"user_id": userID,
+		"data":    values,
+		"ttl":     ttl,
+	}, &session)
+	return &session, err
+}
+
+// Load fetches a session by ID
+func (ss *SessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	err := ss.do(ctx, "GET", ss.baseURL+"/"+url.PathEscape(id), nil, &session)
+	return &session, err
+}
+
+// Touch extends a session to expire ttl seconds from now
+func (ss *SessionStore) Touch(ctx context.Context, id string, ttl int64) (*Session, error) {
+	var session Session
+	err := ss.do(ctx, "POST", ss.baseURL+"/"+url.PathEscape(id)+"/touch", map[string]int64{"ttl": ttl}, &session)
+	return &session, err
+}
+
+// Destroy deletes a session
+func (ss *SessionStore) Destroy(ctx context.Context, id string) error {
+	return ss.do(ctx, "DELETE", ss.baseURL+"/"+url.PathEscape(id), nil, nil)
+}
+
+// ListByUser returns the live sessions of a user
+func (ss *SessionStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	var sessions []*Session
+	err := ss.do(ctx, "GET", ss.baseURL+"?user_id="+url.QueryEscape(userID), nil, &sessions)
+	return sessions, err
+}
+
+func (ss *SessionStore) update(ctx context.Context, id string, values map[string]string) (*Session, error) {
+	var session Session
+	err := ss.do(ctx, "PUT", ss.baseURL+"/"+url.PathEscape(id), map[string]interface{}{"data": values}, &session)
+	return &session, err
+}
+
+func (ss *SessionStore) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     ss.Options.Path,
+		Domain:   ss.Options.Domain,
+		MaxAge:   maxAge,
+		Secure:   ss.Options.Secure,
+		HttpOnly: ss.Options.HttpOnly,
+		SameSite: ss.Options.SameSite,
+	}
+}
+
+// do sends a JSON request and decodes the JSON reply into out
+func (ss *SessionStore) do(ctx context.Context, method, target string, body, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ss.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSessionNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return ServerError(fmt.Sprintf("%s: %s", resp.Status, reply.Error))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type sessionContextKey struct{}
+
+// SessionMiddleware loads the session named by cookie into the request
+// context, where handlers read it with SessionFromContext. Handlers must
+// call store.Save to persist changes.
+func SessionMiddleware(store *SessionStore, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := store.Get(r, name)
+			if err != nil {
+				http.Error(w, "session store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			ctx := context.WithValue(r.Context(), sessionContextKey{}, session)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SessionFromContext returns the session loaded by SessionMiddleware
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return session
+}
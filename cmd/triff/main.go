@@ -0,0 +1,201 @@
+// Command triff runs the server and manages triff data files offline. Run
+// the offline commands while the server is stopped; a running server
+// should use RESTORE TO or /api/v1/persistence/restore instead.
+//
+//	triff serve [-config triff.yaml] [-container]
+//	triff ping [-addr 127.0.0.1:6379] [-timeout 2s]
+//	triff snapshots [-config triff.yaml] [-path triff.db]
+//	triff restore -to <RFC 3339 time | Unix seconds> [-config triff.yaml] [-path triff.db]
+//	triff keygen
+//	triff reencrypt [-decrypt] [-config triff.yaml] [-path triff.db]
+//
+// Encryption keys come from persistence.encryption in the configuration
+// or from TRIFF_ENCRYPTION_KEY and TRIFF_ENCRYPTION_OLD_KEYS.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/storage"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = serve(os.Args[2:])
+	case "ping":
+		err = ping(os.Args[2:])
+	case "snapshots":
+		err = listSnapshots(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "keygen":
+		err = keygen()
+	case "reencrypt":
+		err = reencrypt(os.Args[2:])
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "triff: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "triff %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  triff serve [-config file] [-container]
+  triff ping [-addr host:port] [-timeout d]
+  triff snapshots [-config file] [-path snapshot]
+  triff restore -to <time> [-config file] [-path snapshot]
+  triff keygen
+  triff reencrypt [-decrypt] [-config file] [-path snapshot]`)
+}
+
+// snapshotFlags adds the flags that locate the snapshot file
+func snapshotFlags(fs *flag.FlagSet) (config, path *string) {
+	config = fs.String("config", "", "configuration file to read persistence.path from")
+	path = fs.String("path", "", "snapshot file (overrides the configuration)")
+	return config, path
+}
+
+// loadConfig reads the configuration and resolves the snapshot file from
+// the flags
+func loadConfig(configFile, path string) (*core.Config, string, error) {
+	config, err := utils.MergeConfigs(configFile)
+	if err != nil {
+		return nil, "", err
+	}
+	if path == "" {
+		path = config.Persistence.Path
+	}
+	return config, path, nil
+}
+
+func listSnapshots(args []string) error {
+	fs := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	configFile, pathFlag := snapshotFlags(fs)
+	fs.Parse(args)
+
+	_, path, err := loadConfig(*configFile, *pathFlag)
+	if err != nil {
+		return err
+	}
+	snapshots, err := storage.ListSnapshots(path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTIME\tSIZE\t")
+	for _, snapshot := range snapshots {
+		current := ""
+		if snapshot.Current {
+			current = "current"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", snapshot.Name, snapshot.Time.Format(time.RFC3339), snapshot.Size, current)
+	}
+	return w.Flush()
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile, pathFlag := snapshotFlags(fs)
+	to := fs.String("to", "", "restore the newest snapshot taken at or before this time")
+	fs.Parse(args)
+
+	if *to == "" {
+		return fmt.Errorf("-to is required")
+	}
+	at, err := core.ParseRestoreTime(*to)
+	if err != nil {
+		return err
+	}
+	config, path, err := loadConfig(*configFile, *pathFlag)
+	if err != nil {
+		return err
+	}
+	keys, err := storage.LoadKeyring(config.Persistence.Encryption)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := storage.ListSnapshots(path)
+	if err != nil {
+		return err
+	}
+	point, found := core.SelectRestorePoint(snapshots, at)
+	if !found {
+		return core.ErrNoRestorePoint
+	}
+	if err := storage.RestoreSnapshot(path, point.Name, keys); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s (taken %s) to %s\n", point.Name, point.Time.Format(time.RFC3339), path)
+	return nil
+}
+
+func keygen() error {
+	key, err := storage.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// reencrypt brings the snapshot and its generations onto the current key
+// after a rotation, or back to plaintext with -decrypt before encryption
+// is turned off
+func reencrypt(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	configFile, pathFlag := snapshotFlags(fs)
+	decrypt := fs.Bool("decrypt", false, "write the files in plaintext")
+	fs.Parse(args)
+
+	config, path, err := loadConfig(*configFile, *pathFlag)
+	if err != nil {
+		return err
+	}
+	keys, err := storage.LoadKeyring(config.Persistence.Encryption)
+	if err != nil {
+		return err
+	}
+	target := keys
+	if *decrypt {
+		target = nil
+	} else if keys == nil {
+		return fmt.Errorf("encryption is not enabled; use -decrypt to write plaintext")
+	}
+
+	rewritten, err := storage.ReencryptSnapshots(path, keys, target)
+	for _, name := range rewritten {
+		fmt.Println("rewrote", name)
+	}
+	if err != nil {
+		return err
+	}
+	if target != nil {
+		fmt.Printf("%d files rewritten; all are encrypted with key %s\n", len(rewritten), target.KeyID())
+	} else {
+		fmt.Printf("%d files rewritten; all are plaintext\n", len(rewritten))
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/nitrix4ly/triff/client"
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/server"
+	"github.com/nitrix4ly/triff/storage"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// shutdownTimeout bounds how long serve waits for the HTTP requests in
+// flight once it is told to stop
+const shutdownTimeout = 10 * time.Second
+
+// saveRetryDelay is how long serve waits after a failed save before it
+// tries again, rather than failing every second
+const saveRetryDelay = 5 * time.Second
+
+// serve runs the TCP and HTTP servers until SIGINT or SIGTERM, then stops
+// accepting, lets the HTTP requests in flight finish and saves a final
+// snapshot. Without -config the configuration comes from TRIFF_*
+// variables alone. With -container, or TRIFF_CONTAINER set, logs go to
+// stdout as JSON.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := fs.String("config", "", "configuration file, overridden by TRIFF_* variables")
+	container := fs.Bool("container", envBool("TRIFF_CONTAINER"), "log JSON to stdout, for running in a container")
+	fs.Parse(args)
+
+	if err := utils.CheckEnvSecrets(); err != nil {
+		return err
+	}
+	config, err := utils.MergeConfigs(*configFile)
+	if err != nil {
+		return err
+	}
+	if *container {
+		config.Logging.File = ""
+		if os.Getenv("TRIFF_LOG_FORMAT") == "" {
+			config.Logging.Format = "json"
+		}
+	}
+	if err := utils.ValidateConfig(config); err != nil {
+		return err
+	}
+	if config.Persistence.Enabled && config.Storage.NodeIDFile == "" {
+		config.Storage.NodeIDFile = config.Persistence.Path + ".node-id"
+	}
+	if err := utils.LoadNodeID(config); err != nil {
+		return err
+	}
+	logger, err := utils.NewLoggerFromConfig(config.Logging)
+	if err != nil {
+		return err
+	}
+	defer logger.Close()
+	tlsConfig, err := utils.TLSConfig(config.Security)
+	if err != nil {
+		return err
+	}
+
+	// The snapshot loads while the servers start, so liveness probes are
+	// answered during a long load; commands wait for it with ErrLoading
+	db := core.NewDatabase(config)
+	var loaded <-chan error
+	if config.Persistence.Enabled {
+		if err := openPersistence(db, config); err != nil {
+			return err
+		}
+		loaded = db.StartLoad()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed := make(chan error, 2)
+
+	var tcp *server.TCPServer
+	var tcpListener net.Listener
+	tcpDone := make(chan struct{})
+	if config.Server.EnableTCP {
+		tcp = server.NewTCPServer(db, config.Server.Port, logger)
+		if config.Server.Frontend == "eventloop" && tlsConfig == nil {
+			go func() {
+				defer close(tcpDone)
+				if err := tcp.Start(); err != nil {
+					failed <- err
+				}
+			}()
+		} else {
+			tcpListener, err = net.Listen("tcp", net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.Port)))
+			if err != nil {
+				return err
+			}
+			if tlsConfig != nil {
+				tcpListener = tls.NewListener(tcpListener, tlsConfig)
+			}
+			logger.Info(fmt.Sprintf("TCP server listening on %s", tcpListener.Addr()))
+			go func() {
+				defer close(tcpDone)
+				tcp.Serve(tcpListener)
+			}()
+		}
+	} else {
+		close(tcpDone)
+	}
+
+	var httpServer *http.Server
+	if config.Server.EnableHTTP {
+		httpServer = &http.Server{
+			Addr:      net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.HTTPPort)),
+			Handler:   server.NewHTTPServer(db, config.Server.HTTPPort, logger).Handler(),
+			TLSConfig: tlsConfig,
+		}
+		logger.Info(fmt.Sprintf("HTTP server listening on %s", httpServer.Addr))
+		go func() {
+			var err error
+			if tlsConfig != nil {
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}()
+	}
+
+	go maintain(ctx, db, config, logger)
+
+	for done := false; !done; {
+		select {
+		case err := <-loaded:
+			if err != nil {
+				logger.Error(fmt.Sprintf("loading the snapshot: %v", err))
+				return err
+			}
+			logger.Info(fmt.Sprintf("loaded %d keys", db.Size()))
+			loaded = nil
+		case <-ctx.Done():
+			logger.Info("shutting down")
+			done = true
+		case err := <-failed:
+			logger.Error(fmt.Sprintf("server failed: %v", err))
+			return err
+		}
+	}
+	// A second signal kills at once
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if httpServer != nil {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Warn(fmt.Sprintf("HTTP shutdown: %v", err))
+		}
+	}
+	if tcpListener != nil {
+		tcpListener.Close()
+		<-tcpDone
+	}
+	if tcp != nil {
+		tcp.Stop()
+	}
+	if config.Persistence.Enabled {
+		// Stopped before the load finished, the snapshot on disk is still
+		// the newest data
+		switch err := db.Save(); {
+		case errors.Is(err, core.ErrLoading):
+		case err != nil:
+			return fmt.Errorf("final save: %v", err)
+		default:
+			logger.Info("saved the final snapshot")
+		}
+	}
+	return nil
+}
+
+// openPersistence makes Load read and Save write the snapshot at
+// persistence.path
+func openPersistence(db *core.Database, config *core.Config) error {
+	recovery, err := storage.ParseRecoveryMode(config.Persistence.Recovery)
+	if err != nil {
+		return err
+	}
+	keys, err := storage.LoadKeyring(config.Persistence.Encryption)
+	if err != nil {
+		return err
+	}
+	store := storage.NewSnapshotStore(config.Persistence.Path, recovery, config.Persistence.Generations)
+	store.SetKeyring(keys)
+	db.SetPersistence(store)
+	return nil
+}
+
+// maintain sweeps expired keys every second and, with persistence
+// enabled, saves a snapshot whenever one of the save points in
+// persistence.save is reached, until ctx is done. The save points are read
+// each time, so CONFIG SET save takes effect at once. A failed save is
+// retried after saveRetryDelay.
+func maintain(ctx context.Context, db *core.Database, config *core.Config, logger *utils.Logger) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var retryAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			db.CleanupExpired()
+			if !config.Persistence.Enabled || now.Before(retryAt) || !db.SaveDue() {
+				continue
+			}
+			if err := db.Save(); err != nil && !errors.Is(err, core.ErrBulkLoading) && !errors.Is(err, core.ErrLoading) {
+				logger.Error(fmt.Sprintf("save: %v", err))
+				retryAt = now.Add(saveRetryDelay)
+			}
+		}
+	}
+}
+
+// ping exits with 0 if the server answers PING and 1 otherwise, for a
+// container HEALTHCHECK. The address defaults to TRIFF_PORT on the
+// loopback interface, over TLS if TRIFF_TLS_CERT is set; the certificate
+// isn't verified, since the check only asks whether the server is up.
+func ping(args []string) error {
+	config := utils.GetEnvConfig()
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	addr := fs.String("addr", net.JoinHostPort("127.0.0.1", strconv.Itoa(config.Server.Port)), "server address")
+	timeout := fs.Duration("timeout", 2*time.Second, "how long to wait for the reply")
+	fs.Parse(args)
+
+	options := client.Options{DialTimeout: *timeout, ReadTimeout: *timeout}
+	if config.Security.TLSCert != "" {
+		options.TLS = &tls.Config{InsecureSkipVerify: true}
+	}
+	c, err := client.DialWithOptions(*addr, options)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Ping()
+}
+
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(name))
+	return b
+}
@@ -0,0 +1,270 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+// MarshalCBOR encodes a value of the JSON data model as CBOR (RFC 8949),
+// with definite lengths and the shortest head of every integer and length
+func MarshalCBOR(v interface{}) ([]byte, error) {
+	return appendCBOR(nil, v, 0)
+}
+
+func appendCBOR(buf []byte, v interface{}, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xf6), nil
+	case bool:
+		if v {
+			return append(buf, 0xf5), nil
+		}
+		return append(buf, 0xf4), nil
+	case json.Number:
+		i, u, f, kind := number(v)
+		switch kind {
+		case 'i':
+			return appendCBORInt(buf, i), nil
+		case 'u':
+			return appendCBORHead(buf, cborUint, u), nil
+		}
+		return appendUint(append(buf, 0xfb), math.Float64bits(f), 8), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return appendCBORInt(buf, int64(v)), nil
+		}
+		return appendUint(append(buf, 0xfb), math.Float64bits(v), 8), nil
+	case int:
+		return appendCBORInt(buf, int64(v)), nil
+	case int64:
+		return appendCBORInt(buf, v), nil
+	case string:
+		return append(appendCBORHead(buf, cborText, uint64(len(v))), v...), nil
+	case []interface{}:
+		buf = appendCBORHead(buf, cborArray, uint64(len(v)))
+		var err error
+		for _, item := range v {
+			if buf, err = appendCBOR(buf, item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = appendCBORHead(buf, cborMap, uint64(len(v)))
+		var err error
+		for _, key := range sortedKeys(v) {
+			buf = append(appendCBORHead(buf, cborText, uint64(len(key))), key...)
+			if buf, err = appendCBOR(buf, v[key], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	normalized, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(buf, normalized, depth)
+}
+
+func appendCBORInt(buf []byte, i int64) []byte {
+	if i < 0 {
+		return appendCBORHead(buf, cborNegint, uint64(-(i + 1)))
+	}
+	return appendCBORHead(buf, cborUint, uint64(i))
+}
+
+// appendCBORHead writes a major type with its argument in the shortest form
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint(append(buf, major|25), n, 2)
+	case n <= math.MaxUint32:
+		return appendUint(append(buf, major|26), n, 4)
+	}
+	return appendUint(append(buf, major|27), n, 8)
+}
+
+// UnmarshalCBOR decodes one CBOR value into the JSON data model: integers
+// become int64 or uint64, floats float64, byte and text strings string,
+// arrays []interface{} and maps map[string]interface{}. Tags are skipped
+// and undefined decodes as nil.
+func UnmarshalCBOR(data []byte) (interface{}, error) {
+	r := &reader{data: data}
+	v, err := readCBOR(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, ErrTrailing
+	}
+	return v, nil
+}
+
+// readCBORHead reads a major type and its argument. indefinite is set for
+// the indefinite length form of strings, arrays and maps.
+func readCBORHead(r *reader) (major byte, n uint64, indefinite bool, err error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, info := b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info <= 27:
+		n, err = r.uint(1 << (info - 24))
+		return major, n, false, err
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap:
+		return major, 0, true, nil
+	}
+	return 0, 0, false, fmt.Errorf("%w: cbor initial byte 0x%02x", ErrUnsupported, b)
+}
+
+func readCBOR(r *reader, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	start := r.pos
+	major, n, indefinite, err := readCBORHead(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case cborNegint:
+		if n <= math.MaxInt64 {
+			return -1 - int64(n), nil
+		}
+		return -1 - float64(n), nil
+	case cborBytes, cborText:
+		if !indefinite {
+			return readString(r, n)
+		}
+		var s []byte
+		for {
+			if r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+				r.pos++
+				return string(s), nil
+			}
+			chunk, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			text, ok := chunk.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: non-string chunk in indefinite string", ErrUnsupported)
+			}
+			s = append(s, text...)
+		}
+	case cborArray:
+		if !indefinite && n > uint64(len(r.data)-r.pos) {
+			return nil, ErrTruncated
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+				r.pos++
+				break
+			}
+			item, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		if !indefinite && n > uint64(len(r.data)-r.pos) {
+			return nil, ErrTruncated
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+				r.pos++
+				break
+			}
+			key, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[mapKey(key)] = value
+		}
+		return m, nil
+	case cborTag:
+		return readCBOR(r, depth+1)
+	}
+
+	// Major type 7: simple values and floats, n holds the raw bits
+	switch info := r.data[start] & 0x1f; {
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22, info == 23:
+		return nil, nil
+	case info == 25:
+		return toJSONValue(float16(uint16(n))), nil
+	case info == 26:
+		return toJSONValue(float64(math.Float32frombits(uint32(n)))), nil
+	case info == 27:
+		return toJSONValue(math.Float64frombits(n)), nil
+	}
+	return nil, fmt.Errorf("%w: cbor simple value %d", ErrUnsupported, n)
+}
+
+// float16 decodes an IEEE 754 half precision float
+func float16(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	mant := float64(bits & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if bits&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
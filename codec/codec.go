@@ -0,0 +1,173 @@
+// Package codec converts the JSON data model (null, booleans, numbers,
+// strings, arrays and objects with string keys) to and from MessagePack and
+// CBOR, so the REST API can speak those encodings without a dependency.
+// Binary strings decode as strings, CBOR tags are skipped and MessagePack
+// extension types are not supported.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of decoded arrays and maps
+const maxDepth = 512
+
+var (
+	ErrTruncated   = errors.New("codec: unexpected end of data")
+	ErrTrailing    = errors.New("codec: trailing data after value")
+	ErrTooDeep     = errors.New("codec: value nested too deeply")
+	ErrUnsupported = errors.New("codec: unsupported type")
+)
+
+// Codec is one binary encoding of the JSON data model
+type Codec struct {
+	ContentType string
+	Marshal     func(v interface{}) ([]byte, error)
+	Unmarshal   func(data []byte) (interface{}, error)
+}
+
+var (
+	MessagePack = Codec{ContentType: "application/msgpack", Marshal: MarshalMsgpack, Unmarshal: UnmarshalMsgpack}
+	CBOR        = Codec{ContentType: "application/cbor", Marshal: MarshalCBOR, Unmarshal: UnmarshalCBOR}
+)
+
+// ByContentType returns the codec of a media type, accepting the
+// unregistered application/x-msgpack as well
+func ByContentType(mediaType string) (Codec, bool) {
+	switch mediaType {
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return MessagePack, true
+	case "application/cbor":
+		return CBOR, true
+	}
+	return Codec{}, false
+}
+
+// FromJSON transcodes a JSON document, keeping integers exact
+func (c Codec) FromJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := unmarshalJSON(data, &v); err != nil {
+		return nil, err
+	}
+	return c.Marshal(v)
+}
+
+// ToJSON transcodes a document of the codec's encoding to JSON
+func (c Codec) ToJSON(data []byte) ([]byte, error) {
+	v, err := c.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func unmarshalJSON(data []byte, v *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// number classifies a JSON number as a signed or unsigned integer, or a
+// float when it has a fraction or doesn't fit 64 bits
+func number(n json.Number) (i int64, u uint64, f float64, kind byte) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, 0, 0, 'i'
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return 0, u, 0, 'u'
+	}
+	f, _ = strconv.ParseFloat(string(n), 64)
+	return 0, 0, f, 'f'
+}
+
+// sortedKeys gives maps a deterministic encoding
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toJSONValue maps values JSON can't carry: non-finite floats become null
+func toJSONValue(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// mapKey renders a decoded map key as the string JSON objects need
+func mapKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	if key == nil {
+		return "null"
+	}
+	return fmt.Sprint(key)
+}
+
+// normalize turns values outside the JSON data model, such as structs,
+// into it by a round trip through encoding/json
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = unmarshalJSON(data, &normalized)
+	return normalized, err
+}
+
+// reader walks encoded data
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, ErrTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of n bytes
+func (r *reader) uint(n uint64) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// appendUint appends v as a big endian integer of n bytes
+func appendUint(buf []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*uint(i))))
+	}
+	return buf
+}
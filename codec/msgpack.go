@@ -0,0 +1,274 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// MarshalMsgpack encodes a value of the JSON data model as MessagePack,
+// using the smallest representation of every integer and length
+func MarshalMsgpack(v interface{}) ([]byte, error) {
+	return appendMsgpack(nil, v, 0)
+}
+
+func appendMsgpack(buf []byte, v interface{}, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		i, u, f, kind := number(v)
+		switch kind {
+		case 'i':
+			return appendMsgpackInt(buf, i), nil
+		case 'u':
+			return appendUint(append(buf, 0xcf), u, 8), nil
+		}
+		return appendMsgpackFloat(buf, f), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return appendMsgpackInt(buf, int64(v)), nil
+		}
+		return appendMsgpackFloat(buf, v), nil
+	case int:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(buf, v), nil
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf = append(buf, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			buf = append(buf, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			buf = appendUint(append(buf, 0xda), uint64(n), 2)
+		default:
+			buf = appendUint(append(buf, 0xdb), uint64(n), 4)
+		}
+		return append(buf, v...), nil
+	case []interface{}:
+		buf = appendMsgpackLength(buf, len(v), 0x90, 0xdc)
+		var err error
+		for _, item := range v {
+			if buf, err = appendMsgpack(buf, item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = appendMsgpackLength(buf, len(v), 0x80, 0xde)
+		var err error
+		for _, key := range sortedKeys(v) {
+			if buf, err = appendMsgpack(buf, key, depth+1); err != nil {
+				return nil, err
+			}
+			if buf, err = appendMsgpack(buf, v[key], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	normalized, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(buf, normalized, depth)
+}
+
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(buf, byte(i))
+	case i >= -32 && i < 0:
+		return append(buf, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return appendUint(append(buf, 0xcd), uint64(i), 2)
+	case i >= 0 && i <= math.MaxUint32:
+		return appendUint(append(buf, 0xce), uint64(i), 4)
+	case i >= 0:
+		return appendUint(append(buf, 0xcf), uint64(i), 8)
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendUint(append(buf, 0xd1), uint64(i), 2)
+	case i >= math.MinInt32:
+		return appendUint(append(buf, 0xd2), uint64(i), 4)
+	}
+	return appendUint(append(buf, 0xd3), uint64(i), 8)
+}
+
+func appendMsgpackFloat(buf []byte, f float64) []byte {
+	return appendUint(append(buf, 0xcb), math.Float64bits(f), 8)
+}
+
+// appendMsgpackLength writes an array or map header: the fix form below 16
+// entries, else the 16 or 32 bit form following long
+func appendMsgpackLength(buf []byte, n int, fix, long byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint(append(buf, long), uint64(n), 2)
+	}
+	return appendUint(append(buf, long+1), uint64(n), 4)
+}
+
+// UnmarshalMsgpack decodes one MessagePack value into the JSON data model:
+// integers become int64 or uint64, floats float64, strings and binaries
+// string, arrays []interface{} and maps map[string]interface{}
+func UnmarshalMsgpack(data []byte) (interface{}, error) {
+	r := &reader{data: data}
+	v, err := readMsgpack(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, ErrTrailing
+	}
+	return v, nil
+}
+
+func readMsgpack(r *reader, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return readString(r, uint64(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return readSizedString(r, 1)
+	case 0xc5, 0xda:
+		return readSizedString(r, 2)
+	case 0xc6, 0xdb:
+		return readSizedString(r, 4)
+	case 0xca:
+		bits, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return toJSONValue(float64(math.Float32frombits(uint32(bits)))), nil
+	case 0xcb:
+		bits, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return toJSONValue(math.Float64frombits(bits)), nil
+	case 0xcc, 0xcd, 0xce:
+		v, err := r.uint(1 << (b - 0xcc))
+		return int64(v), err
+	case 0xcf:
+		v, err := r.uint(8)
+		if v <= math.MaxInt64 {
+			return int64(v), err
+		}
+		return v, err
+	case 0xd0:
+		v, err := r.uint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := r.uint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := r.uint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := r.uint(8)
+		return int64(v), err
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, n, depth)
+	}
+	return nil, fmt.Errorf("%w: msgpack type 0x%02x", ErrUnsupported, b)
+}
+
+func readMsgpackArray(r *reader, n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrTruncated // every item takes at least a byte
+	}
+	items := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		item, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func readMsgpackMap(r *reader, n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m[mapKey(key)] = value
+	}
+	return m, nil
+}
+
+// readSizedString reads a string whose length is an n byte integer
+func readSizedString(r *reader, n uint64) (interface{}, error) {
+	length, err := r.uint(n)
+	if err != nil {
+		return nil, err
+	}
+	return readString(r, length)
+}
+
+func readString(r *reader, n uint64) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"github.com/nitrix4ly/triff/core"
+)
+
+// AggregateCommands handles the aggregates triff keeps up to date on write
+type AggregateCommands struct {
+	db *core.Database
+}
+
+// NewAggregateCommands creates a new aggregate commands handler
+func NewAggregateCommands(db *core.Database) *AggregateCommands {
+	return &AggregateCommands{db: db}
+}
+
+// Create declares an aggregate of kind (count, sum, min or max) over the
+// keys matching pattern, or over a field of those holding hashes, readable
+// with GET name
+func (ac *AggregateCommands) Create(name, kind, pattern, field string) *core.Response {
+	aggregateKind, err := core.ParseAggregateKind(kind)
+	if err != nil {
+		return core.Fail("aggregate", err)
+	}
+	if err := ac.db.CreateAggregate(name, aggregateKind, pattern, field); err != nil {
+		return core.Fail("aggregate", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// Drop removes an aggregate and its key
+func (ac *AggregateCommands) Drop(name string) *core.Response {
+	if !ac.db.DropAggregate(name) {
+		return core.Fail("aggregate", core.ErrAggregateNotFound)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// List returns all declared aggregates with their results
+func (ac *AggregateCommands) List() *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    ac.db.ListAggregates(),
+		Type:    "array",
+	}
+}
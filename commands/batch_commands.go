@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// BatchOp is one operation of a batch. Fields an operation doesn't use are
+// ignored.
+type BatchOp struct {
+	Op    string `json:"op"` // get, set, del, exists, incr, append, expire, persist, ttl, hget, hset, hdel, hgetall
+	Key   string `json:"key"`
+	Field string `json:"field,omitempty"`
+	Value string `json:"value,omitempty"`
+	By    *int64 `json:"by,omitempty"`  // incr, defaults to 1
+	TTL   int64  `json:"ttl,omitempty"` // set and expire, in seconds
+}
+
+// Command returns the TCP command an operation performs, which is its op
+// in upper case
+func (op BatchOp) Command() string {
+	return strings.ToUpper(op.Op)
+}
+
+// BatchResult is the outcome of one operation of a batch
+type BatchResult struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// BatchCommands runs several operations in one call
+type BatchCommands struct {
+	db *core.Database
+}
+
+// NewBatchCommands creates a new batch commands handler
+func NewBatchCommands(db *core.Database) *BatchCommands {
+	return &BatchCommands{db: db}
+}
+
+// Exec runs ops in order and returns their results (Data []BatchResult).
+// Without atomic every operation runs on its own and a failed one doesn't
+// stop the rest. With atomic they run as one transaction under the write
+// lock: no other command sees a partial batch, and if an operation fails
+// nothing is written and the response fails with that operation's error.
+func (bc *BatchCommands) Exec(ops []BatchOp, atomic bool) *core.Response {
+	for i, op := range ops {
+		if _, ok := batchOps[strings.ToLower(op.Op)]; !ok {
+			return core.Fail("batch", fmt.Errorf("operation %d: unknown op '%s'", i, op.Op))
+		}
+		if op.Key == "" {
+			return core.Fail("batch", fmt.Errorf("operation %d: key is required", i))
+		}
+	}
+
+	results := make([]BatchResult, len(ops))
+	now := bc.db.Clock().Now().Unix()
+	if !atomic {
+		for i, op := range ops {
+			var result interface{}
+			err := bc.db.UpdateKeys([]string{op.Key}, func(values map[string]*core.TriffValue) error {
+				var err error
+				result, err = applyBatchOp(op, values, now)
+				return err
+			})
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Result = result
+			}
+		}
+		return &core.Response{Success: true, Data: results, Type: "batch"}
+	}
+
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	err := bc.db.UpdateKeys(keys, func(values map[string]*core.TriffValue) error {
+		for i, op := range ops {
+			result, err := applyBatchOp(op, values, now)
+			if err != nil {
+				return fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Key, err)
+			}
+			results[i].Result = result
+		}
+		return nil
+	})
+	if err != nil {
+		return core.Fail("batch", err)
+	}
+	return &core.Response{Success: true, Data: results, Type: "batch"}
+}
+
+// batchOps implements the batch operations on the staged values of a
+// transaction at now, the database clock's Unix time. They replace values
+// rather than changing them in place, so UpdateKeys sees what they wrote.
+var batchOps = map[string]func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error){
+	"get": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return nil, value, nil
+		}
+		if value.Type != core.STRING {
+			return nil, value, errNotString
+		}
+		return value.Data, value, nil
+	},
+	"set": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		set := &core.TriffValue{Type: core.STRING, Data: op.Value}
+		if op.TTL > 0 {
+			set.TTL = now + op.TTL
+		}
+		return "OK", set, nil
+	},
+	"del": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return 0, nil, nil
+		}
+		return 1, nil, nil
+	},
+	"exists": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return 0, value, nil
+		}
+		return 1, value, nil
+	},
+	"incr": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		by := int64(1)
+		if op.By != nil {
+			by = *op.By
+		}
+		var current, ttl int64
+		if value != nil {
+			if value.Type != core.STRING {
+				return nil, value, errNotString
+			}
+			var err error
+			if current, err = strconv.ParseInt(fmt.Sprint(value.Data), 10, 64); err != nil {
+				return nil, value, errNotInteger
+			}
+			ttl = value.TTL
+		}
+		current += by
+		return current, &core.TriffValue{Type: core.STRING, Data: strconv.FormatInt(current, 10), TTL: ttl}, nil
+	},
+	"append": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		appended := &core.TriffValue{Type: core.STRING, Data: op.Value}
+		if value != nil {
+			if value.Type != core.STRING {
+				return nil, value, errNotString
+			}
+			appended.Data, appended.TTL = fmt.Sprint(value.Data)+op.Value, value.TTL
+		}
+		return len(appended.Data.(string)), appended, nil
+	},
+	"expire": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if op.TTL <= 0 {
+			return nil, value, errs.Newf(errs.ErrNotInteger, "ttl must be a positive number of seconds")
+		}
+		if value == nil {
+			return 0, value, nil
+		}
+		return 1, withTTL(value, now+op.TTL), nil
+	},
+	"persist": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil || value.TTL == 0 {
+			return 0, value, nil
+		}
+		return 1, withTTL(value, 0), nil
+	},
+	"ttl": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return int64(-2), value, nil
+		}
+		if value.TTL == 0 {
+			return int64(-1), value, nil
+		}
+		return value.TTL - now, value, nil
+	},
+	"hget": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return nil, value, nil
+		}
+		if value.Type != core.HASH {
+			return nil, value, errNotHash
+		}
+		if field, ok := hashFields(value)[op.Field]; ok {
+			return field, value, nil
+		}
+		return nil, value, nil
+	},
+	"hset": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		fields := make(map[string]string)
+		var ttl int64
+		if value != nil {
+			if value.Type != core.HASH {
+				return nil, value, errNotHash
+			}
+			for f, v := range hashFields(value) {
+				fields[f] = v
+			}
+			ttl = value.TTL
+		}
+		added := 1
+		if _, exists := fields[op.Field]; exists {
+			added = 0
+		}
+		fields[op.Field] = op.Value
+		return added, &core.TriffValue{Type: core.HASH, Data: fields, TTL: ttl}, nil
+	},
+	"hdel": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return 0, value, nil
+		}
+		if value.Type != core.HASH {
+			return nil, value, errNotHash
+		}
+		fields := make(map[string]string)
+		for f, v := range hashFields(value) {
+			fields[f] = v
+		}
+		if _, exists := fields[op.Field]; !exists {
+			return 0, value, nil
+		}
+		delete(fields, op.Field)
+		if len(fields) == 0 {
+			return 1, nil, nil
+		}
+		return 1, &core.TriffValue{Type: core.HASH, Data: fields, TTL: value.TTL}, nil
+	},
+	"hgetall": func(op BatchOp, value *core.TriffValue, now int64) (interface{}, *core.TriffValue, error) {
+		if value == nil {
+			return map[string]string{}, value, nil
+		}
+		if value.Type != core.HASH {
+			return nil, value, errNotHash
+		}
+		return hashFields(value), value, nil
+	},
+}
+
+// applyBatchOp runs op against the staged value of its key
+func applyBatchOp(op BatchOp, values map[string]*core.TriffValue, now int64) (interface{}, error) {
+	result, value, err := batchOps[strings.ToLower(op.Op)](op, values[op.Key], now)
+	if err != nil {
+		return nil, err
+	}
+	values[op.Key] = value
+	return result, nil
+}
+
+// withTTL copies a value with a new expiration
+func withTTL(value *core.TriffValue, ttl int64) *core.TriffValue {
+	return &core.TriffValue{Type: value.Type, Data: value.Data, TTL: ttl}
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// counterPrefix namespaces rollup buckets, stored as integer strings under
+// counter:<name>:<resolution>:<bucket start unix seconds>
+const counterPrefix = "counter:"
+
+// maxCounterPoints bounds how many buckets a single range query may return
+const maxCounterPoints = 10000
+
+var errWrongCounterType = errs.Newf(errs.ErrWrongType, "counter bucket holds a non-integer value")
+
+// CounterResolution is a bucket width together with how long its buckets
+// are kept before they expire
+type CounterResolution struct {
+	Name      string
+	Width     time.Duration
+	Retention time.Duration
+}
+
+// CounterResolutions are the rollups every increment is recorded into
+var CounterResolutions = []CounterResolution{
+	{Name: "minute", Width: time.Minute, Retention: 24 * time.Hour},
+	{Name: "hour", Width: time.Hour, Retention: 30 * 24 * time.Hour},
+	{Name: "day", Width: 24 * time.Hour, Retention: 365 * 24 * time.Hour},
+}
+
+// CounterPoint is one bucket of a counter series
+type CounterPoint struct {
+	Timestamp int64 `json:"timestamp"` // Unix seconds at the start of the bucket
+	Value     int64 `json:"value"`
+}
+
+// CounterSeries is a counter's buckets over a time range, oldest first
+type CounterSeries struct {
+	Name       string         `json:"name"`
+	Resolution string         `json:"resolution"`
+	Total      int64          `json:"total"`
+	Points     []CounterPoint `json:"points"`
+}
+
+// CounterCommands records counts into minute, hour and day buckets that
+// expire on their own, for simple time-series analytics
+type CounterCommands struct {
+	db  *core.Database
+	now func() time.Time
+}
+
+// NewCounterCommands creates a new counter commands handler
+func NewCounterCommands(db *core.Database) *CounterCommands {
+	return &CounterCommands{db: db, now: db.Clock().Now}
+}
+
+// Incr adds amount to every rollup bucket containing at, or now if at is
+// zero. The data is a map from resolution name to the bucket's new value.
+func (cc *CounterCommands) Incr(name string, amount int64, at time.Time) *core.Response {
+	if name == "" {
+		return counterError("counter name is required")
+	}
+	if at.IsZero() {
+		at = cc.now()
+	}
+
+	values := make(map[string]int64, len(CounterResolutions))
+	for _, resolution := range CounterResolutions {
+		start := at.Truncate(resolution.Width)
+		expiresAt := start.Add(resolution.Width + resolution.Retention)
+		if !expiresAt.After(cc.now()) {
+			// Already past retention, don't resurrect the bucket
+			continue
+		}
+
+		value, err := cc.incrBucket(bucketKey(name, resolution.Name, start.Unix()), amount, expiresAt.Unix())
+		if err != nil {
+			return core.Fail("counter", err)
+		}
+		values[resolution.Name] = value
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    values,
+		Type:    "counter",
+	}
+}
+
+// Range returns the series for a resolution between from and to inclusive,
+// with empty buckets reported as zero
+func (cc *CounterCommands) Range(name, resolutionName string, from, to time.Time) *core.Response {
+	resolution, ok := findResolution(resolutionName)
+	if !ok {
+		return counterError("resolution must be minute, hour or day")
+	}
+	if to.Before(from) {
+		return counterError("range end is before its start")
+	}
+
+	start := from.Truncate(resolution.Width)
+	count := int64(to.Sub(start)/resolution.Width) + 1
+	if count > maxCounterPoints {
+		return counterError(fmt.Sprintf("range spans more than %d buckets", maxCounterPoints))
+	}
+
+	series := CounterSeries{
+		Name:       name,
+		Resolution: resolution.Name,
+		Points:     make([]CounterPoint, 0, count),
+	}
+	for bucket := start; !bucket.After(to); bucket = bucket.Add(resolution.Width) {
+		point := CounterPoint{Timestamp: bucket.Unix()}
+		if value, exists := cc.db.Get(bucketKey(name, resolution.Name, point.Timestamp)); exists {
+			point.Value, _ = strconv.ParseInt(stringData(value), 10, 64)
+		}
+		series.Total += point.Value
+		series.Points = append(series.Points, point)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    series,
+		Type:    "counter",
+	}
+}
+
+// incrBucket atomically adds amount to a bucket, creating it with the given
+// absolute expiry
+func (cc *CounterCommands) incrBucket(key string, amount, expiresAt int64) (int64, error) {
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var version uint64
+		var current int64
+		if value, exists := cc.db.Get(key); exists {
+			if value.Type != core.STRING {
+				return 0, errWrongCounterType
+			}
+			parsed, err := strconv.ParseInt(stringData(value), 10, 64)
+			if err != nil {
+				return 0, errWrongCounterType
+			}
+			version, current = value.Version, parsed
+		}
+
+		updated := &core.TriffValue{
+			Type: core.STRING,
+			Data: strconv.FormatInt(current+amount, 10),
+			TTL:  expiresAt,
+		}
+		if _, err := cc.db.CompareAndSet(key, version, updated); err != core.ErrVersionMismatch {
+			return current + amount, err
+		}
+	}
+	return 0, core.ErrVersionMismatch
+}
+
+func findResolution(name string) (CounterResolution, bool) {
+	for _, resolution := range CounterResolutions {
+		if resolution.Name == name {
+			return resolution, true
+		}
+	}
+	return CounterResolution{}, false
+}
+
+func bucketKey(name, resolution string, start int64) string {
+	return fmt.Sprintf("%s%s:%s:%d", counterPrefix, name, resolution, start)
+}
+
+func counterError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "counter",
+	}
+}
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCounterIncrFollowsDatabaseClock checks that an increment without a
+// time lands in the buckets of the database's clock, not the real time
+func TestCounterIncrFollowsDatabaseClock(t *testing.T) {
+	db, clock := newTestDatabase()
+	cc := NewCounterCommands(db)
+	if response := cc.Incr("hits", 2, time.Time{}); !response.Success {
+		t.Fatal(response.Error)
+	}
+
+	for _, resolution := range CounterResolutions {
+		key := bucketKey("hits", resolution.Name, clock.Now().Truncate(resolution.Width).Unix())
+		value, exists := db.Get(key)
+		if !exists || stringData(value) != "2" {
+			t.Errorf("%s bucket %q = %v, want 2", resolution.Name, key, value)
+		}
+	}
+}
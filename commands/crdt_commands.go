@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	errWrongPNCounterType = errs.Newf(errs.ErrWrongType, "key holds a value that is not a pncounter")
+	errWrongORSetType     = errs.Newf(errs.ErrWrongType, "key holds a value that is not an orset")
+)
+
+// CRDTState is a CRDT value as exchanged between replicas. Change events
+// carry the same type and value fields, so a CDC event can be merged as is.
+type CRDTState struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// CRDTCommands implements values that converge across replicas: PN
+// counters and observed-remove sets. Local writes are made under the
+// database's node ID, and Merge folds in the state of another replica.
+type CRDTCommands struct {
+	db *core.Database
+}
+
+// NewCRDTCommands creates a new CRDT commands handler
+func NewCRDTCommands(db *core.Database) *CRDTCommands {
+	return &CRDTCommands{db: db}
+}
+
+// PNIncrBy adds delta, which may be negative, to a counter, creating it at
+// zero if needed. The data is the new value.
+func (cc *CRDTCommands) PNIncrBy(key string, delta int64) *core.Response {
+	node := cc.db.NodeID()
+	var result int64
+	err := cc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		counter, ttl := core.NewPNCounter(), int64(0)
+		if old != nil {
+			current, ok := old.Data.(*core.PNCounter)
+			if !ok {
+				return nil, errWrongPNCounterType
+			}
+			counter, ttl = current.Clone(), old.TTL
+		}
+		counter.Add(node, delta)
+		result = counter.Value()
+		return &core.TriffValue{Type: core.PNCOUNTER, Data: counter, TTL: ttl}, nil
+	})
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(result)
+}
+
+// PNGet returns a counter's value, 0 if the key doesn't exist
+func (cc *CRDTCommands) PNGet(key string) *core.Response {
+	value, found := cc.db.Get(key)
+	if !found {
+		return crdtSuccess(int64(0))
+	}
+	counter, ok := value.Data.(*core.PNCounter)
+	if !ok {
+		return core.Fail("crdt", errWrongPNCounterType)
+	}
+	return crdtSuccess(counter.Value())
+}
+
+// ORAdd adds members to a set, creating it if needed. The data is the
+// number of members that were not already present.
+func (cc *CRDTCommands) ORAdd(key string, members ...string) *core.Response {
+	node := cc.db.NodeID()
+	added := 0
+	err := cc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		set, ttl := core.NewORSet(), int64(0)
+		if old != nil {
+			current, ok := old.Data.(*core.ORSet)
+			if !ok {
+				return nil, errWrongORSetType
+			}
+			set, ttl = current.Clone(), old.TTL
+		}
+		added = 0
+		for _, member := range members {
+			// Re-adding a present member still tags it anew, so it
+			// survives a concurrent remove on another replica
+			if set.Add(node, member) {
+				added++
+			}
+		}
+		return &core.TriffValue{Type: core.ORSET, Data: set, TTL: ttl}, nil
+	})
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(added)
+}
+
+// ORRem removes members from a set. The data is the number that were
+// present. The key stays when the set becomes empty, so the removal can
+// reach other replicas.
+func (cc *CRDTCommands) ORRem(key string, members ...string) *core.Response {
+	removed := 0
+	err := cc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		removed = 0
+		if old == nil {
+			return nil, nil
+		}
+		current, ok := old.Data.(*core.ORSet)
+		if !ok {
+			return nil, errWrongORSetType
+		}
+		set := current.Clone()
+		for _, member := range members {
+			if set.Remove(member) {
+				removed++
+			}
+		}
+		if removed == 0 {
+			return old, nil
+		}
+		return &core.TriffValue{Type: core.ORSET, Data: set, TTL: old.TTL}, nil
+	})
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(removed)
+}
+
+// ORMembers returns the members of a set in sorted order
+func (cc *CRDTCommands) ORMembers(key string) *core.Response {
+	set, err := cc.orset(key)
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(set.Members())
+}
+
+// ORIsMember reports whether member is in a set
+func (cc *CRDTCommands) ORIsMember(key, member string) *core.Response {
+	set, err := cc.orset(key)
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(set.Contains(member))
+}
+
+// State returns a CRDT's state for merging into another replica
+func (cc *CRDTCommands) State(key string) *core.Response {
+	value, found := cc.db.Get(key)
+	if !found {
+		return core.Fail("crdt", errs.Newf(errs.ErrNotFound, "key does not exist"))
+	}
+	if value.Type != core.PNCOUNTER && value.Type != core.ORSET {
+		return core.Fail("crdt", errs.Newf(errs.ErrWrongType, "key holds a %s, not a CRDT", value.Type))
+	}
+	encoded, err := json.Marshal(value.Data)
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(CRDTState{Type: value.Type.String(), Value: encoded})
+}
+
+// Merge folds another replica's state into a key, creating it if needed.
+// The data reports whether the key changed; merging a state already
+// included changes nothing and emits no change event, so replicas that
+// forward each other's changes settle instead of echoing forever.
+func (cc *CRDTCommands) Merge(key string, state CRDTState) *core.Response {
+	changed := false
+	var err error
+	switch state.Type {
+	case core.PNCOUNTER.String():
+		remote := core.NewPNCounter()
+		if err := json.Unmarshal(state.Value, remote); err != nil {
+			return core.Fail("crdt", fmt.Errorf("invalid pncounter state: %w", err))
+		}
+		err = cc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+			counter, ttl := core.NewPNCounter(), int64(0)
+			if old != nil {
+				current, ok := old.Data.(*core.PNCounter)
+				if !ok {
+					return nil, errWrongPNCounterType
+				}
+				counter, ttl = current.Clone(), old.TTL
+			}
+			if changed = counter.Merge(remote); !changed && old != nil {
+				return old, nil
+			}
+			return &core.TriffValue{Type: core.PNCOUNTER, Data: counter, TTL: ttl}, nil
+		})
+	case core.ORSET.String():
+		remote := core.NewORSet()
+		if err := json.Unmarshal(state.Value, remote); err != nil {
+			return core.Fail("crdt", fmt.Errorf("invalid orset state: %w", err))
+		}
+		err = cc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+			set, ttl := core.NewORSet(), int64(0)
+			if old != nil {
+				current, ok := old.Data.(*core.ORSet)
+				if !ok {
+					return nil, errWrongORSetType
+				}
+				set, ttl = current.Clone(), old.TTL
+			}
+			if changed = set.Merge(remote); !changed && old != nil {
+				return old, nil
+			}
+			return &core.TriffValue{Type: core.ORSET, Data: set, TTL: ttl}, nil
+		})
+	default:
+		return core.Fail("crdt", fmt.Errorf("cannot merge a %q value; only pncounter and orset merge", state.Type))
+	}
+	if err != nil {
+		return core.Fail("crdt", err)
+	}
+	return crdtSuccess(changed)
+}
+
+// orset loads a set, returning an empty one if the key doesn't exist
+func (cc *CRDTCommands) orset(key string) (*core.ORSet, error) {
+	value, found := cc.db.Get(key)
+	if !found {
+		return core.NewORSet(), nil
+	}
+	set, ok := value.Data.(*core.ORSet)
+	if !ok {
+		return nil, errWrongORSetType
+	}
+	return set, nil
+}
+
+func crdtSuccess(data interface{}) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    data,
+		Type:    "crdt",
+	}
+}
@@ -2,7 +2,15 @@ package commands
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
 	"sync"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
 )
 
 type HashStore struct {
@@ -58,3 +66,332 @@ func (hs *HashStore) HGetAll(key string) (map[string]string, error) {
 	}
 	return nil, errors.New("key not found")
 }
+
+var (
+	errNotHash         = errs.Newf(errs.ErrWrongType, "value is not a hash")
+	errHashNotInteger  = errs.Newf(errs.ErrNotInteger, "hash value is not an integer")
+	errHashNotFloat    = errs.Newf(errs.ErrNotInteger, "hash value is not a valid float")
+	errIncrementNaNInf = errs.Newf(errs.ErrNotInteger, "increment would produce NaN or Infinity")
+)
+
+// HashCommands handles hash operations backed by the database
+type HashCommands struct {
+	db *core.Database
+}
+
+// NewHashCommands creates a new hash commands handler
+func NewHashCommands(db *core.Database) *HashCommands {
+	return &HashCommands{db: db}
+}
+
+// HSet sets a field in a hash, creating the hash if needed
+func (hc *HashCommands) HSet(key, field, value string) *core.Response {
+	var updated bool
+	err := hc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		fields := make(map[string]string)
+		var ttl int64
+
+		if old != nil {
+			if old.Type != core.HASH {
+				return nil, errNotHash
+			}
+			for f, v := range hashFields(old) {
+				fields[f] = v
+			}
+			ttl = old.TTL
+		}
+
+		_, updated = fields[field]
+		fields[field] = value
+		return &core.TriffValue{Type: core.HASH, Data: fields, TTL: ttl}, nil
+	})
+	if err != nil {
+		return core.Fail("hash", err)
+	}
+
+	added := 1
+	if updated {
+		added = 0
+	}
+	return &core.Response{
+		Success: true,
+		Data:    added,
+		Type:    "integer",
+	}
+}
+
+// HGet returns the value of a hash field
+func (hc *HashCommands) HGet(key, field string) *core.Response {
+	value, exists := hc.db.Get(key)
+	if !exists {
+		return &core.Response{
+			Success: false,
+			Data:    nil,
+			Type:    "hash",
+		}
+	}
+
+	if value.Type != core.HASH {
+		return core.Fail("hash", errNotHash)
+	}
+
+	fieldValue, ok := hashFields(value)[field]
+	if !ok {
+		return &core.Response{
+			Success: false,
+			Data:    nil,
+			Type:    "hash",
+		}
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    fieldValue,
+		Type:    "string",
+	}
+}
+
+// HDel removes a field from a hash, deleting the key when it becomes empty
+func (hc *HashCommands) HDel(key, field string) *core.Response {
+	removed := 0
+	err := hc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		if old.Type != core.HASH {
+			return nil, errNotHash
+		}
+
+		current := hashFields(old)
+		if _, ok := current[field]; !ok {
+			return old, nil
+		}
+		removed = 1
+		if len(current) == 1 {
+			return nil, nil
+		}
+
+		fields := make(map[string]string, len(current)-1)
+		for f, v := range current {
+			if f != field {
+				fields[f] = v
+			}
+		}
+		return &core.TriffValue{Type: core.HASH, Data: fields, TTL: old.TTL}, nil
+	})
+	if err != nil {
+		return core.Fail("hash", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    removed,
+		Type:    "integer",
+	}
+}
+
+// HGetAll returns all fields and values of a hash
+func (hc *HashCommands) HGetAll(key string) *core.Response {
+	value, exists := hc.db.Get(key)
+	if !exists {
+		return &core.Response{
+			Success: true,
+			Data:    map[string]string{},
+			Type:    "hash",
+		}
+	}
+
+	if value.Type != core.HASH {
+		return core.Fail("hash", errNotHash)
+	}
+
+	fields := make(map[string]string)
+	for f, v := range hashFields(value) {
+		fields[f] = v
+	}
+	return &core.Response{
+		Success: true,
+		Data:    fields,
+		Type:    "hash",
+	}
+}
+
+// HIncrBy adds increment to the integer in a hash field, starting from 0
+// for a missing field or hash
+func (hc *HashCommands) HIncrBy(key, field string, increment int64) *core.Response {
+	var newValue int64
+	err := hc.updateFields(key, func(fields map[string]string) (bool, error) {
+		var current int64
+		if raw, ok := fields[field]; ok {
+			var err error
+			if current, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return false, errHashNotInteger
+			}
+		}
+		if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+			return false, errs.Newf(errs.ErrNotInteger, "increment or decrement would overflow")
+		}
+		newValue = current + increment
+		fields[field] = strconv.FormatInt(newValue, 10)
+		return true, nil
+	})
+	if err != nil {
+		return core.Fail("hash", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    newValue,
+		Type:    "integer",
+	}
+}
+
+// HIncrByFloat adds increment to the number in a hash field, starting from
+// 0 for a missing field or hash. The new value is returned as a string,
+// formatted the way it is stored.
+func (hc *HashCommands) HIncrByFloat(key, field string, increment float64) *core.Response {
+	var newValue string
+	err := hc.updateFields(key, func(fields map[string]string) (bool, error) {
+		var current float64
+		if raw, ok := fields[field]; ok {
+			var err error
+			if current, err = strconv.ParseFloat(raw, 64); err != nil {
+				return false, errHashNotFloat
+			}
+		}
+		sum := current + increment
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return false, errIncrementNaNInf
+		}
+		newValue = strconv.FormatFloat(sum, 'f', -1, 64)
+		fields[field] = newValue
+		return true, nil
+	})
+	if err != nil {
+		return core.Fail("hash", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    newValue,
+		Type:    "string",
+	}
+}
+
+// HSetNX sets a hash field only if it doesn't exist yet, returning 1 if it
+// was set and 0 if it was already there
+func (hc *HashCommands) HSetNX(key, field, value string) *core.Response {
+	set := 0
+	err := hc.updateFields(key, func(fields map[string]string) (bool, error) {
+		if _, ok := fields[field]; ok {
+			return false, nil
+		}
+		fields[field] = value
+		set = 1
+		return true, nil
+	})
+	if err != nil {
+		return core.Fail("hash", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    set,
+		Type:    "integer",
+	}
+}
+
+// HRandField returns random fields of a hash, like Redis's HRANDFIELD: up
+// to count distinct fields for a positive count, or exactly -count fields
+// that may repeat for a negative one. With withValues every field is
+// followed by its value.
+func (hc *HashCommands) HRandField(key string, count int, withValues bool) *core.Response {
+	value, exists := hc.db.Get(key)
+	if !exists {
+		return &core.Response{
+			Success: true,
+			Data:    []string{},
+			Type:    "array",
+		}
+	}
+	if value.Type != core.HASH {
+		return core.Fail("hash", errNotHash)
+	}
+
+	fields := hashFields(value)
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	var picked []string
+	if count >= 0 {
+		rand.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		if count < len(names) {
+			names = names[:count]
+		}
+		picked = names
+	} else if len(names) > 0 {
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = names[rand.Intn(len(names))]
+		}
+	}
+
+	items := make([]string, 0, len(picked)*2)
+	for _, field := range picked {
+		items = append(items, field)
+		if withValues {
+			items = append(items, fields[field])
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    items,
+		Type:    "array",
+	}
+}
+
+// updateFields applies fn to a copy of a hash's fields, creating the hash
+// if needed, and stores the result with the hash's TTL if fn reports a
+// change
+func (hc *HashCommands) updateFields(key string, fn func(fields map[string]string) (bool, error)) error {
+	return hc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		fields := make(map[string]string)
+		var ttl int64
+
+		if old != nil {
+			if old.Type != core.HASH {
+				return nil, errNotHash
+			}
+			for f, v := range hashFields(old) {
+				fields[f] = v
+			}
+			ttl = old.TTL
+		}
+
+		changed, err := fn(fields)
+		if err != nil || !changed {
+			return old, err
+		}
+		return &core.TriffValue{Type: core.HASH, Data: fields, TTL: ttl}, nil
+	})
+}
+
+// hashFields returns the fields of a hash value as a string map
+func hashFields(value *core.TriffValue) map[string]string {
+	switch fields := value.Data.(type) {
+	case map[string]string:
+		return fields
+	case core.PackedHash:
+		return fields.Map()
+	case map[string]interface{}:
+		converted := make(map[string]string, len(fields))
+		for f, v := range fields {
+			converted[f] = fmt.Sprint(v)
+		}
+		return converted
+	}
+	return map[string]string{}
+}
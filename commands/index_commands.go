@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// IndexCommands handles secondary index management and queries
+type IndexCommands struct {
+	db *core.Database
+}
+
+// NewIndexCommands creates a new index commands handler
+func NewIndexCommands(db *core.Database) *IndexCommands {
+	return &IndexCommands{db: db}
+}
+
+// Create declares an index on a hash field for keys matching pattern
+func (ic *IndexCommands) Create(name, pattern, field string) *core.Response {
+	if err := ic.db.CreateIndex(name, pattern, field); err != nil {
+		return core.Fail("index", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// Drop removes an index
+func (ic *IndexCommands) Drop(name string) *core.Response {
+	if !ic.db.DropIndex(name) {
+		return core.Fail("index", core.ErrIndexNotFound)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// List returns all declared indexes
+func (ic *IndexCommands) List() *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    ic.db.ListIndexes(),
+		Type:    "array",
+	}
+}
+
+// Find evaluates a predicate against an index. Supported operators are
+// EQ value, RANGE min max, GT/GTE/LT/LTE value.
+func (ic *IndexCommands) Find(index, op string, args []string) *core.Response {
+	var keys []string
+	var err error
+
+	unbounded := core.RangeBound{Unbounded: true}
+
+	switch strings.ToUpper(op) {
+	case "EQ", "=":
+		if len(args) != 1 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindEqual(index, args[0])
+	case "RANGE", "BETWEEN":
+		if len(args) != 2 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindRange(index, core.ParseRangeBound(args[0]), core.ParseRangeBound(args[1]))
+	case "GT", ">":
+		if len(args) != 1 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindRange(index, core.RangeBound{Value: args[0], Exclusive: true}, unbounded)
+	case "GTE", ">=":
+		if len(args) != 1 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindRange(index, core.RangeBound{Value: args[0]}, unbounded)
+	case "LT", "<":
+		if len(args) != 1 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindRange(index, unbounded, core.RangeBound{Value: args[0], Exclusive: true})
+	case "LTE", "<=":
+		if len(args) != 1 {
+			return findSyntaxError()
+		}
+		keys, err = ic.db.FindRange(index, unbounded, core.RangeBound{Value: args[0]})
+	default:
+		return findSyntaxError()
+	}
+
+	if err != nil {
+		return core.Fail("index", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    keys,
+		Type:    "array",
+	}
+}
+
+func findSyntaxError() *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   "syntax error, expected EQ value | RANGE min max | GT|GTE|LT|LTE value",
+		Type:    "index",
+	}
+}
@@ -0,0 +1,343 @@
+package commands
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Leaderboards are sorted sets stored under leaderboardPrefix+name, with
+// their reset schedule kept in a hash next to them
+const (
+	leaderboardPrefix       = "leaderboard:"
+	leaderboardConfigSuffix = ":config"
+)
+
+// Score update modes for Record
+const (
+	ScoreSet  = "set"  // Replace the member's score
+	ScoreIncr = "incr" // Add to the member's score
+	ScoreBest = "best" // Keep the higher of the old and new score
+)
+
+var (
+	errWrongLeaderboardType = errs.Newf(errs.ErrWrongType, "key holds a value that is not a leaderboard")
+	errMemberNotFound       = errs.Newf(errs.ErrNotFound, "member not found")
+)
+
+// LeaderboardEntry is a member's position on a leaderboard, ranks start at 1
+type LeaderboardEntry struct {
+	Rank   int     `json:"rank"`
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// LeaderboardPage is a window of a leaderboard ordered by descending score
+type LeaderboardPage struct {
+	Name    string             `json:"name"`
+	Total   int                `json:"total"`
+	Entries []LeaderboardEntry `json:"entries"`
+	ResetAt int64              `json:"reset_at,omitempty"` // Unix seconds of the next scheduled reset
+}
+
+// LeaderboardRank is a member's entry together with its neighbours
+type LeaderboardRank struct {
+	Entry  LeaderboardEntry   `json:"entry"`
+	Around []LeaderboardEntry `json:"around"`
+	Total  int                `json:"total"`
+}
+
+// LeaderboardCommands provides game-style leaderboards on top of sorted sets
+type LeaderboardCommands struct {
+	db  *core.Database
+	now func() time.Time
+}
+
+// NewLeaderboardCommands creates a new leaderboard commands handler
+func NewLeaderboardCommands(db *core.Database) *LeaderboardCommands {
+	return &LeaderboardCommands{db: db, now: db.Clock().Now}
+}
+
+// Record updates a member's score using mode (set, incr or best) and
+// returns the member's resulting entry
+func (lc *LeaderboardCommands) Record(name, member string, score float64, mode string) *core.Response {
+	if member == "" {
+		return leaderboardError("member is required")
+	}
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return leaderboardError("score must be a finite number")
+	}
+	switch mode {
+	case "":
+		mode = ScoreSet
+	case ScoreSet, ScoreIncr, ScoreBest:
+	default:
+		return leaderboardError("mode must be set, incr or best")
+	}
+
+	key := leaderboardPrefix + name
+	resetAt := lc.nextReset(name)
+	var scores map[string]float64
+	for i := 0; ; i++ {
+		if i == maxRateLimitRetries {
+			return core.Fail("leaderboard", core.ErrVersionMismatch)
+		}
+
+		var version uint64
+		var ttl int64
+		scores = make(map[string]float64)
+		if value, exists := lc.db.Get(key); exists {
+			current, ok := value.Data.(map[string]float64)
+			if value.Type != core.ZSET || !ok {
+				return core.Fail("leaderboard", errWrongLeaderboardType)
+			}
+			for m, s := range current {
+				scores[m] = s
+			}
+			version, ttl = value.Version, value.TTL
+		} else {
+			ttl = resetAt
+		}
+
+		old, exists := scores[member]
+		switch {
+		case mode == ScoreIncr:
+			scores[member] = old + score
+		case mode == ScoreBest && exists && old >= score:
+			// Nothing to write, the stored score is already better
+			return leaderboardSuccess(rankOf(sortScores(scores), member))
+		default:
+			scores[member] = score
+		}
+
+		_, err := lc.db.CompareAndSet(key, version, &core.TriffValue{Type: core.ZSET, Data: scores, TTL: ttl})
+		if err == nil {
+			break
+		}
+		if err != core.ErrVersionMismatch {
+			return core.Fail("leaderboard", err)
+		}
+	}
+
+	return leaderboardSuccess(rankOf(sortScores(scores), member))
+}
+
+// Rank returns a member's entry and up to around entries on either side
+func (lc *LeaderboardCommands) Rank(name, member string, around int) *core.Response {
+	entries, err := lc.entries(name)
+	if err != nil {
+		return core.Fail("leaderboard", err)
+	}
+	entry := rankOf(entries, member)
+	if entry.Rank == 0 {
+		return core.Fail("leaderboard", errMemberNotFound)
+	}
+
+	if around < 0 {
+		around = 0
+	}
+	start := entry.Rank - 1 - around
+	if start < 0 {
+		start = 0
+	}
+	end := entry.Rank + around
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	return leaderboardSuccess(LeaderboardRank{
+		Entry:  entry,
+		Around: entries[start:end],
+		Total:  len(entries),
+	})
+}
+
+// Top returns limit entries starting at offset, highest score first
+func (lc *LeaderboardCommands) Top(name string, offset, limit int) *core.Response {
+	if offset < 0 || limit <= 0 {
+		return leaderboardError("offset must be non-negative and limit positive")
+	}
+	entries, err := lc.entries(name)
+	if err != nil {
+		return core.Fail("leaderboard", err)
+	}
+
+	page := LeaderboardPage{Name: name, Total: len(entries), Entries: []LeaderboardEntry{}}
+	if value, exists := lc.db.Get(leaderboardPrefix + name); exists {
+		page.ResetAt = value.TTL
+	}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Entries = entries[offset:end]
+	}
+	return leaderboardSuccess(page)
+}
+
+// Remove drops a member from a leaderboard
+func (lc *LeaderboardCommands) Remove(name, member string) *core.Response {
+	key := leaderboardPrefix + name
+	for i := 0; i < maxRateLimitRetries; i++ {
+		value, exists := lc.db.Get(key)
+		if !exists {
+			return leaderboardSuccess(false)
+		}
+		current, ok := value.Data.(map[string]float64)
+		if value.Type != core.ZSET || !ok {
+			return core.Fail("leaderboard", errWrongLeaderboardType)
+		}
+		if _, found := current[member]; !found {
+			return leaderboardSuccess(false)
+		}
+		if len(current) == 1 {
+			if lc.db.DeleteIf(key, func(v *core.TriffValue) bool { return v.Version == value.Version }) {
+				return leaderboardSuccess(true)
+			}
+			continue
+		}
+
+		scores := make(map[string]float64, len(current)-1)
+		for m, s := range current {
+			if m != member {
+				scores[m] = s
+			}
+		}
+		updated := &core.TriffValue{Type: core.ZSET, Data: scores, TTL: value.TTL}
+		_, err := lc.db.CompareAndSet(key, value.Version, updated)
+		if err == nil {
+			return leaderboardSuccess(true)
+		}
+		if err != core.ErrVersionMismatch {
+			return core.Fail("leaderboard", err)
+		}
+	}
+	return core.Fail("leaderboard", core.ErrVersionMismatch)
+}
+
+// Reset clears every score on a leaderboard, keeping its schedule
+func (lc *LeaderboardCommands) Reset(name string) *core.Response {
+	return leaderboardSuccess(lc.db.Delete(leaderboardPrefix + name))
+}
+
+// Schedule sets how often a leaderboard resets: hourly, daily, weekly,
+// monthly or none. Resets happen at period boundaries in UTC and are
+// implemented by expiring the board, so they cost nothing until then.
+func (lc *LeaderboardCommands) Schedule(name, period string) *core.Response {
+	if period != "none" {
+		if _, ok := nextPeriodBoundary(lc.now(), period); !ok {
+			return leaderboardError("period must be hourly, daily, weekly, monthly or none")
+		}
+	}
+
+	configKey := leaderboardPrefix + name + leaderboardConfigSuffix
+	if period == "none" {
+		lc.db.Delete(configKey)
+	} else {
+		lc.db.Set(configKey, &core.TriffValue{Type: core.HASH, Data: map[string]string{"reset": period}})
+	}
+
+	// Apply the new schedule to the running board
+	key := leaderboardPrefix + name
+	if resetAt := lc.nextReset(name); resetAt > 0 {
+		lc.db.SetTTL(key, resetAt-lc.now().Unix())
+	} else {
+		lc.db.Persist(key)
+	}
+	return leaderboardSuccess(period)
+}
+
+// entries returns a leaderboard's entries ordered by rank
+func (lc *LeaderboardCommands) entries(name string) ([]LeaderboardEntry, error) {
+	value, exists := lc.db.Get(leaderboardPrefix + name)
+	if !exists {
+		return nil, nil
+	}
+	scores, ok := value.Data.(map[string]float64)
+	if value.Type != core.ZSET || !ok {
+		return nil, errWrongLeaderboardType
+	}
+	return sortScores(scores), nil
+}
+
+// nextReset returns the unix time of the board's next scheduled reset, or
+// 0 if it has no schedule
+func (lc *LeaderboardCommands) nextReset(name string) int64 {
+	config, exists := lc.db.Get(leaderboardPrefix + name + leaderboardConfigSuffix)
+	if !exists {
+		return 0
+	}
+	fields, _ := core.StringMap(config.Data)
+	next, ok := nextPeriodBoundary(lc.now(), fields["reset"])
+	if !ok {
+		return 0
+	}
+	return next.Unix()
+}
+
+// nextPeriodBoundary returns the start of the period after the one
+// containing now, in UTC. Weeks start on Monday.
+func nextPeriodBoundary(now time.Time, period string) (time.Time, bool) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "hourly":
+		return now.Truncate(time.Hour).Add(time.Hour), true
+	case "daily":
+		return day.AddDate(0, 0, 1), true
+	case "weekly":
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, 7-daysSinceMonday), true
+	case "monthly":
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// sortScores orders members by descending score, ties broken by member
+func sortScores(scores map[string]float64) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(scores))
+	for member, score := range scores {
+		entries = append(entries, LeaderboardEntry{Member: member, Score: score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Member < entries[j].Member
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// rankOf finds a member in ranked entries, returning a zero entry if absent
+func rankOf(entries []LeaderboardEntry, member string) LeaderboardEntry {
+	for _, entry := range entries {
+		if entry.Member == member {
+			return entry
+		}
+	}
+	return LeaderboardEntry{}
+}
+
+func leaderboardSuccess(data interface{}) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    data,
+		Type:    "leaderboard",
+	}
+}
+
+func leaderboardError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "leaderboard",
+	}
+}
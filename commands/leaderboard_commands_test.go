@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLeaderboardScheduleFollowsDatabaseClock checks that a scheduled
+// reset expires the board at the next boundary of the database's clock
+func TestLeaderboardScheduleFollowsDatabaseClock(t *testing.T) {
+	db, clock := newTestDatabase()
+	clock.Advance(10 * time.Minute)
+	lc := NewLeaderboardCommands(db)
+	if response := lc.Record("weekly", "alice", 10, "set"); !response.Success {
+		t.Fatal(response.Error)
+	}
+	if response := lc.Schedule("weekly", "hourly"); !response.Success {
+		t.Fatal(response.Error)
+	}
+
+	value, exists := db.Get(leaderboardPrefix + "weekly")
+	want := clock.Now().Truncate(time.Hour).Add(time.Hour).Unix()
+	if !exists || value.TTL != want {
+		t.Fatalf("board expires at %v, want %d", value, want)
+	}
+	clock.Advance(51 * time.Minute)
+	if _, exists := db.Get(leaderboardPrefix + "weekly"); exists {
+		t.Fatal("board still there after the hour passed on the clock")
+	}
+}
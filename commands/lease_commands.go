@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var errWrongLeaseType = errs.Newf(errs.ErrWrongType, "key holds a value that is not a lease")
+
+// LeaderElectionRecord is a lease in the form Kubernetes leader election
+// keeps it in Lease objects and the control-plane.alpha.kubernetes.io/leader
+// annotation, so controllers can hold their election in triff instead
+type LeaderElectionRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int64     `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	LeaderTransitions    int64     `json:"leaderTransitions"`
+}
+
+// HeldAt reports whether the lease still has a holder at now
+func (r LeaderElectionRecord) HeldAt(now time.Time) bool {
+	expires := r.RenewTime.Add(time.Duration(r.LeaseDurationSeconds) * time.Second)
+	return r.HolderIdentity != "" && now.Before(expires)
+}
+
+// LeaseCommands implements leader election leases on top of hashes. Unlike
+// locks they don't expire as keys: the record stays, so the number of
+// leader transitions survives a lease running out.
+type LeaseCommands struct {
+	db *core.Database
+}
+
+// NewLeaseCommands creates a new lease commands handler
+func NewLeaseCommands(db *core.Database) *LeaseCommands {
+	return &LeaseCommands{db: db}
+}
+
+// Acquire takes the lease for holder if it is free or has run out, and
+// renews it if holder already has it. Either way it returns the record as
+// it stands afterwards, so holder leads if the record names it.
+func (lc *LeaseCommands) Acquire(key, holder string, duration time.Duration) *core.Response {
+	if holder == "" || duration <= 0 {
+		return leaseError("lease holder and a positive duration are required")
+	}
+
+	now := lc.db.Clock().Now().UTC().Truncate(time.Second)
+	var record LeaderElectionRecord
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		current, err := leaseRecord(old)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case current == nil:
+			record = LeaderElectionRecord{AcquireTime: now}
+		case current.HolderIdentity == holder:
+			record = *current
+		case current.HeldAt(now):
+			record = *current
+			return old, nil
+		default:
+			record = LeaderElectionRecord{AcquireTime: now, LeaderTransitions: current.LeaderTransitions + 1}
+		}
+		record.HolderIdentity = holder
+		record.LeaseDurationSeconds = lockSeconds(duration)
+		record.RenewTime = now
+		return record.value(), nil
+	})
+	if err != nil {
+		return core.Fail("lease", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    record,
+		Type:    "lease",
+	}
+}
+
+// Get returns the lease record
+func (lc *LeaseCommands) Get(key string) *core.Response {
+	value, _ := lc.db.Get(key)
+	record, err := leaseRecord(value)
+	if err != nil {
+		return core.Fail("lease", err)
+	}
+	if record == nil {
+		return core.Fail("lease", errs.Newf(errs.ErrNotFound, "lease %q not found", key))
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    *record,
+		Type:    "lease",
+	}
+}
+
+// Release gives the lease up if holder has it, the way client-go does on
+// shutdown: the record stays without a holder, so the next candidate
+// takes over at once
+func (lc *LeaseCommands) Release(key, holder string) *core.Response {
+	now := lc.db.Clock().Now().UTC().Truncate(time.Second)
+	released := false
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		current, err := leaseRecord(old)
+		if err != nil || current == nil || current.HolderIdentity != holder || holder == "" {
+			return old, err
+		}
+		released = true
+		record := *current
+		record.HolderIdentity = ""
+		record.LeaseDurationSeconds = 1
+		record.AcquireTime, record.RenewTime = now, now
+		return record.value(), nil
+	})
+	if err != nil {
+		return core.Fail("lease", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    released,
+		Type:    "boolean",
+	}
+}
+
+// value encodes the record as the hash it is stored in
+func (r LeaderElectionRecord) value() *core.TriffValue {
+	return &core.TriffValue{
+		Type: core.HASH,
+		Data: map[string]string{
+			"holderIdentity":       r.HolderIdentity,
+			"leaseDurationSeconds": strconv.FormatInt(r.LeaseDurationSeconds, 10),
+			"acquireTime":          r.AcquireTime.Format(time.RFC3339),
+			"renewTime":            r.RenewTime.Format(time.RFC3339),
+			"leaderTransitions":    strconv.FormatInt(r.LeaderTransitions, 10),
+		},
+	}
+}
+
+// leaseRecord decodes a lease hash, nil for a missing key
+func leaseRecord(value *core.TriffValue) (*LeaderElectionRecord, error) {
+	if value == nil {
+		return nil, nil
+	}
+	fields, ok := core.StringMap(value.Data)
+	if value.Type != core.HASH || !ok {
+		return nil, errWrongLeaseType
+	}
+
+	var record LeaderElectionRecord
+	var err [4]error
+	record.HolderIdentity = fields["holderIdentity"]
+	record.LeaseDurationSeconds, err[0] = strconv.ParseInt(fields["leaseDurationSeconds"], 10, 64)
+	record.AcquireTime, err[1] = time.Parse(time.RFC3339, fields["acquireTime"])
+	record.RenewTime, err[2] = time.Parse(time.RFC3339, fields["renewTime"])
+	record.LeaderTransitions, err[3] = strconv.ParseInt(fields["leaderTransitions"], 10, 64)
+	for _, e := range err {
+		if e != nil {
+			return nil, errWrongLeaseType
+		}
+	}
+	return &record, nil
+}
+
+func leaseError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "lease",
+	}
+}
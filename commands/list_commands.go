@@ -1,60 +1,471 @@
 package commands
 
 import (
-	"errors"
-	"sync"
+	"fmt"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
 )
 
-type ListStore struct {
-	data map[string][]string
-	mu   sync.RWMutex
+var errNotList = errs.Newf(errs.ErrWrongType, "value is not a list")
+
+// PoppedList is what LMPOP popped and the key it came from
+type PoppedList struct {
+	Key   string   `json:"key"` // Empty when every list was empty
+	Items []string `json:"items"`
+}
+
+// ListCommands handles list operations backed by the database
+type ListCommands struct {
+	db *core.Database
+}
+
+// NewListCommands creates a new list commands handler
+func NewListCommands(db *core.Database) *ListCommands {
+	return &ListCommands{db: db}
+}
+
+// LPush pushes values onto the head of a list one after another, so the
+// last ends up first, creating the list if needed. Returns its length.
+func (lc *ListCommands) LPush(key string, values ...string) *core.Response {
+	return lc.push(key, values, true, 0)
+}
+
+// RPush appends values to the tail of a list, creating the list if
+// needed. Returns its length.
+func (lc *ListCommands) RPush(key string, values ...string) *core.Response {
+	return lc.push(key, values, false, 0)
+}
+
+// LPushCapped is LPUSH key value [value ...] CAP limit: it caps the list
+// at limit items, then pushes, dropping the oldest items from the tail.
+// The cap is stored with the list, so later pushes keep trimming; limit 0
+// pushes under the current cap. Returns the length after trimming.
+func (lc *ListCommands) LPushCapped(key string, limit int, values ...string) *core.Response {
+	return lc.push(key, values, true, limit)
+}
+
+// RPushCapped is LPushCapped's counterpart for lists that grow at the
+// tail, dropping the oldest items from the head
+func (lc *ListCommands) RPushCapped(key string, limit int, values ...string) *core.Response {
+	return lc.push(key, values, false, limit)
+}
+
+// LPop removes and returns up to count items from the head of a list (Data
+// []string), deleting the key once the list is empty
+func (lc *ListCommands) LPop(key string, count int) *core.Response {
+	return lc.pop(key, count, true)
+}
+
+// RPop removes and returns up to count items from the tail of a list, last
+// first
+func (lc *ListCommands) RPop(key string, count int) *core.Response {
+	return lc.pop(key, count, false)
 }
 
-func NewListStore() *ListStore {
-	return &ListStore{
-		data: make(map[string][]string),
+// LLen returns the length of a list, 0 for a missing key
+func (lc *ListCommands) LLen(key string) *core.Response {
+	items, err := lc.items(key)
+	if err != nil {
+		return core.Fail("list", err)
 	}
+	return listCount(len(items))
 }
 
-func (ls *ListStore) LPush(key, value string) {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
-	ls.data[key] = append([]string{value}, ls.data[key]...)
+// LRange returns the items from start to stop, both included (Data
+// []string). Negative indexes count from the tail, -1 being the last item.
+func (lc *ListCommands) LRange(key string, start, stop int) *core.Response {
+	items, err := lc.items(key)
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	if start < 0 {
+		start += len(items)
+	}
+	if stop < 0 {
+		stop += len(items)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(items) {
+		stop = len(items) - 1
+	}
+	selected := []string{}
+	if start <= stop {
+		selected = append(selected, items[start:stop+1]...)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    selected,
+		Type:    "array",
+	}
+}
+
+// LMPop pops up to count items from the head (left) or tail of the first
+// non-empty list among keys (Data PoppedList). Its Key is empty if every
+// list was empty.
+func (lc *ListCommands) LMPop(keys []string, left bool, count int) *core.Response {
+	if count < 1 {
+		return core.Fail("list", fmt.Errorf("COUNT must be positive"))
+	}
+	for _, key := range keys {
+		popped, err := lc.popItems(key, count, left)
+		if err != nil {
+			return core.Fail("list", err)
+		}
+		if len(popped) > 0 {
+			return listPopped(PoppedList{Key: key, Items: popped})
+		}
+	}
+	return listPopped(PoppedList{Items: []string{}})
 }
 
-func (ls *ListStore) RPush(key, value string) {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
-	ls.data[key] = append(ls.data[key], value)
+// LPos returns the indexes of the items equal to element (Data []int),
+// like LPOS key element RANK rank COUNT count MAXLEN maxLen. A negative
+// rank searches from the tail, count 0 returns every match and maxLen 0
+// scans the whole list.
+func (lc *ListCommands) LPos(key, element string, rank, count, maxLen int) *core.Response {
+	if rank == 0 {
+		return core.Fail("list", fmt.Errorf("RANK can't be zero"))
+	}
+	if count < 0 || maxLen < 0 {
+		return core.Fail("list", fmt.Errorf("COUNT and MAXLEN can't be negative"))
+	}
+	items, err := lc.items(key)
+	if err != nil {
+		return core.Fail("list", err)
+	}
+
+	positions := []int{}
+	skip := rank - 1
+	step, i := 1, 0
+	if rank < 0 {
+		skip = -rank - 1
+		step, i = -1, len(items)-1
+	}
+	for scanned := 0; i >= 0 && i < len(items) && (maxLen == 0 || scanned < maxLen); i, scanned = i+step, scanned+1 {
+		if items[i] != element {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, i)
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    positions,
+		Type:    "array",
+	}
 }
 
-func (ls *ListStore) LPop(key string) (string, error) {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
-	if list, exists := ls.data[key]; exists && len(list) > 0 {
-		val := list[0]
-		ls.data[key] = list[1:]
-		return val, nil
+// LInsert inserts value before or after the first item equal to pivot and
+// returns the new length, -1 if pivot isn't in the list, or 0 if the key
+// doesn't exist. Only pushes trim to a list's cap, so LINSERT can grow a
+// list past it.
+func (lc *ListCommands) LInsert(key string, before bool, pivot, value string) *core.Response {
+	length := 0
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		length = -1
+		for i, item := range items {
+			if item != pivot {
+				continue
+			}
+			if !before {
+				i++
+			}
+			items = append(items, "")
+			copy(items[i+1:], items[i:])
+			items[i] = value
+			length = len(items)
+			return listValue(old, items), nil
+		}
+		return old, nil
+	})
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return listCount(length)
+}
+
+// LRem removes items equal to value and returns how many: the first count
+// from the head when count is positive, from the tail when it is
+// negative, or all of them when it is 0
+func (lc *ListCommands) LRem(key string, count int, value string) *core.Response {
+	removed := 0
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		remove := make([]bool, len(items))
+		if count >= 0 {
+			for i := 0; i < len(items) && (count == 0 || removed < count); i++ {
+				if items[i] == value {
+					remove[i] = true
+					removed++
+				}
+			}
+		} else {
+			for i := len(items) - 1; i >= 0 && removed < -count; i-- {
+				if items[i] == value {
+					remove[i] = true
+					removed++
+				}
+			}
+		}
+		if removed == 0 {
+			return old, nil
+		}
+
+		kept := make([]string, 0, len(items)-removed)
+		for i, item := range items {
+			if !remove[i] {
+				kept = append(kept, item)
+			}
+		}
+		return listValue(old, kept), nil
+	})
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return listCount(removed)
+}
+
+// LSet replaces the item at index, where negative indexes count from the
+// tail
+func (lc *ListCommands) LSet(key string, index int, value string) *core.Response {
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, errs.Newf(errs.ErrNotFound, "no such key")
+		}
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		i := index
+		if i < 0 {
+			i += len(items)
+		}
+		if i < 0 || i >= len(items) {
+			return nil, fmt.Errorf("index out of range")
+		}
+		items[i] = value
+		return listValue(old, items), nil
+	})
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// LTrim keeps only the items from start to stop, both included, where
+// negative indexes count from the tail, deleting the key if none are left.
+// Returns the length left. LTRIM key 0 99 after every push keeps the
+// latest 100 entries.
+func (lc *ListCommands) LTrim(key string, start, stop int) *core.Response {
+	length := 0
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		from, to := start, stop
+		if from < 0 {
+			from += len(items)
+		}
+		if to < 0 {
+			to += len(items)
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to >= len(items) {
+			to = len(items) - 1
+		}
+		if from > to {
+			return nil, nil
+		}
+		length = to - from + 1
+		if length == len(items) {
+			return old, nil
+		}
+		return listValue(old, items[from:to+1]), nil
+	})
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return listCount(length)
+}
+
+// push adds values to one end of a list and trims it to limit, or to its
+// stored cap if limit is 0, in the same update
+func (lc *ListCommands) push(key string, values []string, head bool, limit int) *core.Response {
+	if limit < 0 {
+		return core.Fail("list", fmt.Errorf("CAP can't be negative"))
+	}
+	length := 0
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		if head {
+			pushed := make([]string, 0, len(items)+len(values))
+			for i := len(values) - 1; i >= 0; i-- {
+				pushed = append(pushed, values[i])
+			}
+			items = append(pushed, items...)
+		} else {
+			items = append(items, values...)
+		}
+
+		if limit == 0 && old != nil {
+			limit = old.Cap
+		}
+		if limit > 0 && len(items) > limit {
+			// The oldest items are at the end pushes don't add to
+			if head {
+				items = items[:limit]
+			} else {
+				items = append([]string(nil), items[len(items)-limit:]...)
+			}
+		}
+		length = len(items)
+		value := listValue(old, items)
+		if value != nil {
+			value.Cap = limit
+		}
+		return value, nil
+	})
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return listCount(length)
+}
+
+func (lc *ListCommands) pop(key string, count int, head bool) *core.Response {
+	if count < 0 {
+		return core.Fail("list", fmt.Errorf("count can't be negative"))
+	}
+	popped, err := lc.popItems(key, count, head)
+	if err != nil {
+		return core.Fail("list", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    popped,
+		Type:    "array",
+	}
+}
+
+// popItems removes up to count items from one end of a list in a single
+// update, deleting the key once it is empty. Items from the tail come
+// last first.
+func (lc *ListCommands) popItems(key string, count int, head bool) ([]string, error) {
+	popped := []string{}
+	err := lc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		items, err := listOf(old)
+		if err != nil {
+			return nil, err
+		}
+		if count > len(items) {
+			count = len(items)
+		}
+		if count == 0 {
+			return old, nil
+		}
+		if head {
+			popped = append(popped, items[:count]...)
+			items = items[count:]
+		} else {
+			for i := len(items) - 1; i >= len(items)-count; i-- {
+				popped = append(popped, items[i])
+			}
+			items = items[:len(items)-count]
+		}
+		return listValue(old, items), nil
+	})
+	return popped, err
+}
+
+// items returns the items of a list, none if the key is missing
+func (lc *ListCommands) items(key string) ([]string, error) {
+	value, exists := lc.db.Get(key)
+	if !exists {
+		return nil, nil
+	}
+	items, ok := core.ListItems(value.Data)
+	if value.Type != core.LIST || !ok {
+		return nil, errNotList
+	}
+	return items, nil
+}
+
+// listOf copies the items of a staged value for modifying, none for a
+// missing key
+func listOf(value *core.TriffValue) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	items, ok := core.ListItems(value.Data)
+	if value.Type != core.LIST || !ok {
+		return nil, errNotList
+	}
+	return append([]string(nil), items...), nil
+}
+
+// listValue returns the value storing items in place of old, keeping its
+// expiry and cap, or nil to delete the key once the list is empty. The
+// database packs small lists as they are stored.
+func listValue(old *core.TriffValue, items []string) *core.TriffValue {
+	if len(items) == 0 {
+		return nil
+	}
+	value := &core.TriffValue{Type: core.LIST, Data: items}
+	if old != nil {
+		value.TTL, value.Cap = old.TTL, old.Cap
 	}
-	return "", errors.New("list is empty or key not found")
+	return value
 }
 
-func (ls *ListStore) RPop(key string) (string, error) {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
-	if list, exists := ls.data[key]; exists && len(list) > 0 {
-		val := list[len(list)-1]
-		ls.data[key] = list[:len(list)-1]
-		return val, nil
+func listCount(n int) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    n,
+		Type:    "integer",
 	}
-	return "", errors.New("list is empty or key not found")
 }
 
-func (ls *ListStore) LRange(key string) ([]string, error) {
-	ls.mu.RLock()
-	defer ls.mu.RUnlock()
-	if list, exists := ls.data[key]; exists {
-		return list, nil
+func listPopped(popped PoppedList) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    popped,
+		Type:    "list",
 	}
-	return nil, errors.New("key not found")
 }
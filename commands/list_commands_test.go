@@ -0,0 +1,217 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// newList returns list commands over a database holding key's items
+func newList(t *testing.T, key string, items ...string) *ListCommands {
+	t.Helper()
+	db, _ := newTestDatabase()
+	lc := NewListCommands(db)
+	if len(items) > 0 {
+		if _, err := core.Result[int](lc.RPush(key, items...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lc
+}
+
+// checkList fails unless key holds want, none meaning the key is gone
+func checkList(t *testing.T, lc *ListCommands, key string, want ...string) {
+	t.Helper()
+	if _, exists := lc.db.Get(key); exists != (len(want) > 0) {
+		t.Fatalf("key %q exists = %v with %d items expected", key, exists, len(want))
+	}
+	items, err := core.Result[[]string](lc.LRange(key, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(items) != fmt.Sprint(want) {
+		t.Fatalf("list %q = %q, want %q", key, items, want)
+	}
+}
+
+func TestLPos(t *testing.T) {
+	lc := newList(t, "l", "a", "b", "c", "b", "d", "b")
+	tests := []struct {
+		element             string
+		rank, count, maxLen int
+		want                []int
+	}{
+		{"b", 1, 1, 0, []int{1}},
+		{"b", 1, 0, 0, []int{1, 3, 5}},
+		{"b", 2, 0, 0, []int{3, 5}},
+		{"b", -1, 2, 0, []int{5, 3}},
+		{"b", 1, 0, 3, []int{1}},
+		{"b", -1, 0, 2, []int{5}},
+		{"x", 1, 0, 0, []int{}},
+	}
+	for _, tt := range tests {
+		got, err := core.Result[[]int](lc.LPos("l", tt.element, tt.rank, tt.count, tt.maxLen))
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("LPos(%q, rank %d, count %d, maxlen %d) = %v (%v), want %v",
+				tt.element, tt.rank, tt.count, tt.maxLen, got, err, tt.want)
+		}
+	}
+	if response := lc.LPos("l", "b", 0, 0, 0); response.Success {
+		t.Error("LPos with rank 0 succeeded")
+	}
+}
+
+func TestLInsert(t *testing.T) {
+	lc := newList(t, "l", "a", "c", "c")
+	if n, err := core.Result[int](lc.LInsert("l", true, "c", "b")); err != nil || n != 4 {
+		t.Fatalf("LInsert before = %d (%v), want 4", n, err)
+	}
+	if n, err := core.Result[int](lc.LInsert("l", false, "c", "d")); err != nil || n != 5 {
+		t.Fatalf("LInsert after = %d (%v), want 5", n, err)
+	}
+	checkList(t, lc, "l", "a", "b", "c", "d", "c")
+
+	if n, err := core.Result[int](lc.LInsert("l", true, "x", "y")); err != nil || n != -1 {
+		t.Fatalf("LInsert with a missing pivot = %d (%v), want -1", n, err)
+	}
+	if n, err := core.Result[int](lc.LInsert("missing", true, "a", "b")); err != nil || n != 0 {
+		t.Fatalf("LInsert on a missing key = %d (%v), want 0", n, err)
+	}
+	checkList(t, lc, "missing")
+}
+
+func TestLRem(t *testing.T) {
+	tests := []struct {
+		count   int
+		removed int
+		want    []string
+	}{
+		{2, 2, []string{"b", "a", "c", "a"}},
+		{-2, 2, []string{"a", "b", "a", "c"}},
+		{0, 4, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		lc := newList(t, "l", "a", "b", "a", "a", "c", "a")
+		if n, err := core.Result[int](lc.LRem("l", tt.count, "a")); err != nil || n != tt.removed {
+			t.Fatalf("LRem count %d = %d (%v), want %d", tt.count, n, err, tt.removed)
+		}
+		checkList(t, lc, "l", tt.want...)
+	}
+
+	lc := newList(t, "l", "a", "a")
+	if n, err := core.Result[int](lc.LRem("l", 0, "a")); err != nil || n != 2 {
+		t.Fatalf("LRem of every item = %d (%v), want 2", n, err)
+	}
+	checkList(t, lc, "l")
+}
+
+func TestLSet(t *testing.T) {
+	lc := newList(t, "l", "a", "b", "c")
+	if _, err := core.Result[string](lc.LSet("l", 0, "x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.Result[string](lc.LSet("l", -1, "z")); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, lc, "l", "x", "b", "z")
+
+	if _, err := core.Result[string](lc.LSet("l", 3, "y")); err == nil {
+		t.Error("LSet past the end succeeded")
+	}
+	if _, err := core.Result[string](lc.LSet("missing", 0, "y")); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("LSet on a missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestLTrim(t *testing.T) {
+	tests := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, 1, []string{"a", "b"}},
+		{-2, -1, []string{"d", "e"}},
+		{1, 100, []string{"b", "c", "d", "e"}},
+		{-100, 0, []string{"a"}},
+		{3, 1, nil},
+		{0, -1, []string{"a", "b", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		lc := newList(t, "l", "a", "b", "c", "d", "e")
+		n, err := core.Result[int](lc.LTrim("l", tt.start, tt.stop))
+		if err != nil || n != len(tt.want) {
+			t.Fatalf("LTrim(%d, %d) = %d (%v), want %d", tt.start, tt.stop, n, err, len(tt.want))
+		}
+		checkList(t, lc, "l", tt.want...)
+	}
+}
+
+// TestListCommandsKeepTTL checks that rewriting a list keeps its expiry
+func TestListCommandsKeepTTL(t *testing.T) {
+	lc := newList(t, "l", "a", "b", "c")
+	if !lc.db.SetTTL("l", 60) {
+		t.Fatal("SetTTL failed")
+	}
+	lc.LSet("l", 0, "x")
+	lc.LInsert("l", true, "b", "y")
+	lc.LRem("l", 1, "c")
+	lc.LTrim("l", 0, 1)
+	if value, exists := lc.db.Get("l"); !exists || value.TTL == 0 {
+		t.Fatalf("list lost its TTL")
+	}
+}
+
+func TestListCommandsWrongType(t *testing.T) {
+	db, _ := newTestDatabase()
+	db.Set("s", &core.TriffValue{Type: core.STRING, Data: "v"})
+	lc := NewListCommands(db)
+	for name, response := range map[string]*core.Response{
+		"LPOS":    lc.LPos("s", "v", 1, 0, 0),
+		"LINSERT": lc.LInsert("s", true, "v", "w"),
+		"LREM":    lc.LRem("s", 0, "v"),
+		"LSET":    lc.LSet("s", 0, "w"),
+		"LTRIM":   lc.LTrim("s", 0, 0),
+	} {
+		if err := response.Failure(); !errors.Is(err, errs.ErrWrongType) {
+			t.Errorf("%s on a string returned %v, want ErrWrongType", name, err)
+		}
+	}
+}
+
+func TestPushCapped(t *testing.T) {
+	lc := newList(t, "feed")
+	for i := 0; i < 5; i++ {
+		if n, err := core.Result[int](lc.LPushCapped("feed", 3, fmt.Sprint(i))); err != nil || n != min(i+1, 3) {
+			t.Fatalf("LPushCapped #%d = %d (%v)", i, n, err)
+		}
+	}
+	checkList(t, lc, "feed", "4", "3", "2")
+
+	// The cap stays with the list through other writes
+	lc.LPop("feed", 1)
+	lc.LSet("feed", 0, "x")
+	if n, err := core.Result[int](lc.LPush("feed", "5", "6")); err != nil || n != 3 {
+		t.Fatalf("LPush under the cap = %d (%v), want 3", n, err)
+	}
+	checkList(t, lc, "feed", "6", "5", "x")
+	if meta, _ := lc.db.Metadata("feed"); meta.Cap != 3 {
+		t.Fatalf("cap = %d, want 3", meta.Cap)
+	}
+
+	lc = newList(t, "log", "a", "b", "c", "d")
+	if n, err := core.Result[int](lc.RPushCapped("log", 2, "e")); err != nil || n != 2 {
+		t.Fatalf("RPushCapped = %d (%v), want 2", n, err)
+	}
+	checkList(t, lc, "log", "d", "e")
+
+	// Without a cap given, pushing onto a new list leaves it uncapped
+	lc.db.Delete("log")
+	lc.RPush("log", "a", "b", "c")
+	checkList(t, lc, "log", "a", "b", "c")
+
+	if response := lc.LPushCapped("feed", -1, "v"); response.Success {
+		t.Error("LPushCapped with a negative cap succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// LockCommands implements owner-token locks on top of string keys.
+// Expirations have second granularity, so lock TTLs are rounded up.
+type LockCommands struct {
+	db *core.Database
+}
+
+// NewLockCommands creates a new lock commands handler
+func NewLockCommands(db *core.Database) *LockCommands {
+	return &LockCommands{db: db}
+}
+
+// Acquire takes the lock for owner if nobody holds it
+func (lc *LockCommands) Acquire(key, owner string, ttl time.Duration) *core.Response {
+	if ttl <= 0 {
+		return &core.Response{
+			Success: false,
+			Error:   "lock ttl must be positive",
+			Type:    "lock",
+		}
+	}
+
+	acquired, err := lc.db.SetIfAbsent(key, &core.TriffValue{
+		Type: core.STRING,
+		Data: owner,
+		TTL:  lc.db.Clock().Now().Unix() + lockSeconds(ttl),
+	})
+	if err != nil {
+		return core.Fail("lock", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    acquired,
+		Type:    "boolean",
+	}
+}
+
+// Release deletes the lock only if it is still held by owner
+func (lc *LockCommands) Release(key, owner string) *core.Response {
+	released := lc.db.DeleteIf(key, ownedBy(owner))
+
+	return &core.Response{
+		Success: true,
+		Data:    released,
+		Type:    "boolean",
+	}
+}
+
+// Extend resets the lock TTL only if it is still held by owner
+func (lc *LockCommands) Extend(key, owner string, ttl time.Duration) *core.Response {
+	if ttl <= 0 {
+		return &core.Response{
+			Success: false,
+			Error:   "lock ttl must be positive",
+			Type:    "lock",
+		}
+	}
+
+	extended := lc.db.SetTTLIf(key, lockSeconds(ttl), ownedBy(owner))
+
+	return &core.Response{
+		Success: true,
+		Data:    extended,
+		Type:    "boolean",
+	}
+}
+
+// Owner returns the token of the current lock holder
+func (lc *LockCommands) Owner(key string) *core.Response {
+	value, exists := lc.db.Get(key)
+	if !exists || value.Type != core.STRING {
+		return &core.Response{
+			Success: false,
+			Data:    nil,
+			Type:    "lock",
+		}
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    value.Data,
+		Type:    "string",
+	}
+}
+
+func ownedBy(owner string) func(*core.TriffValue) bool {
+	return func(value *core.TriffValue) bool {
+		token, ok := value.Data.(string)
+		return ok && value.Type == core.STRING && token == owner
+	}
+}
+
+// lockSeconds rounds a lock duration up to whole seconds
+func lockSeconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// PipelineResult is one key read by a pipeline. Type says how to read
+// Value: a string for strings, a field map for hashes, an array of strings
+// for lists and sets, ZSetEntry values in rank order for sorted sets, and
+// the stored structure for other types.
+type PipelineResult struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`  // "none" for a missing key
+	Value interface{} `json:"value"` // nil for a missing key
+	TTL   int64       `json:"ttl"`   // Seconds left, -1 without expiry, -2 for a missing key
+}
+
+// PipelineCommands reads keys of any type in one call, so a client
+// showing several doesn't need a request per key and per type
+type PipelineCommands struct {
+	db *core.Database
+}
+
+// NewPipelineCommands creates a new pipeline commands handler
+func NewPipelineCommands(db *core.Database) *PipelineCommands {
+	return &PipelineCommands{db: db}
+}
+
+// Get reads keys at the same point in time, whatever they hold, and
+// returns a result per key in order (Data []PipelineResult)
+func (pc *PipelineCommands) Get(keys []string) *core.Response {
+	values := pc.db.GetMany(keys)
+	now := pc.db.Clock().Now().Unix()
+
+	results := make([]PipelineResult, len(keys))
+	for i, key := range keys {
+		results[i] = PipelineResult{Key: key, Type: "none", TTL: -2}
+		value := values[i]
+		if value == nil {
+			continue
+		}
+		results[i].Type = value.Type.String()
+		results[i].Value = pipelineValue(value)
+		results[i].TTL = -1
+		if value.TTL > 0 {
+			results[i].TTL = value.TTL - now
+		}
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    results,
+		Type:    "pipeline",
+	}
+}
+
+// pipelineValue converts a value to the form PipelineResult documents for
+// its type
+func pipelineValue(value *core.TriffValue) interface{} {
+	switch value.Type {
+	case core.STRING:
+		if s, ok := value.Data.(string); ok {
+			return s
+		}
+		return fmt.Sprint(value.Data)
+	case core.HASH:
+		return hashFields(value)
+	case core.LIST:
+		if items, ok := core.ListItems(value.Data); ok {
+			return items
+		}
+	case core.SET:
+		switch members := value.Data.(type) {
+		case core.PackedSet:
+			return members.Members()
+		case map[string]struct{}:
+			sorted := make([]string, 0, len(members))
+			for member := range members {
+				sorted = append(sorted, member)
+			}
+			sort.Strings(sorted)
+			return sorted
+		}
+	case core.ZSET:
+		if scores, ok := value.Data.(map[string]float64); ok {
+			return sortZSet(scores)
+		}
+	}
+	return value.Data
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// QueueCommands implements job queue commands
+type QueueCommands struct {
+	db *core.Database
+}
+
+// NewQueueCommands creates a new queue commands handler
+func NewQueueCommands(db *core.Database) *QueueCommands {
+	return &QueueCommands{db: db}
+}
+
+// Enqueue adds a job, visible after delay
+func (qc *QueueCommands) Enqueue(queue, payload string, delay time.Duration, maxAttempts int) *core.Response {
+	if queue == "" {
+		return queueError("queue name is required")
+	}
+	if delay < 0 || maxAttempts < 0 {
+		return queueError("delay and max attempts must not be negative")
+	}
+
+	job := qc.db.Queues().Enqueue(queue, payload, delay, maxAttempts)
+	return &core.Response{
+		Success: true,
+		Data:    job,
+		Type:    "job",
+	}
+}
+
+// Dequeue delivers the next visible job. Data is nil when the queue is empty.
+func (qc *QueueCommands) Dequeue(queue string, visibility time.Duration) *core.Response {
+	job, ok := qc.db.Queues().Dequeue(queue, visibility)
+	if !ok {
+		return &core.Response{
+			Success: true,
+			Data:    nil,
+			Type:    "job",
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    job,
+		Type:    "job",
+	}
+}
+
+// Ack marks a delivered job as done
+func (qc *QueueCommands) Ack(queue, id, receipt string) *core.Response {
+	if err := qc.db.Queues().Ack(queue, id, receipt); err != nil {
+		return core.Fail("job", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    true,
+		Type:    "boolean",
+	}
+}
+
+// Nack returns a delivered job to the queue for a retry after delay
+func (qc *QueueCommands) Nack(queue, id, receipt string, delay time.Duration) *core.Response {
+	if delay < 0 {
+		return queueError("delay must not be negative")
+	}
+	if err := qc.db.Queues().Nack(queue, id, receipt, delay); err != nil {
+		return core.Fail("job", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    true,
+		Type:    "boolean",
+	}
+}
+
+// Stats returns job counts for a queue
+func (qc *QueueCommands) Stats(queue string) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    qc.db.Queues().Stats(queue),
+		Type:    "queue",
+	}
+}
+
+// List returns stats for all queues
+func (qc *QueueCommands) List() *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    qc.db.Queues().List(),
+		Type:    "array",
+	}
+}
+
+// Purge deletes a queue with all its jobs
+func (qc *QueueCommands) Purge(queue string) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    qc.db.Queues().Purge(queue),
+		Type:    "boolean",
+	}
+}
+
+func queueError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "job",
+	}
+}
@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// maxRateLimitRetries bounds the compare-and-set loop under contention
+const maxRateLimitRetries = 100
+
+var errWrongRateLimitType = errs.Newf(errs.ErrWrongType, "key holds a value that is not rate limiter state")
+
+// RateLimitResult is the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed    bool  `json:"allowed"`
+	Limit      int64 `json:"limit"`
+	Remaining  int64 `json:"remaining"`
+	RetryAfter int64 `json:"retry_after"` // Seconds until the request would be allowed, -1 if allowed
+	ResetAfter int64 `json:"reset_after"` // Seconds until the limiter is back to full capacity
+}
+
+// RateLimitCommands implements rate limiters whose state is updated
+// atomically with compare-and-set, so concurrent clients can't race
+type RateLimitCommands struct {
+	db  *core.Database
+	now func() time.Time
+}
+
+// NewRateLimitCommands creates a new rate limit commands handler
+func NewRateLimitCommands(db *core.Database) *RateLimitCommands {
+	return &RateLimitCommands{db: db, now: db.Clock().Now}
+}
+
+// Throttle applies a token bucket using the generic cell rate algorithm,
+// compatible with redis-cell's CL.THROTTLE: up to burst+1 requests at once
+// and rate requests per period on average. quantity is the request cost.
+func (rc *RateLimitCommands) Throttle(key string, burst, rate int64, period time.Duration, quantity int64) *core.Response {
+	if burst < 0 || rate <= 0 || period <= 0 || quantity < 0 {
+		return rateLimitError("burst, rate, period and quantity must be positive")
+	}
+
+	emission := period.Nanoseconds() / rate
+	if emission <= 0 {
+		return rateLimitError("rate is too high for the period")
+	}
+	tolerance := emission * (burst + 1)
+	increment := emission * quantity
+
+	var result RateLimitResult
+	err := rc.update(key, core.STRING, func(value *core.TriffValue) (*core.TriffValue, bool) {
+		now := rc.now().UnixNano()
+		tat := now
+		if value != nil {
+			if stored, err := strconv.ParseInt(stringData(value), 10, 64); err == nil && stored > now {
+				tat = stored
+			}
+		}
+
+		result = RateLimitResult{Limit: burst + 1, RetryAfter: -1}
+		newTat := tat + increment
+		allowAt := newTat - tolerance
+		if now < allowAt {
+			if increment <= tolerance {
+				result.RetryAfter = ceilSeconds(allowAt - now)
+			}
+			result.ResetAfter = ceilSeconds(tat - now)
+			result.Remaining = remainingCells(tolerance-(tat-now), emission)
+			return nil, false
+		}
+
+		result.Allowed = true
+		result.ResetAfter = ceilSeconds(newTat - now)
+		result.Remaining = remainingCells(tolerance-(newTat-now), emission)
+		return &core.TriffValue{
+			Type: core.STRING,
+			Data: strconv.FormatInt(newTat, 10),
+			TTL:  time.Unix(0, newTat).Unix() + 1,
+		}, true
+	})
+	if err != nil {
+		return core.Fail("ratelimit", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    result,
+		Type:    "ratelimit",
+	}
+}
+
+// SlidingWindow allows at most limit units per window, estimating the
+// rolling count from the current and previous fixed windows
+func (rc *RateLimitCommands) SlidingWindow(key string, limit int64, window time.Duration, cost int64) *core.Response {
+	if limit <= 0 || window <= 0 || cost < 0 {
+		return rateLimitError("limit, window and cost must be positive")
+	}
+
+	size := window.Nanoseconds()
+	var result RateLimitResult
+	err := rc.update(key, core.HASH, func(value *core.TriffValue) (*core.TriffValue, bool) {
+		now := rc.now().UnixNano()
+		start := now - now%size
+		var previous, current int64
+
+		if value != nil {
+			fields := hashFields(value)
+			stored, _ := strconv.ParseInt(fields["window"], 10, 64)
+			count, _ := strconv.ParseInt(fields["count"], 10, 64)
+			switch stored {
+			case start:
+				previous, _ = strconv.ParseInt(fields["previous"], 10, 64)
+				current = count
+			case start - size:
+				previous = count
+			}
+		}
+
+		elapsed := now - start
+		weight := float64(size-elapsed) / float64(size)
+		estimate := int64(math.Ceil(float64(previous)*weight)) + current
+
+		result = RateLimitResult{Limit: limit, RetryAfter: -1}
+		result.ResetAfter = ceilSeconds(2*size - elapsed)
+		if previous == 0 {
+			result.ResetAfter = ceilSeconds(size - elapsed)
+		}
+
+		if estimate+cost > limit {
+			result.Remaining = limit - estimate
+			if result.Remaining < 0 {
+				result.Remaining = 0
+			}
+			if cost <= limit {
+				result.RetryAfter = ceilSeconds(slidingRetry(previous, current, cost, limit, size, elapsed))
+			}
+			return nil, false
+		}
+
+		result.Allowed = true
+		result.Remaining = limit - estimate - cost
+		return &core.TriffValue{
+			Type: core.HASH,
+			Data: map[string]string{
+				"window":   strconv.FormatInt(start, 10),
+				"previous": strconv.FormatInt(previous, 10),
+				"count":    strconv.FormatInt(current+cost, 10),
+			},
+			TTL: time.Unix(0, start+2*size).Unix() + 1,
+		}, true
+	})
+	if err != nil {
+		return core.Fail("ratelimit", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    result,
+		Type:    "ratelimit",
+	}
+}
+
+// update runs fn against the current value and stores its result with
+// compare-and-set, retrying when another writer got there first. fn
+// returns false to leave the value untouched.
+func (rc *RateLimitCommands) update(key string, dataType core.DataType, fn func(value *core.TriffValue) (*core.TriffValue, bool)) error {
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var version uint64
+		value, exists := rc.db.Get(key)
+		if exists {
+			if value.Type != dataType {
+				return errWrongRateLimitType
+			}
+			version = value.Version
+		} else {
+			value = nil
+		}
+
+		updated, write := fn(value)
+		if !write {
+			return nil
+		}
+		if _, err := rc.db.CompareAndSet(key, version, updated); err != core.ErrVersionMismatch {
+			return err
+		}
+	}
+	return core.ErrVersionMismatch
+}
+
+// slidingRetry returns how long until the weighted previous window has
+// decayed enough for cost more units, or until the next window starts
+func slidingRetry(previous, current, cost, limit, size, elapsed int64) int64 {
+	available := limit - current - cost
+	if available < 0 || previous == 0 {
+		// Only the next window helps; by then current becomes the previous window
+		next := size - elapsed
+		if current+cost > limit {
+			return next + slidingRetry(current, 0, cost, limit, size, 0)
+		}
+		return next
+	}
+	// previous * (size - t) / size <= available  =>  t >= size * (1 - available/previous)
+	needed := int64(math.Ceil(float64(size) * (1 - float64(available)/float64(previous))))
+	if needed <= elapsed {
+		return 0
+	}
+	return needed - elapsed
+}
+
+// remainingCells converts spare tolerance into a number of requests
+func remainingCells(spare, emission int64) int64 {
+	if spare < 0 {
+		return 0
+	}
+	return spare / emission
+}
+
+func ceilSeconds(nanos int64) int64 {
+	if nanos <= 0 {
+		return 0
+	}
+	return (nanos + int64(time.Second) - 1) / int64(time.Second)
+}
+
+func stringData(value *core.TriffValue) string {
+	s, _ := value.Data.(string)
+	return s
+}
+
+func rateLimitError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "ratelimit",
+	}
+}
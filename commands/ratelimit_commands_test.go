@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// TestThrottleFollowsDatabaseClock checks that the limiter refills as the
+// database's clock moves, not the real time
+func TestThrottleFollowsDatabaseClock(t *testing.T) {
+	db, clock := newTestDatabase()
+	rc := NewRateLimitCommands(db)
+	throttle := func() RateLimitResult {
+		t.Helper()
+		result, err := core.Result[RateLimitResult](rc.Throttle("api", 0, 1, time.Minute, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if !throttle().Allowed {
+		t.Fatal("first request refused")
+	}
+	if result := throttle(); result.Allowed || result.RetryAfter != 60 {
+		t.Fatalf("second request = %+v, want refused for 60s", result)
+	}
+	clock.Advance(time.Minute)
+	if !throttle().Allowed {
+		t.Fatal("request refused after the period passed on the clock")
+	}
+}
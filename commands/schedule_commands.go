@@ -0,0 +1,366 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Scheduled tasks are kept in one hash of task ID to JSON under
+// scheduleKey, so they are saved and restored with the dataset and every
+// change to them is a single atomic update
+const scheduleKey = "schedule:tasks"
+
+var (
+	errTaskNotFound    = errs.Newf(errs.ErrNotFound, "scheduled task not found")
+	errWrongTaskType   = errs.Newf(errs.ErrWrongType, "%s holds a value that is not a task list", scheduleKey)
+	errScheduleCommand = errors.New("a task needs a command")
+	errScheduleID      = errors.New("a task needs an ID")
+)
+
+// ScheduledTask is a command to run at a set time, once or on a cron
+// schedule
+type ScheduledTask struct {
+	ID      string   `json:"id"`
+	Command []string `json:"command"`        // Command and arguments, e.g. ["LBRESET", "weekly"]
+	Cron    string   `json:"cron,omitempty"` // Empty for a task that runs once
+	Next    int64    `json:"next"`           // Unix seconds of the next run
+	Runs    int64    `json:"runs"`
+	LastRun int64    `json:"last_run,omitempty"`
+}
+
+// ScheduleCommands manages scheduled tasks. Running them is up to the
+// server, which claims due tasks with Due.
+type ScheduleCommands struct {
+	db *core.Database
+}
+
+// NewScheduleCommands creates a new schedule commands handler
+func NewScheduleCommands(db *core.Database) *ScheduleCommands {
+	return &ScheduleCommands{db: db}
+}
+
+// At schedules command to run once at the given time, replacing any task
+// with the same ID. A time in the past runs at the next check.
+func (sc *ScheduleCommands) At(id string, at time.Time, command []string) *core.Response {
+	return sc.add(ScheduledTask{ID: id, Command: command, Next: at.Unix()})
+}
+
+// Cron schedules command to run on a cron expression, evaluated in UTC,
+// replacing any task with the same ID
+func (sc *ScheduleCommands) Cron(id, expr string, command []string) *core.Response {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return core.Fail("schedule", err)
+	}
+	next, ok := schedule.Next(sc.db.Clock().Now())
+	if !ok {
+		return core.Fail("schedule", fmt.Errorf("cron expression %q never matches", expr))
+	}
+	return sc.add(ScheduledTask{ID: id, Command: command, Cron: expr, Next: next.Unix()})
+}
+
+func (sc *ScheduleCommands) add(task ScheduledTask) *core.Response {
+	if task.ID == "" {
+		return core.Fail("schedule", errScheduleID)
+	}
+	if len(task.Command) == 0 || task.Command[0] == "" {
+		return core.Fail("schedule", errScheduleCommand)
+	}
+	err := sc.update(func(tasks map[string]ScheduledTask) bool {
+		tasks[task.ID] = task
+		return true
+	})
+	if err != nil {
+		return core.Fail("schedule", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    task,
+		Type:    "schedule",
+	}
+}
+
+// Remove cancels a task
+func (sc *ScheduleCommands) Remove(id string) *core.Response {
+	removed := false
+	err := sc.update(func(tasks map[string]ScheduledTask) bool {
+		_, removed = tasks[id]
+		delete(tasks, id)
+		return removed
+	})
+	if err != nil {
+		return core.Fail("schedule", err)
+	}
+	if !removed {
+		return core.Fail("schedule", errTaskNotFound)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    true,
+		Type:    "boolean",
+	}
+}
+
+// List returns the scheduled tasks, the next to run first
+func (sc *ScheduleCommands) List() *core.Response {
+	value, exists := sc.db.Get(scheduleKey)
+	var tasks map[string]ScheduledTask
+	if exists {
+		var err error
+		if tasks, err = decodeTasks(value); err != nil {
+			return core.Fail("schedule", err)
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    sortTasks(tasks),
+		Type:    "array",
+	}
+}
+
+// Due claims the tasks due at now, moving recurring ones to their next run
+// and removing the others, so each run is handed out once even with
+// several servers on the database
+func (sc *ScheduleCommands) Due(now time.Time) ([]ScheduledTask, error) {
+	var due []ScheduledTask
+	err := sc.update(func(tasks map[string]ScheduledTask) bool {
+		for id, task := range tasks {
+			if task.Next > now.Unix() {
+				continue
+			}
+			task.Runs++
+			task.LastRun = now.Unix()
+			due = append(due, task)
+
+			next, ok := time.Time{}, false
+			if schedule, err := ParseCron(task.Cron); task.Cron != "" && err == nil {
+				next, ok = schedule.Next(now)
+			}
+			if !ok {
+				delete(tasks, id)
+				continue
+			}
+			task.Next = next.Unix()
+			tasks[id] = task
+		}
+		return len(due) > 0
+	})
+	sort.Slice(due, func(i, j int) bool { return due[i].Next < due[j].Next })
+	return due, err
+}
+
+// update applies fn to the tasks under the write lock, storing them if
+// fn reports a change
+func (sc *ScheduleCommands) update(fn func(tasks map[string]ScheduledTask) bool) error {
+	return sc.db.Update(scheduleKey, func(old *core.TriffValue) (*core.TriffValue, error) {
+		tasks := make(map[string]ScheduledTask)
+		if old != nil {
+			var err error
+			if tasks, err = decodeTasks(old); err != nil {
+				return nil, err
+			}
+		}
+		if !fn(tasks) {
+			return old, nil
+		}
+		if len(tasks) == 0 {
+			return nil, nil
+		}
+		fields := make(map[string]string, len(tasks))
+		for id, task := range tasks {
+			encoded, err := json.Marshal(task)
+			if err != nil {
+				return nil, err
+			}
+			fields[id] = string(encoded)
+		}
+		return &core.TriffValue{Type: core.HASH, Data: fields}, nil
+	})
+}
+
+func decodeTasks(value *core.TriffValue) (map[string]ScheduledTask, error) {
+	fields, ok := core.StringMap(value.Data)
+	if value.Type != core.HASH || !ok {
+		return nil, errWrongTaskType
+	}
+	tasks := make(map[string]ScheduledTask, len(fields))
+	for id, encoded := range fields {
+		var task ScheduledTask
+		if err := json.Unmarshal([]byte(encoded), &task); err != nil {
+			return nil, fmt.Errorf("scheduled task %s: %w", id, err)
+		}
+		tasks[id] = task
+	}
+	return tasks, nil
+}
+
+func sortTasks(tasks map[string]ScheduledTask) []ScheduledTask {
+	list := make([]ScheduledTask, 0, len(tasks))
+	for _, task := range tasks {
+		list = append(list, task)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Next != list[j].Next {
+			return list[i].Next < list[j].Next
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// CronSchedule is a parsed cron expression: five fields for minute, hour,
+// day of month, month and day of week, or a macro such as @daily or
+// @every 15m
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit n set when value n matches
+	anyDOM, anyDOW                bool
+	every                         time.Duration
+}
+
+// cronMacros are the shorthands for common schedules
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression. Fields take *, values, ranges
+// (1-5), steps (*/15, 0-30/10) and comma-separated lists of them. Day of
+// week runs from 0 (Sunday) to 6, with 7 also meaning Sunday. As in
+// cron, a task whose day of month and day of week are both restricted
+// runs on days matching either.
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid cron interval %q (must be a duration of at least 1s)", rest)
+		}
+		return &CronSchedule{every: every}, nil
+	}
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q (must have 5 fields)", expr)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &CronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDOM: fields[2] == "*",
+		anyDOW: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one field into a bit set of the values it matches
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			var err error
+			if i := strings.IndexByte(rangePart, '-'); i >= 0 {
+				lo, err = strconv.Atoi(rangePart[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(rangePart[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(rangePart)
+				hi = lo
+				if step > 1 {
+					hi = max
+				}
+			}
+			if err != nil || lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// cronHorizon bounds the search for the next run, so an expression that
+// names a date that never comes, like February 30, gives up
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t that the schedule matches, in UTC.
+// It reports false if nothing matches within five years.
+func (c *CronSchedule) Next(t time.Time) (time.Time, bool) {
+	t = t.UTC()
+	if c.every > 0 {
+		return t.Truncate(time.Second).Add(c.every), true
+	}
+
+	limit := t.Add(cronHorizon)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"github.com/nitrix4ly/triff/core"
+)
+
+// SearchCommands handles full-text index management and queries
+type SearchCommands struct {
+	db *core.Database
+}
+
+// NewSearchCommands creates a new search commands handler
+func NewSearchCommands(db *core.Database) *SearchCommands {
+	return &SearchCommands{db: db}
+}
+
+// SearchResult is the payload of a successful search
+type SearchResult struct {
+	Total int              `json:"total"`
+	Hits  []core.SearchHit `json:"hits"`
+}
+
+// Create declares a full-text index over keys matching pattern
+func (sc *SearchCommands) Create(name, pattern string, fields []string, stem bool) *core.Response {
+	if err := sc.db.CreateSearchIndex(name, pattern, fields, stem); err != nil {
+		return core.Fail("search", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// Drop removes a full-text index
+func (sc *SearchCommands) Drop(name string) *core.Response {
+	if !sc.db.DropSearchIndex(name) {
+		return core.Fail("search", core.ErrSearchIndexNotFound)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// List returns all full-text indexes
+func (sc *SearchCommands) List() *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    sc.db.ListSearchIndexes(),
+		Type:    "array",
+	}
+}
+
+// Search returns keys matching every term of query. A negative limit returns all hits.
+func (sc *SearchCommands) Search(name, query string, offset, limit int) *core.Response {
+	if offset < 0 {
+		offset = 0
+	}
+
+	hits, total, err := sc.db.Search(name, query, offset, limit)
+	if err != nil {
+		return core.Fail("search", err)
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    SearchResult{Total: total, Hits: hits},
+		Type:    "search",
+	}
+}
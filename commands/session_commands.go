@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Session keys are hashes named SessionKeyPrefix+id. Fields starting with
+// sessionMetaPrefix hold metadata; all other fields are application data.
+const (
+	SessionKeyPrefix  = "session:"
+	sessionIndex      = "sessions:user"
+	sessionMetaPrefix = "__"
+	sessionUserField  = "__user_id"
+	sessionCreated    = "__created_at"
+)
+
+var errSessionNotFound = errs.Newf(errs.ErrNotFound, "session not found")
+
+// Session is the API view of a stored session
+type Session struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id,omitempty"`
+	Data      map[string]string `json:"data"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at,omitempty"`
+}
+
+// SessionCommands implements web sessions on top of hashes with a TTL
+type SessionCommands struct {
+	db *core.Database
+}
+
+// NewSessionCommands creates a new session commands handler and makes sure
+// the per-user session index exists
+func NewSessionCommands(db *core.Database) *SessionCommands {
+	db.CreateIndex(sessionIndex, SessionKeyPrefix+"*", sessionUserField)
+	return &SessionCommands{db: db}
+}
+
+// Create stores a new session with a random ID
+func (sc *SessionCommands) Create(userID string, data map[string]string, ttl int64) *core.Response {
+	if ttl <= 0 {
+		return sessionError("session ttl must be positive")
+	}
+	if err := checkSessionData(data); err != "" {
+		return sessionError(err)
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return core.Fail("session", err)
+	}
+
+	now := sc.db.Clock().Now()
+	fields := map[string]string{
+		sessionCreated: strconv.FormatInt(now.Unix(), 10),
+	}
+	if userID != "" {
+		fields[sessionUserField] = userID
+	}
+	for field, value := range data {
+		fields[field] = value
+	}
+
+	created, err := sc.db.SetIfAbsent(SessionKeyPrefix+id, &core.TriffValue{
+		Type: core.HASH,
+		Data: fields,
+		TTL:  now.Unix() + ttl,
+	})
+	if err != nil {
+		return core.Fail("session", err)
+	}
+	if !created {
+		return sessionError("session id collision, retry")
+	}
+
+	session, _ := sc.load(id)
+	return &core.Response{
+		Success: true,
+		Data:    session,
+		Type:    "session",
+	}
+}
+
+// Get returns a session
+func (sc *SessionCommands) Get(id string) *core.Response {
+	session, exists := sc.load(id)
+	if !exists {
+		return core.Fail("session", errSessionNotFound)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    session,
+		Type:    "session",
+	}
+}
+
+// Save replaces the data of an existing session, keeping its owner and expiry
+func (sc *SessionCommands) Save(id string, data map[string]string) *core.Response {
+	if err := checkSessionData(data); err != "" {
+		return sessionError(err)
+	}
+
+	key := SessionKeyPrefix + id
+	existing, exists := sc.db.Get(key)
+	if !exists || existing.Type != core.HASH {
+		return core.Fail("session", errSessionNotFound)
+	}
+
+	fields := make(map[string]string)
+	for field, value := range hashFields(existing) {
+		if strings.HasPrefix(field, sessionMetaPrefix) {
+			fields[field] = value
+		}
+	}
+	for field, value := range data {
+		fields[field] = value
+	}
+
+	updated, err := sc.db.SetIfExists(key, &core.TriffValue{
+		Type: core.HASH,
+		Data: fields,
+		TTL:  existing.TTL,
+	})
+	if err != nil {
+		return core.Fail("session", err)
+	}
+	if !updated {
+		return core.Fail("session", errSessionNotFound)
+	}
+	return sc.Get(id)
+}
+
+// Touch extends a session to expire ttl seconds from now
+func (sc *SessionCommands) Touch(id string, ttl int64) *core.Response {
+	if ttl <= 0 {
+		return sessionError("session ttl must be positive")
+	}
+	touched := sc.db.SetTTLIf(SessionKeyPrefix+id, ttl, func(value *core.TriffValue) bool {
+		return value.Type == core.HASH
+	})
+	if !touched {
+		return core.Fail("session", errSessionNotFound)
+	}
+	return sc.Get(id)
+}
+
+// Destroy deletes a session
+func (sc *SessionCommands) Destroy(id string) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    sc.db.Delete(SessionKeyPrefix + id),
+		Type:    "boolean",
+	}
+}
+
+// ListByUser returns all live sessions of a user
+func (sc *SessionCommands) ListByUser(userID string) *core.Response {
+	keys, err := sc.db.FindEqual(sessionIndex, userID)
+	if err == core.ErrIndexNotFound {
+		// Someone dropped the index; recreate and backfill it
+		sc.db.CreateIndex(sessionIndex, SessionKeyPrefix+"*", sessionUserField)
+		keys, err = sc.db.FindEqual(sessionIndex, userID)
+	}
+	if err != nil {
+		return core.Fail("session", err)
+	}
+
+	sessions := make([]*Session, 0, len(keys))
+	for _, key := range keys {
+		if session, exists := sc.load(strings.TrimPrefix(key, SessionKeyPrefix)); exists {
+			sessions = append(sessions, session)
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    sessions,
+		Type:    "array",
+	}
+}
+
+// load reads a session hash into its API form
+func (sc *SessionCommands) load(id string) (*Session, bool) {
+	value, exists := sc.db.Get(SessionKeyPrefix + id)
+	if !exists || value.Type != core.HASH {
+		return nil, false
+	}
+
+	fields := hashFields(value)
+	session := &Session{
+		ID:     id,
+		UserID: fields[sessionUserField],
+		Data:   make(map[string]string),
+	}
+	if created, err := strconv.ParseInt(fields[sessionCreated], 10, 64); err == nil {
+		session.CreatedAt = time.Unix(created, 0)
+	}
+	if value.TTL > 0 {
+		session.ExpiresAt = time.Unix(value.TTL, 0)
+	}
+	for field, v := range fields {
+		if !strings.HasPrefix(field, sessionMetaPrefix) {
+			session.Data[field] = v
+		}
+	}
+	return session, true
+}
+
+// checkSessionData rejects fields that would collide with metadata
+func checkSessionData(data map[string]string) string {
+	for field := range data {
+		if strings.HasPrefix(field, sessionMetaPrefix) {
+			return "session data fields may not start with " + sessionMetaPrefix
+		}
+	}
+	return ""
+}
+
+// newSessionID returns 128 random bits, hex encoded
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func sessionError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "session",
+	}
+}
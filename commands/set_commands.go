@@ -1,61 +1,319 @@
 package commands
 
 import (
-	"errors"
-	"sync"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
 )
 
-type SetStore struct {
-	data map[string]map[string]struct{}
-	mu   sync.RWMutex
+var errNotSet = errs.Newf(errs.ErrWrongType, "value is not a set")
+
+// SetCommands handles set operations backed by the database
+type SetCommands struct {
+	db *core.Database
+}
+
+// NewSetCommands creates a new set commands handler
+func NewSetCommands(db *core.Database) *SetCommands {
+	return &SetCommands{db: db}
+}
+
+// SAdd adds members to a set, creating the set if needed, and returns how
+// many weren't in it yet
+func (sc *SetCommands) SAdd(key string, members ...string) *core.Response {
+	added := 0
+	err := sc.update(key, func(set map[string]struct{}) bool {
+		for _, member := range members {
+			if _, exists := set[member]; !exists {
+				set[member] = struct{}{}
+				added++
+			}
+		}
+		return added > 0
+	})
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	return setCount(added)
+}
+
+// SRem removes members from a set and returns how many it had, deleting
+// the key once the set is empty
+func (sc *SetCommands) SRem(key string, members ...string) *core.Response {
+	removed := 0
+	err := sc.update(key, func(set map[string]struct{}) bool {
+		for _, member := range members {
+			if _, exists := set[member]; exists {
+				delete(set, member)
+				removed++
+			}
+		}
+		return removed > 0
+	})
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	return setCount(removed)
+}
+
+// SMembers returns the members of a set, sorted (Data []string)
+func (sc *SetCommands) SMembers(key string) *core.Response {
+	set, err := sc.members(key)
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return &core.Response{
+		Success: true,
+		Data:    members,
+		Type:    "array",
+	}
+}
+
+// SCard returns the number of members of a set, 0 for a missing key
+func (sc *SetCommands) SCard(key string) *core.Response {
+	set, err := sc.members(key)
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	return setCount(len(set))
 }
 
-func NewSetStore() *SetStore {
-	return &SetStore{
-		data: make(map[string]map[string]struct{}),
+// SMIsMember reports for each of members whether it is in the set (Data
+// []bool). SISMEMBER is the single member case.
+func (sc *SetCommands) SMIsMember(key string, members ...string) *core.Response {
+	set, err := sc.members(key)
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	found := make([]bool, len(members))
+	for i, member := range members {
+		_, found[i] = set[member]
+	}
+	return &core.Response{
+		Success: true,
+		Data:    found,
+		Type:    "array",
 	}
 }
 
-func (ss *SetStore) SAdd(key, value string) {
-	ss.mu.Lock()
-	defer ss.mu.Unlock()
-	if _, exists := ss.data[key]; !exists {
-		ss.data[key] = make(map[string]struct{})
+// SRandMember returns random members without removing them, like Redis's
+// SRANDMEMBER: up to count distinct members for a positive count, or
+// exactly -count members that may repeat for a negative one (Data
+// []string)
+func (sc *SetCommands) SRandMember(key string, count int) *core.Response {
+	set, err := sc.members(key)
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	members := shuffledMembers(set)
+	var picked []string
+	if count >= 0 {
+		if count < len(members) {
+			members = members[:count]
+		}
+		picked = members
+	} else {
+		picked = make([]string, 0, -count)
+		for i := 0; i < -count && len(members) > 0; i++ {
+			picked = append(picked, members[rand.Intn(len(members))])
+		}
+	}
+	return &core.Response{
+		Success: true,
+		Data:    picked,
+		Type:    "array",
 	}
-	ss.data[key][value] = struct{}{}
 }
 
-func (ss *SetStore) SRem(key, value string) error {
-	ss.mu.Lock()
-	defer ss.mu.Unlock()
-	if set, exists := ss.data[key]; exists {
-		if _, found := set[value]; found {
-			delete(set, value)
+// SPop removes and returns up to count random members (Data []string),
+// deleting the key once the set is empty
+func (sc *SetCommands) SPop(key string, count int) *core.Response {
+	if count < 0 {
+		return core.Fail("set", fmt.Errorf("count can't be negative"))
+	}
+	popped := []string{}
+	err := sc.update(key, func(set map[string]struct{}) bool {
+		members := shuffledMembers(set)
+		if count < len(members) {
+			members = members[:count]
+		}
+		for _, member := range members {
+			delete(set, member)
+		}
+		popped = members
+		return len(popped) > 0
+	})
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    popped,
+		Type:    "array",
+	}
+}
+
+// SMove moves member from the source set to the destination set in one
+// step, so no reader sees it in both or neither (Data bool, false if
+// member isn't in the source set). Both keys must hold sets or be missing.
+func (sc *SetCommands) SMove(source, destination, member string) *core.Response {
+	moved := false
+	err := sc.db.UpdateKeys([]string{source, destination}, func(values map[string]*core.TriffValue) error {
+		from, err := setOf(values[source])
+		if err != nil {
+			return err
+		}
+		to, err := setOf(values[destination])
+		if err != nil {
+			return err
+		}
+		if _, exists := from[member]; !exists || source == destination {
+			moved = exists
 			return nil
 		}
+		delete(from, member)
+		to[member] = struct{}{}
+		values[source] = setValue(values[source], from)
+		values[destination] = setValue(values[destination], to)
+		moved = true
+		return nil
+	})
+	if err != nil {
+		return core.Fail("set", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    moved,
+		Type:    "boolean",
+	}
+}
+
+// SInterCard returns the size of the intersection of the sets at keys,
+// read at the same point in time, stopping early once it reaches limit (0
+// for no limit). A missing key is an empty set.
+func (sc *SetCommands) SInterCard(limit int, keys ...string) *core.Response {
+	if limit < 0 {
+		return core.Fail("set", fmt.Errorf("LIMIT can't be negative"))
+	}
+	if len(keys) == 0 {
+		return core.Fail("set", fmt.Errorf("at least 1 key is needed"))
+	}
+	values := sc.db.GetMany(keys)
+	sets := make([]map[string]struct{}, len(values))
+	for i, value := range values {
+		if value == nil {
+			return setCount(0)
+		}
+		set, ok := core.SetMembers(value.Data)
+		if value.Type != core.SET || !ok {
+			return core.Fail("set", errNotSet)
+		}
+		sets[i] = set
+	}
+
+	// Walk the smallest set and look its members up in the others
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	count := 0
+	for member := range sets[0] {
+		inAll := true
+		for _, set := range sets[1:] {
+			if _, ok := set[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			count++
+			if count == limit {
+				break
+			}
+		}
 	}
-	return errors.New("value not found in set")
+	return setCount(count)
 }
 
-func (ss *SetStore) SMembers(key string) ([]string, error) {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
-	if set, exists := ss.data[key]; exists {
-		members := []string{}
-		for val := range set {
-			members = append(members, val)
+// update applies fn to a copy of a set's members, a missing key being an
+// empty set, and stores the result if fn reports a change. An emptied set
+// deletes the key.
+func (sc *SetCommands) update(key string, fn func(set map[string]struct{}) bool) error {
+	return sc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		set, err := setOf(old)
+		if err != nil {
+			return nil, err
+		}
+		if !fn(set) {
+			return old, nil
 		}
-		return members, nil
+		return setValue(old, set), nil
+	})
+}
+
+// members returns the members of a set, empty if the key is missing
+func (sc *SetCommands) members(key string) (map[string]struct{}, error) {
+	value, exists := sc.db.Get(key)
+	if !exists {
+		return map[string]struct{}{}, nil
+	}
+	set, ok := core.SetMembers(value.Data)
+	if value.Type != core.SET || !ok {
+		return nil, errNotSet
+	}
+	return set, nil
+}
+
+// setOf copies the members of a staged value for modifying, empty for a
+// missing key
+func setOf(value *core.TriffValue) (map[string]struct{}, error) {
+	if value == nil {
+		return make(map[string]struct{}), nil
+	}
+	members, ok := core.SetMembers(value.Data)
+	if value.Type != core.SET || !ok {
+		return nil, errNotSet
+	}
+	set := make(map[string]struct{}, len(members))
+	for member := range members {
+		set[member] = struct{}{}
+	}
+	return set, nil
+}
+
+// setValue returns the value storing set in place of old, keeping its
+// expiry, or nil to delete the key once the set is empty. The database
+// packs small sets as they are stored.
+func setValue(old *core.TriffValue, set map[string]struct{}) *core.TriffValue {
+	if len(set) == 0 {
+		return nil
+	}
+	var ttl int64
+	if old != nil {
+		ttl = old.TTL
+	}
+	return &core.TriffValue{Type: core.SET, Data: set, TTL: ttl}
+}
+
+// shuffledMembers returns a set's members in random order
+func shuffledMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
 	}
-	return nil, errors.New("key not found")
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	return members
 }
 
-func (ss *SetStore) SExists(key, value string) bool {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
-	if set, exists := ss.data[key]; exists {
-		_, found := set[value]
-		return found
+func setCount(n int) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    n,
+		Type:    "integer",
 	}
-	return false
 }
@@ -0,0 +1,347 @@
+package commands
+
+import (
+	"errors"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	errWrongBloomType  = errs.Newf(errs.ErrWrongType, "key holds a value that is not a bloom filter")
+	errWrongCMSType    = errs.Newf(errs.ErrWrongType, "key holds a value that is not a count-min sketch")
+	errWrongCuckooType = errs.Newf(errs.ErrWrongType, "key holds a value that is not a cuckoo filter")
+	errSketchExists    = errors.New("item exists")
+	errSketchMissing   = errs.Newf(errs.ErrNotFound, "key does not exist")
+
+	// errSketchUnchanged lets an update function skip the write
+	errSketchUnchanged = errors.New("sketch unchanged")
+)
+
+// SketchCommands implements probabilistic data structures: Bloom and cuckoo
+// filters for membership and Count-Min sketches for frequencies. Stored structures
+// are never mutated; updates clone them and compare-and-set the copy.
+type SketchCommands struct {
+	db *core.Database
+}
+
+// NewSketchCommands creates a new sketch commands handler
+func NewSketchCommands(db *core.Database) *SketchCommands {
+	return &SketchCommands{db: db}
+}
+
+// BFReserve creates an empty Bloom filter (BF.RESERVE)
+func (sc *SketchCommands) BFReserve(key string, errorRate float64, capacity uint64) *core.Response {
+	filter, err := core.NewBloomFilter(errorRate, capacity)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	created, err := sc.db.SetIfAbsent(key, &core.TriffValue{Type: core.BLOOM, Data: filter})
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	if !created {
+		return core.Fail("sketch", errSketchExists)
+	}
+	return sketchSuccess(true)
+}
+
+// BFAdd adds items to a filter, creating it with default parameters if
+// needed. The data holds one bool per item, true if it was newly added.
+func (sc *SketchCommands) BFAdd(key string, items ...string) *core.Response {
+	var added []bool
+	err := sc.updateSketch(key, core.BLOOM, func(value *core.TriffValue) (*core.TriffValue, error) {
+		var filter *core.BloomFilter
+		if value == nil {
+			filter, _ = core.NewBloomFilter(core.DefaultBloomErrorRate, core.DefaultBloomCapacity)
+		} else {
+			filter = value.Data.(*core.BloomFilter).Clone()
+		}
+
+		added = make([]bool, len(items))
+		for i, item := range items {
+			added[i] = filter.Add(item)
+		}
+		return &core.TriffValue{Type: core.BLOOM, Data: filter}, nil
+	})
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	return sketchSuccess(added)
+}
+
+// BFExists checks items against a filter; a missing filter contains nothing
+func (sc *SketchCommands) BFExists(key string, items ...string) *core.Response {
+	exists := make([]bool, len(items))
+	value, found := sc.db.Get(key)
+	if !found {
+		return sketchSuccess(exists)
+	}
+	filter, ok := value.Data.(*core.BloomFilter)
+	if value.Type != core.BLOOM || !ok {
+		return core.Fail("sketch", errWrongBloomType)
+	}
+	for i, item := range items {
+		exists[i] = filter.Exists(item)
+	}
+	return sketchSuccess(exists)
+}
+
+// BFInfo returns a filter's parameters and fill
+func (sc *SketchCommands) BFInfo(key string) *core.Response {
+	value, found := sc.db.Get(key)
+	if !found {
+		return core.Fail("sketch", errSketchMissing)
+	}
+	filter, ok := value.Data.(*core.BloomFilter)
+	if value.Type != core.BLOOM || !ok {
+		return core.Fail("sketch", errWrongBloomType)
+	}
+	return sketchSuccess(map[string]interface{}{
+		"capacity":   filter.Capacity,
+		"error_rate": filter.ErrorRate,
+		"hashes":     filter.Hashes,
+		"size":       filter.Size,
+		"items":      filter.Items,
+	})
+}
+
+// CMSInitByDim creates an empty sketch with explicit dimensions (CMS.INITBYDIM)
+func (sc *SketchCommands) CMSInitByDim(key string, width, depth uint32) *core.Response {
+	sketch, err := core.NewCountMinSketch(width, depth)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	return sc.cmsInit(key, sketch)
+}
+
+// CMSInitByProb creates an empty sketch sized for an error rate and the
+// probability of exceeding it (CMS.INITBYPROB)
+func (sc *SketchCommands) CMSInitByProb(key string, errorRate, probability float64) *core.Response {
+	sketch, err := core.NewCountMinSketchForError(errorRate, probability)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	return sc.cmsInit(key, sketch)
+}
+
+func (sc *SketchCommands) cmsInit(key string, sketch *core.CountMinSketch) *core.Response {
+	created, err := sc.db.SetIfAbsent(key, &core.TriffValue{Type: core.CMS, Data: sketch})
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	if !created {
+		return core.Fail("sketch", errSketchExists)
+	}
+	return sketchSuccess(true)
+}
+
+// CMSIncrBy adds to the counts of items in an existing sketch and returns
+// their new estimates
+func (sc *SketchCommands) CMSIncrBy(key string, items []string, increments []uint64) *core.Response {
+	if len(items) != len(increments) {
+		return sketchError("every item needs an increment")
+	}
+
+	var estimates []uint64
+	err := sc.updateSketch(key, core.CMS, func(value *core.TriffValue) (*core.TriffValue, error) {
+		if value == nil {
+			return nil, errSketchMissing
+		}
+		sketch := value.Data.(*core.CountMinSketch).Clone()
+		estimates = make([]uint64, len(items))
+		for i, item := range items {
+			estimates[i] = sketch.IncrBy(item, increments[i])
+		}
+		return &core.TriffValue{Type: core.CMS, Data: sketch}, nil
+	})
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	return sketchSuccess(estimates)
+}
+
+// CMSQuery returns the estimated counts of items
+func (sc *SketchCommands) CMSQuery(key string, items ...string) *core.Response {
+	value, found := sc.db.Get(key)
+	if !found {
+		return core.Fail("sketch", errSketchMissing)
+	}
+	sketch, ok := value.Data.(*core.CountMinSketch)
+	if value.Type != core.CMS || !ok {
+		return core.Fail("sketch", errWrongCMSType)
+	}
+	counts := make([]uint64, len(items))
+	for i, item := range items {
+		counts[i] = sketch.Query(item)
+	}
+	return sketchSuccess(counts)
+}
+
+// CFReserve creates an empty cuckoo filter (CF.RESERVE)
+func (sc *SketchCommands) CFReserve(key string, capacity uint64) *core.Response {
+	filter, err := core.NewCuckooFilter(capacity)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	created, err := sc.db.SetIfAbsent(key, &core.TriffValue{Type: core.CUCKOO, Data: filter})
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	if !created {
+		return core.Fail("sketch", errSketchExists)
+	}
+	return sketchSuccess(true)
+}
+
+// CFAdd adds an item to a cuckoo filter, creating it with the default
+// capacity if needed. With nx set the item is only added if it doesn't
+// already appear to be present, and the data reports whether it was added.
+func (sc *SketchCommands) CFAdd(key, item string, nx bool) *core.Response {
+	added := false
+	err := sc.updateSketch(key, core.CUCKOO, func(value *core.TriffValue) (*core.TriffValue, error) {
+		var filter *core.CuckooFilter
+		if value == nil {
+			filter, _ = core.NewCuckooFilter(core.DefaultCuckooCapacity)
+		} else {
+			if nx && value.Data.(*core.CuckooFilter).Exists(item) {
+				return nil, errSketchUnchanged
+			}
+			filter = value.Data.(*core.CuckooFilter).Clone()
+		}
+
+		if err := filter.Add(item); err != nil {
+			return nil, err
+		}
+		added = true
+		return &core.TriffValue{Type: core.CUCKOO, Data: filter}, nil
+	})
+	if err != nil && err != errSketchUnchanged {
+		return core.Fail("sketch", err)
+	}
+	return sketchSuccess(added)
+}
+
+// CFDel removes one copy of an item, reporting whether it was found
+func (sc *SketchCommands) CFDel(key, item string) *core.Response {
+	deleted := false
+	err := sc.updateSketch(key, core.CUCKOO, func(value *core.TriffValue) (*core.TriffValue, error) {
+		if value == nil {
+			return nil, errSketchMissing
+		}
+		if !value.Data.(*core.CuckooFilter).Exists(item) {
+			return nil, errSketchUnchanged
+		}
+		filter := value.Data.(*core.CuckooFilter).Clone()
+		deleted = filter.Delete(item)
+		return &core.TriffValue{Type: core.CUCKOO, Data: filter}, nil
+	})
+	if err != nil && err != errSketchUnchanged {
+		return core.Fail("sketch", err)
+	}
+	return sketchSuccess(deleted)
+}
+
+// CFExists checks items against a cuckoo filter
+func (sc *SketchCommands) CFExists(key string, items ...string) *core.Response {
+	exists := make([]bool, len(items))
+	filter, err := sc.cuckoo(key)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	if filter != nil {
+		for i, item := range items {
+			exists[i] = filter.Exists(item)
+		}
+	}
+	return sketchSuccess(exists)
+}
+
+// CFCount returns how many copies of an item a cuckoo filter may hold
+func (sc *SketchCommands) CFCount(key, item string) *core.Response {
+	filter, err := sc.cuckoo(key)
+	if err != nil {
+		return core.Fail("sketch", err)
+	}
+	count := 0
+	if filter != nil {
+		count = filter.Count(item)
+	}
+	return sketchSuccess(count)
+}
+
+// cuckoo loads a cuckoo filter, returning nil if the key doesn't exist
+func (sc *SketchCommands) cuckoo(key string) (*core.CuckooFilter, error) {
+	value, found := sc.db.Get(key)
+	if !found {
+		return nil, nil
+	}
+	if err := checkSketchType(value, core.CUCKOO); err != nil {
+		return nil, err
+	}
+	return value.Data.(*core.CuckooFilter), nil
+}
+
+// updateSketch runs a copy-on-write update in a compare-and-set loop. fn
+// receives nil when the key doesn't exist and must not modify value.
+func (sc *SketchCommands) updateSketch(key string, dataType core.DataType, fn func(value *core.TriffValue) (*core.TriffValue, error)) error {
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var version uint64
+		value, exists := sc.db.Get(key)
+		if exists {
+			if err := checkSketchType(value, dataType); err != nil {
+				return err
+			}
+			version = value.Version
+		} else {
+			value = nil
+		}
+
+		updated, err := fn(value)
+		if err != nil {
+			return err
+		}
+		if value != nil && updated.TTL == 0 {
+			updated.TTL = value.TTL
+		}
+		if _, err := sc.db.CompareAndSet(key, version, updated); err != core.ErrVersionMismatch {
+			return err
+		}
+	}
+	return core.ErrVersionMismatch
+}
+
+// checkSketchType verifies that a stored value holds the expected sketch
+func checkSketchType(value *core.TriffValue, dataType core.DataType) error {
+	switch dataType {
+	case core.BLOOM:
+		if _, ok := value.Data.(*core.BloomFilter); !ok {
+			return errWrongBloomType
+		}
+	case core.CMS:
+		if _, ok := value.Data.(*core.CountMinSketch); !ok {
+			return errWrongCMSType
+		}
+	case core.CUCKOO:
+		if _, ok := value.Data.(*core.CuckooFilter); !ok {
+			return errWrongCuckooType
+		}
+	}
+	return nil
+}
+
+func sketchSuccess(data interface{}) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    data,
+		Type:    "sketch",
+	}
+}
+
+func sketchError(message string) *core.Response {
+	return &core.Response{
+		Success: false,
+		Error:   message,
+		Type:    "sketch",
+	}
+}
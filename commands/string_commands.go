@@ -1,12 +1,20 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Errors shared by string and numeric commands
+var (
+	errNotString  = errs.Newf(errs.ErrWrongType, "value is not a string")
+	errNotInteger = errs.Newf(errs.ErrNotInteger, "value is not a valid integer")
 )
 
 // StringCommands handles all string-related operations
@@ -28,16 +36,12 @@ func (sc *StringCommands) Set(key, value string, ttl int64) *core.Response {
 	}
 	
 	if ttl > 0 {
-		triffValue.TTL = time.Now().Unix() + ttl
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
 	}
 	
 	err := sc.db.Set(key, triffValue)
 	if err != nil {
-		return &core.Response{
-			Success: false,
-			Error:   err.Error(),
-			Type:    "string",
-		}
+		return core.Fail("string", err)
 	}
 	
 	return &core.Response{
@@ -47,9 +51,121 @@ func (sc *StringCommands) Set(key, value string, ttl int64) *core.Response {
 	}
 }
 
-// Get retrieves a string value
+// Get retrieves a string value. A null entry answers successfully with
+// nil Data and Type "null", where a missing key fails.
 func (sc *StringCommands) Get(key string) *core.Response {
 	value, exists := sc.db.Get(key)
+	if exists && value.Type == core.NULL {
+		return &core.Response{
+			Success: true,
+			Data:    nil,
+			Type:    "null",
+		}
+	}
+	return stringResponse(value, exists)
+}
+
+// SetNull stores a null entry, caching that the record key stands for
+// doesn't exist, so a read-through layer can tell it from a key it hasn't
+// looked up yet
+func (sc *StringCommands) SetNull(key string, ttl int64) *core.Response {
+	triffValue := &core.TriffValue{
+		Type: core.NULL,
+	}
+	
+	if ttl > 0 {
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
+	}
+	
+	if err := sc.db.Set(key, triffValue); err != nil {
+		return core.Fail("string", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// SetNX stores a string value only if the key does not exist
+func (sc *StringCommands) SetNX(key, value string, ttl int64) *core.Response {
+	triffValue := &core.TriffValue{
+		Type: core.STRING,
+		Data: value,
+	}
+	
+	if ttl > 0 {
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
+	}
+	
+	stored, err := sc.db.SetIfAbsent(key, triffValue)
+	if err != nil {
+		return core.Fail("string", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    stored,
+		Type:    "boolean",
+	}
+}
+
+// SetXX stores a string value only if the key already exists
+func (sc *StringCommands) SetXX(key, value string, ttl int64) *core.Response {
+	triffValue := &core.TriffValue{
+		Type: core.STRING,
+		Data: value,
+	}
+	
+	if ttl > 0 {
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
+	}
+	
+	stored, err := sc.db.SetIfExists(key, triffValue)
+	if err != nil {
+		return core.Fail("string", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    stored,
+		Type:    "boolean",
+	}
+}
+
+// SetKeepTTL stores a string value while retaining the key's current expiration
+func (sc *StringCommands) SetKeepTTL(key, value string) *core.Response {
+	err := sc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		var ttl int64
+		if old != nil {
+			ttl = old.TTL
+		}
+		return &core.TriffValue{Type: core.STRING, Data: value, TTL: ttl}, nil
+	})
+	if err != nil {
+		return core.Fail("string", err)
+	}
+	
+	return &core.Response{
+		Success: true,
+		Data:    "OK",
+		Type:    "string",
+	}
+}
+
+// GetEx retrieves a string value and updates its expiration in the same call.
+// A positive ttl sets a new expiration in seconds, persist removes it, and
+// neither leaves the expiration untouched.
+func (sc *StringCommands) GetEx(key string, ttl int64, persist bool) *core.Response {
+	return stringResponse(sc.db.GetEx(key, core.STRING, ttl, persist))
+}
+
+// GetDel retrieves a string value and deletes the key. Keys of other types
+// are left in place.
+func (sc *StringCommands) GetDel(key string) *core.Response {
+	return stringResponse(sc.db.GetDel(key, core.STRING))
+}
+
+// stringResponse replies with a looked up string value
+func stringResponse(value *core.TriffValue, exists bool) *core.Response {
 	if !exists {
 		return &core.Response{
 			Success: false,
@@ -59,37 +175,157 @@ func (sc *StringCommands) Get(key string) *core.Response {
 	}
 	
 	if value.Type != core.STRING {
+		return core.Fail("string", errNotString)
+	}
+	
+	return &core.Response{
+		Success: true,
+		Data:    value.Data,
+		Type:    "string",
+	}
+}
+
+// StaleValue is returned by GetStale
+type StaleValue struct {
+	Value string `json:"value"`
+	Stale bool   `json:"stale"`
+}
+
+// GetStale retrieves a string value, serving it past expiry while it is inside
+// the stale grace window. The Stale flag tells the caller to revalidate.
+func (sc *StringCommands) GetStale(key string) *core.Response {
+	value, stale, exists := sc.db.GetStale(key)
+	if !exists {
 		return &core.Response{
 			Success: false,
-			Error:   "value is not a string",
+			Data:    nil,
 			Type:    "string",
 		}
 	}
 	
+	if value.Type != core.STRING {
+		return core.Fail("string", errNotString)
+	}
+	
 	return &core.Response{
 		Success: true,
-		Data:    value.Data,
+		Data:    StaleValue{Value: value.Data.(string), Stale: stale},
 		Type:    "string",
 	}
 }
 
-// Append appends a value to an existing string
-func (sc *StringCommands) Append(key, value string) *core.Response {
-	existing, exists := sc.db.Get(key)
-	var newValue string
-	
-	if exists && existing.Type == core.STRING {
-		newValue = existing.Data.(string) + value
-	} else {
-		newValue = value
+// FetchValue is returned by Fetch. Found is false for a missing key, and
+// Null is set for a null entry, which holds no Value. Token is set when
+// the caller was granted the computation.
+type FetchValue struct {
+	Value string `json:"value"`
+	Found bool   `json:"found"`
+	Null  bool   `json:"null,omitempty"`
+	Stale bool   `json:"stale"`
+	Token string `json:"token,omitempty"`
+}
+
+// Fetch retrieves a string value, coalescing concurrent misses so only one
+// caller is granted its computation for lock while the others wait for it
+// or are served the stale value
+func (sc *StringCommands) Fetch(ctx context.Context, key string, lock time.Duration) *core.Response {
+	result, err := sc.db.Fetch(ctx, key, lock)
+	if err != nil {
+		return core.Fail("string", err)
 	}
-	
+
+	fetched := FetchValue{Found: result.Value != nil, Stale: result.Stale, Token: result.Token}
+	if result.Value != nil {
+		switch result.Value.Type {
+		case core.STRING:
+			fetched.Value = result.Value.Data.(string)
+		case core.NULL:
+			fetched.Null = true
+		default:
+			if result.Token != "" {
+				sc.db.Abandon(key, result.Token)
+			}
+			return core.Fail("string", errNotString)
+		}
+	}
+
+	return &core.Response{
+		Success: true,
+		Data:    fetched,
+		Type:    "string",
+	}
+}
+
+// Fill stores the string value computed under a grant from Fetch, returning
+// false if token is no longer the key's grant
+func (sc *StringCommands) Fill(key, token, value string, ttl int64) *core.Response {
 	triffValue := &core.TriffValue{
 		Type: core.STRING,
-		Data: newValue,
+		Data: value,
+	}
+
+	if ttl > 0 {
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
+	}
+
+	filled, err := sc.db.Fill(key, token, triffValue)
+	if err != nil {
+		return core.Fail("string", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    filled,
+		Type:    "string",
+	}
+}
+
+// CompareAndSet stores a string value only if the key is still at the
+// expected version (0 meaning the key must not exist), returning the new version
+func (sc *StringCommands) CompareAndSet(key, value string, version uint64, ttl int64) *core.Response {
+	triffValue := &core.TriffValue{
+		Type: core.STRING,
+		Data: value,
+	}
+	
+	if ttl > 0 {
+		triffValue.TTL = sc.db.Clock().Now().Unix() + ttl
+	}
+	
+	newVersion, err := sc.db.CompareAndSet(key, version, triffValue)
+	if err != nil {
+		return &core.Response{
+			Success: false,
+			Data:    newVersion,
+			Error:   err.Error(),
+			Type:    "string",
+			Err:     err,
+		}
 	}
 	
-	sc.db.Set(key, triffValue)
+	return &core.Response{
+		Success: true,
+		Data:    newVersion,
+		Type:    "integer",
+	}
+}
+
+// Append appends a value to an existing string, keeping its expiration
+func (sc *StringCommands) Append(key, value string) *core.Response {
+	var newValue string
+	err := sc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			newValue = value
+			return &core.TriffValue{Type: core.STRING, Data: newValue}, nil
+		}
+		if old.Type != core.STRING {
+			return nil, errNotString
+		}
+		newValue = old.Data.(string) + value
+		return &core.TriffValue{Type: core.STRING, Data: newValue, TTL: old.TTL}, nil
+	})
+	if err != nil {
+		return core.Fail("string", err)
+	}
 	
 	return &core.Response{
 		Success: true,
@@ -98,8 +334,18 @@ func (sc *StringCommands) Append(key, value string) *core.Response {
 	}
 }
 
-// Strlen returns the length of a string
+// Strlen returns the length of a string in bytes
 func (sc *StringCommands) Strlen(key string) *core.Response {
+	return sc.strlen(key, false)
+}
+
+// StrlenChars returns the length of a string in characters (runes). Bytes
+// that aren't valid UTF-8 count as one character each.
+func (sc *StringCommands) StrlenChars(key string) *core.Response {
+	return sc.strlen(key, true)
+}
+
+func (sc *StringCommands) strlen(key string, chars bool) *core.Response {
 	value, exists := sc.db.Get(key)
 	if !exists {
 		return &core.Response{
@@ -110,59 +356,54 @@ func (sc *StringCommands) Strlen(key string) *core.Response {
 	}
 	
 	if value.Type != core.STRING {
-		return &core.Response{
-			Success: false,
-			Error:   "value is not a string",
-			Type:    "string",
-		}
+		return core.Fail("string", errNotString)
 	}
 	
 	length := len(value.Data.(string))
+	if chars {
+		length = utf8.RuneCountInString(value.Data.(string))
+	}
 	return &core.Response{
 		Success: true,
 		Data:    length,
 		Type:    "integer",
 	}
 }
-
 // Incr increments a numeric string value
 func (sc *StringCommands) Incr(key string) *core.Response {
 	return sc.IncrBy(key, 1)
 }
 
-// IncrBy increments a numeric string value by a specific amount
+// IncrBy increments a numeric string value by a specific amount, keeping
+// its expiration
 func (sc *StringCommands) IncrBy(key string, increment int64) *core.Response {
-	value, exists := sc.db.Get(key)
-	var currentValue int64 = 0
-	
-	if exists {
-		if value.Type != core.STRING {
-			return &core.Response{
-				Success: false,
-				Error:   "value is not a string",
-				Type:    "string",
+	var newValue int64
+	err := sc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		var currentValue, ttl int64
+		if old != nil {
+			if old.Type != core.STRING {
+				return nil, errNotString
 			}
-		}
-		
-		var err error
-		currentValue, err = strconv.ParseInt(value.Data.(string), 10, 64)
-		if err != nil {
-			return &core.Response{
-				Success: false,
-				Error:   "value is not a valid integer",
-				Type:    "string",
+			
+			var err error
+			currentValue, err = strconv.ParseInt(old.Data.(string), 10, 64)
+			if err != nil {
+				return nil, errNotInteger
 			}
+			ttl = old.TTL
 		}
+		
+		newValue = currentValue + increment
+		return &core.TriffValue{
+			Type: core.STRING,
+			Data: fmt.Sprintf("%d", newValue),
+			TTL:  ttl,
+		}, nil
+	})
+	if err != nil {
+		return core.Fail("string", err)
 	}
 	
-	newValue := currentValue + increment
-	triffValue := &core.TriffValue{
-		Type: core.STRING,
-		Data: fmt.Sprintf("%d", newValue),
-	}
-	
-	sc.db.Set(key, triffValue)
-	
 	return &core.Response{
 		Success: true,
 		Data:    newValue,
@@ -175,13 +416,13 @@ func (sc *StringCommands) Decr(key string) *core.Response {
 	return sc.IncrBy(key, -1)
 }
 
-// MGet gets multiple string values
+// MGet gets multiple string values, reading them all at the same point in
+// time. Keys that don't exist or don't hold strings are nil.
 func (sc *StringCommands) MGet(keys []string) *core.Response {
 	results := make([]interface{}, len(keys))
 	
-	for i, key := range keys {
-		value, exists := sc.db.Get(key)
-		if !exists || value.Type != core.STRING {
+	for i, value := range sc.db.GetMany(keys) {
+		if value == nil || value.Type != core.STRING {
 			results[i] = nil
 		} else {
 			results[i] = value.Data
@@ -195,14 +436,18 @@ func (sc *StringCommands) MGet(keys []string) *core.Response {
 	}
 }
 
-// MSet sets multiple string values
+// MSet sets multiple string values at once: if one breaks a limit, none
+// is set
 func (sc *StringCommands) MSet(keyValues map[string]string) *core.Response {
+	values := make(map[string]*core.TriffValue, len(keyValues))
 	for key, value := range keyValues {
-		triffValue := &core.TriffValue{
+		values[key] = &core.TriffValue{
 			Type: core.STRING,
 			Data: value,
 		}
-		sc.db.Set(key, triffValue)
+	}
+	if err := sc.db.SetMany(values); err != nil {
+		return core.Fail("string", err)
 	}
 	
 	return &core.Response{
@@ -212,8 +457,42 @@ func (sc *StringCommands) MSet(keyValues map[string]string) *core.Response {
 	}
 }
 
-// GetRange returns a substring of a string value
+// MSetNX sets multiple string values only if none of the keys exists,
+// all at once. Data is whether they were set.
+func (sc *StringCommands) MSetNX(keyValues map[string]string) *core.Response {
+	values := make(map[string]*core.TriffValue, len(keyValues))
+	for key, value := range keyValues {
+		values[key] = &core.TriffValue{
+			Type: core.STRING,
+			Data: value,
+		}
+	}
+	set, err := sc.db.SetManyIfAbsent(values)
+	if err != nil {
+		return core.Fail("string", err)
+	}
+	
+	return &core.Response{
+		Success: true,
+		Data:    set,
+		Type:    "boolean",
+	}
+}
+
+// GetRange returns a substring of a string value, start and end being
+// inclusive byte offsets that count from the end when negative
 func (sc *StringCommands) GetRange(key string, start, end int) *core.Response {
+	return sc.getRange(key, start, end, false)
+}
+
+// GetRangeChars is GetRange with offsets in characters (runes), so UTF-8
+// text is never cut inside a character. Bytes that aren't valid UTF-8
+// count as one character each and are returned unchanged.
+func (sc *StringCommands) GetRangeChars(key string, start, end int) *core.Response {
+	return sc.getRange(key, start, end, true)
+}
+
+func (sc *StringCommands) getRange(key string, start, end int, chars bool) *core.Response {
 	value, exists := sc.db.Get(key)
 	if !exists {
 		return &core.Response{
@@ -224,15 +503,21 @@ func (sc *StringCommands) GetRange(key string, start, end int) *core.Response {
 	}
 	
 	if value.Type != core.STRING {
-		return &core.Response{
-			Success: false,
-			Error:   "value is not a string",
-			Type:    "string",
-		}
+		return core.Fail("string", errNotString)
 	}
 	
 	str := value.Data.(string)
+	// offsets holds the byte offset of every character plus the end of
+	// the string; for bytes it is the identity
+	var offsets []int
 	length := len(str)
+	if chars {
+		for i := range str {
+			offsets = append(offsets, i)
+		}
+		length = len(offsets)
+		offsets = append(offsets, len(str))
+	}
 	
 	// Handle negative indices
 	if start < 0 {
@@ -257,7 +542,12 @@ func (sc *StringCommands) GetRange(key string, start, end int) *core.Response {
 		}
 	}
 	
-	result := str[start : end+1]
+	result := ""
+	if chars {
+		result = str[offsets[start]:offsets[end+1]]
+	} else {
+		result = str[start : end+1]
+	}
 	return &core.Response{
 		Success: true,
 		Data:    result,
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	seed  = flag.Int64("seed", 0, "seed for the random cases, 0 for a new one")
+	cases = flag.Int("cases", 10000, "random cases per property")
+)
+
+// newRand returns the source of a test's random cases, logging its seed
+// so that a failure can be replayed with -seed
+func newRand(t *testing.T) *rand.Rand {
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", s)
+	return rand.New(rand.NewSource(s))
+}
+
+// argBytes are the bytes random keys and values are made of, binary ones
+// included
+const argBytes = "ab \t\"'\\\r\n\x00\x7f\xff\xc3\xa9x"
+
+func randomArg(rng *rand.Rand) string {
+	b := make([]byte, rng.Intn(12))
+	for i := range b {
+		if rng.Intn(8) == 0 {
+			b[i] = byte(rng.Intn(256))
+		} else {
+			b[i] = argBytes[rng.Intn(len(argBytes))]
+		}
+	}
+	return string(b)
+}
+
+// newTestDatabase creates an empty database on a fake clock
+func newTestDatabase() (*core.Database, *core.FakeClock) {
+	clock := core.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := core.NewDatabase(&core.Config{})
+	db.SetClock(clock)
+	return db, clock
+}
+
+// TestGetAfterSet checks that GET and STRLEN see what SET stored, over
+// whatever the key held before
+func TestGetAfterSet(t *testing.T) {
+	rng := newRand(t)
+	for i := 0; i < *cases; i++ {
+		db, _ := newTestDatabase()
+		strings := NewStringCommands(db)
+		key, value := randomArg(rng), randomArg(rng)
+		if rng.Intn(2) == 0 {
+			NewHashCommands(db).HSet(key, "field", randomArg(rng))
+		}
+
+		if _, err := core.Result[string](strings.Set(key, value, 0)); err != nil {
+			t.Fatalf("SET %q %q: %v", key, value, err)
+		}
+		got, err := core.Result[string](strings.Get(key))
+		if err != nil || got != value {
+			t.Fatalf("GET %q after SET %q: %q (%v)", key, value, got, err)
+		}
+		length, err := core.Result[int](strings.Strlen(key))
+		if err != nil || length != len(value) {
+			t.Fatalf("STRLEN %q of %q: %d (%v)", key, value, length, err)
+		}
+	}
+}
+
+// TestTTLCountsDown moves the clock forward in random steps and checks
+// that a key's TTL never goes up, that it lives until its TTL has passed
+// and that once expired it stays expired
+func TestTTLCountsDown(t *testing.T) {
+	rng := newRand(t)
+	for i := 0; i < *cases; i++ {
+		db, clock := newTestDatabase()
+		strings := NewStringCommands(db)
+		ttl := 1 + rng.Int63n(300)
+		if _, err := core.Result[string](strings.Set("key", "value", ttl)); err != nil {
+			t.Fatal(err)
+		}
+
+		last, elapsed, expired := ttl, time.Duration(0), false
+		for elapsed < time.Duration(ttl+10)*time.Second {
+			step := time.Duration(rng.Int63n(int64(20 * time.Second)))
+			clock.Advance(step)
+			elapsed += step
+
+			remaining := db.GetTTL("key")
+			_, exists := db.Get("key")
+			switch {
+			case remaining > last:
+				t.Fatalf("TTL %d went up to %d after %v", last, remaining, elapsed)
+			case expired && exists:
+				t.Fatalf("key with TTL %d came back after %v", ttl, elapsed)
+			case !exists && elapsed < time.Duration(ttl)*time.Second:
+				t.Fatalf("key with TTL %d expired after %v", ttl, elapsed)
+			}
+			if remaining >= 0 {
+				last = remaining
+			}
+			expired = !exists
+		}
+		if !expired {
+			t.Fatalf("key with TTL %d still there after %v", ttl, elapsed)
+		}
+	}
+}
+
+// TestTypeErrors runs random string commands against a hash and checks
+// each fails with a type error and leaves the hash as it was
+func TestTypeErrors(t *testing.T) {
+	rng := newRand(t)
+	for i := 0; i < *cases; i++ {
+		db, _ := newTestDatabase()
+		strings := NewStringCommands(db)
+		hashes := NewHashCommands(db)
+		key, field := randomArg(rng), randomArg(rng)
+		hashes.HSet(key, field, "value")
+
+		ops := []struct {
+			name string
+			run  func() *core.Response
+		}{
+			{"GET", func() *core.Response { return strings.Get(key) }},
+			{"APPEND", func() *core.Response { return strings.Append(key, randomArg(rng)) }},
+			{"INCR", func() *core.Response { return strings.Incr(key) }},
+			{"STRLEN", func() *core.Response { return strings.Strlen(key) }},
+			{"GETRANGE", func() *core.Response { return strings.GetRange(key, rng.Intn(10)-5, rng.Intn(10)-5) }},
+			{"GETDEL", func() *core.Response { return strings.GetDel(key) }},
+		}
+		for j := 0; j < 5; j++ {
+			op := ops[rng.Intn(len(ops))]
+			response := op.run()
+			if response.Success || !errors.Is(response.Failure(), errs.ErrWrongType) {
+				t.Fatalf("%s on a hash: success %v, error %q", op.name, response.Success, response.Error)
+			}
+			if got, err := core.Result[string](hashes.HGet(key, field)); err != nil || got != "value" {
+				t.Fatalf("hash changed by %s: %q (%v)", op.name, got, err)
+			}
+		}
+	}
+}
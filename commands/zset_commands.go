@@ -0,0 +1,554 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// blockingPollInterval is how often blocking pops look for new members
+const blockingPollInterval = 10 * time.Millisecond
+
+var errNotZSet = errs.Newf(errs.ErrWrongType, "value is not a sorted set")
+
+// ZSetEntry is a sorted set member with its score
+type ZSetEntry struct {
+	Key    string  `json:"key,omitempty"` // Set by blocking pops, which watch several keys
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// ZSetCommands handles sorted set operations. Members are ordered by
+// ascending score, ties by member, as in Redis; leaderboards are sorted
+// sets too, so these work on their keys (leaderboard:<name>) as well.
+type ZSetCommands struct {
+	db *core.Database
+}
+
+// NewZSetCommands creates a new sorted set commands handler
+func NewZSetCommands(db *core.Database) *ZSetCommands {
+	return &ZSetCommands{db: db}
+}
+
+// ZAddOptions are ZADD's flags
+type ZAddOptions struct {
+	NX bool // Only add new members
+	XX bool // Only update members that exist
+	GT bool // Only update a member when its new score is greater
+	LT bool // Only update a member when its new score is less
+	CH bool // Count changed members as well as added ones
+}
+
+// Aggregations for ZUnionStore and ZInterStore
+const (
+	AggregateSum = "sum"
+	AggregateMin = "min"
+	AggregateMax = "max"
+)
+
+// ZAdd adds members or updates their scores under opts' conditions, and
+// returns how many members were added, or added and changed with CH. GT
+// and LT don't stop new members from being added, as in Redis.
+func (zc *ZSetCommands) ZAdd(key string, members []ZSetEntry, opts ZAddOptions) *core.Response {
+	switch {
+	case opts.NX && opts.XX:
+		return core.Fail("zset", fmt.Errorf("XX and NX options at the same time are not compatible"))
+	case (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)):
+		return core.Fail("zset", fmt.Errorf("GT, LT, and/or NX options at the same time are not compatible"))
+	}
+	for _, entry := range members {
+		if math.IsNaN(entry.Score) {
+			return core.Fail("zset", fmt.Errorf("score is not a valid float"))
+		}
+	}
+
+	counted := 0
+	err := zc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		scores := make(map[string]float64)
+		var ttl int64
+		if old != nil {
+			current, ok := old.Data.(map[string]float64)
+			if old.Type != core.ZSET || !ok {
+				return nil, errNotZSet
+			}
+			for member, score := range current {
+				scores[member] = score
+			}
+			ttl = old.TTL
+		}
+
+		for _, entry := range members {
+			current, exists := scores[entry.Member]
+			switch {
+			case exists && (opts.NX || current == entry.Score ||
+				(opts.GT && entry.Score <= current) || (opts.LT && entry.Score >= current)):
+				continue
+			case !exists && opts.XX:
+				continue
+			}
+			scores[entry.Member] = entry.Score
+			if !exists || opts.CH {
+				counted++
+			}
+		}
+		if len(scores) == 0 {
+			return old, nil
+		}
+		return &core.TriffValue{Type: core.ZSET, Data: scores, TTL: ttl}, nil
+	})
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    counted,
+		Type:    "integer",
+	}
+}
+
+// ZUnionStore stores the union of the sorted sets at keys in destination,
+// each set's scores multiplied by its weight (1 when weights is empty) and
+// combined with aggregate (sum, min or max). It returns the size of the
+// result; an empty result deletes destination. Sources are read one after
+// another, not as one snapshot.
+func (zc *ZSetCommands) ZUnionStore(destination string, keys []string, weights []float64, aggregate string) *core.Response {
+	return zc.combineStore(destination, keys, weights, aggregate, false)
+}
+
+// ZInterStore is ZUnionStore for the members present in every set
+func (zc *ZSetCommands) ZInterStore(destination string, keys []string, weights []float64, aggregate string) *core.Response {
+	return zc.combineStore(destination, keys, weights, aggregate, true)
+}
+
+func (zc *ZSetCommands) combineStore(destination string, keys []string, weights []float64, aggregate string, intersect bool) *core.Response {
+	if len(keys) == 0 {
+		return core.Fail("zset", fmt.Errorf("at least 1 input key is needed"))
+	}
+	if len(weights) > 0 && len(weights) != len(keys) {
+		return core.Fail("zset", fmt.Errorf("one weight is needed per key"))
+	}
+	if aggregate == "" {
+		aggregate = AggregateSum
+	}
+	if aggregate != AggregateSum && aggregate != AggregateMin && aggregate != AggregateMax {
+		return core.Fail("zset", fmt.Errorf("aggregate must be sum, min or max"))
+	}
+
+	var result map[string]float64
+	for i, key := range keys {
+		scores, err := zc.scores(key)
+		if err != nil {
+			return core.Fail("zset", err)
+		}
+		weight := 1.0
+		if len(weights) > 0 {
+			weight = weights[i]
+		}
+
+		next := make(map[string]float64, len(scores))
+		for member, score := range scores {
+			weighted := score * weight
+			if math.IsNaN(weighted) {
+				// inf * 0
+				weighted = 0
+			}
+			if i == 0 {
+				next[member] = weighted
+				continue
+			}
+			current, exists := result[member]
+			switch {
+			case !exists && intersect:
+				continue
+			case !exists:
+				next[member] = weighted
+			default:
+				next[member] = aggregateScores(aggregate, current, weighted)
+			}
+		}
+		if i > 0 && !intersect {
+			for member, score := range result {
+				if _, exists := next[member]; !exists {
+					next[member] = score
+				}
+			}
+		}
+		result = next
+	}
+
+	if len(result) == 0 {
+		zc.db.Delete(destination)
+	} else if err := zc.db.Set(destination, &core.TriffValue{Type: core.ZSET, Data: result}); err != nil {
+		return core.Fail("zset", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    len(result),
+		Type:    "integer",
+	}
+}
+
+// aggregateScores combines a member's scores from two sets
+func aggregateScores(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case AggregateMin:
+		return math.Min(a, b)
+	case AggregateMax:
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		// +inf + -inf
+		return 0
+	}
+	return sum
+}
+
+// ZPopMin removes and returns up to count members with the lowest scores
+func (zc *ZSetCommands) ZPopMin(key string, count int) *core.Response {
+	return zc.pop(key, count, false)
+}
+
+// ZPopMax removes and returns up to count members with the highest scores,
+// highest first
+func (zc *ZSetCommands) ZPopMax(key string, count int) *core.Response {
+	return zc.pop(key, count, true)
+}
+
+// ZMPop pops up to count members with the lowest (or highest) scores from
+// the first non-empty sorted set among keys. Each entry carries the key it
+// came from; no entries means every set was empty.
+func (zc *ZSetCommands) ZMPop(keys []string, highest bool, count int) *core.Response {
+	if count < 1 {
+		return core.Fail("zset", fmt.Errorf("count must be positive"))
+	}
+	for _, key := range keys {
+		popped, err := zc.popEntries(key, count, highest)
+		if err != nil {
+			return core.Fail("zset", err)
+		}
+		if len(popped) > 0 {
+			for i := range popped {
+				popped[i].Key = key
+			}
+			return zsetSuccess(popped)
+		}
+	}
+	return zsetSuccess([]ZSetEntry{})
+}
+
+// BZPopMin pops the lowest scored member of the first non-empty set among
+// keys, waiting up to timeout (forever if 0) for one to get members. It
+// returns no entries when the timeout passes.
+func (zc *ZSetCommands) BZPopMin(ctx context.Context, keys []string, timeout time.Duration) *core.Response {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(blockingPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, key := range keys {
+			popped, err := zc.popEntries(key, 1, false)
+			if err != nil {
+				return core.Fail("zset", err)
+			}
+			if len(popped) > 0 {
+				popped[0].Key = key
+				return zsetSuccess(popped)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded && timeout > 0 {
+				return zsetSuccess([]ZSetEntry{})
+			}
+			return core.Fail("zset", ctx.Err())
+		}
+	}
+}
+
+// ZRangeByLex returns the members between min and max in lexicographic
+// order, skipping offset and returning at most count (all if negative).
+// Bounds are "[member" (inclusive), "(member" (exclusive), "-" or "+".
+// Like Redis this assumes all members share one score.
+func (zc *ZSetCommands) ZRangeByLex(key, min, max string, offset, count int) *core.Response {
+	lower, upper, err := parseLexRange(min, max)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	scores, err := zc.scores(key)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+
+	members := []string{}
+	for _, entry := range sortZSet(scores) {
+		if !lower.below(entry.Member) || !upper.above(entry.Member) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count >= 0 && len(members) == count {
+			break
+		}
+		members = append(members, entry.Member)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    members,
+		Type:    "array",
+	}
+}
+
+// ZRemRangeByScore removes the members scored between min and max and
+// returns how many. Bounds are numbers, "-inf" or "+inf", exclusive when
+// prefixed with "(".
+func (zc *ZSetCommands) ZRemRangeByScore(key, min, max string) *core.Response {
+	lower, lowerOpen, err := parseScoreBound(min)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	upper, upperOpen, err := parseScoreBound(max)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	return zc.removeWhere(key, func(_, _ int, entry ZSetEntry) bool {
+		aboveMin := entry.Score > lower || (!lowerOpen && entry.Score == lower)
+		belowMax := entry.Score < upper || (!upperOpen && entry.Score == upper)
+		return aboveMin && belowMax
+	})
+}
+
+// ZRemRangeByRank removes the members from rank start to stop, inclusive,
+// where rank 0 is the lowest score and negative ranks count from the
+// highest
+func (zc *ZSetCommands) ZRemRangeByRank(key string, start, stop int) *core.Response {
+	return zc.removeWhere(key, func(rank, size int, _ ZSetEntry) bool {
+		first, last := start, stop
+		if first < 0 {
+			first += size
+		}
+		if last < 0 {
+			last += size
+		}
+		return rank >= first && rank <= last
+	})
+}
+
+// ZRemRangeByLex removes the members between min and max, using
+// ZRangeByLex's bounds, and returns how many
+func (zc *ZSetCommands) ZRemRangeByLex(key, min, max string) *core.Response {
+	lower, upper, err := parseLexRange(min, max)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	return zc.removeWhere(key, func(_, _ int, entry ZSetEntry) bool {
+		return lower.below(entry.Member) && upper.above(entry.Member)
+	})
+}
+
+func (zc *ZSetCommands) pop(key string, count int, highest bool) *core.Response {
+	if count < 0 {
+		return core.Fail("zset", fmt.Errorf("count can't be negative"))
+	}
+	popped, err := zc.popEntries(key, count, highest)
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	return zsetSuccess(popped)
+}
+
+// popEntries removes up to count members from one end of a sorted set in a
+// single update, deleting the key once it is empty
+func (zc *ZSetCommands) popEntries(key string, count int, highest bool) ([]ZSetEntry, error) {
+	popped := []ZSetEntry{}
+	err := zc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		scores, ok := old.Data.(map[string]float64)
+		if old.Type != core.ZSET || !ok {
+			return nil, errNotZSet
+		}
+
+		entries := sortZSet(scores)
+		if highest {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		if count < len(entries) {
+			entries = entries[:count]
+		}
+		if len(entries) == 0 {
+			return old, nil
+		}
+		popped = entries
+		return zsetWithout(old, scores, entries), nil
+	})
+	return popped, err
+}
+
+// removeWhere removes the members that match and returns how many. match
+// gets each member's rank by ascending score and the size of the set.
+func (zc *ZSetCommands) removeWhere(key string, match func(rank, size int, entry ZSetEntry) bool) *core.Response {
+	removed := 0
+	err := zc.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old == nil {
+			return nil, nil
+		}
+		scores, ok := old.Data.(map[string]float64)
+		if old.Type != core.ZSET || !ok {
+			return nil, errNotZSet
+		}
+
+		entries := sortZSet(scores)
+		matched := []ZSetEntry{}
+		for rank, entry := range entries {
+			if match(rank, len(entries), entry) {
+				matched = append(matched, entry)
+			}
+		}
+		if len(matched) == 0 {
+			return old, nil
+		}
+		removed = len(matched)
+		return zsetWithout(old, scores, matched), nil
+	})
+	if err != nil {
+		return core.Fail("zset", err)
+	}
+	return &core.Response{
+		Success: true,
+		Data:    removed,
+		Type:    "integer",
+	}
+}
+
+// scores returns the members of a sorted set, empty if the key is missing
+func (zc *ZSetCommands) scores(key string) (map[string]float64, error) {
+	value, exists := zc.db.Get(key)
+	if !exists {
+		return map[string]float64{}, nil
+	}
+	scores, ok := value.Data.(map[string]float64)
+	if value.Type != core.ZSET || !ok {
+		return nil, errNotZSet
+	}
+	return scores, nil
+}
+
+// sortZSet orders members by ascending score, ties by member
+func sortZSet(scores map[string]float64) []ZSetEntry {
+	entries := make([]ZSetEntry, 0, len(scores))
+	for member, score := range scores {
+		entries = append(entries, ZSetEntry{Member: member, Score: score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score < entries[j].Score
+		}
+		return entries[i].Member < entries[j].Member
+	})
+	return entries
+}
+
+// zsetWithout returns old without the removed members, or nil to delete
+// the key when none are left
+func zsetWithout(old *core.TriffValue, scores map[string]float64, removed []ZSetEntry) *core.TriffValue {
+	if len(removed) == len(scores) {
+		return nil
+	}
+	gone := make(map[string]bool, len(removed))
+	for _, entry := range removed {
+		gone[entry.Member] = true
+	}
+	kept := make(map[string]float64, len(scores)-len(removed))
+	for member, score := range scores {
+		if !gone[member] {
+			kept[member] = score
+		}
+	}
+	return &core.TriffValue{Type: core.ZSET, Data: kept, TTL: old.TTL}
+}
+
+// lexBound is one end of a ZRANGEBYLEX range
+type lexBound struct {
+	value     string
+	inclusive bool
+	unbounded bool // "-" for the lower end, "+" for the upper
+}
+
+// below reports whether member is at or after a lower bound
+func (b lexBound) below(member string) bool {
+	return b.unbounded || member > b.value || (b.inclusive && member == b.value)
+}
+
+// above reports whether member is at or before an upper bound
+func (b lexBound) above(member string) bool {
+	return b.unbounded || member < b.value || (b.inclusive && member == b.value)
+}
+
+func parseLexRange(min, max string) (lexBound, lexBound, error) {
+	lower, err := parseLexBound(min, "-")
+	if err != nil {
+		return lexBound{}, lexBound{}, err
+	}
+	upper, err := parseLexBound(max, "+")
+	if err != nil {
+		return lexBound{}, lexBound{}, err
+	}
+	return lower, upper, nil
+}
+
+func parseLexBound(bound, infinite string) (lexBound, error) {
+	switch {
+	case bound == infinite:
+		return lexBound{unbounded: true}, nil
+	case strings.HasPrefix(bound, "["):
+		return lexBound{value: bound[1:], inclusive: true}, nil
+	case strings.HasPrefix(bound, "("):
+		return lexBound{value: bound[1:]}, nil
+	}
+	return lexBound{}, fmt.Errorf("min or max not valid string range item")
+}
+
+// parseScoreBound parses a score range bound, reporting whether it is
+// exclusive
+func parseScoreBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+	switch strings.ToLower(bound) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	score, err := strconv.ParseFloat(bound, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, fmt.Errorf("min or max is not a float")
+	}
+	return score, exclusive, nil
+}
+
+func zsetSuccess(entries []ZSetEntry) *core.Response {
+	return &core.Response{
+		Success: true,
+		Data:    entries,
+		Type:    "zset",
+	}
+}
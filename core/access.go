@@ -0,0 +1,98 @@
+package core
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// LFU counter tuning, following Redis' logarithmic access counter
+const (
+	lfuInitValue = 5   // counter of a freshly written key so it isn't evicted immediately
+	lfuMaxValue  = 255 // counters saturate at one byte worth of range
+	lfuLogFactor = 10  // higher values need more hits to grow the counter
+	lfuDecayMins = 1   // minutes of idleness that decrement the counter by one
+)
+
+// touch records a read access at now, the database's clock time. It only
+// uses atomic operations so it can run while the database holds a read
+// lock.
+func (v *TriffValue) touch(now time.Time) {
+	nanos := now.UnixNano()
+	last := atomic.SwapInt64(&v.lastAccess, nanos)
+
+	for {
+		counter := atomic.LoadUint32(&v.lfuCounter)
+		next := lfuIncrement(lfuDecay(counter, last, nanos))
+		if atomic.CompareAndSwapUint32(&v.lfuCounter, counter, next) {
+			return
+		}
+	}
+}
+
+// initAccess initializes access metadata on a write at now, carrying over
+// the frequency of the value being replaced so a key keeps its popularity
+func (v *TriffValue) initAccess(old *TriffValue, now time.Time) {
+	nanos := now.UnixNano()
+	counter := uint32(lfuInitValue)
+	if old != nil {
+		if c := lfuDecay(atomic.LoadUint32(&old.lfuCounter), atomic.LoadInt64(&old.lastAccess), nanos); c > counter {
+			counter = c
+		}
+	}
+	atomic.StoreInt64(&v.lastAccess, nanos)
+	atomic.StoreUint32(&v.lfuCounter, counter)
+}
+
+// IdleTime returns how long before now the value was last read or
+// written. Pass the database's clock time (Database.Clock).
+func (v *TriffValue) IdleTime(now time.Time) time.Duration {
+	last := atomic.LoadInt64(&v.lastAccess)
+	if last == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, last))
+}
+
+// LastAccess returns when the value was last read or written
+func (v *TriffValue) LastAccess() time.Time {
+	last := atomic.LoadInt64(&v.lastAccess)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// AccessFrequency returns the logarithmic access counter (0-255) after
+// applying decay for the time the value has been idle as of now
+func (v *TriffValue) AccessFrequency(now time.Time) uint32 {
+	return lfuDecay(atomic.LoadUint32(&v.lfuCounter), atomic.LoadInt64(&v.lastAccess), now.UnixNano())
+}
+
+// lfuDecay lowers a counter by one for every decay period of idleness
+func lfuDecay(counter uint32, last, now int64) uint32 {
+	if last == 0 || now <= last {
+		return counter
+	}
+	periods := uint32((now - last) / int64(lfuDecayMins*time.Minute))
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfuIncrement grows a counter with probability inversely proportional
+// to its current value so it approximates log(accesses)
+func lfuIncrement(counter uint32) uint32 {
+	if counter >= lfuMaxValue {
+		return lfuMaxValue
+	}
+	base := float64(0)
+	if counter > lfuInitValue {
+		base = float64(counter - lfuInitValue)
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		return counter + 1
+	}
+	return counter
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	ErrAggregateExists   = errors.New("aggregate already exists")
+	ErrAggregateNotFound = errs.Newf(errs.ErrNotFound, "aggregate not found")
+)
+
+// AggregateKind is what an aggregate computes over the keys it covers
+type AggregateKind string
+
+const (
+	AggregateCount AggregateKind = "count" // Keys, or keys with the hash field
+	AggregateSum   AggregateKind = "sum"   // Sum of the numeric values
+	AggregateMin   AggregateKind = "min"   // Smallest numeric value
+	AggregateMax   AggregateKind = "max"   // Largest numeric value
+)
+
+// ParseAggregateKind parses an aggregate kind, ignoring case
+func ParseAggregateKind(s string) (AggregateKind, error) {
+	switch kind := AggregateKind(strings.ToLower(s)); kind {
+	case AggregateCount, AggregateSum, AggregateMin, AggregateMax:
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown aggregate %q, expected count, sum, min or max", s)
+}
+
+// Aggregate is a count, sum, min or max over the keys matching Pattern,
+// kept up to date as they are written and stored as a string under Name.
+// With a Field it covers that field of hash values, and otherwise the
+// string values themselves. Sums, mins and maxes skip values that aren't
+// numbers.
+type Aggregate struct {
+	Name    string        `json:"name"`
+	Kind    AggregateKind `json:"kind"`
+	Pattern string        `json:"pattern"`
+	Field   string        `json:"field,omitempty"`
+
+	values  map[string]float64 // Value counted per key
+	sum     float64
+	extreme float64 // Current min or max, unless stale
+	stale   bool    // The extreme left and must be looked for again
+}
+
+// AggregateInfo describes an aggregate for listing purposes
+type AggregateInfo struct {
+	Name    string        `json:"name"`
+	Kind    AggregateKind `json:"kind"`
+	Pattern string        `json:"pattern"`
+	Field   string        `json:"field,omitempty"`
+	Keys    int           `json:"keys"`
+	Value   *string       `json:"value"` // nil for the min or max of no values
+}
+
+// aggregates holds the declared aggregates. Both are only touched under
+// the database's write lock.
+type aggregates struct {
+	byName map[string]*Aggregate
+	held   bool // Results are stored once a bulk update finishes
+}
+
+// CreateAggregate declares an aggregate of kind over the keys matching
+// pattern, or over field of those holding hashes, and stores its result
+// under name, replacing what the key held. Aggregates can't cover each
+// other's keys, so name must not match the pattern of any aggregate and
+// pattern must not match the name of any. Like indexes, aggregates aren't
+// saved in snapshots, though their keys are.
+func (db *Database) CreateAggregate(name string, kind AggregateKind, pattern, field string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.aggregates.byName[name]; exists {
+		return ErrAggregateExists
+	}
+	if MatchPattern(pattern, name) {
+		return fmt.Errorf("aggregate %q would cover its own key", name)
+	}
+	for _, other := range db.aggregates.byName {
+		if MatchPattern(pattern, other.Name) {
+			return fmt.Errorf("aggregate %q would cover the key of aggregate %q", name, other.Name)
+		}
+		if MatchPattern(other.Pattern, name) {
+			return fmt.Errorf("aggregate %q would be covered by aggregate %q", name, other.Name)
+		}
+	}
+
+	agg := &Aggregate{Name: name, Kind: kind, Pattern: pattern, Field: field}
+	agg.reset()
+	for key, value := range db.Data {
+		if !db.isExpired(value) {
+			agg.update(key, value)
+		}
+	}
+	if db.aggregates.byName == nil {
+		db.aggregates.byName = make(map[string]*Aggregate)
+	}
+	db.aggregates.byName[name] = agg
+	db.storeAggregate(agg)
+	return nil
+}
+
+// DropAggregate removes an aggregate along with its key
+func (db *Database) DropAggregate(name string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.aggregates.byName[name]; !exists {
+		return false
+	}
+	delete(db.aggregates.byName, name)
+	if value, exists := db.Data[name]; exists {
+		db.remove(name, value)
+	}
+	return true
+}
+
+// ListAggregates returns all declared aggregates with their results
+func (db *Database) ListAggregates() []AggregateInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	infos := make([]AggregateInfo, 0, len(db.aggregates.byName))
+	for _, agg := range db.aggregates.byName {
+		info := AggregateInfo{
+			Name:    agg.Name,
+			Kind:    agg.Kind,
+			Pattern: agg.Pattern,
+			Field:   agg.Field,
+			Keys:    len(agg.values),
+		}
+		if result, ok := agg.result(); ok {
+			info.Value = &result
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// updateAggregates counts a write in the aggregates covering key and
+// stores the results that changed. A nil value means the key was removed.
+// Callers must hold the write lock.
+func (db *Database) updateAggregates(key string, value *TriffValue) {
+	for _, agg := range db.aggregates.byName {
+		if agg.update(key, value) && !db.aggregates.held {
+			db.storeAggregate(agg)
+		}
+	}
+}
+
+// holdAggregates stops updateAggregates storing results, for loads that
+// write many keys at once. The returned func stores them all and resumes.
+// Callers must hold the write lock, and again when calling the func.
+func (db *Database) holdAggregates() func() {
+	db.aggregates.held = true
+	return func() {
+		db.aggregates.held = false
+		db.storeAggregates()
+	}
+}
+
+// resetAggregates empties every aggregate after the keyspace was cleared.
+// Callers must hold the write lock and store the results.
+func (db *Database) resetAggregates() {
+	for _, agg := range db.aggregates.byName {
+		agg.reset()
+	}
+}
+
+// storeAggregates writes every aggregate's result to its key. Callers
+// must hold the write lock.
+func (db *Database) storeAggregates() {
+	for _, agg := range db.aggregates.byName {
+		db.storeAggregate(agg)
+	}
+}
+
+// storeAggregate writes an aggregate's result to its key, or removes the
+// key when there is no result. Callers must hold the write lock.
+func (db *Database) storeAggregate(agg *Aggregate) {
+	result, ok := agg.result()
+	current, exists := db.Data[agg.Name]
+	if !ok {
+		if exists {
+			db.remove(agg.Name, current)
+		}
+		return
+	}
+	if exists && !db.isExpired(current) && current.Type == STRING && current.Data == result && current.TTL == 0 {
+		return
+	}
+	db.store(agg.Name, &TriffValue{Type: STRING, Data: result})
+}
+
+// update counts the value now stored under key, returning whether the
+// result may have changed
+func (agg *Aggregate) update(key string, value *TriffValue) bool {
+	if !MatchPattern(agg.Pattern, key) {
+		return false
+	}
+	old, had := agg.values[key]
+	n, ok := agg.measure(value)
+	if had == ok && old == n {
+		return false
+	}
+
+	if had {
+		delete(agg.values, key)
+		agg.sum -= old
+		if old == agg.extreme {
+			agg.stale = true
+		}
+	}
+	if ok {
+		agg.values[key] = n
+		agg.sum += n
+		if !agg.stale && (len(agg.values) == 1 || agg.better(n, agg.extreme)) {
+			agg.extreme = n
+		}
+	}
+	if len(agg.values) == 0 {
+		// Start over rather than keep what rounding left of the sum
+		agg.reset()
+	}
+	return true
+}
+
+// measure returns the number a value counts as, if the aggregate covers it
+func (agg *Aggregate) measure(value *TriffValue) (float64, bool) {
+	if value == nil {
+		return 0, false
+	}
+	var s string
+	if agg.Field != "" {
+		field, ok := hashField(value, agg.Field)
+		if !ok {
+			return 0, false
+		}
+		s = field
+	} else if str, ok := value.Data.(string); ok && value.Type == STRING {
+		s = str
+	} else if agg.Kind != AggregateCount {
+		return 0, false
+	}
+	if agg.Kind == AggregateCount {
+		return 1, true
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return n, true
+}
+
+// better reports whether a is a new extreme for a min or max over b
+func (agg *Aggregate) better(a, b float64) bool {
+	if agg.Kind == AggregateMin {
+		return a < b
+	}
+	return a > b
+}
+
+// result formats the aggregate's value, none for the min or max of no
+// values
+func (agg *Aggregate) result() (string, bool) {
+	switch agg.Kind {
+	case AggregateCount:
+		return strconv.Itoa(len(agg.values)), true
+	case AggregateSum:
+		return strconv.FormatFloat(agg.sum, 'f', -1, 64), true
+	}
+	if len(agg.values) == 0 {
+		return "", false
+	}
+	if agg.stale {
+		first := true
+		for _, n := range agg.values {
+			if first || agg.better(n, agg.extreme) {
+				agg.extreme = n
+				first = false
+			}
+		}
+		agg.stale = false
+	}
+	return strconv.FormatFloat(agg.extreme, 'f', -1, 64), true
+}
+
+// reset empties the aggregate
+func (agg *Aggregate) reset() {
+	agg.values = make(map[string]float64)
+	agg.sum = 0
+	agg.extreme = 0
+	agg.stale = false
+}
@@ -0,0 +1,87 @@
+package core
+
+import "unsafe"
+
+const (
+	// arenaBlockSize is the size of the blocks strings are packed into
+	arenaBlockSize = 1 << 20
+	// arenaMaxString is the longest string packed; longer ones are few
+	// enough to keep their own allocation
+	arenaMaxString = 4 << 10
+)
+
+// stringArena packs small strings into shared blocks, so that a keyspace
+// of millions of short keys and string values is a few hundred objects to
+// the garbage collector rather than millions. A packed string references
+// its block by offset; the block is freed once no string refers to it, so
+// bytes of overwritten values are only reclaimed with their whole block.
+// Callers must hold the database write lock.
+type stringArena struct {
+	block  []byte
+	blocks int64 // Blocks allocated since the last reset
+	packed int64 // Bytes packed since the last reset
+}
+
+// pack returns s copied into the arena, or s itself when it is empty or
+// too long to pack
+func (a *stringArena) pack(s string) string {
+	if len(s) == 0 || len(s) > arenaMaxString {
+		return s
+	}
+	if len(a.block)+len(s) > cap(a.block) {
+		a.block = make([]byte, 0, arenaBlockSize)
+		a.blocks++
+	}
+	offset := len(a.block)
+	// Bytes already handed out are never written again, so the string
+	// stays immutable
+	a.block = append(a.block, s...)
+	a.packed += int64(len(s))
+	return unsafe.String(&a.block[offset], len(s))
+}
+
+// reset starts a new block, leaving the old ones to be collected once the
+// strings in them are gone
+func (a *stringArena) reset() {
+	a.block = nil
+	a.blocks = 0
+	a.packed = 0
+}
+
+// packing reports whether string keys and values go into the arena.
+// Callers must hold the lock.
+func (db *Database) packing() bool {
+	return db.config != nil && db.config.Storage.StringArena
+}
+
+// pack moves a new key and a string value into the arena when
+// storage.string_arena is set, returning the key to store under. Callers
+// must hold the write lock.
+func (db *Database) pack(key string, isNew bool, value *TriffValue) string {
+	if !db.packing() {
+		return key
+	}
+	if isNew {
+		key = db.arena.pack(key)
+	}
+	if s, ok := value.Data.(string); ok && value.Type == STRING {
+		value.Data = db.arena.pack(s)
+	}
+	return key
+}
+
+// newKeyspace makes an empty keyspace map sized for storage.keys_hint
+// keys, or for n if that is more, so that filling it doesn't rehash
+func newKeyspace(config *Config, n int) map[string]*TriffValue {
+	if config != nil && config.Storage.KeysHint > n {
+		n = config.Storage.KeysHint
+	}
+	return make(map[string]*TriffValue, n)
+}
+
+// arenaInfo reports arena usage for Info. Callers must hold the read lock.
+func (db *Database) arenaInfo(info map[string]interface{}) {
+	info["string_arena"] = db.packing()
+	info["arena_blocks"] = db.arena.blocks
+	info["arena_packed_bytes"] = db.arena.packed
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	gcKeys = flag.Int("gc.keys", 1000000, "string keys BenchmarkGC loads")
+	gcSize = flag.Int("gc.size", 32, "bytes per value BenchmarkGC loads")
+)
+
+// BenchmarkGC loads the same keyspace with the default storage layout and
+// with storage.keys_hint and storage.string_arena, then times full garbage
+// collections over each. Besides the time per collection it reports the
+// heap objects the keyspace added, the stop-the-world pause per
+// collection and how long the load took:
+//
+//	go test ./core -run - -bench GC -gc.keys 2000000
+func BenchmarkGC(b *testing.B) {
+	for _, packed := range []bool{false, true} {
+		config := &Config{}
+		name := "default"
+		if packed {
+			config.Storage.KeysHint = *gcKeys
+			config.Storage.StringArena = true
+			name = "keys_hint+string_arena"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkGC(b, config, *gcKeys, *gcSize)
+		})
+	}
+}
+
+func benchmarkGC(b *testing.B, config *Config, keys, size int) {
+	runtime.GC()
+	var base runtime.MemStats
+	runtime.ReadMemStats(&base)
+
+	db := NewDatabase(config)
+	value := strings.Repeat("v", size)
+	start := time.Now()
+	for i := 0; i < keys; i++ {
+		// Each value gets its own allocation, as values read off the
+		// network do
+		data := fmt.Sprintf("%d:%s", i, value)[:size]
+		db.Set(fmt.Sprintf("key:%d", i), &TriffValue{Type: STRING, Data: data})
+	}
+	load := time.Since(start)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+	b.ReportMetric(float64(after.HeapObjects-base.HeapObjects), "heap-objects")
+	b.ReportMetric(load.Seconds(), "load-s")
+	runtime.KeepAlive(db)
+}
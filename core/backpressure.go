@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// backpressurePoll is how often a delayed write checks whether the queues
+// have drained
+const backpressurePoll = 5 * time.Millisecond
+
+// WriteQueue is a write-behind queue between the database and disk. While
+// one falls behind, the servers throttle writes instead of letting it grow.
+type WriteQueue interface {
+	// QueueDepth is the number of entries not yet written to disk
+	QueueDepth() int
+	// FlushLatency is how long the most recent write to disk took
+	FlushLatency() time.Duration
+}
+
+// WriteQueueStatus describes a registered write queue
+type WriteQueueStatus struct {
+	Name           string  `json:"name"`
+	Depth          int     `json:"depth"`
+	FlushLatencyMs float64 `json:"flush_latency_ms"`
+	Behind         bool    `json:"behind"`
+}
+
+// writeQueues holds the registered queues and throttling counters
+type writeQueues struct {
+	queues   map[string]WriteQueue
+	delayed  int64
+	rejected int64
+	mu       sync.RWMutex
+}
+
+// RegisterWriteQueue adds a queue whose backlog throttles writes,
+// replacing any queue registered under the same name
+func (db *Database) RegisterWriteQueue(name string, queue WriteQueue) {
+	db.writeQueues.mu.Lock()
+	defer db.writeQueues.mu.Unlock()
+
+	if db.writeQueues.queues == nil {
+		db.writeQueues.queues = make(map[string]WriteQueue)
+	}
+	db.writeQueues.queues[name] = queue
+}
+
+// WaitWritable applies backpressure before a write. While a queue is
+// behind it waits up to limits.backpressure.max_delay for the queue to
+// drain, then rejects the write with an errs.ErrBackpressure error.
+func (db *Database) WaitWritable(ctx context.Context) error {
+	db.mu.RLock()
+	var limits BackpressureConfig
+	if db.config != nil {
+		limits = db.config.Limits.Backpressure
+	}
+	db.mu.RUnlock()
+
+	if limits.MaxQueueDepth <= 0 && limits.MaxFlushLatency <= 0 {
+		return nil
+	}
+	queue, behind := db.behindQueue(limits)
+	if !behind {
+		return nil
+	}
+
+	if limits.MaxDelay > 0 {
+		atomic.AddInt64(&db.writeQueues.delayed, 1)
+		deadline := time.NewTimer(time.Duration(limits.MaxDelay) * time.Millisecond)
+		defer deadline.Stop()
+		poll := time.NewTicker(backpressurePoll)
+		defer poll.Stop()
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-deadline.C:
+				break wait
+			case <-poll.C:
+				if queue, behind = db.behindQueue(limits); !behind {
+					return nil
+				}
+			}
+		}
+	}
+
+	atomic.AddInt64(&db.writeQueues.rejected, 1)
+	return errs.Newf(errs.ErrBackpressure, "write rejected: %s queue has %d entries waiting for disk, last flush took %s",
+		queue.Name, queue.Depth, time.Duration(queue.FlushLatencyMs*float64(time.Millisecond)).Round(time.Millisecond))
+}
+
+// behindQueue returns the first queue over the limits, if any
+func (db *Database) behindQueue(limits BackpressureConfig) (WriteQueueStatus, bool) {
+	for _, queue := range db.queueStatuses(limits) {
+		if queue.Behind {
+			return queue, true
+		}
+	}
+	return WriteQueueStatus{}, false
+}
+
+// queueStatuses reports every registered queue, ordered by name. A queue
+// is behind when it is deeper than allowed, or when its last flush was too
+// slow and entries are still waiting; an empty queue is never behind.
+func (db *Database) queueStatuses(limits BackpressureConfig) []WriteQueueStatus {
+	db.writeQueues.mu.RLock()
+	defer db.writeQueues.mu.RUnlock()
+
+	statuses := make([]WriteQueueStatus, 0, len(db.writeQueues.queues))
+	for name, queue := range db.writeQueues.queues {
+		depth, latency := queue.QueueDepth(), queue.FlushLatency()
+		tooDeep := limits.MaxQueueDepth > 0 && depth > limits.MaxQueueDepth
+		tooSlow := limits.MaxFlushLatency > 0 && depth > 0 && latency > time.Duration(limits.MaxFlushLatency)*time.Millisecond
+		statuses = append(statuses, WriteQueueStatus{
+			Name:           name,
+			Depth:          depth,
+			FlushLatencyMs: float64(latency) / float64(time.Millisecond),
+			Behind:         tooDeep || tooSlow,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// WriteQueues reports the registered write queues against the configured
+// backpressure limits
+func (db *Database) WriteQueues() []WriteQueueStatus {
+	db.mu.RLock()
+	var limits BackpressureConfig
+	if db.config != nil {
+		limits = db.config.Limits.Backpressure
+	}
+	db.mu.RUnlock()
+
+	return db.queueStatuses(limits)
+}
+
+// backpressureInfo summarizes the write queues for Info. Callers must hold
+// the read lock.
+func (db *Database) backpressureInfo(info map[string]interface{}) {
+	var limits BackpressureConfig
+	if db.config != nil {
+		limits = db.config.Limits.Backpressure
+	}
+	depth, latency, throttled := 0, 0.0, false
+	for _, queue := range db.queueStatuses(limits) {
+		depth += queue.Depth
+		if queue.FlushLatencyMs > latency {
+			latency = queue.FlushLatencyMs
+		}
+		throttled = throttled || queue.Behind
+	}
+	info["write_queue_depth"] = depth
+	info["write_flush_latency_ms"] = latency
+	info["write_throttled"] = throttled
+	info["writes_delayed"] = atomic.LoadInt64(&db.writeQueues.delayed)
+	info["writes_rejected"] = atomic.LoadInt64(&db.writeQueues.rejected)
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+// JSON can only carry valid UTF-8 and replaces anything else, so data with
+// binary strings is written to JSON with every string base64-encoded,
+// flagged by "encoding": "base64" next to it.
+
+// BinaryData reports whether data holds a string, map key or element that
+// isn't valid UTF-8
+func BinaryData(data interface{}) bool {
+	binary := false
+	MapStrings(data, func(s string) (string, error) {
+		binary = binary || !utf8.ValidString(s)
+		return s, nil
+	})
+	return binary
+}
+
+// EncodeBinary base64-encodes every string in data
+func EncodeBinary(data interface{}) interface{} {
+	encoded, _ := MapStrings(data, func(s string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	})
+	return encoded
+}
+
+// DecodeBinary reverses EncodeBinary
+func DecodeBinary(data interface{}) (interface{}, error) {
+	return MapStrings(data, func(s string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		return string(decoded), err
+	})
+}
+
+// MapStrings returns a copy of data with fn applied to its strings: plain
+// strings, map keys, and the elements and values of the slices and maps
+// commands store and JSON decodes to. Other data is returned as is.
+func MapStrings(data interface{}, fn func(string) (string, error)) (interface{}, error) {
+	switch d := data.(type) {
+	case string:
+		return fn(d)
+	case PackedHash:
+		return MapStrings(d.Map(), fn)
+	case PackedList:
+		return MapStrings(d.Items(), fn)
+	case PackedSet:
+		return MapStrings(d.Members(), fn)
+	case map[string]struct{}:
+		mapped := make(map[string]struct{}, len(d))
+		for k := range d {
+			key, err := fn(k)
+			if err != nil {
+				return nil, err
+			}
+			mapped[key] = struct{}{}
+		}
+		return mapped, nil
+	case map[string]string:
+		mapped := make(map[string]string, len(d))
+		for k, v := range d {
+			key, err := fn(k)
+			if err != nil {
+				return nil, err
+			}
+			if mapped[key], err = fn(v); err != nil {
+				return nil, err
+			}
+		}
+		return mapped, nil
+	case map[string]float64:
+		mapped := make(map[string]float64, len(d))
+		for k, v := range d {
+			key, err := fn(k)
+			if err != nil {
+				return nil, err
+			}
+			mapped[key] = v
+		}
+		return mapped, nil
+	case map[string]interface{}:
+		mapped := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			key, err := fn(k)
+			if err != nil {
+				return nil, err
+			}
+			if mapped[key], err = MapStrings(v, fn); err != nil {
+				return nil, err
+			}
+		}
+		return mapped, nil
+	case []string:
+		mapped := make([]string, len(d))
+		for i, v := range d {
+			var err error
+			if mapped[i], err = fn(v); err != nil {
+				return nil, err
+			}
+		}
+		return mapped, nil
+	case []interface{}:
+		mapped := make([]interface{}, len(d))
+		for i, v := range d {
+			var err error
+			if mapped[i], err = MapStrings(v, fn); err != nil {
+				return nil, err
+			}
+		}
+		return mapped, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidCursor is returned by Browse for a cursor it did not produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// BrowseOptions filters, orders and pages a keyspace listing
+type BrowseOptions struct {
+	Cursor  string // Opaque cursor from a previous page, empty for the first page
+	Prefix  string // Only keys starting with Prefix
+	Pattern string // Only keys matching a glob pattern
+	Type    string // Only values of this type ("string", "hash", ...)
+	SortBy  string // "key" (default), "size", "ttl" or "updated_at"
+	Desc    bool
+	Limit   int // Entries per page
+	Preview int // Bytes of value preview to include, 0 for none
+}
+
+// BrowseEntry describes a key in a browse page
+type BrowseEntry struct {
+	Key       string    `json:"key"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	Length    int       `json:"length"`
+	TTL       int64     `json:"ttl"` // Remaining seconds, -1 without expiry
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Preview   string    `json:"preview,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Cursor    string    `json:"-"` // Resumes the listing after this entry
+}
+
+// BrowsePage is one page of Browse results
+type BrowsePage struct {
+	Entries    []BrowseEntry `json:"entries"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Total      int           `json:"total"` // Keys matching the filters
+}
+
+// Browse lists keys page by page. Each cursor records the sort position of
+// the last returned key, so keys written between calls don't shift pages.
+func (db *Database) Browse(opts BrowseOptions) (*BrowsePage, error) {
+	return db.BrowseContext(context.Background(), opts)
+}
+
+// BrowseContext is Browse that gives up once ctx is done
+func (db *Database) BrowseContext(ctx context.Context, opts BrowseOptions) (*BrowsePage, error) {
+	switch opts.SortBy {
+	case "":
+		opts.SortBy = "key"
+	case "key", "size", "ttl", "updated_at":
+	default:
+		return nil, fmt.Errorf("unknown sort field: %s", opts.SortBy)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	var after *browsePosition
+	if opts.Cursor != "" {
+		pos, err := decodeBrowseCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &pos
+	}
+
+	db.mu.RLock()
+	now := db.now().Unix()
+	type candidate struct {
+		key   string
+		value *TriffValue
+		rank  int64
+	}
+	candidates := make([]candidate, 0)
+	i := 0
+	for key, value := range db.Data {
+		i++
+		if err := scanAborted(ctx, i); err != nil {
+			db.mu.RUnlock()
+			return nil, err
+		}
+		if db.isExpired(value) {
+			continue
+		}
+		if opts.Prefix != "" && !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		if opts.Pattern != "" && !MatchPattern(opts.Pattern, key) {
+			continue
+		}
+		if opts.Type != "" && typeName(value.Type) != opts.Type {
+			continue
+		}
+		candidates = append(candidates, candidate{key, value, browseRank(opts.SortBy, value, now)})
+	}
+
+	less := func(a, b browsePosition) bool {
+		if opts.Desc {
+			a, b = b, a
+		}
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.Key < b.Key
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return less(browsePosition{candidates[i].rank, candidates[i].key}, browsePosition{candidates[j].rank, candidates[j].key})
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(candidates), func(i int) bool {
+			return less(*after, browsePosition{candidates[i].rank, candidates[i].key})
+		})
+	}
+	end := start + opts.Limit
+	if end > len(candidates) {
+		end = len(candidates)
+	}
+
+	page := &BrowsePage{
+		Entries: make([]BrowseEntry, 0, end-start),
+		Total:   len(candidates),
+	}
+	for _, c := range candidates[start:end] {
+		entry := BrowseEntry{
+			Key:       c.key,
+			Type:      typeName(c.value.Type),
+			Size:      c.value.size,
+			Length:    valueLength(c.value.Data),
+			TTL:       -1,
+			Version:   c.value.Version,
+			UpdatedAt: c.value.UpdatedAt,
+			Cursor:    encodeBrowseCursor(browsePosition{c.rank, c.key}),
+		}
+		if c.value.TTL > 0 {
+			entry.TTL = c.value.TTL - now
+		}
+		if opts.Preview > 0 {
+			entry.Preview, entry.Truncated = previewValue(c.value.Data, opts.Preview)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	db.mu.RUnlock()
+
+	if end < len(candidates) {
+		last := candidates[end-1]
+		page.NextCursor = encodeBrowseCursor(browsePosition{last.rank, last.key})
+	}
+	return page, nil
+}
+
+// browsePosition is a key's place in a sorted listing
+type browsePosition struct {
+	Rank int64
+	Key  string
+}
+
+// browseRank returns the numeric sort value of a value for a sort field.
+// Keys without a TTL sort after all expiring keys.
+func browseRank(sortBy string, value *TriffValue, now int64) int64 {
+	switch sortBy {
+	case "size":
+		return value.size
+	case "ttl":
+		if value.TTL <= 0 {
+			return math.MaxInt64
+		}
+		return value.TTL - now
+	case "updated_at":
+		return value.UpdatedAt.UnixNano()
+	}
+	return 0
+}
+
+func encodeBrowseCursor(pos browsePosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(pos.Rank, 10) + ":" + pos.Key))
+}
+
+func decodeBrowseCursor(cursor string) (browsePosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return browsePosition{}, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return browsePosition{}, ErrInvalidCursor
+	}
+	rank, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return browsePosition{}, ErrInvalidCursor
+	}
+	return browsePosition{Rank: rank, Key: parts[1]}, nil
+}
+
+// Len returns the byte length of a string value or the element count of a
+// collection
+func (v *TriffValue) Len() int {
+	return valueLength(v.Data)
+}
+
+// valueLength returns the byte length of strings and element count of collections
+func valueLength(data interface{}) int {
+	switch v := data.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case []string:
+		return len(v)
+	case []interface{}:
+		return len(v)
+	case map[string]string:
+		return len(v)
+	case PackedHash:
+		return v.Len()
+	case PackedList:
+		return v.Len()
+	case PackedSet:
+		return v.Len()
+	case map[string]struct{}:
+		return len(v)
+	case map[string]float64:
+		return len(v)
+	case map[string]interface{}:
+		return len(v)
+	case *BloomFilter:
+		return int(v.Items)
+	case *CountMinSketch:
+		return int(v.Total)
+	case *CuckooFilter:
+		return int(v.Items)
+	case *ORSet:
+		return len(v.Entries)
+	}
+	return 0
+}
+
+// previewValue renders at most limit bytes of a value, cut at a rune boundary
+func previewValue(data interface{}, limit int) (string, bool) {
+	var text string
+	switch v := data.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			text = fmt.Sprint(v)
+		} else {
+			text = string(encoded)
+		}
+	}
+
+	if len(text) <= limit {
+		return text, false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
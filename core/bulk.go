@@ -0,0 +1,218 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFinishedJobs bounds how many completed bulk jobs are kept for status queries
+const maxFinishedJobs = 100
+
+// BulkJob tracks a background operation over all keys matching a pattern
+// or having a tag
+type BulkJob struct {
+	ID         string    `json:"id"`
+	Operation  string    `json:"operation"`
+	Pattern    string    `json:"pattern,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Total      int64     `json:"total"`     // Keys matched when the job started
+	Processed  int64     `json:"processed"` // Keys visited so far
+	Updated    int64     `json:"updated"`   // Keys actually changed
+	Done       bool      `json:"done"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// BulkJobManager keeps running and recently finished bulk jobs
+type BulkJobManager struct {
+	jobs   map[string]*BulkJob
+	order  []string
+	nextID uint64
+	mu     sync.Mutex
+}
+
+// NewBulkJobManager creates an empty job registry
+func NewBulkJobManager() *BulkJobManager {
+	return &BulkJobManager{
+		jobs: make(map[string]*BulkJob),
+	}
+}
+
+func (m *BulkJobManager) add(operation, pattern, tag string, total int) *BulkJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	job := &BulkJob{
+		ID:        fmt.Sprintf("job-%d", m.nextID),
+		Operation: operation,
+		Pattern:   pattern,
+		Tag:       tag,
+		Total:     int64(total),
+		StartedAt: time.Now(),
+	}
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job.ID)
+	m.prune()
+	return job
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs. Callers must hold m.mu.
+func (m *BulkJobManager) prune() {
+	finished := 0
+	for _, id := range m.order {
+		if m.jobs[id].Done {
+			finished++
+		}
+	}
+	kept := m.order[:0]
+	for _, id := range m.order {
+		if finished > maxFinishedJobs && m.jobs[id].Done {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+// Get returns a snapshot of a job
+func (m *BulkJobManager) Get(id string) (BulkJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return BulkJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns snapshots of all known jobs, oldest first
+func (m *BulkJobManager) List() []BulkJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]BulkJob, 0, len(m.order))
+	for _, id := range m.order {
+		jobs = append(jobs, m.jobs[id].snapshot())
+	}
+	return jobs
+}
+
+func (m *BulkJobManager) finish(job *BulkJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.Done = true
+	job.FinishedAt = time.Now()
+	m.prune()
+}
+
+// snapshot copies the job, reading progress counters atomically
+func (job *BulkJob) snapshot() BulkJob {
+	snap := *job
+	snap.Processed = atomic.LoadInt64(&job.Processed)
+	snap.Updated = atomic.LoadInt64(&job.Updated)
+	return snap
+}
+
+// ExpirePattern starts a background job setting a TTL of seconds on every
+// key matching pattern. A seconds value of 0 or less removes the TTL
+// instead. Keys are processed in batches of batchSize with the write lock
+// released in between.
+func (db *Database) ExpirePattern(pattern string, seconds int64, batchSize int) BulkJob {
+	operation := "expire"
+	if seconds <= 0 {
+		operation = "persist"
+	}
+
+	// Bulk jobs outlive the request that started them
+	keys, _ := db.matchKeys(context.Background(), pattern)
+	job := db.jobs.add(operation, pattern, "", len(keys))
+	snapshot := job.snapshot()
+
+	go func() {
+		db.forEachBatch(context.Background(), keys, batchSize, func(key string, value *TriffValue) {
+			atomic.AddInt64(&job.Processed, 1)
+			if seconds > 0 {
+				value.TTL = db.now().Unix() + seconds
+			} else if value.TTL != 0 {
+				value.TTL = 0
+			} else {
+				return
+			}
+			atomic.AddInt64(&job.Updated, 1)
+			db.emitChange(ChangeTTL, key, value)
+		})
+		db.jobs.finish(job)
+	}()
+
+	return snapshot
+}
+
+// BulkJob returns the progress of a bulk job
+func (db *Database) BulkJob(id string) (BulkJob, bool) {
+	return db.jobs.Get(id)
+}
+
+// BulkJobs returns all running and recently finished bulk jobs
+func (db *Database) BulkJobs() []BulkJob {
+	return db.jobs.List()
+}
+
+// matchKeys returns the live keys matching a glob pattern, sorted
+func (db *Database) matchKeys(ctx context.Context, pattern string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	matched := make([]string, 0)
+	i := 0
+	for key, value := range db.Data {
+		i++
+		if err := scanAborted(ctx, i); err != nil {
+			return nil, err
+		}
+		if !db.isExpired(value) && MatchPattern(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// forEachBatch calls fn for each key that still exists, holding the write
+// lock for one batch at a time and yielding between batches. It stops
+// before the next batch once ctx is done.
+func (db *Database) forEachBatch(ctx context.Context, keys []string, batchSize int, fn func(key string, value *TriffValue)) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	for start := 0; start < len(keys); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		db.mu.Lock()
+		for _, key := range keys[start:end] {
+			// The key may have been deleted since the scan
+			if value, exists := db.Data[key]; exists && !db.isExpired(value) {
+				fn(key, value)
+			}
+		}
+		db.mu.Unlock()
+		runtime.Gosched()
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// defaultBulkBatch is how many pairs a bulk load applies per write lock
+const defaultBulkBatch = 10000
+
+// ErrBulkLoading is returned by Save while a bulk load is running; the
+// load saves once it finishes
+var ErrBulkLoading = errs.Newf(errs.ErrBackpressure, "snapshots are paused while a bulk load runs")
+
+// ErrLoaderClosed is returned when adding to a bulk loader after Close
+var ErrLoaderClosed = errors.New("bulk loader is closed")
+
+// BulkLoadOptions tunes a bulk load
+type BulkLoadOptions struct {
+	BatchSize int  // Pairs applied per write lock, 10000 when 0
+	Replace   bool // Overwrite existing keys instead of skipping them
+	NoSave    bool // Skip the snapshot taken once the load finishes
+}
+
+// BulkLoadResult counts what a bulk load did
+type BulkLoadResult struct {
+	Loaded   int  `json:"loaded"`
+	Skipped  int  `json:"skipped"`  // Existing keys kept, or values already expired
+	Rejected int  `json:"rejected"` // Values over a configured limit
+	Saved    bool `json:"saved"`
+}
+
+// BulkLoader ingests key/value pairs for an initial import much faster than
+// a loop of Set. Pairs are buffered and applied a batch per write lock;
+// indexes and full-text search are brought up to date once, when the
+// loader is closed, and snapshots are paused until then. Loaded keys skip
+// version history and access statistics, but change subscribers still see
+// a set per key. A BulkLoader is not safe for concurrent use.
+type BulkLoader struct {
+	db     *Database
+	opts   BulkLoadOptions
+	keys   []string
+	values []*TriffValue
+	loaded []string
+	result BulkLoadResult
+	closed bool
+}
+
+// NewBulkLoader starts a bulk load. Close must be called to finish it.
+func (db *Database) NewBulkLoader(opts BulkLoadOptions) *BulkLoader {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBulkBatch
+	}
+	atomic.AddInt32(&db.bulkLoads, 1)
+	return &BulkLoader{
+		db:     db,
+		opts:   opts,
+		keys:   make([]string, 0, opts.BatchSize),
+		values: make([]*TriffValue, 0, opts.BatchSize),
+	}
+}
+
+// BulkLoad loads data in one bulk load, as NewBulkLoader, Add and Close
+func (db *Database) BulkLoad(data map[string]*TriffValue, opts BulkLoadOptions) (BulkLoadResult, error) {
+	loader := db.NewBulkLoader(opts)
+	for key, value := range data {
+		if err := loader.Add(key, value); err != nil {
+			loader.Close()
+			return loader.result, err
+		}
+	}
+	return loader.Close()
+}
+
+// Add queues a pair, applying the batch once it is full
+func (l *BulkLoader) Add(key string, value *TriffValue) error {
+	if l.closed {
+		return ErrLoaderClosed
+	}
+	if value == nil {
+		l.result.Skipped++
+		return nil
+	}
+	l.keys = append(l.keys, key)
+	l.values = append(l.values, value)
+	if len(l.keys) >= l.opts.BatchSize {
+		l.flush()
+	}
+	return nil
+}
+
+// Close applies the last batch, updates indexes and search for the loaded
+// keys and, unless NoSave is set, saves a snapshot. Closing twice returns
+// the same result.
+func (l *BulkLoader) Close() (BulkLoadResult, error) {
+	if l.closed {
+		return l.result, nil
+	}
+	l.closed = true
+	l.flush()
+	l.reindex()
+
+	// With loads overlapping, the last one to finish saves for all
+	db := l.db
+	if atomic.AddInt32(&db.bulkLoads, -1) > 0 || l.opts.NoSave || l.result.Loaded == 0 {
+		return l.result, nil
+	}
+	db.mu.RLock()
+	engine := db.persistence
+	db.mu.RUnlock()
+	if engine == nil {
+		return l.result, nil
+	}
+	if err := db.Save(); err != nil {
+		return l.result, err
+	}
+	l.result.Saved = true
+	return l.result, nil
+}
+
+// flush applies the buffered pairs under one write lock. It does what
+// store does minus history, statistics and index maintenance.
+func (l *BulkLoader) flush() {
+	if len(l.keys) == 0 {
+		return
+	}
+	db := l.db
+
+	db.mu.Lock()
+	for i, key := range l.keys {
+		value := l.values[i]
+		old, exists := db.Data[key]
+		live := exists && !db.isExpired(old)
+		if (live && !l.opts.Replace) || db.isExpired(value) {
+			l.result.Skipped++
+			continue
+		}
+		if err := db.checkLimits(key, value); err != nil {
+			l.result.Rejected++
+			continue
+		}
+
+		value = db.detach(value)
+		value.UpdatedAt = db.now()
+		db.stampPartition(key, value)
+		if live {
+			value.Version = old.Version + 1
+			value.CreatedAt = old.CreatedAt
+			value.initAccess(old, value.UpdatedAt)
+		} else {
+			value.Version = 1
+			value.CreatedAt = value.UpdatedAt
+			value.initAccess(nil, value.UpdatedAt)
+		}
+		if exists {
+			db.memoryUsed -= old.size
+			db.memoryByType[old.Type] -= old.size
+		}
+		db.compact(value)
+		value.size = SizeOf(key, value)
+		db.memoryUsed += value.size
+		db.memoryByType[value.Type] += value.size
+
+		key = db.pack(key, !exists, value)
+		db.Data[key] = value
+		db.order.add(key)
+		db.trackPartition(key, value)
+		db.emitChange(ChangeSet, key, value)
+		l.loaded = append(l.loaded, key)
+		l.result.Loaded++
+	}
+	db.mu.Unlock()
+	runtime.Gosched()
+
+	l.keys = l.keys[:0]
+	l.values = l.values[:0]
+}
+
+// reindex brings indexes and search up to date for the loaded keys, a
+// batch per write lock. Keys written or deleted since they were loaded
+// were indexed by that write, and updating them again is harmless.
+func (l *BulkLoader) reindex() {
+	db := l.db
+	for start := 0; start < len(l.loaded); start += l.opts.BatchSize {
+		end := start + l.opts.BatchSize
+		if end > len(l.loaded) {
+			end = len(l.loaded)
+		}
+
+		db.mu.Lock()
+		release := db.holdAggregates()
+		for _, key := range l.loaded[start:end] {
+			if value, exists := db.Data[key]; exists {
+				db.indexes.Update(key, value)
+				db.search.Update(key, value)
+				db.tags.update(key, tagsOf(value))
+				db.dependents.update(key, dependenciesOf(value))
+				db.updateAggregates(key, value)
+			}
+		}
+		release()
+		db.mu.Unlock()
+		runtime.Gosched()
+	}
+	l.loaded = nil
+}
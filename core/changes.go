@@ -0,0 +1,90 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChangeOp identifies the kind of write a ChangeEvent describes
+type ChangeOp string
+
+const (
+	ChangeSet    ChangeOp = "set"    // A value was written
+	ChangeTTL    ChangeOp = "ttl"    // A key's expiry was changed or removed
+	ChangeTag    ChangeOp = "tag"    // Tags were added to or removed from a key
+	ChangeDepend ChangeOp = "depend" // Dependencies were added to or removed from a key
+	ChangeDelete ChangeOp = "del"    // A key was deleted
+	ChangeExpire ChangeOp = "expire" // A key was removed because its TTL passed
+	ChangeFlush  ChangeOp = "flush"  // All keys were removed
+)
+
+// ChangeEvent describes one committed write. Seq increases with every event
+// for the lifetime of the database.
+type ChangeEvent struct {
+	Seq       uint64      `json:"seq"`
+	Op        ChangeOp    `json:"op"`
+	Key       string      `json:"key,omitempty"`
+	Type      string      `json:"type,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	TTL       int64       `json:"ttl,omitempty"` // Unix seconds, 0 if the key doesn't expire
+	Tags      []string    `json:"tags,omitempty"`
+	DependsOn []string    `json:"depends_on,omitempty"`
+	Version   uint64      `json:"version,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// ChangeListener receives committed writes. Listeners run synchronously
+// while the database lock is held, so they must return quickly and must
+// not call back into the database.
+type ChangeListener func(event ChangeEvent)
+
+// changeFeed fans change events out to listeners
+type changeFeed struct {
+	seq       uint64
+	listeners []ChangeListener
+	mu        sync.RWMutex
+}
+
+// OnChange registers a listener for every subsequent write
+func (db *Database) OnChange(listener ChangeListener) {
+	db.changes.mu.Lock()
+	defer db.changes.mu.Unlock()
+
+	db.changes.listeners = append(db.changes.listeners, listener)
+}
+
+// emitChange notifies listeners of a write. value is nil for removals of
+// keys and for flushes.
+func (db *Database) emitChange(op ChangeOp, key string, value *TriffValue) {
+	// Seq counts every write, listened to or not, since session tokens
+	// are taken from it
+	seq := atomic.AddUint64(&db.changes.seq, 1)
+
+	db.changes.mu.RLock()
+	defer db.changes.mu.RUnlock()
+
+	if len(db.changes.listeners) == 0 {
+		return
+	}
+
+	event := ChangeEvent{
+		Seq:  seq,
+		Op:   op,
+		Key:  key,
+		Time: time.Now(),
+	}
+	if value != nil {
+		event.Type = typeName(value.Type)
+		event.TTL = value.TTL
+		event.Tags = value.Tags
+		event.DependsOn = value.DependsOn
+		event.Version = value.Version
+		if op == ChangeSet {
+			event.Value = value.Data
+		}
+	}
+	for _, listener := range db.changes.listeners {
+		listener(event)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the time TTLs, expiry, time partitions, tombstones and
+// auto-saves go by. The default, SystemClock, is the real time; tests and
+// simulations can give a Database or storage engine a FakeClock instead,
+// and move it forward rather than sleeping.
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed on
+	// this clock
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the real time
+var SystemClock Clock = systemClock{}
+
+// FakeClock is a Clock that only moves when told to. It is safe for
+// concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock creates a FakeClock stopped at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the time the clock is stopped at
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives once the clock is moved d or more
+// forward. A d of 0 or less fires at once.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the After channels that
+// come due in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing the After channels due by then. Setting
+// it back in time fires nothing.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	fired := 0
+	for _, w := range c.waiters {
+		if w.at.After(t) {
+			break
+		}
+		w.ch <- t
+		fired++
+	}
+	c.waiters = c.waiters[fired:]
+}
+
+// Waiters returns how many After channels have yet to fire, so a test can
+// wait for a goroutine to start waiting before it advances the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// SetClock makes the database go by clock for TTLs, expiry, time
+// partitions, tombstones and save points, e.g. a FakeClock in tests. Set it before the
+// database is in use: values already written keep the times they got. A
+// nil clock restores SystemClock.
+func (db *Database) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clock = clock
+	db.queues.setClock(clock)
+	atomic.StoreInt64(&db.lastSave, clock.Now().UnixNano())
+}
+
+// Clock returns the clock the database goes by
+func (db *Database) Clock() Clock {
+	return db.clock
+}
+
+func (db *Database) now() time.Time {
+	return db.clock.Now()
+}
@@ -0,0 +1,103 @@
+package core
+
+import "sync/atomic"
+
+// Clone returns a deep copy of the value, including its access metadata.
+// Data of types the database does not know is shared.
+func (v *TriffValue) Clone() *TriffValue {
+	clone := &TriffValue{
+		Type:      v.Type,
+		Data:      cloneData(v.Data),
+		TTL:       v.TTL,
+		Version:   v.Version,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+		Tags:      v.Tags,
+		DependsOn: v.DependsOn,
+		Cap:       v.Cap,
+		size:      v.size,
+	}
+	atomic.StoreInt64(&clone.lastAccess, atomic.LoadInt64(&v.lastAccess))
+	atomic.StoreUint32(&clone.lfuCounter, atomic.LoadUint32(&v.lfuCounter))
+	return clone
+}
+
+// header returns a copy of the value that shares its data. Writes replace
+// data rather than change it in place, so the copy stays as it is when the
+// stored value's expiry changes or the key is overwritten.
+func (v *TriffValue) header() *TriffValue {
+	header := &TriffValue{
+		Type:      v.Type,
+		Data:      v.Data,
+		TTL:       v.TTL,
+		Version:   v.Version,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+		Tags:      v.Tags,
+		DependsOn: v.DependsOn,
+		Cap:       v.Cap,
+		size:      v.size,
+	}
+	atomic.StoreInt64(&header.lastAccess, atomic.LoadInt64(&v.lastAccess))
+	atomic.StoreUint32(&header.lfuCounter, atomic.LoadUint32(&v.lfuCounter))
+	return header
+}
+
+// cloneData deep copies the data types stored by commands and decoded from
+// JSON. Strings and numbers are immutable and returned as is.
+func cloneData(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]string:
+		clone := make(map[string]string, len(d))
+		for k, v := range d {
+			clone[k] = v
+		}
+		return clone
+	case map[string]struct{}:
+		clone := make(map[string]struct{}, len(d))
+		for k := range d {
+			clone[k] = struct{}{}
+		}
+		return clone
+	case map[string]float64:
+		clone := make(map[string]float64, len(d))
+		for k, v := range d {
+			clone[k] = v
+		}
+		return clone
+	case map[string]interface{}:
+		clone := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			clone[k] = cloneData(v)
+		}
+		return clone
+	case []string:
+		return append([]string(nil), d...)
+	case []interface{}:
+		clone := make([]interface{}, len(d))
+		for i, v := range d {
+			clone[i] = cloneData(v)
+		}
+		return clone
+	case *BloomFilter:
+		return d.Clone()
+	case *CountMinSketch:
+		return d.Clone()
+	case *CuckooFilter:
+		return d.Clone()
+	case *PNCounter:
+		return d.Clone()
+	case *ORSet:
+		return d.Clone()
+	}
+	return data
+}
+
+// detach returns the copy of value that crosses the API boundary, or value
+// itself when storage.zero_copy is set. Callers must hold the lock.
+func (db *Database) detach(value *TriffValue) *TriffValue {
+	if db.config != nil && db.config.Storage.ZeroCopy {
+		return value
+	}
+	return value.Clone()
+}
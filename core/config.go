@@ -0,0 +1,424 @@
+package core
+
+// Config holds database configuration, grouped by subsystem
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Persistence PersistenceConfig `yaml:"persistence"`
+	Security    SecurityConfig    `yaml:"security"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Proxy       ProxyConfig       `yaml:"proxy"`
+	CDC         CDCConfig         `yaml:"cdc"`
+	Sync        SyncConfig        `yaml:"sync"`
+	Usage       UsageConfig       `yaml:"usage"`
+	Webhooks    []WebhookConfig   `yaml:"webhooks"`
+	Debug       DebugConfig       `yaml:"debug"`
+}
+
+// ServerConfig configures the network listeners
+type ServerConfig struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	HTTPPort     int    `yaml:"http_port"`
+	EnableTCP    bool   `yaml:"enable_tcp"`
+	EnableHTTP   bool   `yaml:"enable_http"`
+	ReadTimeout  int64  `yaml:"read_timeout"`  // Seconds, 0 disables the timeout
+	WriteTimeout int64  `yaml:"write_timeout"` // Seconds, 0 disables the timeout
+
+	// ReadOnly rejects writes; Maintenance only serves admin commands to
+	// admin clients. Both can be switched with CONFIG SET.
+	ReadOnly    bool `yaml:"read_only"`
+	Maintenance bool `yaml:"maintenance"`
+
+	// Frontend is how the TCP server waits on its connections: "goroutine"
+	// (the default) reads each connection on its own goroutine,
+	// "eventloop" waits on all of them with epoll and only takes a
+	// goroutine and buffers while a connection has commands to run.
+	// The event loop is only available on Linux.
+	Frontend string `yaml:"frontend"`
+
+	// SessionWait is how long, in milliseconds, a request holding a
+	// session token waits for a replica to apply the write it names
+	SessionWait int64 `yaml:"session_wait"`
+
+	// Replica marks a server kept in step with a primary by whatever
+	// applies the primary's changes. It isn't ready for traffic until that
+	// has caught up to within ReadyMaxLag changes (see Database.Readiness).
+	Replica     bool  `yaml:"replica"`
+	ReadyMaxLag int64 `yaml:"ready_max_lag"`
+
+	Workers WorkersConfig `yaml:"workers"`
+	CORS    CORSConfig    `yaml:"cors"`
+}
+
+// WorkersConfig moves TCP command execution off the connection goroutines
+// onto a bounded pool. A connection still waits for each reply before it
+// runs its next command, so its commands keep their order.
+type WorkersConfig struct {
+	Count     int `yaml:"count"`      // Commands run at once, 0 runs them on their connection's goroutine
+	QueueSize int `yaml:"queue_size"` // Commands waiting for a worker before more are refused, 0 for Count
+
+	// CommandConcurrency caps how many of a command run at once, keyed by
+	// upper-case command name, whether or not the pool is enabled
+	CommandConcurrency map[string]int `yaml:"command_concurrency"`
+}
+
+// CORSConfig controls which browser origins may call the REST API. Routes
+// override the policy under a path prefix, the longest matching prefix
+// winning; fields a route leaves unset keep the global value.
+type CORSConfig struct {
+	CORSPolicy `yaml:",inline"`
+	Routes     []CORSRoute `yaml:"routes"`
+}
+
+// CORSPolicy is the cross-origin policy of a set of routes
+type CORSPolicy struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"` // "*", exact origins or subdomain patterns like https://*.example.com; empty disables CORS
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`   // Request headers, "*" allows any
+	ExposedHeaders   []string `yaml:"exposed_headers"`   // Response headers scripts may read
+	AllowCredentials *bool    `yaml:"allow_credentials"` // Cookies and Authorization; needs explicit origins
+	MaxAge           *int64   `yaml:"max_age"`           // Seconds browsers may cache a preflight
+}
+
+// CORSRoute overrides the CORS policy of the routes under Prefix, an
+// /api/v1 path that also covers its /api/v2 twin
+type CORSRoute struct {
+	Prefix     string `yaml:"prefix"`
+	CORSPolicy `yaml:",inline"`
+}
+
+// StorageConfig configures the keyspace and storage engine
+type StorageConfig struct {
+	Engine        string         `yaml:"engine"` // "memory" or "disk"
+	MaxMemory     int64          `yaml:"max_memory"`
+	StaleGrace    int64          `yaml:"stale_grace"`    // Seconds an expired value stays readable as stale
+	StatsPrefixes []string       `yaml:"stats_prefixes"` // Key prefixes to aggregate access statistics by
+	History       map[string]int `yaml:"history"`        // Key prefix to number of previous versions kept
+	SoftDelete    int64          `yaml:"soft_delete"`    // Seconds deleted keys stay restorable, 0 deletes immediately
+	TTLJitter     int            `yaml:"ttl_jitter"`     // Percent a TTL is shortened by at most, at random, on writes that ask for jitter
+
+	// DependencyDepth bounds how many keys deep a write invalidates keys
+	// that depend on it: a dependency that would make a longer chain is
+	// refused. 0 means no limit.
+	DependencyDepth int `yaml:"dependency_depth"`
+
+	// ZeroCopy hands stored values to callers, and stores the values they
+	// pass, without copying them. It saves allocations on large hashes and
+	// sketches, but callers must then never modify a value after Set or
+	// one returned by Get.
+	ZeroCopy bool `yaml:"zero_copy"`
+
+	// KeysHint is the number of keys expected. The keyspace map is sized
+	// for it up front, so loading a large keyspace doesn't rehash it.
+	KeysHint int `yaml:"keys_hint"`
+
+	// StringArena packs keys and string values up to 4 KiB into shared
+	// 1 MiB blocks. Millions of small strings then cost the garbage
+	// collector a few hundred objects, shortening GC pauses; the bytes of
+	// overwritten or deleted values are only freed with their whole block.
+	StringArena bool `yaml:"string_arena"`
+
+	// Compact sets how small hashes, lists and sets must be to be packed
+	Compact CompactConfig `yaml:"compact"`
+
+	// Partitions split keys into time partitions that are dropped whole
+	// once their retention passes (see PartitionConfig)
+	Partitions []PartitionConfig `yaml:"partitions"`
+
+	// NodeID names this instance in CRDT values. It must differ between
+	// instances that replicate to each other; a random ID is used if empty.
+	NodeID string `yaml:"node_id"`
+
+	// NodeIDFile keeps a random node ID across restarts: utils.LoadNodeID
+	// reads it from this file, or writes a new one there
+	NodeIDFile string `yaml:"node_id_file"`
+}
+
+// CompactConfig sets the size limits up to which hashes, lists and sets
+// are stored packed (see PackedHash). Past either limit they are stored
+// as maps and slices. A max_entries of 0 never packs.
+type CompactConfig struct {
+	HashMaxEntries int `yaml:"hash_max_entries"` // Most fields of a packed hash
+	HashMaxValue   int `yaml:"hash_max_value"`   // Longest field or value of a packed hash, in bytes
+	ListMaxEntries int `yaml:"list_max_entries"`
+	ListMaxValue   int `yaml:"list_max_value"`
+	SetMaxEntries  int `yaml:"set_max_entries"`
+	SetMaxValue    int `yaml:"set_max_value"`
+}
+
+// PartitionConfig groups the keys named Prefix:<period start>[:...] into
+// one partition per hour or day, e.g. logs:2024-06-01:req-17 with Period
+// "day". A partition is dropped Retain periods after its start, so
+// Retain 7 keeps the current day and the six before it.
+type PartitionConfig struct {
+	Prefix string `yaml:"prefix"`
+	Period string `yaml:"period"` // "hour" (2006-01-02T15) or "day" (2006-01-02), in UTC
+	Retain int    `yaml:"retain"`
+}
+
+// PersistenceConfig configures snapshots to disk
+type PersistenceConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Path         string `yaml:"path"`
+	SaveInterval int64  `yaml:"save_interval"` // Seconds between automatic snapshots, unless Save is set
+	Recovery     string `yaml:"recovery"`      // Damaged snapshot handling: "strict" (default), "backup" or "skip"
+	Generations  int    `yaml:"generations"`   // Older snapshots kept as restore points
+
+	// Save takes a snapshot when any of its points is reached. Empty, one
+	// is taken every SaveInterval seconds if anything changed (see
+	// SavePoints).
+	Save []SavePoint `yaml:"save"`
+
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+// EncryptionConfig configures AES-256-GCM encryption of snapshot files.
+// Keys are 32 bytes, written as base64 or hex.
+type EncryptionConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Key        string   `yaml:"key"`         // Key new files are encrypted with
+	KeyCommand string   `yaml:"key_command"` // Shell command printing the key, e.g. a KMS decrypt; used when key is empty
+	OldKeys    []string `yaml:"old_keys"`    // Retired keys, still accepted when reading
+}
+
+// SecurityConfig configures authentication and transport encryption
+type SecurityConfig struct {
+	Password string `yaml:"password"`
+	TLSCert  string `yaml:"tls_cert"` // Certificate file, or the PEM itself
+	TLSKey   string `yaml:"tls_key"`  // Private key file, or the PEM itself
+
+	Flush FlushConfig `yaml:"flush"`
+
+	// RenameCommands renames TCP commands, e.g. CONFIG to a name only
+	// operators know; an empty new name disables the command. The REST
+	// endpoints of renamed and disabled commands are closed.
+	RenameCommands map[string]string `yaml:"rename_commands"`
+
+	// APIKeys identify the clients of a shared instance for usage
+	// accounting and quotas
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+}
+
+// APIKeyConfig is a client of a shared instance. TCP clients present the
+// key with AUTH, REST clients in an X-API-Key header.
+type APIKeyConfig struct {
+	Name          string `yaml:"name"`           // Reported in usage, e.g. a team or service
+	Key           string `yaml:"key"`            // Secret the client presents
+	Namespace     string `yaml:"namespace"`      // Key prefix whose storage is billed to it
+	DailyCommands int64  `yaml:"daily_commands"` // Commands per UTC day, 0 for no quota
+	DailyBytes    int64  `yaml:"daily_bytes"`    // Request plus response bytes per UTC day, 0 for no quota
+}
+
+// FlushConfig puts interlocks on FLUSHALL and DELETE /api/v1/flush, which
+// wipe every key in one request
+type FlushConfig struct {
+	Disabled     bool  `yaml:"disabled"`      // Refuse every flush
+	RequireAdmin bool  `yaml:"require_admin"` // Only clients authenticated with security.password may flush
+	Confirm      bool  `yaml:"confirm"`       // A flush only returns a token; repeating it with the token flushes
+	ConfirmTTL   int64 `yaml:"confirm_ttl"`   // Seconds a confirmation token stays valid
+}
+
+// LimitsConfig bounds resource usage. Zero means unlimited.
+type LimitsConfig struct {
+	MaxClients   int   `yaml:"max_clients"`
+	MaxKeySize   int   `yaml:"max_key_size"`
+	MaxValueSize int64 `yaml:"max_value_size"`
+	MaxKeys      int   `yaml:"max_keys"`
+	MaxElements  int   `yaml:"max_elements"` // Fields or members of one hash, list, set or sorted set
+
+	// CommandTimeout is the longest a single command may run, in
+	// milliseconds. Scans (KEYS, DELPATTERN, QUERY, browsing) stop and
+	// report a timeout once it passes.
+	CommandTimeout int64 `yaml:"command_timeout"`
+
+	// CommandTimeouts overrides CommandTimeout for individual commands,
+	// keyed by upper-case command name
+	CommandTimeouts map[string]int64 `yaml:"command_timeouts"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Backpressure   BackpressureConfig   `yaml:"backpressure"`
+}
+
+// BackpressureConfig throttles writes while a write-behind queue, such as
+// the CDC journal buffer, falls behind the disk
+type BackpressureConfig struct {
+	MaxQueueDepth   int   `yaml:"max_queue_depth"`   // Queued entries above which writes are throttled, 0 for no limit
+	MaxFlushLatency int64 `yaml:"max_flush_latency"` // Milliseconds a flush to disk may take before writes are throttled, 0 for no limit
+	MaxDelay        int64 `yaml:"max_delay"`         // Milliseconds a throttled write waits for the queue to drain before it is rejected, 0 rejects at once
+}
+
+// CircuitBreakerConfig rejects a command and pattern that keep timing out
+// with a fast error instead of letting them hold up the server
+type CircuitBreakerConfig struct {
+	Threshold int   `yaml:"threshold"` // Timeouts within Window that open the circuit, 0 disables
+	Window    int64 `yaml:"window"`    // Seconds
+	Cooldown  int64 `yaml:"cooldown"`  // Seconds the circuit stays open before one trial run
+}
+
+// LoggingConfig configures the logger
+type LoggingConfig struct {
+	Level            string `yaml:"level"`
+	Format           string `yaml:"format"`            // "text" or "json"
+	SlowlogThreshold int64  `yaml:"slowlog_threshold"` // Microseconds after which a command is logged as slow
+	LatencyThreshold int64  `yaml:"latency_threshold"` // Milliseconds after which a command is recorded as a latency spike, 0 disables
+	File             string `yaml:"file"`              // Log file, empty logs to stdout
+	MaxSize          int64  `yaml:"max_size"`          // Megabytes before a log file is rotated
+	MaxAge           int64  `yaml:"max_age"`           // Hours before a log file is rotated
+	MaxBackups       int    `yaml:"max_backups"`       // Rotated files to keep
+	AuditFile        string `yaml:"audit_file"`        // Audit log of write commands, empty disables auditing
+	AdminAuditFile   string `yaml:"admin_audit_file"`  // Hash-chained log of admin operations, empty disables it
+}
+
+// TracingConfig configures OpenTelemetry trace export
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"` // OTLP/HTTP collector, e.g. http://localhost:4318
+	ServiceName string  `yaml:"service_name"`
+	SampleRate  float64 `yaml:"sample_rate"` // Fraction of new traces to record, 0-1
+}
+
+// ProxyConfig configures the caching reverse proxy
+type ProxyConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Port        int               `yaml:"port"`
+	Upstreams   map[string]string `yaml:"upstreams"`     // Path prefix to upstream base URL
+	DefaultTTL  int64             `yaml:"default_ttl"`   // Seconds to cache responses without freshness headers, 0 to skip them
+	MaxBodySize int64             `yaml:"max_body_size"` // Largest response body cached, in bytes
+}
+
+// CDCConfig configures change data capture to an external system
+type CDCConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Sink      string   `yaml:"sink"`       // "webhook", "kafka", "nats" or "file"
+	URL       string   `yaml:"url"`        // Webhook URL, Kafka REST proxy URL, NATS address or output file
+	Topic     string   `yaml:"topic"`      // Kafka topic or NATS subject
+	Journal   string   `yaml:"journal"`    // Directory holding the event journal and delivery checkpoint
+	BatchSize int      `yaml:"batch_size"` // Events delivered per request
+	Prefixes  []string `yaml:"prefixes"`   // Only capture keys with these prefixes, all keys if empty
+}
+
+// SyncConfig configures offline sync. An embedded database syncs the keys
+// under its scopes with a central server's REST API; the server side only
+// uses LogSize.
+type SyncConfig struct {
+	Enabled  bool        `yaml:"enabled"`
+	URL      string      `yaml:"url"`      // Base URL of the central server, e.g. http://central:8080
+	Dir      string      `yaml:"dir"`      // Directory holding the sync state and unsynced keys
+	Interval int64       `yaml:"interval"` // Seconds between sync attempts
+	Scopes   []SyncScope `yaml:"scopes"`
+	LogSize  int         `yaml:"log_size"` // Server: changed keys remembered for incremental pulls
+}
+
+// SyncScope is a key prefix kept in sync and how
+type SyncScope struct {
+	Prefix   string `yaml:"prefix"`
+	Mode     string `yaml:"mode"`     // "both" (default), "push" or "pull"
+	Conflict string `yaml:"conflict"` // "server" (default), "client" or "newest"
+}
+
+// UsageConfig configures accounting of commands, bandwidth and storage per
+// API key in daily rollups
+type UsageConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Path           string `yaml:"path"`            // JSON file the rollups are kept in
+	Retention      int    `yaml:"retention"`       // Days of rollups kept
+	SampleInterval int64  `yaml:"sample_interval"` // Seconds between storage samples and saves
+}
+
+// WebhookConfig defines a webhook that is registered at startup
+type WebhookConfig struct {
+	Pattern string   `yaml:"pattern"` // Glob pattern of keys to watch
+	URL     string   `yaml:"url"`
+	Events  []string `yaml:"events"` // "set", "del" and/or "expire", all if empty
+	Secret  string   `yaml:"secret"` // Signs payloads with HMAC-SHA256 when set
+}
+
+// DebugConfig configures the debug listener serving pprof profiles and
+// expvar counters
+type DebugConfig struct {
+	Enabled              bool   `yaml:"enabled"`
+	Address              string `yaml:"address"`                // host:port, loopback by default
+	Token                string `yaml:"token"`                  // Bearer token required by the listener, security.password if empty
+	MutexProfileFraction int    `yaml:"mutex_profile_fraction"` // Report 1/n mutex contention events, 0 disables
+	BlockProfileRate     int    `yaml:"block_profile_rate"`     // Sample one blocking event per n nanoseconds blocked, 0 disables
+}
+
+// Clone returns a deep copy of the configuration
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Storage.StatsPrefixes = append([]string(nil), c.Storage.StatsPrefixes...)
+	clone.Storage.Partitions = append([]PartitionConfig(nil), c.Storage.Partitions...)
+	clone.CDC.Prefixes = append([]string(nil), c.CDC.Prefixes...)
+	clone.Sync.Scopes = append([]SyncScope(nil), c.Sync.Scopes...)
+	clone.Security.APIKeys = append([]APIKeyConfig(nil), c.Security.APIKeys...)
+	clone.Server.CORS.CORSPolicy = c.Server.CORS.CORSPolicy.Clone()
+	if c.Server.CORS.Routes != nil {
+		clone.Server.CORS.Routes = make([]CORSRoute, len(c.Server.CORS.Routes))
+		for i, route := range c.Server.CORS.Routes {
+			clone.Server.CORS.Routes[i] = CORSRoute{Prefix: route.Prefix, CORSPolicy: route.CORSPolicy.Clone()}
+		}
+	}
+	clone.Persistence.Encryption.OldKeys = append([]string(nil), c.Persistence.Encryption.OldKeys...)
+	clone.Persistence.Save = append([]SavePoint(nil), c.Persistence.Save...)
+	if c.Webhooks != nil {
+		clone.Webhooks = make([]WebhookConfig, len(c.Webhooks))
+		for i, hook := range c.Webhooks {
+			clone.Webhooks[i] = hook
+			clone.Webhooks[i].Events = append([]string(nil), hook.Events...)
+		}
+	}
+	if c.Storage.History != nil {
+		clone.Storage.History = make(map[string]int, len(c.Storage.History))
+		for prefix, depth := range c.Storage.History {
+			clone.Storage.History[prefix] = depth
+		}
+	}
+	if c.Limits.CommandTimeouts != nil {
+		clone.Limits.CommandTimeouts = make(map[string]int64, len(c.Limits.CommandTimeouts))
+		for command, timeout := range c.Limits.CommandTimeouts {
+			clone.Limits.CommandTimeouts[command] = timeout
+		}
+	}
+	if c.Security.RenameCommands != nil {
+		clone.Security.RenameCommands = make(map[string]string, len(c.Security.RenameCommands))
+		for command, name := range c.Security.RenameCommands {
+			clone.Security.RenameCommands[command] = name
+		}
+	}
+	if c.Proxy.Upstreams != nil {
+		clone.Proxy.Upstreams = make(map[string]string, len(c.Proxy.Upstreams))
+		for prefix, target := range c.Proxy.Upstreams {
+			clone.Proxy.Upstreams[prefix] = target
+		}
+	}
+	return &clone
+}
+
+// Clone returns a deep copy of the policy. Empty lists stay empty rather
+// than nil, since a route's empty list overrides the global one.
+func (p CORSPolicy) Clone() CORSPolicy {
+	clone := p
+	clone.AllowedOrigins = cloneStrings(p.AllowedOrigins)
+	clone.AllowedMethods = cloneStrings(p.AllowedMethods)
+	clone.AllowedHeaders = cloneStrings(p.AllowedHeaders)
+	clone.ExposedHeaders = cloneStrings(p.ExposedHeaders)
+	if p.AllowCredentials != nil {
+		credentials := *p.AllowCredentials
+		clone.AllowCredentials = &credentials
+	}
+	if p.MaxAge != nil {
+		maxAge := *p.MaxAge
+		clone.MaxAge = &maxAge
+	}
+	return clone
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
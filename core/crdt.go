@@ -0,0 +1,201 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+)
+
+// CRDT values converge when two databases that accept writes independently
+// exchange and merge their states, in any order and any number of times.
+// Each database writes under its own node ID, so replicas never overwrite
+// each other's updates. Like sketches, stored CRDTs are never mutated;
+// writers Clone before changing them.
+
+// PNCounter is a counter made of per-node increment and decrement totals.
+// Its value is the sum of the increments minus the sum of the decrements,
+// and merging keeps the larger total for every node.
+type PNCounter struct {
+	P map[string]int64 `json:"p"` // Increments per node
+	N map[string]int64 `json:"n"` // Decrements per node
+}
+
+// NewPNCounter creates a counter at zero
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: make(map[string]int64), N: make(map[string]int64)}
+}
+
+// Add changes the counter by delta on behalf of node
+func (c *PNCounter) Add(node string, delta int64) {
+	if delta >= 0 {
+		c.P[node] += delta
+	} else {
+		c.N[node] -= delta
+	}
+}
+
+// Value returns the counter's current value
+func (c *PNCounter) Value() int64 {
+	var value int64
+	for _, n := range c.P {
+		value += n
+	}
+	for _, n := range c.N {
+		value -= n
+	}
+	return value
+}
+
+// Merge folds other into the counter, reporting whether anything changed
+func (c *PNCounter) Merge(other *PNCounter) bool {
+	changed := mergeMax(c.P, other.P)
+	return mergeMax(c.N, other.N) || changed
+}
+
+// Clone returns a deep copy of the counter
+func (c *PNCounter) Clone() *PNCounter {
+	clone := NewPNCounter()
+	for node, n := range c.P {
+		clone.P[node] = n
+	}
+	for node, n := range c.N {
+		clone.N[node] = n
+	}
+	return clone
+}
+
+// mergeMax raises each of into's entries to from's where from's is larger
+func mergeMax(into, from map[string]int64) bool {
+	changed := false
+	for node, n := range from {
+		if n > into[node] {
+			into[node] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ORSet is an observed-remove set: a remove only cancels the adds it has
+// seen, so an add concurrent with a remove wins. Every add is tagged with
+// a dot, the adding node and its add count; Clock records how many adds
+// of each node the set has seen. A merge drops an element's dots that the
+// other side has seen but no longer holds, which is how removes travel
+// without tombstones.
+type ORSet struct {
+	Clock   map[string]uint64            `json:"clock"`
+	Entries map[string]map[string]uint64 `json:"entries"` // Member to node to dot
+}
+
+// NewORSet creates an empty set
+func NewORSet() *ORSet {
+	return &ORSet{
+		Clock:   make(map[string]uint64),
+		Entries: make(map[string]map[string]uint64),
+	}
+}
+
+// Add inserts member on behalf of node, reporting whether it was absent.
+// The new dot supersedes the member's dots this set has observed.
+func (s *ORSet) Add(node, member string) bool {
+	_, existed := s.Entries[member]
+	s.Clock[node]++
+	s.Entries[member] = map[string]uint64{node: s.Clock[node]}
+	return !existed
+}
+
+// Remove deletes member, reporting whether it was present
+func (s *ORSet) Remove(member string) bool {
+	_, existed := s.Entries[member]
+	delete(s.Entries, member)
+	return existed
+}
+
+// Contains reports whether member is in the set
+func (s *ORSet) Contains(member string) bool {
+	_, exists := s.Entries[member]
+	return exists
+}
+
+// Members returns the members in sorted order
+func (s *ORSet) Members() []string {
+	members := make([]string, 0, len(s.Entries))
+	for member := range s.Entries {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Merge folds other into the set, reporting whether anything changed
+func (s *ORSet) Merge(other *ORSet) bool {
+	changed := false
+	for member, dots := range s.Entries {
+		kept := make(map[string]uint64, len(dots))
+		for node, dot := range dots {
+			// Keep dots the other side also holds or has not seen yet
+			if otherDot, held := other.Entries[member][node]; (held && otherDot == dot) || dot > other.Clock[node] {
+				kept[node] = dot
+			}
+		}
+		if len(kept) != len(dots) {
+			changed = true
+		}
+		if len(kept) == 0 {
+			delete(s.Entries, member)
+		} else {
+			s.Entries[member] = kept
+		}
+	}
+	for member, dots := range other.Entries {
+		for node, dot := range dots {
+			// Take dots this side has not seen yet
+			if _, held := s.Entries[member][node]; held || dot <= s.Clock[node] {
+				continue
+			}
+			if s.Entries[member] == nil {
+				s.Entries[member] = make(map[string]uint64)
+			}
+			s.Entries[member][node] = dot
+			changed = true
+		}
+	}
+	for node, count := range other.Clock {
+		if count > s.Clock[node] {
+			s.Clock[node] = count
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clone returns a deep copy of the set
+func (s *ORSet) Clone() *ORSet {
+	clone := NewORSet()
+	for node, count := range s.Clock {
+		clone.Clock[node] = count
+	}
+	for member, dots := range s.Entries {
+		clone.Entries[member] = make(map[string]uint64, len(dots))
+		for node, dot := range dots {
+			clone.Entries[member][node] = dot
+		}
+	}
+	return clone
+}
+
+// NodeID identifies this database in CRDT values. It is storage.node_id,
+// or a random ID chosen at startup; replicas must never share one.
+func (db *Database) NodeID() string {
+	return db.nodeID
+}
+
+// newNodeID returns the configured node ID or a random one
+func newNodeID(config *Config) string {
+	if config != nil && config.Storage.NodeID != "" {
+		return config.Storage.NodeID
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -1,16 +1,56 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// ErrVersionMismatch is returned by CompareAndSet when the key was modified
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// scanCheckInterval is how many keys a scan visits between checks of its
+// context, so cancellation is noticed without a check per key
+const scanCheckInterval = 1024
+
+// scanAborted returns the context's error on every scanCheckInterval-th
+// iteration of a scan once the context is done
+func scanAborted(ctx context.Context, i int) error {
+	if i%scanCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
 // NewDatabase creates a new Triff database instance
 func NewDatabase(config *Config) *Database {
+	var statsPrefixes []string
+	if config != nil {
+		statsPrefixes = config.Storage.StatsPrefixes
+	}
+	clock := SystemClock
+	
 	return &Database{
-		Data:   make(map[string]*TriffValue),
+		Data:   newKeyspace(config, 0),
 		mu:     sync.RWMutex{},
 		config: config,
+		indexes: NewIndexManager(),
+		search:  NewSearchManager(),
+		stats:   NewStatsTracker(statsPrefixes),
+		memoryByType: make(map[DataType]int64),
+		jobs:    NewBulkJobManager(),
+		queues:  NewQueueManager(clock),
+		history: NewHistoryStore(),
+		tombstones: make(map[string]*Tombstone),
+		partitions: make(map[string]*partition),
+		nodeID:  newNodeID(config),
+		epoch:   newEpoch(),
+		clock:   clock,
+		lastSave: clock.Now().UnixNano(),
 	}
 }
 
@@ -21,32 +61,359 @@ func (db *Database) Get(key string) (*TriffValue, bool) {
 	
 	value, exists := db.Data[key]
 	if !exists {
+		db.stats.RecordMiss(key)
 		return nil, false
 	}
 	
-	// Check if value has expired
-	if value.TTL > 0 && time.Now().Unix() > value.TTL {
-		delete(db.Data, key)
+	// Expired values are left for CleanupExpired: reads only hold the read
+	// lock, and values inside the stale grace window must stay for GetStale
+	if db.isExpired(value) {
+		db.stats.RecordMiss(key)
 		return nil, false
 	}
 	
-	return value, true
+	value.touch(db.now())
+	db.stats.RecordHit(key)
+	return db.detach(value), true
+}
+
+// GetStale retrieves a value, also returning values that expired less than
+// the configured stale grace window ago. stale reports whether the returned
+// value has expired and should be revalidated by the caller.
+func (db *Database) GetStale(key string) (value *TriffValue, stale bool, exists bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists = db.Data[key]
+	if !exists {
+		db.stats.RecordMiss(key)
+		return nil, false, false
+	}
+
+	now := db.now().Unix()
+	if value.TTL > 0 && now > value.TTL {
+		if db.pastGrace(value, now) {
+			db.stats.RecordMiss(key)
+			return nil, false, false
+		}
+		value.touch(db.now())
+		db.stats.RecordHit(key)
+		return db.detach(value), true, true
+	}
+
+	value.touch(db.now())
+	db.stats.RecordHit(key)
+	return db.detach(value), false, true
+}
+
+// GetDel retrieves a value and deletes its key in one step (GETDEL). The
+// key is only deleted when its value has type typ, so callers can report a
+// wrong type without side effects.
+func (db *Database) GetDel(key string, typ DataType) (*TriffValue, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		db.stats.RecordMiss(key)
+		return nil, false
+	}
+
+	db.stats.RecordHit(key)
+	if value.Type == typ {
+		db.bury(key, value)
+		db.remove(key, value)
+	}
+	return db.detach(value), true
+}
+
+// GetEx retrieves a value and changes its expiration in one step (GETEX).
+// Positive seconds set a new expiration, persist removes it, and neither
+// leaves it as it is. Like GetDel, only values of type typ are changed.
+func (db *Database) GetEx(key string, typ DataType, seconds int64, persist bool) (*TriffValue, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		db.stats.RecordMiss(key)
+		return nil, false
+	}
+
+	if value.Type != typ {
+		// Reported as a wrong type by the caller
+	} else if persist && value.TTL != 0 {
+		value.TTL = 0
+		db.emitChange(ChangeTTL, key, value)
+	} else if seconds > 0 {
+		value.TTL = db.now().Unix() + seconds
+		db.emitChange(ChangeTTL, key, value)
+	}
+	value.touch(db.now())
+	db.stats.RecordHit(key)
+	return db.detach(value), true
 }
 
-// Set stores a value in the database
+// Set stores a value in the database. Unless storage.zero_copy is set it
+// stores a copy, so the caller's value can be reused.
 func (db *Database) Set(key string, value *TriffValue) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
-	now := time.Now()
+	if err := db.checkLimits(key, value); err != nil {
+		return err
+	}
+	db.store(key, db.detach(value))
+	return nil
+}
+
+// CompareAndSet stores value only if the key's current version equals
+// expected. An expected version of 0 requires the key to not exist.
+// It returns the new version on success.
+func (db *Database) CompareAndSet(key string, expected uint64, value *TriffValue) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var current uint64
+	if old, exists := db.Data[key]; exists && !db.isExpired(old) {
+		current = old.Version
+	}
+	if current != expected {
+		return current, ErrVersionMismatch
+	}
+	if err := db.checkLimits(key, value); err != nil {
+		return current, err
+	}
+
+	stored := db.detach(value)
+	db.store(key, stored)
+	return stored.Version, nil
+}
+
+// SetIfAbsent stores value only if the key does not exist (SET NX). The
+// error is set when the value breaks a limit.
+func (db *Database) SetIfAbsent(key string, value *TriffValue) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if old, exists := db.Data[key]; exists && !db.isExpired(old) {
+		return false, nil
+	}
+	if err := db.checkLimits(key, value); err != nil {
+		return false, err
+	}
+
+	db.store(key, db.detach(value))
+	return true, nil
+}
+
+// SetIfExists stores value only if the key already exists (SET XX). The
+// error is set when the value breaks a limit.
+func (db *Database) SetIfExists(key string, value *TriffValue) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if old, exists := db.Data[key]; !exists || db.isExpired(old) {
+		return false, nil
+	}
+	if err := db.checkLimits(key, value); err != nil {
+		return false, err
+	}
+
+	db.store(key, db.detach(value))
+	return true, nil
+}
+
+// DeleteIf removes a key only if cond holds for its current value.
+// cond runs under the write lock and must not call back into the database.
+func (db *Database) DeleteIf(key string, cond func(value *TriffValue) bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) || !cond(value) {
+		return false
+	}
+
+	db.remove(key, value)
+	return true
+}
+
+// SetTTLIf sets time to live for a key only if cond holds for its current value
+func (db *Database) SetTTLIf(key string, seconds int64, cond func(value *TriffValue) bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) || !cond(value) {
+		return false
+	}
+
+	value.TTL = db.now().Unix() + seconds
+	db.emitChange(ChangeTTL, key, value)
+	return true
+}
+
+// Update atomically replaces a key's value with the result of fn, so
+// read-modify-write commands such as INCR cannot lose concurrent updates.
+// fn receives the current value, or nil when the key is missing or expired,
+// and runs under the write lock, so it must not call back into the
+// database. Returning a nil value deletes the key; returning old itself or
+// an error leaves it untouched, and the error is passed on. Any other
+// returned value is stored as is, unless it breaks a limit.
+func (db *Database) Update(key string, fn func(old *TriffValue) (*TriffValue, error)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, exists := db.Data[key]
+	var old *TriffValue
+	if exists && !db.isExpired(current) {
+		old = db.detach(current)
+	}
+
+	value, err := fn(old)
+	if err != nil || (value == old && old != nil) {
+		return err
+	}
+	if value == nil {
+		if old != nil {
+			db.bury(key, current)
+			db.remove(key, current)
+		}
+		return nil
+	}
+	if err := db.checkLimits(key, value); err != nil {
+		return err
+	}
+	db.store(key, value)
+	return nil
+}
+
+// UpdateKeys is Update over several keys at once. fn gets the current
+// values of keys (nil for missing ones) and changes the map in place, a nil
+// entry deleting its key and an untouched one leaving it as is. The writes
+// are applied together under the write lock, or none of them if fn returns
+// an error or a write exceeds a limit.
+func (db *Database) UpdateKeys(keys []string, fn func(values map[string]*TriffValue) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	values := make(map[string]*TriffValue, len(keys))
+	for _, key := range keys {
+		if value, exists := db.Data[key]; exists && !db.isExpired(value) {
+			values[key] = db.detach(value)
+		} else {
+			values[key] = nil
+		}
+	}
+	old := make(map[string]*TriffValue, len(values))
+	for key, value := range values {
+		old[key] = value
+	}
+
+	if err := fn(values); err != nil {
+		return err
+	}
+	changed := make([]string, 0, len(old))
+	for key, value := range old {
+		if values[key] == value {
+			continue
+		}
+		if values[key] != nil {
+			if err := db.checkLimits(key, values[key]); err != nil {
+				return err
+			}
+		}
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+	for _, key := range changed {
+		if value := values[key]; value != nil {
+			db.store(key, value)
+			continue
+		}
+		// A write before this one may have invalidated the key already,
+		// so go by what is stored now rather than what fn was given
+		if value, exists := db.Data[key]; exists && !db.isExpired(value) {
+			db.bury(key, value)
+			db.remove(key, value)
+		}
+	}
+	return nil
+}
+
+// store writes value under key, maintaining timestamps, version and indexes,
+// and invalidates the keys depending on it. Callers must hold the write
+// lock.
+func (db *Database) store(key string, value *TriffValue) {
+	db.put(key, value)
+	db.invalidateDependents(key)
+}
+
+// put is store without invalidating dependents, for writes that must
+// decide which to spare. Callers must hold the write lock.
+func (db *Database) put(key string, value *TriffValue) {
+	now := db.now()
 	value.UpdatedAt = now
+	db.stampPartition(key, value)
 	
-	if _, exists := db.Data[key]; !exists {
+	old, exists := db.Data[key]
+	if exists && !db.isExpired(old) {
+		value.Version = old.Version + 1
+		if old != value {
+			value.CreatedAt = old.CreatedAt
+		}
+		if value.Tags == nil {
+			// Tags stay with a key until it is deleted or untagged,
+			// and so do its dependencies
+			value.Tags = old.Tags
+		}
+		if value.DependsOn == nil {
+			value.DependsOn = old.DependsOn
+		}
+		value.initAccess(old, now)
+	} else {
 		value.CreatedAt = now
+		value.Version = 1
+		value.initAccess(nil, now)
+	}
+	
+	if exists {
+		db.memoryUsed -= old.size
+		db.memoryByType[old.Type] -= old.size
 	}
+	db.compact(value)
+	value.size = SizeOf(key, value)
+	db.memoryUsed += value.size
+	db.memoryByType[value.Type] += value.size
 	
+	if exists && old != value && !db.isExpired(old) {
+		db.history.Record(key, old, false, db.historyDepth(key))
+	}
+	if old != value {
+		key = db.pack(key, !exists, value)
+	}
 	db.Data[key] = value
-	return nil
+	db.onWrite(key, value)
+	db.emitChange(ChangeSet, key, value)
+}
+
+// remove deletes a key and releases its accounted memory.
+// Callers must hold the write lock.
+func (db *Database) remove(key string, value *TriffValue) {
+	if !db.isExpired(value) {
+		db.history.Record(key, value, true, db.historyDepth(key))
+	}
+	delete(db.Data, key)
+	db.memoryUsed -= value.size
+	db.memoryByType[value.Type] -= value.size
+	db.onWrite(key, nil)
+	if db.isExpired(value) {
+		db.emitChange(ChangeExpire, key, value)
+	} else {
+		db.emitChange(ChangeDelete, key, value)
+	}
+	db.invalidateDependents(key)
 }
 
 // Delete removes a key from the database
@@ -54,11 +421,44 @@ func (db *Database) Delete(key string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
-	if _, exists := db.Data[key]; exists {
-		delete(db.Data, key)
-		return true
+	value, exists := db.Data[key]
+	if !exists {
+		return false
 	}
-	return false
+	if db.isExpired(value) {
+		// Already gone as far as clients can tell; drop it now rather
+		// than waiting for the expiry sweep
+		db.remove(key, value)
+		return false
+	}
+	db.bury(key, value)
+	db.remove(key, value)
+	return true
+}
+
+// DeletePattern removes all keys matching a glob pattern. Keys are deleted
+// in batches of batchSize, releasing the write lock between batches so other
+// clients are not blocked for the whole operation. It returns the number of
+// keys deleted.
+func (db *Database) DeletePattern(pattern string, batchSize int) int {
+	deleted, _ := db.DeletePatternContext(context.Background(), pattern, batchSize)
+	return deleted
+}
+
+// DeletePatternContext is DeletePattern that stops between batches once
+// ctx is done, returning the keys deleted so far and ctx's error
+func (db *Database) DeletePatternContext(ctx context.Context, pattern string, batchSize int) (int, error) {
+	keys, err := db.matchKeys(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	err = db.forEachBatch(ctx, keys, batchSize, func(key string, value *TriffValue) {
+		db.bury(key, value)
+		db.remove(key, value)
+		deleted++
+	})
+	return deleted, err
 }
 
 // Exists checks if a key exists in the database
@@ -66,23 +466,36 @@ func (db *Database) Exists(key string) bool {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	
-	_, exists := db.Data[key]
-	return exists
+	value, exists := db.Data[key]
+	return exists && !db.isExpired(value)
 }
 
-// Keys returns all keys matching a pattern
+// Keys returns all live keys matching a glob pattern (see MatchPattern)
 func (db *Database) Keys(pattern string) []string {
+	keys, _ := db.KeysContext(context.Background(), pattern)
+	return keys
+}
+
+// KeysContext is Keys that gives up once ctx is done
+func (db *Database) KeysContext(ctx context.Context, pattern string) ([]string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	
 	keys := make([]string, 0)
-	for key := range db.Data {
-		// Simple pattern matching - can be enhanced
-		if pattern == "*" || key == pattern {
+	i := 0
+	for key, value := range db.Data {
+		i++
+		if err := scanAborted(ctx, i); err != nil {
+			return nil, err
+		}
+		if db.isExpired(value) {
+			continue
+		}
+		if MatchPattern(pattern, key) {
 			keys = append(keys, key)
 		}
 	}
-	return keys
+	return keys, nil
 }
 
 // FlushAll removes all data from the database
@@ -90,7 +503,23 @@ func (db *Database) FlushAll() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
-	db.Data = make(map[string]*TriffValue)
+	db.Data = newKeyspace(db.config, 0)
+	db.arena.reset()
+	db.memoryUsed = 0
+	db.memoryByType = make(map[DataType]int64)
+	db.indexes.Reset()
+	db.search.Reset()
+	db.stats.Reset()
+	db.queues.Reset()
+	db.history.Reset()
+	db.tombstones = make(map[string]*Tombstone)
+	db.partitions = make(map[string]*partition)
+	db.tags.reset()
+	db.dependents.reset()
+	db.order.reset()
+	db.emitChange(ChangeFlush, "", nil)
+	db.resetAggregates()
+	db.storeAggregates()
 	return nil
 }
 
@@ -107,8 +536,45 @@ func (db *Database) SetTTL(key string, seconds int64) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
-	if value, exists := db.Data[key]; exists {
-		value.TTL = time.Now().Unix() + seconds
+	if value, exists := db.Data[key]; exists && !db.isExpired(value) {
+		value.TTL = db.now().Unix() + seconds
+		db.emitChange(ChangeTTL, key, value)
+		return true
+	}
+	return false
+}
+
+// JitterTTL shortens a TTL in seconds by a random amount of up to
+// storage.ttl_jitter percent, so keys written together with the same
+// lifetime don't all expire in the same second. A jittered TTL never
+// outlives the one asked for, and TTLs too short to shorten by a whole
+// second are returned as is.
+func (db *Database) JitterTTL(seconds int64) int64 {
+	db.mu.RLock()
+	percent := 0
+	if db.config != nil {
+		percent = db.config.Storage.TTLJitter
+	}
+	db.mu.RUnlock()
+
+	spread := seconds * int64(percent) / 100
+	if seconds <= 0 || spread <= 0 {
+		return seconds
+	}
+	if spread >= seconds {
+		spread = seconds - 1
+	}
+	return seconds - rand.Int63n(spread+1)
+}
+
+// Persist removes the expiration from a key
+func (db *Database) Persist(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if value, exists := db.Data[key]; exists && !db.isExpired(value) && value.TTL != 0 {
+		value.TTL = 0
+		db.emitChange(ChangeTTL, key, value)
 		return true
 	}
 	return false
@@ -123,7 +589,7 @@ func (db *Database) GetTTL(key string) int64 {
 		if value.TTL == 0 {
 			return -1 // No expiration
 		}
-		remaining := value.TTL - time.Now().Unix()
+		remaining := value.TTL - db.now().Unix()
 		if remaining <= 0 {
 			return -2 // Expired
 		}
@@ -132,17 +598,21 @@ func (db *Database) GetTTL(key string) int64 {
 	return -2 // Key doesn't exist
 }
 
-// CleanupExpired removes expired keys from the database
+// CleanupExpired removes expired keys from the database, dropping
+// expired time partitions whole
 func (db *Database) CleanupExpired() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
-	now := time.Now().Unix()
+	now := db.now()
+	db.dropExpiredPartitions(now)
 	for key, value := range db.Data {
-		if value.TTL > 0 && now > value.TTL {
-			delete(db.Data, key)
+		if value.TTL > 0 && now.Unix() > value.TTL && db.pastGrace(value, now.Unix()) {
+			db.remove(key, value)
 		}
 	}
+	db.purgeTombstones(now)
+	db.pruneFlights(now)
 }
 
 // Info returns database information
@@ -150,20 +620,226 @@ func (db *Database) Info() map[string]interface{} {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	
-	return map[string]interface{}{
+	hits, misses := db.stats.Totals()
+	
+	info := map[string]interface{}{
 		"version":    "1.0.0",
 		"keys":       len(db.Data),
-		"memory_mb":  db.getMemoryUsage(),
+		"keyspace_hits":   hits,
+		"keyspace_misses": misses,
+		"memory_mb":  float64(db.getMemoryUsage()) / (1024 * 1024),
+		"used_memory": db.getMemoryUsage(),
 		"uptime":     time.Since(time.Now()).Seconds(),
-		"tcp_port":   db.config.Port,
-		"http_port":  db.config.HTTPPort,
+		"tcp_port":   db.config.Server.Port,
+		"http_port":  db.config.Server.HTTPPort,
+		"read_only":  db.config.Server.ReadOnly,
+		"maintenance": db.config.Server.Maintenance,
 	}
+	info["changes_since_last_save"] = db.Dirty()
+	info["last_save_time"] = db.LastSave().Unix()
+	db.backpressureInfo(info)
+	db.limitsInfo(info)
+	db.arenaInfo(info)
+	return info
 }
 
-// getMemoryUsage calculates approximate memory usage
+// getMemoryUsage returns the accounted memory usage in bytes
 func (db *Database) getMemoryUsage() int64 {
-	// Simple estimation - can be enhanced with proper memory calculation
-	return int64(len(db.Data) * 100) // Rough estimate
+	return db.memoryUsed
+}
+
+// Config returns a copy of the active configuration
+func (db *Database) Config() *Config {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.config == nil {
+		return &Config{}
+	}
+	return db.config.Clone()
+}
+
+// SetConfig atomically replaces the active configuration. Settings read
+// per operation (such as StaleGrace) take effect immediately; changed
+// partitions regroup the keys already stored.
+func (db *Database) SetConfig(config *Config) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old := db.config
+	db.config = config
+	db.repartition(old)
+}
+
+// Object returns a key's value without counting it as an access, for
+// introspection commands such as OBJECT IDLETIME
+func (db *Database) Object(key string) (*TriffValue, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return nil, false
+	}
+	return db.detach(value), true
+}
+
+// MemoryUsage returns the accounted bytes of a single key
+func (db *Database) MemoryUsage(key string) (int64, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return 0, false
+	}
+	return value.size, true
+}
+
+// MemoryStats returns a breakdown of accounted memory
+func (db *Database) MemoryStats() MemoryStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := MemoryStats{
+		TotalBytes: db.memoryUsed,
+		Keys:       len(db.Data),
+		ByType:     make(map[string]int64),
+	}
+	if len(db.Data) > 0 {
+		stats.BytesPerKey = db.memoryUsed / int64(len(db.Data))
+	}
+	if db.config != nil {
+		stats.MaxMemory = db.config.Storage.MaxMemory
+	}
+	for t, bytes := range db.memoryByType {
+		if bytes != 0 {
+			stats.ByType[typeName(t)] = bytes
+		}
+	}
+	return stats
+}
+
+// MemoryByPrefix returns the accounted memory of the unexpired keys under
+// each prefix. A key under nested prefixes counts towards each of them.
+func (db *Database) MemoryByPrefix(prefixes []string) map[string]int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	usage := make(map[string]int64, len(prefixes))
+	for _, prefix := range prefixes {
+		usage[prefix] = 0
+	}
+	for key, value := range db.Data {
+		if db.isExpired(value) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				usage[prefix] += value.size
+			}
+		}
+	}
+	return usage
+}
+
+// CreateIndex declares a secondary index on a hash field for keys matching pattern
+func (db *Database) CreateIndex(name, pattern, field string) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.indexes.Create(name, pattern, field, db.Data)
+}
+
+// DropIndex removes a secondary index
+func (db *Database) DropIndex(name string) bool {
+	return db.indexes.Drop(name)
+}
+
+// ListIndexes returns all declared secondary indexes
+func (db *Database) ListIndexes() []IndexInfo {
+	return db.indexes.List()
+}
+
+// FindEqual returns keys whose indexed field equals value
+func (db *Database) FindEqual(index, value string) ([]string, error) {
+	return db.indexes.FindEqual(index, value)
+}
+
+// FindRange returns keys whose indexed field lies within the given bounds
+func (db *Database) FindRange(index string, min, max RangeBound) ([]string, error) {
+	return db.indexes.FindRange(index, min, max)
+}
+
+// CreateSearchIndex declares a full-text index over keys matching pattern.
+// For hashes only the listed fields are indexed, or all fields if none are given.
+func (db *Database) CreateSearchIndex(name, pattern string, fields []string, stem bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.search.Create(name, pattern, fields, stem, db.Data)
+}
+
+// DropSearchIndex removes a full-text index
+func (db *Database) DropSearchIndex(name string) bool {
+	return db.search.Drop(name)
+}
+
+// ListSearchIndexes returns all full-text indexes
+func (db *Database) ListSearchIndexes() []SearchIndexInfo {
+	return db.search.List()
+}
+
+// Search runs a full-text query against an index
+func (db *Database) Search(index, query string, offset, limit int) ([]SearchHit, int, error) {
+	return db.search.Search(index, query, offset, limit)
+}
+
+// isExpired reports whether a value's TTL has passed
+func (db *Database) isExpired(value *TriffValue) bool {
+	return value.TTL > 0 && db.now().Unix() > value.TTL
+}
+
+// KeyStats returns access statistics for a key
+func (db *Database) KeyStats(key string) (KeyStats, bool) {
+	return db.stats.Key(key)
+}
+
+// TopKeys returns per-key statistics filtered by prefix and sorted by
+// hits, misses, writes or idle time
+func (db *Database) TopKeys(prefix, sortBy string, limit int) []KeyStats {
+	return db.stats.Top(prefix, sortBy, limit)
+}
+
+// PrefixStats returns access statistics aggregated per key prefix
+func (db *Database) PrefixStats() []PrefixStats {
+	return db.stats.Prefixes()
+}
+
+// pastGrace reports whether an expired value has also left the stale grace window
+func (db *Database) pastGrace(value *TriffValue, now int64) bool {
+	if db.config == nil || db.config.Storage.StaleGrace <= 0 {
+		return true
+	}
+	return now > value.TTL+db.config.Storage.StaleGrace
+}
+
+// onWrite keeps indexes and statistics in sync with a write.
+// A nil value means the key was removed.
+func (db *Database) onWrite(key string, value *TriffValue) {
+	db.indexes.Update(key, value)
+	db.search.Update(key, value)
+	db.tags.update(key, tagsOf(value))
+	db.dependents.update(key, dependenciesOf(value))
+	db.updateAggregates(key, value)
+	db.trackPartition(key, value)
+	if value == nil {
+		db.order.remove(key)
+		db.stats.Remove(key)
+	} else {
+		db.order.add(key)
+		db.stats.RecordWrite(key)
+	}
 }
 
 // Ping returns pong - health check
@@ -0,0 +1,42 @@
+package core
+
+import "testing"
+
+// TestUpdateKeysDeletesInvalidatedKeyOnce writes a key and deletes one
+// depending on it in the same update, so the write has removed the second
+// key by the time the update gets to deleting it
+func TestUpdateKeysDeletesInvalidatedKeyOnce(t *testing.T) {
+	db := NewDatabase(&Config{Storage: StorageConfig{SoftDelete: 60, History: map[string]int{"": 5}}})
+	setString(t, db, "a", "A")
+	setString(t, db, "z", "Z")
+	if _, err := db.Depend("z", "a"); err != nil {
+		t.Fatal(err)
+	}
+	deletes := 0
+	db.OnChange(func(event ChangeEvent) {
+		if event.Key == "z" && event.Op == ChangeDelete {
+			deletes++
+		}
+	})
+
+	err := db.UpdateKeys([]string{"a", "z"}, func(values map[string]*TriffValue) error {
+		values["a"] = &TriffValue{Type: STRING, Data: "A2"}
+		values["z"] = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := db.Get("a")
+	stats := db.MemoryStats()
+	if stats.Keys != 1 || stats.TotalBytes != a.size || stats.ByType["string"] != a.size {
+		t.Errorf("MemoryStats = %+v, want 1 key of %d bytes", stats, a.size)
+	}
+	if n := len(db.History("z")); n != 1 {
+		t.Errorf("z has %d revisions, want 1", n)
+	}
+	if deletes != 1 {
+		t.Errorf("%d deletes of z in the change feed, want 1", deletes)
+	}
+}
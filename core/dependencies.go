@@ -0,0 +1,221 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// ErrDependencyCycle is returned for a dependency that would make a key
+// depend on itself, directly or through other keys
+var ErrDependencyCycle = errors.New("dependency would create a cycle")
+
+// Depend makes key depend on dependencies: writing or deleting any of
+// them, expiry included, deletes key, and that in turn deletes the keys
+// that depend on key. Caches of data composed from several keys declare
+// what they were built from this way. Dependencies don't have to exist
+// yet. Like tags, they stay with key when it is overwritten, until it is
+// deleted or they are removed with Undepend.
+//
+// It returns how many dependencies key didn't have yet. A dependency that
+// would create a cycle fails with ErrDependencyCycle, and one that would
+// make a write invalidate keys more than storage.dependency_depth deep
+// fails with errs.ErrLimit; either way none are added.
+func (db *Database) Depend(key string, dependencies ...string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return 0, errs.Newf(errs.ErrNotFound, "key %q not found", key)
+	}
+
+	merged := append([]string(nil), value.DependsOn...)
+	deepest := 0
+	added := 0
+	for _, dependency := range dependencies {
+		if dependency == "" || hasTag(merged, dependency) {
+			continue
+		}
+		if dependency == key {
+			return 0, fmt.Errorf("%w: %q can't depend on itself", ErrDependencyCycle, key)
+		}
+		if db.dependsOn(dependency, key) {
+			return 0, fmt.Errorf("%w: %q already depends on %q", ErrDependencyCycle, dependency, key)
+		}
+		if depth := db.dependencyDepth(dependency); depth > deepest {
+			deepest = depth
+		}
+		merged = append(merged, dependency)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	// The longest chain of invalidations through the new dependencies runs
+	// from the deepest key they depend on up to the last key depending on
+	// key
+	if db.config != nil && db.config.Storage.DependencyDepth > 0 {
+		limit := db.config.Storage.DependencyDepth
+		if depth := deepest + 1 + db.dependentDepth(key); depth > limit {
+			return 0, errs.Newf(errs.ErrLimit, "writes would invalidate keys %d deep through %q, over storage.dependency_depth of %d", depth, key, limit)
+		}
+	}
+
+	sort.Strings(merged)
+	db.redepend(key, value, merged)
+	return added, nil
+}
+
+// Undepend removes dependencies from a key, returning how many it had
+func (db *Database) Undepend(key string, dependencies ...string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return 0, errs.Newf(errs.ErrNotFound, "key %q not found", key)
+	}
+	kept := make([]string, 0, len(value.DependsOn))
+	for _, dependency := range value.DependsOn {
+		if !hasTag(dependencies, dependency) {
+			kept = append(kept, dependency)
+		}
+	}
+	removed := len(value.DependsOn) - len(kept)
+	if removed > 0 {
+		if len(kept) == 0 {
+			kept = nil
+		}
+		db.redepend(key, value, kept)
+	}
+	return removed, nil
+}
+
+// redepend replaces a stored value's dependencies. Callers must hold the
+// write lock.
+func (db *Database) redepend(key string, value *TriffValue, dependencies []string) {
+	value.DependsOn = dependencies
+	db.dependents.update(key, dependencies)
+	db.emitChange(ChangeDepend, key, value)
+}
+
+// KeyDependencies returns the keys a key depends on, sorted
+func (db *Database) KeyDependencies(key string) ([]string, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return nil, false
+	}
+	return append([]string{}, value.DependsOn...), true
+}
+
+// Dependents returns the live keys that depend on key directly, sorted
+func (db *Database) Dependents(key string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, len(db.dependents.keys[key]))
+	for dependent := range db.dependents.keys[key] {
+		if value, exists := db.Data[dependent]; exists && !db.isExpired(value) {
+			keys = append(keys, dependent)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// invalidateDependents deletes the keys that depend on key, which was just
+// written or removed, apart from spared ones. Removing them invalidates
+// their own dependents in turn. Callers must hold the write lock.
+func (db *Database) invalidateDependents(key string, spared ...string) {
+	dependents := db.dependents.keys[key]
+	if len(dependents) == 0 {
+		return
+	}
+	// Removing a dependent changes the index, so go by a copy
+	keys := make([]string, 0, len(dependents))
+	for dependent := range dependents {
+		keys = append(keys, dependent)
+	}
+	sort.Strings(keys)
+	for _, dependent := range keys {
+		if hasTag(spared, dependent) {
+			continue
+		}
+		if value, exists := db.Data[dependent]; exists {
+			db.remove(dependent, value)
+		}
+	}
+}
+
+// dependsOn reports whether key depends on target, directly or through
+// other keys. Callers must hold the lock.
+func (db *Database) dependsOn(key, target string) bool {
+	seen := make(map[string]bool)
+	pending := []string{key}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dependency := range db.dependents.labels[current] {
+			if dependency == target {
+				return true
+			}
+			if !seen[dependency] {
+				seen[dependency] = true
+				pending = append(pending, dependency)
+			}
+		}
+	}
+	return false
+}
+
+// dependencyDepth returns the length of the longest chain of dependencies
+// below key, 0 if it depends on nothing. Callers must hold the lock.
+func (db *Database) dependencyDepth(key string) int {
+	return chainDepth(key, func(k string) []string { return db.dependents.labels[k] }, make(map[string]int))
+}
+
+// dependentDepth returns the length of the longest chain of dependents
+// above key, 0 if nothing depends on it. Callers must hold the lock.
+func (db *Database) dependentDepth(key string) int {
+	next := func(k string) []string {
+		keys := make([]string, 0, len(db.dependents.keys[k]))
+		for dependent := range db.dependents.keys[k] {
+			keys = append(keys, dependent)
+		}
+		return keys
+	}
+	return chainDepth(key, next, make(map[string]int))
+}
+
+// chainDepth returns the longest path from key following next, which
+// Depend keeps free of cycles
+func chainDepth(key string, next func(string) []string, memo map[string]int) int {
+	if depth, ok := memo[key]; ok {
+		return depth
+	}
+	memo[key] = 0 // Guards against a cycle loaded from an edited snapshot
+	deepest := 0
+	for _, k := range next(key) {
+		if depth := 1 + chainDepth(k, next, memo); depth > deepest {
+			deepest = depth
+		}
+	}
+	memo[key] = deepest
+	return deepest
+}
+
+// dependenciesOf returns the keys a value depends on, none for a removed
+// value
+func dependenciesOf(value *TriffValue) []string {
+	if value == nil {
+		return nil
+	}
+	return value.DependsOn
+}
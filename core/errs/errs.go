@@ -0,0 +1,69 @@
+// Package errs defines the sentinel errors shared by core, the command
+// handlers and the servers. Errors are classified with errors.Is, so each
+// server can map a class to its own protocol reply (a RESP error prefix or
+// an HTTP status) without comparing message strings.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrWrongType means a key holds a different kind of value than the
+	// operation expects
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+	// ErrNotFound means a key, member, index or other named item doesn't exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrNotInteger means a value or argument isn't a 64-bit integer
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+
+	// ErrBackpressure means a write was turned away because persistence is
+	// falling behind; it can be retried once the backlog drains
+	ErrBackpressure = errors.New("writes are throttled while persistence catches up")
+
+	// ErrNoAuth means the operation needs a client authenticated with the
+	// server password
+	ErrNoAuth = errors.New("authentication required")
+
+	// ErrDisabled means the operation is turned off by configuration
+	ErrDisabled = errors.New("operation is disabled")
+
+	// ErrReadOnly means a write was refused because the server is in
+	// read-only mode
+	ErrReadOnly = errors.New("server is read-only")
+
+	// ErrLimit means a write was refused because it would break one of the
+	// configured size or keyspace limits
+	ErrLimit = errors.New("limit exceeded")
+
+	// ErrQuota means an API key used up one of its daily quotas
+	ErrQuota = errors.New("quota exceeded")
+
+	// ErrMaintenance means the server only serves admin commands while in
+	// maintenance mode
+	ErrMaintenance = errors.New("server is in maintenance mode")
+
+	// ErrLoading means the server is still loading its snapshot and can't
+	// serve commands yet
+	ErrLoading = errors.New("server is loading its data")
+)
+
+// classified is an error with its own message that still matches a
+// sentinel with errors.Is
+type classified struct {
+	class   error
+	message string
+}
+
+func (e *classified) Error() string { return e.message }
+
+func (e *classified) Unwrap() error { return e.class }
+
+// Newf returns an error with a formatted message that belongs to class,
+// e.g. errs.Newf(errs.ErrNotFound, "index %q not found", name)
+func Newf(class error, format string, args ...interface{}) error {
+	return &classified{class: class, message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Fetch coalesces concurrent reads of a missing key, so a cache miss sends
+// one caller to the origin rather than all of them. The first caller to
+// miss gets a compute grant: a token that is valid for a lock TTL, during
+// which it fetches the record and stores it with Fill. Everyone else
+// missing the key in that time waits for the Fill. If the key is inside
+// its stale grace window, they are served the stale value without
+// waiting, while the caller holding the grant refreshes it. A grant that
+// expires without a Fill, e.g. because its holder died, passes to the next
+// caller.
+
+// ErrComputing is returned by Fetch when the key is still being computed
+// by the grant holder once the caller stops waiting
+var ErrComputing = errs.Newf(errs.ErrBackpressure, "key is still being computed")
+
+// FetchResult is what Fetch found. Value is nil when the key is missing.
+// Token is set when the caller was granted the computation and must Fill
+// the key, or Abandon the grant, before the lock TTL runs out.
+type FetchResult struct {
+	Value *TriffValue
+	Stale bool
+	Token string
+}
+
+// flights holds the compute grants in progress
+type flights struct {
+	mu sync.Mutex
+	m  map[string]*flight
+}
+
+type flight struct {
+	token   string
+	expires time.Time
+	done    chan struct{} // Closed when the grant is filled or abandoned
+}
+
+// Fetch reads key, granting the caller its computation if it is missing or
+// stale and nobody else holds the grant, and otherwise waiting for the
+// holder's Fill while ctx allows
+func (db *Database) Fetch(ctx context.Context, key string, lock time.Duration) (FetchResult, error) {
+	for {
+		value, stale, exists := db.GetStale(key)
+		if exists && !stale {
+			return FetchResult{Value: value}, nil
+		}
+
+		f := &db.flights
+		f.mu.Lock()
+		current := f.m[key]
+		if current == nil || db.now().After(current.expires) {
+			// Fill stores under f.mu, so reading again here can't miss a
+			// value filled since the read above
+			value, stale, exists = db.GetStale(key)
+			if exists && !stale {
+				f.mu.Unlock()
+				return FetchResult{Value: value}, nil
+			}
+			granted := &flight{token: newFetchToken(), expires: db.now().Add(lock), done: make(chan struct{})}
+			if f.m == nil {
+				f.m = make(map[string]*flight)
+			}
+			if current != nil {
+				close(current.done)
+			}
+			f.m[key] = granted
+			f.mu.Unlock()
+			return FetchResult{Value: value, Stale: stale, Token: granted.token}, nil
+		}
+		f.mu.Unlock()
+
+		if exists {
+			return FetchResult{Value: value, Stale: true}, nil
+		}
+		select {
+		case <-current.done:
+		case <-db.clock.After(current.expires.Sub(db.now())):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return FetchResult{}, ErrComputing
+			}
+			return FetchResult{}, ctx.Err()
+		}
+	}
+}
+
+// Fill stores the value computed under a grant from Fetch and ends the
+// grant, waking the callers waiting for it. It stores nothing and returns
+// false if token isn't the key's current grant, because it expired and
+// passed to another caller or was already filled. An expired grant
+// nobody has taken over can still be filled.
+func (db *Database) Fill(key, token string, value *TriffValue) (bool, error) {
+	f := &db.flights
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.m[key]
+	if current == nil || current.token != token {
+		return false, nil
+	}
+	if err := db.Set(key, value); err != nil {
+		return false, err
+	}
+	delete(f.m, key)
+	close(current.done)
+	return true, nil
+}
+
+// Abandon gives up a grant from Fetch without storing anything, e.g. when
+// the origin failed, so a waiting caller is granted the computation at
+// once rather than when the lock TTL runs out
+func (db *Database) Abandon(key, token string) bool {
+	f := &db.flights
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.m[key]
+	if current == nil || current.token != token {
+		return false
+	}
+	delete(f.m, key)
+	close(current.done)
+	return true
+}
+
+// pruneFlights drops grants that expired without anyone fetching their key
+// again
+func (db *Database) pruneFlights(now time.Time) {
+	f := &db.flights
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, current := range f.m {
+		if now.After(current.expires) {
+			delete(f.m, key)
+			close(current.done)
+		}
+	}
+}
+
+func newFetchToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	ErrSearchIndexExists   = errors.New("search index already exists")
+	ErrSearchIndexNotFound = errs.Newf(errs.ErrNotFound, "search index not found")
+)
+
+// SearchIndex is an inverted index over string values and hash fields
+// of keys matching Pattern
+type SearchIndex struct {
+	Name    string   `json:"name"`
+	Pattern string   `json:"pattern"`
+	Fields  []string `json:"fields,omitempty"` // hash fields to index; empty means all
+	Stem    bool     `json:"stem"`
+
+	postings map[string]map[string]int // term -> key -> term frequency
+	docs     map[string]map[string]int // key -> term -> term frequency
+}
+
+// SearchIndexInfo describes a search index for listing purposes
+type SearchIndexInfo struct {
+	Name    string   `json:"name"`
+	Pattern string   `json:"pattern"`
+	Fields  []string `json:"fields,omitempty"`
+	Stem    bool     `json:"stem"`
+	Docs    int      `json:"docs"`
+	Terms   int      `json:"terms"`
+}
+
+// SearchHit is a single search result
+type SearchHit struct {
+	Key   string `json:"key"`
+	Score int    `json:"score"`
+}
+
+// SearchManager maintains full-text indexes as values change
+type SearchManager struct {
+	indexes map[string]*SearchIndex
+	mu      sync.RWMutex
+}
+
+// NewSearchManager creates an empty search manager
+func NewSearchManager() *SearchManager {
+	return &SearchManager{
+		indexes: make(map[string]*SearchIndex),
+	}
+}
+
+// Create registers a new search index and backfills it from the given data
+func (sm *SearchManager) Create(name, pattern string, fields []string, stem bool, data map[string]*TriffValue) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.indexes[name]; exists {
+		return ErrSearchIndexExists
+	}
+
+	idx := &SearchIndex{
+		Name:     name,
+		Pattern:  pattern,
+		Fields:   fields,
+		Stem:     stem,
+		postings: make(map[string]map[string]int),
+		docs:     make(map[string]map[string]int),
+	}
+	for key, value := range data {
+		idx.update(key, value)
+	}
+
+	sm.indexes[name] = idx
+	return nil
+}
+
+// Drop removes a search index
+func (sm *SearchManager) Drop(name string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.indexes[name]; exists {
+		delete(sm.indexes, name)
+		return true
+	}
+	return false
+}
+
+// List returns information about all search indexes
+func (sm *SearchManager) List() []SearchIndexInfo {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	infos := make([]SearchIndexInfo, 0, len(sm.indexes))
+	for _, idx := range sm.indexes {
+		infos = append(infos, SearchIndexInfo{
+			Name:    idx.Name,
+			Pattern: idx.Pattern,
+			Fields:  idx.Fields,
+			Stem:    idx.Stem,
+			Docs:    len(idx.docs),
+			Terms:   len(idx.postings),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Update reindexes a key after a write. A nil value removes the key.
+func (sm *SearchManager) Update(key string, value *TriffValue) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, idx := range sm.indexes {
+		idx.update(key, value)
+	}
+}
+
+// Reset clears all indexed documents but keeps the index definitions
+func (sm *SearchManager) Reset() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, idx := range sm.indexes {
+		idx.postings = make(map[string]map[string]int)
+		idx.docs = make(map[string]map[string]int)
+	}
+}
+
+// Search returns keys containing every term of query, best matches first.
+// The total number of matches is returned alongside the requested page.
+func (sm *SearchManager) Search(name, query string, offset, limit int) ([]SearchHit, int, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	idx, exists := sm.indexes[name]
+	if !exists {
+		return nil, 0, ErrSearchIndexNotFound
+	}
+
+	terms := Tokenize(query, idx.Stem)
+	if len(terms) == 0 {
+		return []SearchHit{}, 0, nil
+	}
+
+	// Start from the rarest term to keep the candidate set small
+	sort.Slice(terms, func(i, j int) bool {
+		return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]])
+	})
+
+	scores := make(map[string]int)
+	for key, tf := range idx.postings[terms[0]] {
+		scores[key] = tf
+	}
+	for _, term := range terms[1:] {
+		posting := idx.postings[term]
+		for key := range scores {
+			tf, ok := posting[key]
+			if !ok {
+				delete(scores, key)
+				continue
+			}
+			scores[key] += tf
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for key, score := range scores {
+		hits = append(hits, SearchHit{Key: key, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Key < hits[j].Key
+	})
+
+	total := len(hits)
+	if offset > total {
+		offset = total
+	}
+	hits = hits[offset:]
+	if limit >= 0 && limit < len(hits) {
+		hits = hits[:limit]
+	}
+	return hits, total, nil
+}
+
+// update replaces the indexed terms of key with those of value
+func (idx *SearchIndex) update(key string, value *TriffValue) {
+	if old, indexed := idx.docs[key]; indexed {
+		for term := range old {
+			if posting := idx.postings[term]; posting != nil {
+				delete(posting, key)
+				if len(posting) == 0 {
+					delete(idx.postings, term)
+				}
+			}
+		}
+		delete(idx.docs, key)
+	}
+
+	if value == nil || !MatchPattern(idx.Pattern, key) {
+		return
+	}
+
+	text := idx.documentText(value)
+	if text == "" {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, term := range Tokenize(text, idx.Stem) {
+		counts[term]++
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	for term, tf := range counts {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][key] = tf
+	}
+	idx.docs[key] = counts
+}
+
+// documentText extracts the searchable text of a value
+func (idx *SearchIndex) documentText(value *TriffValue) string {
+	switch value.Type {
+	case STRING:
+		if s, ok := value.Data.(string); ok {
+			return s
+		}
+	case HASH:
+		var parts []string
+		if len(idx.Fields) > 0 {
+			for _, field := range idx.Fields {
+				if v, ok := hashField(value, field); ok {
+					parts = append(parts, v)
+				}
+			}
+			return strings.Join(parts, " ")
+		}
+		switch fields := value.Data.(type) {
+		case map[string]string:
+			for _, v := range fields {
+				parts = append(parts, v)
+			}
+		case PackedHash:
+			fields.Range(func(_, v string) bool {
+				parts = append(parts, v)
+				return true
+			})
+		case map[string]interface{}:
+			for _, v := range fields {
+				parts = append(parts, fmt.Sprint(v))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+// Tokenize splits text into lowercase terms on any non letter/digit
+// boundary, optionally reducing each term to its stem
+func Tokenize(text string, stem bool) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if stem {
+		for i, word := range words {
+			words[i] = Stem(word)
+		}
+	}
+	return words
+}
+
+// Stem applies light English suffix stripping. It is intentionally simple:
+// it folds common inflections (plurals, -ing, -ed, -ly) onto one term.
+func Stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "ches"),
+		strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "zes"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "us"):
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			word = word[:len(word)-len(suffix)]
+			// Undouble trailing consonants: "running" -> "run"
+			if n := len(word); n >= 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouls", rune(word[n-1])) {
+				word = word[:n-1]
+			}
+			break
+		}
+	}
+	return word
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revision is a previous version of a key, kept when history is enabled for
+// the key's prefix with Storage.History
+type Revision struct {
+	Version    uint64      `json:"version"`
+	Type       string      `json:"type"`
+	Data       interface{} `json:"data"`
+	TTL        int64       `json:"ttl"`
+	UpdatedAt  time.Time   `json:"updated_at"` // When this version was written
+	ReplacedAt time.Time   `json:"replaced_at"`
+	Deleted    bool        `json:"deleted"` // The key was deleted rather than overwritten
+}
+
+// HistoryStore keeps the most recent revisions of keys, newest first.
+// Stored values are never mutated in place by writers, so revisions can
+// share their Data with the value they were taken from.
+type HistoryStore struct {
+	revisions map[string][]Revision
+	mu        sync.RWMutex
+}
+
+// NewHistoryStore creates an empty history store
+func NewHistoryStore() *HistoryStore {
+	return &HistoryStore{revisions: make(map[string][]Revision)}
+}
+
+// Record saves value as the newest revision of key, keeping at most depth
+// revisions. A depth of zero disables history for the key.
+func (hs *HistoryStore) Record(key string, value *TriffValue, deleted bool, depth int) {
+	if depth <= 0 {
+		return
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	revision := Revision{
+		Version:    value.Version,
+		Type:       typeName(value.Type),
+		Data:       value.Data,
+		TTL:        value.TTL,
+		UpdatedAt:  value.UpdatedAt,
+		ReplacedAt: time.Now(),
+		Deleted:    deleted,
+	}
+	revisions := append([]Revision{revision}, hs.revisions[key]...)
+	if len(revisions) > depth {
+		revisions = revisions[:depth]
+	}
+	hs.revisions[key] = revisions
+}
+
+// Get returns the nth previous revision of key, 1 being the most recent
+func (hs *HistoryStore) Get(key string, n int) (Revision, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	revisions := hs.revisions[key]
+	if n < 1 || n > len(revisions) {
+		return Revision{}, false
+	}
+	return revisions[n-1], true
+}
+
+// List returns all kept revisions of key, newest first
+func (hs *HistoryStore) List(key string) []Revision {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	return append([]Revision(nil), hs.revisions[key]...)
+}
+
+// Reset drops all history
+func (hs *HistoryStore) Reset() {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.revisions = make(map[string][]Revision)
+}
+
+// historyDepth returns how many revisions to keep for key: the setting of
+// the longest configured prefix it matches. Callers must hold the lock.
+func (db *Database) historyDepth(key string) int {
+	if db.config == nil {
+		return 0
+	}
+	depth, longest := 0, -1
+	for prefix, n := range db.config.Storage.History {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			depth, longest = n, len(prefix)
+		}
+	}
+	return depth
+}
+
+// Revision returns the nth previous version of a key, 1 being the version
+// before the current one. The current value is revision 0.
+func (db *Database) Revision(key string, n int) (Revision, bool) {
+	if n == 0 {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+
+		value, exists := db.Data[key]
+		if !exists || db.isExpired(value) {
+			return Revision{}, false
+		}
+		return Revision{
+			Version:   value.Version,
+			Type:      typeName(value.Type),
+			Data:      value.Data,
+			TTL:       value.TTL,
+			UpdatedAt: value.UpdatedAt,
+		}, true
+	}
+	return db.history.Get(key, n)
+}
+
+// History returns the kept previous versions of a key, newest first
+func (db *Database) History(key string) []Revision {
+	return db.history.List(key)
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+var (
+	ErrIndexExists   = errors.New("index already exists")
+	ErrIndexNotFound = errs.Newf(errs.ErrNotFound, "index not found")
+)
+
+// Index is a secondary index over one field of hash values whose keys
+// match Pattern
+type Index struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Field   string `json:"field"`
+
+	byValue map[string]map[string]struct{} // field value -> keys
+	byKey   map[string]string              // key -> indexed field value
+}
+
+// IndexInfo describes an index for listing purposes
+type IndexInfo struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Field   string `json:"field"`
+	Keys    int    `json:"keys"`
+	Values  int    `json:"values"`
+}
+
+// RangeBound is one side of a range predicate. An unbounded side matches everything.
+type RangeBound struct {
+	Value     string
+	Exclusive bool
+	Unbounded bool
+}
+
+// IndexManager keeps secondary indexes up to date as hash values change
+type IndexManager struct {
+	indexes map[string]*Index
+	mu      sync.RWMutex
+}
+
+// NewIndexManager creates an empty index manager
+func NewIndexManager() *IndexManager {
+	return &IndexManager{
+		indexes: make(map[string]*Index),
+	}
+}
+
+// Create registers a new index and backfills it from the given data
+func (im *IndexManager) Create(name, pattern, field string, data map[string]*TriffValue) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, exists := im.indexes[name]; exists {
+		return ErrIndexExists
+	}
+
+	idx := &Index{
+		Name:    name,
+		Pattern: pattern,
+		Field:   field,
+		byValue: make(map[string]map[string]struct{}),
+		byKey:   make(map[string]string),
+	}
+	for key, value := range data {
+		idx.update(key, value)
+	}
+
+	im.indexes[name] = idx
+	return nil
+}
+
+// Drop removes an index
+func (im *IndexManager) Drop(name string) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, exists := im.indexes[name]; exists {
+		delete(im.indexes, name)
+		return true
+	}
+	return false
+}
+
+// List returns information about all declared indexes
+func (im *IndexManager) List() []IndexInfo {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	infos := make([]IndexInfo, 0, len(im.indexes))
+	for _, idx := range im.indexes {
+		infos = append(infos, IndexInfo{
+			Name:    idx.Name,
+			Pattern: idx.Pattern,
+			Field:   idx.Field,
+			Keys:    len(idx.byKey),
+			Values:  len(idx.byValue),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Update reindexes a key after a write. A nil value removes the key.
+func (im *IndexManager) Update(key string, value *TriffValue) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, idx := range im.indexes {
+		idx.update(key, value)
+	}
+}
+
+// Reset clears all index entries but keeps the index definitions
+func (im *IndexManager) Reset() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, idx := range im.indexes {
+		idx.byValue = make(map[string]map[string]struct{})
+		idx.byKey = make(map[string]string)
+	}
+}
+
+// FindEqual returns the keys whose indexed field equals value
+func (im *IndexManager) FindEqual(name, value string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	idx, exists := im.indexes[name]
+	if !exists {
+		return nil, ErrIndexNotFound
+	}
+
+	keys := make([]string, 0, len(idx.byValue[value]))
+	for key := range idx.byValue[value] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// FindRange returns the keys whose indexed field lies between min and max.
+// Values are compared numerically when both sides parse as numbers and
+// lexicographically otherwise.
+func (im *IndexManager) FindRange(name string, min, max RangeBound) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	idx, exists := im.indexes[name]
+	if !exists {
+		return nil, ErrIndexNotFound
+	}
+
+	keys := make([]string, 0)
+	for value, set := range idx.byValue {
+		if !min.Unbounded {
+			c := compareIndexValues(value, min.Value)
+			if c < 0 || (c == 0 && min.Exclusive) {
+				continue
+			}
+		}
+		if !max.Unbounded {
+			c := compareIndexValues(value, max.Value)
+			if c > 0 || (c == 0 && max.Exclusive) {
+				continue
+			}
+		}
+		for key := range set {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ParseRangeBound parses a bound in ZRANGEBYSCORE style: "-inf", "+inf",
+// a plain value (inclusive) or "(value" (exclusive)
+func ParseRangeBound(s string) RangeBound {
+	switch s {
+	case "-inf", "+inf", "inf", "":
+		return RangeBound{Unbounded: true}
+	}
+	if len(s) > 1 && s[0] == '(' {
+		return RangeBound{Value: s[1:], Exclusive: true}
+	}
+	return RangeBound{Value: s}
+}
+
+// update moves key to the bucket for its current field value
+func (idx *Index) update(key string, value *TriffValue) {
+	fieldValue, ok := "", false
+	if value != nil && MatchPattern(idx.Pattern, key) {
+		fieldValue, ok = hashField(value, idx.Field)
+	}
+
+	if old, indexed := idx.byKey[key]; indexed {
+		if ok && old == fieldValue {
+			return
+		}
+		if set := idx.byValue[old]; set != nil {
+			delete(set, key)
+			if len(set) == 0 {
+				delete(idx.byValue, old)
+			}
+		}
+		delete(idx.byKey, key)
+	}
+
+	if !ok {
+		return
+	}
+	if idx.byValue[fieldValue] == nil {
+		idx.byValue[fieldValue] = make(map[string]struct{})
+	}
+	idx.byValue[fieldValue][key] = struct{}{}
+	idx.byKey[key] = fieldValue
+}
+
+// hashField extracts a field from a hash value
+func hashField(value *TriffValue, field string) (string, bool) {
+	if value.Type != HASH {
+		return "", false
+	}
+	switch fields := value.Data.(type) {
+	case map[string]string:
+		v, ok := fields[field]
+		return v, ok
+	case PackedHash:
+		return fields.Get(field)
+	case map[string]interface{}:
+		v, ok := fields[field]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// compareIndexValues orders two field values, numerically if possible
+func compareIndexValues(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil && !math.IsNaN(fa) && !math.IsNaN(fb) {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
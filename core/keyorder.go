@@ -0,0 +1,107 @@
+package core
+
+import "sort"
+
+// maxKeyBucket is the most keys a keyOrder bucket holds before it splits
+const maxKeyBucket = 512
+
+// keyOrder keeps the keyspace's keys sorted, so a Scan page seeks to its
+// cursor instead of walking the whole map. Keys are held in buckets of up
+// to maxKeyBucket, each sorted and all of one sorting before all of the
+// next, so a write moves at most a bucket's worth of keys. It is kept up
+// to date by onWrite, under the database's write lock.
+type keyOrder struct {
+	buckets [][]string // Never empty
+}
+
+// add inserts a key, if it isn't there yet
+func (ko *keyOrder) add(key string) {
+	if len(ko.buckets) == 0 {
+		ko.buckets = [][]string{{key}}
+		return
+	}
+	b := ko.bucket(key)
+	bucket := ko.buckets[b]
+	i := sort.SearchStrings(bucket, key)
+	if i < len(bucket) && bucket[i] == key {
+		return
+	}
+	bucket = append(bucket, "")
+	copy(bucket[i+1:], bucket[i:])
+	bucket[i] = key
+	ko.buckets[b] = bucket
+	if len(bucket) <= maxKeyBucket {
+		return
+	}
+
+	half := len(bucket) / 2
+	left := append(make([]string, 0, maxKeyBucket), bucket[:half]...)
+	right := append(make([]string, 0, maxKeyBucket), bucket[half:]...)
+	ko.buckets = append(ko.buckets, nil)
+	copy(ko.buckets[b+2:], ko.buckets[b+1:])
+	ko.buckets[b], ko.buckets[b+1] = left, right
+}
+
+// remove deletes a key, if it is there
+func (ko *keyOrder) remove(key string) {
+	if len(ko.buckets) == 0 {
+		return
+	}
+	b := ko.bucket(key)
+	bucket := ko.buckets[b]
+	i := sort.SearchStrings(bucket, key)
+	if i == len(bucket) || bucket[i] != key {
+		return
+	}
+	copy(bucket[i:], bucket[i+1:])
+	bucket[len(bucket)-1] = ""
+	bucket = bucket[:len(bucket)-1]
+	ko.buckets[b] = bucket
+
+	switch {
+	case len(bucket) == 0:
+		ko.buckets = append(ko.buckets[:b], ko.buckets[b+1:]...)
+	case len(bucket) < maxKeyBucket/4 && b+1 < len(ko.buckets) && len(bucket)+len(ko.buckets[b+1]) <= maxKeyBucket:
+		// Merge small buckets, so deleting most keys doesn't leave a
+		// bucket per key
+		ko.buckets[b] = append(bucket, ko.buckets[b+1]...)
+		ko.buckets = append(ko.buckets[:b+1], ko.buckets[b+2:]...)
+	}
+}
+
+// ascend calls fn with the keys from from on in order, starting after it
+// unless inclusive, until fn returns false
+func (ko *keyOrder) ascend(from string, inclusive bool, fn func(key string) bool) {
+	if len(ko.buckets) == 0 {
+		return
+	}
+	b := ko.bucket(from)
+	i := sort.Search(len(ko.buckets[b]), func(i int) bool {
+		if inclusive {
+			return ko.buckets[b][i] >= from
+		}
+		return ko.buckets[b][i] > from
+	})
+	for ; b < len(ko.buckets); b, i = b+1, 0 {
+		for _, key := range ko.buckets[b][i:] {
+			if !fn(key) {
+				return
+			}
+		}
+	}
+}
+
+// bucket returns the index of the bucket key belongs in: the last one
+// starting at or before it, or the first
+func (ko *keyOrder) bucket(key string) int {
+	b := sort.Search(len(ko.buckets), func(i int) bool { return ko.buckets[i][0] > key })
+	if b > 0 {
+		b--
+	}
+	return b
+}
+
+// reset empties the order
+func (ko *keyOrder) reset() {
+	ko.buckets = nil
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyStats holds access statistics for a single key
+type KeyStats struct {
+	Key        string    `json:"key"`
+	Hits       uint64    `json:"hits"`
+	Misses     uint64    `json:"misses"`
+	Writes     uint64    `json:"writes"`
+	LastAccess time.Time `json:"last_access"`
+	LastWrite  time.Time `json:"last_write"`
+}
+
+// PrefixStats aggregates access statistics for all keys sharing a prefix
+type PrefixStats struct {
+	Prefix string `json:"prefix"`
+	Keys   int    `json:"keys"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Writes uint64 `json:"writes"`
+}
+
+// StatsTracker records hits, misses and writes per key and per prefix.
+// Misses on keys that never existed only count towards prefix and global
+// totals so lookups of random keys can't grow the tracker without bound.
+type StatsTracker struct {
+	keys     map[string]*KeyStats
+	prefixes map[string]*PrefixStats
+	// configured prefixes; when empty a key's prefix is everything up to its first ':'
+	configured []string
+	hits       uint64
+	misses     uint64
+	mu         sync.Mutex
+}
+
+// NewStatsTracker creates a tracker aggregating over the given prefixes
+func NewStatsTracker(prefixes []string) *StatsTracker {
+	return &StatsTracker{
+		keys:       make(map[string]*KeyStats),
+		prefixes:   make(map[string]*PrefixStats),
+		configured: prefixes,
+	}
+}
+
+// RecordHit counts a successful read of key
+func (st *StatsTracker) RecordHit(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.hits++
+	ks := st.keyStats(key)
+	ks.Hits++
+	ks.LastAccess = time.Now()
+	st.prefixStats(key).Hits++
+}
+
+// RecordMiss counts a read of a missing or expired key
+func (st *StatsTracker) RecordMiss(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.misses++
+	if ks, exists := st.keys[key]; exists {
+		ks.Misses++
+		ks.LastAccess = time.Now()
+	}
+	st.prefixStats(key).Misses++
+}
+
+// RecordWrite counts a write to key
+func (st *StatsTracker) RecordWrite(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ks := st.keyStats(key)
+	ks.Writes++
+	ks.LastWrite = time.Now()
+	st.prefixStats(key).Writes++
+}
+
+// Remove forgets the per-key statistics of a deleted key
+func (st *StatsTracker) Remove(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.keys[key]; exists {
+		delete(st.keys, key)
+		if ps := st.prefixes[st.prefixOf(key)]; ps != nil && ps.Keys > 0 {
+			ps.Keys--
+		}
+	}
+}
+
+// Reset clears per-key statistics, keeping prefix and global counters
+func (st *StatsTracker) Reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.keys = make(map[string]*KeyStats)
+	for _, ps := range st.prefixes {
+		ps.Keys = 0
+	}
+}
+
+// Key returns a copy of the statistics of key
+func (st *StatsTracker) Key(key string) (KeyStats, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if ks, exists := st.keys[key]; exists {
+		return *ks, true
+	}
+	return KeyStats{}, false
+}
+
+// Top returns up to limit key statistics filtered by prefix and ordered by
+// sortBy: "hits" (hottest first), "misses", "writes" or "idle" (least
+// recently accessed first, to find dead data)
+func (st *StatsTracker) Top(prefix, sortBy string, limit int) []KeyStats {
+	st.mu.Lock()
+	result := make([]KeyStats, 0, len(st.keys))
+	for key, ks := range st.keys {
+		if prefix == "" || strings.HasPrefix(key, prefix) {
+			result = append(result, *ks)
+		}
+	}
+	st.mu.Unlock()
+
+	var less func(a, b KeyStats) bool
+	switch sortBy {
+	case "misses":
+		less = func(a, b KeyStats) bool { return a.Misses > b.Misses }
+	case "writes":
+		less = func(a, b KeyStats) bool { return a.Writes > b.Writes }
+	case "idle":
+		less = func(a, b KeyStats) bool { return a.LastAccess.Before(b.LastAccess) }
+	default:
+		less = func(a, b KeyStats) bool { return a.Hits > b.Hits }
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if less(result[i], result[j]) {
+			return true
+		}
+		if less(result[j], result[i]) {
+			return false
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	if limit > 0 && limit < len(result) {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Prefixes returns aggregated statistics for every prefix seen so far
+func (st *StatsTracker) Prefixes() []PrefixStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	result := make([]PrefixStats, 0, len(st.prefixes))
+	for _, ps := range st.prefixes {
+		result = append(result, *ps)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Prefix < result[j].Prefix })
+	return result
+}
+
+// Totals returns the global hit and miss counters
+func (st *StatsTracker) Totals() (hits, misses uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.hits, st.misses
+}
+
+func (st *StatsTracker) keyStats(key string) *KeyStats {
+	ks, exists := st.keys[key]
+	if !exists {
+		ks = &KeyStats{Key: key}
+		st.keys[key] = ks
+		st.prefixStats(key).Keys++
+	}
+	return ks
+}
+
+func (st *StatsTracker) prefixStats(key string) *PrefixStats {
+	prefix := st.prefixOf(key)
+	ps, exists := st.prefixes[prefix]
+	if !exists {
+		ps = &PrefixStats{Prefix: prefix}
+		st.prefixes[prefix] = ps
+	}
+	return ps
+}
+
+// prefixOf returns the longest configured prefix of key, or its first
+// ':'-separated segment when no prefixes are configured
+func (st *StatsTracker) prefixOf(key string) string {
+	if len(st.configured) > 0 {
+		best := ""
+		for _, prefix := range st.configured {
+			if strings.HasPrefix(key, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		return best
+	}
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i+1]
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package core
+
+// labelIndex maps labels that values carry, such as their tags, to the
+// keys of those values, so finding the keys with a label doesn't scan the
+// keyspace. It is kept up to date by onWrite, under the database's write
+// lock.
+type labelIndex struct {
+	keys   map[string]map[string]struct{} // Keys by label
+	labels map[string][]string            // Labels by key, as last indexed
+}
+
+// update indexes the labels of the value now stored under key, none if it
+// was removed
+func (li *labelIndex) update(key string, labels []string) {
+	old := li.labels[key]
+	if len(old) == 0 && len(labels) == 0 {
+		return
+	}
+	if li.keys == nil {
+		li.keys = make(map[string]map[string]struct{})
+		li.labels = make(map[string][]string)
+	}
+
+	for _, label := range old {
+		if keys := li.keys[label]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(li.keys, label)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		delete(li.labels, key)
+		return
+	}
+	li.labels[key] = labels
+	for _, label := range labels {
+		keys := li.keys[label]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			li.keys[label] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// reset empties the index
+func (li *labelIndex) reset() {
+	li.keys = nil
+	li.labels = nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// LimitError is a write refused because it would exceed one of the limits
+// config's bounds. It matches errs.ErrLimit.
+type LimitError struct {
+	Limit string // "max_key_size", "max_value_size", "max_elements" or "max_keys"
+	Size  int64  // What the write would have made it
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case "max_key_size":
+		return fmt.Sprintf("key is %d bytes, over the limit of %d (max_key_size)", e.Size, e.Max)
+	case "max_value_size":
+		return fmt.Sprintf("value is %d bytes, over the limit of %d (max_value_size)", e.Size, e.Max)
+	case "max_elements":
+		return fmt.Sprintf("value has %d elements, over the limit of %d (max_elements)", e.Size, e.Max)
+	}
+	return fmt.Sprintf("keyspace is full at %d keys (max_keys)", e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return errs.ErrLimit }
+
+// checkLimits refuses to store value under key if that would break the
+// key, value, element or keyspace limits, or if key is in an expired time
+// partition. A value already over a limit that was lowered can still
+// shrink. Callers must hold the write lock.
+func (db *Database) checkLimits(key string, value *TriffValue) error {
+	if db.config == nil {
+		return nil
+	}
+	if err := db.checkPartition(key); err != nil {
+		return err
+	}
+	limits := db.config.Limits
+	old, exists := db.Data[key]
+
+	var err error
+	if limits.MaxKeySize > 0 && len(key) > limits.MaxKeySize {
+		err = &LimitError{Limit: "max_key_size", Size: int64(len(key)), Max: int64(limits.MaxKeySize)}
+	}
+	if err == nil && limits.MaxValueSize > 0 {
+		if size := contentSize(value.Data); size > limits.MaxValueSize && (!exists || size > contentSize(old.Data)) {
+			err = &LimitError{Limit: "max_value_size", Size: size, Max: limits.MaxValueSize}
+		}
+	}
+	if err == nil && limits.MaxElements > 0 {
+		if n, ok := elementCount(value); ok && n > limits.MaxElements && (!exists || n > elementCountOf(old)) {
+			err = &LimitError{Limit: "max_elements", Size: int64(n), Max: int64(limits.MaxElements)}
+		}
+	}
+	if err == nil && limits.MaxKeys > 0 && !exists && len(db.Data) >= limits.MaxKeys {
+		err = &LimitError{Limit: "max_keys", Size: int64(len(db.Data) + 1), Max: int64(limits.MaxKeys)}
+	}
+	if err != nil {
+		db.limitRejections++
+	}
+	return err
+}
+
+// contentSize is the bytes of a value's strings, without the container
+// overhead payloadSize adds for memory accounting. Sorted set scores count
+// as 8 bytes; sketches and CRDTs fall back to payloadSize.
+func contentSize(data interface{}) int64 {
+	var size int64
+	switch v := data.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case []string:
+		for _, item := range v {
+			size += int64(len(item))
+		}
+	case []interface{}:
+		for _, item := range v {
+			size += contentSize(item)
+		}
+	case map[string]string:
+		for field, item := range v {
+			size += int64(len(field) + len(item))
+		}
+	case PackedHash:
+		v.Range(func(field, item string) bool {
+			size += int64(len(field) + len(item))
+			return true
+		})
+	case PackedList:
+		for _, item := range v.Items() {
+			size += int64(len(item))
+		}
+	case PackedSet:
+		for _, member := range v.Members() {
+			size += int64(len(member))
+		}
+	case map[string]struct{}:
+		for member := range v {
+			size += int64(len(member))
+		}
+	case map[string]float64:
+		for member := range v {
+			size += int64(len(member) + 8)
+		}
+	case map[string]interface{}:
+		for field, item := range v {
+			size += int64(len(field)) + contentSize(item)
+		}
+	default:
+		return payloadSize(data)
+	}
+	return size
+}
+
+// elementCount returns the number of fields or members of a collection
+// value, or false for values that aren't collections
+func elementCount(value *TriffValue) (int, bool) {
+	switch data := value.Data.(type) {
+	case map[string]string:
+		return len(data), true
+	case PackedHash:
+		return data.Len(), true
+	case map[string]interface{}:
+		return len(data), true
+	case PackedList:
+		return data.Len(), true
+	case PackedSet:
+		return data.Len(), true
+	case map[string]struct{}:
+		return len(data), true
+	case map[string]float64:
+		return len(data), true
+	case []string:
+		return len(data), true
+	case []interface{}:
+		return len(data), true
+	case *ORSet:
+		return len(data.Members()), true
+	}
+	return 0, false
+}
+
+// elementCountOf is elementCount with 0 for values that aren't collections
+func elementCountOf(value *TriffValue) int {
+	n, _ := elementCount(value)
+	return n
+}
+
+// limitsInfo adds the configured limits and how close the keyspace is to
+// them to INFO. Callers must hold the read lock.
+func (db *Database) limitsInfo(info map[string]interface{}) {
+	var limits LimitsConfig
+	if db.config != nil {
+		limits = db.config.Limits
+	}
+	info["max_keys"] = limits.MaxKeys
+	info["max_key_size"] = limits.MaxKeySize
+	info["max_value_size"] = limits.MaxValueSize
+	info["max_elements"] = limits.MaxElements
+	if limits.MaxKeys > 0 {
+		info["keys_utilization"] = float64(len(db.Data)) / float64(limits.MaxKeys)
+	}
+	info["limit_rejections"] = db.limitRejections
+}
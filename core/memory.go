@@ -0,0 +1,153 @@
+package core
+
+// Approximate sizes of Go runtime structures on 64-bit platforms, used to
+// account for container overhead in addition to the payload bytes
+const (
+	stringHeaderSize    = 16 // pointer + length
+	sliceHeaderSize     = 24 // pointer + length + capacity
+	interfaceSize       = 16 // type word + data word
+	mapHeaderSize       = 48 // hmap
+	mapEntryOverhead    = 8  // tophash byte plus amortized bucket/overflow cost
+	pointerSize         = 8
+	triffValueSize      = 96 // TriffValue struct including timestamps
+	keyspaceEntrySize   = stringHeaderSize + pointerSize + mapEntryOverhead
+	numericPayloadSize  = 8
+	unknownPayloadGuess = 16
+)
+
+// MemoryStats summarizes accounted memory usage
+type MemoryStats struct {
+	TotalBytes  int64            `json:"total_bytes"`
+	Keys        int              `json:"keys"`
+	BytesPerKey int64            `json:"bytes_per_key"`
+	ByType      map[string]int64 `json:"by_type"`
+	MaxMemory   int64            `json:"max_memory"`
+}
+
+// SizeOf estimates the bytes held by a key and its value, including the
+// keyspace map entry, the TriffValue struct and container overhead of the
+// payload
+func SizeOf(key string, value *TriffValue) int64 {
+	size := int64(keyspaceEntrySize + len(key) + triffValueSize)
+	if value == nil {
+		return size
+	}
+	return size + payloadSize(value.Data)
+}
+
+// payloadSize estimates the heap bytes referenced by a value's Data
+func payloadSize(data interface{}) int64 {
+	switch v := data.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(sliceHeaderSize + cap(v))
+	case PackedHash:
+		return int64(stringHeaderSize + len(v))
+	case PackedList:
+		return int64(stringHeaderSize + len(v))
+	case PackedSet:
+		return int64(stringHeaderSize + len(v))
+	case []string:
+		size := int64(sliceHeaderSize + cap(v)*stringHeaderSize)
+		for _, item := range v {
+			size += int64(len(item))
+		}
+		return size
+	case []interface{}:
+		size := int64(sliceHeaderSize + cap(v)*interfaceSize)
+		for _, item := range v {
+			size += payloadSize(item)
+		}
+		return size
+	case map[string]string:
+		size := int64(mapHeaderSize)
+		for field, item := range v {
+			size += int64(2*stringHeaderSize + mapEntryOverhead + len(field) + len(item))
+		}
+		return size
+	case map[string]struct{}:
+		size := int64(mapHeaderSize)
+		for member := range v {
+			size += int64(stringHeaderSize + mapEntryOverhead + len(member))
+		}
+		return size
+	case map[string]float64:
+		size := int64(mapHeaderSize)
+		for member := range v {
+			size += int64(stringHeaderSize + numericPayloadSize + mapEntryOverhead + len(member))
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(mapHeaderSize)
+		for field, item := range v {
+			size += int64(stringHeaderSize+interfaceSize+mapEntryOverhead+len(field)) + payloadSize(item)
+		}
+		return size
+	case *BloomFilter:
+		return int64(sliceHeaderSize+64) + int64(len(v.Bits))*numericPayloadSize
+	case *CountMinSketch:
+		return int64(sliceHeaderSize+32) + int64(len(v.Counters))*numericPayloadSize
+	case *CuckooFilter:
+		return int64(sliceHeaderSize+40) + int64(len(v.Slots))*2
+	case *PNCounter:
+		size := int64(2 * mapHeaderSize)
+		for _, totals := range []map[string]int64{v.P, v.N} {
+			for node := range totals {
+				size += int64(stringHeaderSize+mapEntryOverhead+len(node)) + numericPayloadSize
+			}
+		}
+		return size
+	case *ORSet:
+		size := int64(2 * mapHeaderSize)
+		for node := range v.Clock {
+			size += int64(stringHeaderSize+mapEntryOverhead+len(node)) + numericPayloadSize
+		}
+		for member, dots := range v.Entries {
+			size += int64(stringHeaderSize + mapHeaderSize + mapEntryOverhead + len(member))
+			for node := range dots {
+				size += int64(stringHeaderSize+mapEntryOverhead+len(node)) + numericPayloadSize
+			}
+		}
+		return size
+	case int, int64, uint64, float64, bool:
+		return numericPayloadSize
+	}
+	return unknownPayloadGuess
+}
+
+// typeName returns the lowercase name of a data type
+func typeName(t DataType) string {
+	switch t {
+	case STRING:
+		return "string"
+	case HASH:
+		return "hash"
+	case LIST:
+		return "list"
+	case SET:
+		return "set"
+	case ZSET:
+		return "zset"
+	case BLOOM:
+		return "bloom"
+	case CMS:
+		return "cms"
+	case CUCKOO:
+		return "cuckoo"
+	case PNCOUNTER:
+		return "pncounter"
+	case ORSET:
+		return "orset"
+	case NULL:
+		return "null"
+	}
+	return "unknown"
+}
+
+// String returns the lowercase name of a data type
+func (t DataType) String() string {
+	return typeName(t)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strconv"
+	"time"
+)
+
+// KeyMetadata describes a key without its value, as served by OBJECT and
+// the REST metadata endpoints
+type KeyMetadata struct {
+	Type         string    `json:"type"`
+	Encoding     string    `json:"encoding"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Elements     int       `json:"elements"` // 0 for values that aren't collections
+	Version      uint64    `json:"version"`
+	TTL          int64     `json:"ttl"` // Remaining seconds, -1 without expiry
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	LastAccessed time.Time `json:"last_accessed_at"`
+	Frequency    uint32    `json:"frequency"`
+	IdleSeconds  int64     `json:"idle_seconds"`
+	Tags         []string  `json:"tags,omitempty"`
+	DependsOn    []string  `json:"depends_on,omitempty"`
+	Cap          int       `json:"cap,omitempty"` // Of capped lists
+}
+
+// Metadata returns a key's metadata without counting it as an access
+func (db *Database) Metadata(key string) (KeyMetadata, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return KeyMetadata{}, false
+	}
+	ttl := int64(-1)
+	if value.TTL > 0 {
+		ttl = value.TTL - db.now().Unix()
+	}
+	return KeyMetadata{
+		Type:         typeName(value.Type),
+		Encoding:     Encoding(value),
+		SizeBytes:    value.size,
+		Elements:     elementCountOf(value),
+		Version:      value.Version,
+		TTL:          ttl,
+		CreatedAt:    value.CreatedAt,
+		UpdatedAt:    value.UpdatedAt,
+		LastAccessed: value.LastAccess(),
+		Frequency:    value.AccessFrequency(db.now()),
+		IdleSeconds:  int64(value.IdleTime(db.now()).Seconds()),
+		Tags:         value.Tags,
+		DependsOn:    value.DependsOn,
+		Cap:          value.Cap,
+	}, true
+}
+
+// Encoding names the representation a value is held in: "int" or "raw"
+// for strings, "hashtable" for hashes, sets and sorted sets, "array" for
+// lists and the type name for sketches and CRDTs
+func Encoding(value *TriffValue) string {
+	switch data := value.Data.(type) {
+	case string:
+		if _, err := strconv.ParseInt(data, 10, 64); err == nil {
+			return "int"
+		}
+		return "raw"
+	case int, int64, uint64:
+		return "int"
+	case PackedHash, PackedList, PackedSet:
+		return "listpack"
+	case map[string]string, map[string]interface{}, map[string]struct{}, map[string]float64:
+		return "hashtable"
+	case []string, []interface{}:
+		return "array"
+	}
+	return typeName(value.Type)
+}
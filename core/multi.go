@@ -0,0 +1,134 @@
+package core
+
+import "sort"
+
+// GetMany retrieves several keys under one read lock, so they are read at
+// the same point in time and the lock is taken once for all of them. The
+// result has a value per key, nil where the key doesn't exist.
+func (db *Database) GetMany(keys []string) []*TriffValue {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	values := make([]*TriffValue, len(keys))
+	for i, key := range keys {
+		value, exists := db.Data[key]
+		if !exists || db.isExpired(value) {
+			db.stats.RecordMiss(key)
+			continue
+		}
+		value.touch(db.now())
+		db.stats.RecordHit(key)
+		values[i] = db.detach(value)
+	}
+	return values
+}
+
+// SetMany stores several values under one write lock. It is all or
+// nothing: every value is checked against the limits first, and if one
+// breaks them nothing is stored. Keys are stored in sorted order, so the
+// changes they emit are in the same order whatever order values holds
+// them in.
+func (db *Database) SetMany(values map[string]*TriffValue) error {
+	keys := sortedKeys(values)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.setMany(keys, values)
+}
+
+// SetManyIfAbsent is SetMany if none of the keys exists (MSETNX), and
+// stores nothing otherwise. It reports whether the values were stored.
+func (db *Database) SetManyIfAbsent(values map[string]*TriffValue) (bool, error) {
+	keys := sortedKeys(values)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, key := range keys {
+		if old, exists := db.Data[key]; exists && !db.isExpired(old) {
+			return false, nil
+		}
+	}
+	if err := db.setMany(keys, values); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setMany stores values in the order of keys, or none of them if one
+// breaks a limit. Callers must hold the write lock.
+func (db *Database) setMany(keys []string, values map[string]*TriffValue) error {
+	created := 0
+	for _, key := range keys {
+		if err := db.checkLimits(key, values[key]); err != nil {
+			return err
+		}
+		if _, exists := db.Data[key]; !exists {
+			created++
+		}
+	}
+	// checkLimits counts keys one at a time; the batch may still add more
+	// than max_keys allows in all
+	if max := db.maxKeys(); max > 0 && created > 0 && len(db.Data)+created > max {
+		db.limitRejections++
+		return &LimitError{Limit: "max_keys", Size: int64(len(db.Data) + created), Max: int64(max)}
+	}
+
+	for _, key := range keys {
+		db.store(key, db.detach(values[key]))
+	}
+	return nil
+}
+
+// Swap exchanges the values of two keys under one write lock, TTLs
+// included, so readers see either both old values or both new ones, as
+// when flipping a blue and a green config. Tags and dependencies stay with
+// the keys. It reports false and changes nothing unless both keys exist.
+func (db *Database) Swap(a, b string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	valueA, existsA := db.Data[a]
+	valueB, existsB := db.Data[b]
+	if !existsA || !existsB || db.isExpired(valueA) || db.isExpired(valueB) {
+		return false, nil
+	}
+	if a == b {
+		return true, nil
+	}
+	if err := db.checkLimits(a, valueB); err != nil {
+		return false, err
+	}
+	if err := db.checkLimits(b, valueA); err != nil {
+		return false, err
+	}
+
+	// Tags and dependencies stay with their keys rather than moving with
+	// the values, so a swap never makes a key depend on itself, and the
+	// two keys don't invalidate each other when one depends on the other
+	swappedA, swappedB := valueB.Clone(), valueA.Clone()
+	swappedA.Tags, swappedA.DependsOn = nil, nil
+	swappedB.Tags, swappedB.DependsOn = nil, nil
+	db.put(a, swappedA)
+	db.put(b, swappedB)
+	db.invalidateDependents(a, a, b)
+	db.invalidateDependents(b, a, b)
+	return true, nil
+}
+
+func sortedKeys(values map[string]*TriffValue) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (db *Database) maxKeys() int {
+	if db.config == nil {
+		return 0
+	}
+	return db.config.Limits.MaxKeys
+}
@@ -0,0 +1,62 @@
+package core
+
+import "testing"
+
+func setString(t *testing.T, db *Database, key, value string) {
+	t.Helper()
+	if err := db.Set(key, &TriffValue{Type: STRING, Data: value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSwapKeepsDependencies swaps two keys of which one depends on the
+// other, with a third depending on the second
+func TestSwapKeepsDependencies(t *testing.T) {
+	db := NewDatabase(&Config{})
+	setString(t, db, "a", "A")
+	setString(t, db, "b", "B")
+	setString(t, db, "c", "C")
+	setString(t, db, "d", "D")
+	if _, err := db.Depend("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Depend("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Depend("d", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Tag("a", "blue"); err != nil {
+		t.Fatal(err)
+	}
+
+	swapped, err := db.Swap("a", "b")
+	if err != nil || !swapped {
+		t.Fatalf("Swap = %v, %v", swapped, err)
+	}
+	for key, want := range map[string]string{"a": "B", "b": "A"} {
+		value, ok := db.Get(key)
+		if !ok || value.Data != want {
+			t.Errorf("%q = %v after the swap, want %q", key, value, want)
+		}
+	}
+	if deps, _ := db.KeyDependencies("a"); len(deps) != 0 {
+		t.Errorf("a depends on %q, want nothing", deps)
+	}
+	if deps, _ := db.KeyDependencies("b"); len(deps) != 1 || deps[0] != "a" {
+		t.Errorf("b depends on %q, want [a]", deps)
+	}
+	if tags, _ := db.KeyTags("a"); len(tags) != 1 || tags[0] != "blue" {
+		t.Errorf("a is tagged %q, want [blue]", tags)
+	}
+	if tags, _ := db.KeyTags("b"); len(tags) != 0 {
+		t.Errorf("b is tagged %q, want nothing", tags)
+	}
+
+	// Keys depending on the swapped ones from outside are invalidated
+	for _, key := range []string{"c", "d"} {
+		if _, ok := db.Get(key); ok {
+			t.Errorf("%q survived a swap of what it depends on", key)
+		}
+	}
+}
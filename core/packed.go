@@ -0,0 +1,369 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+)
+
+// Small hashes, lists and sets are kept packed, like Redis's listpacks:
+// their strings back to back in a single string, each preceded by its
+// length as a uvarint. A packed value is one allocation instead of a map
+// or slice with one per element, at the cost of a linear scan to find an
+// element. Writes go through a decoded copy and are packed again, so
+// storage.compact keeps the limits low enough for that to stay cheap.
+
+// DefaultCompact are the packing limits used when none are configured
+var DefaultCompact = CompactConfig{
+	HashMaxEntries: 128,
+	HashMaxValue:   64,
+	ListMaxEntries: 128,
+	ListMaxValue:   64,
+	SetMaxEntries:  128,
+	SetMaxValue:    64,
+}
+
+// PackedHash is a packed hash: fields and values in turn, sorted by field
+type PackedHash string
+
+// PackedList is a packed list, in list order
+type PackedList string
+
+// PackedSet is a packed set, sorted
+type PackedSet string
+
+// PackHash packs fields when the hash is within the limits, returning
+// fields itself otherwise
+func (c CompactConfig) PackHash(fields map[string]string) interface{} {
+	if len(fields) == 0 || len(fields) > c.HashMaxEntries {
+		return fields
+	}
+	names := make([]string, 0, len(fields))
+	size := 0
+	for field, value := range fields {
+		if len(field) > c.HashMaxValue || len(value) > c.HashMaxValue {
+			return fields
+		}
+		names = append(names, field)
+		size += len(field) + len(value) + 2
+	}
+	sort.Strings(names)
+
+	packed := make([]byte, 0, size)
+	for _, field := range names {
+		packed = appendPacked(packed, field)
+		packed = appendPacked(packed, fields[field])
+	}
+	return PackedHash(packed)
+}
+
+// PackList packs items when the list is within the limits. The second
+// result is false, and the list should be kept as is, otherwise.
+func (c CompactConfig) PackList(items []string) (PackedList, bool) {
+	if len(items) == 0 || len(items) > c.ListMaxEntries {
+		return "", false
+	}
+	size := 0
+	for _, item := range items {
+		if len(item) > c.ListMaxValue {
+			return "", false
+		}
+		size += len(item) + 1
+	}
+	packed := make([]byte, 0, size)
+	for _, item := range items {
+		packed = appendPacked(packed, item)
+	}
+	return PackedList(packed), true
+}
+
+// PackSet packs members when the set is within the limits. The second
+// result is false, and the set should be kept as is, otherwise.
+func (c CompactConfig) PackSet(members map[string]struct{}) (PackedSet, bool) {
+	if len(members) == 0 || len(members) > c.SetMaxEntries {
+		return "", false
+	}
+	sorted := make([]string, 0, len(members))
+	size := 0
+	for member := range members {
+		if len(member) > c.SetMaxValue {
+			return "", false
+		}
+		sorted = append(sorted, member)
+		size += len(member) + 1
+	}
+	sort.Strings(sorted)
+	packed := make([]byte, 0, size)
+	for _, member := range sorted {
+		packed = appendPacked(packed, member)
+	}
+	return PackedSet(packed), true
+}
+
+// Len returns the number of fields
+func (h PackedHash) Len() int {
+	return countPacked(string(h)) / 2
+}
+
+// Get returns the value of a field
+func (h PackedHash) Get(field string) (string, bool) {
+	found, ok := "", false
+	h.Range(func(f, value string) bool {
+		if f >= field {
+			found, ok = value, f == field
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// Range calls fn for each field in order until it returns false
+func (h PackedHash) Range(fn func(field, value string) bool) {
+	rest := string(h)
+	for rest != "" {
+		var field, value string
+		field, rest = nextPacked(rest)
+		value, rest = nextPacked(rest)
+		if !fn(field, value) {
+			return
+		}
+	}
+}
+
+// Map unpacks the hash into a new map
+func (h PackedHash) Map() map[string]string {
+	fields := make(map[string]string, h.Len())
+	h.Range(func(field, value string) bool {
+		fields[field] = value
+		return true
+	})
+	return fields
+}
+
+// MarshalJSON encodes the hash as the object it stands for
+func (h PackedHash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Map())
+}
+
+// Len returns the number of items
+func (l PackedList) Len() int {
+	return countPacked(string(l))
+}
+
+// Items unpacks the list into a new slice
+func (l PackedList) Items() []string {
+	return unpack(string(l))
+}
+
+// MarshalJSON encodes the list as the array it stands for
+func (l PackedList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Items())
+}
+
+// Len returns the number of members
+func (s PackedSet) Len() int {
+	return countPacked(string(s))
+}
+
+// Has reports whether member is in the set
+func (s PackedSet) Has(member string) bool {
+	rest := string(s)
+	for rest != "" {
+		var m string
+		m, rest = nextPacked(rest)
+		if m >= member {
+			return m == member
+		}
+	}
+	return false
+}
+
+// Members unpacks the set into a new slice, sorted
+func (s PackedSet) Members() []string {
+	return unpack(string(s))
+}
+
+// Map unpacks the set into a new map
+func (s PackedSet) Map() map[string]struct{} {
+	members := make(map[string]struct{}, s.Len())
+	rest := string(s)
+	for rest != "" {
+		var member string
+		member, rest = nextPacked(rest)
+		members[member] = struct{}{}
+	}
+	return members
+}
+
+// MarshalJSON encodes the set as an array of its members
+func (s PackedSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Members())
+}
+
+func appendPacked(packed []byte, s string) []byte {
+	packed = binary.AppendUvarint(packed, uint64(len(s)))
+	return append(packed, s...)
+}
+
+// nextPacked splits the first string off packed data
+func nextPacked(packed string) (string, string) {
+	var n uint64
+	var shift uint
+	i := 0
+	for ; i < len(packed); i++ {
+		b := packed[i]
+		n |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			i++
+			break
+		}
+		shift += 7
+	}
+	end := i + int(n)
+	return packed[i:end], packed[end:]
+}
+
+func countPacked(packed string) int {
+	n := 0
+	for packed != "" {
+		_, packed = nextPacked(packed)
+		n++
+	}
+	return n
+}
+
+func unpack(packed string) []string {
+	items := make([]string, 0, countPacked(packed))
+	for packed != "" {
+		var item string
+		item, packed = nextPacked(packed)
+		items = append(items, item)
+	}
+	return items
+}
+
+// StringMap returns hash data stored as a map or packed as a map. A stored
+// map is returned as is and must not be modified.
+func StringMap(data interface{}) (map[string]string, bool) {
+	switch fields := data.(type) {
+	case map[string]string:
+		return fields, true
+	case PackedHash:
+		return fields.Map(), true
+	}
+	return nil, false
+}
+
+// SetMembers returns set data stored as a map or packed, or decoded from
+// JSON, as a map. A stored map is returned as is and must not be modified.
+func SetMembers(data interface{}) (map[string]struct{}, bool) {
+	switch members := data.(type) {
+	case map[string]struct{}:
+		return members, true
+	case PackedSet:
+		return members.Map(), true
+	case []string:
+		set := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			set[member] = struct{}{}
+		}
+		return set, true
+	case []interface{}:
+		// A packed set encodes as an array of its members
+		set := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			s, ok := member.(string)
+			if !ok {
+				return nil, false
+			}
+			set[s] = struct{}{}
+		}
+		return set, true
+	case map[string]interface{}:
+		// And a map as an object with its members as keys
+		set := make(map[string]struct{}, len(members))
+		for member := range members {
+			set[member] = struct{}{}
+		}
+		return set, true
+	}
+	return nil, false
+}
+
+// ListItems returns list data stored as a slice or packed, or decoded from
+// JSON, as a slice. A stored slice is returned as is and must not be
+// modified.
+func ListItems(data interface{}) ([]string, bool) {
+	switch items := data.(type) {
+	case []string:
+		return items, true
+	case PackedList:
+		return items.Items(), true
+	case []interface{}:
+		list := make([]string, len(items))
+		for i, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// compact packs a small hash, list or set being stored, including one
+// decoded from a snapshot. Callers must hold the write lock.
+func (db *Database) compact(value *TriffValue) {
+	limits := DefaultCompact
+	if db.config != nil {
+		limits = db.config.Storage.Compact
+	}
+	switch value.Type {
+	case HASH:
+		switch fields := value.Data.(type) {
+		case map[string]string:
+			value.Data = limits.PackHash(fields)
+		case map[string]interface{}:
+			converted := make(map[string]string, len(fields))
+			for field, v := range fields {
+				s, ok := v.(string)
+				if !ok {
+					return
+				}
+				converted[field] = s
+			}
+			if packed, ok := limits.PackHash(converted).(PackedHash); ok {
+				value.Data = packed
+			}
+		}
+	case LIST:
+		if _, packed := value.Data.(PackedList); packed {
+			return
+		}
+		items, ok := ListItems(value.Data)
+		if !ok {
+			return
+		}
+		if packed, ok := limits.PackList(items); ok {
+			value.Data = packed
+		} else {
+			value.Data = items
+		}
+	case SET:
+		if _, packed := value.Data.(PackedSet); packed {
+			return
+		}
+		members, ok := SetMembers(value.Data)
+		if !ok {
+			return
+		}
+		if packed, ok := limits.PackSet(members); ok {
+			value.Data = packed
+		} else {
+			value.Data = members
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Time partitions split the keys under a configured prefix by the period
+// named in them, e.g. logs:2024-06-01:req-17 is in the partition
+// logs:2024-06-01. A partition is kept for Retain periods from its start
+// and then dropped whole: its keys are tracked per partition as they are
+// written, so CleanupExpired deletes them without scanning the keyspace or
+// checking TTLs. Each key also gets the partition's end as its TTL, so
+// reads stop seeing it as soon as the partition expires.
+
+// ErrPartitionNotFound is returned when dropping a partition that holds no keys
+var ErrPartitionNotFound = errs.Newf(errs.ErrNotFound, "partition not found")
+
+// partitionPeriods are the supported periods and how partition names
+// format their start
+var partitionPeriods = map[string]struct {
+	layout string
+	length time.Duration
+}{
+	"hour": {"2006-01-02T15", time.Hour},
+	"day":  {"2006-01-02", 24 * time.Hour},
+}
+
+// ValidPartitionPeriod reports whether period can be used in a PartitionConfig
+func ValidPartitionPeriod(period string) bool {
+	_, ok := partitionPeriods[period]
+	return ok
+}
+
+// PartitionInfo describes a time partition for listing purposes
+type PartitionInfo struct {
+	Name    string    `json:"name"`
+	Prefix  string    `json:"prefix"`
+	Start   time.Time `json:"start"`
+	Expires time.Time `json:"expires"`
+	Keys    int       `json:"keys"`
+}
+
+// partition is a time partition and the keys written to it
+type partition struct {
+	prefix  string
+	start   time.Time
+	expires time.Time
+	keys    map[string]struct{}
+}
+
+// findPartition returns the partition key belongs to under config, with
+// no keys. Keys under a partitioned prefix whose next segment isn't a
+// period start are not partitioned.
+func findPartition(config *Config, key string) (string, *partition, bool) {
+	if config == nil {
+		return "", nil, false
+	}
+	for _, pc := range config.Storage.Partitions {
+		if len(key) <= len(pc.Prefix)+1 || !strings.HasPrefix(key, pc.Prefix) || key[len(pc.Prefix)] != ':' {
+			continue
+		}
+		period, ok := partitionPeriods[pc.Period]
+		if !ok {
+			continue
+		}
+		id := key[len(pc.Prefix)+1:]
+		if i := strings.IndexByte(id, ':'); i >= 0 {
+			id = id[:i]
+		}
+		start, err := time.ParseInLocation(period.layout, id, time.UTC)
+		if err != nil {
+			continue
+		}
+		return pc.Prefix + ":" + id, &partition{
+			prefix:  pc.Prefix,
+			start:   start,
+			expires: start.Add(time.Duration(pc.Retain) * period.length),
+		}, true
+	}
+	return "", nil, false
+}
+
+// checkPartition refuses writes to a partition that has already expired,
+// which would only be dropped again. Callers must hold the write lock.
+func (db *Database) checkPartition(key string) error {
+	name, p, ok := findPartition(db.config, key)
+	if ok && !db.now().Before(p.expires) {
+		return errs.Newf(errs.ErrLimit, "partition %s expired at %s", name, p.expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// stampPartition caps the TTL of a value written to a partition at the
+// partition's end. Callers must hold the write lock.
+func (db *Database) stampPartition(key string, value *TriffValue) {
+	if _, p, ok := findPartition(db.config, key); ok {
+		if end := p.expires.Unix(); value.TTL == 0 || value.TTL > end {
+			value.TTL = end
+		}
+	}
+}
+
+// trackPartition adds a written key to its partition, or removes a
+// deleted one. Callers must hold the write lock.
+func (db *Database) trackPartition(key string, value *TriffValue) {
+	name, found, ok := findPartition(db.config, key)
+	if !ok {
+		return
+	}
+	p := db.partitions[name]
+	if value == nil {
+		if p != nil {
+			delete(p.keys, key)
+			if len(p.keys) == 0 {
+				delete(db.partitions, name)
+			}
+		}
+		return
+	}
+	if p == nil {
+		p = found
+		p.keys = make(map[string]struct{})
+		db.partitions[name] = p
+	}
+	p.keys[key] = struct{}{}
+}
+
+// dropExpiredPartitions drops the partitions whose retention has passed.
+// Callers must hold the write lock.
+func (db *Database) dropExpiredPartitions(now time.Time) {
+	for name, p := range db.partitions {
+		if !now.Before(p.expires) {
+			db.dropPartition(name, p)
+		}
+	}
+}
+
+// dropPartition deletes a partition's keys as expired: they leave no
+// history or tombstone. Callers must hold the write lock.
+func (db *Database) dropPartition(name string, p *partition) {
+	// Detached first, so trackPartition has nothing left to update
+	delete(db.partitions, name)
+	for key := range p.keys {
+		value, exists := db.Data[key]
+		if !exists {
+			continue
+		}
+		delete(db.Data, key)
+		db.memoryUsed -= value.size
+		db.memoryByType[value.Type] -= value.size
+		db.onWrite(key, nil)
+		db.emitChange(ChangeExpire, key, value)
+		db.invalidateDependents(key)
+	}
+}
+
+// DropPartition drops a partition before its retention runs out, e.g.
+// DropPartition("logs:2024-06-01"), returning how many keys it held
+func (db *Database) DropPartition(name string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	p, exists := db.partitions[name]
+	if !exists {
+		return 0, ErrPartitionNotFound
+	}
+	keys := len(p.keys)
+	db.dropPartition(name, p)
+	return keys, nil
+}
+
+// Partitions lists the time partitions holding keys, by name
+func (db *Database) Partitions() []PartitionInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	infos := make([]PartitionInfo, 0, len(db.partitions))
+	for name, p := range db.partitions {
+		infos = append(infos, PartitionInfo{
+			Name:    name,
+			Prefix:  p.prefix,
+			Start:   p.start,
+			Expires: p.expires,
+			Keys:    len(p.keys),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// repartition rebuilds the partitions after storage.partitions changed,
+// moving the TTLs stamped under the old settings to the new partition ends.
+// Callers must hold the write lock.
+func (db *Database) repartition(old *Config) {
+	if old != nil && db.config != nil && reflect.DeepEqual(old.Storage.Partitions, db.config.Storage.Partitions) {
+		return
+	}
+	db.partitions = make(map[string]*partition)
+	for key, value := range db.Data {
+		if _, p, ok := findPartition(old, key); ok && value.TTL == p.expires.Unix() {
+			value.TTL = 0
+		}
+		db.stampPartition(key, value)
+		db.trackPartition(key, value)
+	}
+}
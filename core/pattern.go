@@ -0,0 +1,148 @@
+package core
+
+import "strings"
+
+// MatchPattern reports whether key matches a Redis-style glob pattern.
+// Supported syntax: * (any sequence), ? (any single byte), [abc] / [a-z]
+// character classes (with ^ for negation) and \ to escape a special byte.
+func MatchPattern(pattern, key string) bool {
+	if pattern == "*" {
+		return true
+	}
+	return matchGlob(pattern, key)
+}
+
+func matchGlob(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if matchGlob(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' && end+1 < len(pattern) {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				// Unterminated class, treat '[' literally
+				if str[0] != '[' {
+					return false
+				}
+				pattern = pattern[1:]
+				str = str[1:]
+				continue
+			}
+			if !matchClass(pattern[1:end], str[0]) {
+				return false
+			}
+			pattern = pattern[end+1:]
+			str = str[1:]
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			pattern = pattern[1:]
+			str = str[1:]
+		}
+	}
+	return len(str) == 0
+}
+
+// literalPrefix returns the bytes every key matching pattern starts with
+func literalPrefix(pattern string) string {
+	var prefix []byte
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?', '[':
+			return string(prefix)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			prefix = append(prefix, pattern[i])
+		default:
+			prefix = append(prefix, c)
+		}
+	}
+	return string(prefix)
+}
+
+// matchClass checks a single byte against the body of a [...] class
+func matchClass(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		lo := class[i]
+		if lo == '\\' && i+1 < len(class) {
+			i++
+			lo = class[i]
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			hi := class[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+		} else if c == lo {
+			matched = true
+		}
+	}
+
+	if negate {
+		return !matched
+	}
+	return matched
+}
+
+// EscapePattern escapes glob metacharacters so the result matches s literally
+func EscapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Persistence errors
+var (
+	ErrNoPersistence  = errors.New("persistence is not configured")
+	ErrNoSnapshots    = errors.New("persistence engine keeps no snapshot history")
+	ErrNoRestorePoint = errs.Newf(errs.ErrNotFound, "no snapshot at or before the requested time")
+)
+
+// SnapshotInfo describes a restore point
+type SnapshotInfo struct {
+	Name    string    `json:"name"`    // Identifies the snapshot to its engine
+	Time    time.Time `json:"time"`    // When the snapshot was taken
+	Size    int64     `json:"size"`    // Bytes on disk
+	Current bool      `json:"current"` // The snapshot the database loads at startup
+}
+
+// SnapshotHistory is implemented by persistence engines that keep older
+// snapshot generations to restore from
+type SnapshotHistory interface {
+	// Snapshots lists restore points, newest first
+	Snapshots() ([]SnapshotInfo, error)
+	// LoadSnapshot reads the restore point with the given name
+	LoadSnapshot(name string) (map[string]*TriffValue, error)
+}
+
+// SetPersistence sets the engine Save and Load use
+func (db *Database) SetPersistence(engine PersistenceEngine) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.persistence = engine
+}
+
+// Save writes a snapshot of the keyspace. It is taken with Snapshot, so
+// writes are only blocked while the key index is copied, not while values
+// are encoded. It returns ErrBulkLoading while a bulk load runs, and
+// ErrLoading while the snapshot loads, so a half-loaded keyspace never
+// replaces it.
+func (db *Database) Save() error {
+	if atomic.LoadInt32(&db.bulkLoads) > 0 {
+		return ErrBulkLoading
+	}
+	if db.Loading() {
+		return ErrLoading
+	}
+	db.mu.RLock()
+	engine := db.persistence
+	db.mu.RUnlock()
+	if engine == nil {
+		return ErrNoPersistence
+	}
+
+	seq := atomic.LoadUint64(&db.changes.seq)
+	if err := engine.Save(db.Snapshot().Data()); err != nil {
+		return err
+	}
+	db.markSaved(seq)
+	return nil
+}
+
+// Load replaces the keyspace with the engine's current snapshot. Commands
+// fail with ErrLoading while it runs.
+func (db *Database) Load() error {
+	atomic.AddInt32(&db.loading, 1)
+	defer atomic.AddInt32(&db.loading, -1)
+
+	db.mu.RLock()
+	engine := db.persistence
+	db.mu.RUnlock()
+	if engine == nil {
+		return ErrNoPersistence
+	}
+
+	data, err := engine.Load()
+	if err != nil {
+		return err
+	}
+	db.replaceData(data)
+	db.markSaved(atomic.LoadUint64(&db.changes.seq))
+	return nil
+}
+
+// Snapshots lists the restore points of the persistence engine, newest
+// first
+func (db *Database) Snapshots() ([]SnapshotInfo, error) {
+	history, err := db.snapshotHistory()
+	if err != nil {
+		return nil, err
+	}
+	return history.Snapshots()
+}
+
+// RestoreTo replaces the keyspace with the newest snapshot taken at or
+// before t, for undoing a bad FLUSHALL or mass delete. The state being
+// replaced is saved first, so a restore can itself be undone.
+func (db *Database) RestoreTo(t time.Time) (SnapshotInfo, error) {
+	history, err := db.snapshotHistory()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	snapshots, err := history.Snapshots()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	point, found := SelectRestorePoint(snapshots, t)
+	if !found {
+		return SnapshotInfo{}, ErrNoRestorePoint
+	}
+
+	// Load before saving: the save rotates generations and may prune the
+	// one being restored
+	data, err := history.LoadSnapshot(point.Name)
+	if err != nil {
+		return point, fmt.Errorf("loading %s: %w", point.Name, err)
+	}
+	if err := db.Save(); err != nil {
+		return point, fmt.Errorf("saving current state: %w", err)
+	}
+	db.replaceData(data)
+	return point, nil
+}
+
+// snapshotHistory returns the persistence engine if it keeps generations
+func (db *Database) snapshotHistory() (SnapshotHistory, error) {
+	db.mu.RLock()
+	engine := db.persistence
+	db.mu.RUnlock()
+
+	if engine == nil {
+		return nil, ErrNoPersistence
+	}
+	history, ok := engine.(SnapshotHistory)
+	if !ok {
+		return nil, ErrNoSnapshots
+	}
+	return history, nil
+}
+
+// replaceData swaps in loaded data, keeping each value's version and
+// timestamps. Expired values are dropped. Change subscribers see a flush
+// followed by a set per key.
+func (db *Database) replaceData(data map[string]*TriffValue) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.Data = newKeyspace(db.config, len(data))
+	db.arena.reset()
+	db.memoryUsed = 0
+	db.memoryByType = make(map[DataType]int64)
+	db.indexes.Reset()
+	db.search.Reset()
+	db.history.Reset()
+	db.tombstones = make(map[string]*Tombstone)
+	db.partitions = make(map[string]*partition)
+	db.tags.reset()
+	db.dependents.reset()
+	db.order.reset()
+	db.resetAggregates()
+	db.emitChange(ChangeFlush, "", nil)
+
+	release := db.holdAggregates()
+	defer release()
+	for key, value := range data {
+		if value == nil || db.isExpired(value) {
+			continue
+		}
+		value.initAccess(nil, db.now())
+		db.compact(value)
+		value.size = SizeOf(key, value)
+		db.memoryUsed += value.size
+		db.memoryByType[value.Type] += value.size
+		key = db.pack(key, true, value)
+		db.Data[key] = value
+		db.onWrite(key, value)
+		db.emitChange(ChangeSet, key, value)
+	}
+}
+
+// SelectRestorePoint picks the newest snapshot taken at or before t from
+// snapshots listed newest first
+func SelectRestorePoint(snapshots []SnapshotInfo, t time.Time) (SnapshotInfo, bool) {
+	for _, snapshot := range snapshots {
+		if !snapshot.Time.After(t) {
+			return snapshot, true
+		}
+	}
+	return SnapshotInfo{}, false
+}
+
+// ParseRestoreTime parses a restore point given as RFC 3339 or as Unix
+// seconds
+func ParseRestoreTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: use RFC 3339 or Unix seconds", s)
+	}
+	return t, nil
+}
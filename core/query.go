@@ -0,0 +1,443 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query limits: rows returned when no LIMIT is given, and the largest LIMIT
+const (
+	DefaultQueryLimit = 1000
+	MaxQueryLimit     = 10000
+)
+
+// QueryKeyField is a pseudo-field holding the key itself, usable in
+// SELECT, WHERE and ORDER BY
+const QueryKeyField = "_key"
+
+// ErrQuerySyntax wraps all query parse errors
+var ErrQuerySyntax = errors.New("query syntax error")
+
+// Query is a parsed SELECT over hash values:
+//
+//	SELECT field[, field...] | * FROM pattern
+//	  [WHERE field op value [AND ...]]
+//	  [ORDER BY field [ASC|DESC]] [LIMIT n [OFFSET m]]
+//
+// op is one of =, !=, <, <=, > and >=. Values compare numerically when both
+// sides are numbers. Conditions on a missing field are false.
+type Query struct {
+	Fields  []string         `json:"fields"` // Empty selects all fields
+	Pattern string           `json:"pattern"`
+	Where   []QueryCondition `json:"where,omitempty"`
+	OrderBy string           `json:"order_by,omitempty"`
+	Desc    bool             `json:"desc,omitempty"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset,omitempty"`
+}
+
+// QueryCondition is a single field comparison
+type QueryCondition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// QueryRow is one matching hash with its projected fields
+type QueryRow struct {
+	Key    string            `json:"key"`
+	Fields map[string]string `json:"fields"`
+}
+
+// QueryResult holds a page of rows and how they were found
+type QueryResult struct {
+	Rows    []QueryRow `json:"rows"`
+	Total   int        `json:"total"`           // Matching rows before LIMIT and OFFSET
+	Scanned int        `json:"scanned"`         // Keys examined
+	Index   string     `json:"index,omitempty"` // Secondary index used to find candidates
+}
+
+// ParseQuery parses the query language described on Query
+func ParseQuery(input string) (*Query, error) {
+	tokens, err := tokenizeQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	q := &Query{Limit: DefaultQueryLimit}
+
+	if err := p.keyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.peek() == "*" {
+		p.next()
+	} else {
+		for {
+			field, err := p.word("field name")
+			if err != nil {
+				return nil, err
+			}
+			q.Fields = append(q.Fields, field)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.keyword("FROM"); err != nil {
+		return nil, err
+	}
+	if q.Pattern, err = p.word("key pattern"); err != nil {
+		return nil, err
+	}
+
+	if p.peekKeyword("WHERE") {
+		p.next()
+		for {
+			var cond QueryCondition
+			if cond.Field, err = p.word("field name"); err != nil {
+				return nil, err
+			}
+			switch op := p.next(); op {
+			case "=", "!=", "<", "<=", ">", ">=":
+				cond.Op = op
+			case "<>":
+				cond.Op = "!="
+			default:
+				return nil, fmt.Errorf("%w: expected comparison operator, got %q", ErrQuerySyntax, op)
+			}
+			if cond.Value, err = p.word("value"); err != nil {
+				return nil, err
+			}
+			q.Where = append(q.Where, cond)
+			if !p.peekKeyword("AND") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.peekKeyword("ORDER") {
+		p.next()
+		if err := p.keyword("BY"); err != nil {
+			return nil, err
+		}
+		if q.OrderBy, err = p.word("field name"); err != nil {
+			return nil, err
+		}
+		if p.peekKeyword("DESC") {
+			p.next()
+			q.Desc = true
+		} else if p.peekKeyword("ASC") {
+			p.next()
+		}
+	}
+
+	if p.peekKeyword("LIMIT") {
+		p.next()
+		if q.Limit, err = p.number(); err != nil {
+			return nil, err
+		}
+		if q.Limit > MaxQueryLimit {
+			return nil, fmt.Errorf("%w: LIMIT must be at most %d", ErrQuerySyntax, MaxQueryLimit)
+		}
+		if p.peekKeyword("OFFSET") {
+			p.next()
+			if q.Offset, err = p.number(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrQuerySyntax, tok)
+	}
+	return q, nil
+}
+
+// Query runs a query against hash values, using a secondary index declared
+// on the same pattern for one of the WHERE fields when there is one
+func (db *Database) Query(q *Query) (*QueryResult, error) {
+	return db.QueryContext(context.Background(), q)
+}
+
+// QueryContext is Query that gives up once ctx is done
+func (db *Database) QueryContext(ctx context.Context, q *Query) (*QueryResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := &QueryResult{Rows: []QueryRow{}}
+	candidates, index, err := db.queryCandidates(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	result.Index = index
+
+	var matched []QueryRow
+	for _, key := range candidates {
+		result.Scanned++
+		if err := scanAborted(ctx, result.Scanned); err != nil {
+			return nil, err
+		}
+		value, exists := db.Data[key]
+		if !exists || value.Type != HASH || db.isExpired(value) || !MatchPattern(q.Pattern, key) {
+			continue
+		}
+		fields := hashFields(value)
+		fields[QueryKeyField] = key
+		if !q.matches(fields) {
+			continue
+		}
+		matched = append(matched, QueryRow{Key: key, Fields: fields})
+	}
+
+	if q.OrderBy != "" {
+		sort.SliceStable(matched, func(i, j int) bool {
+			a, okA := matched[i].Fields[q.OrderBy]
+			b, okB := matched[j].Fields[q.OrderBy]
+			if okA != okB {
+				return okA // Rows without the field sort last
+			}
+			c := compareIndexValues(a, b)
+			if q.Desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	result.Total = len(matched)
+	if q.Offset < len(matched) {
+		matched = matched[q.Offset:]
+		if len(matched) > q.Limit {
+			matched = matched[:q.Limit]
+		}
+		for _, row := range matched {
+			result.Rows = append(result.Rows, q.project(row))
+		}
+	}
+	return result, nil
+}
+
+// queryCandidates returns the keys to examine in key order, narrowed by an
+// index when possible. Callers must hold the read lock.
+func (db *Database) queryCandidates(ctx context.Context, q *Query) ([]string, string, error) {
+	for _, cond := range q.Where {
+		if cond.Op == "!=" {
+			continue
+		}
+		index, ok := db.indexes.find(q.Pattern, cond.Field)
+		if !ok {
+			continue
+		}
+
+		var keys []string
+		var err error
+		switch cond.Op {
+		case "=":
+			keys, err = db.indexes.FindEqual(index, cond.Value)
+		case "<", "<=":
+			keys, err = db.indexes.FindRange(index, RangeBound{Unbounded: true}, RangeBound{Value: cond.Value, Exclusive: cond.Op == "<"})
+		case ">", ">=":
+			keys, err = db.indexes.FindRange(index, RangeBound{Value: cond.Value, Exclusive: cond.Op == ">"}, RangeBound{Unbounded: true})
+		}
+		sort.Strings(keys)
+		return keys, index, err
+	}
+
+	keys := make([]string, 0)
+	i := 0
+	for key := range db.Data {
+		i++
+		if err := scanAborted(ctx, i); err != nil {
+			return nil, "", err
+		}
+		if MatchPattern(q.Pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, "", nil
+}
+
+func (q *Query) matches(fields map[string]string) bool {
+	for _, cond := range q.Where {
+		value, ok := fields[cond.Field]
+		if !ok {
+			return false
+		}
+		c := compareIndexValues(value, cond.Value)
+		var match bool
+		switch cond.Op {
+		case "=":
+			match = c == 0
+		case "!=":
+			match = c != 0
+		case "<":
+			match = c < 0
+		case "<=":
+			match = c <= 0
+		case ">":
+			match = c > 0
+		case ">=":
+			match = c >= 0
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// project keeps the selected fields of a row, dropping the key pseudo-field
+// unless it was asked for
+func (q *Query) project(row QueryRow) QueryRow {
+	if len(q.Fields) == 0 {
+		delete(row.Fields, QueryKeyField)
+		return row
+	}
+	projected := make(map[string]string, len(q.Fields))
+	for _, field := range q.Fields {
+		if value, ok := row.Fields[field]; ok {
+			projected[field] = value
+		}
+	}
+	return QueryRow{Key: row.Key, Fields: projected}
+}
+
+// find returns an index declared on exactly pattern and field
+func (im *IndexManager) find(pattern, field string) (string, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	for name, idx := range im.indexes {
+		if idx.Pattern == pattern && idx.Field == field {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// hashFields copies a hash value's fields as strings
+func hashFields(value *TriffValue) map[string]string {
+	fields := make(map[string]string)
+	switch data := value.Data.(type) {
+	case map[string]string:
+		for field, v := range data {
+			fields[field] = v
+		}
+	case PackedHash:
+		data.Range(func(field, v string) bool {
+			fields[field] = v
+			return true
+		})
+	case map[string]interface{}:
+		for field, v := range data {
+			fields[field] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *queryParser) peekKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *queryParser) keyword(keyword string) error {
+	if !p.peekKeyword(keyword) {
+		return fmt.Errorf("%w: expected %s, got %q", ErrQuerySyntax, keyword, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+// word consumes an identifier, pattern or value, unquoting quoted strings
+func (p *queryParser) word(what string) (string, error) {
+	tok := p.next()
+	switch {
+	case tok == "" || tok == "," || strings.ContainsAny(tok[:1], "=!<>"):
+		return "", fmt.Errorf("%w: expected %s, got %q", ErrQuerySyntax, what, tok)
+	case tok[0] == '\'' || tok[0] == '"':
+		return tok[1 : len(tok)-1], nil
+	}
+	return tok, nil
+}
+
+func (p *queryParser) number() (int, error) {
+	tok := p.next()
+	n, err := strconv.Atoi(tok)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: expected a non-negative number, got %q", ErrQuerySyntax, tok)
+	}
+	return n, nil
+}
+
+// tokenizeQuery splits a query into words, quoted strings (kept with their
+// quotes, escapes removed), commas and comparison operators
+func tokenizeQuery(input string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ',':
+			tokens = append(tokens, ",")
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			j := i + 1
+			if j < len(input) && (input[j] == '=' || (c == '<' && input[j] == '>')) {
+				j++
+			}
+			tokens = append(tokens, input[i:j])
+			i = j
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			sb.WriteByte(c)
+			j := i + 1
+			for ; j < len(input) && input[j] != c; j++ {
+				if input[j] == '\\' && j+1 < len(input) {
+					j++
+				}
+				sb.WriteByte(input[j])
+			}
+			if j >= len(input) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrQuerySyntax)
+			}
+			sb.WriteByte(c)
+			tokens = append(tokens, sb.String())
+			i = j + 1
+		default:
+			j := i
+			for j < len(input) && !strings.ContainsRune(" \t\n\r,=!<>'\"", rune(input[j])) {
+				j++
+			}
+			tokens = append(tokens, input[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,342 @@
+package core
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Queue defaults
+const (
+	DefaultVisibilityTimeout = 30 * time.Second
+	DefaultMaxAttempts       = 5
+	DeadLetterSuffix         = ":dlq"
+)
+
+var (
+	ErrJobNotFound    = errs.Newf(errs.ErrNotFound, "job not found")
+	ErrInvalidReceipt = errors.New("receipt does not match the job's current delivery")
+)
+
+// Job is a unit of work in a queue
+type Job struct {
+	ID          string    `json:"id"`
+	Queue       string    `json:"queue"`
+	Payload     string    `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	VisibleAt   time.Time `json:"visible_at"`
+	Receipt     string    `json:"receipt,omitempty"` // Identifies the current delivery for ACK/NACK
+
+	index    int // position in the queue heap
+	inflight bool
+}
+
+// QueueStats summarizes a queue
+type QueueStats struct {
+	Name     string `json:"name"`
+	Ready    int    `json:"ready"`    // Visible jobs waiting for a consumer
+	Delayed  int    `json:"delayed"`  // Jobs scheduled for later
+	Inflight int    `json:"inflight"` // Delivered jobs awaiting acknowledgement
+	Dead     int    `json:"dead"`     // Jobs in the dead-letter queue
+}
+
+// jobHeap orders jobs by the time they become visible
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].VisibleAt.Equal(h[j].VisibleAt) {
+		return h[i].EnqueuedAt.Before(h[j].EnqueuedAt)
+	}
+	return h[i].VisibleAt.Before(h[j].VisibleAt)
+}
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	job.index = -1
+	return job
+}
+
+// jobQueue holds one queue's jobs. Delayed, ready and in-flight jobs share
+// a heap keyed by visibility time: delivering a job pushes its visibility
+// out by the timeout, so unacknowledged jobs reappear automatically.
+type jobQueue struct {
+	jobs jobHeap
+	byID map[string]*Job
+}
+
+// QueueManager implements reliable job queues with visibility timeouts,
+// retries and dead-letter queues
+type QueueManager struct {
+	queues map[string]*jobQueue
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+// NewQueueManager creates an empty queue manager whose visibility timeouts
+// and delays go by clock
+func NewQueueManager(clock Clock) *QueueManager {
+	return &QueueManager{
+		queues: make(map[string]*jobQueue),
+		now:    clock.Now,
+	}
+}
+
+func (qm *QueueManager) queue(name string) *jobQueue {
+	q, exists := qm.queues[name]
+	if !exists {
+		q = &jobQueue{byID: make(map[string]*Job)}
+		qm.queues[name] = q
+	}
+	return q
+}
+
+// Enqueue adds a job that becomes visible after delay. maxAttempts of 0
+// uses DefaultMaxAttempts.
+func (qm *QueueManager) Enqueue(name, payload string, delay time.Duration, maxAttempts int) Job {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	now := qm.now()
+	job := &Job{
+		ID:          newJobID(),
+		Queue:       name,
+		Payload:     payload,
+		MaxAttempts: maxAttempts,
+		EnqueuedAt:  now,
+		VisibleAt:   now.Add(delay),
+	}
+	q := qm.queue(name)
+	heap.Push(&q.jobs, job)
+	q.byID[job.ID] = job
+	return *job
+}
+
+// Dequeue delivers the next visible job and hides it for the visibility
+// timeout. Jobs that have used up their attempts are moved to the
+// dead-letter queue instead of being delivered.
+func (qm *QueueManager) Dequeue(name string, visibility time.Duration) (Job, bool) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if visibility <= 0 {
+		visibility = DefaultVisibilityTimeout
+	}
+	q, exists := qm.queues[name]
+	if !exists {
+		return Job{}, false
+	}
+
+	now := qm.now()
+	for q.jobs.Len() > 0 {
+		job := q.jobs[0]
+		if job.VisibleAt.After(now) {
+			return Job{}, false
+		}
+		if job.Attempts >= job.MaxAttempts {
+			qm.deadLetter(q, job)
+			continue
+		}
+
+		job.Attempts++
+		job.inflight = true
+		job.Receipt = newJobID()
+		job.VisibleAt = now.Add(visibility)
+		heap.Fix(&q.jobs, job.index)
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// Ack removes a delivered job for good
+func (qm *QueueManager) Ack(name, id, receipt string) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	q, job, err := qm.delivered(name, id, receipt)
+	if err != nil {
+		return err
+	}
+	heap.Remove(&q.jobs, job.index)
+	delete(q.byID, id)
+	return nil
+}
+
+// Nack returns a delivered job to the queue after delay. Once the job has
+// used all its attempts it goes to the dead-letter queue.
+func (qm *QueueManager) Nack(name, id, receipt string, delay time.Duration) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	q, job, err := qm.delivered(name, id, receipt)
+	if err != nil {
+		return err
+	}
+	if job.Attempts >= job.MaxAttempts {
+		qm.deadLetter(q, job)
+		return nil
+	}
+	job.inflight = false
+	job.Receipt = ""
+	job.VisibleAt = qm.now().Add(delay)
+	heap.Fix(&q.jobs, job.index)
+	return nil
+}
+
+// Peek returns a job without delivering it
+func (qm *QueueManager) Peek(name, id string) (Job, bool) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if q, exists := qm.queues[name]; exists {
+		if job, exists := q.byID[id]; exists {
+			snapshot := *job
+			snapshot.Receipt = ""
+			return snapshot, true
+		}
+	}
+	return Job{}, false
+}
+
+// Stats returns counters for a queue
+func (qm *QueueManager) Stats(name string) QueueStats {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	return qm.stats(name)
+}
+
+// List returns stats for every queue, sorted by name
+func (qm *QueueManager) List() []QueueStats {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	names := make([]string, 0, len(qm.queues))
+	for name := range qm.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make([]QueueStats, 0, len(names))
+	for _, name := range names {
+		stats = append(stats, qm.stats(name))
+	}
+	return stats
+}
+
+// Purge drops a queue and all its jobs
+func (qm *QueueManager) Purge(name string) bool {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	_, exists := qm.queues[name]
+	delete(qm.queues, name)
+	return exists
+}
+
+// Reset drops all queues
+func (qm *QueueManager) Reset() {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	qm.queues = make(map[string]*jobQueue)
+}
+
+// setClock makes visibility timeouts and delays go by clock
+func (qm *QueueManager) setClock(clock Clock) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	qm.now = clock.Now
+}
+
+// stats counts jobs by state. Callers must hold qm.mu.
+func (qm *QueueManager) stats(name string) QueueStats {
+	stats := QueueStats{Name: name}
+	now := qm.now()
+	if q, exists := qm.queues[name]; exists {
+		for _, job := range q.jobs {
+			switch {
+			case job.inflight && job.VisibleAt.After(now):
+				stats.Inflight++
+			case job.VisibleAt.After(now):
+				stats.Delayed++
+			default:
+				stats.Ready++
+			}
+		}
+	}
+	if dead, exists := qm.queues[name+DeadLetterSuffix]; exists {
+		stats.Dead = dead.jobs.Len()
+	}
+	return stats
+}
+
+// delivered looks up an in-flight job and checks its receipt. Callers must hold qm.mu.
+func (qm *QueueManager) delivered(name, id, receipt string) (*jobQueue, *Job, error) {
+	q, exists := qm.queues[name]
+	if !exists {
+		return nil, nil, ErrJobNotFound
+	}
+	job, exists := q.byID[id]
+	if !exists {
+		return nil, nil, ErrJobNotFound
+	}
+	if !job.inflight || job.Receipt != receipt {
+		return nil, nil, ErrInvalidReceipt
+	}
+	return q, job, nil
+}
+
+// deadLetter moves a job to the queue's dead-letter queue. Callers must hold qm.mu.
+func (qm *QueueManager) deadLetter(q *jobQueue, job *Job) {
+	heap.Remove(&q.jobs, job.index)
+	delete(q.byID, job.ID)
+
+	dead := qm.queue(job.Queue + DeadLetterSuffix)
+	job.Queue += DeadLetterSuffix
+	job.inflight = false
+	job.Receipt = ""
+	job.Attempts = 0
+	job.VisibleAt = qm.now()
+	heap.Push(&dead.jobs, job)
+	dead.byID[job.ID] = job
+}
+
+// Queues returns the job queues of the database. Queue state lives next to
+// the keyspace like indexes do and is cleared by FlushAll.
+func (db *Database) Queues() *QueueManager {
+	return db.queues
+}
+
+func newJobID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
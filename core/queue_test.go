@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// TestQueueFollowsDatabaseClock checks that delays and visibility timeouts
+// go by the database's clock
+func TestQueueFollowsDatabaseClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	qm := NewQueueManager(clock)
+
+	qm.Enqueue("jobs", "payload", time.Minute, 0)
+	if _, ok := qm.Dequeue("jobs", time.Minute); ok {
+		t.Fatal("delayed job delivered early")
+	}
+	clock.Advance(time.Minute)
+	job, ok := qm.Dequeue("jobs", time.Minute)
+	if !ok {
+		t.Fatal("job not delivered once its delay passed on the clock")
+	}
+	if !job.VisibleAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("job hidden until %v, want a minute after %v", job.VisibleAt, clock.Now())
+	}
+	if _, ok := qm.Dequeue("jobs", time.Minute); ok {
+		t.Fatal("job delivered again within its visibility timeout")
+	}
+	clock.Advance(time.Minute)
+	if _, ok := qm.Dequeue("jobs", time.Minute); !ok {
+		t.Fatal("job not redelivered after its visibility timeout")
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// ErrLoading is returned for commands sent while the snapshot loads
+var ErrLoading = errs.Newf(errs.ErrLoading, "the snapshot is still loading")
+
+// Readiness tells an orchestrator such as Kubernetes whether this
+// database should be sent traffic
+type Readiness struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"` // Why it isn't ready
+}
+
+// Readiness reports the database ready once its snapshot has loaded and,
+// with server.replica set, once it has applied its primary's changes to
+// within server.ready_max_lag of the latest one reported with
+// SetPrimaryOffset
+func (db *Database) Readiness() Readiness {
+	var reasons []string
+	if db.Loading() {
+		reasons = append(reasons, "loading the snapshot")
+	}
+	if server := db.Config().Server; server.Replica {
+		if db.ReplicaOffset().Epoch == "" {
+			reasons = append(reasons, "no changes applied from a primary yet")
+		} else if lag := db.ReplicaLag(); lag > uint64(server.ReadyMaxLag) {
+			reasons = append(reasons, fmt.Sprintf("%d changes behind the primary", lag))
+		}
+	}
+	return Readiness{Ready: len(reasons) == 0, Reasons: reasons}
+}
+
+// Loading reports whether a snapshot load is running or about to start
+// with StartLoad
+func (db *Database) Loading() bool {
+	return atomic.LoadInt32(&db.loading) > 0
+}
+
+// StartLoad marks the database loading and runs Load in the background,
+// sending its result on the returned channel. Servers can start before it
+// finishes: their commands fail with ErrLoading and readiness probes
+// fail until it does, and liveness probes are answered meanwhile.
+func (db *Database) StartLoad() <-chan error {
+	atomic.AddInt32(&db.loading, 1)
+	done := make(chan error, 1)
+	go func() {
+		err := db.Load()
+		atomic.AddInt32(&db.loading, -1)
+		done <- err
+	}()
+	return done
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Triff doesn't replicate on its own, but replicas kept in step from a
+// primary's change stream (say, by a CDC consumer applying its events) can
+// still give clients read-your-writes consistency. A write on the primary
+// is identified by a session token: the primary's epoch, chosen at random
+// when the database is created, and the Seq of the write's change event.
+// Whatever applies changes to a replica reports the primary's epoch and
+// the Seq it has applied, and a read holding a token waits until the
+// replica has got that far.
+//
+// The same process can acknowledge what it applied back to the primary,
+// per replica, so writers there can wait for their writes to reach a
+// number of replicas (WAIT).
+
+// ErrUnknownEpoch is returned for a session token from a primary this
+// database neither is nor follows, e.g. one that has since restarted
+var ErrUnknownEpoch = errors.New("session token is from another primary epoch")
+
+// ErrInvalidSession is returned for a malformed session token
+var ErrInvalidSession = errors.New("invalid session token")
+
+// SessionToken identifies a write on a primary
+type SessionToken struct {
+	Epoch  string
+	Offset uint64
+}
+
+// String encodes the token as epoch.offset
+func (t SessionToken) String() string {
+	return t.Epoch + "." + strconv.FormatUint(t.Offset, 10)
+}
+
+// ParseSessionToken decodes a token encoded with String
+func ParseSessionToken(s string) (SessionToken, error) {
+	epoch, offset, found := strings.Cut(s, ".")
+	if !found || epoch == "" {
+		return SessionToken{}, ErrInvalidSession
+	}
+	n, err := strconv.ParseUint(offset, 10, 64)
+	if err != nil {
+		return SessionToken{}, ErrInvalidSession
+	}
+	return SessionToken{Epoch: epoch, Offset: n}, nil
+}
+
+// replicaAcks is how far each replica of this database has acknowledged
+// applying its changes, as reported with AckReplica
+type replicaAcks struct {
+	mu      sync.Mutex
+	offsets map[string]uint64 // By replica ID
+	advance chan struct{}     // Closed and replaced whenever an offset moves
+}
+
+// replicaState is how far a replica has applied its primary's changes
+type replicaState struct {
+	mu      sync.Mutex
+	epoch   string
+	offset  uint64
+	head    uint64        // The primary's latest Seq as last reported, for readiness
+	advance chan struct{} // Closed and replaced whenever offset moves
+}
+
+// Epoch identifies this database instance in session tokens. Change Seqs
+// start over with every instance, so tokens from another one can't be
+// compared with them.
+func (db *Database) Epoch() string {
+	return db.epoch
+}
+
+// SessionToken returns the token of the latest write to this database
+func (db *Database) SessionToken() SessionToken {
+	return SessionToken{Epoch: db.epoch, Offset: atomic.LoadUint64(&db.changes.seq)}
+}
+
+// SetReplicaOffset records that this database has applied its primary's
+// changes up to offset, the Seq of the last one applied. A new epoch
+// means the primary changed or restarted and starts the count over;
+// within an epoch the offset only moves forward.
+func (db *Database) SetReplicaOffset(epoch string, offset uint64) {
+	r := &db.replica
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if epoch == r.epoch && offset <= r.offset {
+		return
+	}
+	if epoch != r.epoch {
+		r.head = 0
+	}
+	r.epoch, r.offset = epoch, offset
+	if r.advance != nil {
+		close(r.advance)
+		r.advance = nil
+	}
+}
+
+// ReplicaOffset returns the primary epoch and offset set with
+// SetReplicaOffset, an empty epoch if this database isn't a replica
+func (db *Database) ReplicaOffset() SessionToken {
+	db.replica.mu.Lock()
+	defer db.replica.mu.Unlock()
+	return SessionToken{Epoch: db.replica.epoch, Offset: db.replica.offset}
+}
+
+// SetPrimaryOffset records the Seq of the latest change on the primary,
+// as seen by whatever applies its changes here, so readiness can tell how
+// far behind this replica is. A report for another epoch than the one
+// being applied is ignored.
+func (db *Database) SetPrimaryOffset(epoch string, offset uint64) {
+	r := &db.replica
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if epoch == r.epoch && offset > r.head {
+		r.head = offset
+	}
+}
+
+// ReplicaLag returns how many of the primary's changes, as of the last
+// SetPrimaryOffset, this replica has yet to apply
+func (db *Database) ReplicaLag() uint64 {
+	db.replica.mu.Lock()
+	defer db.replica.mu.Unlock()
+	if db.replica.head <= db.replica.offset {
+		return 0
+	}
+	return db.replica.head - db.replica.offset
+}
+
+// WaitSession waits until this database reflects the write token
+// identifies: at once for its own writes, or once a replica has applied
+// the primary's changes up to it. It fails with ctx's error if that
+// doesn't happen in time, and with ErrUnknownEpoch for a token from an
+// epoch it doesn't know.
+func (db *Database) WaitSession(ctx context.Context, token SessionToken) error {
+	if token.Epoch == db.epoch {
+		return nil
+	}
+	r := &db.replica
+	for {
+		r.mu.Lock()
+		if token.Epoch != r.epoch {
+			r.mu.Unlock()
+			return ErrUnknownEpoch
+		}
+		if r.offset >= token.Offset {
+			r.mu.Unlock()
+			return nil
+		}
+		if r.advance == nil {
+			r.advance = make(chan struct{})
+		}
+		advance := r.advance
+		r.mu.Unlock()
+
+		select {
+		case <-advance:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AckReplica records, on a primary, that the replica id has applied this
+// database's changes up to offset, the Seq of the last one applied, so
+// WaitReplicas can count it. It fails with ErrUnknownEpoch for an
+// acknowledgement of another epoch's changes, such as one from before a
+// restart. A replica's offset only moves forward.
+func (db *Database) AckReplica(id, epoch string, offset uint64) error {
+	if epoch != db.epoch {
+		return ErrUnknownEpoch
+	}
+	a := &db.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.offsets == nil {
+		a.offsets = make(map[string]uint64)
+	}
+	if current, exists := a.offsets[id]; exists && offset <= current {
+		return nil
+	}
+	a.offsets[id] = offset
+	if a.advance != nil {
+		close(a.advance)
+		a.advance = nil
+	}
+	return nil
+}
+
+// ReplicaAcks returns the offset each replica has acknowledged with
+// AckReplica, by replica ID
+func (db *Database) ReplicaAcks() map[string]uint64 {
+	db.acks.mu.Lock()
+	defer db.acks.mu.Unlock()
+	acks := make(map[string]uint64, len(db.acks.offsets))
+	for id, offset := range db.acks.offsets {
+		acks[id] = offset
+	}
+	return acks
+}
+
+// WaitReplicas waits until numReplicas replicas have acknowledged this
+// database's changes up to offset, or ctx is done, and returns how many
+// have
+func (db *Database) WaitReplicas(ctx context.Context, offset uint64, numReplicas int) int {
+	a := &db.acks
+	for {
+		a.mu.Lock()
+		acked := 0
+		for _, replicaOffset := range a.offsets {
+			if replicaOffset >= offset {
+				acked++
+			}
+		}
+		if acked >= numReplicas {
+			a.mu.Unlock()
+			return acked
+		}
+		if a.advance == nil {
+			a.advance = make(chan struct{})
+		}
+		advance := a.advance
+		a.mu.Unlock()
+
+		select {
+		case <-advance:
+		case <-ctx.Done():
+			return acked
+		}
+	}
+}
+
+// newEpoch returns a random epoch for a new database
+func newEpoch() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
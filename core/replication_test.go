@@ -0,0 +1,49 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWaitReplicas checks that WaitReplicas counts the replicas that have
+// acknowledged a write and returns once enough have, or on timeout
+func TestWaitReplicas(t *testing.T) {
+	db := NewDatabase(&Config{})
+	setString(t, db, "a", "A")
+	offset := db.SessionToken().Offset
+
+	if err := db.AckReplica("r1", "other", offset); !errors.Is(err, ErrUnknownEpoch) {
+		t.Fatalf("acknowledging another epoch returned %v, want ErrUnknownEpoch", err)
+	}
+	if err := db.AckReplica("r1", db.Epoch(), offset); err != nil {
+		t.Fatal(err)
+	}
+	db.AckReplica("r2", db.Epoch(), offset-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if n := db.WaitReplicas(ctx, offset, 2); n != 1 {
+		t.Fatalf("WaitReplicas timed out with %d replicas, want 1", n)
+	}
+
+	done := make(chan int, 1)
+	go func() { done <- db.WaitReplicas(context.Background(), offset, 2) }()
+	time.Sleep(10 * time.Millisecond)
+	db.AckReplica("r2", db.Epoch(), offset)
+	select {
+	case n := <-done:
+		if n != 2 {
+			t.Fatalf("WaitReplicas returned %d, want 2", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("WaitReplicas didn't return once the second replica acknowledged")
+	}
+
+	// Offsets only move forward
+	db.AckReplica("r1", db.Epoch(), 0)
+	if acks := db.ReplicaAcks(); acks["r1"] != offset || acks["r2"] != offset {
+		t.Fatalf("ReplicaAcks = %v, want both at %d", acks, offset)
+	}
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SavePoint asks for a snapshot once Seconds have passed since the last
+// one, if at least Changes writes were made in between, like "save 900 1"
+// in Redis
+type SavePoint struct {
+	Seconds int64 `yaml:"seconds"`
+	Changes int64 `yaml:"changes"`
+}
+
+// ParseSavePoints parses save points in the Redis form, seconds and
+// changes pairs such as "900 1 300 10". An empty string gives none.
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("save points come in seconds and changes pairs, got %q", s)
+	}
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid save point seconds %q", fields[i])
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("invalid save point changes %q", fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// FormatSavePoints formats save points in the Redis form
+func FormatSavePoints(points []SavePoint) string {
+	fields := make([]string, 0, 2*len(points))
+	for _, point := range points {
+		fields = append(fields, strconv.FormatInt(point.Seconds, 10), strconv.FormatInt(point.Changes, 10))
+	}
+	return strings.Join(fields, " ")
+}
+
+// SavePoints returns the save points in effect: persistence.save, or else
+// one that saves every save_interval seconds if anything changed
+func (p PersistenceConfig) SavePoints() []SavePoint {
+	if len(p.Save) > 0 || p.SaveInterval <= 0 {
+		return p.Save
+	}
+	return []SavePoint{{Seconds: p.SaveInterval, Changes: 1}}
+}
+
+// SavePointDue reports whether one of points asks for a snapshot elapsed
+// after the last one, with changes writes since
+func SavePointDue(points []SavePoint, elapsed time.Duration, changes int64) bool {
+	for _, point := range points {
+		if changes >= point.Changes && elapsed >= time.Duration(point.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// Dirty returns the number of writes, expiries included, since the last
+// Save or Load
+func (db *Database) Dirty() int64 {
+	return int64(atomic.LoadUint64(&db.changes.seq) - atomic.LoadUint64(&db.savedSeq))
+}
+
+// LastSave returns when Save last succeeded, or when the database was
+// loaded or created if it hasn't yet
+func (db *Database) LastSave() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&db.lastSave))
+}
+
+// SaveDue reports whether the configured save points ask for a snapshot.
+// Whatever runs the database calls Save when it does.
+func (db *Database) SaveDue() bool {
+	points := db.Config().Persistence.SavePoints()
+	return SavePointDue(points, db.now().Sub(db.LastSave()), db.Dirty())
+}
+
+// markSaved records that the keyspace was written out or read in as of the
+// write with Seq seq
+func (db *Database) markSaved(seq uint64) {
+	atomic.StoreUint64(&db.savedSeq, seq)
+	atomic.StoreInt64(&db.lastSave, db.now().UnixNano())
+}
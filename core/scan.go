@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+)
+
+// Scan walks the keyspace in key order. A cursor is the last key a page
+// returned, not a position in the map, so it stays valid however the
+// keyspace changes between calls, even across a restart or FLUSHALL, and
+// an iteration:
+//
+//   - returns every key that exists from its first call to its last
+//     exactly once, whether or not the key is written in between
+//   - never returns a key twice, even one deleted and created again
+//   - may or may not return keys created or deleted while it runs, as a
+//     key sorting before the cursor is already behind it
+//
+// The database keeps its keys sorted (see keyOrder), so a page seeks to
+// its cursor under the read lock and reads on from there. It costs time
+// in Count and the keys it skips for not matching, not in the size of the
+// keyspace. A Match pattern that starts with literal bytes only visits
+// the keys starting with them.
+
+// ScanStart is the cursor that starts an iteration, and the one returned
+// with its last page
+const ScanStart = "0"
+
+// ScanOptions filters and sizes a Scan page
+type ScanOptions struct {
+	Match string // Glob pattern keys must match, empty for all
+	Type  string // Only values of this type ("string", "hash", ...)
+	Count int    // Keys per page; pages are full until the last
+}
+
+// defaultScanCount is the page size when ScanOptions.Count is unset, as
+// in Redis
+const defaultScanCount = 10
+
+// Scan returns a page of keys after cursor, in order, and the cursor of
+// the next page, ScanStart once the iteration is complete
+func (db *Database) Scan(ctx context.Context, cursor string, opts ScanOptions) ([]string, string, error) {
+	after, started, err := decodeScanCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	// Every key matching the pattern starts with its literal prefix, so
+	// the page can start there and end where the keys run past it
+	prefix := ""
+	if opts.Match != "" && opts.Match != "*" {
+		prefix = literalPrefix(opts.Match)
+	}
+	from, inclusive := after, !started
+	if prefix > from {
+		from, inclusive = prefix, true
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, count)
+	more := false
+	i := 0
+	db.order.ascend(from, inclusive, func(key string) bool {
+		i++
+		if err = scanAborted(ctx, i); err != nil {
+			return false
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		value, exists := db.Data[key]
+		if !exists || db.isExpired(value) {
+			return true
+		}
+		if opts.Match != "" && opts.Match != "*" && !MatchPattern(opts.Match, key) {
+			return true
+		}
+		if opts.Type != "" && typeName(value.Type) != opts.Type {
+			return true
+		}
+		if len(keys) == count {
+			more = true
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !more || len(keys) == 0 {
+		return keys, ScanStart, nil
+	}
+	return keys, encodeScanCursor(keys[len(keys)-1]), nil
+}
+
+// encodeScanCursor turns a key into a cursor. The prefix keeps the cursor
+// of the empty key from reading as ScanStart.
+func encodeScanCursor(key string) string {
+	return "k" + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeScanCursor returns the key a cursor resumes after, started being
+// false for ScanStart
+func decodeScanCursor(cursor string) (after string, started bool, err error) {
+	if cursor == ScanStart || cursor == "" {
+		return "", false, nil
+	}
+	encoded, ok := strings.CutPrefix(cursor, "k")
+	if !ok {
+		return "", false, ErrInvalidCursor
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, ErrInvalidCursor
+	}
+	return string(raw), true, nil
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newScanDatabase(t *testing.T, keys ...string) *Database {
+	t.Helper()
+	db := NewDatabase(&Config{})
+	for _, key := range keys {
+		if err := db.Set(key, &TriffValue{Type: STRING, Data: "v"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// scanAll runs an iteration to its end, failing on a page that isn't in
+// order after the one before it
+func scanAll(t *testing.T, db *Database, opts ScanOptions, between func()) []string {
+	t.Helper()
+	var all []string
+	cursor := ScanStart
+	for {
+		keys, next, err := db.Scan(context.Background(), cursor, opts)
+		if err != nil {
+			t.Fatalf("Scan(%q): %v", cursor, err)
+		}
+		if next != ScanStart && len(keys) != opts.Count {
+			t.Fatalf("page of %d keys before the last, want %d", len(keys), opts.Count)
+		}
+		for _, key := range keys {
+			if len(all) > 0 && key <= all[len(all)-1] {
+				t.Fatalf("%q returned after %q", key, all[len(all)-1])
+			}
+			all = append(all, key)
+		}
+		if next == ScanStart {
+			return all
+		}
+		cursor = next
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScanReturnsEveryKeyOnce(t *testing.T) {
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, fmt.Sprintf("key:%d", i))
+	}
+	db := newScanDatabase(t, keys...)
+
+	for _, count := range []int{1, 7, 512, 5000} {
+		got := scanAll(t, db, ScanOptions{Count: count}, nil)
+		sort.Strings(keys)
+		if fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Fatalf("COUNT %d returned %d keys, want all %d once", count, len(got), len(keys))
+		}
+	}
+}
+
+func TestScanMatch(t *testing.T) {
+	db := newScanDatabase(t, "a", "user:1", "user:2", "user:10", "user*x", "userx", "users:1", "z")
+	tests := []struct {
+		match string
+		want  []string
+	}{
+		{"user:*", []string{"user:1", "user:10", "user:2"}},
+		{"user:?", []string{"user:1", "user:2"}},
+		{`user\**`, []string{"user*x"}},
+		{"*:1", []string{"user:1", "users:1"}},
+		{"z", []string{"z"}},
+		{"none*", nil},
+	}
+	for _, tt := range tests {
+		got := scanAll(t, db, ScanOptions{Match: tt.match, Count: 1}, nil)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("MATCH %q = %q, want %q", tt.match, got, tt.want)
+		}
+	}
+}
+
+func TestScanSkipsExpiredKeys(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := newScanDatabase(t)
+	db.SetClock(clock)
+	db.Set("a", &TriffValue{Type: STRING, Data: "v"})
+	db.Set("b", &TriffValue{Type: STRING, Data: "v", TTL: clock.Now().Unix() + 10})
+	db.Set("c", &TriffValue{Type: STRING, Data: "v"})
+	clock.Advance(time.Minute)
+
+	if got := scanAll(t, db, ScanOptions{Count: 1}, nil); fmt.Sprint(got) != "[a c]" {
+		t.Fatalf("Scan = %q, want [a c]", got)
+	}
+}
+
+// TestScanConcurrentWrites scans while other goroutines overwrite the
+// keys that stay and create and delete others, checking that no key comes
+// back twice and that every key that stays comes back exactly once
+func TestScanConcurrentWrites(t *testing.T) {
+	var stable []string
+	for i := 0; i < 2000; i++ {
+		stable = append(stable, fmt.Sprintf("stable:%05d", i))
+	}
+	db := newScanDatabase(t, stable...)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				churn := fmt.Sprintf("stable:%05d:churn", rng.Intn(2000))
+				switch rng.Intn(3) {
+				case 0:
+					db.Set(stable[rng.Intn(len(stable))], &TriffValue{Type: STRING, Data: "new"})
+				case 1:
+					db.Set(churn, &TriffValue{Type: STRING, Data: "v"})
+				case 2:
+					db.Delete(churn)
+				}
+			}
+		}(w)
+	}
+
+	for round := 0; round < 5; round++ {
+		got := scanAll(t, db, ScanOptions{Match: "stable:*", Count: 13}, func() { time.Sleep(time.Microsecond) })
+		seen := make(map[string]int)
+		for _, key := range got {
+			seen[key]++
+		}
+		for _, key := range stable {
+			if seen[key] != 1 {
+				t.Errorf("round %d returned %q %d times, want once", round, key, seen[key])
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// TestKeyOrder checks the sorted buckets against a map through inserts
+// and deletes that split and merge them
+func TestKeyOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var order keyOrder
+	want := make(map[string]bool)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("%04d", rng.Intn(5000))
+		// Delete more than insert in the second half, emptying buckets
+		if rng.Intn(2) == 0 || (i > 10000 && rng.Intn(3) != 0) {
+			order.remove(key)
+			delete(want, key)
+		} else {
+			order.add(key)
+			want[key] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(want))
+	for key := range want {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	var got []string
+	order.ascend("", true, func(key string) bool {
+		got = append(got, key)
+		return true
+	})
+	if fmt.Sprint(got) != fmt.Sprint(sorted) {
+		t.Fatalf("ascend returned %d keys, want %d in order", len(got), len(sorted))
+	}
+	for _, bucket := range order.buckets {
+		if len(bucket) == 0 || len(bucket) > maxKeyBucket {
+			t.Fatalf("bucket of %d keys", len(bucket))
+		}
+	}
+
+	if len(sorted) > 1 {
+		from := sorted[len(sorted)/2]
+		var after []string
+		order.ascend(from, false, func(key string) bool {
+			after = append(after, key)
+			return true
+		})
+		if fmt.Sprint(after) != fmt.Sprint(sorted[len(sorted)/2+1:]) {
+			t.Fatalf("ascend after %q returned %d keys, want %d", from, len(after), len(sorted)/2)
+		}
+	}
+}
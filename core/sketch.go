@@ -0,0 +1,310 @@
+package core
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// Defaults used when a Bloom filter is created implicitly by BF.ADD
+const (
+	DefaultBloomErrorRate = 0.01
+	DefaultBloomCapacity  = 100
+)
+
+// ErrInvalidSketchParams is returned for out-of-range filter or sketch sizes
+var ErrInvalidSketchParams = errors.New("invalid error rate, capacity or dimensions")
+
+// BloomFilter answers set membership with no false negatives and a bounded
+// false positive rate while it holds at most Capacity items. Filters are
+// treated as immutable once stored; writers Clone before adding.
+type BloomFilter struct {
+	Capacity  uint64   `json:"capacity"`
+	ErrorRate float64  `json:"error_rate"`
+	Hashes    uint32   `json:"hashes"`
+	Size      uint64   `json:"size"` // Number of bits
+	Items     uint64   `json:"items"`
+	Bits      []uint64 `json:"bits"`
+}
+
+// NewBloomFilter sizes a filter for capacity items at the given error rate
+func NewBloomFilter(errorRate float64, capacity uint64) (*BloomFilter, error) {
+	if errorRate <= 0 || errorRate >= 1 || capacity == 0 {
+		return nil, ErrInvalidSketchParams
+	}
+	// m = -n ln p / (ln 2)^2, k = m/n ln 2
+	bits := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bits / float64(capacity) * math.Ln2)
+	if hashes < 1 {
+		hashes = 1
+	}
+	size := uint64(bits)
+	return &BloomFilter{
+		Capacity:  capacity,
+		ErrorRate: errorRate,
+		Hashes:    uint32(hashes),
+		Size:      size,
+		Bits:      make([]uint64, (size+63)/64),
+	}, nil
+}
+
+// Add inserts an item, returning false if it was probably already present
+func (bf *BloomFilter) Add(item string) bool {
+	h1, h2 := sketchHashes(item)
+	added := false
+	for i := uint64(0); i < uint64(bf.Hashes); i++ {
+		bit := (h1 + i*h2) % bf.Size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if bf.Bits[word]&mask == 0 {
+			bf.Bits[word] |= mask
+			added = true
+		}
+	}
+	if added {
+		bf.Items++
+	}
+	return added
+}
+
+// Exists reports whether an item may have been added
+func (bf *BloomFilter) Exists(item string) bool {
+	h1, h2 := sketchHashes(item)
+	for i := uint64(0); i < uint64(bf.Hashes); i++ {
+		bit := (h1 + i*h2) % bf.Size
+		if bf.Bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a deep copy of the filter
+func (bf *BloomFilter) Clone() *BloomFilter {
+	clone := *bf
+	clone.Bits = append([]uint64(nil), bf.Bits...)
+	return &clone
+}
+
+// CountMinSketch estimates item frequencies in fixed memory. Estimates never
+// undercount and overcount by at most error * total with the configured
+// probability. Like BloomFilter it is cloned before being modified.
+type CountMinSketch struct {
+	Width    uint32   `json:"width"`
+	Depth    uint32   `json:"depth"`
+	Total    uint64   `json:"total"`
+	Counters []uint64 `json:"counters"` // Depth rows of Width counters
+}
+
+// NewCountMinSketch creates a sketch with the given dimensions
+func NewCountMinSketch(width, depth uint32) (*CountMinSketch, error) {
+	if width == 0 || depth == 0 || uint64(width)*uint64(depth) > 1<<28 {
+		return nil, ErrInvalidSketchParams
+	}
+	return &CountMinSketch{
+		Width:    width,
+		Depth:    depth,
+		Counters: make([]uint64, int(width)*int(depth)),
+	}, nil
+}
+
+// NewCountMinSketchForError sizes a sketch so estimates exceed the true
+// count by more than errorRate * total with at most the given probability
+func NewCountMinSketchForError(errorRate, probability float64) (*CountMinSketch, error) {
+	if errorRate <= 0 || errorRate >= 1 || probability <= 0 || probability >= 1 {
+		return nil, ErrInvalidSketchParams
+	}
+	width := math.Ceil(math.E / errorRate)
+	depth := math.Ceil(math.Log(1 / probability))
+	return NewCountMinSketch(uint32(width), uint32(depth))
+}
+
+// IncrBy adds increment to an item's count and returns its new estimate
+func (cms *CountMinSketch) IncrBy(item string, increment uint64) uint64 {
+	h1, h2 := sketchHashes(item)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < uint64(cms.Depth); row++ {
+		i := row*uint64(cms.Width) + (h1+row*h2)%uint64(cms.Width)
+		cms.Counters[i] += increment
+		if cms.Counters[i] < estimate {
+			estimate = cms.Counters[i]
+		}
+	}
+	cms.Total += increment
+	return estimate
+}
+
+// Query returns an item's estimated count
+func (cms *CountMinSketch) Query(item string) uint64 {
+	h1, h2 := sketchHashes(item)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < uint64(cms.Depth); row++ {
+		if c := cms.Counters[row*uint64(cms.Width)+(h1+row*h2)%uint64(cms.Width)]; c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// Clone returns a deep copy of the sketch
+func (cms *CountMinSketch) Clone() *CountMinSketch {
+	clone := *cms
+	clone.Counters = append([]uint64(nil), cms.Counters...)
+	return &clone
+}
+
+// sketchHashes derives two independent hashes of an item, combined as
+// h1 + i*h2 to simulate any number of hash functions
+func sketchHashes(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	h1 := h.Sum64()
+	h.Write([]byte{0xff})
+	h2 := h.Sum64() | 1 // odd, so the probe sequence doesn't collapse
+	return h1, h2
+}
+
+// Cuckoo filter sizing
+const (
+	DefaultCuckooCapacity = 1024
+	cuckooBucketSize      = 4
+	cuckooMaxKicks        = 500
+)
+
+// ErrCuckooFull is returned when an item can't be placed after relocating
+// cuckooMaxKicks fingerprints
+var ErrCuckooFull = errors.New("filter is full")
+
+// CuckooFilter answers set membership like a Bloom filter but also supports
+// deleting items that were added. Each bucket holds four 16-bit fingerprints,
+// zero marking an empty slot. Like the other sketches it is cloned before
+// being modified, which also means a failed insert leaves no trace.
+type CuckooFilter struct {
+	Capacity uint64   `json:"capacity"`
+	Buckets  uint64   `json:"buckets"` // Power of two
+	Items    uint64   `json:"items"`
+	Slots    []uint16 `json:"slots"`
+	Kicks    uint64   `json:"kicks"` // Drives victim selection deterministically
+}
+
+// NewCuckooFilter sizes a filter for capacity items at 95% bucket load
+func NewCuckooFilter(capacity uint64) (*CuckooFilter, error) {
+	if capacity == 0 || capacity > 1<<32 {
+		return nil, ErrInvalidSketchParams
+	}
+	buckets := uint64(1)
+	for float64(buckets*cuckooBucketSize)*0.95 < float64(capacity) {
+		buckets <<= 1
+	}
+	return &CuckooFilter{
+		Capacity: capacity,
+		Buckets:  buckets,
+		Slots:    make([]uint16, buckets*cuckooBucketSize),
+	}, nil
+}
+
+// Add inserts an item; adding the same item twice stores it twice so it
+// must be deleted twice
+func (cf *CuckooFilter) Add(item string) error {
+	fp, i1, i2 := cf.locate(item)
+	if cf.insert(i1, fp) || cf.insert(i2, fp) {
+		cf.Items++
+		return nil
+	}
+
+	// Both buckets are full: evict fingerprints to their alternate buckets
+	i := i1
+	if cf.Kicks%2 == 1 {
+		i = i2
+	}
+	for n := 0; n < cuckooMaxKicks; n++ {
+		cf.Kicks++
+		slot := i*cuckooBucketSize + cf.Kicks%cuckooBucketSize
+		fp, cf.Slots[slot] = cf.Slots[slot], fp
+		i = cf.altIndex(i, fp)
+		if cf.insert(i, fp) {
+			cf.Items++
+			return nil
+		}
+	}
+	return ErrCuckooFull
+}
+
+// Exists reports whether an item may have been added
+func (cf *CuckooFilter) Exists(item string) bool {
+	fp, i1, i2 := cf.locate(item)
+	return cf.find(i1, fp) >= 0 || cf.find(i2, fp) >= 0
+}
+
+// Count returns how many times an item's fingerprint was stored, an upper
+// bound on the times it was added
+func (cf *CuckooFilter) Count(item string) int {
+	fp, i1, i2 := cf.locate(item)
+	count := 0
+	for _, i := range []uint64{i1, i2} {
+		for _, slot := range cf.Slots[i*cuckooBucketSize : (i+1)*cuckooBucketSize] {
+			if slot == fp {
+				count++
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+	return count
+}
+
+// Delete removes one copy of an item, returning false if it wasn't found.
+// Deleting an item that was never added may remove a colliding item.
+func (cf *CuckooFilter) Delete(item string) bool {
+	fp, i1, i2 := cf.locate(item)
+	for _, i := range []uint64{i1, i2} {
+		if slot := cf.find(i, fp); slot >= 0 {
+			cf.Slots[slot] = 0
+			cf.Items--
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the filter
+func (cf *CuckooFilter) Clone() *CuckooFilter {
+	clone := *cf
+	clone.Slots = append([]uint16(nil), cf.Slots...)
+	return &clone
+}
+
+// locate returns an item's fingerprint and its two candidate buckets
+func (cf *CuckooFilter) locate(item string) (uint16, uint64, uint64) {
+	h1, h2 := sketchHashes(item)
+	fp := uint16(h2 >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := h1 & (cf.Buckets - 1)
+	return fp, i1, cf.altIndex(i1, fp)
+}
+
+// altIndex maps a bucket to the other bucket its fingerprint may live in;
+// applying it twice returns the original bucket
+func (cf *CuckooFilter) altIndex(i uint64, fp uint16) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & (cf.Buckets - 1)
+}
+
+func (cf *CuckooFilter) insert(i uint64, fp uint16) bool {
+	if slot := cf.find(i, 0); slot >= 0 {
+		cf.Slots[slot] = fp
+		return true
+	}
+	return false
+}
+
+// find returns the slot holding fp in bucket i, or -1
+func (cf *CuckooFilter) find(i uint64, fp uint16) int {
+	for slot := i * cuckooBucketSize; slot < (i+1)*cuckooBucketSize; slot++ {
+		if cf.Slots[slot] == fp {
+			return int(slot)
+		}
+	}
+	return -1
+}
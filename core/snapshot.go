@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// Snapshot is a read-only, point-in-time view of the keyspace, for backup
+// tooling and for aggregates over every key. Taking one copies the key
+// index under the read lock but shares the data of values, which writes
+// replace rather than change in place, so it is cheap even for a large
+// keyspace and reading it never blocks writers.
+type Snapshot struct {
+	time     time.Time
+	values   map[string]*TriffValue
+	keys     []string // Sorted
+	zeroCopy bool
+}
+
+// Snapshot returns a view of the keyspace as it is now. Keys that have
+// expired are left out.
+func (db *Database) Snapshot() *Snapshot {
+	db.mu.RLock()
+	snapshot := &Snapshot{
+		time:     db.now(),
+		values:   make(map[string]*TriffValue, len(db.Data)),
+		zeroCopy: db.config != nil && db.config.Storage.ZeroCopy,
+	}
+	for key, value := range db.Data {
+		if !db.isExpired(value) {
+			snapshot.values[key] = value.header()
+		}
+	}
+	db.mu.RUnlock()
+
+	snapshot.keys = make([]string, 0, len(snapshot.values))
+	for key := range snapshot.values {
+		snapshot.keys = append(snapshot.keys, key)
+	}
+	sort.Strings(snapshot.keys)
+	return snapshot
+}
+
+// Time is when the snapshot was taken
+func (s *Snapshot) Time() time.Time {
+	return s.time
+}
+
+// Len returns the number of keys in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.keys)
+}
+
+// Get returns a key's value as of the snapshot. Like Database.Get it is a
+// copy unless storage.zero_copy is set.
+func (s *Snapshot) Get(key string) (*TriffValue, bool) {
+	value, exists := s.values[key]
+	if !exists {
+		return nil, false
+	}
+	if s.zeroCopy {
+		return value, true
+	}
+	return value.Clone(), true
+}
+
+// Keys returns the keys matching a glob pattern, in order
+func (s *Snapshot) Keys(pattern string) []string {
+	keys := make([]string, 0)
+	for _, key := range s.keys {
+		if MatchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Range calls fn for every key in order until it returns false. Values
+// are not copied, so fn must not change them.
+func (s *Snapshot) Range(fn func(key string, value *TriffValue) bool) {
+	for _, key := range s.keys {
+		if !fn(key, s.values[key]) {
+			return
+		}
+	}
+}
+
+// Export writes the snapshot as JSON lines of {"key": ..., "value": ...},
+// in key order. Binary data is base64-encoded as in TriffValue.MarshalJSON.
+func (s *Snapshot) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, key := range s.keys {
+		record := struct {
+			Key   string      `json:"key"`
+			Value *TriffValue `json:"value"`
+		}{key, s.values[key]}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Data returns a map of the snapshot's values, shared with the snapshot,
+// in the form persistence engines save
+func (s *Snapshot) Data() map[string]*TriffValue {
+	data := make(map[string]*TriffValue, len(s.values))
+	for key, value := range s.values {
+		data[key] = value
+	}
+	return data
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Tag adds tags to a key, returning how many it didn't have yet. Tags stay
+// with the key when it is overwritten, until it is deleted or they are
+// removed with Untag. Empty tags are ignored.
+func (db *Database) Tag(key string, tags ...string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return 0, errs.Newf(errs.ErrNotFound, "key %q not found", key)
+	}
+	merged := append([]string(nil), value.Tags...)
+	added := 0
+	for _, tag := range tags {
+		if tag != "" && !hasTag(merged, tag) {
+			merged = append(merged, tag)
+			added++
+		}
+	}
+	if added > 0 {
+		sort.Strings(merged)
+		db.retag(key, value, merged)
+	}
+	return added, nil
+}
+
+// Untag removes tags from a key, returning how many it had
+func (db *Database) Untag(key string, tags ...string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return 0, errs.Newf(errs.ErrNotFound, "key %q not found", key)
+	}
+	kept := make([]string, 0, len(value.Tags))
+	for _, tag := range value.Tags {
+		if !hasTag(tags, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	removed := len(value.Tags) - len(kept)
+	if removed > 0 {
+		if len(kept) == 0 {
+			kept = nil
+		}
+		db.retag(key, value, kept)
+	}
+	return removed, nil
+}
+
+// retag replaces a stored value's tags. Callers must hold the write lock.
+func (db *Database) retag(key string, value *TriffValue, tags []string) {
+	value.Tags = tags
+	db.tags.update(key, tags)
+	db.emitChange(ChangeTag, key, value)
+}
+
+// KeyTags returns a key's tags, sorted
+func (db *Database) KeyTags(key string) ([]string, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, exists := db.Data[key]
+	if !exists || db.isExpired(value) {
+		return nil, false
+	}
+	return append([]string{}, value.Tags...), true
+}
+
+// TaggedKeys returns the live keys with a tag, sorted
+func (db *Database) TaggedKeys(tag string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, len(db.tags.keys[tag]))
+	for key := range db.tags.keys[tag] {
+		if value, exists := db.Data[key]; exists && !db.isExpired(value) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InvalidateTag starts a background job removing every key with a tag, the
+// way a cache drops what was derived from an entity that changed. With
+// seconds above 0 the keys are given that long to live instead, so they
+// can still be served while they are recomputed; keys that expire sooner
+// keep their TTL. Keys are processed in batches of batchSize with the
+// write lock released in between, and keys untagged meanwhile are left
+// alone.
+func (db *Database) InvalidateTag(tag string, seconds int64, batchSize int) BulkJob {
+	operation := "invalidate"
+	if seconds > 0 {
+		operation = "expire"
+	}
+
+	keys := db.TaggedKeys(tag)
+	job := db.jobs.add(operation, "", tag, len(keys))
+	snapshot := job.snapshot()
+
+	go func() {
+		db.forEachBatch(context.Background(), keys, batchSize, func(key string, value *TriffValue) {
+			atomic.AddInt64(&job.Processed, 1)
+			if !hasTag(value.Tags, tag) {
+				return
+			}
+			if seconds > 0 {
+				expires := db.now().Unix() + seconds
+				if value.TTL > 0 && value.TTL <= expires {
+					return
+				}
+				value.TTL = expires
+				db.emitChange(ChangeTTL, key, value)
+			} else {
+				db.bury(key, value)
+				db.remove(key, value)
+			}
+			atomic.AddInt64(&job.Updated, 1)
+		})
+		db.jobs.finish(job)
+	}()
+
+	return snapshot
+}
+
+// tagsOf returns a value's tags, none for a removed value
+func tagsOf(value *TriffValue) []string {
+	if value == nil {
+		return nil
+	}
+	return value.Tags
+}
+
+// hasTag reports whether tags holds tag
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Errors returned by Restore
+var (
+	ErrNoTombstone = errs.Newf(errs.ErrNotFound, "no restorable deleted key")
+	ErrKeyExists   = errors.New("key exists")
+)
+
+// Tombstone is a deleted key that can still be restored while soft delete
+// is enabled with Storage.SoftDelete
+type Tombstone struct {
+	Key       string      `json:"key"`
+	Value     *TriffValue `json:"-"`
+	Type      string      `json:"type"`
+	DeletedAt time.Time   `json:"deleted_at"`
+	PurgeAt   time.Time   `json:"purge_at"`
+}
+
+// bury keeps a deleted value as a tombstone if soft delete is enabled.
+// Callers must hold the write lock.
+func (db *Database) bury(key string, value *TriffValue) {
+	if db.config == nil || db.config.Storage.SoftDelete <= 0 || db.isExpired(value) {
+		return
+	}
+	now := db.now()
+	db.tombstones[key] = &Tombstone{
+		Key:       key,
+		Value:     value,
+		Type:      typeName(value.Type),
+		DeletedAt: now,
+		PurgeAt:   now.Add(time.Duration(db.config.Storage.SoftDelete) * time.Second),
+	}
+}
+
+// purgeTombstones drops tombstones whose grace period has passed.
+// Callers must hold the write lock.
+func (db *Database) purgeTombstones(now time.Time) {
+	for key, tombstone := range db.tombstones {
+		if now.After(tombstone.PurgeAt) {
+			delete(db.tombstones, key)
+		}
+	}
+}
+
+// Restore brings back a soft-deleted key. It fails if the key has been
+// written since it was deleted, so a restore never clobbers newer data.
+func (db *Database) Restore(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tombstone, exists := db.tombstones[key]
+	if !exists || db.now().After(tombstone.PurgeAt) {
+		delete(db.tombstones, key)
+		return ErrNoTombstone
+	}
+	if current, exists := db.Data[key]; exists && !db.isExpired(current) {
+		return ErrKeyExists
+	}
+
+	delete(db.tombstones, key)
+	restored := *tombstone.Value
+	db.store(key, &restored)
+	restored.CreatedAt = tombstone.Value.CreatedAt
+	restored.Version = tombstone.Value.Version + 1
+	return nil
+}
+
+// Tombstones lists restorable deleted keys, most recently deleted first
+func (db *Database) Tombstones() []Tombstone {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.purgeTombstones(db.now())
+	tombstones := make([]Tombstone, 0, len(db.tombstones))
+	for _, tombstone := range db.tombstones {
+		tombstones = append(tombstones, *tombstone)
+	}
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.After(tombstones[j].DeletedAt)
+	})
+	return tombstones
+}
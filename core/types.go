@@ -1,8 +1,12 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/nitrix4ly/triff/core/errs"
 )
 
 // DataType represents different data types supported by Triff
@@ -14,6 +18,12 @@ const (
 	LIST
 	SET
 	ZSET
+	BLOOM
+	CMS
+	CUCKOO
+	PNCOUNTER
+	ORSET
+	NULL // A cached miss: the key exists but stands for a record that doesn't
 )
 
 // TriffValue represents a value stored in the database
@@ -21,8 +31,80 @@ type TriffValue struct {
 	Type      DataType    `json:"type"`
 	Data      interface{} `json:"data"`
 	TTL       int64       `json:"ttl"`       // Time to live in seconds
+	Version   uint64      `json:"version"`   // Incremented on every write
 	CreatedAt time.Time   `json:"created_at"`
 	UpdatedAt time.Time   `json:"updated_at"`
+	Tags      []string    `json:"tags,omitempty"` // Sorted; replaced, never changed in place
+	DependsOn []string    `json:"depends_on,omitempty"` // Keys whose writes invalidate this one; sorted, replaced
+	Cap       int         `json:"cap,omitempty"` // Most items a list keeps, pushes trimming its oldest; 0 for no cap
+	
+	size int64 // accounted memory, maintained by the database
+	
+	// Access metadata for LRU/LFU policies, updated atomically on reads
+	lastAccess int64  // unix nanoseconds
+	lfuCounter uint32 // logarithmic access frequency
+}
+
+// MarshalJSON encodes the value's fields, base64-encoding the strings of
+// Data when it holds binary ones (see BinaryData)
+func (v *TriffValue) MarshalJSON() ([]byte, error) {
+	type plain TriffValue
+	if !BinaryData(v.Data) {
+		return json.Marshal((*plain)(v))
+	}
+	return json.Marshal(struct {
+		*plain
+		Data     interface{} `json:"data"`
+		Encoding string      `json:"encoding"`
+	}{(*plain)(v), EncodeBinary(v.Data), "base64"})
+}
+
+// UnmarshalJSON decodes Data as the Go type values of Type are stored as,
+// so sketches and CRDTs survive a snapshot. Other types decode as usual,
+// base64-encoded data included.
+func (v *TriffValue) UnmarshalJSON(data []byte) error {
+	type plain TriffValue
+	aux := struct {
+		*plain
+		Data     json.RawMessage `json:"data"`
+		Encoding string          `json:"encoding"`
+	}{plain: (*plain)(v)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var target interface{}
+	switch v.Type {
+	case BLOOM:
+		target = &BloomFilter{}
+	case CMS:
+		target = &CountMinSketch{}
+	case CUCKOO:
+		target = &CuckooFilter{}
+	case PNCOUNTER:
+		target = NewPNCounter()
+	case ORSET:
+		target = NewORSet()
+	default:
+		v.Data = nil
+		if len(aux.Data) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(aux.Data, &v.Data); err != nil || aux.Encoding != "base64" {
+			return err
+		}
+		decoded, err := DecodeBinary(v.Data)
+		if err != nil {
+			return err
+		}
+		v.Data = decoded
+		return nil
+	}
+	if err := json.Unmarshal(aux.Data, target); err != nil {
+		return err
+	}
+	v.Data = target
+	return nil
 }
 
 // Database represents the main database structure
@@ -31,23 +113,45 @@ type Database struct {
 	mu        sync.RWMutex
 	config    *Config
 	persistence PersistenceEngine
+	indexes   *IndexManager
+	search    *SearchManager
+	stats     *StatsTracker
+	memoryUsed   int64
+	memoryByType map[DataType]int64
+	jobs      *BulkJobManager
+	queues    *QueueManager
+	history   *HistoryStore
+	tombstones map[string]*Tombstone
+	changes   changeFeed
+	writeQueues writeQueues
+	nodeID    string
+	limitRejections int64 // Writes refused by checkLimits
+	bulkLoads int32       // Bulk loads running; Save waits for them
+	loading   int32       // Snapshot loads running; commands get ErrLoading
+	savedSeq  uint64      // Change Seq as of the last Save or Load, for Dirty
+	lastSave  int64       // UnixNano of the last Save or Load, for save points
+	arena     stringArena
+	epoch     string       // Identifies this instance in session tokens
+	replica   replicaState // How far the primary's changes were applied
+	acks      replicaAcks  // How far each replica has applied this database's changes
+	flights   flights      // Compute grants handed out by Fetch
+	partitions map[string]*partition // Time partitions by name, e.g. logs:2024-06-01
+	tags      labelIndex   // Keys by tag, for InvalidateTag
+	dependents labelIndex  // Keys by the keys they depend on
+	aggregates aggregates  // Aggregates kept up to date on write, by name
+	order     keyOrder     // Keys in sorted order, for Scan
+	clock     Clock        // What TTLs and expiry go by; SystemClock unless set
 }
 
-// Config holds database configuration
-type Config struct {
-	Port            int    `yaml:"port"`
-	HTTPPort        int    `yaml:"http_port"`
-	MaxMemory       int64  `yaml:"max_memory"`
-	PersistencePath string `yaml:"persistence_path"`
-	LogLevel        string `yaml:"log_level"`
-	EnableHTTP      bool   `yaml:"enable_http"`
-	EnableTCP       bool   `yaml:"enable_tcp"`
-}
-
-// StorageEngine defines interface for storage implementations
+// StorageEngine defines interface for storage implementations. Values
+// expire once the clock passes their TTL, in Unix seconds, and expired
+// values are invisible to every method but Size. Keys takes glob patterns
+// (see MatchPattern). Package storage/enginetest checks an implementation
+// against this contract.
 type StorageEngine interface {
 	Get(key string) (*TriffValue, bool)
 	Set(key string, value *TriffValue) error
+	Update(key string, fn func(old *TriffValue) (*TriffValue, error)) error
 	Delete(key string) bool
 	Exists(key string) bool
 	Keys(pattern string) []string
@@ -74,4 +178,46 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
 	Type    string      `json:"type"`
+	Err     error       `json:"-"` // Typed cause of Error, for errors.Is
+}
+
+// Fail builds an unsuccessful response that keeps err for classification
+// with errors.Is against the errs sentinels
+func Fail(responseType string, err error) *Response {
+	return &Response{
+		Success: false,
+		Error:   err.Error(),
+		Type:    responseType,
+		Err:     err,
+	}
+}
+
+// Failure returns the error of an unsuccessful response, or nil. A failed
+// response without an error message (such as GET on a missing key) is
+// errs.ErrNotFound.
+func (r *Response) Failure() error {
+	switch {
+	case r.Success:
+		return nil
+	case r.Err != nil:
+		return r.Err
+	case r.Error != "":
+		return errors.New(r.Error)
+	}
+	return errs.ErrNotFound
+}
+
+// Result returns a response's data as T, or the response's failure. Data
+// of another type is reported as errs.ErrWrongType instead of panicking
+// like a bare type assertion.
+func Result[T any](r *Response) (T, error) {
+	var zero T
+	if err := r.Failure(); err != nil {
+		return zero, err
+	}
+	data, ok := r.Data.(T)
+	if !ok {
+		return zero, errs.Newf(errs.ErrWrongType, "%s response holds %T, not %T", r.Type, r.Data, zero)
+	}
+	return data, nil
 }
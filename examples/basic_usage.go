@@ -14,17 +14,27 @@ import (
 func main() {
 	// Create database configuration
 	config := &core.Config{
-		Port:            6379,
-		HTTPPort:        8080,
-		MaxMemory:       1024 * 1024 * 1024, // 1GB
-		PersistencePath: "./triff.db",
-		LogLevel:        "info",
-		EnableHTTP:      true,
-		EnableTCP:       true,
+		Server: core.ServerConfig{
+			Port:       6379,
+			HTTPPort:   8080,
+			EnableTCP:  true,
+			EnableHTTP: true,
+		},
+		Storage: core.StorageConfig{
+			Engine:    "memory",
+			MaxMemory: 1024 * 1024 * 1024, // 1GB
+		},
+		Persistence: core.PersistenceConfig{
+			Path: "./triff.db",
+		},
+		Logging: core.LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
 	}
 
 	// Initialize logger
-	logger := utils.NewLogger(config.LogLevel)
+	logger := utils.NewLogger(config.Logging.Level)
 
 	// Create new database instance
 	db := core.NewDatabase(config)
@@ -115,25 +125,25 @@ func main() {
 	fmt.Println("\n=== Starting Servers ===")
 	
 	// Start TCP server in a goroutine
-	if config.EnableTCP {
-		tcpServer := server.NewTCPServer(db, config.Port, logger)
+	if config.Server.EnableTCP {
+		tcpServer := server.NewTCPServer(db, config.Server.Port, logger)
 		go func() {
 			if err := tcpServer.Start(); err != nil {
 				log.Printf("TCP server error: %v", err)
 			}
 		}()
-		fmt.Printf("✓ TCP server started on port %d\n", config.Port)
+		fmt.Printf("✓ TCP server started on port %d\n", config.Server.Port)
 	}
 
 	// Start HTTP server in a goroutine
-	if config.EnableHTTP {
-		httpServer := server.NewHTTPServer(db, config.HTTPPort, logger)
+	if config.Server.EnableHTTP {
+		httpServer := server.NewHTTPServer(db, config.Server.HTTPPort, logger)
 		go func() {
 			if err := httpServer.Start(); err != nil {
 				log.Printf("HTTP server error: %v", err)
 			}
 		}()
-		fmt.Printf("✓ HTTP server started on port %d\n", config.HTTPPort)
+		fmt.Printf("✓ HTTP server started on port %d\n", config.Server.HTTPPort)
 	}
 
 	// Keep the program running
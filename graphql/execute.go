@@ -0,0 +1,535 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+
+	// ReadOnly rejects mutations, for requests that must not have side
+	// effects such as HTTP GETs
+	ReadOnly bool `json:"-"`
+
+	// Hidden reports root fields to treat as unknown, for those the server
+	// has switched off. Nil hides none.
+	Hidden func(typeName, field string) bool `json:"-"`
+
+	// Context is passed to resolvers; fields not yet resolved when it is
+	// done fail. Nil means context.Background().
+	Context context.Context `json:"-"`
+}
+
+// Response is the result of executing a request. Data is omitted when the
+// request failed before execution and null when a non-null root field failed.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a request or field error
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Execute parses, validates and runs a request
+func (s *Schema) Execute(req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+
+	root := s.Query
+	if op.kind == "mutation" {
+		if req.ReadOnly {
+			return &Response{Errors: []*Error{{Message: "mutations are not allowed in a read-only request", Locations: []Location{op.loc}}}}
+		}
+		if s.Mutation == nil {
+			return &Response{Errors: []*Error{{Message: "schema does not support mutations", Locations: []Location{op.loc}}}}
+		}
+		root = s.Mutation
+	}
+
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e := &execution{schema: s, doc: doc, ctx: ctx, hidden: req.Hidden}
+	if errs := e.validate(op, root); len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+	if errs := e.coerceVariables(op, req.Variables); len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	data, failed := e.executeFields(root, nil, op.selections, nil)
+	resp := &Response{Data: data, Errors: e.errors}
+	if failed {
+		resp.Data = json.RawMessage("null")
+	}
+	return resp
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+func asError(err error) *Error {
+	if gqlErr, ok := err.(*Error); ok {
+		return gqlErr
+	}
+	return &Error{Message: err.Error()}
+}
+
+// execution holds the state of one request
+type execution struct {
+	schema *Schema
+	doc    *document
+	ctx    context.Context
+	hidden func(typeName, field string) bool
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// orderedMap is a response object, which keeps fields in query order
+type orderedMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// fieldGroup is all selections of one response key, merged
+type fieldGroup struct {
+	key    string
+	fields []*field
+}
+
+// collectFields flattens fragments and applies @skip and @include,
+// grouping fields by response key in the order they first appear
+func (e *execution) collectFields(selections []selection, groups []*fieldGroup, index map[string]*fieldGroup, visited map[string]bool) []*fieldGroup {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if group, exists := index[key]; exists {
+				group.fields = append(group.fields, sel)
+				continue
+			}
+			group := &fieldGroup{key: key, fields: []*field{sel}}
+			index[key] = group
+			groups = append(groups, group)
+		case *inlineFragment:
+			if e.included(sel.directives) {
+				groups = e.collectFields(sel.selections, groups, index, visited)
+			}
+		case *fragmentSpread:
+			if !e.included(sel.directives) || visited[sel.name] {
+				continue
+			}
+			visited[sel.name] = true
+			groups = e.collectFields(e.doc.fragments[sel.name].selections, groups, index, visited)
+		}
+	}
+	return groups
+}
+
+func (e *execution) included(directives []*directive) bool {
+	for _, d := range directives {
+		condition, _ := e.resolveValue(d.arguments["if"]).(bool)
+		switch d.name {
+		case "skip":
+			if condition {
+				return false
+			}
+		case "include":
+			if !condition {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// executeFields resolves the selections of an object. failed reports that
+// a non-null field was null, which makes the whole object null.
+func (e *execution) executeFields(t *Type, source interface{}, selections []selection, path []interface{}) (result *orderedMap, failed bool) {
+	result = &orderedMap{}
+	for _, group := range e.collectFields(selections, nil, make(map[string]*fieldGroup), make(map[string]bool)) {
+		f := group.fields[0]
+		fieldPath := appendPath(path, group.key)
+
+		if f.name == "__typename" {
+			result.keys = append(result.keys, group.key)
+			result.values = append(result.values, t.Name)
+			continue
+		}
+
+		def := e.schema.fieldDef(t, f.name)
+		value, failed := e.resolveField(def, t, source, group, fieldPath)
+		if failed && def.Type.Kind == KindNonNull {
+			return nil, true
+		}
+		result.keys = append(result.keys, group.key)
+		result.values = append(result.values, value)
+	}
+	return result, false
+}
+
+func (e *execution) resolveField(def *Field, parent *Type, source interface{}, group *fieldGroup, path []interface{}) (interface{}, bool) {
+	f := group.fields[0]
+	args, err := e.coerceArguments(def.Args, f.arguments)
+	if err != nil {
+		e.fieldError(err, f, path)
+		return nil, true
+	}
+
+	var value interface{}
+	switch {
+	case parent == e.schema.Query && f.name == "__schema":
+		value = e.schema
+	case parent == e.schema.Query && f.name == "__type":
+		if t, exists := e.schema.types[args["name"].(string)]; exists {
+			value = t
+		}
+	case def.Resolve != nil:
+		if err := e.ctx.Err(); err != nil {
+			e.fieldError(err, f, path)
+			return nil, true
+		}
+		value, err = resolve(def.Resolve, ResolveParams{Context: e.ctx, Source: source, Args: args})
+	default:
+		if m, ok := source.(map[string]interface{}); ok {
+			value = m[def.Name]
+		}
+	}
+	if err != nil {
+		e.fieldError(err, f, path)
+		return nil, true
+	}
+
+	var selections []selection
+	for _, f := range group.fields {
+		selections = append(selections, f.selections...)
+	}
+	return e.completeValue(def.Type, f, selections, value, path)
+}
+
+// resolve calls a resolver, turning a panic into a field error
+func resolve(fn ResolveFunc, p ResolveParams) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return fn(p)
+}
+
+// completeValue shapes a resolved value according to its type. failed
+// reports a null caused by an error, which non-null positions propagate.
+func (e *execution) completeValue(t *Type, f *field, selections []selection, value interface{}, path []interface{}) (interface{}, bool) {
+	if t.Kind == KindNonNull {
+		completed, failed := e.completeValue(t.OfType, f, selections, value, path)
+		if failed {
+			return nil, true
+		}
+		if completed == nil {
+			e.fieldError(fmt.Errorf("Cannot return null for non-nullable field %s.", f.name), f, path)
+			return nil, true
+		}
+		return completed, false
+	}
+
+	if isNull(value) {
+		return nil, false
+	}
+
+	switch t.Kind {
+	case KindScalar:
+		serialized, err := t.serialize(value)
+		if err != nil {
+			e.fieldError(err, f, path)
+			return nil, true
+		}
+		return serialized, false
+
+	case KindEnum:
+		name := fmt.Sprint(value)
+		for _, v := range t.EnumValues {
+			if v == name {
+				return name, false
+			}
+		}
+		e.fieldError(fmt.Errorf("Enum %q cannot represent value: %q", t.Name, name), f, path)
+		return nil, true
+
+	case KindList:
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			e.fieldError(fmt.Errorf("Expected a list for field %s.", f.name), f, path)
+			return nil, true
+		}
+		completed := make([]interface{}, items.Len())
+		for i := range completed {
+			item, failed := e.completeValue(t.OfType, f, selections, items.Index(i).Interface(), appendPath(path, i))
+			if failed && t.OfType.Kind == KindNonNull {
+				return nil, true
+			}
+			completed[i] = item
+		}
+		return completed, false
+
+	case KindObject:
+		object, failed := e.executeFields(t, value, selections, path)
+		if failed {
+			return nil, true
+		}
+		return object, false
+	}
+	return nil, false
+}
+
+func (e *execution) fieldError(err error, f *field, path []interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: []Location{f.loc},
+		Path:      path,
+	})
+}
+
+// coerceArguments applies defaults and converts argument values to the
+// types the resolver expects
+func (e *execution) coerceArguments(defs []*Argument, given map[string]value) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(defs))
+	for _, def := range defs {
+		raw, present := given[def.Name]
+		if name, ok := raw.(variable); ok {
+			_, present = e.vars[string(name)]
+		}
+		if !present {
+			if def.Default != nil {
+				args[def.Name] = def.Default
+			} else if def.Type.Kind == KindNonNull {
+				return nil, fmt.Errorf("Argument %q of required type %q was not provided.", def.Name, def.Type)
+			}
+			continue
+		}
+		coerced, err := coerceInput(def.Type, e.resolveValue(raw))
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q has invalid value: %v", def.Name, err)
+		}
+		if coerced == nil {
+			// An explicit null falls back to the default, so resolvers can
+			// rely on arguments with defaults being set
+			if def.Default != nil {
+				args[def.Name] = def.Default
+			}
+			continue
+		}
+		args[def.Name] = coerced
+	}
+	return args, nil
+}
+
+// coerceVariables checks the provided variables against their declared
+// types, applying defaults
+func (e *execution) coerceVariables(op *operation, provided map[string]interface{}) []*Error {
+	e.vars = make(map[string]interface{})
+	var errs []*Error
+	for _, def := range op.variables {
+		t, err := e.schema.inputType(def.typ)
+		if err != nil {
+			errs = append(errs, &Error{Message: err.Error(), Locations: []Location{def.loc}})
+			continue
+		}
+		raw, present := provided[def.name]
+		if !present {
+			if def.defaultValue != nil {
+				raw, present = e.resolveValue(def.defaultValue), true
+			} else if t.Kind == KindNonNull {
+				errs = append(errs, &Error{
+					Message:   fmt.Sprintf("Variable \"$%s\" of required type %q was not provided.", def.name, t),
+					Locations: []Location{def.loc},
+				})
+				continue
+			}
+		}
+		if !present {
+			continue
+		}
+		coerced, err := coerceInput(t, raw)
+		if err != nil {
+			errs = append(errs, &Error{
+				Message:   fmt.Sprintf("Variable \"$%s\" got invalid value: %v", def.name, err),
+				Locations: []Location{def.loc},
+			})
+			continue
+		}
+		e.vars[def.name] = coerced
+	}
+	return errs
+}
+
+// inputType resolves a variable's declared type against the schema
+func (s *Schema) inputType(ref *typeRef) (*Type, error) {
+	var t *Type
+	if ref.list != nil {
+		elem, err := s.inputType(ref.list)
+		if err != nil {
+			return nil, err
+		}
+		t = NewList(elem)
+	} else {
+		named, exists := s.types[ref.name]
+		if !exists {
+			return nil, fmt.Errorf("Unknown type %q.", ref.name)
+		}
+		if !named.isLeaf() {
+			return nil, fmt.Errorf("Variable type %q must be an input type.", ref.name)
+		}
+		t = named
+	}
+	if ref.nonNull {
+		t = NewNonNull(t)
+	}
+	return t, nil
+}
+
+// resolveValue turns a document value into plain Go values, substituting
+// variables
+func (e *execution) resolveValue(v value) interface{} {
+	switch v := v.(type) {
+	case variable:
+		return e.vars[string(v)]
+	case enumValue:
+		return string(v)
+	case listValue:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = e.resolveValue(item)
+		}
+		return items
+	case objectValue:
+		fields := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			fields[name] = e.resolveValue(item)
+		}
+		return fields
+	}
+	return v
+}
+
+// coerceInput converts a plain value to an input type
+func coerceInput(t *Type, v interface{}) (interface{}, error) {
+	if t.Kind == KindNonNull {
+		if v == nil {
+			return nil, fmt.Errorf("expected non-null value of type %q", t.OfType)
+		}
+		return coerceInput(t.OfType, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t.Kind {
+	case KindList:
+		items, ok := v.([]interface{})
+		if !ok {
+			// A single value is accepted as a list of one
+			items = []interface{}{v}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			c, err := coerceInput(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			coerced[i] = c
+		}
+		return coerced, nil
+	case KindEnum:
+		if s, ok := v.(string); ok {
+			for _, value := range t.EnumValues {
+				if value == s {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("value %v does not exist in %q enum", v, t.Name)
+	case KindScalar:
+		return t.parse(v)
+	}
+	return nil, fmt.Errorf("%q is not an input type", t)
+}
+
+func appendPath(path []interface{}, element interface{}) []interface{} {
+	extended := make([]interface{}, len(path)+1)
+	copy(extended, path)
+	extended[len(path)] = element
+	return extended
+}
+
+// isNull reports nil interfaces as well as nil pointers, maps and slices
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
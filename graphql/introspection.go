@@ -0,0 +1,179 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The introspection types, which describe a schema to tools such as
+// GraphiQL and code generators
+var (
+	introspectionSchema *Type
+	introspectionType   *Type
+)
+
+func init() {
+	typeKind := NewEnum("__TypeKind", "An enum describing what kind of type a given `__Type` is.",
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL")
+	directiveLocation := NewEnum("__DirectiveLocation", "A Directive can be adjacent to many parts of the GraphQL language, a __DirectiveLocation describes one such possible adjacencies.",
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		"SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE",
+		"INPUT_OBJECT", "INPUT_FIELD_DEFINITION")
+
+	schemaType := &Type{Kind: KindObject, Name: "__Schema", Description: "A GraphQL Schema defines the capabilities of a GraphQL server."}
+	typeType := &Type{Kind: KindObject, Name: "__Type", Description: "The fundamental unit of any GraphQL Schema is the type."}
+	fieldType := &Type{Kind: KindObject, Name: "__Field", Description: "Object and Interface types are described by a list of Fields, each of which has a name, potentially a list of arguments, and a return type."}
+	inputValueType := &Type{Kind: KindObject, Name: "__InputValue", Description: "Arguments provided to Fields or Directives and the input fields of an InputObject are represented as Input Values which describe their type and optionally a default value."}
+	enumValueType := &Type{Kind: KindObject, Name: "__EnumValue", Description: "One possible value for a given Enum."}
+	directiveType := &Type{Kind: KindObject, Name: "__Directive", Description: "A Directive provides a way to describe alternate runtime execution and type validation behavior in a GraphQL document."}
+
+	includeDeprecated := []*Argument{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+	notDeprecated := []*Field{
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: constant(false)},
+		{Name: "deprecationReason", Type: String, Resolve: constant(nil)},
+	}
+
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: constant(nil)},
+		{Name: "types", Description: "A list of all types supported by this server.", Type: nonNullList(typeType),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Schema).sortedTypes(), nil }},
+		{Name: "queryType", Description: "The type that query operations will be rooted at.", Type: NewNonNull(typeType),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Schema).Query, nil }},
+		{Name: "mutationType", Description: "If this server supports mutation, the type that mutation operations will be rooted at.", Type: typeType,
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Schema).Mutation, nil }},
+		{Name: "subscriptionType", Description: "If this server support subscription, the type that subscription operations will be rooted at.", Type: typeType, Resolve: constant(nil)},
+		{Name: "directives", Description: "A list of all directives supported by this server.", Type: nonNullList(directiveType), Resolve: constant(builtinDirectives)},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NewNonNull(typeKind),
+			Resolve: func(p ResolveParams) (interface{}, error) { return string(p.Source.(*Type).Kind), nil }},
+		{Name: "name", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) { return optional(p.Source.(*Type).Name), nil }},
+		{Name: "description", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) { return optional(p.Source.(*Type).Description), nil }},
+		{Name: "specifiedByURL", Type: String, Resolve: constant(nil)},
+		{Name: "fields", Type: NewList(NewNonNull(fieldType)), Args: includeDeprecated,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if t := p.Source.(*Type); t.Kind == KindObject {
+					return t.Fields, nil
+				}
+				return nil, nil
+			}},
+		{Name: "interfaces", Type: NewList(NewNonNull(typeType)),
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if p.Source.(*Type).Kind == KindObject {
+					return []*Type{}, nil
+				}
+				return nil, nil
+			}},
+		{Name: "possibleTypes", Type: NewList(NewNonNull(typeType)), Resolve: constant(nil)},
+		{Name: "enumValues", Type: NewList(NewNonNull(enumValueType)), Args: includeDeprecated,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if t := p.Source.(*Type); t.Kind == KindEnum {
+					return t.EnumValues, nil
+				}
+				return nil, nil
+			}},
+		{Name: "inputFields", Type: NewList(NewNonNull(inputValueType)), Args: includeDeprecated, Resolve: constant(nil)},
+		{Name: "ofType", Type: typeType,
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Type).OfType, nil }},
+	}
+
+	fieldType.Fields = append([]*Field{
+		{Name: "name", Type: NewNonNull(String),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Field).Name, nil }},
+		{Name: "description", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) { return optional(p.Source.(*Field).Description), nil }},
+		{Name: "args", Type: nonNullList(inputValueType), Args: includeDeprecated,
+			Resolve: func(p ResolveParams) (interface{}, error) { return nonNilArgs(p.Source.(*Field).Args), nil }},
+		{Name: "type", Type: NewNonNull(typeType),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Field).Type, nil }},
+	}, notDeprecated...)
+
+	inputValueType.Fields = append([]*Field{
+		{Name: "name", Type: NewNonNull(String),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Argument).Name, nil }},
+		{Name: "description", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) { return optional(p.Source.(*Argument).Description), nil }},
+		{Name: "type", Type: NewNonNull(typeType),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*Argument).Type, nil }},
+		{Name: "defaultValue", Description: "A GraphQL-formatted string representing the default value for this input value.", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				arg := p.Source.(*Argument)
+				if arg.Default == nil {
+					return nil, nil
+				}
+				return formatLiteral(arg.Type, arg.Default), nil
+			}},
+	}, notDeprecated...)
+
+	enumValueType.Fields = append([]*Field{
+		{Name: "name", Type: NewNonNull(String),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(string), nil }},
+		{Name: "description", Type: String, Resolve: constant(nil)},
+	}, notDeprecated...)
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*directiveDef).Name, nil }},
+		{Name: "description", Type: String,
+			Resolve: func(p ResolveParams) (interface{}, error) { return optional(p.Source.(*directiveDef).Description), nil }},
+		{Name: "isRepeatable", Type: NewNonNull(Boolean), Resolve: constant(false)},
+		{Name: "locations", Type: nonNullList(directiveLocation),
+			Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(*directiveDef).Locations, nil }},
+		{Name: "args", Type: nonNullList(inputValueType), Args: includeDeprecated,
+			Resolve: func(p ResolveParams) (interface{}, error) { return nonNilArgs(p.Source.(*directiveDef).Args), nil }},
+	}
+
+	introspectionSchema = schemaType
+	introspectionType = typeType
+	schemaMetaField.Type = NewNonNull(schemaType)
+	typeMetaField.Type = typeType
+}
+
+// nonNullList is the common [T!]! shape
+func nonNullList(of *Type) *Type {
+	return NewNonNull(NewList(NewNonNull(of)))
+}
+
+func constant(v interface{}) ResolveFunc {
+	return func(ResolveParams) (interface{}, error) { return v, nil }
+}
+
+// optional maps empty strings to null
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nonNilArgs(args []*Argument) []*Argument {
+	if args == nil {
+		return []*Argument{}
+	}
+	return args
+}
+
+// formatLiteral prints a default value as GraphQL source
+func formatLiteral(t *Type, v interface{}) string {
+	named := t.named()
+	switch v := v.(type) {
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatLiteral(named, item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case string:
+		if named.Kind == KindEnum {
+			return v
+		}
+		return strconv.Quote(v)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,447 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nitrix4ly/triff/commands"
+	"github.com/nitrix4ly/triff/core"
+)
+
+// maxPageSize bounds the first argument of keys
+const maxPageSize = 1000
+
+// keyNode is a key with the value it had when it was looked up, so every
+// field of one Key object describes the same version
+type keyNode struct {
+	key   string
+	value *core.TriffValue
+}
+
+// NewKeyspaceSchema builds the schema served on /graphql: key/value CRUD,
+// hash field access, list slices and paginated keyspace listings
+func NewKeyspaceSchema(db *core.Database) (*Schema, error) {
+	stringCommands := commands.NewStringCommands(db)
+	hashCommands := commands.NewHashCommands(db)
+
+	lookup := func(key string) interface{} {
+		value, exists := db.Object(key)
+		if !exists {
+			return nil
+		}
+		return &keyNode{key: key, value: value}
+	}
+
+	hashFieldType := &Type{
+		Kind:        KindObject,
+		Name:        "HashField",
+		Description: "A field of a hash",
+		Fields: []*Field{
+			{Name: "field", Type: NewNonNull(String)},
+			{Name: "value", Type: NewNonNull(String)},
+		},
+	}
+
+	keyType := &Type{
+		Kind:        KindObject,
+		Name:        "Key",
+		Description: "A key and its value",
+		Fields: []*Field{
+			{Name: "key", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*keyNode).key, nil
+			}},
+			{Name: "type", Description: "string, hash, list, set, zset, bloom, cms or cuckoo", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*keyNode).value.Type.String(), nil
+			}},
+			{Name: "ttl", Description: "Remaining seconds to live, -1 without expiry", Type: NewNonNull(Int), Resolve: func(p ResolveParams) (interface{}, error) {
+				return db.GetTTL(p.Source.(*keyNode).key), nil
+			}},
+			{Name: "version", Type: NewNonNull(Int), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*keyNode).value.Version, nil
+			}},
+			{Name: "updatedAt", Description: "RFC 3339 time of the last write", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*keyNode).value.UpdatedAt.Format(time.RFC3339Nano), nil
+			}},
+			{Name: "value", Description: "Strings as stored, other types as JSON", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+				data := p.Source.(*keyNode).value.Data
+				if s, ok := data.(string); ok {
+					return s, nil
+				}
+				encoded, err := json.Marshal(data)
+				return string(encoded), err
+			}},
+			{Name: "length", Description: "Bytes of a string, elements of a collection", Type: NewNonNull(Int), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*keyNode).value.Len(), nil
+			}},
+			{
+				Name:        "fields",
+				Description: "Fields of a hash ordered by name, null for other types",
+				Type:        NewList(NewNonNull(hashFieldType)),
+				Args:        []*Argument{{Name: "names", Description: "Only these fields", Type: NewList(NewNonNull(String))}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					fields, ok := hashData(p.Source.(*keyNode).value)
+					if !ok {
+						return nil, nil
+					}
+					names := make([]string, 0, len(fields))
+					if wanted, ok := p.Args["names"].([]interface{}); ok {
+						for _, name := range wanted {
+							if _, exists := fields[name.(string)]; exists {
+								names = append(names, name.(string))
+							}
+						}
+					} else {
+						for name := range fields {
+							names = append(names, name)
+						}
+						sort.Strings(names)
+					}
+					result := make([]map[string]interface{}, len(names))
+					for i, name := range names {
+						result[i] = map[string]interface{}{"field": name, "value": fields[name]}
+					}
+					return result, nil
+				},
+			},
+			{
+				Name:        "field",
+				Description: "One field of a hash",
+				Type:        String,
+				Args:        []*Argument{{Name: "name", Type: NewNonNull(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					fields, ok := hashData(p.Source.(*keyNode).value)
+					if !ok {
+						return nil, nil
+					}
+					if value, exists := fields[p.Args["name"].(string)]; exists {
+						return value, nil
+					}
+					return nil, nil
+				},
+			},
+			{
+				Name:        "list",
+				Description: "Elements start through stop of a list, inclusive; negative indexes count from the end. Null for other types.",
+				Type:        NewList(NewNonNull(String)),
+				Args: []*Argument{
+					{Name: "start", Type: Int, Default: 0},
+					{Name: "stop", Type: Int, Default: -1},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					items, ok := listData(p.Source.(*keyNode).value)
+					if !ok {
+						return nil, nil
+					}
+					return sliceRange(items, p.Args["start"].(int), p.Args["stop"].(int)), nil
+				},
+			},
+		},
+	}
+
+	pageInfoType := &Type{
+		Kind: KindObject,
+		Name: "PageInfo",
+		Fields: []*Field{
+			{Name: "hasNextPage", Type: NewNonNull(Boolean)},
+			{Name: "endCursor", Description: "Pass as after to fetch the next page", Type: String},
+		},
+	}
+	keyEdgeType := &Type{
+		Kind: KindObject,
+		Name: "KeyEdge",
+		Fields: []*Field{
+			{Name: "cursor", Type: NewNonNull(String)},
+			{Name: "node", Type: NewNonNull(keyType)},
+		},
+	}
+	keyConnectionType := &Type{
+		Kind:        KindObject,
+		Name:        "KeyConnection",
+		Description: "A page of keys",
+		Fields: []*Field{
+			{Name: "edges", Type: nonNullList(keyEdgeType)},
+			{Name: "nodes", Type: nonNullList(keyType)},
+			{Name: "pageInfo", Type: NewNonNull(pageInfoType)},
+			{Name: "totalCount", Description: "Keys matching the filters across all pages", Type: NewNonNull(Int)},
+		},
+	}
+
+	queryRowType := &Type{
+		Kind: KindObject,
+		Name: "QueryRow",
+		Fields: []*Field{
+			{Name: "key", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(core.QueryRow).Key, nil
+			}},
+			{Name: "fields", Type: nonNullList(hashFieldType), Resolve: func(p ResolveParams) (interface{}, error) {
+				row := p.Source.(core.QueryRow)
+				names := make([]string, 0, len(row.Fields))
+				for name := range row.Fields {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				result := make([]map[string]interface{}, len(names))
+				for i, name := range names {
+					result[i] = map[string]interface{}{"field": name, "value": row.Fields[name]}
+				}
+				return result, nil
+			}},
+		},
+	}
+	queryResultType := &Type{
+		Kind:        KindObject,
+		Name:        "QueryResult",
+		Description: "Hashes selected by a SELECT query",
+		Fields: []*Field{
+			{Name: "rows", Type: nonNullList(queryRowType), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*core.QueryResult).Rows, nil
+			}},
+			{Name: "total", Description: "Matching rows before LIMIT and OFFSET", Type: NewNonNull(Int), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*core.QueryResult).Total, nil
+			}},
+			{Name: "scanned", Type: NewNonNull(Int), Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(*core.QueryResult).Scanned, nil
+			}},
+			{Name: "index", Description: "Secondary index used, if any", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+				return optional(p.Source.(*core.QueryResult).Index), nil
+			}},
+		},
+	}
+
+	query := &Type{
+		Kind: KindObject,
+		Name: "Query",
+		Fields: []*Field{
+			{
+				Name:        "key",
+				Description: "Look up a key, null if it does not exist",
+				Type:        keyType,
+				Args:        []*Argument{{Name: "key", Type: NewNonNull(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return lookup(p.Args["key"].(string)), nil
+				},
+			},
+			{
+				Name:        "keys",
+				Description: "List keys in key order, page by page",
+				Type:        NewNonNull(keyConnectionType),
+				Args: []*Argument{
+					{Name: "pattern", Description: "Glob pattern", Type: String},
+					{Name: "prefix", Type: String},
+					{Name: "type", Description: "Only keys of this type", Type: String},
+					{Name: "first", Description: fmt.Sprintf("Page size, at most %d", maxPageSize), Type: Int, Default: 50},
+					{Name: "after", Description: "endCursor of the previous page", Type: String},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					first := p.Args["first"].(int)
+					if first <= 0 || first > maxPageSize {
+						return nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+					}
+					opts := core.BrowseOptions{Limit: first}
+					opts.Pattern, _ = p.Args["pattern"].(string)
+					opts.Prefix, _ = p.Args["prefix"].(string)
+					opts.Type, _ = p.Args["type"].(string)
+					opts.Cursor, _ = p.Args["after"].(string)
+
+					page, err := db.BrowseContext(p.Context, opts)
+					if err != nil {
+						return nil, err
+					}
+					edges := make([]map[string]interface{}, 0, len(page.Entries))
+					nodes := make([]interface{}, 0, len(page.Entries))
+					var endCursor interface{}
+					for _, entry := range page.Entries {
+						endCursor = entry.Cursor
+						node := lookup(entry.Key)
+						if node == nil {
+							// Deleted since the page was read
+							continue
+						}
+						edges = append(edges, map[string]interface{}{"cursor": entry.Cursor, "node": node})
+						nodes = append(nodes, node)
+					}
+					return map[string]interface{}{
+						"edges":      edges,
+						"nodes":      nodes,
+						"totalCount": page.Total,
+						"pageInfo": map[string]interface{}{
+							"hasNextPage": page.NextCursor != "",
+							"endCursor":   endCursor,
+						},
+					}, nil
+				},
+			},
+			{
+				Name:        "query",
+				Description: "Run a SELECT query over hashes, as accepted by the QUERY command",
+				Type:        NewNonNull(queryResultType),
+				Args:        []*Argument{{Name: "query", Type: NewNonNull(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					q, err := core.ParseQuery(p.Args["query"].(string))
+					if err != nil {
+						return nil, err
+					}
+					return db.QueryContext(p.Context, q)
+				},
+			},
+		},
+	}
+
+	mutation := &Type{
+		Kind: KindObject,
+		Name: "Mutation",
+		Fields: []*Field{
+			{
+				Name:        "set",
+				Description: "Set a string value, optionally expiring after ttl seconds",
+				Type:        NewNonNull(keyType),
+				Args: []*Argument{
+					{Name: "key", Type: NewNonNull(String)},
+					{Name: "value", Type: NewNonNull(String)},
+					{Name: "ttl", Type: Int},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					key := p.Args["key"].(string)
+					ttl, _ := p.Args["ttl"].(int)
+					if ttl < 0 {
+						return nil, errors.New("ttl must not be negative")
+					}
+					if response := stringCommands.Set(key, p.Args["value"].(string), int64(ttl)); !response.Success {
+						return nil, response.Failure()
+					}
+					return lookup(key), nil
+				},
+			},
+			{
+				Name:        "delete",
+				Description: "Delete a key, returning whether it existed",
+				Type:        NewNonNull(Boolean),
+				Args:        []*Argument{{Name: "key", Type: NewNonNull(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return db.Delete(p.Args["key"].(string)), nil
+				},
+			},
+			{
+				Name:        "expire",
+				Description: "Expire a key after seconds, returning whether it exists",
+				Type:        NewNonNull(Boolean),
+				Args: []*Argument{
+					{Name: "key", Type: NewNonNull(String)},
+					{Name: "seconds", Type: NewNonNull(Int)},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					seconds := p.Args["seconds"].(int)
+					if seconds <= 0 {
+						return nil, errors.New("seconds must be positive")
+					}
+					return db.SetTTL(p.Args["key"].(string), int64(seconds)), nil
+				},
+			},
+			{
+				Name:        "persist",
+				Description: "Remove a key's expiry, returning whether it had one",
+				Type:        NewNonNull(Boolean),
+				Args:        []*Argument{{Name: "key", Type: NewNonNull(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return db.Persist(p.Args["key"].(string)), nil
+				},
+			},
+			{
+				Name:        "hset",
+				Description: "Set a hash field, creating the hash if needed",
+				Type:        NewNonNull(keyType),
+				Args: []*Argument{
+					{Name: "key", Type: NewNonNull(String)},
+					{Name: "field", Type: NewNonNull(String)},
+					{Name: "value", Type: NewNonNull(String)},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					key := p.Args["key"].(string)
+					if response := hashCommands.HSet(key, p.Args["field"].(string), p.Args["value"].(string)); !response.Success {
+						return nil, response.Failure()
+					}
+					return lookup(key), nil
+				},
+			},
+			{
+				Name:        "hdel",
+				Description: "Remove a hash field, returning whether it existed",
+				Type:        NewNonNull(Boolean),
+				Args: []*Argument{
+					{Name: "key", Type: NewNonNull(String)},
+					{Name: "field", Type: NewNonNull(String)},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					removed, err := core.Result[int](hashCommands.HDel(p.Args["key"].(string), p.Args["field"].(string)))
+					if err != nil {
+						return nil, err
+					}
+					return removed == 1, nil
+				},
+			},
+		},
+	}
+
+	return NewSchema(query, mutation)
+}
+
+func hashData(value *core.TriffValue) (map[string]string, bool) {
+	if value.Type != core.HASH {
+		return nil, false
+	}
+	switch data := value.Data.(type) {
+	case map[string]string:
+		return data, true
+	case core.PackedHash:
+		return data.Map(), true
+	case map[string]interface{}:
+		fields := make(map[string]string, len(data))
+		for field, v := range data {
+			fields[field] = fmt.Sprint(v)
+		}
+		return fields, true
+	}
+	return nil, false
+}
+
+func listData(value *core.TriffValue) ([]string, bool) {
+	if value.Type != core.LIST {
+		return nil, false
+	}
+	switch data := value.Data.(type) {
+	case []string:
+		return data, true
+	case []interface{}:
+		// Lists loaded from a JSON snapshot
+		items := make([]string, len(data))
+		for i, v := range data {
+			items[i] = fmt.Sprint(v)
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// sliceRange applies LRANGE-style inclusive indexes
+func sliceRange(items []string, start, stop int) []string {
+	n := len(items)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+	return items[start : stop+1]
+}
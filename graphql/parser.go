@@ -0,0 +1,765 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location is a line and column in the query document, both 1-based
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// document is a parsed executable document
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // "query" or "mutation"
+	name       string
+	variables  []*variableDef
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type variableDef struct {
+	name         string
+	typ          *typeRef
+	defaultValue value
+	loc          Location
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.list != nil {
+		s = "[" + t.list.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	arguments  map[string]value
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []*directive
+	selections    []selection
+	loc           Location
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	selections    []selection
+	loc           Location
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+// Values as written in the document. Literals are stored as the Go values
+// JSON decoding would produce; enumValue, variable, listValue and
+// objectValue may contain variables and are resolved at execution time.
+type value interface{}
+
+type (
+	enumValue   string
+	variable    string
+	listValue   []value
+	objectValue map[string]value
+)
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  int
+	text  string
+	value string // Decoded content of string tokens
+	loc   Location
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int // Byte offset of the start of the current line
+}
+
+func (l *lexer) location() Location {
+	return Location{Line: l.line, Column: l.pos - l.col + 1}
+}
+
+func (l *lexer) next() (token, error) {
+	// Skip whitespace, commas and comments
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if c == '\n' {
+			l.pos++
+			l.line++
+			l.col = l.pos
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == ',' {
+			l.pos++
+			continue
+		}
+		if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+			// Byte order mark
+			l.pos += len("\uFEFF")
+			continue
+		}
+		break
+	}
+
+	loc := l.location()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, loc: loc}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&()[]{}:=@|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), loc: loc}, nil
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			return token{}, syntaxError(loc, "unexpected \".\"")
+		}
+		l.pos += 3
+		return token{kind: tokPunct, text: "...", loc: loc}, nil
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, text: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString(loc)
+		}
+		return l.string(loc)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, syntaxError(loc, fmt.Sprintf("unexpected character %q", r))
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return token{}, syntaxError(loc, "invalid number")
+	}
+	kind := tokInt
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.pos++
+		kind = tokFloat
+		if digits() == 0 {
+			return token{}, syntaxError(loc, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		kind = tokFloat
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, syntaxError(loc, "invalid number")
+		}
+	}
+	return token{kind: kind, text: l.src[start:l.pos], loc: loc}, nil
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	l.pos++ // Opening quote
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokString, value: sb.String(), loc: loc}, nil
+		case c == '\n' || c == '\r':
+			return token{}, syntaxError(loc, "unterminated string")
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, syntaxError(loc, "unterminated string")
+			}
+			l.pos++
+			switch esc := l.src[l.pos]; esc {
+			case '"', '\\', '/':
+				sb.WriteByte(esc)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if l.pos+5 > len(l.src) {
+					return token{}, syntaxError(loc, "invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(l.src[l.pos+1:l.pos+5], 16, 32)
+				if err != nil {
+					return token{}, syntaxError(loc, "invalid unicode escape")
+				}
+				sb.WriteRune(rune(code))
+				l.pos += 4
+			default:
+				return token{}, syntaxError(loc, fmt.Sprintf("invalid escape \\%c", esc))
+			}
+			l.pos++
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, syntaxError(loc, "unterminated string")
+}
+
+// blockString reads a """triple-quoted""" string and removes its common
+// indentation as the spec requires
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.pos += 3
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			l.pos += 3
+			return token{kind: tokString, value: dedentBlockString(sb.String()), loc: loc}, nil
+		}
+		if strings.HasPrefix(l.src[l.pos:], `\"""`) {
+			sb.WriteString(`"""`)
+			l.pos += 4
+			continue
+		}
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = l.pos + 1
+		}
+		sb.WriteByte(l.src[l.pos])
+		l.pos++
+	}
+	return token{}, syntaxError(loc, "unterminated block string")
+}
+
+func dedentBlockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func syntaxError(loc Location, message string) *Error {
+	return &Error{Message: "Syntax Error: " + message, Locations: []Location{loc}}
+}
+
+// parser is a recursive descent parser over the lexer with one token of
+// lookahead
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+// parse parses an executable document: operations and fragments
+func parse(source string) (*document, error) {
+	p := &parser{lexer: &lexer{src: source, line: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			op := &operation{kind: "query", loc: p.tok.loc}
+			var err error
+			if op.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && (p.tok.text == "query" || p.tok.text == "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && p.tok.text == "subscription":
+			return nil, &Error{Message: "subscriptions are not supported", Locations: []Location{p.tok.loc}}
+		case p.tok.kind == tokName && p.tok.text == "fragment":
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := doc.fragments[frag.name]; exists {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q.", frag.name), Locations: []Location{frag.loc}}
+			}
+			doc.fragments[frag.name] = frag
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "document contains no operations"}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return syntaxError(p.tok.loc, "unexpected end of document")
+	}
+	text := p.tok.text
+	if p.tok.kind == tokString {
+		text = strconv.Quote(p.tok.value)
+	}
+	return syntaxError(p.tok.loc, fmt.Sprintf("unexpected %s", text))
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokEOF {
+			return syntaxError(p.tok.loc, fmt.Sprintf("expected %q, found end of document", punct))
+		}
+		return syntaxError(p.tok.loc, fmt.Sprintf("expected %q, found %s", punct, p.tok.text))
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.text, loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.tok.kind == tokName {
+		if op.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if op.variables, err = p.variableDefs(); err != nil {
+			return nil, err
+		}
+	}
+	if op.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) variableDefs() ([]*variableDef, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []*variableDef
+	for !p.peek(")") {
+		def := &variableDef{loc: p.tok.loc}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		var err error
+		if def.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if def.typ, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if p.peek("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if def.defaultValue, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.directives(); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, p.advance()
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	t := &typeRef{}
+	if p.peek("[") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		t.list = elem
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if t.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("!") {
+		t.nonNull = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []selection
+	for !p.peek("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, syntaxError(p.tok.loc, "empty selection set")
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	if p.peek("...") {
+		loc := p.tok.loc
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.text != "on" {
+			spread := &fragmentSpread{loc: loc}
+			var err error
+			if spread.name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if spread.directives, err = p.directives(); err != nil {
+				return nil, err
+			}
+			return spread, nil
+		}
+		inline := &inlineFragment{loc: loc}
+		var err error
+		if p.tok.kind == tokName {
+			// "on" Type
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if inline.typeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if inline.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if inline.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return inline, nil
+	}
+
+	f := &field{loc: p.tok.loc}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		f.alias = f.name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if f.arguments, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]value, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make(map[string]value)
+	for !p.peek(")") {
+		loc := p.tok.loc
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := args[name]; exists {
+			return nil, &Error{Message: fmt.Sprintf("There can be only one argument named %q.", name), Locations: []Location{loc}}
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		d := &directive{}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if p.peek("(") {
+			if d.arguments, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	frag := &fragment{loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if frag.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if frag.name == "on" {
+		return nil, syntaxError(frag.loc, "fragment cannot be named \"on\"")
+	}
+	if p.tok.kind != tokName || p.tok.text != "on" {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if frag.typeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	if frag.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return frag, nil
+}
+
+// value parses a value literal; constant values may not contain variables
+func (p *parser) value(constant bool) (value, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokPunct:
+		switch tok.text {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			return variable(name), err
+		case "[":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			list := listValue{}
+			for !p.peek("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, p.advance()
+		case "{":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			obj := objectValue{}
+			for !p.peek("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return obj, p.advance()
+		}
+	case tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, syntaxError(tok.loc, "integer out of range")
+		}
+		return n, p.advance()
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, syntaxError(tok.loc, "invalid float")
+		}
+		return f, p.advance()
+	case tokString:
+		return tok.value, p.advance()
+	case tokName:
+		switch tok.text {
+		case "true":
+			return true, p.advance()
+		case "false":
+			return false, p.advance()
+		case "null":
+			return nil, p.advance()
+		}
+		return enumValue(tok.text), p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,340 @@
+// Package graphql implements a small GraphQL server: a parser for
+// executable documents, a type system with introspection, and an executor.
+// The keyspace schema served on /graphql is built with NewKeyspaceSchema.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Kind classifies a type, as reported by introspection
+type Kind string
+
+const (
+	KindScalar  Kind = "SCALAR"
+	KindObject  Kind = "OBJECT"
+	KindEnum    Kind = "ENUM"
+	KindList    Kind = "LIST"
+	KindNonNull Kind = "NON_NULL"
+)
+
+// Type is a named scalar, enum or object type, or a list or non-null
+// wrapper around another type
+type Type struct {
+	Kind        Kind
+	Name        string
+	Description string
+	Fields      []*Field // Object types
+	EnumValues  []string // Enum types
+	OfType      *Type    // List and non-null wrappers
+
+	// Scalars convert resolved values for output and argument values for
+	// resolvers
+	serialize func(v interface{}) (interface{}, error)
+	parse     func(v interface{}) (interface{}, error)
+}
+
+// Field is a field of an object type
+type Field struct {
+	Name        string
+	Description string
+	Type        *Type
+	Args        []*Argument
+	Resolve     ResolveFunc // Nil reads Name from a map[string]interface{} source
+}
+
+// Argument is a field argument. A nil Default means the argument has none.
+type Argument struct {
+	Name        string
+	Description string
+	Type        *Type
+	Default     interface{}
+}
+
+// ResolveFunc produces a field's value from its parent value and arguments
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+// ResolveParams is passed to resolvers
+type ResolveParams struct {
+	Context context.Context        // The request's context
+	Source  interface{}            // Value of the parent object
+	Args    map[string]interface{} // Coerced arguments; omitted optional arguments are absent
+}
+
+// NewList wraps a type in a list
+func NewList(of *Type) *Type {
+	return &Type{Kind: KindList, OfType: of}
+}
+
+// NewNonNull wraps a type as non-null
+func NewNonNull(of *Type) *Type {
+	return &Type{Kind: KindNonNull, OfType: of}
+}
+
+// NewEnum creates an enum type whose values resolve to and from strings
+func NewEnum(name, description string, values ...string) *Type {
+	return &Type{Kind: KindEnum, Name: name, Description: description, EnumValues: values}
+}
+
+// field returns the field named name of an object type
+func (t *Type) field(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// named unwraps list and non-null wrappers
+func (t *Type) named() *Type {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t
+}
+
+func (t *Type) isLeaf() bool {
+	named := t.named()
+	return named.Kind == KindScalar || named.Kind == KindEnum
+}
+
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindList:
+		return "[" + t.OfType.String() + "]"
+	case KindNonNull:
+		return t.OfType.String() + "!"
+	}
+	return t.Name
+}
+
+// Built-in scalars
+var (
+	String = &Type{
+		Kind:        KindScalar,
+		Name:        "String",
+		Description: "The `String` scalar type represents textual data, represented as UTF-8 character sequences.",
+		serialize: func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case []byte:
+				return string(v), nil
+			case bool:
+				return strconv.FormatBool(v), nil
+			case fmt.Stringer:
+				return v.String(), nil
+			}
+			if n, ok := toFloat(v); ok {
+				return strconv.FormatFloat(n, 'f', -1, 64), nil
+			}
+			return nil, fmt.Errorf("String cannot represent value: %v", v)
+		},
+		parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent a non string value: %v", v)
+		},
+	}
+
+	Int = &Type{
+		Kind:        KindScalar,
+		Name:        "Int",
+		Description: "The `Int` scalar type represents non-fractional signed whole numeric values between -(2^31) and 2^31 - 1.",
+		serialize: func(v interface{}) (interface{}, error) {
+			return toInt(v)
+		},
+		parse: func(v interface{}) (interface{}, error) {
+			if _, ok := v.(bool); ok {
+				return nil, fmt.Errorf("Int cannot represent non-integer value: %v", v)
+			}
+			return toInt(v)
+		},
+	}
+
+	Float = &Type{
+		Kind:        KindScalar,
+		Name:        "Float",
+		Description: "The `Float` scalar type represents signed double-precision fractional values as specified by IEEE 754.",
+		serialize: func(v interface{}) (interface{}, error) {
+			if n, ok := toFloat(v); ok && !math.IsInf(n, 0) && !math.IsNaN(n) {
+				return n, nil
+			}
+			return nil, fmt.Errorf("Float cannot represent value: %v", v)
+		},
+		parse: func(v interface{}) (interface{}, error) {
+			if n, ok := toFloat(v); ok {
+				return n, nil
+			}
+			return nil, fmt.Errorf("Float cannot represent non numeric value: %v", v)
+		},
+	}
+
+	Boolean = &Type{
+		Kind:        KindScalar,
+		Name:        "Boolean",
+		Description: "The `Boolean` scalar type represents `true` or `false`.",
+		serialize: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent value: %v", v)
+		},
+		parse: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %v", v)
+		},
+	}
+
+	ID = &Type{
+		Kind:        KindScalar,
+		Name:        "ID",
+		Description: "The `ID` scalar type represents a unique identifier, serialized as a string.",
+		serialize: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, err := toInt(v); err == nil {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %v", v)
+		},
+		parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, err := toInt(v); err == nil {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %v", v)
+		},
+	}
+)
+
+// toInt converts any Go number holding a whole value in the 32-bit range
+func toInt(v interface{}) (int, error) {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt32 {
+			return 0, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %v", v)
+		}
+		n = int64(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("Int cannot represent non-integer value: %v", v)
+		}
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return 0, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %v", v)
+		}
+		n = int64(v)
+	default:
+		return 0, fmt.Errorf("Int cannot represent non-integer value: %v", v)
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %v", v)
+	}
+	return int(n), nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Schema is a set of types with a query root and an optional mutation root
+type Schema struct {
+	Query    *Type
+	Mutation *Type
+	types    map[string]*Type
+}
+
+// NewSchema collects every type reachable from the roots, including the
+// introspection types, and checks that type names are unique
+func NewSchema(query, mutation *Type) (*Schema, error) {
+	if query == nil || query.Kind != KindObject {
+		return nil, fmt.Errorf("graphql: query root must be an object type")
+	}
+	s := &Schema{Query: query, Mutation: mutation, types: make(map[string]*Type)}
+	roots := []*Type{query, String, Boolean, introspectionSchema}
+	if mutation != nil {
+		roots = append(roots, mutation)
+	}
+	for _, t := range roots {
+		if err := s.addType(t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) addType(t *Type) error {
+	t = t.named()
+	if existing, exists := s.types[t.Name]; exists {
+		if existing != t {
+			return fmt.Errorf("graphql: schema has two types named %q", t.Name)
+		}
+		return nil
+	}
+	if t.Name == "" {
+		return fmt.Errorf("graphql: unnamed %s type", t.Kind)
+	}
+	s.types[t.Name] = t
+	for _, f := range t.Fields {
+		if err := s.addType(f.Type); err != nil {
+			return err
+		}
+		for _, arg := range f.Args {
+			if !arg.Type.isLeaf() {
+				return fmt.Errorf("graphql: argument %s.%s(%s) must be a scalar or enum", t.Name, f.Name, arg.Name)
+			}
+			if err := s.addType(arg.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortedTypes returns all named types ordered by name
+func (s *Schema) sortedTypes() []*Type {
+	types := make([]*Type, 0, len(s.types))
+	for _, t := range s.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Name < types[j].Name
+	})
+	return types
+}
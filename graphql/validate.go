@@ -0,0 +1,240 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+// Meta fields available on the query root. Their types are set once the
+// introspection types exist.
+var (
+	schemaMetaField = &Field{
+		Name:        "__schema",
+		Description: "Access the current type schema of this server.",
+	}
+	typeMetaField = &Field{
+		Name:        "__type",
+		Description: "Request the type information of a single type.",
+		Args:        []*Argument{{Name: "name", Type: NewNonNull(String)}},
+	}
+)
+
+// Directives every schema supports
+var builtinDirectives = []*directiveDef{
+	{
+		Name:        "include",
+		Description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Argument{{Name: "if", Description: "Included when true.", Type: NewNonNull(Boolean)}},
+	},
+	{
+		Name:        "skip",
+		Description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Argument{{Name: "if", Description: "Skipped when true.", Type: NewNonNull(Boolean)}},
+	},
+}
+
+type directiveDef struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []*Argument
+}
+
+// fieldDef looks up a field, including the introspection meta fields
+func (s *Schema) fieldDef(t *Type, name string) *Field {
+	if t == s.Query {
+		switch name {
+		case "__schema":
+			return schemaMetaField
+		case "__type":
+			return typeMetaField
+		}
+	}
+	return t.field(name)
+}
+
+// validator checks an operation against the schema before anything is
+// executed, so a bad query never runs half a mutation
+type validator struct {
+	schema    *Schema
+	doc       *document
+	hidden    func(typeName, field string) bool
+	variables map[string]*variableDef
+	errors    []*Error
+}
+
+func (e *execution) validate(op *operation, root *Type) []*Error {
+	v := &validator{schema: e.schema, doc: e.doc, hidden: e.hidden, variables: make(map[string]*variableDef)}
+	for _, def := range op.variables {
+		if _, exists := v.variables[def.name]; exists {
+			v.errorf(def.loc, "There can be only one variable named \"$%s\".", def.name)
+		}
+		v.variables[def.name] = def
+	}
+	for name, frag := range e.doc.fragments {
+		if _, exists := e.schema.types[frag.typeCondition]; !exists {
+			v.errorf(frag.loc, "Unknown type %q.", frag.typeCondition)
+		}
+		if v.cyclic(name, map[string]bool{}) {
+			v.errorf(frag.loc, "Cannot spread fragment %q within itself.", name)
+		}
+	}
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	v.directives(op.directives, op.loc)
+	v.selections(root, op.selections)
+	return v.errors
+}
+
+func (v *validator) errorf(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}})
+}
+
+// cyclic reports whether a fragment spreads itself, directly or not
+func (v *validator) cyclic(name string, visiting map[string]bool) bool {
+	if visiting[name] {
+		return true
+	}
+	frag, exists := v.doc.fragments[name]
+	if !exists {
+		return false
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+	return v.spreadsCycle(frag.selections, visiting)
+}
+
+func (v *validator) spreadsCycle(selections []selection, visiting map[string]bool) bool {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if v.spreadsCycle(sel.selections, visiting) {
+				return true
+			}
+		case *inlineFragment:
+			if v.spreadsCycle(sel.selections, visiting) {
+				return true
+			}
+		case *fragmentSpread:
+			if v.cyclic(sel.name, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *validator) selections(t *Type, selections []selection) {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			v.field(t, sel)
+		case *inlineFragment:
+			v.directives(sel.directives, sel.loc)
+			if sel.typeCondition != "" && sel.typeCondition != t.Name {
+				v.errorf(sel.loc, "Fragment cannot be spread here as objects of type %q can never be of type %q.", t.Name, sel.typeCondition)
+				continue
+			}
+			v.selections(t, sel.selections)
+		case *fragmentSpread:
+			v.directives(sel.directives, sel.loc)
+			frag, exists := v.doc.fragments[sel.name]
+			if !exists {
+				v.errorf(sel.loc, "Unknown fragment %q.", sel.name)
+				continue
+			}
+			if frag.typeCondition != t.Name {
+				v.errorf(sel.loc, "Fragment %q cannot be spread here as objects of type %q can never be of type %q.", sel.name, t.Name, frag.typeCondition)
+				continue
+			}
+			v.selections(t, frag.selections)
+		}
+	}
+}
+
+func (v *validator) field(parent *Type, f *field) {
+	v.directives(f.directives, f.loc)
+	if f.name == "__typename" {
+		if len(f.arguments) > 0 || f.selections != nil {
+			v.errorf(f.loc, "Field \"__typename\" takes no arguments or subfields.")
+		}
+		return
+	}
+
+	def := v.schema.fieldDef(parent, f.name)
+	if def != nil && v.hidden != nil && (parent == v.schema.Query || parent == v.schema.Mutation) && v.hidden(parent.Name, f.name) {
+		def = nil
+	}
+	if def == nil {
+		v.errorf(f.loc, "Cannot query field %q on type %q.", f.name, parent.Name)
+		return
+	}
+	v.arguments(def.Args, f.arguments, f.loc, fmt.Sprintf("field %q", f.name))
+
+	named := def.Type.named()
+	switch {
+	case named.Kind == KindObject && f.selections == nil:
+		v.errorf(f.loc, "Field %q of type %q must have a selection of subfields.", f.name, def.Type)
+	case named.Kind != KindObject && f.selections != nil:
+		v.errorf(f.loc, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+	case named.Kind == KindObject:
+		v.selections(named, f.selections)
+	}
+}
+
+func (v *validator) directives(directives []*directive, loc Location) {
+	for _, d := range directives {
+		var def *directiveDef
+		for _, builtin := range builtinDirectives {
+			if builtin.Name == d.name {
+				def = builtin
+			}
+		}
+		if def == nil {
+			v.errorf(loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		v.arguments(def.Args, d.arguments, loc, fmt.Sprintf("directive \"@%s\"", d.name))
+	}
+}
+
+// arguments checks names, required arguments and variable use. Values are
+// type checked when they are coerced during execution.
+func (v *validator) arguments(defs []*Argument, given map[string]value, loc Location, owner string) {
+	for name, val := range given {
+		found := false
+		for _, def := range defs {
+			if def.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			v.errorf(loc, "Unknown argument %q on %s.", name, owner)
+		}
+		v.variableUses(val, loc)
+	}
+	for _, def := range defs {
+		if _, provided := given[def.Name]; !provided && def.Type.Kind == KindNonNull && def.Default == nil {
+			v.errorf(loc, "Argument %q of type %q is required on %s, but it was not provided.", def.Name, def.Type, owner)
+		}
+	}
+}
+
+func (v *validator) variableUses(val value, loc Location) {
+	switch val := val.(type) {
+	case variable:
+		if _, defined := v.variables[string(val)]; !defined {
+			v.errorf(loc, "Variable \"$%s\" is not defined.", string(val))
+		}
+	case listValue:
+		for _, item := range val {
+			v.variableUses(item, loc)
+		}
+	case objectValue:
+		for _, item := range val {
+			v.variableUses(item, loc)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package offline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Hub is the server side of offline sync. It remembers the keys of the last
+// logSize changes so a pull only returns what changed since the client's
+// cursor; a client whose cursor fell out of the log, or that has none, gets
+// every key of its scopes instead.
+type Hub struct {
+	db    *core.Database
+	epoch string
+
+	log   []logEntry // Ring of changed keys, oldest at next once full
+	next  int
+	full  bool
+	head  uint64 // Seq of the newest change
+	floor uint64 // Cursors before this seq can't be answered from the log
+	mu    sync.Mutex
+}
+
+type logEntry struct {
+	seq uint64
+	key string
+}
+
+// NewHub creates a hub remembering up to logSize changed keys. With a log
+// size of 0 every pull returns whole scopes.
+func NewHub(db *core.Database, logSize int) *Hub {
+	epoch := make([]byte, 8)
+	rand.Read(epoch)
+	h := &Hub{db: db, epoch: hex.EncodeToString(epoch)}
+	if logSize > 0 {
+		h.log = make([]logEntry, logSize)
+		db.OnChange(h.record)
+	}
+	return h
+}
+
+// record is the database change listener
+func (h *Hub) record(event core.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.head = event.Seq
+	if event.Op == core.ChangeFlush {
+		// Every key may be gone, so only whole scopes are accurate now
+		h.next, h.full, h.floor = 0, false, event.Seq
+		return
+	}
+	if h.full {
+		h.floor = h.log[h.next].seq
+	}
+	h.log[h.next] = logEntry{seq: event.Seq, key: event.Key}
+	h.next++
+	if h.next == len(h.log) {
+		h.next, h.full = 0, true
+	}
+}
+
+// Sync applies a request's pushed changes and answers its pull
+func (h *Hub) Sync(request Request) (*Response, error) {
+	response := &Response{Changes: []Change{}, Results: make([]Result, 0, len(request.Changes))}
+	for _, change := range request.Changes {
+		result, err := h.apply(request.Scopes, change)
+		if err != nil {
+			return nil, err
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	pull := make([]Scope, 0, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		if scope.Pull {
+			pull = append(pull, scope)
+		}
+	}
+
+	h.mu.Lock()
+	head := h.head
+	keys, incremental := h.changedSince(request.Cursor)
+	h.mu.Unlock()
+	response.Cursor = formatCursor(h.epoch, head)
+	if len(pull) == 0 {
+		return response, nil
+	}
+
+	if !incremental {
+		response.Full = true
+		keys = h.db.Keys("*")
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if _, found := scopeFor(pull, key); !found {
+			continue
+		}
+		change := Change{Key: key}
+		if value, found := h.db.Object(key); found {
+			change.Value = value
+		} else if response.Full {
+			continue
+		}
+		response.Changes = append(response.Changes, change)
+	}
+	return response, nil
+}
+
+// changedSince returns the keys changed after cursor in order of their
+// last change, or false if the log can't tell. Callers must hold the lock.
+func (h *Hub) changedSince(cursor string) ([]string, bool) {
+	if cursor == "" || h.log == nil {
+		return nil, false
+	}
+	epoch, seq, err := parseCursor(cursor)
+	if err != nil || epoch != h.epoch || seq < h.floor || seq > h.head {
+		return nil, false
+	}
+
+	entries := h.log
+	if !h.full {
+		entries = h.log[:h.next]
+	}
+	last := make(map[string]uint64)
+	for _, entry := range entries {
+		if entry.seq > seq && entry.seq > last[entry.key] {
+			last[entry.key] = entry.seq
+		}
+	}
+	keys := make([]string, 0, len(last))
+	for key := range last {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return last[keys[i]] < last[keys[j]] })
+	return keys, true
+}
+
+// apply resolves one pushed change against the server's value
+func (h *Hub) apply(scopes []Scope, change Change) (Result, error) {
+	result := Result{Key: change.Key}
+	scope, found := scopeFor(scopes, change.Key)
+	if !found {
+		result.Status = StatusRejected
+		return result, nil
+	}
+
+	err := h.db.Update(change.Key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if merged, ok := mergeCRDT(old, change.Value); ok {
+			result.Status = StatusMerged
+			return merged, nil
+		}
+
+		var version uint64
+		if old != nil {
+			version = old.Version
+		}
+		if version != change.Base && !clientWins(scope.Conflict, old, change) {
+			result.Status = StatusConflict
+			return old, nil
+		}
+
+		result.Status = StatusApplied
+		if change.Value == nil {
+			return nil, nil
+		}
+		return &core.TriffValue{Type: change.Value.Type, Data: change.Value.Data, TTL: change.Value.TTL}, nil
+	})
+	if errors.Is(err, errs.ErrLimit) {
+		// The server's limits are no reason to fail the client's whole sync
+		result.Status = StatusRejected
+	} else if err != nil {
+		return result, fmt.Errorf("applying %s: %w", change.Key, err)
+	}
+	if value, found := h.db.Object(change.Key); found {
+		result.Value = value
+	}
+	return result, nil
+}
+
+// clientWins decides a conflict: the key changed on the server since the
+// client last saw it
+func clientWins(policy string, current *core.TriffValue, change Change) bool {
+	switch strings.ToLower(policy) {
+	case "client":
+		return true
+	case "newest":
+		// A deletion on the server leaves no time to compare with
+		return current == nil || change.Time.After(current.UpdatedAt)
+	}
+	return false
+}
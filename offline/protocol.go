@@ -0,0 +1,137 @@
+// Package offline keeps an embedded triff database in sync with a central
+// triff server over unreliable connections. The embedded side, a Syncer,
+// remembers which keys under its sync scopes changed locally while it was
+// offline; each sync pushes their current values to the server and pulls
+// what changed there since the last sync. The server side, a Hub, applies
+// pushed changes according to each scope's conflict policy and answers
+// pulls from a log of recently changed keys.
+package offline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// Path is where the server's REST API serves sync requests
+const Path = "/api/v1/sync"
+
+// Statuses of pushed changes
+const (
+	StatusApplied  = "applied"  // The server took the pushed value
+	StatusMerged   = "merged"   // The pushed CRDT was merged into the server's
+	StatusConflict = "conflict" // The key changed on the server too and the server's value was kept
+	StatusRejected = "rejected" // The key is outside every scope of the request, or the value breaks a server limit
+)
+
+// Request is one sync round from a Syncer: local changes to push and the
+// scopes to pull
+type Request struct {
+	Cursor  string   `json:"cursor,omitempty"` // From the last response, empty to pull everything
+	Scopes  []Scope  `json:"scopes"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Scope is a synced key prefix as sent to the server
+type Scope struct {
+	Prefix   string `json:"prefix"`
+	Conflict string `json:"conflict,omitempty"` // "server" if empty, "client" or "newest"
+	Pull     bool   `json:"pull"`
+}
+
+// Change is a key's value in either direction. Value is nil for a key that
+// was deleted.
+type Change struct {
+	Key   string           `json:"key"`
+	Value *core.TriffValue `json:"value,omitempty"`
+
+	// Base is the server version the pushed change was made on, 0 if the
+	// key did not exist on the server as far as the client knew
+	Base uint64 `json:"base,omitempty"`
+	// Time is when the pushed change was made, for the "newest" policy
+	Time time.Time `json:"time,omitempty"`
+}
+
+// Result tells what became of a pushed change. Value is the server's value
+// afterwards, nil if the key doesn't exist there.
+type Result struct {
+	Key    string           `json:"key"`
+	Status string           `json:"status"`
+	Value  *core.TriffValue `json:"value,omitempty"`
+}
+
+// Response answers a Request
+type Response struct {
+	Cursor  string   `json:"cursor"`
+	Full    bool     `json:"full"` // Changes hold every key of the pulled scopes; keys not among them were deleted
+	Changes []Change `json:"changes"`
+	Results []Result `json:"results"`
+}
+
+// scopeFor returns the scope with the longest prefix matching key
+func scopeFor(scopes []Scope, key string) (Scope, bool) {
+	best, found := Scope{}, false
+	for _, scope := range scopes {
+		if strings.HasPrefix(key, scope.Prefix) && (!found || len(scope.Prefix) > len(best.Prefix)) {
+			best, found = scope, true
+		}
+	}
+	return best, found
+}
+
+// formatCursor encodes a position in a hub's change log. The epoch changes
+// whenever the server restarts, which invalidates older cursors.
+func formatCursor(epoch string, seq uint64) string {
+	return epoch + ":" + strconv.FormatUint(seq, 10)
+}
+
+func parseCursor(cursor string) (string, uint64, error) {
+	epoch, seq, found := strings.Cut(cursor, ":")
+	if !found {
+		return "", 0, fmt.Errorf("invalid sync cursor: %q", cursor)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid sync cursor: %q", cursor)
+	}
+	return epoch, n, nil
+}
+
+// isCRDT reports whether values of a type are merged instead of replaced
+func isCRDT(value *core.TriffValue) bool {
+	return value != nil && (value.Type == core.PNCOUNTER || value.Type == core.ORSET)
+}
+
+// mergeCRDT merges incoming into current when both are the same CRDT type,
+// returning current itself if the merge changed nothing
+func mergeCRDT(current, incoming *core.TriffValue) (*core.TriffValue, bool) {
+	if !isCRDT(current) || !isCRDT(incoming) || current.Type != incoming.Type {
+		return nil, false
+	}
+	switch data := current.Data.(type) {
+	case *core.PNCounter:
+		other, ok := incoming.Data.(*core.PNCounter)
+		if !ok {
+			return nil, false
+		}
+		merged := data.Clone()
+		if !merged.Merge(other) {
+			return current, true
+		}
+		return &core.TriffValue{Type: current.Type, Data: merged, TTL: current.TTL}, true
+	case *core.ORSet:
+		other, ok := incoming.Data.(*core.ORSet)
+		if !ok {
+			return nil, false
+		}
+		merged := data.Clone()
+		if !merged.Merge(other) {
+			return current, true
+		}
+		return &core.TriffValue{Type: current.Type, Data: merged, TTL: current.TTL}, true
+	}
+	return nil, false
+}
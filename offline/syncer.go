@@ -0,0 +1,481 @@
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+const (
+	stateFile = "state.json"
+
+	requestTimeout = 30 * time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// Syncer keeps the scoped keys of an embedded database in sync with a
+// central server. Local writes are recorded while offline and pushed on
+// the next successful sync; the record survives restarts in the sync
+// directory.
+type Syncer struct {
+	db     *core.Database
+	config core.SyncConfig
+	client *http.Client
+	logger *utils.Logger
+
+	state    syncState
+	changed  bool // State differs from the file
+	lastErr  error
+	applying string // Key being written with a value from the server; only touched under the database lock
+	mu       sync.Mutex
+	syncing  sync.Mutex // Serializes sync rounds
+
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// syncState is what a Syncer persists between runs
+type syncState struct {
+	Cursor   string               `json:"cursor"`
+	Versions map[string]uint64    `json:"versions"` // Server version of each synced key as last seen
+	Dirty    map[string]time.Time `json:"dirty"`    // Keys changed locally since they were pushed, and when
+	LastSync time.Time            `json:"last_sync"`
+}
+
+// SyncStatus reports the state of a Syncer
+type SyncStatus struct {
+	Pending   int       `json:"pending"` // Local changes not yet pushed
+	LastSync  time.Time `json:"last_sync"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Report summarizes one sync round
+type Report struct {
+	Pushed    int `json:"pushed"`    // Local changes the server took
+	Merged    int `json:"merged"`    // Local CRDT changes merged with the server's
+	Conflicts int `json:"conflicts"` // Local changes discarded for the server's value
+	Rejected  int `json:"rejected"`
+	Pulled    int `json:"pulled"`  // Server values written locally
+	Deleted   int `json:"deleted"` // Local keys deleted because the server no longer has them
+}
+
+// NewSyncer creates a syncer for the scopes described by config
+func NewSyncer(db *core.Database, config core.SyncConfig, logger *utils.Logger) *Syncer {
+	return &Syncer{
+		db:      db,
+		config:  config,
+		client:  &http.Client{Timeout: requestTimeout},
+		logger:  logger,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start loads the sync state, begins recording local changes and syncs
+// every interval. On the very first start, keys already in the push
+// scopes are queued for pushing so existing local data isn't lost to the
+// first pull.
+func (s *Syncer) Start() error {
+	if err := os.MkdirAll(s.config.Dir, 0755); err != nil {
+		return err
+	}
+	first, err := s.loadState()
+	if err != nil {
+		return err
+	}
+
+	s.db.OnChange(s.record)
+	if first {
+		// The listener takes s.mu under the database lock, so list the
+		// keys before taking s.mu
+		keys, now := s.db.Keys("*"), time.Now()
+		s.mu.Lock()
+		for _, key := range keys {
+			if s.pushes(key) {
+				s.state.Dirty[key] = now
+			}
+		}
+		s.changed = true
+		s.mu.Unlock()
+	}
+
+	go s.run()
+	s.logger.Info(fmt.Sprintf("Offline sync with %s for %d scopes", s.config.URL, len(s.config.Scopes)))
+	return nil
+}
+
+// Stop ends syncing and saves the record of unsynced keys
+func (s *Syncer) Stop() error {
+	close(s.stop)
+	<-s.done
+	return s.saveState()
+}
+
+// Trigger asks for a sync as soon as possible, for example when the app
+// learns that the network is back. It does not wait for the sync.
+func (s *Syncer) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status reports pending local changes and the last sync
+func (s *Syncer) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := SyncStatus{Pending: len(s.state.Dirty), LastSync: s.state.LastSync}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// record is the database change listener. It runs under the database
+// lock, so it only marks the key.
+func (s *Syncer) record(event core.ChangeEvent) {
+	if event.Op == core.ChangeFlush {
+		s.mu.Lock()
+		for key := range s.state.Versions {
+			if s.pushes(key) {
+				s.state.Dirty[key] = event.Time
+			}
+		}
+		s.changed = true
+		s.mu.Unlock()
+		return
+	}
+	if event.Key == s.applying {
+		s.applying = ""
+		return
+	}
+	// The server expires keys by the same TTL
+	if event.Op == core.ChangeExpire || !s.pushes(event.Key) {
+		return
+	}
+
+	s.mu.Lock()
+	s.state.Dirty[event.Key] = event.Time
+	s.changed = true
+	s.mu.Unlock()
+}
+
+// pushes reports whether local changes to key are pushed
+func (s *Syncer) pushes(key string) bool {
+	scope, found := s.scope(key)
+	return found && scope.Mode != "pull"
+}
+
+// pulls reports whether server changes to key are pulled
+func (s *Syncer) pulls(key string) bool {
+	scope, found := s.scope(key)
+	return found && scope.Mode != "push"
+}
+
+// scope returns the configured scope with the longest prefix matching key
+func (s *Syncer) scope(key string) (core.SyncScope, bool) {
+	best, found := core.SyncScope{}, false
+	for _, scope := range s.config.Scopes {
+		if strings.HasPrefix(key, scope.Prefix) && (!found || len(scope.Prefix) > len(best.Prefix)) {
+			best, found = scope, true
+		}
+	}
+	return best, found
+}
+
+// run syncs every interval until stopped, backing off while the server is
+// unreachable
+func (s *Syncer) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	interval := time.Duration(s.config.Interval) * time.Second
+	backoff := time.Duration(0)
+	next := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.trigger:
+			next = time.Now()
+		case <-ticker.C:
+		}
+
+		if time.Now().Before(next) {
+			if err := s.saveState(); err != nil {
+				s.logger.Error(fmt.Sprintf("sync: saving state: %v", err))
+			}
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		_, err := s.Sync(ctx)
+		cancel()
+		if err != nil {
+			if backoff == 0 {
+				backoff = time.Second
+			} else if backoff < maxBackoff {
+				backoff *= 2
+			}
+			if backoff > interval {
+				backoff = interval
+			}
+			next = time.Now().Add(backoff)
+			s.logger.Warn(fmt.Sprintf("sync: %v, retrying in %s", err, backoff))
+			continue
+		}
+		backoff = 0
+		next = time.Now().Add(interval)
+	}
+}
+
+// Sync runs one round now: local changes are pushed, then server changes
+// are pulled. Keys changed locally during the round are left for the next.
+func (s *Syncer) Sync(ctx context.Context) (Report, error) {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+
+	report, err := s.sync(ctx)
+	s.mu.Lock()
+	s.lastErr = err
+	if err == nil {
+		s.state.LastSync = time.Now()
+		s.changed = true
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return report, err
+	}
+	return report, s.saveState()
+}
+
+func (s *Syncer) sync(ctx context.Context) (Report, error) {
+	var report Report
+
+	s.mu.Lock()
+	request := Request{Cursor: s.state.Cursor}
+	dirty := make(map[string]time.Time, len(s.state.Dirty))
+	for key, at := range s.state.Dirty {
+		dirty[key] = at
+		request.Changes = append(request.Changes, Change{Key: key, Base: s.state.Versions[key], Time: at})
+	}
+	s.mu.Unlock()
+
+	for _, scope := range s.config.Scopes {
+		request.Scopes = append(request.Scopes, Scope{
+			Prefix:   scope.Prefix,
+			Conflict: scope.Conflict,
+			Pull:     scope.Mode != "push",
+		})
+	}
+	sort.Slice(request.Changes, func(i, j int) bool { return request.Changes[i].Key < request.Changes[j].Key })
+	for i := range request.Changes {
+		if value, found := s.db.Object(request.Changes[i].Key); found {
+			request.Changes[i].Value = value
+		}
+	}
+
+	response, err := s.post(ctx, request)
+	if err != nil {
+		return report, err
+	}
+
+	for _, result := range response.Results {
+		switch result.Status {
+		case StatusApplied:
+			report.Pushed++
+		case StatusMerged:
+			report.Merged++
+		case StatusConflict:
+			report.Conflicts++
+		case StatusRejected:
+			report.Rejected++
+			s.logger.Warn(fmt.Sprintf("sync: server rejected change to %s", result.Key))
+		}
+		// Applied values already match; the others take the server's
+		if !s.settle(result.Key, dirty[result.Key], result.Value, result.Status != StatusApplied && result.Status != StatusRejected) {
+			continue
+		}
+		if result.Status == StatusMerged || result.Status == StatusConflict {
+			report.Pulled++
+		}
+	}
+
+	present := make(map[string]bool, len(response.Changes))
+	for _, change := range response.Changes {
+		present[change.Key] = true
+		if !s.pulls(change.Key) || s.seen(change) {
+			continue
+		}
+		if s.settle(change.Key, time.Time{}, change.Value, true) {
+			report.Pulled++
+		}
+	}
+	if response.Full {
+		for _, key := range s.db.Keys("*") {
+			if present[key] || !s.pulls(key) {
+				continue
+			}
+			if s.settle(key, time.Time{}, nil, true) {
+				report.Deleted++
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.state.Cursor = response.Cursor
+	s.changed = true
+	s.mu.Unlock()
+	return report, nil
+}
+
+// seen reports whether a pulled change is the server version this syncer
+// already has, typically its own push coming back
+func (s *Syncer) seen(change Change) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version, known := s.state.Versions[change.Key]
+	if change.Value == nil {
+		return !known
+	}
+	return known && version == change.Value.Version
+}
+
+// settle records the server's value of a key and, with write, stores it
+// locally. A key changed locally after pushedAt (any time, for a pull)
+// keeps its local value until it is pushed. It reports whether the local
+// value was replaced.
+func (s *Syncer) settle(key string, pushedAt time.Time, value *core.TriffValue, write bool) bool {
+	s.mu.Lock()
+	if at, dirty := s.state.Dirty[key]; dirty {
+		if pushedAt.IsZero() || !at.Equal(pushedAt) {
+			s.mu.Unlock()
+			return false
+		}
+		delete(s.state.Dirty, key)
+	}
+	if value == nil {
+		delete(s.state.Versions, key)
+	} else {
+		s.state.Versions[key] = value.Version
+	}
+	s.changed = true
+	s.mu.Unlock()
+
+	if !write {
+		return false
+	}
+	replaced := false
+	err := s.db.Update(key, func(old *core.TriffValue) (*core.TriffValue, error) {
+		if value == nil {
+			if old == nil {
+				return nil, nil
+			}
+			s.applying, replaced = key, true
+			return nil, nil
+		}
+		s.applying, replaced = key, true
+		return &core.TriffValue{Type: value.Type, Data: value.Data, TTL: value.TTL}, nil
+	})
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("sync: writing %s: %v", key, err))
+		return false
+	}
+	return replaced
+}
+
+// post sends a request to the server
+func (s *Syncer) post(ctx context.Context, request Request) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.config.URL, "/")+Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	var response Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding sync response: %w", err)
+	}
+	return &response, nil
+}
+
+// loadState reads the sync state, reporting whether there was none
+func (s *Syncer) loadState() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = syncState{Versions: make(map[string]uint64), Dirty: make(map[string]time.Time)}
+	data, err := os.ReadFile(filepath.Join(s.config.Dir, stateFile))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return false, fmt.Errorf("reading sync state: %w", err)
+	}
+	if s.state.Versions == nil {
+		s.state.Versions = make(map[string]uint64)
+	}
+	if s.state.Dirty == nil {
+		s.state.Dirty = make(map[string]time.Time)
+	}
+	return false, nil
+}
+
+// saveState writes the state atomically if it changed
+func (s *Syncer) saveState() error {
+	s.mu.Lock()
+	if !s.changed {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.state)
+	s.changed = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.config.Dir, stateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		s.mu.Lock()
+		s.changed = true
+		s.mu.Unlock()
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,331 @@
+// Package proxy implements a small caching reverse proxy that stores
+// upstream GET responses in a triff database.
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// KeyPrefix namespaces cached responses in the keyspace
+const KeyPrefix = "proxy:"
+
+// hopHeaders are connection-specific and never forwarded or cached
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// cacheableStatus lists the response codes that are stored
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// ReverseCache forwards requests to configured upstreams and caches GET
+// responses according to their Cache-Control headers
+type ReverseCache struct {
+	db       *core.Database
+	config   core.ProxyConfig
+	prefixes []string // upstream prefixes, longest first
+	client   *http.Client
+	server   *http.Server
+	logger   *utils.Logger
+}
+
+// NewReverseCache creates a caching proxy for the upstreams in config
+func NewReverseCache(db *core.Database, config core.ProxyConfig, logger *utils.Logger) *ReverseCache {
+	prefixes := make([]string, 0, len(config.Upstreams))
+	for prefix := range config.Upstreams {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	return &ReverseCache{
+		db:       db,
+		config:   config,
+		prefixes: prefixes,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// Redirects are cached and returned to the client as they are
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+	}
+}
+
+// Start listens on the configured proxy port
+func (rc *ReverseCache) Start() error {
+	rc.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", rc.config.Port),
+		Handler: rc,
+	}
+	rc.logger.Info(fmt.Sprintf("Caching proxy listening on port %d", rc.config.Port))
+	return rc.server.ListenAndServe()
+}
+
+// Stop shuts the proxy listener down
+func (rc *ReverseCache) Stop() error {
+	if rc.server != nil {
+		return rc.server.Close()
+	}
+	return nil
+}
+
+// CacheKey returns the key a response for an upstream URL is stored under
+func CacheKey(upstreamURL string) string {
+	return KeyPrefix + upstreamURL
+}
+
+// Purge removes the cached response for one upstream URL
+func (rc *ReverseCache) Purge(upstreamURL string) bool {
+	return rc.db.Delete(CacheKey(upstreamURL))
+}
+
+// PurgePrefix removes all cached responses whose upstream URL starts with prefix
+func (rc *ReverseCache) PurgePrefix(prefix string) int {
+	return rc.db.DeletePattern(core.EscapePattern(CacheKey(prefix))+"*", 0)
+}
+
+// ServeHTTP implements http.Handler
+func (rc *ReverseCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := rc.upstreamURL(r.URL)
+	if !ok {
+		http.Error(w, "no upstream for path", http.StatusBadGateway)
+		return
+	}
+
+	switch r.Method {
+	case "PURGE":
+		if rc.Purge(target) {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	case "GET", "HEAD":
+		if rc.serveCached(w, r, target) {
+			return
+		}
+	}
+
+	rc.forward(w, r, target)
+}
+
+// upstreamURL maps a request URL onto the upstream with the longest matching prefix
+func (rc *ReverseCache) upstreamURL(u *url.URL) (string, bool) {
+	for _, prefix := range rc.prefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			target := strings.TrimRight(rc.config.Upstreams[prefix], "/") + u.Path
+			if u.RawQuery != "" {
+				target += "?" + u.RawQuery
+			}
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// serveCached writes a cached response if there is a fresh one
+func (rc *ReverseCache) serveCached(w http.ResponseWriter, r *http.Request, target string) bool {
+	if requestBypassesCache(r) {
+		return false
+	}
+	value, exists := rc.db.Get(CacheKey(target))
+	if !exists || value.Type != core.HASH {
+		return false
+	}
+	fields, ok := core.StringMap(value.Data)
+	if !ok {
+		return false
+	}
+
+	status, err := strconv.Atoi(fields["status"])
+	if err != nil {
+		return false
+	}
+	var header http.Header
+	if err := json.Unmarshal([]byte(fields["header"]), &header); err != nil {
+		return false
+	}
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	if stored, err := strconv.ParseInt(fields["stored_at"], 10, 64); err == nil {
+		w.Header().Set("Age", strconv.FormatInt(time.Now().Unix()-stored, 10))
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(status)
+	if r.Method == "GET" {
+		io.WriteString(w, fields["body"])
+	}
+	return true
+}
+
+// forward proxies a request upstream and caches the response when allowed
+func (rc *ReverseCache) forward(w http.ResponseWriter, r *http.Request, target string) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	req.Header = r.Header.Clone()
+	removeHopHeaders(req.Header)
+	if ip := clientIP(r.RemoteAddr); ip != "" {
+		req.Header.Set("X-Forwarded-For", strings.TrimPrefix(req.Header.Get("X-Forwarded-For")+", "+ip, ", "))
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		rc.logger.Warn(fmt.Sprintf("proxy: upstream request to %s failed: %v", target, err))
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+
+	ttl := int64(0)
+	if r.Method == "GET" && cacheableStatus[resp.StatusCode] {
+		ttl = freshness(resp.Header, rc.config.DefaultTTL)
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	if r.Method == "GET" {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if ttl <= 0 {
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	// Buffer up to the size limit; larger bodies are streamed and not cached
+	var body bytes.Buffer
+	n, err := io.Copy(&body, io.LimitReader(resp.Body, rc.config.MaxBodySize+1))
+	w.Write(body.Bytes())
+	if err != nil {
+		return
+	}
+	if n > rc.config.MaxBodySize {
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	rc.store(target, resp, body.String(), ttl)
+}
+
+// store saves a response as a hash with status, header and body fields
+func (rc *ReverseCache) store(target string, resp *http.Response, body string, ttl int64) {
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	rc.db.Set(CacheKey(target), &core.TriffValue{
+		Type: core.HASH,
+		Data: map[string]string{
+			"status":    strconv.Itoa(resp.StatusCode),
+			"header":    string(encoded),
+			"body":      body,
+			"stored_at": strconv.FormatInt(now, 10),
+		},
+		TTL: now + ttl,
+	})
+}
+
+// freshness returns how many seconds a response may be cached, 0 if it
+// must not be. s-maxage takes precedence over max-age, then Expires, then
+// the configured default.
+func freshness(header http.Header, defaultTTL int64) int64 {
+	if header.Get("Vary") == "*" || header.Get("Set-Cookie") != "" {
+		return 0
+	}
+
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[name]; ok {
+			return 0
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds <= 0 {
+				return 0
+			}
+			return seconds
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		if seconds := int64(time.Until(t).Seconds()); seconds > 0 {
+			return seconds
+		}
+		return 0
+	}
+	return defaultTTL
+}
+
+// parseCacheControl splits a Cache-Control header into lowercase directives
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), "\"")
+	}
+	return directives
+}
+
+// requestBypassesCache honours client requests for an end-to-end reload
+func requestBypassesCache(r *http.Request) bool {
+	directives := parseCacheControl(r.Header.Get("Cache-Control"))
+	_, noCache := directives["no-cache"]
+	_, noStore := directives["no-store"]
+	return noCache || noStore || r.Header.Get("Pragma") == "no-cache"
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+func clientIP(remoteAddr string) string {
+	if i := strings.LastIndex(remoteAddr, ":"); i >= 0 {
+		return remoteAddr[:i]
+	}
+	return remoteAddr
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// maxAdminBody is how much of an admin request's body is kept in the
+// admin audit log
+const maxAdminBody = 4096
+
+// adminRoutes maps the REST endpoints that perform administrative
+// operations to the operation names the TCP commands are recorded under
+var adminRoutes = map[string]string{
+	"DELETE /api/v1/flush":             "FLUSHALL",
+	"DELETE /api/v1/keys":              "DELPATTERN",
+	"PUT /api/v1/config":               "CONFIG SET",
+	"PATCH /api/v1/config":             "CONFIG SET",
+	"POST /api/v1/config/reload":       "CONFIG RELOAD",
+	"POST /api/v1/persistence/restore": "RESTORE",
+}
+
+// adminAuditMiddleware records admin requests in the admin audit log,
+// with their query and the start of their body as arguments
+func (s *HTTPServer) adminAuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation, ok := adminRoutes[r.Method+" "+canonicalRoute(r.URL.Path)]
+		if s.adminLog == nil || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		args := []string{r.URL.RequestURI()}
+		if r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				s.writeError(w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if len(body) > maxAdminBody {
+				body = body[:maxAdminBody]
+			}
+			if body = bytes.TrimSpace(body); len(body) > 0 {
+				args = append(args, string(body))
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		err := s.adminLog.Record(utils.AdminRecord{
+			RequestID: r.Header.Get("X-Request-ID"),
+			Client:    r.RemoteAddr,
+			Protocol:  "http",
+			Operation: operation,
+			Args:      args,
+			Success:   recorder.status < 400,
+		})
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("admin audit log write failed: %v", err))
+		}
+	})
+}
+
+// requireAdmin restricts a handler to admin clients
+func (s *HTTPServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", adminChallenge)
+			s.writeError(w, http.StatusUnauthorized, "admin credentials required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminChallenge is the WWW-Authenticate header of admin endpoints
+const adminChallenge = `Basic realm="triff admin"`
+
+// isAdmin reports whether a request presents security.password as a bearer
+// token or basic auth password. Without a password configured every client
+// is an admin, as the rest of the API is open too.
+func (s *HTTPServer) isAdmin(r *http.Request) bool {
+	secret := s.db.Config().Security.Password
+	if secret == "" {
+		return true
+	}
+
+	var given string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		given = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// adminKey marks the context of commands from TCP clients that
+// authenticated with AUTH
+type adminKey struct{}
+
+func withAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminKey{}, true)
+}
+
+// isAdmin reports whether a TCP command comes from an admin client: one
+// that authenticated, or any client when there is no password
+func (s *TCPServer) isAdmin(ctx context.Context) bool {
+	if s.db.Config().Security.Password == "" {
+		return true
+	}
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// authenticate answers AUTH <password|api key>, returning the name of the
+// API key the client presented, if it wasn't the password. It runs outside
+// processCommand so secrets stay out of the logs, traces and audit trail.
+func (s *TCPServer) authenticate(line string) (string, string) {
+	args := commandArgs(line)[1:]
+	if len(args) != 1 {
+		return "-ERR wrong number of arguments for 'auth' command", ""
+	}
+	security := s.db.Config().Security
+	if name, ok := lookupAPIKey(security.APIKeys, args[0]); ok {
+		return "+OK", name
+	}
+	if security.Password == "" && len(security.APIKeys) == 0 {
+		return "-ERR AUTH called without any password configured", ""
+	}
+	if security.Password == "" || subtle.ConstantTimeCompare([]byte(args[0]), []byte(security.Password)) != 1 {
+		return "-WRONGPASS invalid password", ""
+	}
+	return "+OK", ""
+}
+
+// handleAdminAudit lists admin audit records after ?since=<seq>, at most
+// ?limit=<n> (100 by default), optionally only ?operation=<name>
+func (s *HTTPServer) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if s.adminLog == nil {
+		s.writeError(w, http.StatusNotFound, "admin audit log is not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		since = n
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	records, err := s.adminLog.Records(since, limit, query.Get("operation"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"records": records,
+		"count":   len(records),
+	})
+}
+
+// handleAdminAuditVerify checks the admin audit log's hash chain
+func (s *HTTPServer) handleAdminAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if s.adminLog == nil {
+		s.writeError(w, http.StatusNotFound, "admin audit log is not enabled")
+		return
+	}
+
+	status, err := s.adminLog.Verify()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, status)
+}
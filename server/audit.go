@@ -0,0 +1,147 @@
+package server
+
+import "strings"
+
+// writeCommands lists the TCP commands that modify data or server state
+// and are therefore recorded in the audit log
+var writeCommands = map[string]bool{
+	"SET":              true,
+	"SETNULL":          true,
+	"FILL":             true,
+	"MSET":             true,
+	"MSETNX":           true,
+	"SWAP":             true,
+	"GETEX":            true,
+	"GETDEL":           true,
+	"CAS":              true,
+	"DEL":              true,
+	"RESTOREKEY":       true,
+	"UNDELETE":         true,
+	"DELPATTERN":       true,
+	"PARTITION":        true,
+	"SCHEDULE":         true,
+	"FLUSHALL":         true,
+	"RESTORE":          true,
+	"EXPIRE":           true,
+	"EXPIREPATTERN":    true,
+	"PERSISTPATTERN":   true,
+	"TAG":              true,
+	"UNTAG":            true,
+	"INVALIDATETAG":    true,
+	"DEPEND":           true,
+	"UNDEPEND":         true,
+	"INCR":             true,
+	"DECR":             true,
+	"APPEND":           true,
+	"HSET":             true,
+	"HDEL":             true,
+	"HINCRBY":          true,
+	"HINCRBYFLOAT":     true,
+	"HSETNX":           true,
+	"SADD":             true,
+	"SREM":             true,
+	"SPOP":             true,
+	"SMOVE":            true,
+	"LPUSH":            true,
+	"RPUSH":            true,
+	"LPOP":             true,
+	"RPOP":             true,
+	"LMPOP":            true,
+	"LINSERT":          true,
+	"LREM":             true,
+	"LSET":             true,
+	"LTRIM":            true,
+	"IDX.CREATE":       true,
+	"IDX.DROP":         true,
+	"AGG.CREATE":       true,
+	"AGG.DROP":         true,
+	"FT.CREATE":        true,
+	"FT.DROP":          true,
+	"LOCK":             true,
+	"LOCKEXTEND":       true,
+	"UNLOCK":           true,
+	"CL.THROTTLE":      true,
+	"ENQUEUE":          true,
+	"DEQUEUE":          true,
+	"ACK":              true,
+	"NACK":             true,
+	"QPURGE":           true,
+	"LBADD":            true,
+	"LBREM":            true,
+	"LBRESET":          true,
+	"LBSCHEDULE":       true,
+	"ZADD":             true,
+	"ZUNIONSTORE":      true,
+	"ZINTERSTORE":      true,
+	"ZPOPMIN":          true,
+	"ZPOPMAX":          true,
+	"ZMPOP":            true,
+	"BZPOPMIN":         true,
+	"ZREMRANGEBYSCORE": true,
+	"ZREMRANGEBYRANK":  true,
+	"ZREMRANGEBYLEX":   true,
+	"CINCR":            true,
+	"BF.RESERVE":       true,
+	"BF.ADD":           true,
+	"BF.MADD":          true,
+	"CMS.INITBYDIM":    true,
+	"CMS.INITBYPROB":   true,
+	"CMS.INCRBY":       true,
+	"CF.RESERVE":       true,
+	"CF.ADD":           true,
+	"CF.ADDNX":         true,
+	"CF.DEL":           true,
+	"PN.INCRBY":        true,
+	"PN.INCR":          true,
+	"PN.DECR":          true,
+	"OR.ADD":           true,
+	"OR.REM":           true,
+	"CRDT.MERGE":       true,
+	"RL.SLIDING":       true,
+}
+
+// isWriteCommand reports whether a parsed command line should be audited
+func isWriteCommand(parts []string) bool {
+	if len(parts) == 0 {
+		return false
+	}
+	command := strings.ToUpper(parts[0])
+	switch command {
+	case "CONFIG":
+		return len(parts) > 1 && strings.ToUpper(parts[1]) != "GET"
+	case "PARTITION", "SCHEDULE":
+		return len(parts) > 1 && strings.ToUpper(parts[1]) != "LIST"
+	case "LEASE":
+		return len(parts) > 1 && strings.ToUpper(parts[1]) != "GET"
+	}
+	return writeCommands[command]
+}
+
+// adminOperation names the administrative operation a command performs, if
+// any. These are also recorded in the hash-chained admin audit log.
+func adminOperation(parts []string) (string, bool) {
+	if len(parts) == 0 {
+		return "", false
+	}
+	command := strings.ToUpper(parts[0])
+	switch command {
+	case "FLUSHALL", "DELPATTERN":
+		return command, true
+	case "CONFIG":
+		if len(parts) > 1 {
+			if sub := strings.ToUpper(parts[1]); sub == "SET" || sub == "RELOAD" {
+				return "CONFIG " + sub, true
+			}
+		}
+	case "RESTORE":
+		if len(parts) > 1 && strings.ToUpper(parts[1]) == "TO" {
+			return command, true
+		}
+	case "SCHEDULE":
+		// Scheduled commands run with admin rights
+		if len(parts) > 1 && strings.ToUpper(parts[1]) != "LIST" {
+			return "SCHEDULE " + strings.ToUpper(parts[1]), true
+		}
+	}
+	return "", false
+}
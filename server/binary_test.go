@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/nitrix4ly/triff/testutil"
+)
+
+// binaryValues are keys and values that break protocols which aren't
+// binary-safe
+var binaryValues = []string{
+	"nul\x00inside",
+	"crlf\r\nSET injected 1",
+	"\r\n",
+	"\x00",
+	"\xff\xfe\x80 not utf-8",
+	`"quoted" \\ 'single' \x41`,
+	"trailing space ",
+}
+
+func TestTCPBinaryRoundTrip(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true})
+	c := srv.Client()
+	for i, value := range binaryValues {
+		key := fmt.Sprintf("%s:%d", value, i)
+		if _, err := c.Do("SET", key, value); err != nil {
+			t.Fatalf("SET %q: %v", key, err)
+		}
+		got, err := c.Do("GET", key)
+		if err != nil || got != value {
+			t.Errorf("GET %q = %q (%v), want %q", key, got, err, value)
+		}
+	}
+	if n, err := c.Do("EXISTS", "injected"); err != nil || n != int64(0) {
+		t.Errorf("EXISTS injected = %v (%v), a CRLF in a value ran a command", n, err)
+	}
+}
+
+// TestTCPEscapedLine sends an escaped command line by hand and checks the
+// bulk reply carries the raw bytes with their length
+func TestTCPEscapedLine(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true})
+	conn, err := net.Dial("tcp", srv.TCPAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, `SET "k\x00\r\n" "a\x00b\r\nc"`+"\r\n")
+	if line, err := r.ReadString('\n'); err != nil || line != "+OK\r\n" {
+		t.Fatalf("SET replied %q (%v)", line, err)
+	}
+	fmt.Fprint(conn, `GET "k\x00\r\n"`+"\r\n")
+	want := "$6\r\na\x00b\r\nc\r\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil || string(got) != want {
+		t.Fatalf("GET replied %q (%v), want %q", got, err, want)
+	}
+}
+
+func TestHTTPBase64RoundTrip(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true, HTTP: true})
+	c := srv.Client()
+	for i, value := range binaryValues {
+		key := fmt.Sprintf("bin:%d:%s", i, value)
+		body, _ := json.Marshal(map[string]string{
+			"value":    base64.StdEncoding.EncodeToString([]byte(value)),
+			"encoding": "base64",
+		})
+		resp, err := http.Post(srv.URL("/api/v1/keys/"+url.PathEscape(key)), "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST %q: status %d", key, resp.StatusCode)
+		}
+
+		// What HTTP stored is what TCP reads
+		if got, err := c.Do("GET", key); err != nil || got != value {
+			t.Errorf("GET %q over TCP = %q (%v), want %q", key, got, err, value)
+		}
+
+		resp, err = http.Get(srv.URL("/api/v1/keys/" + url.PathEscape(key) + "?encoding=base64"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Key      string `json:"key"`
+			Value    string `json:"value"`
+			Encoding string `json:"encoding"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %q: %v", key, err)
+		}
+		gotKey, _ := base64.StdEncoding.DecodeString(got.Key)
+		gotValue, _ := base64.StdEncoding.DecodeString(got.Value)
+		if got.Encoding != "base64" || string(gotKey) != key || string(gotValue) != value {
+			t.Errorf("GET %q = key %q, value %q, encoding %q", key, gotKey, gotValue, got.Encoding)
+		}
+	}
+}
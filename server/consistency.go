@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// sessionHeader carries session tokens: writes answer with the token of
+// the write, and requests sending one back wait until this server
+// reflects that write
+const sessionHeader = "X-Triff-Session"
+
+// A write sending waitReplicasHeader is answered once that many replicas
+// have acknowledged it, or after waitTimeoutHeader milliseconds
+// (server.session_wait by default). replicasHeader reports how many did.
+const (
+	waitReplicasHeader = "X-Triff-Wait-Replicas"
+	waitTimeoutHeader  = "X-Triff-Wait-Timeout"
+	replicasHeader     = "X-Triff-Replicas"
+)
+
+// sessionMiddleware gives HTTP clients read-your-writes consistency
+// across a primary and its replicas. A request holding a token from the
+// primary waits up to server.session_wait for a replica to apply that
+// write, and gets 503 if it doesn't, so the client can retry or go to the
+// primary; a token from an unknown epoch gets 412.
+func (s *HTTPServer) sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get(sessionHeader); header != "" && r.Method != "OPTIONS" {
+			token, err := core.ParseSessionToken(header)
+			if err != nil {
+				s.writeErrorCode(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(s.db.Config().Server.SessionWait)*time.Millisecond)
+			err = s.db.WaitSession(ctx, token)
+			cancel()
+			if err == context.DeadlineExceeded {
+				w.Header().Set("Retry-After", "1")
+				s.writeClassified(w, err, http.StatusServiceUnavailable, "replica has not caught up with the session")
+				return
+			}
+			if err != nil {
+				s.writeClassified(w, err, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+		}
+
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &sessionWriter{ResponseWriter: w, db: s.db, ctx: r.Context()}
+		if header := r.Header.Get(waitReplicasHeader); header != "" {
+			n, err := strconv.Atoi(header)
+			v := s.validator()
+			v.check(err == nil && n >= 0, waitReplicasHeader, codeBadRequest, "must be a number, 0 or more")
+			timeout := s.db.Config().Server.SessionWait
+			if header := r.Header.Get(waitTimeoutHeader); header != "" {
+				ms, err := strconv.ParseInt(header, 10, 64)
+				v.check(err == nil && ms > 0, waitTimeoutHeader, codeBadRequest, "must be a positive number of milliseconds")
+				timeout = ms
+			}
+			if v.failed(w) {
+				return
+			}
+			sw.waitReplicas, sw.waitTimeout = n, time.Duration(timeout)*time.Millisecond
+		}
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// sessionWriter adds the session token to a write's response. The token
+// is taken as the handler starts its reply, after the write committed,
+// and the reply waits there for the replicas the request asked for.
+type sessionWriter struct {
+	http.ResponseWriter
+	db      *core.Database
+	written bool
+
+	ctx          context.Context
+	waitReplicas int // 0 unless the request sent waitReplicasHeader
+	waitTimeout  time.Duration
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		if status < 400 {
+			token := w.db.SessionToken()
+			w.Header().Set(sessionHeader, token.String())
+			if w.waitReplicas > 0 {
+				ctx, cancel := context.WithTimeout(w.ctx, w.waitTimeout)
+				acked := w.db.WaitReplicas(ctx, token.Offset, w.waitReplicas)
+				cancel()
+				w.Header().Set(replicasHeader, strconv.Itoa(acked))
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// handleReplication reports this server's session token and, on a
+// replica, how far it has applied its primary's changes
+func (s *HTTPServer) handleReplication(w http.ResponseWriter, r *http.Request) {
+	token := s.db.SessionToken()
+	response := map[string]interface{}{
+		"epoch":  token.Epoch,
+		"offset": token.Offset,
+	}
+	if acks := s.db.ReplicaAcks(); len(acks) > 0 {
+		response["replicas"] = acks
+	}
+	if replica := s.db.ReplicaOffset(); replica.Epoch != "" {
+		response["replica"] = map[string]interface{}{
+			"primary_epoch": replica.Epoch,
+			"offset":        replica.Offset,
+			"lag":           s.db.ReplicaLag(),
+		}
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleReplicaOffset is called by whatever applies a primary's changes to
+// this server, with the primary's epoch and the Seq of the last change
+// applied, and optionally the primary's latest Seq so readiness can tell
+// how far behind this server is
+func (s *HTTPServer) handleReplicaOffset(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Epoch         string `json:"epoch"`
+		Offset        uint64 `json:"offset"`
+		PrimaryOffset uint64 `json:"primary_offset"` // The primary's latest Seq, for readiness
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Epoch != "", "epoch", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+	s.db.SetReplicaOffset(payload.Epoch, payload.Offset)
+	s.db.SetPrimaryOffset(payload.Epoch, payload.PrimaryOffset)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"primary_epoch": payload.Epoch,
+		"offset":        s.db.ReplicaOffset().Offset,
+		"lag":           s.db.ReplicaLag(),
+	})
+}
+
+// handleReplicaAck is called on a primary by whatever applies its changes
+// to a replica, with the replica's ID, this server's epoch and the Seq of
+// the last change the replica applied, so WAIT and waitReplicasHeader can
+// count the replica
+func (s *HTTPServer) handleReplicaAck(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Replica string `json:"replica"`
+		Epoch   string `json:"epoch"`
+		Offset  uint64 `json:"offset"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Replica != "", "replica", codeFieldRequired, "is required")
+	v.check(payload.Epoch != "", "epoch", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+	if err := s.db.AckReplica(payload.Replica, payload.Epoch, payload.Offset); err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"replica": payload.Replica,
+		"offset":  s.db.ReplicaAcks()[payload.Replica],
+	})
+}
@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/testutil"
+)
+
+// TestWaitCountsReplicaAcks checks that WAIT over TCP and the wait header
+// over REST count the replicas that have acknowledged a write
+func TestWaitCountsReplicaAcks(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true, HTTP: true})
+	c := srv.Client()
+	if _, err := c.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Do("WAIT", "1", "20"); err != nil || n != int64(0) {
+		t.Fatalf("WAIT without acknowledgements = %v (%v), want 0", n, err)
+	}
+
+	ack := func(replica string) {
+		body := fmt.Sprintf(`{"replica": %q, "epoch": %q, "offset": %d}`,
+			replica, srv.DB.Epoch(), srv.DB.SessionToken().Offset)
+		if status, data := do(t, "PUT", srv.URL("/api/v1/replication/ack"), body); status != http.StatusOK {
+			t.Errorf("acknowledging returned %d %s", status, data)
+		}
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ack("r1")
+	}()
+	if n, err := c.Do("WAIT", "1", "2000"); err != nil || n != int64(1) {
+		t.Fatalf("WAIT = %v (%v), want 1", n, err)
+	}
+	if status, _ := do(t, "PUT", srv.URL("/api/v1/replication/ack"), `{"replica": "r1", "epoch": "old", "offset": 1}`); status != http.StatusPreconditionFailed {
+		t.Errorf("acknowledging another epoch returned %d, want 412", status)
+	}
+
+	put := func(wait, timeout string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("PUT", srv.URL("/api/v1/string/k"), strings.NewReader(`{"value": "w"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Triff-Wait-Replicas", wait)
+		req.Header.Set("X-Triff-Wait-Timeout", timeout)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := put("1", "20"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Triff-Replicas") != "0" {
+		t.Fatalf("a write no replica acknowledged returned %d with %q replicas, want 0",
+			resp.StatusCode, resp.Header.Get("X-Triff-Replicas"))
+	}
+	ack("r1")
+	ack("r2")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ack("r1")
+	}()
+	if resp := put("1", "2000"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Triff-Replicas") != "1" {
+		t.Fatalf("an acknowledged write returned %d with %q replicas, want 1",
+			resp.StatusCode, resp.Header.Get("X-Triff-Replicas"))
+	}
+	if resp := put("-1", "20"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("a negative replica count returned %d, want 400", resp.StatusCode)
+	}
+}
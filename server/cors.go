@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// corsMiddleware applies the server.cors policy of a request's route.
+// Requests from origins the policy doesn't allow are still served, just
+// without CORS headers, so browsers keep their responses from scripts;
+// preflights from them are refused outright.
+func (s *HTTPServer) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := corsPolicy(s.db.Config().Server.CORS, canonicalRoute(r.URL.Path))
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if !allowsOrigin(policy.AllowedOrigins, origin) {
+			if preflight {
+				s.writeErrorCode(w, http.StatusForbidden, codeForbidden, "origin "+origin+" is not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		credentials := policy.AllowCredentials != nil && *policy.AllowCredentials
+		if containsFold(policy.AllowedOrigins, "*") && !credentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(policy.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !containsFold(policy.AllowedMethods, method) {
+			s.writeErrorCode(w, http.StatusForbidden, codeForbidden, "method "+method+" is not allowed for cross-origin requests")
+			return
+		}
+		requested := r.Header.Get("Access-Control-Request-Headers")
+		if containsFold(policy.AllowedHeaders, "*") {
+			// A literal * isn't honored for credentialed requests, so the
+			// requested headers are echoed instead
+			if requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+		} else {
+			for _, name := range strings.Split(requested, ",") {
+				if name = strings.TrimSpace(name); name != "" && !containsFold(policy.AllowedHeaders, name) {
+					s.writeErrorCode(w, http.StatusForbidden, codeForbidden, "header "+name+" is not allowed for cross-origin requests")
+					return
+				}
+			}
+			if len(policy.AllowedHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			}
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+		if policy.MaxAge != nil && *policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.FormatInt(*policy.MaxAge, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// corsPolicy is the CORS policy of a path: the global policy with the
+// fields set by the route of the longest matching prefix replaced
+func corsPolicy(cors core.CORSConfig, path string) core.CORSPolicy {
+	policy := cors.CORSPolicy
+	var route *core.CORSRoute
+	for i := range cors.Routes {
+		if strings.HasPrefix(path, cors.Routes[i].Prefix) && (route == nil || len(cors.Routes[i].Prefix) > len(route.Prefix)) {
+			route = &cors.Routes[i]
+		}
+	}
+	if route == nil {
+		return policy
+	}
+	if route.AllowedOrigins != nil {
+		policy.AllowedOrigins = route.AllowedOrigins
+	}
+	if route.AllowedMethods != nil {
+		policy.AllowedMethods = route.AllowedMethods
+	}
+	if route.AllowedHeaders != nil {
+		policy.AllowedHeaders = route.AllowedHeaders
+	}
+	if route.ExposedHeaders != nil {
+		policy.ExposedHeaders = route.ExposedHeaders
+	}
+	if route.AllowCredentials != nil {
+		policy.AllowCredentials = route.AllowCredentials
+	}
+	if route.MaxAge != nil {
+		policy.MaxAge = route.MaxAge
+	}
+	return policy
+}
+
+// allowsOrigin matches an Origin header against allowed origins: "*", an
+// exact origin, or a subdomain pattern like https://*.example.com, which
+// doesn't match example.com itself
+func allowsOrigin(allowed []string, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, pattern := range allowed {
+		if pattern == "*" || strings.EqualFold(strings.TrimSuffix(pattern, "/"), origin) {
+			return true
+		}
+		p, err := url.Parse(pattern)
+		if err != nil || !strings.HasPrefix(p.Host, "*.") || !strings.EqualFold(p.Scheme, u.Scheme) {
+			continue
+		}
+		if suffix := p.Host[1:]; len(u.Host) > len(suffix) && strings.EqualFold(u.Host[len(u.Host)-len(suffix):], suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
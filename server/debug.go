@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// Counters published under /debug/vars. expvar names are process-global,
+// so they are registered once and report on the most recently started
+// debug server's database.
+var (
+	publishOnce   sync.Once
+	debugDB       atomic.Pointer[core.Database]
+	commandCounts = new(expvar.Map)
+	commandErrors = new(expvar.Map)
+
+	commandTimeoutCounts = new(expvar.Map)
+	commandRejections    = new(expvar.Map)
+)
+
+// DebugServer serves net/http/pprof profiles and expvar counters on a
+// separate listener, so it can stay off the public API port
+type DebugServer struct {
+	db       *core.Database
+	config   core.DebugConfig
+	password string
+	server   *http.Server
+	logger   *utils.Logger
+}
+
+// NewDebugServer creates a debug listener from the database's debug config
+func NewDebugServer(db *core.Database, logger *utils.Logger) *DebugServer {
+	config := db.Config()
+	return &DebugServer{
+		db:       db,
+		config:   config.Debug,
+		password: config.Security.Password,
+		logger:   logger,
+	}
+}
+
+// Start listens on the configured debug address. It returns nil without
+// listening when the debug listener is disabled.
+func (d *DebugServer) Start() error {
+	if !d.config.Enabled {
+		return nil
+	}
+
+	runtime.SetMutexProfileFraction(d.config.MutexProfileFraction)
+	runtime.SetBlockProfileRate(d.config.BlockProfileRate)
+	debugDB.Store(d.db)
+	publishVars()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	d.server = &http.Server{
+		Addr:    d.config.Address,
+		Handler: d.authMiddleware(mux),
+	}
+	d.logger.Info(fmt.Sprintf("Debug listener on %s", d.config.Address))
+	return d.server.ListenAndServe()
+}
+
+// Stop shuts the debug listener down
+func (d *DebugServer) Stop() error {
+	if d.server != nil {
+		return d.server.Close()
+	}
+	return nil
+}
+
+// secret is the credential clients must present, or "" when the listener
+// is open (validation only allows that on loopback addresses)
+func (d *DebugServer) secret() string {
+	if d.config.Token != "" {
+		return d.config.Token
+	}
+	return d.password
+}
+
+// authMiddleware accepts the secret as a bearer token or as the password
+// of HTTP basic auth, which is what `go tool pprof` and browsers can send
+func (d *DebugServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := d.secret()
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var given string
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		} else if _, password, ok := r.BasicAuth(); ok {
+			given = password
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="triff debug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publishVars registers triff's expvar counters next to the runtime's
+// cmdline and memstats
+func publishVars() {
+	publishOnce.Do(func() {
+		expvar.Publish("triff", expvar.Func(func() interface{} {
+			if db := debugDB.Load(); db != nil {
+				return db.Info()
+			}
+			return nil
+		}))
+		expvar.Publish("triff_memory", expvar.Func(func() interface{} {
+			if db := debugDB.Load(); db != nil {
+				return db.MemoryStats()
+			}
+			return nil
+		}))
+		expvar.Publish("triff_write_queues", expvar.Func(func() interface{} {
+			if db := debugDB.Load(); db != nil {
+				return db.WriteQueues()
+			}
+			return nil
+		}))
+		expvar.Publish("triff_commands", commandCounts)
+		expvar.Publish("triff_command_errors", commandErrors)
+		expvar.Publish("triff_command_timeouts", commandTimeoutCounts)
+		expvar.Publish("triff_command_rejections", commandRejections)
+	})
+}
+
+// countCommand records a TCP command and whether it failed. Unknown
+// commands share one counter so clients cannot grow the map.
+func countCommand(command, response string) {
+	if strings.HasPrefix(response, "-ERR unknown command") {
+		command = "unknown"
+	}
+	commandCounts.Add(command, 1)
+	if strings.HasPrefix(response, "-") {
+		commandErrors.Add(command, 1)
+	}
+}
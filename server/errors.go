@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// protocolError formats a command error as a RESP error reply. Wrong type
+// errors use Redis's WRONGTYPE prefix, throttled writes TRYAGAIN, missing
+// authentication NOAUTH, refusals by the server mode READONLY and
+// MAINTENANCE, broken limits LIMIT, used up quotas QUOTA and commands
+// sent while the snapshot loads LOADING, so clients can tell them apart.
+func protocolError(err error) string {
+	switch {
+	case errors.Is(err, errs.ErrWrongType):
+		return "-WRONGTYPE " + err.Error()
+	case errors.Is(err, errs.ErrBackpressure):
+		return "-TRYAGAIN " + err.Error()
+	case errors.Is(err, errs.ErrNoAuth):
+		return "-NOAUTH " + err.Error()
+	case errors.Is(err, errs.ErrReadOnly):
+		return "-READONLY " + err.Error()
+	case errors.Is(err, errs.ErrMaintenance):
+		return "-MAINTENANCE " + err.Error()
+	case errors.Is(err, errs.ErrLimit):
+		return "-LIMIT " + err.Error()
+	case errors.Is(err, errs.ErrQuota):
+		return "-QUOTA " + err.Error()
+	case errors.Is(err, errs.ErrLoading):
+		return "-LOADING " + err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "-ERR command timed out: " + err.Error()
+	case errors.Is(err, context.Canceled):
+		return "-ERR command canceled: " + err.Error()
+	}
+	return "-ERR " + err.Error()
+}
+
+// errorStatus maps a command error to an HTTP status, falling back to
+// status for errors outside the errs classes
+func errorStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, errs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errs.ErrWrongType):
+		return http.StatusConflict
+	case errors.Is(err, errs.ErrNotInteger):
+		return http.StatusBadRequest
+	case errors.Is(err, errs.ErrNoAuth):
+		return http.StatusUnauthorized
+	case errors.Is(err, errs.ErrDisabled), errors.Is(err, errs.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, errs.ErrLimit):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errs.ErrQuota):
+		return http.StatusTooManyRequests
+	case errors.Is(err, core.ErrVersionMismatch), errors.Is(err, core.ErrUnknownEpoch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errs.ErrBackpressure), errors.Is(err, errs.ErrMaintenance), errors.Is(err, errs.ErrLoading):
+		return http.StatusServiceUnavailable
+	}
+	return status
+}
+
+// persistenceStatus maps snapshot errors: missing persistence is a server
+// setup problem rather than a bad request
+func persistenceStatus(err error) int {
+	if errors.Is(err, core.ErrNoPersistence) || errors.Is(err, core.ErrNoSnapshots) {
+		return http.StatusNotImplemented
+	}
+	return errorStatus(err, http.StatusInternalServerError)
+}
+
+// writeFailure replies with an unsuccessful command response, using the
+// status of its error class or status otherwise
+func (s *HTTPServer) writeFailure(w http.ResponseWriter, response *core.Response, status int) {
+	err := response.Failure()
+	s.writeClassified(w, err, status, err.Error())
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The event loop frontend waits on every connection with one epoll set
+// instead of parking a goroutine and a read buffer on each. Connections
+// are registered one-shot: when one becomes readable the loop reads what
+// it has into a buffer shared by all connections, and hands any complete
+// lines to a goroutine that runs them and writes the replies. Only then
+// is the connection armed again, so its commands run in order and an idle
+// connection holds nothing but its socket and, at most, a partial line.
+// While the goroutine runs, the connection is only watched for the client
+// hanging up, which cancels the commands like it does on the goroutine
+// frontend.
+
+const (
+	// maxLoopRead is how much the loop reads from one connection before
+	// running its commands, so a client pipelining a flood of them can't
+	// hold the loop; what is left is read once the connection is rearmed
+	maxLoopRead = 1 << 20
+
+	// flushReplies is how many bytes of replies a batch buffers before it
+	// writes them
+	flushReplies = 64 << 10
+
+	loopEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+	// hangupEvents are watched while a connection's commands run
+	hangupEvents = syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+)
+
+type eventLoop struct {
+	s       *TCPServer
+	epfd    int
+	lfd     int
+	wake    [2]int // Pipe written to by stop to get the loop out of epoll_wait
+	buf     []byte // Read buffer, only used by the loop goroutine
+	stopped atomic.Bool
+
+	mu    sync.Mutex
+	conns map[int]*loopConn
+}
+
+type loopConn struct {
+	fd     int
+	sess   *session
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending []byte // Partial line carried over to the next read, nil if none
+	busy    bool   // Handed to a goroutine running its commands
+}
+
+// startEventLoop listens on port and serves connections from the event
+// loop until stopEventLoop is called
+func (s *TCPServer) startEventLoop() error {
+	lfd, err := listenSocket(s.port)
+	if err != nil {
+		return fmt.Errorf("failed to start TCP server: %v", err)
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(lfd)
+		return fmt.Errorf("failed to start TCP server: epoll: %v", err)
+	}
+	loop := &eventLoop{s: s, epfd: epfd, lfd: lfd, buf: make([]byte, 64<<10), conns: make(map[int]*loopConn)}
+	if err := syscall.Pipe2(loop.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		loop.closeFds()
+		return fmt.Errorf("failed to start TCP server: %v", err)
+	}
+	for _, fd := range []int{lfd, loop.wake[0]} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			loop.closeFds()
+			return fmt.Errorf("failed to start TCP server: epoll: %v", err)
+		}
+	}
+
+	s.loop.Store(loop)
+	s.logger.Info(fmt.Sprintf("TCP server listening on port %d (event loop)", s.port))
+	return loop.run()
+}
+
+// stopEventLoop stops accepting connections and closes the idle ones;
+// connections running commands are closed once they finish
+func (s *TCPServer) stopEventLoop() error {
+	loop := s.loop.Load()
+	if loop == nil || !loop.stopped.CompareAndSwap(false, true) {
+		return nil
+	}
+	_, err := syscall.Write(loop.wake[1], []byte{0})
+	return err
+}
+
+// listenSocket opens a non-blocking listening socket on port, accepting
+// IPv4 and IPv6 like net.Listen does for ":port"
+func listenSocket(port int) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	var addr syscall.Sockaddr = &syscall.SockaddrInet6{Port: port}
+	if err != nil {
+		fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+		addr = &syscall.SockaddrInet4{Port: port}
+		if err != nil {
+			return -1, err
+		}
+	} else {
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0)
+	}
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func (l *eventLoop) run() error {
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			l.shutdown()
+			return fmt.Errorf("TCP event loop: %v", err)
+		}
+		if l.stopped.Load() {
+			l.shutdown()
+			return nil
+		}
+		for _, event := range events[:n] {
+			switch fd := int(event.Fd); fd {
+			case l.lfd:
+				l.accept()
+			case l.wake[0]:
+			default:
+				l.mu.Lock()
+				c := l.conns[fd]
+				l.mu.Unlock()
+				if c != nil {
+					l.event(c, event.Events)
+				}
+			}
+		}
+	}
+}
+
+// accept registers every pending connection
+func (l *eventLoop) accept() {
+	for {
+		fd, sa, err := syscall.Accept4(l.lfd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err == syscall.EAGAIN || err == syscall.EINTR || err == syscall.ECONNABORTED {
+			if err == syscall.EAGAIN {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			l.s.logger.Error(fmt.Sprintf("Error accepting connection: %v", err))
+			// Out of descriptors: the listener stays readable, so back
+			// off rather than spin
+			time.Sleep(10 * time.Millisecond)
+			return
+		}
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		c := &loopConn{fd: fd, cancel: cancel, sess: &session{ctx: ctx, commandCtx: ctx, client: sockaddrString(sa)}}
+		l.mu.Lock()
+		l.conns[fd] = c
+		l.mu.Unlock()
+		event := syscall.EpollEvent{Events: loopEvents, Fd: int32(fd)}
+		if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			l.close(c)
+			continue
+		}
+		l.s.logger.Info(fmt.Sprintf("New client connected: %s", c.sess.client))
+	}
+}
+
+// event handles an event on a connection: input to read, or the client
+// hanging up while its commands run, which cancels them
+func (l *eventLoop) event(c *loopConn, events uint32) {
+	c.mu.Lock()
+	busy := c.busy
+	c.mu.Unlock()
+	if !busy {
+		l.readable(c)
+		return
+	}
+	if events&(syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+		c.cancel()
+	}
+}
+
+// readable reads what a connection has sent and runs its complete lines
+func (l *eventLoop) readable(c *loopConn) {
+	c.mu.Lock()
+	var lines []string
+	eof := false
+	for read := 0; read < maxLoopRead; {
+		n, err := syscall.Read(c.fd, l.buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil || n == 0 {
+			eof = true
+			break
+		}
+		read += n
+		lines = c.split(l.buf[:n], lines)
+		if len(c.pending) > maxCommandLine {
+			l.s.logger.Error(fmt.Sprintf("Connection error: %s sent a line over %d bytes", c.sess.client, maxCommandLine))
+			eof, lines = true, nil
+			break
+		}
+	}
+	if len(lines) == 0 {
+		c.mu.Unlock()
+		if eof {
+			l.close(c)
+		} else {
+			l.rearm(c)
+		}
+		return
+	}
+	c.busy = true
+	c.mu.Unlock()
+	if !eof {
+		// serve rearms the connection for input once the lines have run
+		event := syscall.EpollEvent{Events: hangupEvents, Fd: int32(c.fd)}
+		syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, c.fd, &event)
+	}
+	go l.serve(c, lines, eof)
+}
+
+// split appends the complete lines in data to lines, carrying a trailing
+// partial line over in pending
+func (c *loopConn) split(data []byte, lines []string) []string {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if c.pending != nil {
+			lines = append(lines, string(append(c.pending, data[:i]...)))
+			c.pending = nil
+		} else {
+			lines = append(lines, string(data[:i]))
+		}
+		data = data[i+1:]
+	}
+	if len(data) > 0 {
+		c.pending = append(c.pending, data...)
+	}
+	return lines
+}
+
+// serve runs a batch of lines from one connection and writes their
+// replies, then gives the connection back to the loop
+func (l *eventLoop) serve(c *loopConn, lines []string, eof bool) {
+	out := newReply()
+	var err error
+	for _, line := range lines {
+		if err = c.sess.ctx.Err(); err != nil {
+			// The client hung up, so the rest of the batch isn't run
+			break
+		}
+		response, ok := l.s.serveLine(c.sess, line)
+		if !ok {
+			continue
+		}
+		appendReply(out, response)
+		if out.Len() >= flushReplies {
+			if err = writeAll(c.fd, out.Bytes()); err != nil {
+				break
+			}
+			out.Reset()
+		}
+	}
+	if err == nil && out.Len() > 0 {
+		err = writeAll(c.fd, out.Bytes())
+	}
+	releaseReply(out)
+
+	c.mu.Lock()
+	c.busy = false
+	c.mu.Unlock()
+	if err != nil || eof || l.stopped.Load() {
+		l.close(c)
+		return
+	}
+	l.rearm(c)
+}
+
+func (l *eventLoop) rearm(c *loopConn) {
+	event := syscall.EpollEvent{Events: loopEvents, Fd: int32(c.fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, c.fd, &event); err != nil {
+		l.close(c)
+	}
+}
+
+func (l *eventLoop) close(c *loopConn) {
+	l.mu.Lock()
+	if l.conns[c.fd] != c {
+		l.mu.Unlock()
+		return
+	}
+	delete(l.conns, c.fd)
+	l.mu.Unlock()
+
+	c.cancel()
+	syscall.Close(c.fd)
+	l.s.logger.Info(fmt.Sprintf("Client disconnected: %s", c.sess.client))
+}
+
+// shutdown closes the listener and the idle connections. Busy ones see
+// stopped when their batch is done and close themselves.
+func (l *eventLoop) shutdown() {
+	l.mu.Lock()
+	var idle []*loopConn
+	for _, c := range l.conns {
+		c.mu.Lock()
+		if !c.busy {
+			idle = append(idle, c)
+		}
+		c.mu.Unlock()
+	}
+	l.mu.Unlock()
+	for _, c := range idle {
+		l.close(c)
+	}
+	l.closeFds()
+}
+
+func (l *eventLoop) closeFds() {
+	syscall.Close(l.lfd)
+	if l.wake[0] != l.wake[1] {
+		syscall.Close(l.wake[0])
+		syscall.Close(l.wake[1])
+	}
+	// The epoll set outlives the loop for busy connections rearming on
+	// it; they fail once it is closed and close themselves
+	syscall.Close(l.epfd)
+}
+
+// writeAll writes b to a non-blocking socket, waiting for it to drain
+// whenever its send buffer is full
+func writeAll(fd int, b []byte) error {
+	for len(b) > 0 {
+		n, err := syscall.Write(fd, b)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			if err := waitWritable(fd); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// pollFd is struct pollfd
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollOut = 0x4
+
+func waitWritable(fd int) error {
+	pfd := pollFd{fd: int32(fd), events: pollOut}
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, 0, 0, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		}
+		return errors.New(errno.Error())
+	}
+}
+
+func sockaddrString(sa syscall.Sockaddr) string {
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return (&net.TCPAddr{IP: addr.Addr[:], Port: addr.Port}).String()
+	case *syscall.SockaddrInet6:
+		return (&net.TCPAddr{IP: addr.Addr[:], Port: addr.Port}).String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// TestEventLoopHangupCancelsCommand disconnects while BZPOPMIN blocks on
+// the event loop frontend and checks the command gives up instead of
+// popping what is pushed later
+func TestEventLoopHangupCancelsCommand(t *testing.T) {
+	config := utils.DefaultConfig()
+	config.Persistence.Enabled = false
+	config.Server.Frontend = "eventloop"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	s := NewTCPServer(core.NewDatabase(config), port, utils.NewLogger("error"))
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+	t.Cleanup(func() {
+		s.Stop()
+		<-done
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	var conn net.Conn
+	waitFor(t, "the event loop to accept connections", func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+	fmt.Fprint(conn, "BZPOPMIN zs 10\r\n")
+	waitFor(t, "BZPOPMIN to block", func() bool { return loopConns(s, true) == 1 })
+	conn.Close()
+	waitFor(t, "the connection to close", func() bool { return loopConns(s, false) == 0 })
+
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	r := bufio.NewReader(other)
+	fmt.Fprint(other, "ZADD zs 1 m\r\nZPOPMIN zs\r\n")
+	for _, want := range []string{":1\r\n", "*2\r\n", "$1\r\n", "m\r\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("replied %q (%v), want %q", line, err, want)
+		}
+	}
+}
+
+// loopConns counts the event loop's connections, only the busy ones if
+// busy is set
+func loopConns(s *TCPServer, busy bool) int {
+	loop := s.loop.Load()
+	if loop == nil {
+		return -1
+	}
+	loop.mu.Lock()
+	defer loop.mu.Unlock()
+	n := 0
+	for _, c := range loop.conns {
+		c.mu.Lock()
+		if c.busy || !busy {
+			n++
+		}
+		c.mu.Unlock()
+	}
+	return n
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !ok(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
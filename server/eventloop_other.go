@@ -0,0 +1,16 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// eventLoop is the epoll frontend, only built on Linux
+type eventLoop struct{}
+
+func (s *TCPServer) startEventLoop() error {
+	return errors.New("failed to start TCP server: the eventloop frontend needs Linux")
+}
+
+func (s *TCPServer) stopEventLoop() error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// flushGuard applies security.flush to requests to flush the database.
+// Confirmation tokens are single use and only valid on the server that
+// handed them out.
+type flushGuard struct {
+	tokens map[string]time.Time // Token to expiry
+	mu     sync.Mutex
+}
+
+func newFlushGuard() *flushGuard {
+	return &flushGuard{tokens: make(map[string]time.Time)}
+}
+
+// check decides whether a flush may go ahead. admin tells whether the
+// client authenticated with security.password and token is the
+// confirmation token it sent, if any. When the flush still has to be
+// confirmed, check returns a new token to repeat it with.
+func (g *flushGuard) check(config core.FlushConfig, admin bool, token string) (string, error) {
+	if config.Disabled {
+		return "", errs.Newf(errs.ErrDisabled, "flushing the database is disabled by security.flush")
+	}
+	if config.RequireAdmin && !admin {
+		return "", errs.Newf(errs.ErrNoAuth, "flushing the database requires authentication")
+	}
+	if !config.Confirm {
+		return "", nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for issued, expiry := range g.tokens {
+		if now.After(expiry) {
+			delete(g.tokens, issued)
+		}
+	}
+	if token != "" {
+		if _, ok := g.tokens[token]; !ok {
+			return "", fmt.Errorf("invalid or expired flush confirmation token")
+		}
+		delete(g.tokens, token)
+		return "", nil
+	}
+
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(raw)
+	g.tokens[token] = now.Add(flushConfirmTTL(config))
+	return token, nil
+}
+
+// flushConfirmTTL is how long a confirmation token stays valid, 30 seconds
+// for configs that leave it unset
+func flushConfirmTTL(config core.FlushConfig) time.Duration {
+	if config.ConfirmTTL <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(config.ConfirmTTL) * time.Second
+}
@@ -16,12 +16,12 @@ func NewHandler(db *core.Database) *Handler {
 
 func (h *Handler) GetHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
-	value, err := h.DB.Get(key)
-	if err != nil {
+	value, exists := h.DB.Get(key)
+	if !exists {
 		http.Error(w, "Key not found", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]string{"value": value})
+	json.NewEncoder(w).Encode(map[string]interface{}{"value": value.Data})
 }
 
 func (h *Handler) SetHandler(w http.ResponseWriter, r *http.Request) {
@@ -32,7 +32,7 @@ func (h *Handler) SetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	key := body["key"]
 	value := body["value"]
-	err := h.DB.Set(key, value)
+	err := h.DB.Set(key, &core.TriffValue{Type: core.STRING, Data: value})
 	if err != nil {
 		http.Error(w, "Failed to set key", http.StatusInternalServerError)
 		return
@@ -42,8 +42,7 @@ func (h *Handler) SetHandler(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
-	err := h.DB.Delete(key)
-	if err != nil {
+	if !h.DB.Delete(key) {
 		http.Error(w, "Key not found", http.StatusNotFound)
 		return
 	}
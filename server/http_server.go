@@ -1,16 +1,29 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"github.com/nitrix4ly/triff/commands"
 	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+	"github.com/nitrix4ly/triff/graphql"
+	"github.com/nitrix4ly/triff/offline"
+	"github.com/nitrix4ly/triff/proxy"
+	"github.com/nitrix4ly/triff/tracing"
 	"github.com/nitrix4ly/triff/utils"
+	"github.com/nitrix4ly/triff/webhook"
 )
 
 // HTTPServer handles HTTP REST API requests
@@ -18,7 +31,35 @@ type HTTPServer struct {
 	db             *core.Database
 	port           int
 	router         *mux.Router
+	middleware     []mux.MiddlewareFunc
 	stringCommands *commands.StringCommands
+	hashCommands   *commands.HashCommands
+	setCommands    *commands.SetCommands
+	listCommands   *commands.ListCommands
+	indexCommands  *commands.IndexCommands
+	pipelineCommands *commands.PipelineCommands
+	aggregateCommands *commands.AggregateCommands
+	searchCommands *commands.SearchCommands
+	lockCommands   *commands.LockCommands
+	leaseCommands  *commands.LeaseCommands
+	rateLimitCommands *commands.RateLimitCommands
+	queueCommands  *commands.QueueCommands
+	leaderboardCommands *commands.LeaderboardCommands
+	counterCommands *commands.CounterCommands
+	crdtCommands   *commands.CRDTCommands
+	sessionCommands *commands.SessionCommands
+	batchCommands  *commands.BatchCommands
+	scheduleCommands *commands.ScheduleCommands
+	webhooks       *webhook.Manager
+	syncHub        *offline.Hub
+	watches        *watchHub
+	graphql        *graphql.Schema
+	config         *utils.ConfigManager
+	audit          *utils.AuditLog
+	adminLog       *utils.AdminLog
+	flushGuard     *flushGuard
+	latency        *utils.LatencyMonitor
+	usage          *utils.UsageMeter
 	logger         *utils.Logger
 }
 
@@ -29,9 +70,38 @@ func NewHTTPServer(db *core.Database, port int, logger *utils.Logger) *HTTPServe
 		port:           port,
 		router:         mux.NewRouter(),
 		stringCommands: commands.NewStringCommands(db),
+		hashCommands:   commands.NewHashCommands(db),
+		setCommands:    commands.NewSetCommands(db),
+		listCommands:   commands.NewListCommands(db),
+		indexCommands:  commands.NewIndexCommands(db),
+		pipelineCommands: commands.NewPipelineCommands(db),
+		aggregateCommands: commands.NewAggregateCommands(db),
+		searchCommands: commands.NewSearchCommands(db),
+		lockCommands:   commands.NewLockCommands(db),
+		leaseCommands:  commands.NewLeaseCommands(db),
+		rateLimitCommands: commands.NewRateLimitCommands(db),
+		queueCommands:  commands.NewQueueCommands(db),
+		leaderboardCommands: commands.NewLeaderboardCommands(db),
+		counterCommands: commands.NewCounterCommands(db),
+		crdtCommands:   commands.NewCRDTCommands(db),
+		sessionCommands: commands.NewSessionCommands(db),
+		batchCommands:  commands.NewBatchCommands(db),
+		scheduleCommands: commands.NewScheduleCommands(db),
+		webhooks:       webhook.NewManager(db, logger),
+		syncHub:        offline.NewHub(db, db.Config().Sync.LogSize),
+		watches:        newWatchHub(db),
+		flushGuard:     newFlushGuard(),
+		config:         utils.NewConfigManager(db, "", logger),
+		latency:        utils.NewLatencyMonitor(db.Config().Logging.LatencyThreshold),
 		logger:         logger,
 	}
 	
+	schema, err := graphql.NewKeyspaceSchema(db)
+	if err != nil {
+		logger.Error(fmt.Sprintf("GraphQL schema: %v", err))
+	}
+	server.graphql = schema
+	
 	server.setupRoutes()
 	return server
 }
@@ -39,63 +109,387 @@ func NewHTTPServer(db *core.Database, port int, logger *utils.Logger) *HTTPServe
 // Start begins the HTTP server
 func (s *HTTPServer) Start() error {
 	s.logger.Info(fmt.Sprintf("HTTP server listening on port %d", s.port))
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), s.router)
+	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), s.Handler())
+}
+
+// Handler returns the handler Start serves, for embedders that run their
+// own http.Server or mount the API in another mux. It answers the
+// /healthz and /readyz probes itself.
+func (s *HTTPServer) Handler() http.Handler {
+	return s.probeMiddleware(s.corsMiddleware(s.router))
+}
+
+// Use adds middleware, such as authentication or tenant resolution, that
+// runs after the built-in middleware for every request matching a route,
+// in the order added. Call it before Start.
+func (s *HTTPServer) Use(middleware ...func(http.Handler) http.Handler) {
+	for _, m := range middleware {
+		s.middleware = append(s.middleware, m)
+		s.router.Use(m)
+	}
+}
+
+// Router returns the router, so embedders can add routes of their own
+func (s *HTTPServer) Router() *mux.Router {
+	return s.router
+}
+
+// SetRouter replaces the router, e.g. with one of the embedding
+// application that already has its routes and middleware. The API routes,
+// the built-in middleware and any added with Use are registered on it.
+// Call it before Start.
+func (s *HTTPServer) SetRouter(router *mux.Router) {
+	s.router = router
+	s.setupRoutes()
+	s.router.Use(s.middleware...)
+}
+
+// SetConfigManager shares a config manager (e.g. one that knows the
+// config file path for reloads) instead of the server's default one
+func (s *HTTPServer) SetConfigManager(config *utils.ConfigManager) {
+	s.config = config
+}
+
+// SetAuditLog enables recording of write requests
+func (s *HTTPServer) SetAuditLog(audit *utils.AuditLog) {
+	s.audit = audit
+}
+
+// SetAdminLog enables recording of admin requests in the hash-chained
+// admin audit log and serves it under /api/v1/admin/audit
+func (s *HTTPServer) SetAdminLog(adminLog *utils.AdminLog) {
+	s.adminLog = adminLog
+}
+
+// commandContext derives the context a request's command runs under: it
+// ends when the client goes away or the configured command timeout passes
+func (s *HTTPServer) commandContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if timeout := s.db.Config().Limits.CommandTimeout; timeout > 0 {
+		return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Millisecond)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// SetLatencyMonitor shares the TCP server's latency monitor so its
+// command latencies are reported on /api/v1/latency
+func (s *HTTPServer) SetLatencyMonitor(latency *utils.LatencyMonitor) {
+	s.latency = latency
 }
 
 // setupRoutes configures all HTTP routes
 func (s *HTTPServer) setupRoutes() {
-	// Add CORS middleware
-	s.router.Use(s.corsMiddleware)
+	// CORS wraps the router in Start: router middleware doesn't run for
+	// the OPTIONS preflights of routes that don't list OPTIONS
+	s.router.Use(s.tracingMiddleware)
 	s.router.Use(s.loggingMiddleware)
+	s.router.Use(s.negotiationMiddleware)
+	s.router.Use(s.envelopeMiddleware)
+	s.router.Use(s.usageMiddleware)
+	s.router.Use(s.auditMiddleware)
+	s.router.Use(s.adminAuditMiddleware)
+	s.router.Use(s.renamedCommandMiddleware)
+	s.router.Use(s.modeMiddleware)
+	s.router.Use(s.backpressureMiddleware)
+	s.router.Use(s.sessionMiddleware)
 
-	// API routes
-	api := s.router.PathPrefix("/api/v1").Subrouter()
+	// GraphQL
+	s.router.HandleFunc("/graphql", s.handleGraphQL).Methods("GET", "POST")
 	
+	// API routes. /api/v2 serves the same endpoints with every JSON
+	// response wrapped in the v2 envelope.
+	s.registerAPI(s.router.PathPrefix(apiV1).Subrouter())
+	s.registerAPI(s.router.PathPrefix(apiV2).Subrouter())
+}
+
+// registerAPI adds the REST endpoints to the subrouter of an API version
+func (s *HTTPServer) registerAPI(api *mux.Router) {
 	// Basic operations
 	api.HandleFunc("/ping", s.handlePing).Methods("GET")
 	api.HandleFunc("/info", s.handleInfo).Methods("GET")
 	api.HandleFunc("/keys", s.handleKeys).Methods("GET")
-	api.HandleFunc("/keys/{key}", s.handleKeyOperations).Methods("GET", "POST", "PUT", "DELETE")
+	api.HandleFunc("/keys", s.handleDeletePattern).Methods("DELETE")
+	api.HandleFunc("/browse", s.handleBrowse).Methods("GET")
+	api.HandleFunc("/batch", s.handleBatch).Methods("POST")
+	api.HandleFunc("/watch", s.handleWatch).Methods("GET")
+	api.HandleFunc("/keys/{key}", s.handleKeyOperations).Methods("GET", "HEAD", "POST", "PUT", "DELETE")
 	api.HandleFunc("/keys/{key}/ttl", s.handleTTL).Methods("GET", "POST")
+	api.HandleFunc("/ttl/bulk", s.handleBulkTTLList).Methods("GET")
+	api.HandleFunc("/ttl/bulk", s.handleBulkTTL).Methods("POST")
+	api.HandleFunc("/ttl/bulk/{id}", s.handleBulkTTLStatus).Methods("GET")
+	api.HandleFunc("/keys/{key}/tags", s.handleKeyTags).Methods("GET", "POST")
+	api.HandleFunc("/keys/{key}/tags/{tag}", s.handleKeyUntag).Methods("DELETE")
+	api.HandleFunc("/tags/{tag}/keys", s.handleTagKeys).Methods("GET")
+	api.HandleFunc("/tags/{tag}/invalidate", s.handleTagInvalidate).Methods("POST")
+	api.HandleFunc("/keys/{key}/dependencies", s.handleKeyDependencies).Methods("GET", "POST")
+	api.HandleFunc("/keys/{key}/dependencies/{dependency}", s.handleKeyUndepend).Methods("DELETE")
+	api.HandleFunc("/keys/{key}/dependents", s.handleKeyDependents).Methods("GET")
 	api.HandleFunc("/keys/{key}/exists", s.handleExists).Methods("GET")
+	api.HandleFunc("/keys/{key}/memory", s.handleKeyMemory).Methods("GET")
+	api.HandleFunc("/keys/{key}/history", s.handleKeyHistory).Methods("GET")
+	api.HandleFunc("/keys/{key}/restore", s.handleKeyRestore).Methods("POST")
+	api.HandleFunc("/tombstones", s.handleTombstones).Methods("GET")
+	api.HandleFunc("/partitions", s.handlePartitions).Methods("GET")
+	api.HandleFunc("/partitions/{name}", s.handlePartitionDrop).Methods("DELETE")
+	api.HandleFunc("/memory", s.handleMemoryStats).Methods("GET")
+	api.HandleFunc("/latency", s.handleLatency).Methods("GET")
 	
 	// String operations
 	api.HandleFunc("/string/{key}", s.handleStringGet).Methods("GET")
 	api.HandleFunc("/string/{key}", s.handleStringSet).Methods("POST", "PUT")
 	api.HandleFunc("/string/{key}/append", s.handleStringAppend).Methods("POST")
 	api.HandleFunc("/string/{key}/length", s.handleStringLength).Methods("GET")
+	api.HandleFunc("/string/{key}/range", s.handleStringRange).Methods("GET")
 	api.HandleFunc("/string/{key}/incr", s.handleStringIncr).Methods("POST")
 	api.HandleFunc("/string/{key}/decr", s.handleStringDecr).Methods("POST")
 	
+	// Hash operations
+	api.HandleFunc("/hash/{key}", s.handleHashGetAll).Methods("GET")
+	api.HandleFunc("/hash/{key}", s.handleHashSet).Methods("POST", "PUT")
+	api.HandleFunc("/hash/{key}/{field}", s.handleHashField).Methods("GET", "PUT", "DELETE")
+	api.HandleFunc("/hash/{key}/{field}/incr", s.handleHashIncr).Methods("POST")
+	
+	// Set operations
+	api.HandleFunc("/set/{key}", s.handleSetMembers).Methods("GET")
+	api.HandleFunc("/set/{key}", s.handleSetAdd).Methods("POST")
+	api.HandleFunc("/set/{key}/members/{member}", s.handleSetMember).Methods("GET", "DELETE")
+	api.HandleFunc("/set/{key}/ismember", s.handleSetIsMember).Methods("GET")
+	api.HandleFunc("/set/{key}/pop", s.handleSetPop).Methods("POST")
+	api.HandleFunc("/set/{key}/move", s.handleSetMove).Methods("POST")
+	api.HandleFunc("/sets/intercard", s.handleSetInterCard).Methods("GET")
+	
+	// List operations
+	api.HandleFunc("/list/{key}", s.handleListRange).Methods("GET")
+	api.HandleFunc("/list/{key}/length", s.handleListLength).Methods("GET")
+	api.HandleFunc("/list/{key}/lpush", s.handleListPush).Methods("POST")
+	api.HandleFunc("/list/{key}/rpush", s.handleListPush).Methods("POST")
+	api.HandleFunc("/list/{key}/lpop", s.handleListPop).Methods("POST")
+	api.HandleFunc("/list/{key}/rpop", s.handleListPop).Methods("POST")
+	api.HandleFunc("/list/{key}/pos", s.handleListPos).Methods("GET")
+	api.HandleFunc("/list/{key}/insert", s.handleListInsert).Methods("POST")
+	api.HandleFunc("/list/{key}/rem", s.handleListRem).Methods("POST")
+	api.HandleFunc("/list/{key}/items/{index}", s.handleListSet).Methods("PUT")
+	api.HandleFunc("/list/{key}/trim", s.handleListTrim).Methods("POST")
+	api.HandleFunc("/lists/mpop", s.handleListMPop).Methods("POST")
+	
+	// Secondary indexes
+	api.HandleFunc("/indexes", s.handleIndexList).Methods("GET")
+	api.HandleFunc("/indexes", s.handleIndexCreate).Methods("POST")
+	api.HandleFunc("/indexes/{name}", s.handleIndexDrop).Methods("DELETE")
+	api.HandleFunc("/indexes/{name}/find", s.handleIndexFind).Methods("GET")
+
+	// Aggregates kept up to date on write
+	api.HandleFunc("/aggregates", s.handleAggregateList).Methods("GET")
+	api.HandleFunc("/aggregates", s.handleAggregateCreate).Methods("POST")
+	api.HandleFunc("/aggregates/{name}", s.handleAggregateDrop).Methods("DELETE")
+	api.HandleFunc("/query", s.handleQuery).Methods("GET", "POST")
+	
+	// Full-text search
+	api.HandleFunc("/search", s.handleSearchList).Methods("GET")
+	api.HandleFunc("/search", s.handleSearchCreate).Methods("POST")
+	api.HandleFunc("/search/{name}", s.handleSearchQuery).Methods("GET")
+	api.HandleFunc("/search/{name}", s.handleSearchDrop).Methods("DELETE")
+	
+	// Locks
+	api.HandleFunc("/locks/{key}", s.handleLock).Methods("GET", "POST", "PUT", "DELETE")
+	api.HandleFunc("/leases/{key}", s.handleLease).Methods("GET", "PUT", "DELETE")
+	
+	// Rate limiting
+	api.HandleFunc("/ratelimit/{key}", s.handleRateLimit).Methods("POST")
+	
+	// Job queues
+	api.HandleFunc("/queues", s.handleQueueList).Methods("GET")
+	api.HandleFunc("/queues/{name}", s.handleQueueStats).Methods("GET")
+	api.HandleFunc("/queues/{name}", s.handleQueuePurge).Methods("DELETE")
+	api.HandleFunc("/queues/{name}/jobs", s.handleQueueEnqueue).Methods("POST")
+	api.HandleFunc("/queues/{name}/dequeue", s.handleQueueDequeue).Methods("POST")
+	api.HandleFunc("/queues/{name}/jobs/{id}/ack", s.handleQueueAck).Methods("POST")
+	api.HandleFunc("/queues/{name}/jobs/{id}/nack", s.handleQueueAck).Methods("POST")
+	
+	// Leaderboards
+	api.HandleFunc("/leaderboards/{name}", s.handleLeaderboardTop).Methods("GET")
+	api.HandleFunc("/leaderboards/{name}", s.handleLeaderboardReset).Methods("DELETE")
+	api.HandleFunc("/leaderboards/{name}/scores", s.handleLeaderboardRecord).Methods("POST")
+	api.HandleFunc("/leaderboards/{name}/members/{member}", s.handleLeaderboardRank).Methods("GET")
+	api.HandleFunc("/leaderboards/{name}/members/{member}", s.handleLeaderboardRemove).Methods("DELETE")
+	api.HandleFunc("/leaderboards/{name}/schedule", s.handleLeaderboardSchedule).Methods("PUT")
+	
+	// Time-bucketed counters
+	api.HandleFunc("/counters/{name}", s.handleCounterRange).Methods("GET")
+	api.HandleFunc("/counters/{name}", s.handleCounterIncr).Methods("POST")
+	
+	// CRDTs
+	api.HandleFunc("/crdt/merge", s.handleCRDTMerge).Methods("POST")
+	api.HandleFunc("/crdt/{key}", s.handleCRDTState).Methods("GET")
+	
+	// Offline sync of embedded instances
+	api.HandleFunc("/sync", s.handleSync).Methods("POST")
+	
+	// Admin audit log
+	api.HandleFunc("/admin/audit", s.requireAdmin(s.handleAdminAudit)).Methods("GET")
+	api.HandleFunc("/admin/audit/verify", s.requireAdmin(s.handleAdminAuditVerify)).Methods("GET")
+	api.HandleFunc("/admin/usage", s.requireAdmin(s.handleUsage)).Methods("GET")
+	
+	// Webhooks
+	api.HandleFunc("/webhooks", s.handleWebhookList).Methods("GET")
+	api.HandleFunc("/webhooks", s.handleWebhookCreate).Methods("POST")
+	api.HandleFunc("/webhooks/{id}", s.handleWebhookGet).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", s.handleWebhookDelete).Methods("DELETE")
+	
+	// Scheduled tasks, run by the TCP server
+	api.HandleFunc("/schedules", s.handleScheduleList).Methods("GET")
+	api.HandleFunc("/schedules", s.requireAdmin(s.handleScheduleCreate)).Methods("POST")
+	api.HandleFunc("/schedules/{id}", s.requireAdmin(s.handleScheduleDelete)).Methods("DELETE")
+	
+	// Sessions
+	api.HandleFunc("/sessions", s.handleSessionCreate).Methods("POST")
+	api.HandleFunc("/sessions", s.handleSessionList).Methods("GET")
+	api.HandleFunc("/sessions/{id}", s.handleSession).Methods("GET", "PUT", "DELETE")
+	api.HandleFunc("/sessions/{id}/touch", s.handleSessionTouch).Methods("POST")
+	
+	// Access statistics
+	api.HandleFunc("/stats/keys", s.handleStatsKeys).Methods("GET")
+	api.HandleFunc("/stats/keys/{key}", s.handleStatsKey).Methods("GET")
+	api.HandleFunc("/stats/prefixes", s.handleStatsPrefixes).Methods("GET")
+	
+	// Caching proxy
+	api.HandleFunc("/proxy/cache", s.handleProxyPurge).Methods("DELETE")
+	
+	// Runtime configuration
+	api.HandleFunc("/config", s.handleConfigGet).Methods("GET")
+	api.HandleFunc("/config", s.requireAdmin(s.handleConfigSet)).Methods("PUT", "PATCH")
+	api.HandleFunc("/config/reload", s.requireAdmin(s.handleConfigReload)).Methods("POST")
+	
 	// Bulk operations
 	api.HandleFunc("/bulk/get", s.handleBulkGet).Methods("POST")
+	api.HandleFunc("/pipeline", s.handlePipeline).Methods("POST")
 	api.HandleFunc("/bulk/set", s.handleBulkSet).Methods("POST")
+	api.HandleFunc("/bulk/swap", s.handleBulkSwap).Methods("POST")
 	api.HandleFunc("/flush", s.handleFlushAll).Methods("DELETE")
+	
+	// Snapshots and point-in-time restore
+	api.HandleFunc("/persistence/snapshots", s.handleSnapshotList).Methods("GET")
+	api.HandleFunc("/persistence/snapshots", s.handleSnapshotSave).Methods("POST")
+	api.HandleFunc("/persistence/restore", s.handleRestore).Methods("POST")
+	
+	// Read-your-writes across replicas
+	api.HandleFunc("/replication", s.handleReplication).Methods("GET")
+	api.HandleFunc("/replication/offset", s.requireAdmin(s.handleReplicaOffset)).Methods("PUT")
+	api.HandleFunc("/replication/ack", s.requireAdmin(s.handleReplicaAck)).Methods("PUT")
 }
 
 // Middleware functions
-func (s *HTTPServer) corsMiddleware(next http.Handler) http.Handler {
+
+// tracingMiddleware records a server span per request, continuing the
+// caller's trace when a traceparent header is present
+func (s *HTTPServer) tracingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		if !tracing.Enabled() {
+			next.ServeHTTP(w, r)
 			return
 		}
 		
-		next.ServeHTTP(w, r)
+		ctx := r.Context()
+		if remote, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemote(ctx, remote)
+		}
+		
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, tracing.KindServer)
+		defer span.Finish()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		span.SetAttribute("net.peer.addr", r.RemoteAddr)
+		
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(recorder.status)))
+		}
 	})
 }
 
 func (s *HTTPServer) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		s.logger.Info(fmt.Sprintf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr))
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = utils.NewRequestID()
+			r.Header.Set("X-Request-ID", requestID)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		
+		s.logger.WithRequestID(requestID).Info(fmt.Sprintf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditMiddleware records every request that may modify data
+func (s *HTTPServer) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		
+		err := s.audit.Record(utils.AuditEntry{
+			RequestID: r.Header.Get("X-Request-ID"),
+			Client:    r.RemoteAddr,
+			Protocol:  "http",
+			Command:   r.Method,
+			Args:      []string{r.URL.Path},
+			Success:   recorder.status < 400,
+		})
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("audit log write failed: %v", err))
+		}
+	})
+}
+
+// backpressureMiddleware holds back requests that may modify data while
+// persistence is behind, answering 503 once they wait too long
+func (s *HTTPServer) backpressureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := s.db.WaitWritable(r.Context()); err != nil {
+			w.Header().Set("Retry-After", "1")
+			s.writeClassified(w, err, http.StatusServiceUnavailable, err.Error())
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush event streams
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // Handler functions
 func (s *HTTPServer) handlePing(w http.ResponseWriter, r *http.Request) {
 	response := map[string]string{"message": "PONG", "status": "ok"}
@@ -113,7 +507,13 @@ func (s *HTTPServer) handleKeys(w http.ResponseWriter, r *http.Request) {
 		pattern = "*"
 	}
 	
-	keys := s.db.Keys(pattern)
+	ctx, cancel := s.commandContext(r)
+	defer cancel()
+	keys, err := s.db.KeysContext(ctx, pattern)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
 	response := map[string]interface{}{
 		"keys":  keys,
 		"count": len(keys),
@@ -121,12 +521,111 @@ func (s *HTTPServer) handleKeys(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, response)
 }
 
+func (s *HTTPServer) handleDeletePattern(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		s.writeError(w, http.StatusBadRequest, "pattern is required")
+		return
+	}
+	
+	batch := 1000
+	if size := r.URL.Query().Get("batch"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			s.writeError(w, http.StatusBadRequest, "batch must be a positive integer")
+			return
+		}
+		batch = n
+	}
+	
+	ctx, cancel := s.commandContext(r)
+	defer cancel()
+	deleted, err := s.db.DeletePatternContext(ctx, pattern, batch)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, fmt.Sprintf("%v after deleting %d keys", err, deleted))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pattern": pattern,
+		"deleted": deleted,
+	})
+}
+
+func (s *HTTPServer) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := core.BrowseOptions{
+		Cursor:  query.Get("cursor"),
+		Prefix:  query.Get("prefix"),
+		Pattern: query.Get("pattern"),
+		Type:    strings.ToLower(query.Get("type")),
+		SortBy:  query.Get("sort"),
+		Limit:   50,
+	}
+	
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		s.writeError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 1000 {
+			s.writeError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		opts.Limit = n
+	}
+	
+	if preview := query.Get("preview"); preview != "" {
+		n, err := strconv.Atoi(preview)
+		if err != nil || n < 0 {
+			s.writeError(w, http.StatusBadRequest, "preview must be a non-negative number of bytes")
+			return
+		}
+		opts.Preview = n
+	}
+	
+	ctx, cancel := s.commandContext(r)
+	defer cancel()
+	page, err := s.db.BrowseContext(ctx, opts)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, page)
+}
+
 func (s *HTTPServer) handleKeyOperations(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 	
 	switch r.Method {
 	case "GET":
+		// ?wait=30s holds the read until the key changes from the version
+		// in If-None-Match (or the current one), answering 304 if it
+		// doesn't in time
+		if wait := r.URL.Query().Get("wait"); wait != "" {
+			timeout, ok := parseWait(wait)
+			if !ok {
+				s.writeError(w, http.StatusBadRequest, fmt.Sprintf("wait must be a duration up to %s", maxWait))
+				return
+			}
+			if !s.waitForChange(r, key, timeout) {
+				if meta, exists := s.db.Metadata(key); exists {
+					w.Header().Set("ETag", formatETag(meta.Version))
+				}
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		
+		// ?meta=true adds the key's metadata, read before the GET counts
+		// as an access so last_accessed_at is the previous access
+		meta, _ := s.db.Metadata(key)
 		value, exists := s.db.Get(key)
 		if !exists {
 			s.writeError(w, http.StatusNotFound, "key not found")
@@ -138,12 +637,140 @@ func (s *HTTPServer) handleKeyOperations(w http.ResponseWriter, r *http.Request)
 			"value":      value.Data,
 			"type":       value.Type,
 			"ttl":        s.db.GetTTL(key),
+			"version":    value.Version,
 			"created_at": value.CreatedAt,
 			"updated_at": value.UpdatedAt,
 		}
+		if r.URL.Query().Get("meta") == "true" {
+			response["meta"] = meta
+		}
+		// A null entry caches a miss; its value is null like any nil Data,
+		// so the flag is what tells it apart
+		if value.Type == core.NULL {
+			response["null"] = true
+		}
+		// JSON can't carry binary strings, so they are sent base64-encoded
+		if r.URL.Query().Get("encoding") == "base64" || !utf8.ValidString(key) || core.BinaryData(value.Data) {
+			response["key"] = base64.StdEncoding.EncodeToString([]byte(key))
+			response["value"] = core.EncodeBinary(value.Data)
+			response["encoding"] = "base64"
+		}
+		w.Header().Set("ETag", formatETag(value.Version))
+		w.Header().Set("Last-Modified", value.UpdatedAt.UTC().Format(http.TimeFormat))
+		if notModified(r, value.Version, value.UpdatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		s.writeJSON(w, http.StatusOK, response)
 		
+	case "HEAD":
+		// HEAD answers the key's metadata as headers without counting
+		// as an access
+		meta, exists := s.db.Metadata(key)
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		setMetadataHeaders(w.Header(), meta)
+		if notModified(r, meta.Version, meta.UpdatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		
+	case "POST", "PUT":
+		var payload struct {
+			Value    string `json:"value"`
+			TTL      int64  `json:"ttl,omitempty"`
+			Jitter   bool   `json:"jitter,omitempty"` // Shorten ttl at random by up to storage.ttl_jitter percent
+			Encoding string `json:"encoding,omitempty"`
+			Null     bool   `json:"null,omitempty"` // Store a null entry caching a miss instead of value
+		}
+		
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+		if payload.Jitter {
+			payload.TTL = s.db.JitterTTL(payload.TTL)
+		}
+		validator := s.validator()
+		validator.key("key", key)
+		validator.ttl("ttl", payload.TTL)
+		validator.check(payload.Encoding == "" || payload.Encoding == "base64", "encoding", codeBadRequest, "must be base64 if set")
+		if payload.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(payload.Value)
+			validator.check(err == nil, "value", codeBadRequest, "is not valid base64")
+			payload.Value = string(decoded)
+		}
+		validator.check(!payload.Null || payload.Value == "", "value", codeBadRequest, "must be empty for a null entry")
+		validator.check(!payload.Null || payload.TTL > 0, "ttl", codeBadRequest, "is required for a null entry")
+		if validator.failed(w) {
+			return
+		}
+		if payload.Null && (r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != "") {
+			s.writeError(w, http.StatusBadRequest, "null entries can't be written conditionally")
+			return
+		}
+		
+		// If-Match makes the write conditional on the current version,
+		// If-None-Match: * only creates keys that don't exist yet
+		var response *core.Response
+		if payload.Null {
+			response = s.stringCommands.SetNull(key, payload.TTL)
+		} else if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			var expected uint64
+			if ifMatch == "*" {
+				value, exists := s.db.Get(key)
+				if !exists {
+					s.writeError(w, http.StatusPreconditionFailed, "key does not exist")
+					return
+				}
+				expected = value.Version
+			} else {
+				version, ok := parseETag(ifMatch)
+				if !ok {
+					s.writeError(w, http.StatusBadRequest, "invalid If-Match header")
+					return
+				}
+				expected = version
+			}
+			response = s.stringCommands.CompareAndSet(key, payload.Value, expected, payload.TTL)
+		} else if r.Header.Get("If-None-Match") == "*" {
+			response = s.stringCommands.CompareAndSet(key, payload.Value, 0, payload.TTL)
+		} else {
+			response = s.stringCommands.Set(key, payload.Value, payload.TTL)
+		}
+		
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusInternalServerError)
+			return
+		}
+		
+		value, _ := s.db.Get(key)
+		if value != nil {
+			w.Header().Set("ETag", formatETag(value.Version))
+		}
+		s.writeJSON(w, http.StatusOK, map[string]string{"message": "value set successfully"})
+		
 	case "DELETE":
+		// If-Match only deletes the version the client last saw
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" {
+			expected, ok := parseETag(ifMatch)
+			if !ok {
+				s.writeError(w, http.StatusBadRequest, "invalid If-Match header")
+				return
+			}
+			if !s.db.DeleteIf(key, func(value *core.TriffValue) bool { return value.Version == expected }) {
+				if s.db.Exists(key) {
+					s.writeError(w, http.StatusPreconditionFailed, "version mismatch")
+				} else {
+					s.writeError(w, http.StatusNotFound, "key not found")
+				}
+				return
+			}
+			s.writeJSON(w, http.StatusOK, map[string]string{"message": "key deleted"})
+			return
+		}
 		if s.db.Delete(key) {
 			s.writeJSON(w, http.StatusOK, map[string]string{"message": "key deleted"})
 		} else {
@@ -156,15 +783,56 @@ func (s *HTTPServer) handleStringGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 	
-	response := s.stringCommands.Get(key)
-	if response.Success {
-		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+	query := r.URL.Query()
+	
+	// ?stale=true serves values inside the stale grace window, flagged as stale
+	if query.Get("stale") == "true" {
+		result, err := core.Result[commands.StaleValue](s.stringCommands.GetStale(key))
+		if err != nil {
+			s.writeClassified(w, err, http.StatusNotFound, "key not found or not a string")
+			return
+		}
+		if result.Stale {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+		if mediaType, ok := rawResponseType(r); ok {
+			writeRaw(w, mediaType, result.Value)
+			return
+		}
+		response := map[string]interface{}{
 			"key":   key,
-			"value": response.Data,
-		})
-	} else {
+			"stale": result.Stale,
+		}
+		encodeStringValue(response, result.Value, r)
+		s.writeJSON(w, http.StatusOK, response)
+		return
+	}
+	
+	// ?ex=seconds or ?persist=true update the expiration as part of the read (GETEX)
+	var ttl int64
+	if ex := query.Get("ex"); ex != "" {
+		n, err := strconv.ParseInt(ex, 10, 64)
+		if err != nil || n <= 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid expire time")
+			return
+		}
+		ttl = n
+	}
+	persist := query.Get("persist") == "true"
+	
+	value, err := core.Result[string](s.stringCommands.GetEx(key, ttl, persist))
+	if err != nil {
 		s.writeError(w, http.StatusNotFound, "key not found or not a string")
+		return
 	}
+	// Accept: application/octet-stream or text/plain serves the bare value
+	if mediaType, ok := rawResponseType(r); ok {
+		writeRaw(w, mediaType, value)
+		return
+	}
+	response := map[string]interface{}{"key": key}
+	encodeStringValue(response, value, r)
+	s.writeJSON(w, http.StatusOK, response)
 }
 
 func (s *HTTPServer) handleStringSet(w http.ResponseWriter, r *http.Request) {
@@ -172,20 +840,58 @@ func (s *HTTPServer) handleStringSet(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 	
 	var payload struct {
-		Value string `json:"value"`
-		TTL   int64  `json:"ttl,omitempty"`
+		Value    string `json:"value"`
+		Encoding string `json:"encoding,omitempty"` // "base64" for binary values
+		TTL      int64  `json:"ttl,omitempty"`
+		KeepTTL  bool   `json:"keep_ttl,omitempty"`
+	}
+	
+	// Raw bodies (application/octet-stream, text/plain) are the value
+	// itself, with ?ttl= and ?keep_ttl=true in the query
+	if rawRequest(r) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "failed to read body")
+			return
+		}
+		payload.Value = string(body)
+		query := r.URL.Query()
+		if ttl := query.Get("ttl"); ttl != "" {
+			n, err := strconv.ParseInt(ttl, 10, 64)
+			if err != nil {
+				n = -1
+			}
+			payload.TTL = n
+		}
+		payload.KeepTTL = query.Get("keep_ttl") == "true"
+	} else if !s.decodeJSON(w, r, &payload) {
+		return
 	}
 	
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		s.writeError(w, http.StatusBadRequest, "invalid JSON payload")
+	validator := s.validator()
+	validator.key("key", key)
+	validator.ttl("ttl", payload.TTL)
+	validator.check(!payload.KeepTTL || payload.TTL == 0, "keep_ttl", codeBadRequest, "can't be combined with ttl")
+	validator.check(payload.Encoding == "" || payload.Encoding == "base64", "encoding", codeBadRequest, "must be base64 if set")
+	if payload.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(payload.Value)
+		validator.check(err == nil, "value", codeBadRequest, "is not valid base64")
+		payload.Value = string(decoded)
+	}
+	if validator.failed(w) {
 		return
 	}
 	
-	response := s.stringCommands.Set(key, payload.Value, payload.TTL)
+	var response *core.Response
+	if payload.KeepTTL {
+		response = s.stringCommands.SetKeepTTL(key, payload.Value)
+	} else {
+		response = s.stringCommands.Set(key, payload.Value, payload.TTL)
+	}
 	if response.Success {
 		s.writeJSON(w, http.StatusOK, map[string]string{"message": "value set successfully"})
 	} else {
-		s.writeError(w, http.StatusInternalServerError, response.Error)
+		s.writeFailure(w, response, http.StatusInternalServerError)
 	}
 }
 
@@ -197,8 +903,7 @@ func (s *HTTPServer) handleStringAppend(w http.ResponseWriter, r *http.Request)
 		Value string `json:"value"`
 	}
 	
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		s.writeError(w, http.StatusBadRequest, "invalid JSON payload")
+	if !s.decodeJSON(w, r, &payload) {
 		return
 	}
 	
@@ -209,7 +914,7 @@ func (s *HTTPServer) handleStringAppend(w http.ResponseWriter, r *http.Request)
 			"length":  response.Data,
 		})
 	} else {
-		s.writeError(w, http.StatusInternalServerError, response.Error)
+		s.writeFailure(w, response, http.StatusInternalServerError)
 	}
 }
 
@@ -217,13 +922,54 @@ func (s *HTTPServer) handleStringLength(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	key := vars["key"]
 	
+	// ?unit=chars counts characters instead of bytes
+	chars, ok := lengthUnit([]string{r.URL.Query().Get("unit")})
+	if !ok && r.URL.Query().Get("unit") != "" {
+		s.writeErrorCode(w, http.StatusBadRequest, codeBadRequest, "unit must be bytes or chars")
+		return
+	}
 	response := s.stringCommands.Strlen(key)
+	if chars {
+		response = s.stringCommands.StrlenChars(key)
+	}
 	s.writeJSON(w, http.StatusOK, map[string]interface{}{
 		"key":    key,
 		"length": response.Data,
 	})
 }
 
+// handleStringRange returns the part of a string between ?start= and
+// ?end=, inclusive offsets that count from the end when negative, in
+// bytes or with ?unit=chars in characters
+func (s *HTTPServer) handleStringRange(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	query := r.URL.Query()
+	
+	start, startErr := strconv.Atoi(query.Get("start"))
+	end, endErr := strconv.Atoi(query.Get("end"))
+	chars, ok := lengthUnit([]string{query.Get("unit")})
+	validator := s.validator()
+	validator.check(startErr == nil, "start", codeFieldType, "must be an integer")
+	validator.check(endErr == nil, "end", codeFieldType, "must be an integer")
+	validator.check(ok || query.Get("unit") == "", "unit", codeBadRequest, "must be bytes or chars")
+	if validator.failed(w) {
+		return
+	}
+	
+	response := s.stringCommands.GetRange(key, start, end)
+	if chars {
+		response = s.stringCommands.GetRangeChars(key, start, end)
+	}
+	value, err := core.Result[string](response)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	result := map[string]interface{}{"key": key, "start": start, "end": end}
+	encodeStringValue(result, value, r)
+	s.writeJSON(w, http.StatusOK, result)
+}
+
 func (s *HTTPServer) handleStringIncr(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
@@ -247,7 +993,7 @@ func (s *HTTPServer) handleStringIncr(w http.ResponseWriter, r *http.Request) {
 			"value": response.Data,
 		})
 	} else {
-		s.writeError(w, http.StatusBadRequest, response.Error)
+		s.writeFailure(w, response, http.StatusBadRequest)
 	}
 }
 
@@ -262,7 +1008,7 @@ func (s *HTTPServer) handleStringDecr(w http.ResponseWriter, r *http.Request) {
 			"value": response.Data,
 		})
 	} else {
-		s.writeError(w, http.StatusBadRequest, response.Error)
+		s.writeFailure(w, response, http.StatusBadRequest)
 	}
 }
 
@@ -281,12 +1027,15 @@ func (s *HTTPServer) handleTTL(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		var payload struct {
 			Seconds int64 `json:"seconds"`
+			Jitter  bool  `json:"jitter"`
 		}
 		
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			s.writeError(w, http.StatusBadRequest, "invalid JSON payload")
+		if !s.decodeJSON(w, r, &payload) {
 			return
 		}
+		if payload.Jitter {
+			payload.Seconds = s.db.JitterTTL(payload.Seconds)
+		}
 		
 		if s.db.SetTTL(key, payload.Seconds) {
 			s.writeJSON(w, http.StatusOK, map[string]string{"message": "TTL set successfully"})
@@ -296,60 +1045,2223 @@ func (s *HTTPServer) handleTTL(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *HTTPServer) handleExists(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	key := vars["key"]
-	
-	exists := s.db.Exists(key)
-	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"key":    key,
-		"exists": exists,
-	})
-}
-
-func (s *HTTPServer) handleBulkGet(w http.ResponseWriter, r *http.Request) {
+func (s *HTTPServer) handleBulkTTL(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Keys []string `json:"keys"`
+		Pattern string `json:"pattern"`
+		Prefix  string `json:"prefix"`
+		TTL     int64  `json:"ttl"`
+		Persist bool   `json:"persist"`
+		Batch   int    `json:"batch"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
 	}
 	
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		s.writeError(w, http.StatusBadRequest, "invalid JSON payload")
+	pattern := payload.Pattern
+	if payload.Prefix != "" {
+		if pattern != "" {
+			s.writeError(w, http.StatusBadRequest, "use either pattern or prefix, not both")
+			return
+		}
+		pattern = core.EscapePattern(payload.Prefix) + "*"
+	}
+	if pattern == "" {
+		s.writeError(w, http.StatusBadRequest, "pattern or prefix is required")
+		return
+	}
+	if payload.Persist == (payload.TTL > 0) {
+		s.writeError(w, http.StatusBadRequest, "specify either a positive ttl or persist")
 		return
 	}
 	
-	response := s.stringCommands.MGet(payload.Keys)
-	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"keys":   payload.Keys,
-		"values": response.Data,
-	})
+	job := s.db.ExpirePattern(pattern, payload.TTL, payload.Batch)
+	w.Header().Set("Location", "/api/v1/ttl/bulk/"+job.ID)
+	s.writeJSON(w, http.StatusAccepted, job)
 }
 
-func (s *HTTPServer) handleBulkSet(w http.ResponseWriter, r *http.Request) {
+func (s *HTTPServer) handleBulkTTLList(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.db.BulkJobs())
+}
+
+func (s *HTTPServer) handleBulkTTLStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	job, exists := s.db.BulkJob(id)
+	if !exists {
+		s.writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+// handleKeyTags lists a key's tags, or adds {"tags": [...]} to them
+func (s *HTTPServer) handleKeyTags(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if r.Method == "GET" {
+		tags, exists := s.db.KeyTags(key)
+		if !exists {
+			s.writeErrorCode(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "tags": tags})
+		return
+	}
+
 	var payload struct {
-		Data map[string]string `json:"data"`
+		Tags []string `json:"tags"`
 	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		s.writeError(w, http.StatusBadRequest, "invalid JSON payload")
+	if !s.decodeJSON(w, r, &payload) {
 		return
 	}
-	
-	response := s.stringCommands.MSet(payload.Data)
-	if response.Success {
-		s.writeJSON(w, http.StatusOK, map[string]interface{}{
-			"message": "bulk set successful",
-			"count":   len(payload.Data),
-		})
-	} else {
-		s.writeError(w, http.StatusInternalServerError, response.Error)
+	v := s.validator()
+	v.check(len(payload.Tags) > 0, "tags", codeFieldRequired, "is required")
+	for _, tag := range payload.Tags {
+		v.check(tag != "", "tags", codeFieldRequired, "must not contain empty tags")
+	}
+	if v.failed(w) {
+		return
 	}
-}
 
+	added, err := s.db.Tag(key, payload.Tags...)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	tags, _ := s.db.KeyTags(key)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "added": added, "tags": tags})
+}
+
+// handleKeyUntag removes a tag from a key
+func (s *HTTPServer) handleKeyUntag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	removed, err := s.db.Untag(vars["key"], vars["tag"])
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"removed": removed})
+}
+
+// handleTagKeys lists the keys with a tag
+func (s *HTTPServer) handleTagKeys(w http.ResponseWriter, r *http.Request) {
+	tag := mux.Vars(r)["tag"]
+	keys := s.db.TaggedKeys(tag)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"tag": tag, "keys": keys, "count": len(keys)})
+}
+
+// handleTagInvalidate starts a background job deleting the keys with a
+// tag, or with a ttl, expiring them. Its progress is at the Location
+// returned, like a bulk TTL job's.
+func (s *HTTPServer) handleTagInvalidate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TTL   int64 `json:"ttl"`
+		Batch int   `json:"batch"`
+	}
+	if r.ContentLength != 0 && !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.ttl("ttl", payload.TTL)
+	v.check(payload.Batch >= 0, "batch", codeValidation, "must not be negative")
+	if v.failed(w) {
+		return
+	}
+
+	job := s.db.InvalidateTag(mux.Vars(r)["tag"], payload.TTL, payload.Batch)
+	w.Header().Set("Location", "/api/v1/ttl/bulk/"+job.ID)
+	s.writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleKeyDependencies lists the keys a key depends on, or adds
+// {"dependencies": [...]} to them. A dependency that would create a cycle
+// is a conflict.
+func (s *HTTPServer) handleKeyDependencies(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if r.Method == "GET" {
+		dependencies, exists := s.db.KeyDependencies(key)
+		if !exists {
+			s.writeErrorCode(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "dependencies": dependencies})
+		return
+	}
+
+	var payload struct {
+		Dependencies []string `json:"dependencies"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(len(payload.Dependencies) > 0, "dependencies", codeFieldRequired, "is required")
+	for _, dependency := range payload.Dependencies {
+		v.check(dependency != "", "dependencies", codeFieldRequired, "must not contain empty keys")
+	}
+	if v.failed(w) {
+		return
+	}
+
+	added, err := s.db.Depend(key, payload.Dependencies...)
+	if errors.Is(err, core.ErrDependencyCycle) {
+		s.writeErrorCode(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	dependencies, _ := s.db.KeyDependencies(key)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "added": added, "dependencies": dependencies})
+}
+
+// handleKeyUndepend removes a dependency from a key
+func (s *HTTPServer) handleKeyUndepend(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	removed, err := s.db.Undepend(vars["key"], vars["dependency"])
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"removed": removed})
+}
+
+// handleKeyDependents lists the keys that depend on a key, which need not
+// exist itself
+func (s *HTTPServer) handleKeyDependents(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	keys := s.db.Dependents(key)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "dependents": keys, "count": len(keys)})
+}
+
+func (s *HTTPServer) handleExists(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+	
+	exists := s.db.Exists(key)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"exists": exists,
+	})
+}
+
+func (s *HTTPServer) handleKeyMemory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	bytes, exists := s.db.MemoryUsage(key)
+	if !exists {
+		s.writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"bytes": bytes,
+	})
+}
+
+// setMetadataHeaders describes a key in the headers of a HEAD response
+func setMetadataHeaders(header http.Header, meta core.KeyMetadata) {
+	header.Set("ETag", formatETag(meta.Version))
+	header.Set("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+	header.Set("X-Triff-Type", meta.Type)
+	header.Set("X-Triff-Encoding", meta.Encoding)
+	header.Set("X-Triff-Size", strconv.FormatInt(meta.SizeBytes, 10))
+	header.Set("X-Triff-Elements", strconv.Itoa(meta.Elements))
+	header.Set("X-Triff-Version", strconv.FormatUint(meta.Version, 10))
+	header.Set("X-Triff-TTL", strconv.FormatInt(meta.TTL, 10))
+	header.Set("X-Triff-Created-At", meta.CreatedAt.UTC().Format(time.RFC3339))
+	if !meta.LastAccessed.IsZero() {
+		header.Set("X-Triff-Last-Accessed", meta.LastAccessed.UTC().Format(time.RFC3339))
+	}
+	header.Set("X-Triff-Idle-Seconds", strconv.FormatInt(meta.IdleSeconds, 10))
+}
+
+// maxBatchOps bounds the operations of one batch request
+const maxBatchOps = 1000
+
+// handleBatch runs an ordered list of operations in one request, as one
+// transaction with "atomic": true
+func (s *HTTPServer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Operations []commands.BatchOp `json:"operations"`
+		Atomic     bool               `json:"atomic"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	if len(payload.Operations) == 0 || len(payload.Operations) > maxBatchOps {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("a batch needs 1 to %d operations", maxBatchOps))
+		return
+	}
+	// Renamed and disabled commands are off the HTTP API, in batches too
+	for i, op := range payload.Operations {
+		if s.commandDisabled(op.Command()) {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: unknown op '%s'", i, op.Op))
+			return
+		}
+	}
+	
+	response := s.batchCommands.Exec(payload.Operations, payload.Atomic)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": response.Data,
+		"atomic":  payload.Atomic,
+	})
+}
+
+// handleKeyHistory lists the kept previous versions of a key, newest first
+func (s *HTTPServer) handleKeyHistory(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	
+	response := map[string]interface{}{
+		"key":       key,
+		"revisions": s.db.History(key),
+	}
+	if current, exists := s.db.Revision(key, 0); exists {
+		response["current"] = current
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleKeyRestore undoes a soft delete
+func (s *HTTPServer) handleKeyRestore(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	
+	switch err := s.db.Restore(key); err {
+	case nil:
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "restored": true})
+	case core.ErrNoTombstone:
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case core.ErrKeyExists:
+		s.writeError(w, http.StatusConflict, err.Error())
+	default:
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *HTTPServer) handleTombstones(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.db.Tombstones())
+}
+
+func (s *HTTPServer) handlePartitions(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.db.Partitions())
+}
+
+// handlePartitionDrop drops a time partition before its retention runs out
+func (s *HTTPServer) handlePartitionDrop(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	dropped, err := s.db.DropPartition(name)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"partition": name, "dropped": dropped})
+}
+
+func (s *HTTPServer) handleMemoryStats(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.db.MemoryStats())
+}
+
+// handleLatency returns percentile summaries per command family, in
+// microseconds, and the latest spike of each command
+func (s *HTTPServer) handleLatency(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"threshold_ms": s.latency.Threshold(),
+		"families":     s.latency.Summaries(),
+		"latest":       s.latency.Latest(),
+	})
+}
+
+func (s *HTTPServer) handleBulkGet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Keys []string `json:"keys"`
+	}
+	
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	response := s.stringCommands.MGet(payload.Keys)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":   payload.Keys,
+		"values": response.Data,
+	})
+}
+
+// maxPipelineKeys bounds the keys of one pipeline request
+const maxPipelineKeys = 1000
+
+// handlePipeline reads {"keys": [...]} of any type in one request, with a
+// typed result per key
+func (s *HTTPServer) handlePipeline(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Keys []string `json:"keys"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(len(payload.Keys) > 0, "keys", codeFieldRequired, "is required")
+	v.check(len(payload.Keys) <= maxPipelineKeys, "keys", codeValidation, fmt.Sprintf("must hold at most %d keys", maxPipelineKeys))
+	if v.failed(w) {
+		return
+	}
+
+	response := s.pipelineCommands.Get(payload.Keys)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": response.Data,
+	})
+}
+
+// handleBulkSet sets several keys at once. With {"nx": true} they are only
+// set if none of them exists, as MSETNX.
+func (s *HTTPServer) handleBulkSet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Data map[string]string `json:"data"`
+		NX   bool              `json:"nx,omitempty"`
+	}
+	
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	if payload.NX {
+		response := s.stringCommands.MSetNX(payload.Data)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"set":   response.Data,
+			"count": len(payload.Data),
+		})
+		return
+	}
+	
+	response := s.stringCommands.MSet(payload.Data)
+	if response.Success {
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message": "bulk set successful",
+			"count":   len(payload.Data),
+		})
+	} else {
+		s.writeFailure(w, response, http.StatusInternalServerError)
+	}
+}
+
+// handleBulkSwap exchanges the values of two keys atomically, replying 404
+// unless both exist
+func (s *HTTPServer) handleBulkSwap(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Keys []string `json:"keys"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	validator := s.validator()
+	validator.check(len(payload.Keys) == 2, "keys", codeBadRequest, "must name two keys")
+	if validator.failed(w) {
+		return
+	}
+	
+	swapped, err := s.db.Swap(payload.Keys[0], payload.Keys[1])
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !swapped {
+		s.writeError(w, http.StatusNotFound, "both keys must exist")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"keys": payload.Keys, "swapped": true})
+}
+
+// handleFlushAll flushes the database, subject to security.flush. When a
+// confirmation is required the first request only returns a token, and
+// repeating it with ?confirm=<token> flushes.
 func (s *HTTPServer) handleFlushAll(w http.ResponseWriter, r *http.Request) {
+	flush := s.db.Config().Security.Flush
+	token, err := s.flushGuard.check(flush, s.isAdmin(r), r.URL.Query().Get("confirm"))
+	if err != nil {
+		if errors.Is(err, errs.ErrNoAuth) {
+			w.Header().Set("WWW-Authenticate", adminChallenge)
+		}
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	if token != "" {
+		s.writeJSON(w, http.StatusPreconditionRequired, map[string]interface{}{
+			"error":         "flush must be confirmed: repeat the request with the confirm_token as ?confirm=",
+			"confirm_token": token,
+			"expires_in":    int64(flushConfirmTTL(flush).Seconds()),
+		})
+		return
+	}
 	s.db.FlushAll()
 	s.writeJSON(w, http.StatusOK, map[string]string{"message": "database flushed"})
 }
 
+// handleHashGetAll returns a hash's fields, or with ?random=<count> (and
+// optionally &withvalues=true) a random sample of them, as HRANDFIELD
+func (s *HTTPServer) handleHashGetAll(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	if random := r.URL.Query().Get("random"); random != "" {
+		count, err := strconv.Atoi(random)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "random must be an integer")
+			return
+		}
+		response := s.hashCommands.HRandField(key, count, r.URL.Query().Get("withvalues") == "true")
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"key":    key,
+			"fields": response.Data,
+		})
+		return
+	}
+
+	response := s.hashCommands.HGetAll(key)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"fields": response.Data,
+	})
+}
+
+func (s *HTTPServer) handleHashSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	var payload struct {
+		Fields map[string]string `json:"fields"`
+	}
+
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+
+	added := 0
+	for field, value := range payload.Fields {
+		response := s.hashCommands.HSet(key, field, value)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		added += response.Data.(int)
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"added": added,
+	})
+}
+
+func (s *HTTPServer) handleHashField(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, field := vars["key"], vars["field"]
+
+	switch r.Method {
+	case "GET":
+		response := s.hashCommands.HGet(key, field)
+		if response.Success {
+			s.writeJSON(w, http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"field": field,
+				"value": response.Data,
+			})
+		} else if response.Error != "" {
+			s.writeFailure(w, response, http.StatusBadRequest)
+		} else {
+			s.writeError(w, http.StatusNotFound, "field not found")
+		}
+
+	case "PUT":
+		// {"nx": true} only sets a field that doesn't exist, as HSETNX
+		var payload struct {
+			Value string `json:"value"`
+			NX    bool   `json:"nx,omitempty"`
+		}
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+		response := s.hashCommands.HSet(key, field, payload.Value)
+		if payload.NX {
+			response = s.hashCommands.HSetNX(key, field, payload.Value)
+		}
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"key":   key,
+			"field": field,
+			"added": response.Data,
+		})
+
+	case "DELETE":
+		response := s.hashCommands.HDel(key, field)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"key":     key,
+			"deleted": response.Data,
+		})
+	}
+}
+
+// handleHashIncr adds {"by": n} (1 by default) to a hash field. An integer
+// increments like HINCRBY, anything with a fraction or exponent, such as
+// 1.5 or 2.0, like HINCRBYFLOAT.
+func (s *HTTPServer) handleHashIncr(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, field := vars["key"], vars["field"]
+
+	var payload struct {
+		By json.Number `json:"by,omitempty"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil && err != io.EOF {
+		s.writeErrorCode(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON payload: "+err.Error())
+		return
+	}
+	if payload.By == "" {
+		payload.By = "1"
+	}
+
+	var response *core.Response
+	if by, err := payload.By.Int64(); err == nil {
+		response = s.hashCommands.HIncrBy(key, field, by)
+	} else if by, err := payload.By.Float64(); err == nil {
+		response = s.hashCommands.HIncrByFloat(key, field, by)
+	} else {
+		s.writeError(w, http.StatusBadRequest, "by must be a number")
+		return
+	}
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"field": field,
+		"value": response.Data,
+	})
+}
+
+// handleSetMembers lists a set's members, or with ?random=n picks members
+// like SRANDMEMBER
+func (s *HTTPServer) handleSetMembers(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	response := s.setCommands.SMembers(key)
+	if random := r.URL.Query().Get("random"); random != "" {
+		count, err := strconv.Atoi(random)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "random must be an integer")
+			return
+		}
+		response = s.setCommands.SRandMember(key, count)
+	}
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"members": response.Data,
+	})
+}
+
+// handleSetAdd adds {"members": [...]} to a set
+func (s *HTTPServer) handleSetAdd(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	var payload struct {
+		Members []string `json:"members"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(len(payload.Members) > 0, "members", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.setCommands.SAdd(key, payload.Members...)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"added": response.Data,
+	})
+}
+
+// handleSetMember checks or removes one member
+func (s *HTTPServer) handleSetMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, member := vars["key"], vars["member"]
+
+	if r.Method == "DELETE" {
+		response := s.setCommands.SRem(key, member)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"key":     key,
+			"removed": response.Data,
+		})
+		return
+	}
+
+	found, err := core.Result[[]bool](s.setCommands.SMIsMember(key, member))
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"member": member,
+		"found":  found[0],
+	})
+}
+
+// handleSetIsMember checks several members at once, like SMISMEMBER:
+// ?member=a&member=b replies "found" in the same order
+func (s *HTTPServer) handleSetIsMember(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	members := r.URL.Query()["member"]
+	v := s.validator()
+	v.check(len(members) > 0, "member", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.setCommands.SMIsMember(key, members...)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"members": members,
+		"found":   response.Data,
+	})
+}
+
+// handleSetPop removes and returns {"count": n} random members, 1 by
+// default
+func (s *HTTPServer) handleSetPop(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	payload := struct {
+		Count int `json:"count"`
+	}{Count: 1}
+	if r.ContentLength != 0 && !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Count >= 0, "count", codeBadRequest, "must not be negative")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.setCommands.SPop(key, payload.Count)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"members": response.Data,
+	})
+}
+
+// handleSetMove moves {"member": m} to the set {"destination": key}
+func (s *HTTPServer) handleSetMove(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	var payload struct {
+		Destination string `json:"destination"`
+		Member      string `json:"member"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.key("destination", payload.Destination)
+	if v.failed(w) {
+		return
+	}
+
+	response := s.setCommands.SMove(key, payload.Destination, payload.Member)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":         key,
+		"destination": payload.Destination,
+		"moved":       response.Data,
+	})
+}
+
+// handleSetInterCard sizes the intersection of ?key=a&key=b sets, like
+// SINTERCARD, stopping at ?limit=n if given
+func (s *HTTPServer) handleSetInterCard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	keys := query["key"]
+	limit := 0
+	v := s.validator()
+	v.check(len(keys) > 0, "key", codeFieldRequired, "is required")
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil && n >= 0, "limit", codeBadRequest, "must be a number, 0 or more")
+		limit = n
+	}
+	if v.failed(w) {
+		return
+	}
+
+	response := s.setCommands.SInterCard(limit, keys...)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":  keys,
+		"count": response.Data,
+	})
+}
+
+// handleListRange returns the items from ?start to ?stop, the whole list
+// by default
+func (s *HTTPServer) handleListRange(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	query := r.URL.Query()
+
+	start, stop := 0, -1
+	v := s.validator()
+	if raw := query.Get("start"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil, "start", codeBadRequest, "must be an integer")
+		start = n
+	}
+	if raw := query.Get("stop"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil, "stop", codeBadRequest, "must be an integer")
+		stop = n
+	}
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LRange(key, start, stop)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"items": response.Data,
+	})
+}
+
+func (s *HTTPServer) handleListLength(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	response := s.listCommands.LLen(key)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"length": response.Data,
+	})
+}
+
+// handleListPush pushes {"values": [...]} onto the head (lpush) or tail
+// (rpush) of a list. {"cap": n} caps the list at n items, like CAP.
+func (s *HTTPServer) handleListPush(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	var payload struct {
+		Values []string `json:"values"`
+		Cap    int      `json:"cap"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(len(payload.Values) > 0, "values", codeFieldRequired, "is required")
+	v.check(payload.Cap >= 0, "cap", codeBadRequest, "must be a number, 0 or more")
+	if v.failed(w) {
+		return
+	}
+
+	push := s.listCommands.RPushCapped
+	if strings.HasSuffix(r.URL.Path, "/lpush") {
+		push = s.listCommands.LPushCapped
+	}
+	response := push(key, payload.Cap, payload.Values...)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"length": response.Data,
+	})
+}
+
+// handleListPop removes and returns {"count": n} items, 1 by default, from
+// the head (lpop) or tail (rpop) of a list
+func (s *HTTPServer) handleListPop(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	payload := struct {
+		Count int `json:"count"`
+	}{Count: 1}
+	if r.ContentLength != 0 && !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Count >= 0, "count", codeBadRequest, "must not be negative")
+	if v.failed(w) {
+		return
+	}
+
+	pop := s.listCommands.RPop
+	if strings.HasSuffix(r.URL.Path, "/lpop") {
+		pop = s.listCommands.LPop
+	}
+	response := pop(key, payload.Count)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"items": response.Data,
+	})
+}
+
+// handleListMPop pops from the first non-empty of {"keys": [...]}, like
+// LMPOP: {"from": "left"} (the default) or "right", and {"count": n}
+func (s *HTTPServer) handleListMPop(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		Keys  []string `json:"keys"`
+		From  string   `json:"from"`
+		Count int      `json:"count"`
+	}{From: "left", Count: 1}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	from := strings.ToLower(payload.From)
+	v := s.validator()
+	v.check(len(payload.Keys) > 0, "keys", codeFieldRequired, "is required")
+	v.check(from == "left" || from == "right", "from", codeBadRequest, "must be left or right")
+	v.check(payload.Count > 0, "count", codeBadRequest, "must be positive")
+	if v.failed(w) {
+		return
+	}
+
+	popped, err := core.Result[commands.PoppedList](s.listCommands.LMPop(payload.Keys, from == "left", payload.Count))
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, popped)
+}
+
+// handleListPos returns the indexes of the items equal to ?element=, like
+// LPOS, with ?rank=, ?count= (0 for every match) and ?maxlen=
+func (s *HTTPServer) handleListPos(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	query := r.URL.Query()
+
+	rank, count, maxLen := 1, 0, 0
+	v := s.validator()
+	v.check(query.Has("element"), "element", codeFieldRequired, "is required")
+	if raw := query.Get("rank"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil && n != 0, "rank", codeBadRequest, "must be an integer other than 0")
+		rank = n
+	}
+	if raw := query.Get("count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil && n >= 0, "count", codeBadRequest, "must be a number, 0 or more")
+		count = n
+	}
+	if raw := query.Get("maxlen"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.check(err == nil && n >= 0, "maxlen", codeBadRequest, "must be a number, 0 or more")
+		maxLen = n
+	}
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LPos(key, query.Get("element"), rank, count, maxLen)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":       key,
+		"positions": response.Data,
+	})
+}
+
+// handleListInsert inserts {"value": v} next to the first item equal to
+// {"pivot": p}, like LINSERT: {"where": "before"} (the default) or
+// "after". The length is -1 if the pivot isn't in the list.
+func (s *HTTPServer) handleListInsert(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	payload := struct {
+		Pivot string  `json:"pivot"`
+		Value *string `json:"value"`
+		Where string  `json:"where"`
+	}{Where: "before"}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	where := strings.ToLower(payload.Where)
+	v := s.validator()
+	v.check(payload.Value != nil, "value", codeFieldRequired, "is required")
+	v.check(where == "before" || where == "after", "where", codeBadRequest, "must be before or after")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LInsert(key, where == "before", payload.Pivot, *payload.Value)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"length": response.Data,
+	})
+}
+
+// handleListRem removes items equal to {"value": v}, like LREM: the first
+// {"count": n} from the head, from the tail for a negative count, or all
+// of them for 0, the default
+func (s *HTTPServer) handleListRem(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	var payload struct {
+		Value *string `json:"value"`
+		Count int     `json:"count"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Value != nil, "value", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LRem(key, payload.Count, *payload.Value)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"removed": response.Data,
+	})
+}
+
+// handleListSet replaces the item at the index in the path with
+// {"value": v}, like LSET. Negative indexes count from the tail.
+func (s *HTTPServer) handleListSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	var payload struct {
+		Value *string `json:"value"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	index, err := strconv.Atoi(vars["index"])
+	v := s.validator()
+	v.check(err == nil, "index", codeBadRequest, "must be an integer")
+	v.check(payload.Value != nil, "value", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LSet(key, index, *payload.Value)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"index": index,
+	})
+}
+
+// handleListTrim keeps only the items from {"start": n} to {"stop": m},
+// both included, like LTRIM, and replies the length left
+func (s *HTTPServer) handleListTrim(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	var payload struct {
+		Start *int `json:"start"`
+		Stop  *int `json:"stop"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Start != nil, "start", codeFieldRequired, "is required")
+	v.check(payload.Stop != nil, "stop", codeFieldRequired, "is required")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.listCommands.LTrim(key, *payload.Start, *payload.Stop)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"length": response.Data,
+	})
+}
+
+func (s *HTTPServer) handleIndexList(w http.ResponseWriter, r *http.Request) {
+	response := s.indexCommands.List()
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"indexes": response.Data,
+	})
+}
+
+func (s *HTTPServer) handleIndexCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+		Field   string `json:"field"`
+	}
+
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.Name == "" || payload.Pattern == "" || payload.Field == "" {
+		s.writeError(w, http.StatusBadRequest, "name, pattern and field are required")
+		return
+	}
+
+	response := s.indexCommands.Create(payload.Name, payload.Pattern, payload.Field)
+	if response.Success {
+		s.writeJSON(w, http.StatusCreated, map[string]string{"message": "index created"})
+	} else {
+		s.writeFailure(w, response, http.StatusConflict)
+	}
+}
+
+func (s *HTTPServer) handleIndexDrop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	response := s.indexCommands.Drop(name)
+	if response.Success {
+		s.writeJSON(w, http.StatusOK, map[string]string{"message": "index dropped"})
+	} else {
+		s.writeFailure(w, response, http.StatusNotFound)
+	}
+}
+
+func (s *HTTPServer) handleAggregateList(w http.ResponseWriter, r *http.Request) {
+	response := s.aggregateCommands.List()
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"aggregates": response.Data,
+	})
+}
+
+// handleAggregateCreate declares an aggregate from {"name", "kind",
+// "pattern", "field"}, where field is only set for hashes
+func (s *HTTPServer) handleAggregateCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name    string `json:"name"`
+		Kind    string `json:"kind"`
+		Pattern string `json:"pattern"`
+		Field   string `json:"field"`
+	}
+
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.Name != "", "name", codeFieldRequired, "is required")
+	v.check(payload.Pattern != "", "pattern", codeFieldRequired, "is required")
+	_, err := core.ParseAggregateKind(payload.Kind)
+	v.check(err == nil, "kind", codeValidation, "must be count, sum, min or max")
+	if v.failed(w) {
+		return
+	}
+
+	response := s.aggregateCommands.Create(payload.Name, payload.Kind, payload.Pattern, payload.Field)
+	if response.Success {
+		s.writeJSON(w, http.StatusCreated, map[string]string{"message": "aggregate created"})
+	} else {
+		s.writeFailure(w, response, http.StatusConflict)
+	}
+}
+
+func (s *HTTPServer) handleAggregateDrop(w http.ResponseWriter, r *http.Request) {
+	response := s.aggregateCommands.Drop(mux.Vars(r)["name"])
+	if response.Success {
+		s.writeJSON(w, http.StatusOK, map[string]string{"message": "aggregate dropped"})
+	} else {
+		s.writeFailure(w, response, http.StatusNotFound)
+	}
+}
+
+// handleIndexFind accepts one predicate: ?eq=, ?min=&max=, or ?gt=/gte=/lt=/lte=
+func (s *HTTPServer) handleIndexFind(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	query := r.URL.Query()
+
+	var response *core.Response
+	switch {
+	case query.Has("eq"):
+		response = s.indexCommands.Find(name, "EQ", []string{query.Get("eq")})
+	case query.Has("min") || query.Has("max"):
+		min, max := query.Get("min"), query.Get("max")
+		if min == "" {
+			min = "-inf"
+		}
+		if max == "" {
+			max = "+inf"
+		}
+		response = s.indexCommands.Find(name, "RANGE", []string{min, max})
+	case query.Has("gt"):
+		response = s.indexCommands.Find(name, "GT", []string{query.Get("gt")})
+	case query.Has("gte"):
+		response = s.indexCommands.Find(name, "GTE", []string{query.Get("gte")})
+	case query.Has("lt"):
+		response = s.indexCommands.Find(name, "LT", []string{query.Get("lt")})
+	case query.Has("lte"):
+		response = s.indexCommands.Find(name, "LTE", []string{query.Get("lte")})
+	default:
+		s.writeError(w, http.StatusBadRequest, "missing predicate: use eq, min/max, gt, gte, lt or lte")
+		return
+	}
+
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+
+	keys := response.Data.([]string)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"index": name,
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// handleGraphQL serves GraphQL over HTTP: POST with a JSON body or an
+// application/graphql query, or GET with query, operationName and variables
+// parameters. GET requests cannot run mutations.
+func (s *HTTPServer) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if s.graphql == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "GraphQL is unavailable")
+		return
+	}
+	
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		req.ReadOnly = true
+		if variables := params.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				s.writeError(w, http.StatusBadRequest, "variables must be a JSON object")
+				return
+			}
+		}
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Query = string(body)
+	} else if !s.decodeJSON(w, r, &req) {
+	return
+}
+	if req.Query == "" {
+		s.writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	
+	if s.db.Config().Server.ReadOnly {
+		req.ReadOnly = true
+	}
+	req.Hidden = s.graphqlFieldDisabled
+	
+	ctx, cancel := s.commandContext(r)
+	defer cancel()
+	req.Context = ctx
+	s.writeJSON(w, http.StatusOK, s.graphql.Execute(req))
+}
+
+// handleQuery runs a SELECT query over hashes, given as ?q= or as a JSON
+// body {"query": "..."}
+func (s *HTTPServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	text := r.URL.Query().Get("q")
+	if r.Method == http.MethodPost {
+		var payload struct {
+			Query string `json:"query"`
+		}
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+		text = payload.Query
+	}
+	if text == "" {
+		s.writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	query, err := core.ParseQuery(text)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx, cancel := s.commandContext(r)
+	defer cancel()
+	result, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func (s *HTTPServer) handleSearchList(w http.ResponseWriter, r *http.Request) {
+	response := s.searchCommands.List()
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"indexes": response.Data,
+	})
+}
+
+func (s *HTTPServer) handleSearchCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name    string   `json:"name"`
+		Pattern string   `json:"pattern"`
+		Fields  []string `json:"fields,omitempty"`
+		Stem    bool     `json:"stem,omitempty"`
+	}
+
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.Name == "" || payload.Pattern == "" {
+		s.writeError(w, http.StatusBadRequest, "name and pattern are required")
+		return
+	}
+
+	response := s.searchCommands.Create(payload.Name, payload.Pattern, payload.Fields, payload.Stem)
+	if response.Success {
+		s.writeJSON(w, http.StatusCreated, map[string]string{"message": "search index created"})
+	} else {
+		s.writeFailure(w, response, http.StatusConflict)
+	}
+}
+
+func (s *HTTPServer) handleSearchQuery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	query := r.URL.Query()
+
+	offset, limit := 0, 10
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+		offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	result, err := core.Result[commands.SearchResult](s.searchCommands.Search(name, query.Get("q"), offset, limit))
+	if err != nil {
+		s.writeClassified(w, err, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"index": name,
+		"query": query.Get("q"),
+		"total": result.Total,
+		"hits":  result.Hits,
+	})
+}
+
+func (s *HTTPServer) handleSearchDrop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	response := s.searchCommands.Drop(name)
+	if response.Success {
+		s.writeJSON(w, http.StatusOK, map[string]string{"message": "search index dropped"})
+	} else {
+		s.writeFailure(w, response, http.StatusNotFound)
+	}
+}
+
+// handleLock acquires (POST), extends (PUT), releases (DELETE) or inspects (GET) a lock
+func (s *HTTPServer) handleLock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	if r.Method == "GET" {
+		response := s.lockCommands.Owner(key)
+		if !response.Success {
+			s.writeError(w, http.StatusNotFound, "lock not held")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"key":   key,
+			"owner": response.Data,
+			"ttl":   s.db.GetTTL(key),
+		})
+		return
+	}
+
+	var payload struct {
+		Owner string `json:"owner"`
+		TTLMs int64  `json:"ttl_ms,omitempty"`
+	}
+
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.Owner == "" {
+		s.writeError(w, http.StatusBadRequest, "owner is required")
+		return
+	}
+
+	ttl := time.Duration(payload.TTLMs) * time.Millisecond
+	var response *core.Response
+	switch r.Method {
+	case "POST":
+		response = s.lockCommands.Acquire(key, payload.Owner, ttl)
+	case "PUT":
+		response = s.lockCommands.Extend(key, payload.Owner, ttl)
+	case "DELETE":
+		response = s.lockCommands.Release(key, payload.Owner)
+	}
+
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	if !response.Data.(bool) {
+		s.writeError(w, http.StatusConflict, "lock is held by another owner")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   key,
+		"owner": payload.Owner,
+		"ok":    true,
+	})
+}
+
+// handleLease reads, acquires or renews (PUT) and releases (DELETE) a
+// leader election lease. PUT answers 409 with the record if another
+// holder has the lease.
+func (s *HTTPServer) handleLease(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if r.Method == "GET" {
+		response := s.leaseCommands.Get(key)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, response.Data)
+		return
+	}
+
+	var payload struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int64  `json:"leaseDurationSeconds"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	v := s.validator()
+	v.check(payload.HolderIdentity != "", "holderIdentity", codeFieldRequired, "is required")
+	v.check(r.Method != "PUT" || payload.LeaseDurationSeconds > 0, "leaseDurationSeconds", codeTTLInvalid, "must be a positive number of seconds")
+	if v.failed(w) {
+		return
+	}
+
+	if r.Method == "DELETE" {
+		response := s.leaseCommands.Release(key, payload.HolderIdentity)
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"released": response.Data})
+		return
+	}
+
+	response := s.leaseCommands.Acquire(key, payload.HolderIdentity, time.Duration(payload.LeaseDurationSeconds)*time.Second)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	record := response.Data.(commands.LeaderElectionRecord)
+	status := http.StatusOK
+	if record.HolderIdentity != payload.HolderIdentity {
+		status = http.StatusConflict
+	}
+	s.writeJSON(w, status, record)
+}
+
+// handleStatsKeys lists per-key statistics: ?sort=hits|misses|writes|idle&prefix=&limit=
+func (s *HTTPServer) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Algorithm string `json:"algorithm"` // "token_bucket" (default) or "sliding_window"
+		Burst     int64  `json:"burst"`
+		Rate      int64  `json:"rate"`
+		Limit     int64  `json:"limit"`
+		Period    int64  `json:"period"` // Seconds
+		Quantity  *int64 `json:"quantity"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	key := mux.Vars(r)["key"]
+	quantity := int64(1)
+	if payload.Quantity != nil {
+		quantity = *payload.Quantity
+	}
+	period := time.Duration(payload.Period) * time.Second
+	
+	var response *core.Response
+	switch payload.Algorithm {
+	case "", "token_bucket":
+		response = s.rateLimitCommands.Throttle(key, payload.Burst, payload.Rate, period, quantity)
+	case "sliding_window":
+		response = s.rateLimitCommands.SlidingWindow(key, payload.Limit, period, quantity)
+	default:
+		s.writeError(w, http.StatusBadRequest, "algorithm must be token_bucket or sliding_window")
+		return
+	}
+	result, err := core.Result[commands.RateLimitResult](response)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(result.ResetAfter, 10))
+	status := http.StatusOK
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.FormatInt(result.RetryAfter, 10))
+		status = http.StatusTooManyRequests
+	}
+	s.writeJSON(w, status, result)
+}
+
+func (s *HTTPServer) handleQueueList(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.queueCommands.List().Data)
+}
+
+func (s *HTTPServer) handleQueueStats(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.queueCommands.Stats(mux.Vars(r)["name"]).Data)
+}
+
+func (s *HTTPServer) handleQueuePurge(w http.ResponseWriter, r *http.Request) {
+	if !s.queueCommands.Purge(mux.Vars(r)["name"]).Data.(bool) {
+		s.writeError(w, http.StatusNotFound, "queue not found")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]bool{"purged": true})
+}
+
+func (s *HTTPServer) handleQueueEnqueue(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Payload     string `json:"payload"`
+		Delay       int64  `json:"delay"` // Seconds
+		MaxAttempts int    `json:"max_attempts"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	response := s.queueCommands.Enqueue(mux.Vars(r)["name"], payload.Payload, time.Duration(payload.Delay)*time.Second, payload.MaxAttempts)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, response.Data)
+}
+
+func (s *HTTPServer) handleQueueDequeue(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		VisibilityTimeout int64 `json:"visibility_timeout"` // Seconds
+	}
+	if r.ContentLength != 0 {
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+	}
+	
+	response := s.queueCommands.Dequeue(mux.Vars(r)["name"], time.Duration(payload.VisibilityTimeout)*time.Second)
+	if response.Data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+// handleQueueAck serves both ack and nack, told apart by the last path segment
+func (s *HTTPServer) handleQueueAck(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Receipt string `json:"receipt"`
+		Delay   int64  `json:"delay"` // Seconds before a nacked job is retried
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	vars := mux.Vars(r)
+	var response *core.Response
+	if strings.HasSuffix(r.URL.Path, "/nack") {
+		response = s.queueCommands.Nack(vars["name"], vars["id"], payload.Receipt, time.Duration(payload.Delay)*time.Second)
+	} else {
+		response = s.queueCommands.Ack(vars["name"], vars["id"], payload.Receipt)
+	}
+	
+	switch {
+	case response.Success:
+		s.writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case errors.Is(response.Err, core.ErrInvalidReceipt):
+		s.writeFailure(w, response, http.StatusConflict)
+	default:
+		s.writeFailure(w, response, http.StatusBadRequest)
+	}
+}
+
+func (s *HTTPServer) handleLeaderboardTop(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, 10
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+		offset = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	
+	response := s.leaderboardCommands.Top(mux.Vars(r)["name"], offset, limit)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleLeaderboardRecord(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Member string  `json:"member"`
+		Score  float64 `json:"score"`
+		Mode   string  `json:"mode"` // "set" (default), "incr" or "best"
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	response := s.leaderboardCommands.Record(mux.Vars(r)["name"], payload.Member, payload.Score, payload.Mode)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleLeaderboardRank(w http.ResponseWriter, r *http.Request) {
+	around := 0
+	if v := r.URL.Query().Get("around"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid around")
+			return
+		}
+		around = n
+	}
+	
+	vars := mux.Vars(r)
+	response := s.leaderboardCommands.Rank(vars["name"], vars["member"], around)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleLeaderboardRemove(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	response := s.leaderboardCommands.Remove(vars["name"], vars["member"])
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	if !response.Data.(bool) {
+		s.writeError(w, http.StatusNotFound, "member not found")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]bool{"removed": true})
+}
+
+func (s *HTTPServer) handleLeaderboardReset(w http.ResponseWriter, r *http.Request) {
+	reset := s.leaderboardCommands.Reset(mux.Vars(r)["name"]).Data.(bool)
+	s.writeJSON(w, http.StatusOK, map[string]bool{"reset": reset})
+}
+
+func (s *HTTPServer) handleLeaderboardSchedule(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Reset string `json:"reset"` // hourly, daily, weekly, monthly or none
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	response := s.leaderboardCommands.Schedule(mux.Vars(r)["name"], payload.Reset)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]string{"reset": payload.Reset})
+}
+
+func (s *HTTPServer) handleCounterIncr(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		Amount    int64 `json:"amount"`
+		Timestamp int64 `json:"timestamp"` // Unix seconds, defaults to now
+	}{Amount: 1}
+	if r.ContentLength != 0 {
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+	}
+	
+	var at time.Time
+	if payload.Timestamp != 0 {
+		at = time.Unix(payload.Timestamp, 0)
+	}
+	response := s.counterCommands.Incr(mux.Vars(r)["name"], payload.Amount, at)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+// handleCounterRange returns a series, by default the last 24 hourly buckets
+func (s *HTTPServer) handleCounterRange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	resolution := query.Get("resolution")
+	if resolution == "" {
+		resolution = "hour"
+	}
+	
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid to")
+			return
+		}
+		to = time.Unix(n, 0)
+	}
+	from := to.Add(-23 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid from")
+			return
+		}
+		from = time.Unix(n, 0)
+	}
+	
+	response := s.counterCommands.Range(mux.Vars(r)["name"], resolution, from, to)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+// handleCRDTState returns a CRDT's state for merging into another replica
+func (s *HTTPServer) handleCRDTState(w http.ResponseWriter, r *http.Request) {
+	response := s.crdtCommands.State(mux.Vars(r)["key"])
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+// handleCRDTMerge merges the CRDT writes in a batch of change events, as
+// posted by another server's CDC webhook sink. Other events are skipped, so
+// the sink needs no filtering beyond the keys to replicate.
+func (s *HTTPServer) handleCRDTMerge(w http.ResponseWriter, r *http.Request) {
+	var events []struct {
+		Op    core.ChangeOp   `json:"op"`
+		Key   string          `json:"key"`
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	if !s.decodeJSON(w, r, &events) {
+		return
+	}
+	
+	merged, changed, skipped := 0, 0, 0
+	for _, event := range events {
+		if event.Op != core.ChangeSet || (event.Type != core.PNCOUNTER.String() && event.Type != core.ORSET.String()) {
+			skipped++
+			continue
+		}
+		response := s.crdtCommands.Merge(event.Key, commands.CRDTState{Type: event.Type, Value: event.Value})
+		if !response.Success {
+			s.writeFailure(w, response, http.StatusBadRequest)
+			return
+		}
+		merged++
+		if response.Data.(bool) {
+			changed++
+		}
+	}
+	s.writeJSON(w, http.StatusOK, map[string]int{
+		"merged":  merged,
+		"changed": changed,
+		"skipped": skipped,
+	})
+}
+
+// handleSync pushes an embedded instance's local changes and returns what
+// changed in its scopes since its cursor
+func (s *HTTPServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	var request offline.Request
+	if !s.decodeJSON(w, r, &request) {
+		return
+	}
+	if len(request.Scopes) == 0 {
+		s.writeError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	
+	response, err := s.syncHub.Sync(request)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *HTTPServer) handleWebhookList(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": s.webhooks.List(),
+		"dropped":  s.webhooks.Dropped(),
+	})
+}
+
+func (s *HTTPServer) handleWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Pattern string   `json:"pattern"`
+		URL     string   `json:"url"`
+		Events  []string `json:"events"`
+		Secret  string   `json:"secret"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	hook, err := s.webhooks.Add(payload.Pattern, payload.URL, payload.Events, payload.Secret)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, hook)
+}
+
+func (s *HTTPServer) handleWebhookGet(w http.ResponseWriter, r *http.Request) {
+	hook, err := s.webhooks.Get(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, hook)
+}
+
+func (s *HTTPServer) handleWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.Remove(mux.Vars(r)["id"]); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+func (s *HTTPServer) handleScheduleList(w http.ResponseWriter, r *http.Request) {
+	tasks, err := core.Result[[]commands.ScheduledTask](s.scheduleCommands.List())
+	if err != nil {
+		s.writeClassified(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, tasks)
+}
+
+// handleScheduleCreate schedules a command to run once, at a unix time or
+// in a number of seconds, or on a cron expression
+func (s *HTTPServer) handleScheduleCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID      string   `json:"id"`
+		Command []string `json:"command"`
+		At      int64    `json:"at"`
+		In      int64    `json:"in"`
+		Cron    string   `json:"cron"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	
+	when := 0
+	for _, set := range []bool{payload.At != 0, payload.In != 0, payload.Cron != ""} {
+		if set {
+			when++
+		}
+	}
+	validator := s.validator()
+	validator.check(payload.ID != "", "id", codeFieldRequired, "is required")
+	validator.check(len(payload.Command) > 0 && payload.Command[0] != "", "command", codeFieldRequired, "is required")
+	validator.check(len(payload.Command) == 0 || !strings.EqualFold(payload.Command[0], "AUTH"), "command", codeBadRequest, "can't be AUTH")
+	validator.check(when == 1, "at", codeBadRequest, "exactly one of at, in and cron is required")
+	validator.check(payload.In >= 0, "in", codeBadRequest, "must be a positive number of seconds")
+	if validator.failed(w) {
+		return
+	}
+	
+	var response *core.Response
+	switch {
+	case payload.Cron != "":
+		response = s.scheduleCommands.Cron(payload.ID, payload.Cron, payload.Command)
+	case payload.In != 0:
+		response = s.scheduleCommands.At(payload.ID, time.Now().Add(time.Duration(payload.In)*time.Second), payload.Command)
+	default:
+		response = s.scheduleCommands.At(payload.ID, time.Unix(payload.At, 0), payload.Command)
+	}
+	task, err := core.Result[commands.ScheduledTask](response)
+	if err != nil {
+		s.writeClassified(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, task)
+}
+
+func (s *HTTPServer) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	response := s.scheduleCommands.Remove(mux.Vars(r)["id"])
+	if !response.Success {
+		s.writeClassified(w, response.Failure(), http.StatusInternalServerError, response.Error)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+// defaultSessionTTL applies when a session is created without a ttl
+const defaultSessionTTL = 3600
+
+func (s *HTTPServer) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID string            `json:"user_id"`
+		Data   map[string]string `json:"data"`
+		TTL    int64             `json:"ttl"`
+	}
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.TTL == 0 {
+		payload.TTL = defaultSessionTTL
+	}
+	
+	response := s.sessionCommands.Create(payload.UserID, payload.Data, payload.TTL)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, response.Data)
+}
+
+func (s *HTTPServer) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		s.writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	
+	response := s.sessionCommands.ListByUser(userID)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	
+	var response *core.Response
+	switch r.Method {
+	case "GET":
+		response = s.sessionCommands.Get(id)
+	case "PUT":
+		var payload struct {
+			Data map[string]string `json:"data"`
+		}
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+		response = s.sessionCommands.Save(id, payload.Data)
+	case "DELETE":
+		response = s.sessionCommands.Destroy(id)
+		if !response.Data.(bool) {
+			s.writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]bool{"destroyed": true})
+		return
+	}
+	
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleSessionTouch(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TTL int64 `json:"ttl"`
+	}
+	if r.ContentLength != 0 {
+		if !s.decodeJSON(w, r, &payload) {
+			return
+		}
+	}
+	if payload.TTL == 0 {
+		payload.TTL = defaultSessionTTL
+	}
+	
+	response := s.sessionCommands.Touch(mux.Vars(r)["id"], payload.TTL)
+	if !response.Success {
+		s.writeFailure(w, response, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response.Data)
+}
+
+func (s *HTTPServer) handleStatsKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = "hits"
+	case "hits", "misses", "writes", "idle":
+	default:
+		s.writeError(w, http.StatusBadRequest, "invalid sort, use hits, misses, writes or idle")
+		return
+	}
+
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	keys := s.db.TopKeys(query.Get("prefix"), sortBy, limit)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sort":  sortBy,
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+func (s *HTTPServer) handleStatsKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	stats, exists := s.db.KeyStats(key)
+	if !exists {
+		s.writeError(w, http.StatusNotFound, "no statistics for key")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *HTTPServer) handleStatsPrefixes(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"prefixes": s.db.PrefixStats(),
+	})
+}
+
+func (s *HTTPServer) handleProxyPurge(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Get("url") != "":
+		purged := 0
+		if s.db.Delete(proxy.CacheKey(query.Get("url"))) {
+			purged = 1
+		}
+		s.writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	case query.Get("prefix") != "":
+		pattern := core.EscapePattern(proxy.CacheKey(query.Get("prefix"))) + "*"
+		s.writeJSON(w, http.StatusOK, map[string]int{"purged": s.db.DeletePattern(pattern, 0)})
+	default:
+		s.writeError(w, http.StatusBadRequest, "url or prefix is required")
+	}
+}
+
+func (s *HTTPServer) handleConfigGet(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	s.writeJSON(w, http.StatusOK, s.config.Get(pattern))
+}
+
+// handleConfigSet applies a JSON object of parameter names to values.
+// Parameters are applied in order and the first failure stops the update.
+func (s *HTTPServer) handleConfigSet(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]string
+	if !s.decodeJSON(w, r, &payload) {
+		return
+	}
+
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := s.config.Set(name, payload[name]); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	s.writeJSON(w, http.StatusOK, s.config.Get("*"))
+}
+
+func (s *HTTPServer) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.config.Reload(); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]string{"message": "configuration reloaded"})
+}
+
+func (s *HTTPServer) handleSnapshotList(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := s.db.Snapshots()
+	if err != nil {
+		s.writeClassified(w, err, persistenceStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"snapshots": snapshots})
+}
+
+func (s *HTTPServer) handleSnapshotSave(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Save(); err != nil {
+		s.writeClassified(w, err, persistenceStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]string{"message": "snapshot saved"})
+}
+
+// handleRestore replaces the keyspace with the newest snapshot taken at or
+// before "to" (RFC 3339 or Unix seconds)
+func (s *HTTPServer) handleRestore(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		To string `json:"to"`
+	}
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	to, err := core.ParseRestoreTime(req.To)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	snapshot, err := s.db.RestoreTo(to)
+	if err != nil {
+		s.writeClassified(w, err, persistenceStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "keyspace restored",
+		"snapshot": snapshot,
+	})
+}
+
 // Utility functions
 func (s *HTTPServer) writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -357,6 +3269,45 @@ func (s *HTTPServer) writeJSON(w http.ResponseWriter, status int, data interface
 	json.NewEncoder(w).Encode(data)
 }
 
+// formatETag renders a value version as a strong entity tag
+func formatETag(version uint64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// parseETag extracts the version from an entity tag, accepting weak tags
+func parseETag(tag string) (uint64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	tag = strings.Trim(tag, "\"")
+	version, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// notModified reports whether a conditional read can be answered with 304.
+// If-None-Match takes precedence over If-Modified-Since, which has second
+// precision like Last-Modified.
+func notModified(r *http.Request, version uint64, updatedAt time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			if strings.TrimSpace(tag) == "*" {
+				return true
+			}
+			if tagged, ok := parseETag(tag); ok && tagged == version {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !updatedAt.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// writeError replies with an error whose code follows from its status
 func (s *HTTPServer) writeError(w http.ResponseWriter, status int, message string) {
-	s.writeJSON(w, status, map[string]string{"error": message})
+	s.writeErrorCode(w, status, statusCode(status), message)
 }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// commandFamilies groups TCP commands for latency summaries. Commands with
+// a module prefix (BF., IDX., ...) are grouped by prefix in commandFamily.
+var commandFamilies = map[string]string{
+	"SET": "string", "GET": "string", "MSET": "string", "MSETNX": "string", "SETNULL": "string", "MGET": "string", "GETEX": "string", "GETDEL": "string", "GETSTALE": "string", "FETCH": "string", "FILL": "string", "ABANDON": "string", "GETREV": "string",
+	"CAS": "string", "INCR": "string", "DECR": "string", "APPEND": "string", "STRLEN": "string", "GETRANGE": "string",
+
+	"HSET": "hash", "HGET": "hash", "HDEL": "hash", "HGETALL": "hash",
+	"HINCRBY": "hash", "HINCRBYFLOAT": "hash", "HSETNX": "hash", "HRANDFIELD": "hash",
+
+	"SADD": "set", "SREM": "set", "SMEMBERS": "set", "SCARD": "set", "SISMEMBER": "set",
+	"SMISMEMBER": "set", "SRANDMEMBER": "set", "SPOP": "set", "SMOVE": "set", "SINTERCARD": "set",
+
+	"LPUSH": "list", "RPUSH": "list", "LPOP": "list", "RPOP": "list", "LLEN": "list", "LRANGE": "list", "LMPOP": "list",
+	"LPOS": "list", "LINSERT": "list", "LREM": "list", "LSET": "list", "LTRIM": "list",
+
+	"DEL": "keyspace", "EXISTS": "keyspace", "KEYS": "keyspace", "SCAN": "keyspace", "TTL": "keyspace", "EXPIRE": "keyspace",
+	"DELPATTERN": "keyspace", "SWAP": "keyspace", "PARTITION": "keyspace", "EXPIREPATTERN": "keyspace", "PERSISTPATTERN": "keyspace", "BULKJOB": "keyspace",
+	"RESTOREKEY": "keyspace", "UNDELETE": "keyspace", "VERSION": "keyspace", "OBJECT": "keyspace",
+	"KEYSTATS": "keyspace", "FLUSHALL": "keyspace", "DBSIZE": "keyspace", "PIPELINE": "keyspace",
+	"TAG": "keyspace", "UNTAG": "keyspace", "TAGS": "keyspace", "TAGKEYS": "keyspace", "INVALIDATETAG": "keyspace",
+	"DEPEND": "keyspace", "UNDEPEND": "keyspace", "DEPENDENCIES": "keyspace", "DEPENDENTS": "keyspace",
+
+	"FIND": "index", "QUERY": "index",
+
+	"LOCK": "lock", "LOCKEXTEND": "lock", "UNLOCK": "lock", "LEASE": "lock",
+
+	"CL.THROTTLE": "ratelimit", "RL.SLIDING": "ratelimit",
+
+	"ENQUEUE": "queue", "DEQUEUE": "queue", "ACK": "queue", "NACK": "queue", "QSTATS": "queue", "QPURGE": "queue",
+
+	"LBADD": "leaderboard", "LBRANK": "leaderboard", "LBTOP": "leaderboard", "LBREM": "leaderboard",
+	"LBRESET": "leaderboard", "LBSCHEDULE": "leaderboard",
+
+	"ZADD": "zset", "ZUNIONSTORE": "zset", "ZINTERSTORE": "zset",
+	"ZPOPMIN": "zset", "ZPOPMAX": "zset", "BZPOPMIN": "zset", "ZMPOP": "zset", "ZRANGEBYLEX": "zset",
+	"ZREMRANGEBYSCORE": "zset", "ZREMRANGEBYRANK": "zset", "ZREMRANGEBYLEX": "zset",
+
+	"CINCR": "counter", "CRANGE": "counter",
+
+	"SCHEDULE": "schedule",
+}
+
+// commandFamilyPrefixes maps module-style command prefixes to families
+var commandFamilyPrefixes = map[string]string{
+	"BF.":   "sketch",
+	"CMS.":  "sketch",
+	"CF.":   "sketch",
+	"PN.":   "crdt",
+	"OR.":   "crdt",
+	"CRDT.": "crdt",
+	"IDX.":  "index",
+	"AGG.":  "index",
+	"FT.":   "search",
+}
+
+// commandFamily returns the latency summary group of a command. Anything
+// not listed (PING, INFO, CONFIG, ...) is a server command.
+func commandFamily(command string) string {
+	if family, exists := commandFamilies[command]; exists {
+		return family
+	}
+	if dot := strings.IndexByte(command, '.'); dot > 0 {
+		if family, exists := commandFamilyPrefixes[command[:dot+1]]; exists {
+			return family
+		}
+	}
+	return "server"
+}
+
+// formatLatencyLatest replies one [event, time, latest, max] array per
+// command, like Redis's LATENCY LATEST
+func formatLatencyLatest(latest []utils.LatencyLatest) string {
+	w := newReply()
+	w.ArrayHeader(len(latest))
+	for _, l := range latest {
+		w.ArrayHeader(4)
+		w.Bulk(l.Event)
+		w.Int(l.Time)
+		w.Int(l.Latency)
+		w.Int(l.Max)
+	}
+	return finishReply(w)
+}
+
+// formatLatencyHistory replies one [time, latency] array per spike
+func formatLatencyHistory(spikes []utils.LatencySpike) string {
+	w := newReply()
+	w.ArrayHeader(len(spikes))
+	for _, spike := range spikes {
+		w.ArrayHeader(2)
+		w.Int(spike.Time)
+		w.Int(spike.Latency)
+	}
+	return finishReply(w)
+}
@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nitrix4ly/triff/client"
+	"github.com/nitrix4ly/triff/testutil"
+)
+
+func TestTCPListCommands(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true})
+	c := srv.Client()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"RPUSH", "l", "a", "b", "c", "b"}, "4"},
+		{[]string{"LPOS", "l", "b"}, "1"},
+		{[]string{"LPOS", "l", "b", "RANK", "-1", "COUNT", "0"}, "[3 1]"},
+		{[]string{"LINSERT", "l", "AFTER", "a", "z"}, "5"},
+		{[]string{"LINSERT", "l", "BEFORE", "x", "y"}, "-1"},
+		{[]string{"LREM", "l", "-1", "b"}, "1"},
+		{[]string{"LSET", "l", "-1", "d"}, "OK"},
+		{[]string{"LRANGE", "l", "0", "-1"}, "[a z b d]"},
+		{[]string{"LTRIM", "l", "1", "2"}, "OK"},
+		{[]string{"LRANGE", "l", "0", "-1"}, "[z b]"},
+	}
+	for _, tt := range tests {
+		got, err := c.Do(tt.args...)
+		if err != nil || fmt.Sprint(got) != tt.want {
+			t.Fatalf("%q = %v (%v), want %s", tt.args, got, err, tt.want)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if n, err := c.Do("LPUSH", "feed", fmt.Sprint(i), "CAP", "2"); err != nil || n != int64(min(i+1, 2)) {
+			t.Fatalf("LPUSH CAP #%d = %v (%v)", i, n, err)
+		}
+	}
+	if n, err := c.Do("RPUSH", "feed", "x"); err != nil || n != int64(2) {
+		t.Fatalf("RPUSH onto a capped list = %v (%v), want 2", n, err)
+	}
+	if items, err := c.Do("LRANGE", "feed", "0", "-1"); err != nil || fmt.Sprint(items) != "[2 x]" {
+		t.Fatalf("capped list = %v (%v), want [2 x]", items, err)
+	}
+	if _, err := c.Do("LPUSH", "feed", "v", "CAP", "-1"); err == nil {
+		t.Error("LPUSH with a negative CAP succeeded")
+	}
+
+	if _, err := c.Do("LPOS", "l", "x"); err != client.ErrNil {
+		t.Errorf("LPOS of a missing item returned %v, want a nil reply", err)
+	}
+	if _, err := c.Do("LSET", "missing", "0", "v"); err == nil {
+		t.Error("LSET on a missing key succeeded")
+	}
+	if _, err := c.Do("LINSERT", "l", "AROUND", "z", "v"); err == nil {
+		t.Error("LINSERT AROUND succeeded")
+	}
+}
+
+func TestHTTPListCommands(t *testing.T) {
+	srv := testutil.StartWithOptions(t, testutil.Options{HTTP: true})
+	requests := []struct {
+		method, path, body string
+		want               string
+	}{
+		{"POST", "/api/v1/list/l/rpush", `{"values": ["a", "b", "c", "b"]}`, `"length":4`},
+		{"GET", "/api/v1/list/l/pos?element=b", "", `"positions":[1,3]`},
+		{"GET", "/api/v1/list/l/pos?element=b&rank=-1&count=1", "", `"positions":[3]`},
+		{"POST", "/api/v1/list/l/insert", `{"pivot": "a", "value": "z", "where": "after"}`, `"length":5`},
+		{"POST", "/api/v1/list/l/rem", `{"value": "b", "count": -1}`, `"removed":1`},
+		{"PUT", "/api/v1/list/l/items/-1", `{"value": "d"}`, `"index":-1`},
+		{"POST", "/api/v1/list/l/trim", `{"start": 1, "stop": 2}`, `"length":2`},
+		{"GET", "/api/v1/list/l", "", `"items":["z","b"]`},
+		{"POST", "/api/v1/list/feed/rpush", `{"values": ["a", "b", "c"], "cap": 2}`, `"length":2`},
+		{"POST", "/api/v1/list/feed/rpush", `{"values": ["d"]}`, `"length":2`},
+		{"GET", "/api/v1/list/feed", "", `"items":["c","d"]`},
+	}
+	for _, rr := range requests {
+		status, body := do(t, rr.method, srv.URL(rr.path), rr.body)
+		if status != http.StatusOK || !bytes.Contains(body, []byte(rr.want)) {
+			t.Fatalf("%s %s returned %d %s, want %s", rr.method, rr.path, status, body, rr.want)
+		}
+	}
+
+	if status, _ := do(t, "PUT", srv.URL("/api/v1/list/missing/items/0"), `{"value": "v"}`); status != http.StatusNotFound {
+		t.Errorf("setting an item of a missing list returned %d, want 404", status)
+	}
+	if status, _ := do(t, "POST", srv.URL("/api/v1/list/l/trim"), `{"start": 0}`); status != http.StatusBadRequest {
+		t.Errorf("trimming without a stop returned %d, want 400", status)
+	}
+}
+
+// do sends a request with a JSON body, unless it is empty, and returns the
+// status and body of the response
+func do(t *testing.T, method, url, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// maintenanceCommands are the TCP commands served in maintenance mode, to
+// admin clients only
+var maintenanceCommands = map[string]bool{
+	"PING":       true,
+	"INFO":       true,
+	"DBSIZE":     true,
+	"CONFIG":     true,
+	"LATENCY":    true,
+	"CIRCUIT":    true,
+	"MEMORY":     true,
+	"SAVE":       true,
+	"RESTORE":    true,
+	"FLUSHALL":   true,
+	"DELPATTERN": true,
+	"BULKJOB":    true,
+}
+
+// maintenanceRoutes are the REST endpoints served in maintenance mode, to
+// admin clients only
+var maintenanceRoutes = map[string]bool{
+	"GET /api/v1/ping":                   true,
+	"GET /api/v1/info":                   true,
+	"GET /api/v1/latency":                true,
+	"GET /api/v1/memory":                 true,
+	"GET /api/v1/config":                 true,
+	"PUT /api/v1/config":                 true,
+	"PATCH /api/v1/config":               true,
+	"POST /api/v1/config/reload":         true,
+	"GET /api/v1/admin/audit":            true,
+	"GET /api/v1/admin/audit/verify":     true,
+	"GET /api/v1/admin/usage":            true,
+	"GET /api/v1/persistence/snapshots":  true,
+	"POST /api/v1/persistence/snapshots": true,
+	"POST /api/v1/persistence/restore":   true,
+	"DELETE /api/v1/flush":               true,
+	"DELETE /api/v1/keys":                true,
+}
+
+// loadingCommands and loadingRoutes are served while the snapshot loads
+var (
+	loadingCommands = map[string]bool{"PING": true, "INFO": true}
+	loadingRoutes   = map[string]bool{"GET /api/v1/ping": true, "GET /api/v1/info": true}
+)
+
+// readOnlyRoutes are the REST endpoints that take a body but don't modify
+// data, so they are still served in read-only mode. GraphQL stays open
+// with mutations refused.
+var readOnlyRoutes = map[string]bool{
+	"POST /api/v1/bulk/get":              true,
+	"POST /api/v1/pipeline":              true,
+	"POST /api/v1/query":                 true,
+	"POST /api/v1/persistence/snapshots": true,
+	"POST /graphql":                      true,
+}
+
+// configRoutes change the configuration. They are served in read-only mode
+// to admin clients, so the mode can be switched back.
+var configRoutes = map[string]bool{
+	"PUT /api/v1/config":         true,
+	"PATCH /api/v1/config":       true,
+	"POST /api/v1/config/reload": true,
+}
+
+// checkMode refuses commands the server's mode doesn't allow, and all but
+// PING and INFO while the snapshot loads. CONFIG is open to admins in
+// read-only mode so the mode can be switched back; processCommand refuses
+// changes to the configuration from other clients.
+func (s *TCPServer) checkMode(ctx context.Context, parts []string) error {
+	server := s.db.Config().Server
+	command := strings.ToUpper(parts[0])
+	if s.db.Loading() && !loadingCommands[command] {
+		return core.ErrLoading
+	}
+	if server.Maintenance && (!maintenanceCommands[command] || !s.isAdmin(ctx)) {
+		return errs.Newf(errs.ErrMaintenance, "server is in maintenance mode, only admin commands are served")
+	}
+	if server.ReadOnly && !(command == "CONFIG" && s.isAdmin(ctx)) && isWriteCommand(parts) {
+		return errs.Newf(errs.ErrReadOnly, "server is read-only, writes are refused")
+	}
+	return nil
+}
+
+// modeMiddleware applies read-only and maintenance mode, and the loading
+// gate, to the REST API. Maintenance and loading answer 503 with a
+// Retry-After, read-only refusals 403.
+func (s *HTTPServer) modeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := s.db.Config().Server
+		route := r.Method + " " + canonicalRoute(r.URL.Path)
+		loading := s.db.Loading() && !loadingRoutes[route]
+		if r.Method == http.MethodOptions || (!server.Maintenance && !server.ReadOnly && !loading) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var err error
+		if loading {
+			w.Header().Set("Retry-After", "1")
+			err = core.ErrLoading
+		} else if server.Maintenance && (!maintenanceRoutes[route] || !s.isAdmin(r)) {
+			w.Header().Set("Retry-After", "60")
+			err = errs.Newf(errs.ErrMaintenance, "server is in maintenance mode, only admin requests are served")
+		} else if server.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead && !readOnlyRoutes[route] && !(configRoutes[route] && s.isAdmin(r)) {
+			err = errs.Newf(errs.ErrReadOnly, "server is read-only, writes are refused")
+		}
+		if err != nil {
+			s.writeClassified(w, err, http.StatusForbidden, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nitrix4ly/triff/client"
+	"github.com/nitrix4ly/triff/testutil"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// TestReadOnlyConfigNeedsAdmin checks that in read-only mode only admin
+// clients can change the configuration, over TCP and REST
+func TestReadOnlyConfigNeedsAdmin(t *testing.T) {
+	config := utils.DefaultConfig()
+	config.Persistence.Enabled = false
+	config.Server.ReadOnly = true
+	config.Security.Password = "secret"
+	srv := testutil.StartWithOptions(t, testutil.Options{TCP: true, HTTP: true, Config: config})
+
+	c := srv.Client()
+	if _, err := c.Do("CONFIG", "SET", "read-only", "no"); err == nil {
+		t.Fatal("CONFIG SET without AUTH was served in read-only mode")
+	}
+	if _, err := c.Do("CONFIG", "RELOAD"); err == nil {
+		t.Fatal("CONFIG RELOAD without AUTH was served in read-only mode")
+	}
+
+	if status := patchConfig(t, srv, `{"read-only": "no"}`, ""); status != http.StatusForbidden {
+		t.Fatalf("PATCH /api/v1/config without credentials returned %d in read-only mode, want 403", status)
+	}
+	if !srv.DB.Config().Server.ReadOnly {
+		t.Fatal("a client without credentials switched read-only mode off")
+	}
+
+	admin, err := client.DialWithOptions(srv.TCPAddr, client.Options{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Do("CONFIG", "SET", "read-only", "no"); err != nil {
+		t.Fatalf("CONFIG SET from an admin client: %v", err)
+	}
+	if srv.DB.Config().Server.ReadOnly {
+		t.Fatal("CONFIG SET from an admin client left read-only mode on")
+	}
+	if _, err := c.Do("CONFIG", "SET", "read-only", "yes"); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Fatalf("CONFIG SET without AUTH returned %v, want NOAUTH", err)
+	}
+	if status := patchConfig(t, srv, `{"read-only": "yes"}`, ""); status != http.StatusUnauthorized {
+		t.Fatalf("PATCH /api/v1/config without credentials returned %d, want 401", status)
+	}
+	if status := patchConfig(t, srv, `{"read-only": "yes"}`, "secret"); status != http.StatusOK {
+		t.Fatalf("PATCH /api/v1/config with the password returned %d, want 200", status)
+	}
+	if status := patchConfig(t, srv, `{"read-only": "no"}`, "secret"); status != http.StatusOK {
+		t.Fatalf("PATCH /api/v1/config with the password returned %d in read-only mode, want 200", status)
+	}
+}
+
+// patchConfig sends PATCH /api/v1/config, with password as a bearer token
+// unless it is empty, and returns the status
+func patchConfig(t *testing.T, srv *testutil.Server, body, password string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPatch, srv.URL("/api/v1/config"), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if password != "" {
+		req.Header.Set("Authorization", "Bearer "+password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nitrix4ly/triff/codec"
+)
+
+// negotiationMiddleware lets REST clients use MessagePack or CBOR instead
+// of JSON. Request bodies sent as application/msgpack or application/cbor
+// are transcoded to JSON before the handler reads them, and JSON responses
+// are transcoded to the first of those an Accept header prefers over JSON.
+// Other responses, such as raw values and event streams, pass through.
+func (s *HTTPServer) negotiationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			if requestCodec, ok := codec.ByContentType(mediaType); ok {
+				body, err := io.ReadAll(r.Body)
+				if err == nil {
+					body, err = requestCodec.ToJSON(body)
+				}
+				if err != nil {
+					s.writeError(w, http.StatusBadRequest, "invalid "+requestCodec.ContentType+" body")
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				r.Header.Set("Content-Type", "application/json")
+			}
+		}
+
+		responseCodec, ok := acceptedCodec(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		transcoder := &jsonRewriter{ResponseWriter: w, rewrite: func(header http.Header, status int, body []byte) []byte {
+			// Bodies that can't be transcoded are sent as JSON
+			encoded, err := responseCodec.FromJSON(body)
+			if err != nil {
+				return body
+			}
+			header.Set("Content-Type", responseCodec.ContentType)
+			return encoded
+		}}
+		next.ServeHTTP(transcoder, r)
+		transcoder.finish()
+	})
+}
+
+// acceptedCodec returns the binary codec an Accept header lists before
+// application/json, if any
+func acceptedCodec(r *http.Request) (codec.Codec, bool) {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return codec.Codec{}, false
+		}
+		if c, ok := codec.ByContentType(mediaType); ok {
+			return c, true
+		}
+	}
+	return codec.Codec{}, false
+}
+
+// jsonRewriter buffers JSON responses so a middleware can rewrite them
+// once the handler is done, and writes any other response straight through
+type jsonRewriter struct {
+	http.ResponseWriter
+	rewrite   func(header http.Header, status int, body []byte) []byte
+	status    int
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func (j *jsonRewriter) WriteHeader(status int) {
+	if j.status != 0 {
+		return
+	}
+	j.status = status
+	mediaType, _, _ := mime.ParseMediaType(j.Header().Get("Content-Type"))
+	if mediaType == "application/json" {
+		j.buffering = true
+		return
+	}
+	j.ResponseWriter.WriteHeader(status)
+}
+
+func (j *jsonRewriter) Write(p []byte) (int, error) {
+	if j.status == 0 {
+		j.WriteHeader(http.StatusOK)
+	}
+	if j.buffering {
+		return j.buf.Write(p)
+	}
+	return j.ResponseWriter.Write(p)
+}
+
+func (j *jsonRewriter) Unwrap() http.ResponseWriter {
+	return j.ResponseWriter
+}
+
+// finish writes a buffered JSON response as rewritten
+func (j *jsonRewriter) finish() {
+	if !j.buffering {
+		return
+	}
+	body := j.rewrite(j.Header(), j.status, j.buf.Bytes())
+	j.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	j.ResponseWriter.WriteHeader(j.status)
+	j.ResponseWriter.Write(body)
+}
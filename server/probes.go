@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Kubernetes probe paths. They are answered ahead of the router, so they
+// need no authentication, aren't refused in maintenance mode and don't
+// fill the request log every few seconds.
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+// probeMiddleware answers the liveness and readiness probes and passes
+// everything else on
+func (s *HTTPServer) probeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case livenessPath:
+			s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		case readinessPath:
+			s.handleReadiness(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleReadiness answers 200 once the database is ready for traffic and
+// 503 with the reasons until then (see core.Database.Readiness)
+func (s *HTTPServer) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	readiness := s.db.Readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	if readiness.Reasons == nil {
+		readiness.Reasons = []string{}
+	}
+	s.writeJSON(w, status, map[string]interface{}{
+		"ready":   readiness.Ready,
+		"reasons": readiness.Reasons,
+		"node_id": s.db.NodeID(),
+		"epoch":   s.db.Epoch(),
+	})
+}
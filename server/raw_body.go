@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// rawContentTypes are the media types string values are sent and served as
+// without a JSON wrapper
+var rawContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+}
+
+// rawRequest reports whether a request body is a raw string value rather
+// than a JSON payload
+func rawRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && rawContentTypes[mediaType]
+}
+
+// rawResponseType returns the raw media type a client asks for with its
+// Accept header, if it prefers one over JSON
+func rawResponseType(r *http.Request) (string, bool) {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if rawContentTypes[mediaType] {
+			return mediaType, true
+		}
+		if mediaType == "application/json" {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// writeRaw serves a string value as the body of the response
+func writeRaw(w http.ResponseWriter, mediaType, value string) {
+	if mediaType == "text/plain" {
+		mediaType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(value))
+}
+
+// encodeStringValue adds a string value to a JSON response. Values that
+// aren't valid UTF-8, or all values with ?encoding=base64, are base64
+// encoded and flagged with "encoding", as JSON strings can't carry
+// arbitrary bytes.
+func encodeStringValue(response map[string]interface{}, value string, r *http.Request) {
+	if r.URL.Query().Get("encoding") == "base64" || !utf8.ValidString(value) {
+		response["value"] = base64.StdEncoding.EncodeToString([]byte(value))
+		response["encoding"] = "base64"
+		return
+	}
+	response["value"] = value
+}
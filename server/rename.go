@@ -0,0 +1,194 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// commandNames is the security.rename_commands table the TCP server
+// applies
+type commandNames struct {
+	aliases map[string]string // Upper-case new name to the command it runs
+	hidden  map[string]bool   // Commands no longer reachable under their own name
+}
+
+// newCommandNames normalizes renames, where an empty new name disables the
+// command
+func newCommandNames(renames map[string]string) *commandNames {
+	names := &commandNames{
+		aliases: make(map[string]string, len(renames)),
+		hidden:  make(map[string]bool, len(renames)),
+	}
+	for command, name := range renames {
+		command = strings.ToUpper(command)
+		names.hidden[command] = true
+		if name != "" {
+			names.aliases[strings.ToUpper(name)] = command
+		}
+	}
+	return names
+}
+
+// resolve rewrites a command line sent under a new name to the command it
+// runs, or returns false if no command answers to the name the line uses
+func (n *commandNames) resolve(line string) (string, bool) {
+	if len(n.hidden) == 0 {
+		return line, true
+	}
+	name, rest := line, ""
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		name, rest = line[:i], line[i:]
+	}
+	name = strings.ToUpper(name)
+	if command, ok := n.aliases[name]; ok {
+		return command + rest, true
+	}
+	return line, !n.hidden[name]
+}
+
+// restCommands maps REST endpoints to the TCP commands they perform, so
+// renaming or disabling a command also closes its endpoint. A URL can't
+// carry a secret new name, so renamed commands are TCP only.
+var restCommands = map[string]string{
+	"GET /api/v1/info":                                    "INFO",
+	"GET /api/v1/keys":                                    "KEYS",
+	"DELETE /api/v1/keys":                                 "DELPATTERN",
+	"GET /api/v1/browse":                                  "KEYS",
+	"GET /api/v1/keys/{key}":                              "GET",
+	"HEAD /api/v1/keys/{key}":                             "OBJECT",
+	"POST /api/v1/keys/{key}":                             "SET",
+	"PUT /api/v1/keys/{key}":                              "SET",
+	"DELETE /api/v1/keys/{key}":                           "DEL",
+	"GET /api/v1/keys/{key}/ttl":                          "TTL",
+	"POST /api/v1/keys/{key}/ttl":                         "EXPIRE",
+	"GET /api/v1/keys/{key}/exists":                       "EXISTS",
+	"GET /api/v1/string/{key}":                            "GET",
+	"POST /api/v1/string/{key}":                           "SET",
+	"PUT /api/v1/string/{key}":                            "SET",
+	"POST /api/v1/string/{key}/append":                    "APPEND",
+	"GET /api/v1/string/{key}/length":                     "STRLEN",
+	"GET /api/v1/string/{key}/range":                      "GETRANGE",
+	"POST /api/v1/string/{key}/incr":                      "INCR",
+	"POST /api/v1/string/{key}/decr":                      "DECR",
+	"GET /api/v1/hash/{key}":                              "HGETALL",
+	"POST /api/v1/hash/{key}":                             "HSET",
+	"PUT /api/v1/hash/{key}":                              "HSET",
+	"GET /api/v1/hash/{key}/{field}":                      "HGET",
+	"PUT /api/v1/hash/{key}/{field}":                      "HSET",
+	"DELETE /api/v1/hash/{key}/{field}":                   "HDEL",
+	"POST /api/v1/hash/{key}/{field}/incr":                "HINCRBY",
+	"GET /api/v1/set/{key}":                               "SMEMBERS",
+	"POST /api/v1/set/{key}":                              "SADD",
+	"GET /api/v1/set/{key}/members/{member}":              "SISMEMBER",
+	"DELETE /api/v1/set/{key}/members/{member}":           "SREM",
+	"GET /api/v1/set/{key}/ismember":                      "SMISMEMBER",
+	"POST /api/v1/set/{key}/pop":                          "SPOP",
+	"POST /api/v1/set/{key}/move":                         "SMOVE",
+	"GET /api/v1/sets/intercard":                          "SINTERCARD",
+	"GET /api/v1/list/{key}":                              "LRANGE",
+	"GET /api/v1/list/{key}/length":                       "LLEN",
+	"POST /api/v1/list/{key}/lpush":                       "LPUSH",
+	"POST /api/v1/list/{key}/rpush":                       "RPUSH",
+	"POST /api/v1/list/{key}/lpop":                        "LPOP",
+	"POST /api/v1/list/{key}/rpop":                        "RPOP",
+	"GET /api/v1/list/{key}/pos":                          "LPOS",
+	"POST /api/v1/list/{key}/insert":                      "LINSERT",
+	"POST /api/v1/list/{key}/rem":                         "LREM",
+	"PUT /api/v1/list/{key}/items/{index}":                "LSET",
+	"POST /api/v1/list/{key}/trim":                        "LTRIM",
+	"POST /api/v1/lists/mpop":                             "LMPOP",
+	"POST /api/v1/ttl/bulk":                               "EXPIREPATTERN",
+	"GET /api/v1/keys/{key}/tags":                         "TAGS",
+	"POST /api/v1/keys/{key}/tags":                        "TAG",
+	"DELETE /api/v1/keys/{key}/tags/{tag}":                "UNTAG",
+	"GET /api/v1/tags/{tag}/keys":                         "TAGKEYS",
+	"POST /api/v1/tags/{tag}/invalidate":                  "INVALIDATETAG",
+	"GET /api/v1/keys/{key}/dependencies":                 "DEPENDENCIES",
+	"POST /api/v1/keys/{key}/dependencies":                "DEPEND",
+	"DELETE /api/v1/keys/{key}/dependencies/{dependency}": "UNDEPEND",
+	"GET /api/v1/keys/{key}/dependents":                   "DEPENDENTS",
+	"GET /api/v1/aggregates":                              "AGG.LIST",
+	"POST /api/v1/aggregates":                             "AGG.CREATE",
+	"DELETE /api/v1/aggregates/{name}":                    "AGG.DROP",
+	"POST /api/v1/pipeline":                               "PIPELINE",
+	"POST /api/v1/bulk/get":                               "MGET",
+	"POST /api/v1/bulk/set":                               "MSET",
+	"POST /api/v1/bulk/swap":                              "SWAP",
+	"GET /api/v1/query":                                   "QUERY",
+	"POST /api/v1/query":                                  "QUERY",
+	"DELETE /api/v1/queues/{name}":                        "QPURGE",
+	"DELETE /api/v1/leaderboards/{name}":                  "LBRESET",
+	"GET /api/v1/config":                                  "CONFIG",
+	"PUT /api/v1/config":                                  "CONFIG",
+	"PATCH /api/v1/config":                                "CONFIG",
+	"POST /api/v1/config/reload":                          "CONFIG",
+	"DELETE /api/v1/flush":                                "FLUSHALL",
+	"GET /api/v1/persistence/snapshots":                   "RESTORE",
+	"POST /api/v1/persistence/snapshots":                  "SAVE",
+	"POST /api/v1/persistence/restore":                    "RESTORE",
+	"GET /api/v1/partitions":                              "PARTITION",
+	"DELETE /api/v1/partitions/{name}":                    "PARTITION",
+	"GET /api/v1/schedules":                               "SCHEDULE",
+	"POST /api/v1/schedules":                              "SCHEDULE",
+	"DELETE /api/v1/schedules/{id}":                       "SCHEDULE",
+	"GET /api/v1/leases/{key}":                            "LEASE",
+	"PUT /api/v1/leases/{key}":                            "LEASE",
+	"DELETE /api/v1/leases/{key}":                         "LEASE",
+}
+
+// graphqlCommands maps GraphQL root fields to the TCP commands they
+// perform. Fields of disabled commands are unknown to the request, as
+// their endpoints are.
+var graphqlCommands = map[string]string{
+	"Query.keys":      "KEYS",
+	"Query.query":     "QUERY",
+	"Mutation.set":    "SET",
+	"Mutation.delete": "DEL",
+	"Mutation.expire": "EXPIRE",
+	"Mutation.hset":   "HSET",
+	"Mutation.hdel":   "HDEL",
+}
+
+// commandDisabled reports whether security.rename_commands renames or
+// disables a command, taking it off the HTTP API
+func (s *HTTPServer) commandDisabled(command string) bool {
+	for renamed := range s.db.Config().Security.RenameCommands {
+		if strings.EqualFold(renamed, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// graphqlFieldDisabled reports whether a GraphQL root field performs a
+// renamed or disabled command
+func (s *HTTPServer) graphqlFieldDisabled(typeName, field string) bool {
+	command, ok := graphqlCommands[typeName+"."+field]
+	return ok && s.commandDisabled(command)
+}
+
+// renamedCommandMiddleware answers 404 on the endpoints of renamed or
+// disabled commands, as if they didn't exist
+func (s *HTTPServer) renamedCommandMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.db.Config().Security.RenameCommands) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		if command, ok := restCommands[r.Method+" "+canonicalRoute(route)]; ok && s.commandDisabled(command) {
+			s.writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// Command replies are passed around as strings without their final CRLF,
+// which the connection adds as it writes them. The helpers here build
+// them with pooled RESP writers, so a reply costs the one allocation of
+// its string instead of one per element.
+
+// maxPooledWriter is the largest writer put back in the pool; the odd huge
+// reply shouldn't pin its buffer for good
+const maxPooledWriter = 64 << 10
+
+var respWriters = sync.Pool{
+	New: func() interface{} { return utils.NewRESPWriter(512) },
+}
+
+// newReply takes a writer from the pool for building a reply
+func newReply() *utils.RESPWriter {
+	w := respWriters.Get().(*utils.RESPWriter)
+	w.Reset()
+	return w
+}
+
+// finishReply returns the reply built in w and puts w back in the pool
+func finishReply(w *utils.RESPWriter) string {
+	var reply string
+	if n := w.Len(); n >= 2 {
+		reply = string(w.Bytes()[:n-2])
+	}
+	releaseReply(w)
+	return reply
+}
+
+// writeReply encodes a command's reply for the wire into w. Status and
+// error replies are one line, so line breaks in them, say from a binary
+// key quoted in an error message, are replaced to keep the protocol in
+// step; bulk replies carry their length and can hold anything.
+func writeReply(w *utils.RESPWriter, response string) []byte {
+	w.Reset()
+	appendReply(w, response)
+	return w.Bytes()
+}
+
+// appendReply encodes a reply after those already in w, for writing a
+// batch of pipelined replies at once
+func appendReply(w *utils.RESPWriter, response string) {
+	if len(response) > 0 && (response[0] == '+' || response[0] == '-') {
+		w.Line(response)
+	} else {
+		w.Raw(response)
+	}
+}
+
+// releaseReply puts a writer taken with newReply back in the pool without
+// copying out what it holds
+func releaseReply(w *utils.RESPWriter) {
+	if w.Cap() <= maxPooledWriter {
+		respWriters.Put(w)
+	}
+}
+
+// formatInt replies an integer
+func formatInt(n int64) string {
+	var scratch [24]byte
+	return string(strconv.AppendInt(append(scratch[:0], ':'), n, 10))
+}
+
+// formatBulk replies a bulk string
+func formatBulk(s string) string {
+	w := newReply()
+	w.Bulk(s)
+	return finishReply(w)
+}
+
+// formatUint replies an unsigned integer
+func formatUint(n uint64) string {
+	var scratch [24]byte
+	return string(strconv.AppendUint(append(scratch[:0], ':'), n, 10))
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/commands"
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// scheduleInterval is how often the TCP server looks for due tasks, which
+// bounds how late a task runs
+const scheduleInterval = time.Second
+
+// startScheduler runs due scheduled tasks until the server stops. Tasks
+// are claimed from the database, so several servers on one database run
+// each of them once. It goes by the database's clock, so a FakeClock
+// drives it too.
+func (s *TCPServer) startScheduler() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopScheduler = cancel
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-s.db.Clock().After(scheduleInterval):
+				s.runDueTasks(ctx, now)
+			}
+		}
+	}()
+}
+
+// runDueTasks runs the tasks due at now one after another, like commands
+// from an admin client named after the task
+func (s *TCPServer) runDueTasks(ctx context.Context, now time.Time) {
+	tasks, err := s.scheduleCommands.Due(now)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("scheduler: %v", err))
+		return
+	}
+	for _, task := range tasks {
+		sess := &session{ctx: ctx, commandCtx: withAdmin(ctx), client: "scheduler:" + task.ID}
+		response, _ := s.serveLine(sess, utils.JoinArgs(task.Command))
+		if strings.HasPrefix(response, "-") {
+			s.logger.Error(fmt.Sprintf("scheduled task %s failed: %s", task.ID, response[1:]))
+		} else {
+			s.logger.Debug(fmt.Sprintf("scheduled task %s ran", task.ID))
+		}
+	}
+}
+
+// schedule serves SCHEDULE AT id unix-time command [arg ...],
+// SCHEDULE IN id seconds command [arg ...], SCHEDULE CRON id expr
+// command [arg ...], SCHEDULE REMOVE id and SCHEDULE LIST. Tasks run
+// with admin rights, so only admin clients may change them.
+func (s *TCPServer) schedule(ctx context.Context, args []string) string {
+	if len(args) == 0 {
+		return "-ERR wrong number of arguments for 'schedule' command"
+	}
+	sub := strings.ToUpper(args[0])
+	if sub == "LIST" {
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'schedule list' command"
+		}
+		tasks, err := core.Result[[]commands.ScheduledTask](s.scheduleCommands.List())
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatTasks(tasks)
+	}
+	if !s.isAdmin(ctx) {
+		return protocolError(errs.Newf(errs.ErrNoAuth, "scheduled tasks can only be changed by admin clients"))
+	}
+
+	if sub == "REMOVE" {
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'schedule remove' command"
+		}
+		if response := s.scheduleCommands.Remove(args[1]); !response.Success {
+			if errors.Is(response.Failure(), errs.ErrNotFound) {
+				return ":0"
+			}
+			return protocolError(response.Failure())
+		}
+		return ":1"
+	}
+
+	if len(args) < 4 {
+		return "-ERR wrong number of arguments for 'schedule' command"
+	}
+	id, when, command := args[1], args[2], args[3:]
+	if err := s.checkScheduledCommand(command); err != nil {
+		return protocolError(err)
+	}
+	var response *core.Response
+	switch sub {
+	case "AT", "IN":
+		n, err := strconv.ParseInt(when, 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		at := time.Unix(n, 0)
+		if sub == "IN" {
+			at = s.db.Clock().Now().Add(time.Duration(n) * time.Second)
+		}
+		response = s.scheduleCommands.At(id, at, command)
+	case "CRON":
+		response = s.scheduleCommands.Cron(id, when, command)
+	default:
+		return "-ERR syntax error, expected SCHEDULE AT|IN|CRON|REMOVE|LIST"
+	}
+	task, err := core.Result[commands.ScheduledTask](response)
+	if err != nil {
+		return protocolError(err)
+	}
+	return formatInt(task.Next)
+}
+
+// checkScheduledCommand refuses to schedule commands the server doesn't
+// know, under their current names, and AUTH, which a task can't use
+func (s *TCPServer) checkScheduledCommand(command []string) error {
+	line, known := s.names.resolve(utils.JoinArgs(command))
+	if !known {
+		return fmt.Errorf("unknown command '%s'", strings.ToUpper(command[0]))
+	}
+	if strings.EqualFold(commandArgs(line)[0], "AUTH") {
+		return fmt.Errorf("AUTH can't be scheduled")
+	}
+	return nil
+}
+
+// formatTasks replies one [id, next run, cron, command] array per task,
+// the cron expression empty for tasks that run once
+func formatTasks(tasks []commands.ScheduledTask) string {
+	w := newReply()
+	w.ArrayHeader(len(tasks))
+	for _, task := range tasks {
+		w.ArrayHeader(4)
+		w.Bulk(task.ID)
+		w.Int(task.Next)
+		w.Bulk(task.Cron)
+		w.Bulk(utils.JoinArgs(task.Command))
+	}
+	return finishReply(w)
+}
@@ -2,13 +2,22 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/nitrix4ly/triff/commands"
 	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+	"github.com/nitrix4ly/triff/tracing"
 	"github.com/nitrix4ly/triff/utils"
 )
 
@@ -18,31 +27,105 @@ type TCPServer struct {
 	port           int
 	listener       net.Listener
 	stringCommands *commands.StringCommands
+	hashCommands   *commands.HashCommands
+	setCommands    *commands.SetCommands
+	listCommands   *commands.ListCommands
+	indexCommands  *commands.IndexCommands
+	pipelineCommands *commands.PipelineCommands
+	aggregateCommands *commands.AggregateCommands
+	searchCommands *commands.SearchCommands
+	lockCommands   *commands.LockCommands
+	leaseCommands  *commands.LeaseCommands
+	rateLimitCommands *commands.RateLimitCommands
+	queueCommands  *commands.QueueCommands
+	leaderboardCommands *commands.LeaderboardCommands
+	zsetCommands   *commands.ZSetCommands
+	counterCommands *commands.CounterCommands
+	sketchCommands *commands.SketchCommands
+	crdtCommands   *commands.CRDTCommands
+	scheduleCommands *commands.ScheduleCommands
+	config         *utils.ConfigManager
+	audit          *utils.AuditLog
+	adminLog       *utils.AdminLog
+	flushGuard     *flushGuard
+	latency        *utils.LatencyMonitor
+	timeouts       atomic.Pointer[commandTimeouts]
+	names          *commandNames
+	usage          *utils.UsageMeter
+	breaker        *utils.CircuitBreaker
+	pool           *commandPool
+	loop           atomic.Pointer[eventLoop]
+	stopScheduler  context.CancelFunc
 	logger         *utils.Logger
 }
 
 // NewTCPServer creates a new TCP server instance
 func NewTCPServer(db *core.Database, port int, logger *utils.Logger) *TCPServer {
-	return &TCPServer{
+	config := db.Config()
+	server := &TCPServer{
 		db:             db,
 		port:           port,
 		stringCommands: commands.NewStringCommands(db),
+		hashCommands:   commands.NewHashCommands(db),
+		setCommands:    commands.NewSetCommands(db),
+		listCommands:   commands.NewListCommands(db),
+		indexCommands:  commands.NewIndexCommands(db),
+		pipelineCommands: commands.NewPipelineCommands(db),
+		aggregateCommands: commands.NewAggregateCommands(db),
+		searchCommands: commands.NewSearchCommands(db),
+		lockCommands:   commands.NewLockCommands(db),
+		leaseCommands:  commands.NewLeaseCommands(db),
+		rateLimitCommands: commands.NewRateLimitCommands(db),
+		queueCommands:  commands.NewQueueCommands(db),
+		leaderboardCommands: commands.NewLeaderboardCommands(db),
+		zsetCommands:   commands.NewZSetCommands(db),
+		counterCommands: commands.NewCounterCommands(db),
+		sketchCommands: commands.NewSketchCommands(db),
+		crdtCommands:   commands.NewCRDTCommands(db),
+		scheduleCommands: commands.NewScheduleCommands(db),
+		config:         utils.NewConfigManager(db, "", logger),
+		flushGuard:     newFlushGuard(),
+		latency:        utils.NewLatencyMonitor(config.Logging.LatencyThreshold),
+		breaker:        utils.NewCircuitBreaker(config.Limits.CircuitBreaker),
+		pool:           newCommandPool(config.Server.Workers),
 		logger:         logger,
 	}
+	server.timeouts.Store(newCommandTimeouts(config.Limits))
+	server.names = newCommandNames(config.Security.RenameCommands)
+	server.config.OnChange(server.applyConfig)
+	return server
 }
 
 // Start begins listening for TCP connections
 func (s *TCPServer) Start() error {
-	var err error
-	s.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	s.startScheduler()
+	if s.db.Config().Server.Frontend == "eventloop" {
+		return s.startEventLoop()
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
 	if err != nil {
 		return fmt.Errorf("failed to start TCP server: %v", err)
 	}
 
 	s.logger.Info(fmt.Sprintf("TCP server listening on port %d", s.port))
+	return s.serve(listener)
+}
+
+// Serve accepts connections on listener, e.g. one on port 0 for a test,
+// until Stop closes it. It always uses the goroutine frontend.
+func (s *TCPServer) Serve(listener net.Listener) error {
+	s.startScheduler()
+	return s.serve(listener)
+}
 
+func (s *TCPServer) serve(listener net.Listener) error {
+	s.listener = listener
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
 			s.logger.Error(fmt.Sprintf("Error accepting connection: %v", err))
 			continue
@@ -52,8 +135,45 @@ func (s *TCPServer) Start() error {
 	}
 }
 
+// SetConfigManager shares a config manager (e.g. one that knows the
+// config file path for reloads) instead of the server's default one
+func (s *TCPServer) SetConfigManager(config *utils.ConfigManager) {
+	s.config = config
+	config.OnChange(s.applyConfig)
+}
+
+// SetAuditLog enables recording of write commands
+func (s *TCPServer) SetAuditLog(audit *utils.AuditLog) {
+	s.audit = audit
+}
+
+// SetAdminLog enables recording of admin commands in the hash-chained
+// admin audit log
+func (s *TCPServer) SetAdminLog(adminLog *utils.AdminLog) {
+	s.adminLog = adminLog
+}
+
+// SetLatencyMonitor shares a latency monitor, so the HTTP server can
+// report on the commands this server runs
+func (s *TCPServer) SetLatencyMonitor(latency *utils.LatencyMonitor) {
+	s.latency = latency
+}
+
+// applyConfig keeps settings read on every command in step with CONFIG SET
+func (s *TCPServer) applyConfig(old, updated *core.Config) {
+	s.latency.SetThreshold(updated.Logging.LatencyThreshold)
+	s.timeouts.Store(newCommandTimeouts(updated.Limits))
+	s.breaker.SetConfig(updated.Limits.CircuitBreaker)
+}
+
 // Stop stops the TCP server
 func (s *TCPServer) Stop() error {
+	if s.stopScheduler != nil {
+		s.stopScheduler()
+	}
+	if s.loop.Load() != nil {
+		return s.stopEventLoop()
+	}
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -66,27 +186,241 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 	
 	s.logger.Info(fmt.Sprintf("New client connected: %s", conn.RemoteAddr()))
 	
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	// Lines are read on their own goroutine so that a disconnect cancels
+	// the command that is running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines := make(chan string)
+	go func() {
+		defer cancel()
+		defer close(lines)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 64*1024), maxCommandLine)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
 		}
-		
-		response := s.processCommand(line)
-		conn.Write([]byte(response + "\r\n"))
-	}
+		if err := scanner.Err(); err != nil {
+			s.logger.Error(fmt.Sprintf("Connection error: %v", err))
+		}
+	}()
 	
-	if err := scanner.Err(); err != nil {
-		s.logger.Error(fmt.Sprintf("Connection error: %v", err))
+	// Replies are encoded into one buffer reused for the connection's life
+	out := utils.NewRESPWriter(4096)
+	sess := &session{ctx: ctx, commandCtx: ctx, client: conn.RemoteAddr().String()}
+	for line := range lines {
+		if response, ok := s.serveLine(sess, line); ok {
+			conn.Write(writeReply(out, response))
+		}
 	}
 	
 	s.logger.Info(fmt.Sprintf("Client disconnected: %s", conn.RemoteAddr()))
 }
 
-// processCommand parses and executes commands
-func (s *TCPServer) processCommand(input string) string {
-	parts := strings.Fields(input)
+// session is the state the commands of one connection share
+type session struct {
+	ctx        context.Context // Canceled when the client disconnects
+	commandCtx context.Context // ctx, also telling commands whether the client authenticated
+	client     string
+}
+
+// serveLine runs one command line read from a client and returns its
+// reply; ok is false for a blank line, which gets none
+func (s *TCPServer) serveLine(sess *session, line string) (response string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false
+	}
+	if _, err := utils.SplitArgs(line); err != nil {
+		return "-ERR Protocol error: " + err.Error(), true
+	}
+	
+	// Renamed commands are translated here, so everything after sees
+	// the command's own name
+	line, known := s.names.resolve(line)
+	if !known {
+		return fmt.Sprintf("-ERR unknown command '%s'", strings.ToUpper(commandArgs(line)[0])), true
+	}
+	
+	if strings.EqualFold(commandArgs(line)[0], "AUTH") {
+		response, apiKey := s.authenticate(line)
+		if response == "+OK" && apiKey != "" {
+			sess.commandCtx = withAPIKey(sess.ctx, apiKey)
+		} else if response == "+OK" {
+			sess.commandCtx = withAdmin(sess.ctx)
+		}
+		return response, true
+	}
+	
+	requestID := utils.NewRequestID()
+	s.logger.WithRequestID(requestID).Debug(fmt.Sprintf("command from %s: %s", sess.client, line))
+	
+	response = s.pool.run(sess.commandCtx, strings.ToUpper(commandArgs(line)[0]), func() string {
+		return s.meteredCommand(sess.commandCtx, sess.client, line)
+	})
+	
+	if s.audit != nil {
+		s.auditCommand(requestID, sess.client, line, response)
+	}
+	if s.adminLog != nil {
+		s.auditAdmin(requestID, sess.client, line, response)
+	}
+	return response, true
+}
+
+// lengthUnit parses the optional BYTES or CHARS argument of STRLEN and
+// GETRANGE, reporting whether lengths are counted in characters
+func lengthUnit(args []string) (chars bool, ok bool) {
+	if len(args) == 0 {
+		return false, true
+	}
+	switch strings.ToUpper(args[0]) {
+	case "BYTES":
+		return false, true
+	case "CHARS":
+		return true, true
+	}
+	return false, false
+}
+
+// restoreBulk serves RESTORE BULK [REPLACE] [NOSAVE] PAIRS key value ...,
+// loading string pairs with a core.BulkLoader. PAIRS ends the options so
+// keys named like them stay unambiguous.
+func (s *TCPServer) restoreBulk(args []string) string {
+	var opts core.BulkLoadOptions
+	for len(args) > 0 && strings.ToUpper(args[0]) != "PAIRS" {
+		switch strings.ToUpper(args[0]) {
+		case "REPLACE":
+			opts.Replace = true
+		case "NOSAVE":
+			opts.NoSave = true
+		default:
+			return "-ERR syntax error, expected RESTORE BULK [REPLACE] [NOSAVE] PAIRS key value [key value ...]"
+		}
+		args = args[1:]
+	}
+	if len(args) < 3 || len(args)%2 != 1 {
+		return "-ERR wrong number of arguments for 'restore bulk' command"
+	}
+
+	loader := s.db.NewBulkLoader(opts)
+	for i := 1; i < len(args); i += 2 {
+		loader.Add(args[i], &core.TriffValue{Type: core.STRING, Data: args[i+1]})
+	}
+	result, err := loader.Close()
+	if err != nil {
+		return protocolError(err)
+	}
+	return fmt.Sprintf("+OK loaded %d skipped %d rejected %d", result.Loaded, result.Skipped, result.Rejected)
+}
+
+// maxCommandLine is the longest command line read, which bounds the size
+// of values sent over TCP
+const maxCommandLine = 512 << 20
+
+// commandArgs splits a command line into its arguments, honoring quotes.
+// Lines with unbalanced quotes are refused as they are read, so the
+// arguments of every line that gets this far can be split.
+func commandArgs(line string) []string {
+	args, _ := utils.SplitArgs(line)
+	return args
+}
+
+// tracedCommand runs a command inside a server span when tracing is enabled
+func (s *TCPServer) tracedCommand(ctx context.Context, client, line string) string {
+	command := strings.ToUpper(commandArgs(line)[0])
+	if !tracing.Enabled() {
+		return s.timedCommand(ctx, command, line)
+	}
+	
+	ctx, span := tracing.Start(ctx, command, tracing.KindServer)
+	defer span.Finish()
+	span.SetAttribute("db.system", "triff")
+	span.SetAttribute("db.operation", command)
+	span.SetAttribute("net.peer.addr", client)
+	
+	response := s.timedCommand(ctx, command, line)
+	if strings.HasPrefix(response, "-") {
+		span.SetError(errors.New(strings.TrimPrefix(response, "-")))
+	}
+	return response
+}
+
+// timedCommand runs a command under its timeout and records its count and
+// latency. Unknown commands are counted but not timed.
+func (s *TCPServer) timedCommand(ctx context.Context, command, line string) string {
+	start := time.Now()
+	var response string
+	if err := s.checkMode(ctx, commandArgs(line)); err != nil {
+		response = protocolError(err)
+	} else if err := s.throttleWrite(ctx, line); err != nil {
+		response = protocolError(err)
+	} else {
+		response = s.runLimited(ctx, command, line)
+	}
+	elapsed := time.Since(start)
+	
+	countCommand(command, response)
+	if !strings.HasPrefix(response, "-ERR unknown command") {
+		s.latency.Record(command, commandFamily(command), elapsed)
+	}
+	return response
+}
+
+// throttleWrite holds back write commands while persistence is behind
+func (s *TCPServer) throttleWrite(ctx context.Context, line string) error {
+	if !isWriteCommand(commandArgs(line)) {
+		return nil
+	}
+	return s.db.WaitWritable(ctx)
+}
+
+// auditCommand records a write command and whether it succeeded
+func (s *TCPServer) auditCommand(requestID, client, line, response string) {
+	parts := commandArgs(line)
+	if !isWriteCommand(parts) {
+		return
+	}
+	err := s.audit.Record(utils.AuditEntry{
+		RequestID: requestID,
+		Client:    client,
+		Protocol:  "tcp",
+		Command:   strings.ToUpper(parts[0]),
+		Args:      parts[1:],
+		Success:   !strings.HasPrefix(response, "-"),
+	})
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("audit log write failed: %v", err))
+	}
+}
+
+// auditAdmin records an administrative command in the admin audit log
+func (s *TCPServer) auditAdmin(requestID, client, line, response string) {
+	parts := commandArgs(line)
+	operation, ok := adminOperation(parts)
+	if !ok {
+		return
+	}
+	err := s.adminLog.Record(utils.AdminRecord{
+		RequestID: requestID,
+		Client:    client,
+		Protocol:  "tcp",
+		Operation: operation,
+		Args:      parts[1:],
+		Success:   !strings.HasPrefix(response, "-"),
+	})
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("admin audit log write failed: %v", err))
+	}
+}
+
+// processCommand parses and executes commands. Long-running commands stop
+// and reply with an error once ctx is done.
+func (s *TCPServer) processCommand(ctx context.Context, input string) string {
+	parts := commandArgs(input)
 	if len(parts) == 0 {
 		return "-ERR empty command"
 	}
@@ -104,142 +438,2715 @@ func (s *TCPServer) processCommand(input string) string {
 		}
 		key, value := args[0], args[1]
 		var ttl int64 = 0
+		keepTTL := false
+		jitter := false
+		condition := ""
 		
-		// Check for EX option (expiration in seconds)
-		if len(args) >= 4 && strings.ToUpper(args[2]) == "EX" {
-			var err error
-			ttl, err = strconv.ParseInt(args[3], 10, 64)
+		// Parse options: EX seconds, PX milliseconds, KEEPTTL, NX, XX,
+		// JITTER
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "JITTER":
+				jitter = true
+			case "NX", "XX":
+				if condition != "" {
+					return "-ERR syntax error"
+				}
+				condition = strings.ToUpper(args[i])
+			case "EX", "PX":
+				if i+1 >= len(args) || keepTTL {
+					return "-ERR syntax error"
+				}
+				var err error
+				ttl, err = strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || ttl <= 0 {
+					return "-ERR invalid expire time"
+				}
+				if strings.ToUpper(args[i]) == "PX" {
+					// Expirations have second granularity, round up
+					ttl = (ttl + 999) / 1000
+				}
+				i++
+			case "KEEPTTL":
+				if ttl > 0 {
+					return "-ERR syntax error"
+				}
+				keepTTL = true
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		if jitter {
+			// JITTER shortens the expiry given with EX or PX
+			if ttl == 0 {
+				return "-ERR syntax error"
+			}
+			ttl = s.db.JitterTTL(ttl)
+		}
+		
+		if condition != "" {
+			if keepTTL {
+				return "-ERR syntax error"
+			}
+			var response *core.Response
+			if condition == "NX" {
+				response = s.stringCommands.SetNX(key, value, ttl)
+			} else {
+				response = s.stringCommands.SetXX(key, value, ttl)
+			}
+			set, err := core.Result[bool](response)
 			if err != nil {
-				return "-ERR invalid expire time"
+				return protocolError(err)
 			}
+			if set {
+				return "+OK"
+			}
+			return "$-1"
 		}
 		
-		response := s.stringCommands.Set(key, value, ttl)
+		var response *core.Response
+		if keepTTL {
+			response = s.stringCommands.SetKeepTTL(key, value)
+		} else {
+			response = s.stringCommands.Set(key, value, ttl)
+		}
 		if response.Success {
 			return "+OK"
 		}
-		return fmt.Sprintf("-ERR %s", response.Error)
+		return protocolError(response.Failure())
 		
-	case "GET":
+	case "SETNULL":
+		// SETNULL key seconds [JITTER] caches a miss: GET answers *-1 for
+		// it rather than the $-1 of a key that doesn't exist
+		if len(args) != 2 && len(args) != 3 {
+			return "-ERR wrong number of arguments for 'setnull' command"
+		}
+		ttl, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || ttl <= 0 {
+			return "-ERR invalid expire time"
+		}
+		if len(args) == 3 {
+			if strings.ToUpper(args[2]) != "JITTER" {
+				return "-ERR syntax error"
+			}
+			ttl = s.db.JitterTTL(ttl)
+		}
+		if response := s.stringCommands.SetNull(args[0], ttl); !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "GETEX":
+		// GETEX key [EX seconds | PX milliseconds | EXAT timestamp | PERSIST]
+		if len(args) < 1 || len(args) > 3 {
+			return "-ERR wrong number of arguments for 'getex' command"
+		}
+		var ttl int64 = 0
+		persist := false
+		if len(args) == 2 {
+			if strings.ToUpper(args[1]) != "PERSIST" {
+				return "-ERR syntax error"
+			}
+			persist = true
+		} else if len(args) == 3 {
+			n, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR invalid expire time"
+			}
+			switch strings.ToUpper(args[1]) {
+			case "EX":
+				ttl = n
+			case "PX":
+				ttl = (n + 999) / 1000
+			case "EXAT":
+				ttl = n - s.db.Clock().Now().Unix()
+				if ttl <= 0 {
+					ttl = 1
+				}
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		response := s.stringCommands.GetEx(args[0], ttl, persist)
+		if response.Success && response.Data != nil {
+			return formatBulk(response.Data.(string))
+		}
+		if response.Error != "" {
+			return protocolError(response.Failure())
+		}
+		return "$-1"
+		
+	case "GETDEL":
 		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'get' command"
+			return "-ERR wrong number of arguments for 'getdel' command"
 		}
-		response := s.stringCommands.Get(args[0])
+		response := s.stringCommands.GetDel(args[0])
 		if response.Success && response.Data != nil {
-			return fmt.Sprintf("$%d\r\n%s", len(response.Data.(string)), response.Data.(string))
+			return formatBulk(response.Data.(string))
+		}
+		if response.Error != "" {
+			return protocolError(response.Failure())
 		}
 		return "$-1"
 		
-	case "DEL":
-		if len(args) == 0 {
-			return "-ERR wrong number of arguments for 'del' command"
+	case "CAS":
+		// CAS key version value [EX seconds]
+		if len(args) != 3 && len(args) != 5 {
+			return "-ERR wrong number of arguments for 'cas' command"
 		}
-		count := 0
-		for _, key := range args {
-			if s.db.Delete(key) {
-				count++
+		version, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return "-ERR invalid version"
+		}
+		var ttl int64 = 0
+		if len(args) == 5 {
+			if strings.ToUpper(args[3]) != "EX" {
+				return "-ERR syntax error"
 			}
+			ttl, err = strconv.ParseInt(args[4], 10, 64)
+			if err != nil || ttl <= 0 {
+				return "-ERR invalid expire time"
+			}
+		}
+		n, err := core.Result[uint64](s.stringCommands.CompareAndSet(args[0], args[2], version, ttl))
+		if err != nil {
+			return protocolError(err)
 		}
-		return fmt.Sprintf(":%d", count)
+		return formatUint(n)
 		
-	case "EXISTS":
+	case "VERSION":
 		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'exists' command"
+			return "-ERR wrong number of arguments for 'version' command"
 		}
-		if s.db.Exists(args[0]) {
+		if value, exists := s.db.Get(args[0]); exists {
+			return formatUint(value.Version)
+		}
+		return ":0"
+		
+	case "LOCK", "LOCKEXTEND":
+		// LOCK key owner ttl-ms, LOCKEXTEND key owner ttl-ms
+		if len(args) != 3 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || ms <= 0 {
+			return "-ERR invalid lock ttl"
+		}
+		ttl := time.Duration(ms) * time.Millisecond
+		var response *core.Response
+		if command == "LOCK" {
+			response = s.lockCommands.Acquire(args[0], args[1], ttl)
+		} else {
+			response = s.lockCommands.Extend(args[0], args[1], ttl)
+		}
+		held, err := core.Result[bool](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		if held {
 			return ":1"
 		}
 		return ":0"
 		
-	case "KEYS":
-		pattern := "*"
-		if len(args) > 0 {
-			pattern = args[0]
+	case "UNLOCK":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'unlock' command"
 		}
-		keys := s.db.Keys(pattern)
-		result := fmt.Sprintf("*%d\r\n", len(keys))
-		for _, key := range keys {
-			result += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+		released, err := core.Result[bool](s.lockCommands.Release(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		if released {
+			return ":1"
 		}
-		return result
+		return ":0"
 		
-	case "FLUSHALL":
-		s.db.FlushAll()
-		return "+OK"
+	case "LEASE":
+		// LEASE ACQUIRE key holder seconds, LEASE GET key, LEASE RELEASE key holder
+		// ACQUIRE and GET reply [holder, duration, acquired, renewed, transitions]
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'lease' command"
+		}
+		switch sub := strings.ToUpper(args[0]); {
+		case sub == "ACQUIRE" && len(args) == 4:
+			seconds, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || seconds <= 0 {
+				return "-ERR invalid lease duration"
+			}
+			record, err := core.Result[commands.LeaderElectionRecord](s.leaseCommands.Acquire(args[1], args[2], time.Duration(seconds)*time.Second))
+			if err != nil {
+				return protocolError(err)
+			}
+			return formatLease(record)
+		case sub == "GET" && len(args) == 2:
+			record, err := core.Result[commands.LeaderElectionRecord](s.leaseCommands.Get(args[1]))
+			if errors.Is(err, errs.ErrNotFound) {
+				return "$-1"
+			}
+			if err != nil {
+				return protocolError(err)
+			}
+			return formatLease(record)
+		case sub == "RELEASE" && len(args) == 3:
+			released, err := core.Result[bool](s.leaseCommands.Release(args[1], args[2]))
+			if err != nil {
+				return protocolError(err)
+			}
+			if released {
+				return ":1"
+			}
+			return ":0"
+		}
+		return "-ERR syntax error, expected LEASE ACQUIRE <key> <holder> <seconds>, LEASE GET <key> or LEASE RELEASE <key> <holder>"
 		
-	case "INFO":
-		info := s.db.Info()
-		result := ""
-		for key, value := range info {
-			result += fmt.Sprintf("%s:%v\r\n", key, value)
+	case "CL.THROTTLE":
+		// CL.THROTTLE key max_burst count_per_period period [quantity]
+		// Replies like redis-cell: limited, limit, remaining, retry_after, reset_after
+		if len(args) != 4 && len(args) != 5 {
+			return "-ERR wrong number of arguments for 'cl.throttle' command"
+		}
+		nums := make([]int64, 0, 4)
+		for _, arg := range args[1:] {
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			nums = append(nums, n)
 		}
-		return fmt.Sprintf("$%d\r\n%s", len(result), result)
+		quantity := int64(1)
+		if len(nums) == 4 {
+			quantity = nums[3]
+		}
+		response := s.rateLimitCommands.Throttle(args[0], nums[0], nums[1], time.Duration(nums[2])*time.Second, quantity)
+		return formatRateLimit(response)
 		
-	case "DBSIZE":
-		size := s.db.Size()
-		return fmt.Sprintf(":%d", size)
+	case "RL.SLIDING":
+		// RL.SLIDING key limit window_seconds [cost]
+		if len(args) != 3 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'rl.sliding' command"
+		}
+		limit, err1 := strconv.ParseInt(args[1], 10, 64)
+		window, err2 := strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		cost := int64(1)
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			cost = n
+		}
+		response := s.rateLimitCommands.SlidingWindow(args[0], limit, time.Duration(window)*time.Second, cost)
+		return formatRateLimit(response)
 		
-	case "TTL":
+	case "ENQUEUE":
+		// ENQUEUE queue payload [DELAY seconds] [MAXATTEMPTS n]
+		if len(args) < 2 || len(args)%2 != 0 {
+			return "-ERR wrong number of arguments for 'enqueue' command"
+		}
+		var delay int64
+		maxAttempts := 0
+		for i := 2; i < len(args); i += 2 {
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			switch strings.ToUpper(args[i]) {
+			case "DELAY":
+				delay = n
+			case "MAXATTEMPTS":
+				maxAttempts = int(n)
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		job, err := core.Result[core.Job](s.queueCommands.Enqueue(args[0], args[1], time.Duration(delay)*time.Second, maxAttempts))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBulk(job.ID)
+		
+	case "DEQUEUE":
+		// DEQUEUE queue [VISIBILITY seconds], replies [id, receipt, payload, attempts]
+		if len(args) != 1 && len(args) != 3 {
+			return "-ERR wrong number of arguments for 'dequeue' command"
+		}
+		var visibility int64
+		if len(args) == 3 {
+			if strings.ToUpper(args[1]) != "VISIBILITY" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			visibility = n
+		}
+		response := s.queueCommands.Dequeue(args[0], time.Duration(visibility)*time.Second)
+		if response.Data == nil {
+			return "*-1"
+		}
+		job, err := core.Result[core.Job](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray([]string{job.ID, job.Receipt, job.Payload, strconv.Itoa(job.Attempts)})
+		
+	case "ACK":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'ack' command"
+		}
+		response := s.queueCommands.Ack(args[0], args[1], args[2])
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return ":1"
+		
+	case "NACK":
+		// NACK queue id receipt [DELAY seconds]
+		if len(args) != 3 && len(args) != 5 {
+			return "-ERR wrong number of arguments for 'nack' command"
+		}
+		var delay int64
+		if len(args) == 5 {
+			if strings.ToUpper(args[3]) != "DELAY" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || n < 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			delay = n
+		}
+		response := s.queueCommands.Nack(args[0], args[1], args[2], time.Duration(delay)*time.Second)
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return ":1"
+		
+	case "QSTATS":
 		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'ttl' command"
+			return "-ERR wrong number of arguments for 'qstats' command"
 		}
-		ttl := s.db.GetTTL(args[0])
-		return fmt.Sprintf(":%d", ttl)
+		stats, err := core.Result[core.QueueStats](s.queueCommands.Stats(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray([]string{
+			"ready", strconv.Itoa(stats.Ready),
+			"delayed", strconv.Itoa(stats.Delayed),
+			"inflight", strconv.Itoa(stats.Inflight),
+			"dead", strconv.Itoa(stats.Dead),
+		})
 		
-	case "EXPIRE":
-		if len(args) != 2 {
-			return "-ERR wrong number of arguments for 'expire' command"
+	case "QPURGE":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'qpurge' command"
 		}
-		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		purged, err := core.Result[bool](s.queueCommands.Purge(args[0]))
 		if err != nil {
-			return "-ERR invalid expire time"
+			return protocolError(err)
 		}
-		if s.db.SetTTL(args[0], seconds) {
+		if purged {
 			return ":1"
 		}
 		return ":0"
 		
-	case "INCR":
-		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'incr' command"
+	case "LBADD":
+		// LBADD board member score [INCR|BEST], replies [rank, score]
+		if len(args) != 3 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'lbadd' command"
 		}
-		response := s.stringCommands.Incr(args[0])
-		if response.Success {
-			return fmt.Sprintf(":%d", response.Data.(int64))
+		score, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return "-ERR value is not a valid float"
+		}
+		mode := commands.ScoreSet
+		if len(args) == 4 {
+			mode = strings.ToLower(args[3])
+		}
+		entry, err := core.Result[commands.LeaderboardEntry](s.leaderboardCommands.Record(args[0], args[1], score, mode))
+		if err != nil {
+			return protocolError(err)
 		}
-		return fmt.Sprintf("-ERR %s", response.Error)
+		return formatArray([]string{strconv.Itoa(entry.Rank), formatScore(entry.Score)})
 		
-	case "DECR":
-		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'decr' command"
+	case "LBRANK":
+		// LBRANK board member [AROUND n], replies rank, member, score triples
+		if len(args) != 2 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'lbrank' command"
 		}
-		response := s.stringCommands.Decr(args[0])
-		if response.Success {
-			return fmt.Sprintf(":%d", response.Data.(int64))
+		around := 0
+		if len(args) == 4 {
+			if strings.ToUpper(args[2]) != "AROUND" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.Atoi(args[3])
+			if err != nil || n < 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			around = n
+		}
+		rank, err := core.Result[commands.LeaderboardRank](s.leaderboardCommands.Rank(args[0], args[1], around))
+		if errors.Is(err, errs.ErrNotFound) {
+			return "*-1"
+		}
+		if err != nil {
+			return protocolError(err)
 		}
-		return fmt.Sprintf("-ERR %s", response.Error)
+		return formatLeaderboard(rank.Around)
 		
-	case "APPEND":
+	case "LBTOP":
+		// LBTOP board [OFFSET o] [LIMIT n]
+		if len(args) < 1 || len(args)%2 != 1 {
+			return "-ERR wrong number of arguments for 'lbtop' command"
+		}
+		offset, limit := 0, 10
+		for i := 1; i < len(args); i += 2 {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			switch strings.ToUpper(args[i]) {
+			case "OFFSET":
+				offset = n
+			case "LIMIT":
+				limit = n
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		page, err := core.Result[commands.LeaderboardPage](s.leaderboardCommands.Top(args[0], offset, limit))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatLeaderboard(page.Entries)
+		
+	case "LBREM":
 		if len(args) != 2 {
-			return "-ERR wrong number of arguments for 'append' command"
+			return "-ERR wrong number of arguments for 'lbrem' command"
 		}
-		response := s.stringCommands.Append(args[0], args[1])
-		if response.Success {
-			return fmt.Sprintf(":%d", response.Data.(int))
+		removed, err := core.Result[bool](s.leaderboardCommands.Remove(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		if removed {
+			return ":1"
 		}
-		return fmt.Sprintf("-ERR %s", response.Error)
+		return ":0"
 		
-	case "STRLEN":
+	case "LBRESET":
 		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'strlen' command"
+			return "-ERR wrong number of arguments for 'lbreset' command"
 		}
-		response := s.stringCommands.Strlen(args[0])
-		if response.Success {
-			return fmt.Sprintf(":%d", response.Data.(int))
+		reset, err := core.Result[bool](s.leaderboardCommands.Reset(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		if reset {
+			return ":1"
 		}
-		return fmt.Sprintf("-ERR %s", response.Error)
+		return ":0"
+		
+	case "LBSCHEDULE":
+		// LBSCHEDULE board hourly|daily|weekly|monthly|none
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'lbschedule' command"
+		}
+		response := s.leaderboardCommands.Schedule(args[0], strings.ToLower(args[1]))
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "ZADD":
+		// ZADD key [NX|XX] [GT|LT] [CH] score member [score member ...]
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'zadd' command"
+		}
+		var opts commands.ZAddOptions
+		i := 1
+	flags:
+		for ; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				opts.NX = true
+			case "XX":
+				opts.XX = true
+			case "GT":
+				opts.GT = true
+			case "LT":
+				opts.LT = true
+			case "CH":
+				opts.CH = true
+			default:
+				break flags
+			}
+		}
+		pairs := args[i:]
+		if len(pairs) == 0 || len(pairs)%2 != 0 {
+			return "-ERR syntax error"
+		}
+		members := make([]commands.ZSetEntry, 0, len(pairs)/2)
+		for j := 0; j < len(pairs); j += 2 {
+			score, err := strconv.ParseFloat(pairs[j], 64)
+			if err != nil {
+				return "-ERR value is not a valid float"
+			}
+			members = append(members, commands.ZSetEntry{Member: pairs[j+1], Score: score})
+		}
+		n, err := core.Result[int](s.zsetCommands.ZAdd(args[0], members, opts))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "ZUNIONSTORE", "ZINTERSTORE":
+		// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+		if len(args) < 3 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil || numKeys < 1 || len(args) < 2+numKeys {
+			return "-ERR numkeys must be a positive integer no greater than the number of keys"
+		}
+		keys := args[2 : 2+numKeys]
+		var weights []float64
+		aggregate := ""
+		for i := 2 + numKeys; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "WEIGHTS":
+				if i+numKeys >= len(args) {
+					return "-ERR syntax error"
+				}
+				for _, raw := range args[i+1 : i+1+numKeys] {
+					weight, err := strconv.ParseFloat(raw, 64)
+					if err != nil {
+						return "-ERR weight value is not a float"
+					}
+					weights = append(weights, weight)
+				}
+				i += numKeys
+			case "AGGREGATE":
+				if i+1 >= len(args) {
+					return "-ERR syntax error"
+				}
+				aggregate = strings.ToLower(args[i+1])
+				i++
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		store := s.zsetCommands.ZUnionStore
+		if command == "ZINTERSTORE" {
+			store = s.zsetCommands.ZInterStore
+		}
+		n, err := core.Result[int](store(args[0], keys, weights, aggregate))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "ZPOPMIN", "ZPOPMAX":
+		// ZPOPMIN key [count]
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		count := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return "-ERR value is out of range, must be positive"
+			}
+			count = n
+		}
+		pop := s.zsetCommands.ZPopMin
+		if command == "ZPOPMAX" {
+			pop = s.zsetCommands.ZPopMax
+		}
+		entries, err := core.Result[[]commands.ZSetEntry](pop(args[0], count))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatZSetEntries(entries, false)
+		
+	case "ZMPOP":
+		// ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'zmpop' command"
+		}
+		numKeys, err := strconv.Atoi(args[0])
+		if err != nil || numKeys < 1 || len(args) < 2+numKeys {
+			return "-ERR numkeys must be a positive integer no greater than the number of keys"
+		}
+		keys, rest := args[1:1+numKeys], args[1+numKeys:]
+		where := strings.ToUpper(rest[0])
+		if where != "MIN" && where != "MAX" {
+			return "-ERR syntax error"
+		}
+		count := 1
+		if len(rest) == 3 && strings.EqualFold(rest[1], "COUNT") {
+			n, err := strconv.Atoi(rest[2])
+			if err != nil || n < 1 {
+				return "-ERR count should be greater than 0"
+			}
+			count = n
+		} else if len(rest) != 1 {
+			return "-ERR syntax error"
+		}
+		entries, err := core.Result[[]commands.ZSetEntry](s.zsetCommands.ZMPop(keys, where == "MAX", count))
+		if err != nil {
+			return protocolError(err)
+		}
+		if len(entries) == 0 {
+			return "*-1"
+		}
+		// [key, [member, score, ...]]
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n%s", len(entries[0].Key), entries[0].Key, formatZSetEntries(entries, false))
+		
+	case "BZPOPMIN":
+		// BZPOPMIN key [key ...] timeout
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'bzpopmin' command"
+		}
+		seconds, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+			return "-ERR timeout is not a float or out of range"
+		}
+		timeout := time.Duration(seconds * float64(time.Second))
+		entries, err := core.Result[[]commands.ZSetEntry](s.zsetCommands.BZPopMin(ctx, args[:len(args)-1], timeout))
+		if err != nil {
+			return protocolError(err)
+		}
+		if len(entries) == 0 {
+			return "*-1"
+		}
+		return formatZSetEntries(entries, true)
+		
+	case "ZRANGEBYLEX":
+		// ZRANGEBYLEX key min max [LIMIT offset count]
+		if len(args) != 3 && len(args) != 6 {
+			return "-ERR wrong number of arguments for 'zrangebylex' command"
+		}
+		offset, count := 0, -1
+		if len(args) == 6 {
+			if !strings.EqualFold(args[3], "LIMIT") {
+				return "-ERR syntax error"
+			}
+			o, err1 := strconv.Atoi(args[4])
+			c, err2 := strconv.Atoi(args[5])
+			if err1 != nil || err2 != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			offset, count = o, c
+		}
+		members, err := core.Result[[]string](s.zsetCommands.ZRangeByLex(args[0], args[1], args[2], offset, count))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray(members)
+		
+	case "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX", "ZREMRANGEBYRANK":
+		if len(args) != 3 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		var response *core.Response
+		switch command {
+		case "ZREMRANGEBYSCORE":
+			response = s.zsetCommands.ZRemRangeByScore(args[0], args[1], args[2])
+		case "ZREMRANGEBYLEX":
+			response = s.zsetCommands.ZRemRangeByLex(args[0], args[1], args[2])
+		default:
+			start, err1 := strconv.Atoi(args[1])
+			stop, err2 := strconv.Atoi(args[2])
+			if err1 != nil || err2 != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			response = s.zsetCommands.ZRemRangeByRank(args[0], start, stop)
+		}
+		n, err := core.Result[int](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "CINCR":
+		// CINCR counter [amount] [AT unix-seconds], replies resolution/value pairs
+		if len(args) < 1 || len(args) > 4 || len(args) == 3 {
+			return "-ERR wrong number of arguments for 'cincr' command"
+		}
+		amount := int64(1)
+		var at time.Time
+		rest := args[1:]
+		if len(rest)%2 == 1 {
+			n, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			amount = n
+			rest = rest[1:]
+		}
+		if len(rest) == 2 {
+			if strings.ToUpper(rest[0]) != "AT" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.ParseInt(rest[1], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			at = time.Unix(n, 0)
+		}
+		values, err := core.Result[map[string]int64](s.counterCommands.Incr(args[0], amount, at))
+		if err != nil {
+			return protocolError(err)
+		}
+		items := make([]string, 0, len(values)*2)
+		for _, resolution := range commands.CounterResolutions {
+			if value, ok := values[resolution.Name]; ok {
+				items = append(items, resolution.Name, strconv.FormatInt(value, 10))
+			}
+		}
+		return formatArray(items)
+		
+	case "CRANGE":
+		// CRANGE counter minute|hour|day from to, replies timestamp/value pairs
+		if len(args) != 4 {
+			return "-ERR wrong number of arguments for 'crange' command"
+		}
+		from, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		to, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		series, err := core.Result[commands.CounterSeries](s.counterCommands.Range(args[0], strings.ToLower(args[1]), time.Unix(from, 0), time.Unix(to, 0)))
+		if err != nil {
+			return protocolError(err)
+		}
+		items := make([]int64, 0, len(series.Points)*2)
+		for _, point := range series.Points {
+			items = append(items, point.Timestamp, point.Value)
+		}
+		return formatIntArray(items...)
+		
+	case "BF.RESERVE":
+		// BF.RESERVE key error_rate capacity
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'bf.reserve' command"
+		}
+		errorRate, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return "-ERR value is not a valid float"
+		}
+		capacity, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		response := s.sketchCommands.BFReserve(args[0], errorRate, capacity)
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "BF.ADD", "BF.MADD":
+		if len(args) < 2 || (command == "BF.ADD" && len(args) != 2) {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		added, err := core.Result[[]bool](s.sketchCommands.BFAdd(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(command == "BF.ADD", added)
+		
+	case "BF.EXISTS", "BF.MEXISTS":
+		if len(args) < 2 || (command == "BF.EXISTS" && len(args) != 2) {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		exists, err := core.Result[[]bool](s.sketchCommands.BFExists(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(command == "BF.EXISTS", exists)
+		
+	case "BF.INFO":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'bf.info' command"
+		}
+		info, err := core.Result[map[string]interface{}](s.sketchCommands.BFInfo(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		items := []string{}
+		for _, field := range []string{"capacity", "error_rate", "hashes", "size", "items"} {
+			items = append(items, field, fmt.Sprint(info[field]))
+		}
+		return formatArray(items)
+		
+	case "CMS.INITBYDIM":
+		// CMS.INITBYDIM key width depth
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'cms.initbydim' command"
+		}
+		width, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		depth, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		response := s.sketchCommands.CMSInitByDim(args[0], uint32(width), uint32(depth))
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "CMS.INITBYPROB":
+		// CMS.INITBYPROB key error probability
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'cms.initbyprob' command"
+		}
+		errorRate, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return "-ERR value is not a valid float"
+		}
+		probability, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return "-ERR value is not a valid float"
+		}
+		response := s.sketchCommands.CMSInitByProb(args[0], errorRate, probability)
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "CMS.INCRBY":
+		// CMS.INCRBY key item increment [item increment ...]
+		if len(args) < 3 || len(args)%2 != 1 {
+			return "-ERR wrong number of arguments for 'cms.incrby' command"
+		}
+		items := make([]string, 0, len(args)/2)
+		increments := make([]uint64, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			n, err := strconv.ParseUint(args[i+1], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			items = append(items, args[i])
+			increments = append(increments, n)
+		}
+		counts, err := core.Result[[]uint64](s.sketchCommands.CMSIncrBy(args[0], items, increments))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatUintArray(counts)
+		
+	case "CMS.QUERY":
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'cms.query' command"
+		}
+		counts, err := core.Result[[]uint64](s.sketchCommands.CMSQuery(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatUintArray(counts)
+		
+	case "CF.RESERVE":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'cf.reserve' command"
+		}
+		capacity, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		response := s.sketchCommands.CFReserve(args[0], capacity)
+		if !response.Success {
+			return protocolError(response.Failure())
+		}
+		return "+OK"
+		
+	case "CF.ADD", "CF.ADDNX":
+		if len(args) != 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		added, err := core.Result[bool](s.sketchCommands.CFAdd(args[0], args[1], command == "CF.ADDNX"))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(true, []bool{added})
+		
+	case "CF.DEL":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'cf.del' command"
+		}
+		deleted, err := core.Result[bool](s.sketchCommands.CFDel(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(true, []bool{deleted})
+		
+	case "CF.EXISTS", "CF.MEXISTS":
+		if len(args) < 2 || (command == "CF.EXISTS" && len(args) != 2) {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		exists, err := core.Result[[]bool](s.sketchCommands.CFExists(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(command == "CF.EXISTS", exists)
+		
+	case "CF.COUNT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'cf.count' command"
+		}
+		count, err := core.Result[int](s.sketchCommands.CFCount(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(count))
+		
+	case "PN.INCRBY", "PN.INCR", "PN.DECR":
+		if (command == "PN.INCRBY" && len(args) != 2) || (command != "PN.INCRBY" && len(args) != 1) {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		delta := int64(1)
+		if command == "PN.DECR" {
+			delta = -1
+		} else if command == "PN.INCRBY" {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			delta = n
+		}
+		value, err := core.Result[int64](s.crdtCommands.PNIncrBy(args[0], delta))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(value)
+		
+	case "PN.GET":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'pn.get' command"
+		}
+		value, err := core.Result[int64](s.crdtCommands.PNGet(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(value)
+		
+	case "OR.ADD", "OR.REM":
+		if len(args) < 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		response := s.crdtCommands.ORAdd(args[0], args[1:]...)
+		if command == "OR.REM" {
+			response = s.crdtCommands.ORRem(args[0], args[1:]...)
+		}
+		n, err := core.Result[int](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "OR.MEMBERS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'or.members' command"
+		}
+		members, err := core.Result[[]string](s.crdtCommands.ORMembers(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray(members)
+		
+	case "OR.ISMEMBER":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'or.ismember' command"
+		}
+		member, err := core.Result[bool](s.crdtCommands.ORIsMember(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(true, []bool{member})
+		
+	case "CRDT.STATE":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'crdt.state' command"
+		}
+		state, err := core.Result[commands.CRDTState](s.crdtCommands.State(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBulk(string(data))
+		
+	case "CRDT.MERGE":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'crdt.merge' command"
+		}
+		var state commands.CRDTState
+		if err := json.Unmarshal([]byte(args[1]), &state); err != nil {
+			return "-ERR state is not valid JSON"
+		}
+		changed, err := core.Result[bool](s.crdtCommands.Merge(args[0], state))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(true, []bool{changed})
+		
+	case "WAIT":
+		// WAIT numreplicas timeout-ms blocks until numreplicas replicas
+		// have acknowledged every write so far (see AckReplica), or the
+		// timeout passes, and replies how many have. A timeout of 0
+		// waits until the client disconnects.
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'wait' command"
+		}
+		numReplicas, err := strconv.Atoi(args[0])
+		if err != nil || numReplicas < 0 {
+			return "-ERR value is not an integer or out of range"
+		}
+		timeout, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || timeout < 0 {
+			return "-ERR timeout is not an integer or out of range"
+		}
+		waitCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+			defer cancel()
+		}
+		return formatInt(int64(s.db.WaitReplicas(waitCtx, s.db.SessionToken().Offset, numReplicas)))
+		
+	case "OBJECT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'object' command"
+		}
+		value, exists := s.db.Object(args[1])
+		if !exists {
+			return "$-1"
+		}
+		switch strings.ToUpper(args[0]) {
+		case "FREQ":
+			return formatInt(int64(value.AccessFrequency(s.db.Clock().Now())))
+		case "IDLETIME":
+			return formatInt(int64(value.IdleTime(s.db.Clock().Now()).Seconds()))
+		case "ENCODING":
+			return fmt.Sprintf("+%s", core.Encoding(value))
+		default:
+			return fmt.Sprintf("-ERR unknown subcommand '%s'", args[0])
+		}
+		
+	case "MEMORY":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'memory' command"
+		}
+		switch strings.ToUpper(args[0]) {
+		case "USAGE":
+			if len(args) != 2 {
+				return "-ERR wrong number of arguments for 'memory usage' command"
+			}
+			bytes, exists := s.db.MemoryUsage(args[1])
+			if !exists {
+				return "$-1"
+			}
+			return formatInt(bytes)
+		case "STATS":
+			stats := s.db.MemoryStats()
+			items := []string{
+				"total.allocated", strconv.FormatInt(stats.TotalBytes, 10),
+				"keys.count", strconv.Itoa(stats.Keys),
+				"keys.bytes-per-key", strconv.FormatInt(stats.BytesPerKey, 10),
+				"maxmemory", strconv.FormatInt(stats.MaxMemory, 10),
+			}
+			types := make([]string, 0, len(stats.ByType))
+			for name := range stats.ByType {
+				types = append(types, name)
+			}
+			sort.Strings(types)
+			for _, name := range types {
+				items = append(items, "type."+name, strconv.FormatInt(stats.ByType[name], 10))
+			}
+			return formatArray(items)
+		default:
+			return fmt.Sprintf("-ERR unknown subcommand '%s'", args[0])
+		}
+		
+	case "CONFIG":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'config' command"
+		}
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			if len(args) != 2 {
+				return "-ERR wrong number of arguments for 'config get' command"
+			}
+			params := s.config.Get(args[1])
+			names := make([]string, 0, len(params))
+			for name := range params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			items := make([]string, 0, len(params)*2)
+			for _, name := range names {
+				items = append(items, name, params[name])
+			}
+			return formatArray(items)
+		case "SET":
+			if len(args) != 3 {
+				return "-ERR wrong number of arguments for 'config set' command"
+			}
+			if !s.isAdmin(ctx) {
+				return protocolError(errs.Newf(errs.ErrNoAuth, "the configuration can only be changed by admin clients"))
+			}
+			if err := s.config.Set(args[1], args[2]); err != nil {
+				return fmt.Sprintf("-ERR %v", err)
+			}
+			return "+OK"
+		case "RELOAD":
+			if !s.isAdmin(ctx) {
+				return protocolError(errs.Newf(errs.ErrNoAuth, "the configuration can only be changed by admin clients"))
+			}
+			if err := s.config.Reload(); err != nil {
+				return fmt.Sprintf("-ERR %v", err)
+			}
+			return "+OK"
+		default:
+			return fmt.Sprintf("-ERR unknown subcommand '%s'", args[0])
+		}
+		
+	case "LATENCY":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'latency' command"
+		}
+		switch strings.ToUpper(args[0]) {
+		case "LATEST":
+			return formatLatencyLatest(s.latency.Latest())
+		case "HISTORY":
+			if len(args) != 2 {
+				return "-ERR wrong number of arguments for 'latency history' command"
+			}
+			return formatLatencyHistory(s.latency.History(strings.ToUpper(args[1])))
+		case "RESET":
+			names := make([]string, len(args)-1)
+			for i, name := range args[1:] {
+				names[i] = strings.ToUpper(name)
+			}
+			return formatInt(int64(s.latency.Reset(names...)))
+		default:
+			return fmt.Sprintf("-ERR unknown subcommand '%s'", args[0])
+		}
+		
+	case "CIRCUIT":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'circuit' command"
+		}
+		switch strings.ToUpper(args[0]) {
+		case "LIST":
+			return formatCircuitStates(s.breaker.States())
+		case "RESET":
+			// Keys are "COMMAND pattern", so a single key spans two arguments
+			if len(args) == 1 {
+				return formatInt(int64(s.breaker.Reset()))
+			}
+			return formatInt(int64(s.breaker.Reset(circuitKey(strings.ToUpper(args[1]), args[2:]))))
+		default:
+			return fmt.Sprintf("-ERR unknown subcommand '%s'", args[0])
+		}
+		
+	case "KEYSTATS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'keystats' command"
+		}
+		stats, exists := s.db.KeyStats(args[0])
+		if !exists {
+			return "*-1"
+		}
+		return formatArray([]string{
+			"hits", strconv.FormatUint(stats.Hits, 10),
+			"misses", strconv.FormatUint(stats.Misses, 10),
+			"writes", strconv.FormatUint(stats.Writes, 10),
+			"last_access", strconv.FormatInt(stats.LastAccess.Unix(), 10),
+			"last_write", strconv.FormatInt(stats.LastWrite.Unix(), 10),
+		})
+		
+	case "GETSTALE":
+		// Replies with [value, stale flag] so clients know when to revalidate
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'getstale' command"
+		}
+		result, err := core.Result[commands.StaleValue](s.stringCommands.GetStale(args[0]))
+		if errors.Is(err, errs.ErrNotFound) {
+			return "*-1"
+		}
+		if err != nil {
+			return protocolError(err)
+		}
+		stale := 0
+		if result.Stale {
+			stale = 1
+		}
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n:%d", len(result.Value), result.Value, stale)
+		
+	case "FETCH":
+		// FETCH key lock-ms [TIMEOUT ms] replies [value, stale flag, token].
+		// A token grants the computation: FILL the key with it, or ABANDON
+		// it, within lock-ms. Other callers missing the key wait for the
+		// FILL, for at most TIMEOUT ms if given, unless a stale value can
+		// be served meanwhile.
+		if len(args) != 2 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'fetch' command"
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || ms <= 0 {
+			return "-ERR invalid lock ttl"
+		}
+		fetchCtx := ctx
+		if len(args) == 4 {
+			if strings.ToUpper(args[2]) != "TIMEOUT" {
+				return "-ERR syntax error"
+			}
+			timeout, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || timeout < 0 {
+				return "-ERR timeout is not an integer or out of range"
+			}
+			var cancel context.CancelFunc
+			fetchCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+			defer cancel()
+		}
+		result, err := core.Result[commands.FetchValue](s.stringCommands.Fetch(fetchCtx, args[0], time.Duration(ms)*time.Millisecond))
+		if err != nil {
+			return protocolError(err)
+		}
+		w := newReply()
+		w.ArrayHeader(3)
+		switch {
+		case result.Null:
+			w.Raw("*-1")
+		case result.Found:
+			w.Bulk(result.Value)
+		default:
+			w.Null()
+		}
+		if result.Stale {
+			w.Int(1)
+		} else {
+			w.Int(0)
+		}
+		if result.Token != "" {
+			w.Bulk(result.Token)
+		} else {
+			w.Null()
+		}
+		return finishReply(w)
+		
+	case "FILL":
+		// FILL key token value [EX seconds] stores the value computed under
+		// a FETCH grant, replying 0 if the grant passed to another caller
+		if len(args) != 3 && len(args) != 5 {
+			return "-ERR wrong number of arguments for 'fill' command"
+		}
+		var ttl int64
+		if len(args) == 5 {
+			if strings.ToUpper(args[3]) != "EX" {
+				return "-ERR syntax error"
+			}
+			seconds, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || seconds <= 0 {
+				return "-ERR invalid expire time"
+			}
+			ttl = seconds
+		}
+		filled, err := core.Result[bool](s.stringCommands.Fill(args[0], args[1], args[2], ttl))
+		if err != nil {
+			return protocolError(err)
+		}
+		if filled {
+			return ":1"
+		}
+		return ":0"
+		
+	case "ABANDON":
+		// ABANDON key token gives up a FETCH grant, e.g. when the origin
+		// failed, so a waiting caller is granted the computation at once
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'abandon' command"
+		}
+		if s.db.Abandon(args[0], args[1]) {
+			return ":1"
+		}
+		return ":0"
+		
+	case "GET":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'get' command"
+		}
+		response := s.stringCommands.Get(args[0])
+		if response.Type == "null" {
+			return "*-1"
+		}
+		if response.Success && response.Data != nil {
+			return formatBulk(response.Data.(string))
+		}
+		return "$-1"
+		
+	case "MGET":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'mget' command"
+		}
+		w := newReply()
+		w.ArrayHeader(len(args))
+		for _, value := range s.db.GetMany(args) {
+			if value == nil || value.Type != core.STRING {
+				w.Null()
+			} else {
+				w.Bulk(value.Data.(string))
+			}
+		}
+		return finishReply(w)
+		
+	case "PIPELINE":
+		// PIPELINE key [key ...]
+		// Reads keys of any type at once, replying one [type, ttl, value]
+		// array per key
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'pipeline' command"
+		}
+		results, err := core.Result[[]commands.PipelineResult](s.pipelineCommands.Get(args))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatPipeline(results)
+		
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return "-ERR wrong number of arguments for 'mset' command"
+		}
+		values := make(map[string]*core.TriffValue, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			values[args[i]] = &core.TriffValue{Type: core.STRING, Data: args[i+1]}
+		}
+		if err := s.db.SetMany(values); err != nil {
+			return protocolError(err)
+		}
+		return "+OK"
+		
+	case "MSETNX":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return "-ERR wrong number of arguments for 'msetnx' command"
+		}
+		values := make(map[string]*core.TriffValue, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			values[args[i]] = &core.TriffValue{Type: core.STRING, Data: args[i+1]}
+		}
+		set, err := s.db.SetManyIfAbsent(values)
+		if err != nil {
+			return protocolError(err)
+		}
+		if !set {
+			return ":0"
+		}
+		return ":1"
+		
+	case "SWAP":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'swap' command"
+		}
+		swapped, err := s.db.Swap(args[0], args[1])
+		if err != nil {
+			return protocolError(err)
+		}
+		if !swapped {
+			return ":0"
+		}
+		return ":1"
+		
+	case "RESTOREKEY", "UNDELETE":
+		if len(args) != 1 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		switch err := s.db.Restore(args[0]); err {
+		case nil:
+			return ":1"
+		case core.ErrNoTombstone:
+			return ":0"
+		default:
+			return fmt.Sprintf("-ERR %v", err)
+		}
+		
+	case "GETREV":
+		// GETREV key n returns the value n versions back, 0 being the current one
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'getrev' command"
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return "-ERR value is not an integer or out of range"
+		}
+		revision, exists := s.db.Revision(args[0], n)
+		if !exists {
+			return "$-1"
+		}
+		data, ok := revision.Data.(string)
+		if !ok {
+			encoded, err := json.Marshal(revision.Data)
+			if err != nil {
+				return fmt.Sprintf("-ERR %v", err)
+			}
+			data = string(encoded)
+		}
+		return formatBulk(data)
+		
+	case "DEL":
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'del' command"
+		}
+		count := 0
+		for _, key := range args {
+			if s.db.Delete(key) {
+				count++
+			}
+		}
+		return formatInt(int64(count))
+		
+	case "EXISTS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'exists' command"
+		}
+		if s.db.Exists(args[0]) {
+			return ":1"
+		}
+		return ":0"
+		
+	case "KEYS":
+		pattern := "*"
+		if len(args) > 0 {
+			pattern = args[0]
+		}
+		keys, err := s.db.KeysContext(ctx, pattern)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray(keys)
+		
+	case "SCAN":
+		// SCAN cursor [MATCH pattern] [COUNT n] [TYPE type]
+		if len(args) == 0 || len(args)%2 != 1 {
+			return "-ERR wrong number of arguments for 'scan' command"
+		}
+		var opts core.ScanOptions
+		for i := 1; i < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				opts.Match = args[i+1]
+			case "COUNT":
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n <= 0 {
+					return "-ERR value is not an integer or out of range"
+				}
+				opts.Count = n
+			case "TYPE":
+				opts.Type = strings.ToLower(args[i+1])
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		keys, next, err := s.db.Scan(ctx, args[0], opts)
+		if err != nil {
+			return protocolError(err)
+		}
+		w := newReply()
+		w.ArrayHeader(2)
+		w.Bulk(next)
+		w.ArrayHeader(len(keys))
+		for _, key := range keys {
+			w.Bulk(key)
+		}
+		return finishReply(w)
+		
+	case "DELPATTERN":
+		// DELPATTERN pattern [COUNT batch]
+		if len(args) != 1 && len(args) != 3 {
+			return "-ERR wrong number of arguments for 'delpattern' command"
+		}
+		batch := 1000
+		if len(args) == 3 {
+			if strings.ToUpper(args[1]) != "COUNT" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			batch = n
+		}
+		deleted, err := s.db.DeletePatternContext(ctx, args[0], batch)
+		if err != nil {
+			return protocolError(fmt.Errorf("%w after deleting %d keys", err, deleted))
+		}
+		return formatInt(int64(deleted))
+		
+	case "SCHEDULE":
+		return s.schedule(ctx, args)
+		
+	case "PARTITION":
+		// PARTITION LIST replies [name, start, expires, keys] per time
+		// partition; PARTITION DROP name drops one before it expires
+		if len(args) == 1 && strings.ToUpper(args[0]) == "LIST" {
+			return formatPartitions(s.db.Partitions())
+		}
+		if len(args) != 2 || strings.ToUpper(args[0]) != "DROP" {
+			return "-ERR syntax error, expected PARTITION LIST or PARTITION DROP <name>"
+		}
+		dropped, err := s.db.DropPartition(args[1])
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(dropped))
+		
+	case "EXPIREPATTERN", "PERSISTPATTERN":
+		// EXPIREPATTERN pattern seconds [COUNT batch] / PERSISTPATTERN pattern [COUNT batch]
+		// Runs in the background and replies with a job ID for BULKJOB
+		name := strings.ToLower(command)
+		rest := args
+		var seconds int64
+		if command == "EXPIREPATTERN" {
+			if len(args) < 2 {
+				return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", name)
+			}
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR invalid expire time"
+			}
+			seconds = n
+			rest = append([]string{args[0]}, args[2:]...)
+		}
+		if len(rest) != 1 && len(rest) != 3 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", name)
+		}
+		batch := 1000
+		if len(rest) == 3 {
+			if strings.ToUpper(rest[1]) != "COUNT" {
+				return "-ERR syntax error"
+			}
+			n, err := strconv.Atoi(rest[2])
+			if err != nil || n <= 0 {
+				return "-ERR value is not an integer or out of range"
+			}
+			batch = n
+		}
+		job := s.db.ExpirePattern(rest[0], seconds, batch)
+		return formatBulk(job.ID)
+		
+	case "BULKJOB":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'bulkjob' command"
+		}
+		job, exists := s.db.BulkJob(args[0])
+		if !exists {
+			return "*-1"
+		}
+		return formatArray([]string{
+			"id", job.ID,
+			"operation", job.Operation,
+			"pattern", job.Pattern,
+			"total", strconv.FormatInt(job.Total, 10),
+			"processed", strconv.FormatInt(job.Processed, 10),
+			"updated", strconv.FormatInt(job.Updated, 10),
+			"done", strconv.FormatBool(job.Done),
+			"tag", job.Tag,
+		})
+		
+	case "TAG", "UNTAG":
+		// TAG key tag [tag ...] / UNTAG key tag [tag ...]
+		// Replies with the number of tags added or removed, 0 for a
+		// missing key
+		if len(args) < 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		change := s.db.Tag
+		if command == "UNTAG" {
+			change = s.db.Untag
+		}
+		n, err := change(args[0], args[1:]...)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "TAGS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'tags' command"
+		}
+		tags, _ := s.db.KeyTags(args[0])
+		return formatArray(tags)
+		
+	case "TAGKEYS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'tagkeys' command"
+		}
+		return formatArray(s.db.TaggedKeys(args[0]))
+		
+	case "INVALIDATETAG":
+		// INVALIDATETAG tag [EXPIRE seconds] [COUNT batch]
+		// Runs in the background and replies with a job ID for BULKJOB
+		if len(args) == 0 || len(args)%2 != 1 {
+			return "-ERR wrong number of arguments for 'invalidatetag' command"
+		}
+		var seconds int64
+		batch := 1000
+		for i := 1; i < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "EXPIRE":
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n <= 0 {
+					return "-ERR invalid expire time"
+				}
+				seconds = n
+			case "COUNT":
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n <= 0 {
+					return "-ERR value is not an integer or out of range"
+				}
+				batch = n
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		job := s.db.InvalidateTag(args[0], seconds, batch)
+		return formatBulk(job.ID)
+		
+	case "DEPEND", "UNDEPEND":
+		// DEPEND key dependency [dependency ...] /
+		// UNDEPEND key dependency [dependency ...]
+		// Replies with the number of dependencies added or removed, 0 for
+		// a missing key
+		if len(args) < 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		change := s.db.Depend
+		if command == "UNDEPEND" {
+			change = s.db.Undepend
+		}
+		n, err := change(args[0], args[1:]...)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "DEPENDENCIES":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'dependencies' command"
+		}
+		dependencies, _ := s.db.KeyDependencies(args[0])
+		return formatArray(dependencies)
+		
+	case "DEPENDENTS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'dependents' command"
+		}
+		return formatArray(s.db.Dependents(args[0]))
+		
+	case "SAVE":
+		if len(args) != 0 {
+			return "-ERR wrong number of arguments for 'save' command"
+		}
+		if err := s.db.Save(); err != nil {
+			return protocolError(err)
+		}
+		return "+OK"
+		
+	case "RESTORE":
+		// RESTORE LIST | RESTORE TO <RFC 3339 time | Unix seconds> |
+		// RESTORE BULK [REPLACE] [NOSAVE] PAIRS key value [key value ...]
+		if len(args) > 0 && strings.ToUpper(args[0]) == "BULK" {
+			return s.restoreBulk(args[1:])
+		}
+		if len(args) == 1 && strings.ToUpper(args[0]) == "LIST" {
+			snapshots, err := s.db.Snapshots()
+			if err != nil {
+				return protocolError(err)
+			}
+			return formatSnapshots(snapshots)
+		}
+		if len(args) != 2 || strings.ToUpper(args[0]) != "TO" {
+			return "-ERR syntax error, expected RESTORE LIST, RESTORE TO <timestamp> or RESTORE BULK"
+		}
+		to, err := core.ParseRestoreTime(args[1])
+		if err != nil {
+			return protocolError(err)
+		}
+		snapshot, err := s.db.RestoreTo(to)
+		if err != nil {
+			return protocolError(err)
+		}
+		return "+OK restored " + snapshot.Name
+		
+	case "FLUSHALL":
+		// FLUSHALL [ASYNC|SYNC] [CONFIRM token]. The keyspace is swapped out
+		// in constant time and reclaimed by the garbage collector, so both
+		// modes return at once.
+		token := ""
+		if n := len(args); n >= 2 && strings.ToUpper(args[n-2]) == "CONFIRM" {
+			token, args = args[n-1], args[:n-2]
+		}
+		if len(args) > 1 {
+			return "-ERR wrong number of arguments for 'flushall' command"
+		}
+		if len(args) == 1 {
+			mode := strings.ToUpper(args[0])
+			if mode != "ASYNC" && mode != "SYNC" {
+				return "-ERR syntax error"
+			}
+		}
+		flush := s.db.Config().Security.Flush
+		confirm, err := s.flushGuard.check(flush, s.isAdmin(ctx), token)
+		if err != nil {
+			return protocolError(err)
+		}
+		if confirm != "" {
+			return fmt.Sprintf("-CONFIRM %s send FLUSHALL CONFIRM %s within %s to flush", confirm, confirm, flushConfirmTTL(flush))
+		}
+		s.db.FlushAll()
+		return "+OK"
+		
+	case "INFO":
+		info := s.db.Info()
+		result := ""
+		for key, value := range info {
+			result += fmt.Sprintf("%s:%v\r\n", key, value)
+		}
+		return formatBulk(result)
+		
+	case "DBSIZE":
+		size := s.db.Size()
+		return formatInt(size)
+		
+	case "TTL":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'ttl' command"
+		}
+		ttl := s.db.GetTTL(args[0])
+		return formatInt(ttl)
+		
+	case "EXPIRE":
+		// EXPIRE key seconds [JITTER]
+		if len(args) != 2 && len(args) != 3 {
+			return "-ERR wrong number of arguments for 'expire' command"
+		}
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "-ERR invalid expire time"
+		}
+		if len(args) == 3 {
+			if strings.ToUpper(args[2]) != "JITTER" {
+				return "-ERR syntax error"
+			}
+			seconds = s.db.JitterTTL(seconds)
+		}
+		if s.db.SetTTL(args[0], seconds) {
+			return ":1"
+		}
+		return ":0"
+		
+	case "INCR":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'incr' command"
+		}
+		n, err := core.Result[int64](s.stringCommands.Incr(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(n)
+		
+	case "DECR":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'decr' command"
+		}
+		n, err := core.Result[int64](s.stringCommands.Decr(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(n)
+		
+	case "APPEND":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'append' command"
+		}
+		n, err := core.Result[int](s.stringCommands.Append(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "STRLEN":
+		// STRLEN key [BYTES | CHARS]
+		if len(args) != 1 && len(args) != 2 {
+			return "-ERR wrong number of arguments for 'strlen' command"
+		}
+		chars, ok := lengthUnit(args[1:])
+		if !ok {
+			return "-ERR syntax error"
+		}
+		response := s.stringCommands.Strlen(args[0])
+		if chars {
+			response = s.stringCommands.StrlenChars(args[0])
+		}
+		n, err := core.Result[int](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "GETRANGE":
+		// GETRANGE key start end [BYTES | CHARS]
+		if len(args) != 3 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'getrange' command"
+		}
+		start, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		end, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		chars, ok := lengthUnit(args[3:])
+		if !ok {
+			return "-ERR syntax error"
+		}
+		response := s.stringCommands.GetRange(args[0], start, end)
+		if chars {
+			response = s.stringCommands.GetRangeChars(args[0], start, end)
+		}
+		value, err := core.Result[string](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBulk(value)
+		
+	case "HSET":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'hset' command"
+		}
+		n, err := core.Result[int](s.hashCommands.HSet(args[0], args[1], args[2]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "HGET":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'hget' command"
+		}
+		response := s.hashCommands.HGet(args[0], args[1])
+		if response.Success && response.Data != nil {
+			return formatBulk(response.Data.(string))
+		}
+		if response.Error != "" {
+			return protocolError(response.Failure())
+		}
+		return "$-1"
+		
+	case "HDEL":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'hdel' command"
+		}
+		n, err := core.Result[int](s.hashCommands.HDel(args[0], args[1]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "HGETALL":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'hgetall' command"
+		}
+		fields, err := core.Result[map[string]string](s.hashCommands.HGetAll(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		items := make([]string, 0, len(fields)*2)
+		for _, field := range names {
+			items = append(items, field, fields[field])
+		}
+		return formatArray(items)
+		
+	case "HINCRBY":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'hincrby' command"
+		}
+		increment, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		n, err := core.Result[int64](s.hashCommands.HIncrBy(args[0], args[1], increment))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(n)
+		
+	case "HINCRBYFLOAT":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'hincrbyfloat' command"
+		}
+		increment, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return "-ERR value is not a valid float"
+		}
+		value, err := core.Result[string](s.hashCommands.HIncrByFloat(args[0], args[1], increment))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBulk(value)
+		
+	case "HSETNX":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'hsetnx' command"
+		}
+		n, err := core.Result[int](s.hashCommands.HSetNX(args[0], args[1], args[2]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "HRANDFIELD":
+		// HRANDFIELD key [count [WITHVALUES]]
+		if len(args) < 1 || len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "WITHVALUES")) {
+			return "-ERR wrong number of arguments for 'hrandfield' command"
+		}
+		count := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			count = n
+		}
+		items, err := core.Result[[]string](s.hashCommands.HRandField(args[0], count, len(args) == 3))
+		if err != nil {
+			return protocolError(err)
+		}
+		if len(args) == 1 {
+			// Without a count the reply is a single field, or nil
+			if len(items) == 0 {
+				return "$-1"
+			}
+			return formatBulk(items[0])
+		}
+		return formatArray(items)
+		
+	case "SADD", "SREM":
+		// SADD key member [member ...]
+		if len(args) < 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		update := s.setCommands.SAdd
+		if command == "SREM" {
+			update = s.setCommands.SRem
+		}
+		n, err := core.Result[int](update(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'smembers' command"
+		}
+		members, err := core.Result[[]string](s.setCommands.SMembers(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray(members)
+		
+	case "SCARD":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'scard' command"
+		}
+		n, err := core.Result[int](s.setCommands.SCard(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "SISMEMBER", "SMISMEMBER":
+		// SMISMEMBER key member [member ...]
+		if len(args) < 2 || (command == "SISMEMBER" && len(args) != 2) {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		found, err := core.Result[[]bool](s.setCommands.SMIsMember(args[0], args[1:]...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(command == "SISMEMBER", found)
+		
+	case "SRANDMEMBER", "SPOP":
+		// SRANDMEMBER key [count]
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		count := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || (command == "SPOP" && n < 0) {
+				return "-ERR value is out of range, must be positive"
+			}
+			count = n
+		}
+		var response *core.Response
+		if command == "SPOP" {
+			response = s.setCommands.SPop(args[0], count)
+		} else {
+			response = s.setCommands.SRandMember(args[0], count)
+		}
+		members, err := core.Result[[]string](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		if len(args) == 1 {
+			// Without a count the reply is a single member, or nil
+			if len(members) == 0 {
+				return "$-1"
+			}
+			return formatBulk(members[0])
+		}
+		return formatArray(members)
+		
+	case "SMOVE":
+		// SMOVE source destination member
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'smove' command"
+		}
+		moved, err := core.Result[bool](s.setCommands.SMove(args[0], args[1], args[2]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatBools(true, []bool{moved})
+		
+	case "SINTERCARD":
+		// SINTERCARD numkeys key [key ...] [LIMIT limit]
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'sintercard' command"
+		}
+		numKeys, err := strconv.Atoi(args[0])
+		if err != nil || numKeys < 1 || len(args) < 1+numKeys {
+			return "-ERR numkeys should be greater than 0"
+		}
+		keys, rest := args[1:1+numKeys], args[1+numKeys:]
+		limit := 0
+		if len(rest) == 2 && strings.EqualFold(rest[0], "LIMIT") {
+			n, err := strconv.Atoi(rest[1])
+			if err != nil || n < 0 {
+				return "-ERR LIMIT can't be negative"
+			}
+			limit = n
+		} else if len(rest) != 0 {
+			return "-ERR syntax error"
+		}
+		n, err := core.Result[int](s.setCommands.SInterCard(limit, keys...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "LPUSH", "RPUSH":
+		// LPUSH key value [value ...] [CAP limit]
+		if len(args) < 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		values, limit := args[1:], 0
+		if len(args) >= 4 && strings.EqualFold(args[len(args)-2], "CAP") {
+			n, err := strconv.Atoi(args[len(args)-1])
+			if err != nil || n < 0 {
+				return "-ERR CAP must be a number, 0 or more"
+			}
+			values, limit = args[1:len(args)-2], n
+		}
+		push := s.listCommands.LPushCapped
+		if command == "RPUSH" {
+			push = s.listCommands.RPushCapped
+		}
+		n, err := core.Result[int](push(args[0], limit, values...))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "LPOP", "RPOP":
+		// LPOP key [count]
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Sprintf("-ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+		}
+		count := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return "-ERR value is out of range, must be positive"
+			}
+			count = n
+		}
+		pop := s.listCommands.LPop
+		if command == "RPOP" {
+			pop = s.listCommands.RPop
+		}
+		items, err := core.Result[[]string](pop(args[0], count))
+		if err != nil {
+			return protocolError(err)
+		}
+		if len(args) == 1 {
+			// Without a count the reply is a single item, or nil
+			if len(items) == 0 {
+				return "$-1"
+			}
+			return formatBulk(items[0])
+		}
+		if len(items) == 0 {
+			return "*-1"
+		}
+		return formatArray(items)
+		
+	case "LLEN":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'llen' command"
+		}
+		n, err := core.Result[int](s.listCommands.LLen(args[0]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "LRANGE":
+		// LRANGE key start stop
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'lrange' command"
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		items, err := core.Result[[]string](s.listCommands.LRange(args[0], start, stop))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatArray(items)
+		
+	case "LPOS":
+		// LPOS key element [RANK rank] [COUNT count] [MAXLEN len]
+		if len(args) < 2 || len(args)%2 != 0 {
+			return "-ERR wrong number of arguments for 'lpos' command"
+		}
+		rank, count, maxLen := 1, 1, 0
+		withCount := false
+		for i := 2; i < len(args); i += 2 {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			switch strings.ToUpper(args[i]) {
+			case "RANK":
+				rank = n
+			case "COUNT":
+				count, withCount = n, true
+			case "MAXLEN":
+				maxLen = n
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		positions, err := core.Result[[]int](s.listCommands.LPos(args[0], args[1], rank, count, maxLen))
+		if err != nil {
+			return protocolError(err)
+		}
+		if !withCount {
+			// Without COUNT the reply is the first match, or nil
+			if len(positions) == 0 {
+				return "$-1"
+			}
+			return formatInt(int64(positions[0]))
+		}
+		items := make([]int64, len(positions))
+		for i, position := range positions {
+			items[i] = int64(position)
+		}
+		return formatIntArray(items...)
+		
+	case "LINSERT":
+		// LINSERT key BEFORE|AFTER pivot element
+		if len(args) != 4 {
+			return "-ERR wrong number of arguments for 'linsert' command"
+		}
+		where := strings.ToUpper(args[1])
+		if where != "BEFORE" && where != "AFTER" {
+			return "-ERR syntax error"
+		}
+		n, err := core.Result[int](s.listCommands.LInsert(args[0], where == "BEFORE", args[2], args[3]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "LREM":
+		// LREM key count element
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'lrem' command"
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		n, err := core.Result[int](s.listCommands.LRem(args[0], count, args[2]))
+		if err != nil {
+			return protocolError(err)
+		}
+		return formatInt(int64(n))
+		
+	case "LSET":
+		// LSET key index element
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'lset' command"
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		if _, err := core.Result[string](s.listCommands.LSet(args[0], index, args[2])); err != nil {
+			return protocolError(err)
+		}
+		return "+OK"
+		
+	case "LTRIM":
+		// LTRIM key start stop
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'ltrim' command"
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		if _, err := core.Result[int](s.listCommands.LTrim(args[0], start, stop)); err != nil {
+			return protocolError(err)
+		}
+		return "+OK"
+		
+	case "LMPOP":
+		// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'lmpop' command"
+		}
+		numKeys, err := strconv.Atoi(args[0])
+		if err != nil || numKeys < 1 || len(args) < 2+numKeys {
+			return "-ERR numkeys must be a positive integer no greater than the number of keys"
+		}
+		keys, rest := args[1:1+numKeys], args[1+numKeys:]
+		where := strings.ToUpper(rest[0])
+		if where != "LEFT" && where != "RIGHT" {
+			return "-ERR syntax error"
+		}
+		count := 1
+		if len(rest) == 3 && strings.EqualFold(rest[1], "COUNT") {
+			n, err := strconv.Atoi(rest[2])
+			if err != nil || n < 1 {
+				return "-ERR count should be greater than 0"
+			}
+			count = n
+		} else if len(rest) != 1 {
+			return "-ERR syntax error"
+		}
+		popped, err := core.Result[commands.PoppedList](s.listCommands.LMPop(keys, where == "LEFT", count))
+		if err != nil {
+			return protocolError(err)
+		}
+		if popped.Key == "" {
+			return "*-1"
+		}
+		// [key, [item, ...]]
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n%s", len(popped.Key), popped.Key, formatArray(popped.Items))
+		
+	case "IDX.CREATE":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments for 'idx.create' command"
+		}
+		response := s.indexCommands.Create(args[0], args[1], args[2])
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "IDX.DROP":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'idx.drop' command"
+		}
+		response := s.indexCommands.Drop(args[0])
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "IDX.LIST":
+		response := s.indexCommands.List()
+		indexes, err := core.Result[[]core.IndexInfo](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		items := make([]string, 0, len(indexes))
+		for _, idx := range indexes {
+			items = append(items, fmt.Sprintf("%s %s %s", idx.Name, idx.Pattern, idx.Field))
+		}
+		return formatArray(items)
+		
+	case "AGG.CREATE":
+		// AGG.CREATE name COUNT|SUM|MIN|MAX pattern [field]
+		if len(args) != 3 && len(args) != 4 {
+			return "-ERR wrong number of arguments for 'agg.create' command"
+		}
+		field := ""
+		if len(args) == 4 {
+			field = args[3]
+		}
+		response := s.aggregateCommands.Create(args[0], args[1], args[2], field)
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "AGG.DROP":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'agg.drop' command"
+		}
+		response := s.aggregateCommands.Drop(args[0])
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "AGG.LIST":
+		response := s.aggregateCommands.List()
+		aggregates, err := core.Result[[]core.AggregateInfo](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		items := make([]string, 0, len(aggregates))
+		for _, agg := range aggregates {
+			items = append(items, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", agg.Name, agg.Kind, agg.Pattern, agg.Field)))
+		}
+		return formatArray(items)
+		
+	case "FIND":
+		// FIND index EQ value | RANGE min max | GT|GTE|LT|LTE value
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'find' command"
+		}
+		response := s.indexCommands.Find(args[0], args[1], args[2:])
+		if response.Success {
+			return formatArray(response.Data.([]string))
+		}
+		return protocolError(response.Failure())
+		
+	case "QUERY":
+		// QUERY SELECT fields FROM pattern [WHERE ...] [ORDER BY ...] [LIMIT n [OFFSET m]]
+		// replies one array per row: the key followed by field/value pairs
+		if len(args) == 0 {
+			return "-ERR wrong number of arguments for 'query' command"
+		}
+		// Parse the rest of the line as typed, so quoted values keep their spaces
+		query, err := core.ParseQuery(strings.TrimPrefix(strings.TrimSpace(input), parts[0]))
+		if err != nil {
+			return fmt.Sprintf("-ERR %v", err)
+		}
+		result, err := s.db.QueryContext(ctx, query)
+		if err != nil {
+			return protocolError(err)
+		}
+		w := newReply()
+		w.ArrayHeader(len(result.Rows))
+		names := []string{}
+		for _, row := range result.Rows {
+			names = names[:0]
+			for field := range row.Fields {
+				names = append(names, field)
+			}
+			sort.Strings(names)
+			w.ArrayHeader(1 + 2*len(names))
+			w.Bulk(row.Key)
+			for _, field := range names {
+				w.Bulk(field)
+				w.Bulk(row.Fields[field])
+			}
+		}
+		return finishReply(w)
+		
+	case "FT.CREATE":
+		// FT.CREATE name pattern [STEM] [FIELDS field ...]
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'ft.create' command"
+		}
+		stem := false
+		var fields []string
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "STEM":
+				stem = true
+			case "FIELDS":
+				fields = append(fields, args[i+1:]...)
+				i = len(args)
+			default:
+				return "-ERR syntax error"
+			}
+		}
+		response := s.searchCommands.Create(args[0], args[1], fields, stem)
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "FT.DROP":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'ft.drop' command"
+		}
+		response := s.searchCommands.Drop(args[0])
+		if response.Success {
+			return "+OK"
+		}
+		return protocolError(response.Failure())
+		
+	case "FT.LIST":
+		response := s.searchCommands.List()
+		indexes, err := core.Result[[]core.SearchIndexInfo](response)
+		if err != nil {
+			return protocolError(err)
+		}
+		items := make([]string, 0, len(indexes))
+		for _, idx := range indexes {
+			items = append(items, idx.Name)
+		}
+		return formatArray(items)
+		
+	case "FT.SEARCH":
+		// FT.SEARCH name term [term ...] [LIMIT offset count]
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments for 'ft.search' command"
+		}
+		terms := args[1:]
+		offset, limit := 0, 10
+		if n := len(terms); n >= 3 && strings.ToUpper(terms[n-3]) == "LIMIT" {
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(terms[n-2])
+			limit, err2 = strconv.Atoi(terms[n-1])
+			if err1 != nil || err2 != nil {
+				return "-ERR value is not an integer or out of range"
+			}
+			terms = terms[:n-3]
+		}
+		result, err := core.Result[commands.SearchResult](s.searchCommands.Search(args[0], strings.Join(terms, " "), offset, limit))
+		if err != nil {
+			return protocolError(err)
+		}
+		w := newReply()
+		w.ArrayHeader(len(result.Hits) + 1)
+		w.Int(int64(result.Total))
+		for _, hit := range result.Hits {
+			w.Bulk(hit.Key)
+		}
+		return finishReply(w)
 		
 	default:
 		return fmt.Sprintf("-ERR unknown command '%s'", command)
 	}
 }
+
+// formatArray encodes a list of strings as a multi-bulk reply
+// formatRateLimit renders a rate limit result as an integer array
+func formatRateLimit(response *core.Response) string {
+	result, err := core.Result[commands.RateLimitResult](response)
+	if err != nil {
+		return protocolError(err)
+	}
+	limited := int64(1)
+	if result.Allowed {
+		limited = 0
+	}
+	return formatIntArray(limited, result.Limit, result.Remaining, result.RetryAfter, result.ResetAfter)
+}
+
+// formatLeaderboard flattens entries into rank, member, score triples
+func formatLeaderboard(entries []commands.LeaderboardEntry) string {
+	items := make([]string, 0, len(entries)*3)
+	for _, entry := range entries {
+		items = append(items, strconv.Itoa(entry.Rank), entry.Member, formatScore(entry.Score))
+	}
+	return formatArray(items)
+}
+
+// formatZSetEntries flattens popped entries into member, score pairs, led
+// by the key they came from for blocking pops
+func formatZSetEntries(entries []commands.ZSetEntry, withKey bool) string {
+	items := make([]string, 0, len(entries)*3)
+	for _, entry := range entries {
+		if withKey {
+			items = append(items, entry.Key)
+		}
+		items = append(items, entry.Member, formatScore(entry.Score))
+	}
+	return formatArray(items)
+}
+
+// formatScore renders a score the way Redis replies with sorted set scores
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// formatBools replies a single integer for one-item commands and an
+// integer array otherwise
+func formatBools(single bool, values []bool) string {
+	items := make([]int64, len(values))
+	for i, v := range values {
+		if v {
+			items[i] = 1
+		}
+	}
+	if single {
+		return formatInt(items[0])
+	}
+	return formatIntArray(items...)
+}
+
+// formatUintArray formats unsigned counts as a RESP integer array
+func formatUintArray(values []uint64) string {
+	w := newReply()
+	w.ArrayHeader(len(values))
+	for _, v := range values {
+		w.Uint(v)
+	}
+	return finishReply(w)
+}
+
+// formatIntArray builds a multi-bulk reply of integers
+func formatIntArray(items ...int64) string {
+	w := newReply()
+	w.ArrayHeader(len(items))
+	for _, item := range items {
+		w.Int(item)
+	}
+	return finishReply(w)
+}
+
+func formatArray(items []string) string {
+	w := newReply()
+	w.ArrayHeader(len(items))
+	for _, item := range items {
+		w.Bulk(item)
+	}
+	return finishReply(w)
+}
+
+// formatLease replies [holder, duration seconds, acquire unix time, renew
+// unix time, leader transitions]
+func formatLease(record commands.LeaderElectionRecord) string {
+	w := newReply()
+	w.ArrayHeader(5)
+	w.Bulk(record.HolderIdentity)
+	w.Int(record.LeaseDurationSeconds)
+	w.Int(record.AcquireTime.Unix())
+	w.Int(record.RenewTime.Unix())
+	w.Int(record.LeaderTransitions)
+	return finishReply(w)
+}
+
+// formatPipeline replies one [type, ttl, value] array per key read by
+// PIPELINE. Strings are bulk strings, hashes field/value pairs sorted by
+// field, lists and sets arrays of items, sorted sets member/score pairs in
+// rank order and other types JSON; a missing key has a null value.
+func formatPipeline(results []commands.PipelineResult) string {
+	w := newReply()
+	w.ArrayHeader(len(results))
+	for _, result := range results {
+		w.ArrayHeader(3)
+		w.Bulk(result.Type)
+		w.Int(result.TTL)
+		switch value := result.Value.(type) {
+		case nil:
+			w.Null()
+		case string:
+			w.Bulk(value)
+		case map[string]string:
+			fields := make([]string, 0, len(value))
+			for field := range value {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			w.ArrayHeader(len(fields) * 2)
+			for _, field := range fields {
+				w.Bulk(field)
+				w.Bulk(value[field])
+			}
+		case []string:
+			w.ArrayHeader(len(value))
+			for _, item := range value {
+				w.Bulk(item)
+			}
+		case []commands.ZSetEntry:
+			w.ArrayHeader(len(value) * 2)
+			for _, entry := range value {
+				w.Bulk(entry.Member)
+				w.Bulk(formatScore(entry.Score))
+			}
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				encoded = []byte(fmt.Sprint(value))
+			}
+			w.Bulk(string(encoded))
+		}
+	}
+	return finishReply(w)
+}
+
+// formatPartitions replies one [name, start, expires, keys] array per time
+// partition, with the times in Unix seconds
+func formatPartitions(partitions []core.PartitionInfo) string {
+	w := newReply()
+	w.ArrayHeader(len(partitions))
+	for _, partition := range partitions {
+		w.ArrayHeader(4)
+		w.Bulk(partition.Name)
+		w.Int(partition.Start.Unix())
+		w.Int(partition.Expires.Unix())
+		w.Int(int64(partition.Keys))
+	}
+	return finishReply(w)
+}
+
+// formatSnapshots replies one [name, unix time, size, current] array per
+// restore point
+func formatSnapshots(snapshots []core.SnapshotInfo) string {
+	w := newReply()
+	w.ArrayHeader(len(snapshots))
+	for _, snapshot := range snapshots {
+		current := "0"
+		if snapshot.Current {
+			current = "1"
+		}
+		w.ArrayHeader(4)
+		w.Bulk(snapshot.Name)
+		w.Bulk(strconv.FormatInt(snapshot.Time.Unix(), 10))
+		w.Bulk(strconv.FormatInt(snapshot.Size, 10))
+		w.Bulk(current)
+	}
+	return finishReply(w)
+}
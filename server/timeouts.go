@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// commandTimeouts is the timeout configuration the TCP server applies,
+// swapped as a whole when the config changes
+type commandTimeouts struct {
+	fallback int64            // milliseconds, 0 for none
+	commands map[string]int64 // upper-case command to milliseconds
+}
+
+// newCommandTimeouts normalizes the command names of limits' overrides
+func newCommandTimeouts(limits core.LimitsConfig) *commandTimeouts {
+	timeouts := &commandTimeouts{
+		fallback: limits.CommandTimeout,
+		commands: make(map[string]int64, len(limits.CommandTimeouts)),
+	}
+	for command, timeout := range limits.CommandTimeouts {
+		timeouts.commands[strings.ToUpper(command)] = timeout
+	}
+	return timeouts
+}
+
+// blockingCommands wait by design under their own timeout argument, so the
+// fallback timeout doesn't apply to them; an override for them still does
+var blockingCommands = map[string]bool{
+	"BZPOPMIN": true,
+	"FETCH":    true,
+	"WAIT":     true,
+}
+
+// timeout returns how long command may run, or 0 for no limit
+func (t *commandTimeouts) timeout(command string) time.Duration {
+	ms, exists := t.commands[command]
+	if !exists && !blockingCommands[command] {
+		ms = t.fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// circuitKey identifies what the circuit breaker tracks: the command and
+// its first argument, which for scans is the pattern
+func circuitKey(command string, args []string) string {
+	if len(args) == 0 {
+		return command
+	}
+	return command + " " + args[0]
+}
+
+// circuitOpenError is the fast reply to a command whose circuit is open
+func circuitOpenError(key string, retry time.Duration) string {
+	if retry <= 0 {
+		return fmt.Sprintf("-ERR circuit open for '%s': trial run in progress", key)
+	}
+	return fmt.Sprintf("-ERR circuit open for '%s': retry in %ds", key, int64(math.Ceil(retry.Seconds())))
+}
+
+// recordTimeout reports a command that ran past its timeout and feeds the
+// circuit breaker. Commands that do not check their context finish anyway
+// and are reported all the same.
+func (s *TCPServer) recordTimeout(key string, limit, elapsed time.Duration) {
+	commandTimeoutCounts.Add(strings.Fields(key)[0], 1)
+	s.logger.Warn(fmt.Sprintf("command '%s' timed out after %s (limit %s)", key, elapsed.Round(time.Millisecond), limit))
+	if s.breaker.Timeout(key) {
+		s.logger.Warn(fmt.Sprintf("circuit opened for '%s' after repeated timeouts", key))
+	}
+}
+
+// runLimited runs a command under its timeout, going through the circuit
+// breaker when a timeout applies
+func (s *TCPServer) runLimited(ctx context.Context, command, line string) string {
+	limit := s.timeouts.Load().timeout(command)
+	if limit <= 0 {
+		return s.processCommand(ctx, line)
+	}
+
+	key := circuitKey(command, commandArgs(line)[1:])
+	if allowed, retry := s.breaker.Allow(key); !allowed {
+		return circuitOpenError(key, retry)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	start := time.Now()
+	response := s.processCommand(ctx, line)
+	if ctx.Err() == context.DeadlineExceeded {
+		s.recordTimeout(key, limit, time.Since(start))
+	} else {
+		s.breaker.Success(key)
+	}
+	return response
+}
+
+// formatCircuitStates replies one [key, open, timeouts, trips] array per
+// tracked circuit
+func formatCircuitStates(states []utils.CircuitState) string {
+	w := newReply()
+	w.ArrayHeader(len(states))
+	for _, state := range states {
+		open := "0"
+		if state.Open {
+			open = "1"
+		}
+		w.ArrayHeader(4)
+		w.Bulk(state.Key)
+		w.Bulk(open)
+		w.Bulk(strconv.Itoa(state.Timeouts))
+		w.Bulk(strconv.FormatInt(state.Trips, 10))
+	}
+	return finishReply(w)
+}
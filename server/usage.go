@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// apiKeyHeader is the header REST clients present their API key in
+const apiKeyHeader = "X-API-Key"
+
+// lookupAPIKey returns the name of the API key with the given secret
+func lookupAPIKey(apiKeys []core.APIKeyConfig, key string) (string, bool) {
+	for _, apiKey := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+			return apiKey.Name, true
+		}
+	}
+	return "", false
+}
+
+// apiKeyNameKey marks the context of commands from TCP clients that
+// authenticated with an API key
+type apiKeyNameKey struct{}
+
+func withAPIKey(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, apiKeyNameKey{}, name)
+}
+
+// apiKeyName is the name a command's usage is accounted under
+func apiKeyName(ctx context.Context) string {
+	if name, ok := ctx.Value(apiKeyNameKey{}).(string); ok {
+		return name
+	}
+	return utils.AnonymousUsage
+}
+
+// SetUsageMeter enables per API key usage accounting and quotas
+func (s *TCPServer) SetUsageMeter(usage *utils.UsageMeter) {
+	s.usage = usage
+}
+
+// meteredCommand runs a command if the client's API key has quota left and
+// accounts it with the bytes of the command and reply lines
+func (s *TCPServer) meteredCommand(ctx context.Context, client, line string) string {
+	if s.usage == nil {
+		return s.tracedCommand(ctx, client, line)
+	}
+
+	name := apiKeyName(ctx)
+	if err := s.usage.Allow(name); err != nil {
+		return protocolError(err)
+	}
+	response := s.tracedCommand(ctx, client, line)
+	s.usage.Record(name, int64(len(line)+2), int64(len(response)+2))
+	return response
+}
+
+// SetUsageMeter enables per API key usage accounting and quotas, and
+// serves the rollups under /api/v1/admin/usage
+func (s *HTTPServer) SetUsageMeter(usage *utils.UsageMeter) {
+	s.usage = usage
+}
+
+// usageMiddleware identifies the API key of a request by its X-API-Key
+// header, refuses it once the key's daily quota is used up and accounts
+// the bytes of its body and response. Unknown keys are refused with 401.
+func (s *HTTPServer) usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.usage == nil || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name := utils.AnonymousUsage
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			var ok bool
+			if name, ok = lookupAPIKey(s.db.Config().Security.APIKeys, key); !ok {
+				s.writeError(w, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+		}
+		if err := s.usage.Allow(name); err != nil {
+			reset := time.Until(utils.QuotaReset(time.Now()))
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Seconds())+1))
+			s.writeClassified(w, err, http.StatusTooManyRequests, err.Error())
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		counter := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(counter, r)
+		s.usage.Record(name, body.n, counter.n)
+	})
+}
+
+// handleUsage lists the daily usage rollups, optionally of one
+// ?api_key=<name> between ?from= and ?to= dates (YYYY-MM-DD, inclusive)
+func (s *HTTPServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if s.usage == nil {
+		s.writeError(w, http.StatusNotFound, "usage accounting is not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	for _, param := range []string{"from", "to"} {
+		if date := query.Get(param); date != "" {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s date, expected YYYY-MM-DD", param))
+				return
+			}
+		}
+	}
+	records := s.usage.Usage(query.Get("api_key"), query.Get("from"), query.Get("to"))
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"usage": records,
+		"count": len(records),
+	})
+}
+
+// countingReader counts the bytes of a request body the handler reads
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of a response body
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
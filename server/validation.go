@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// Machine-readable error codes of REST error responses, next to the
+// human-readable message: {"error": "...", "code": "ERR_..."}
+const (
+	codeBadRequest         = "ERR_BAD_REQUEST"
+	codeValidation         = "ERR_VALIDATION"
+	codeInvalidJSON        = "ERR_INVALID_JSON"
+	codeEmptyBody          = "ERR_EMPTY_BODY"
+	codeFieldType          = "ERR_FIELD_TYPE"
+	codeFieldRequired      = "ERR_FIELD_REQUIRED"
+	codeTTLInvalid         = "ERR_TTL_INVALID"
+	codeKeyTooLong         = "ERR_KEY_TOO_LONG"
+	codeValueTooLarge      = "ERR_VALUE_TOO_LARGE"
+	codeTooManyElements    = "ERR_TOO_MANY_ELEMENTS"
+	codeKeyspaceFull       = "ERR_KEYSPACE_FULL"
+	codeWrongType          = "ERR_WRONG_TYPE"
+	codeNotFound           = "ERR_NOT_FOUND"
+	codeNotInteger         = "ERR_NOT_INTEGER"
+	codeVersionMismatch    = "ERR_VERSION_MISMATCH"
+	codeUnauthorized       = "ERR_UNAUTHORIZED"
+	codeForbidden          = "ERR_FORBIDDEN"
+	codeDisabled           = "ERR_DISABLED"
+	codeReadOnly           = "ERR_READ_ONLY"
+	codeMaintenance        = "ERR_MAINTENANCE"
+	codeLoading            = "ERR_LOADING"
+	codeQuotaExceeded      = "ERR_QUOTA_EXCEEDED"
+	codeBackpressure       = "ERR_BACKPRESSURE"
+	codeTimeout            = "ERR_TIMEOUT"
+	codeConflict           = "ERR_CONFLICT"
+	codePreconditionFailed = "ERR_PRECONDITION_FAILED"
+	codeMethodNotAllowed   = "ERR_METHOD_NOT_ALLOWED"
+	codeTooLarge           = "ERR_TOO_LARGE"
+	codeRateLimited        = "ERR_RATE_LIMITED"
+	codeNotImplemented     = "ERR_NOT_IMPLEMENTED"
+	codeUnavailable        = "ERR_UNAVAILABLE"
+	codeInternal           = "ERR_INTERNAL"
+)
+
+// statusCodes are the codes of errors that only have a status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusConflict:              codeConflict,
+	http.StatusPreconditionFailed:    codePreconditionFailed,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusNotImplemented:        codeNotImplemented,
+	http.StatusServiceUnavailable:    codeUnavailable,
+	http.StatusGatewayTimeout:        codeTimeout,
+}
+
+// statusCode is the error code of a status
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return codeInternal
+}
+
+// limitCodes are the codes of the limits in core.LimitError
+var limitCodes = map[string]string{
+	"max_key_size":   codeKeyTooLong,
+	"max_value_size": codeValueTooLarge,
+	"max_elements":   codeTooManyElements,
+	"max_keys":       codeKeyspaceFull,
+}
+
+// errorCode is the error code of a command error, falling back to the
+// code of status for errors outside the errs classes
+func errorCode(err error, status int) string {
+	var limit *core.LimitError
+	switch {
+	case errors.As(err, &limit):
+		return limitCodes[limit.Limit]
+	case errors.Is(err, errs.ErrWrongType):
+		return codeWrongType
+	case errors.Is(err, errs.ErrNotFound):
+		return codeNotFound
+	case errors.Is(err, errs.ErrNotInteger):
+		return codeNotInteger
+	case errors.Is(err, errs.ErrNoAuth):
+		return codeUnauthorized
+	case errors.Is(err, errs.ErrDisabled):
+		return codeDisabled
+	case errors.Is(err, errs.ErrReadOnly):
+		return codeReadOnly
+	case errors.Is(err, errs.ErrMaintenance):
+		return codeMaintenance
+	case errors.Is(err, errs.ErrLoading):
+		return codeLoading
+	case errors.Is(err, errs.ErrQuota):
+		return codeQuotaExceeded
+	case errors.Is(err, errs.ErrBackpressure):
+		return codeBackpressure
+	case errors.Is(err, core.ErrVersionMismatch):
+		return codeVersionMismatch
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimeout
+	}
+	return statusCode(status)
+}
+
+// errorBody is the JSON body of REST error responses
+type errorBody struct {
+	Error  string       `json:"error"`
+	Code   string       `json:"code"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// fieldError explains what is wrong with one field of a request
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeErrorCode replies with an error of a specific code
+func (s *HTTPServer) writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	s.writeJSON(w, status, errorBody{Error: message, Code: code})
+}
+
+// writeClassified replies with a command error, using the status and code
+// of its class or status and its code otherwise
+func (s *HTTPServer) writeClassified(w http.ResponseWriter, err error, status int, message string) {
+	status = errorStatus(err, status)
+	s.writeErrorCode(w, status, errorCode(err, status), message)
+}
+
+// validator collects the field errors of a request
+type validator struct {
+	s      *HTTPServer
+	fields []fieldError
+}
+
+func (s *HTTPServer) validator() *validator {
+	return &validator{s: s}
+}
+
+// check records a field error unless ok
+func (v *validator) check(ok bool, field, code, message string) {
+	if !ok {
+		v.fields = append(v.fields, fieldError{Field: field, Code: code, Message: message})
+	}
+}
+
+// key checks a key is set and within limits.max_key_size
+func (v *validator) key(field, key string) {
+	v.check(key != "", field, codeFieldRequired, "is required")
+	if max := v.s.db.Config().Limits.MaxKeySize; max > 0 {
+		v.check(len(key) <= max, field, codeKeyTooLong, fmt.Sprintf("is %d bytes, over the limit of %d", len(key), max))
+	}
+}
+
+// ttl checks a TTL in seconds isn't negative, 0 meaning none
+func (v *validator) ttl(field string, ttl int64) {
+	v.check(ttl >= 0, field, codeTTLInvalid, "must be a positive number of seconds, or 0 for none")
+}
+
+// failed replies with the collected field errors, if there are any
+func (v *validator) failed(w http.ResponseWriter) bool {
+	if len(v.fields) == 0 {
+		return false
+	}
+	message := v.fields[0].Field + ": " + v.fields[0].Message
+	if len(v.fields) > 1 {
+		message = fmt.Sprintf("%s (and %d more)", message, len(v.fields)-1)
+	}
+	v.s.writeJSON(w, http.StatusBadRequest, errorBody{Error: message, Code: codeValidation, Fields: v.fields})
+	return true
+}
+
+// decodeJSON decodes a request body into v, replying with what is wrong
+// with it if it can't: an empty body, malformed JSON with the offset of the
+// problem, or a field of the wrong type
+func (s *HTTPServer) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var syntax *json.SyntaxError
+	var fieldType *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		s.writeErrorCode(w, http.StatusBadRequest, codeEmptyBody, "request body is empty, expected a JSON object")
+	case errors.As(err, &syntax):
+		s.writeErrorCode(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("invalid JSON at byte %d: %v", syntax.Offset, err))
+	case errors.As(err, &fieldType) && fieldType.Field != "":
+		validator := s.validator()
+		validator.check(false, fieldType.Field, codeFieldType, fmt.Sprintf("must be %s, got %s", jsonKind(fieldType.Type), fieldType.Value))
+		validator.failed(w)
+	case errors.As(err, &fieldType):
+		s.writeErrorCode(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("expected %s, got %s", jsonKind(fieldType.Type), fieldType.Value))
+	default:
+		s.writeErrorCode(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON payload: "+err.Error())
+	}
+	return false
+}
+
+// jsonKind names the JSON type a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Ptr:
+		return jsonKind(t.Elem())
+	}
+	return "a " + t.String()
+}
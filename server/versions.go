@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The REST API versions. Both serve the same endpoints: v1 answers with
+// each endpoint's own JSON shape, v2 wraps every JSON response in an
+// apiEnvelope.
+const (
+	apiV1 = "/api/v1"
+	apiV2 = "/api/v2"
+)
+
+// canonicalRoute maps a path of any API version to its /api/v1 form, which
+// the route tables (admin, mode, renamed commands) are keyed by
+func canonicalRoute(path string) string {
+	if strings.HasPrefix(path, apiV2+"/") {
+		return apiV1 + path[len(apiV2):]
+	}
+	return path
+}
+
+// apiEnvelope is the shape of every v2 response: data on success, error on
+// failure, and meta either way
+type apiEnvelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *apiError       `json:"error,omitempty"`
+	Meta  envelopeMeta    `json:"meta"`
+}
+
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+type envelopeMeta struct {
+	APIVersion string `json:"api_version"`
+	Status     int    `json:"status"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// envelope wraps a v1 JSON response body. Failed responses carry their
+// errorBody as the error and no data.
+func envelope(status int, body []byte, requestID string) apiEnvelope {
+	wrapped := apiEnvelope{
+		Data: json.RawMessage("null"),
+		Meta: envelopeMeta{APIVersion: "v2", Status: status, RequestID: requestID},
+	}
+	if status < http.StatusBadRequest {
+		if len(strings.TrimSpace(string(body))) > 0 {
+			wrapped.Data = json.RawMessage(body)
+		}
+		return wrapped
+	}
+
+	var failure errorBody
+	json.Unmarshal(body, &failure)
+	if failure.Error == "" {
+		failure.Error = http.StatusText(status)
+	}
+	if failure.Code == "" {
+		failure.Code = statusCode(status)
+	}
+	wrapped.Error = &apiError{Code: failure.Code, Message: failure.Error, Fields: failure.Fields}
+	return wrapped
+}
+
+// envelopeMiddleware wraps the JSON responses of the v2 API in an
+// apiEnvelope, including the refusals of the middlewares after it. Other
+// responses, such as raw values, event streams and 304s, pass through.
+func (s *HTTPServer) envelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, apiV2+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rewriter := &jsonRewriter{ResponseWriter: w, rewrite: func(header http.Header, status int, body []byte) []byte {
+			wrapped, err := json.Marshal(envelope(status, body, header.Get("X-Request-ID")))
+			if err != nil {
+				return body
+			}
+			return append(wrapped, '\n')
+		}}
+		next.ServeHTTP(rewriter, r)
+		rewriter.finish()
+	})
+}
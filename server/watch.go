@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+const (
+	// watchBuffer is how many events a watcher may fall behind by before
+	// its stream is ended
+	watchBuffer = 256
+
+	// watchKeepAlive is how often an idle stream gets a comment line, so
+	// proxies don't time it out
+	watchKeepAlive = 15 * time.Second
+
+	// maxWait bounds how long a ?wait= key read is held
+	maxWait = 5 * time.Minute
+)
+
+// watchHub fans the database's change events out to the SSE streams of
+// /api/v1/watch and to key reads waiting for a change. It starts listening
+// on the first watch.
+type watchHub struct {
+	db       *core.Database
+	once     sync.Once
+	watchers map[*watcher]struct{}
+	mu       sync.Mutex
+}
+
+// watcher is one subscription to the changes of the keys match accepts.
+// events is closed when the subscriber falls too far behind.
+type watcher struct {
+	match  func(key string) bool
+	events chan core.ChangeEvent
+}
+
+func newWatchHub(db *core.Database) *watchHub {
+	return &watchHub{db: db, watchers: make(map[*watcher]struct{})}
+}
+
+// subscribe registers a watcher for the keys match accepts
+func (h *watchHub) subscribe(match func(key string) bool) *watcher {
+	h.once.Do(func() { h.db.OnChange(h.publish) })
+
+	w := &watcher{match: match, events: make(chan core.ChangeEvent, watchBuffer)}
+	h.mu.Lock()
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+	return w
+}
+
+// unsubscribe removes a watcher that is still registered
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		close(w.events)
+	}
+}
+
+// publish is the database change listener. It never blocks: a watcher
+// whose buffer is full is dropped and its stream ends.
+func (h *watchHub) publish(event core.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers {
+		if event.Op != core.ChangeFlush && !w.match(event.Key) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			delete(h.watchers, w)
+			close(w.events)
+		}
+	}
+}
+
+// handleWatch streams changes of the keys matching ?pattern= (default *)
+// as Server-Sent Events: one event per write named after its op (set, ttl,
+// del, expire, flush) with the change as JSON data and its sequence number
+// as id. ?values=true includes written values. A client that falls too far
+// behind gets a "lagged" event and the stream ends, EventSource clients
+// then reconnect.
+func (s *HTTPServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	withValues := r.URL.Query().Get("values") == "true"
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	watcher := s.watches.subscribe(func(key string) bool { return core.MatchPattern(pattern, key) })
+	defer s.watches.unsubscribe(watcher)
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-watcher.events:
+			if !ok {
+				fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+				controller.Flush()
+				return
+			}
+			if !withValues {
+				event.Value = nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Op, data)
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// parseWait reads a ?wait= duration, written as a Go duration ("30s") or
+// in whole seconds ("30")
+func parseWait(wait string) (time.Duration, bool) {
+	timeout, err := time.ParseDuration(wait)
+	if err != nil {
+		seconds, serr := strconv.Atoi(wait)
+		if serr != nil {
+			return 0, false
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 || timeout > maxWait {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// waitForChange holds a key read until the key's version differs from
+// known, the timeout passes or the client goes away. known is the version
+// in the request's If-None-Match, or the current one without it, 0 for a
+// missing key. It reports whether the key changed.
+func (s *HTTPServer) waitForChange(r *http.Request, key string, timeout time.Duration) bool {
+	watcher := s.watches.subscribe(func(changed string) bool { return changed == key })
+	defer s.watches.unsubscribe(watcher)
+
+	version := func() uint64 {
+		meta, _ := s.db.Metadata(key)
+		return meta.Version
+	}
+	known := version()
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if tagged, ok := parseETag(ifNoneMatch); ok {
+			known = tagged
+		}
+	}
+	if version() != known {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-timer.C:
+			return false
+		case _, ok := <-watcher.events:
+			if !ok || version() != known {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// errQueueFull refuses a command when every worker is busy and the queue
+// in front of them is full
+var errQueueFull = errs.Newf(errs.ErrBackpressure, "command queue is full")
+
+// commandPool runs TCP commands on a fixed set of workers, so tens of
+// thousands of connections share a few goroutines doing the work instead
+// of all competing for the scheduler at once. It is read from
+// server.workers when the server is created.
+type commandPool struct {
+	jobs   chan poolJob             // nil when commands run on their connection's goroutine
+	limits map[string]chan struct{} // upper-case command to its concurrency semaphore
+}
+
+type poolJob struct {
+	run  func() string
+	done chan<- string
+}
+
+// newCommandPool starts the workers config asks for
+func newCommandPool(config core.WorkersConfig) *commandPool {
+	pool := &commandPool{limits: make(map[string]chan struct{}, len(config.CommandConcurrency))}
+	for command, limit := range config.CommandConcurrency {
+		if limit > 0 {
+			pool.limits[strings.ToUpper(command)] = make(chan struct{}, limit)
+		}
+	}
+	if config.Count <= 0 {
+		return pool
+	}
+
+	queue := config.QueueSize
+	if queue <= 0 {
+		queue = config.Count
+	}
+	pool.jobs = make(chan poolJob, queue)
+	for i := 0; i < config.Count; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (p *commandPool) work() {
+	for job := range p.jobs {
+		job.done <- job.run()
+	}
+}
+
+// run runs a command and returns its reply. The caller waits for it, which
+// is what keeps a connection's commands in order: the next one is only
+// read once this one has replied. Blocking commands run on the caller's
+// goroutine, since waiting on a worker they would starve everyone else.
+func (p *commandPool) run(ctx context.Context, command string, fn func() string) string {
+	if limit, exists := p.limits[command]; exists {
+		select {
+		case limit <- struct{}{}:
+			defer func() { <-limit }()
+		case <-ctx.Done():
+			return protocolError(ctx.Err())
+		}
+	}
+
+	if p.jobs == nil || blockingCommands[command] {
+		return fn()
+	}
+	done := make(chan string, 1)
+	select {
+	case p.jobs <- poolJob{run: fn, done: done}:
+	default:
+		commandRejections.Add(command, 1)
+		return protocolError(errQueueFull)
+	}
+	return <-done
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
 	"sync"
+
+	"github.com/nitrix4ly/triff/tracing"
 )
 
 type DiskEngine struct {
@@ -24,7 +27,13 @@ func NewDiskEngine(path string) (*DiskEngine, error) {
 	return engine, nil
 }
 
-func (de *DiskEngine) load() error {
+func (de *DiskEngine) load() (err error) {
+	_, span := tracing.Start(context.Background(), "storage.disk.load", tracing.KindInternal)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
@@ -40,7 +49,13 @@ func (de *DiskEngine) load() error {
 	return json.NewDecoder(file).Decode(&de.data)
 }
 
-func (de *DiskEngine) persist() error {
+func (de *DiskEngine) persist() (err error) {
+	_, span := tracing.Start(context.Background(), "storage.disk.persist", tracing.KindInternal)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+
 	de.mu.RLock()
 	defer de.mu.RUnlock()
 
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// Encrypted files start with a header line naming the format version and
+// the key, followed by the GCM nonce and the sealed plaintext file:
+//
+//	TRIFF-ENCRYPTED 1 <key id>
+//	<12 byte nonce><ciphertext and tag>
+//
+// The header is authenticated along with the contents.
+const (
+	encryptedMagic   = "TRIFF-ENCRYPTED"
+	encryptedVersion = 1
+)
+
+// keyCommandTimeout bounds how long key_command may take
+const keyCommandTimeout = 30 * time.Second
+
+// Encryption errors
+var (
+	ErrInvalidKey  = errors.New("encryption key must be 32 bytes, base64 or hex encoded")
+	ErrSnapshotKey = errors.New("no key to decrypt snapshot")
+)
+
+// Keyring holds the key new files are encrypted with and the retired keys
+// older files may still be encrypted with. Keys are identified in file
+// headers by a hash, so rotating only means adding the new key and moving
+// the old one to the retired list.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring encrypting with primary and decrypting with
+// primary or any of old
+func NewKeyring(primary []byte, old ...[]byte) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, key := range append([][]byte{primary}, old...) {
+		if len(key) != 32 {
+			return nil, ErrInvalidKey
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			keyring.primary = id
+		}
+		keyring.keys[id] = aead
+	}
+	return keyring, nil
+}
+
+// LoadKeyring builds the keyring described by the configuration, running
+// key_command when no key is set. It returns nil when encryption is
+// disabled.
+func LoadKeyring(config core.EncryptionConfig) (*Keyring, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	encoded := config.Key
+	if encoded == "" {
+		if config.KeyCommand == "" {
+			return nil, errors.New("encryption is enabled without a key or key_command")
+		}
+		output, err := runKeyCommand(config.KeyCommand)
+		if err != nil {
+			return nil, err
+		}
+		encoded = output
+	}
+	primary, err := ParseKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	old := make([][]byte, 0, len(config.OldKeys))
+	for i, encoded := range config.OldKeys {
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("old key %d: %w", i+1, err)
+		}
+		old = append(old, key)
+	}
+	return NewKeyring(primary, old...)
+}
+
+// runKeyCommand runs a key_command through the shell and returns what it
+// printed
+func runKeyCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("key command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// ParseKey decodes a 32 byte key written as hex or base64
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if len(encoded) == 64 {
+		if key, err := hex.DecodeString(encoded); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// GenerateKey returns a random key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// keyID names a key in file headers without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// KeyID identifies the key new files are encrypted with
+func (k *Keyring) KeyID() string {
+	return k.primary
+}
+
+// seal encrypts a file's contents with the primary key
+func (k *Keyring) seal(plaintext []byte) ([]byte, error) {
+	aead := k.keys[k.primary]
+	header := fmt.Sprintf("%s %d %s\n", encryptedMagic, encryptedVersion, k.primary)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(header), nonce...)
+	return aead.Seal(out, nonce, plaintext, []byte(header)), nil
+}
+
+// isEncrypted reports whether content is an encrypted file
+func isEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(encryptedMagic+" "))
+}
+
+// openFile decrypts the contents of an encrypted file, returning the
+// plaintext and the ID of the key that decrypted it. A nil keyring can
+// open nothing.
+func (k *Keyring) openFile(content []byte) ([]byte, string, error) {
+	end := bytes.IndexByte(content, '\n')
+	if end < 0 {
+		return nil, "", fmt.Errorf("%w: encryption header cut off", ErrTruncatedSnapshot)
+	}
+	header := content[:end+1]
+	fields := strings.Fields(string(header))
+	if len(fields) != 3 {
+		return nil, "", fmt.Errorf("%w: bad encryption header %q", ErrCorruptSnapshot, bytes.TrimSpace(header))
+	}
+	if fields[1] != fmt.Sprint(encryptedVersion) {
+		return nil, "", fmt.Errorf("%w: encryption version %s", ErrSnapshotVersion, fields[1])
+	}
+
+	id := fields[2]
+	if k == nil {
+		return nil, id, fmt.Errorf("%w: file is encrypted and encryption is not configured", ErrSnapshotKey)
+	}
+	aead, found := k.keys[id]
+	if !found {
+		return nil, id, fmt.Errorf("%w: file is encrypted with key %s, which is not in the keyring", ErrSnapshotKey, id)
+	}
+
+	sealed := content[end+1:]
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, id, fmt.Errorf("%w: encrypted data cut off", ErrTruncatedSnapshot)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		// GCM cannot tell damage from truncation; both fail authentication
+		return nil, id, fmt.Errorf("%w: decryption failed", ErrCorruptSnapshot)
+	}
+	return plaintext, id, nil
+}
+
+// ReencryptSnapshots rewrites the snapshot at path and its generations so
+// they are encrypted with the primary key of to, or stored in plaintext
+// when to is nil. Encrypted files are decrypted with from. Files already in
+// the wanted form are left alone, and each file keeps its modification
+// time, which dates the restore point. It returns the names of the files
+// it rewrote.
+func ReencryptSnapshots(path string, from, to *Keyring) ([]string, error) {
+	snapshots, err := ListSnapshots(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		file, err := snapshotPath(path, snapshot.Name)
+		if err != nil {
+			return rewritten, err
+		}
+		changed, err := reencryptFile(file, from, to)
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", snapshot.Name, err)
+		}
+		if changed {
+			rewritten = append(rewritten, snapshot.Name)
+		}
+	}
+	return rewritten, nil
+}
+
+// reencryptFile rewrites one file for ReencryptSnapshots, reporting
+// whether it had to
+func reencryptFile(path string, from, to *Keyring) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	plaintext := content
+	if isEncrypted(content) {
+		var id string
+		plaintext, id, err = from.openFile(content)
+		if err != nil {
+			return false, err
+		}
+		if to != nil && id == to.primary {
+			return false, nil
+		}
+	} else if to == nil {
+		return false, nil
+	}
+
+	out := plaintext
+	if to != nil {
+		if out, err = to.seal(plaintext); err != nil {
+			return false, err
+		}
+	}
+	tmp, err := writeTemp(path, out, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
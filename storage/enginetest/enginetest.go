@@ -0,0 +1,654 @@
+// Package enginetest checks storage engines against the core.StorageEngine
+// and core.PersistenceEngine contracts, so an engine written outside this
+// repository can be dropped in with confidence. Call Run from a test in
+// the engine's package:
+//
+//	func TestEngine(t *testing.T) {
+//		enginetest.Run(t, enginetest.Harness{
+//			Open: func(t testing.TB, dir string) core.StorageEngine {
+//				return storage.NewMemoryEngine(filepath.Join(dir, "triff.db"), false)
+//			},
+//			Close: func(engine core.StorageEngine) error {
+//				return engine.(*storage.MemoryEngine).Stop()
+//			},
+//		})
+//	}
+//
+// and run it with go test -race: the concurrency cases only prove much
+// under the race detector. Expiry is checked for engines with a
+// SetClock(core.Clock) method, which are given a core.FakeClock; for
+// others those cases are skipped.
+package enginetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// Harness opens the engine under test
+type Harness struct {
+	// Open returns an engine keeping its data under dir. Every case gets
+	// a new empty dir; engines that don't persist can ignore it.
+	Open func(t testing.TB, dir string) core.StorageEngine
+
+	// Close writes out what engine holds and releases it, so that Open on
+	// the same dir gets the data back. Engines still open when a case
+	// ends are closed with it. Without Close the persistence cases are
+	// skipped.
+	Close func(engine core.StorageEngine) error
+
+	// Concurrency is how many goroutines the concurrency cases run,
+	// 8 if zero
+	Concurrency int
+}
+
+// clocked engines can be made to go by a fake clock
+type clocked interface {
+	SetClock(clock core.Clock)
+}
+
+// epoch is where the fake clock starts
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// errAbort is returned from Update functions to check that it comes back
+var errAbort = errors.New("enginetest: update aborted")
+
+// Run checks the engine the harness opens against the core.StorageEngine
+// contract, one subtest per part of it
+func Run(t *testing.T, h Harness) {
+	if h.Open == nil {
+		t.Fatal("enginetest: Harness.Open is required")
+	}
+	cases := []struct {
+		name string
+		run  func(t *testing.T, h Harness)
+	}{
+		{"GetSet", testGetSet},
+		{"Delete", testDelete},
+		{"Size", testSize},
+		{"FlushAll", testFlushAll},
+		{"Keys", testKeys},
+		{"Update", testUpdate},
+		{"Expiry", testExpiry},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"ConcurrentUpdate", testConcurrentUpdate},
+		{"Persistence", testPersistence},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, h)
+		})
+	}
+}
+
+// RunPersistence checks the persistence engine open returns against the
+// core.PersistenceEngine contract. Each case gets a new empty dir to keep
+// its data under.
+func RunPersistence(t *testing.T, open func(t testing.TB, dir string) core.PersistenceEngine) {
+	t.Run("LoadEmpty", func(t *testing.T) {
+		data, err := open(t, t.TempDir()).Load()
+		if err != nil {
+			t.Fatalf("Load with nothing saved: %v", err)
+		}
+		if len(data) != 0 {
+			t.Fatalf("Load with nothing saved returned %d keys, want none", len(data))
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		dir := t.TempDir()
+		want := sampleData(epoch.Unix() + 3600)
+		if err := open(t, dir).Save(sampleData(epoch.Unix() + 3600)); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		got, err := open(t, dir).Load()
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		checkData(t, got, want)
+	})
+
+	t.Run("SaveReplaces", func(t *testing.T) {
+		dir := t.TempDir()
+		persistence := open(t, dir)
+		if err := persistence.Save(sampleData(0)); err != nil {
+			t.Fatalf("first Save: %v", err)
+		}
+		want := map[string]*core.TriffValue{"only": stringValue("left", 0)}
+		if err := persistence.Save(map[string]*core.TriffValue{"only": stringValue("left", 0)}); err != nil {
+			t.Fatalf("second Save: %v", err)
+		}
+		got, err := open(t, dir).Load()
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		checkData(t, got, want)
+	})
+
+	t.Run("SetPath", func(t *testing.T) {
+		dir := t.TempDir()
+		persistence := open(t, dir)
+		persistence.SetPath(fmt.Sprintf("%s/moved.db", t.TempDir()))
+		if err := persistence.Save(sampleData(0)); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		data, err := open(t, dir).Load()
+		if err != nil {
+			t.Fatalf("Load from the original path: %v", err)
+		}
+		if len(data) != 0 {
+			t.Fatalf("Save after SetPath wrote %d keys to the original path", len(data))
+		}
+		got, err := persistence.Load()
+		if err != nil {
+			t.Fatalf("Load from the new path: %v", err)
+		}
+		checkData(t, got, sampleData(0))
+	})
+}
+
+func testGetSet(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+
+	if value, ok := engine.Get("missing"); ok || value != nil {
+		t.Fatalf("Get of a missing key = %v, %v; want nil, false", value, ok)
+	}
+	if engine.Exists("missing") {
+		t.Fatal("Exists of a missing key = true")
+	}
+
+	for key, value := range sampleData(0) {
+		if err := engine.Set(key, value); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+	for key, want := range sampleData(0) {
+		checkValue(t, engine, key, want)
+		if !engine.Exists(key) {
+			t.Fatalf("Exists(%q) = false after Set", key)
+		}
+	}
+
+	if err := engine.Set("greeting", stringValue("bye", 0)); err != nil {
+		t.Fatalf("Set over an existing key: %v", err)
+	}
+	checkValue(t, engine, "greeting", stringValue("bye", 0))
+}
+
+func testDelete(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	set(t, engine, "doomed", stringValue("x", 0))
+	set(t, engine, "kept", stringValue("y", 0))
+
+	if !engine.Delete("doomed") {
+		t.Fatal("Delete of an existing key = false")
+	}
+	if engine.Delete("doomed") {
+		t.Fatal("Delete of a deleted key = true")
+	}
+	if engine.Delete("missing") {
+		t.Fatal("Delete of a missing key = true")
+	}
+	if _, ok := engine.Get("doomed"); ok || engine.Exists("doomed") {
+		t.Fatal("deleted key is still there")
+	}
+	checkValue(t, engine, "kept", stringValue("y", 0))
+}
+
+func testSize(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	checkSize(t, engine, 0)
+	set(t, engine, "a", stringValue("1", 0))
+	set(t, engine, "b", stringValue("2", 0))
+	set(t, engine, "c", stringValue("3", 0))
+	checkSize(t, engine, 3)
+	set(t, engine, "a", stringValue("4", 0))
+	checkSize(t, engine, 3)
+	engine.Delete("b")
+	checkSize(t, engine, 2)
+}
+
+func testFlushAll(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	for key, value := range sampleData(0) {
+		set(t, engine, key, value)
+	}
+	if err := engine.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+	checkSize(t, engine, 0)
+	checkKeys(t, engine, "*", nil)
+	if engine.Exists("greeting") {
+		t.Fatal("key survived FlushAll")
+	}
+	set(t, engine, "after", stringValue("x", 0))
+	checkValue(t, engine, "after", stringValue("x", 0))
+}
+
+func testKeys(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	checkKeys(t, engine, "*", nil)
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "h*llo"} {
+		set(t, engine, key, stringValue(key, 0))
+	}
+
+	patterns := map[string][]string{
+		"*":         {"h*llo", "order:1", "user:1", "user:10", "user:2"},
+		"user:*":    {"user:1", "user:10", "user:2"},
+		"user:?":    {"user:1", "user:2"},
+		"user:[12]": {"user:1", "user:2"},
+		"user:[^1]": {"user:2"},
+		"*:1":       {"order:1", "user:1"},
+		"order:1":   {"order:1"},
+		`h\*llo`:    {"h*llo"},
+		"h?llo":     {"h*llo"},
+		"nothing*":  nil,
+		"user:1:*":  nil,
+	}
+	for pattern, want := range patterns {
+		checkKeys(t, engine, pattern, want)
+	}
+}
+
+func testUpdate(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+
+	t.Run("Missing", func(t *testing.T) {
+		err := engine.Update("absent", func(old *core.TriffValue) (*core.TriffValue, error) {
+			if old != nil {
+				t.Errorf("Update of a missing key passed %v, want nil", old)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if engine.Exists("absent") {
+			t.Fatal("returning nil for a missing key created it")
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		err := engine.Update("created", func(old *core.TriffValue) (*core.TriffValue, error) {
+			return stringValue("new", 0), nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		checkValue(t, engine, "created", stringValue("new", 0))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		set(t, engine, "replaced", stringValue("before", 0))
+		err := engine.Update("replaced", func(old *core.TriffValue) (*core.TriffValue, error) {
+			if old == nil || !sameData(old.Data, "before") {
+				t.Errorf("Update passed %v, want the stored value", old)
+			}
+			return stringValue("after", 0), nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		checkValue(t, engine, "replaced", stringValue("after", 0))
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		set(t, engine, "unchanged", stringValue("same", 0))
+		err := engine.Update("unchanged", func(old *core.TriffValue) (*core.TriffValue, error) {
+			return old, nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		checkValue(t, engine, "unchanged", stringValue("same", 0))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		set(t, engine, "removed", stringValue("x", 0))
+		err := engine.Update("removed", func(old *core.TriffValue) (*core.TriffValue, error) {
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if engine.Exists("removed") {
+			t.Fatal("returning nil didn't delete the key")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		set(t, engine, "guarded", stringValue("kept", 0))
+		err := engine.Update("guarded", func(old *core.TriffValue) (*core.TriffValue, error) {
+			return stringValue("lost", 0), errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("Update = %v, want the error fn returned", err)
+		}
+		checkValue(t, engine, "guarded", stringValue("kept", 0))
+
+		err = engine.Update("unborn", func(old *core.TriffValue) (*core.TriffValue, error) {
+			return stringValue("lost", 0), errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("Update = %v, want the error fn returned", err)
+		}
+		if engine.Exists("unborn") {
+			t.Fatal("a failed Update created the key")
+		}
+	})
+}
+
+func testExpiry(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	clock := h.fakeClock(t, engine)
+	expires := clock.Now().Unix() + 10
+
+	set(t, engine, "session", stringValue("alive", expires))
+	set(t, engine, "forever", stringValue("alive", 0))
+	set(t, engine, "doomed", stringValue("alive", expires))
+	set(t, engine, "updated", stringValue("alive", expires))
+
+	clock.Advance(5 * time.Second)
+	checkValue(t, engine, "session", stringValue("alive", expires))
+	checkKeys(t, engine, "*", []string{"doomed", "forever", "session", "updated"})
+
+	clock.Advance(6 * time.Second)
+	for _, key := range []string{"session", "doomed", "updated"} {
+		if value, ok := engine.Get(key); ok || value != nil {
+			t.Fatalf("Get(%q) after its TTL = %v, %v; want nil, false", key, value, ok)
+		}
+		if engine.Exists(key) {
+			t.Fatalf("Exists(%q) after its TTL = true", key)
+		}
+	}
+	checkKeys(t, engine, "*", []string{"forever"})
+	if engine.Delete("doomed") {
+		t.Fatal("Delete of an expired key = true")
+	}
+	err := engine.Update("updated", func(old *core.TriffValue) (*core.TriffValue, error) {
+		if old != nil {
+			t.Errorf("Update of an expired key passed %v, want nil", old)
+		}
+		return stringValue("reborn", 0), nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	checkValue(t, engine, "updated", stringValue("reborn", 0))
+
+	set(t, engine, "session", stringValue("again", 0))
+	checkValue(t, engine, "session", stringValue("again", 0))
+
+	clock.Advance(365 * 24 * time.Hour)
+	checkValue(t, engine, "forever", stringValue("alive", 0))
+}
+
+func testConcurrentAccess(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	workers := h.concurrency()
+	const rounds = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("w%d:%d", w, i)
+				if err := engine.Set(key, stringValue(strconv.Itoa(i), 0)); err != nil {
+					t.Errorf("Set(%q): %v", key, err)
+					return
+				}
+				if _, ok := engine.Get(key); !ok {
+					t.Errorf("Get(%q) missed right after Set", key)
+				}
+				engine.Get(fmt.Sprintf("w%d:%d", (w+1)%workers, i))
+				engine.Exists(fmt.Sprintf("w%d:%d", (w+1)%workers, i))
+				if i%2 == 1 && !engine.Delete(key) {
+					t.Errorf("Delete(%q) = false right after Set", key)
+				}
+				if i%50 == 0 {
+					engine.Keys(fmt.Sprintf("w%d:*", (w+1)%workers))
+					engine.Size()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	checkSize(t, engine, int64(workers*rounds/2))
+	for w := 0; w < workers; w++ {
+		if keys := engine.Keys(fmt.Sprintf("w%d:*", w)); len(keys) != rounds/2 {
+			t.Fatalf("worker %d left %d keys, want %d", w, len(keys), rounds/2)
+		}
+	}
+}
+
+func testConcurrentUpdate(t *testing.T, h Harness) {
+	engine := h.open(t, t.TempDir())
+	workers := h.concurrency()
+	const increments = 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				err := engine.Update("counter", func(old *core.TriffValue) (*core.TriffValue, error) {
+					n := 0
+					if old != nil {
+						n, _ = strconv.Atoi(fmt.Sprint(old.Data))
+					}
+					return stringValue(strconv.Itoa(n+1), 0), nil
+				})
+				if err != nil {
+					t.Errorf("Update: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Lost updates mean Update isn't atomic
+	checkValue(t, engine, "counter", stringValue(strconv.Itoa(workers*increments), 0))
+}
+
+func testPersistence(t *testing.T, h Harness) {
+	if h.Close == nil {
+		t.Skip("enginetest: the harness has no Close, so the engine is taken not to persist")
+	}
+	dir := t.TempDir()
+	engine, closeEngine := h.openClosable(t, dir)
+	expires := time.Now().Unix() + 3600
+	if c, ok := engine.(clocked); ok {
+		c.SetClock(core.NewFakeClock(epoch))
+		expires = epoch.Unix() + 3600
+	}
+
+	want := sampleData(expires)
+	for key, value := range sampleData(expires) {
+		set(t, engine, key, value)
+	}
+	set(t, engine, "deleted", stringValue("x", 0))
+	engine.Delete("deleted")
+
+	engine, closeEngine = h.reopen(t, closeEngine, dir)
+	if c, ok := engine.(clocked); ok {
+		c.SetClock(core.NewFakeClock(epoch))
+	}
+	checkSize(t, engine, int64(len(want)))
+	for key, value := range want {
+		checkValue(t, engine, key, value)
+	}
+	if engine.Exists("deleted") {
+		t.Fatal("a deleted key came back after reopening")
+	}
+
+	if err := engine.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+	engine, _ = h.reopen(t, closeEngine, dir)
+	checkSize(t, engine, 0)
+}
+
+// open opens an engine under dir that is closed when the test ends
+func (h Harness) open(t testing.TB, dir string) core.StorageEngine {
+	t.Helper()
+	engine, _ := h.openClosable(t, dir)
+	return engine
+}
+
+// openClosable opens an engine under dir, returning a close function that
+// is also called when the test ends, and only closes the engine once
+func (h Harness) openClosable(t testing.TB, dir string) (core.StorageEngine, func() error) {
+	t.Helper()
+	engine := h.Open(t, dir)
+	if engine == nil {
+		t.Fatal("enginetest: Harness.Open returned nil")
+	}
+	var once sync.Once
+	closeEngine := func() error {
+		var err error
+		once.Do(func() {
+			if h.Close != nil {
+				err = h.Close(engine)
+			}
+		})
+		return err
+	}
+	t.Cleanup(func() { closeEngine() })
+	return engine, closeEngine
+}
+
+// reopen closes an engine and opens its dir again
+func (h Harness) reopen(t testing.TB, closeEngine func() error, dir string) (core.StorageEngine, func() error) {
+	t.Helper()
+	if err := closeEngine(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return h.openClosable(t, dir)
+}
+
+// fakeClock makes engine go by a fake clock, skipping the test if it can't
+func (h Harness) fakeClock(t testing.TB, engine core.StorageEngine) *core.FakeClock {
+	t.Helper()
+	c, ok := engine.(clocked)
+	if !ok {
+		t.Skip("enginetest: the engine has no SetClock(core.Clock) method")
+	}
+	clock := core.NewFakeClock(epoch)
+	c.SetClock(clock)
+	return clock
+}
+
+func (h Harness) concurrency() int {
+	if h.Concurrency > 0 {
+		return h.Concurrency
+	}
+	return 8
+}
+
+// sampleData returns new values of the common types. Engines may keep the
+// values they are given, so each call builds them afresh.
+func sampleData(ttl int64) map[string]*core.TriffValue {
+	return map[string]*core.TriffValue{
+		"greeting": stringValue("hello", 0),
+		"session":  stringValue("token", ttl),
+		"user:1": {
+			Type: core.HASH,
+			Data: map[string]string{"name": "alice", "email": "alice@example.com"},
+		},
+		"queue": {
+			Type: core.LIST,
+			Data: []string{"first", "second", "third"},
+			TTL:  ttl,
+		},
+	}
+}
+
+func stringValue(s string, ttl int64) *core.TriffValue {
+	return &core.TriffValue{Type: core.STRING, Data: s, TTL: ttl}
+}
+
+func set(t testing.TB, engine core.StorageEngine, key string, value *core.TriffValue) {
+	t.Helper()
+	if err := engine.Set(key, value); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
+
+// checkValue fails the test unless key holds want's type, data and TTL
+func checkValue(t testing.TB, engine core.StorageEngine, key string, want *core.TriffValue) {
+	t.Helper()
+	got, ok := engine.Get(key)
+	if !ok || got == nil {
+		t.Fatalf("Get(%q) missed, want %v", key, want.Data)
+	}
+	if got.Type != want.Type || got.TTL != want.TTL || !sameData(got.Data, want.Data) {
+		t.Fatalf("Get(%q) = %s %v ttl %d, want %s %v ttl %d",
+			key, got.Type, got.Data, got.TTL, want.Type, want.Data, want.TTL)
+	}
+}
+
+// checkData fails the test unless got holds the same values as want
+func checkData(t testing.TB, got, want map[string]*core.TriffValue) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		g, ok := got[key]
+		if !ok || g == nil {
+			t.Fatalf("key %q is missing", key)
+		}
+		if g.Type != w.Type || g.TTL != w.TTL || !sameData(g.Data, w.Data) {
+			t.Fatalf("key %q = %s %v ttl %d, want %s %v ttl %d",
+				key, g.Type, g.Data, g.TTL, w.Type, w.Data, w.TTL)
+		}
+	}
+}
+
+func checkKeys(t testing.TB, engine core.StorageEngine, pattern string, want []string) {
+	t.Helper()
+	got := append([]string(nil), engine.Keys(pattern)...)
+	sort.Strings(got)
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys(%q) = %q, want %q", pattern, got, want)
+	}
+}
+
+func checkSize(t testing.TB, engine core.StorageEngine, want int64) {
+	t.Helper()
+	if got := engine.Size(); got != want {
+		t.Fatalf("Size() = %d, want %d", got, want)
+	}
+}
+
+// sameData compares data by its JSON form, so a hash an engine packed or
+// a list read back from disk as []interface{} equals the one stored
+func sameData(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
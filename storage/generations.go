@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// DefaultGenerations is how many older snapshots are kept when the config
+// does not say
+const DefaultGenerations = 5
+
+// generationLayout timestamps snapshot generations as <path>.<time>, in
+// UTC so names sort by age
+const generationLayout = "20060102T150405.000000000Z"
+
+// ErrUnknownSnapshot is returned for a snapshot name that is neither the
+// current snapshot nor one of its generations
+var ErrUnknownSnapshot = errors.New("unknown snapshot")
+
+// generation is an older snapshot kept next to the current one
+type generation struct {
+	path string
+	time time.Time
+	size int64
+}
+
+// rotateSnapshot turns the current snapshot into a generation named after
+// the time it was written
+func rotateSnapshot(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// File times can be coarser than the saves they record; never let a
+	// generation replace another
+	taken := info.ModTime().UTC()
+	target := path + "." + taken.Format(generationLayout)
+	for {
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		taken = taken.Add(time.Nanosecond)
+		target = path + "." + taken.Format(generationLayout)
+	}
+	return os.Rename(path, target)
+}
+
+// listGenerations returns the generations of the snapshot at path, newest
+// first. Quarantined and temporary files are not generations.
+func listGenerations(path string) ([]generation, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(path) + "."
+	generations := make([]generation, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		taken, err := time.Parse(generationLayout, strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		generations = append(generations, generation{
+			path: filepath.Join(filepath.Dir(path), name),
+			time: taken,
+			size: info.Size(),
+		})
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].time.After(generations[j].time)
+	})
+	return generations, nil
+}
+
+// pruneGenerations deletes all but the newest keep generations
+func pruneGenerations(path string, keep int) error {
+	generations, err := listGenerations(path)
+	if err != nil {
+		return err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	for _, old := range generations[min(keep, len(generations)):] {
+		if err := os.Remove(old.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSnapshots lists the snapshot at path and its generations as restore
+// points, newest first
+func ListSnapshots(path string) ([]core.SnapshotInfo, error) {
+	snapshots := make([]core.SnapshotInfo, 0)
+	if info, err := os.Stat(path); err == nil {
+		snapshots = append(snapshots, core.SnapshotInfo{
+			Name:    filepath.Base(path),
+			Time:    info.ModTime().UTC(),
+			Size:    info.Size(),
+			Current: true,
+		})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	generations, err := listGenerations(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range generations {
+		snapshots = append(snapshots, core.SnapshotInfo{
+			Name: filepath.Base(g.path),
+			Time: g.time,
+			Size: g.size,
+		})
+	}
+	return snapshots, nil
+}
+
+// snapshotPath resolves a snapshot name from ListSnapshots to its file,
+// refusing names that could point outside the snapshot's generations
+func snapshotPath(path, name string) (string, error) {
+	base := filepath.Base(path)
+	if name != base {
+		suffix, found := strings.CutPrefix(name, base+".")
+		if !found {
+			return "", fmt.Errorf("%w: %s", ErrUnknownSnapshot, name)
+		}
+		if _, err := time.Parse(generationLayout, suffix); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrUnknownSnapshot, name)
+		}
+	}
+	return filepath.Join(filepath.Dir(path), name), nil
+}
+
+// RestoreSnapshot makes the snapshot with the given name current, for
+// restoring while the server is stopped. The snapshot being replaced
+// becomes a generation, so the restore can be undone. keys decrypts the
+// restored snapshot and encrypts the new current one.
+func RestoreSnapshot(path, name string, keys *Keyring) error {
+	source, err := snapshotPath(path, name)
+	if err != nil {
+		return err
+	}
+	if name == filepath.Base(path) {
+		return nil
+	}
+	data, _, err := readSnapshotFile(source, false, keys)
+	if err != nil {
+		return err
+	}
+	// Keep one more generation than exist so the restore prunes nothing
+	generations, err := listGenerations(path)
+	if err != nil {
+		return err
+	}
+	return WriteSnapshot(path, data, len(generations)+1, keys)
+}
@@ -1,38 +1,71 @@
 package storage
 
 import (
-	"encoding/json"
-	"os"
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/tracing"
 )
 
+// saveCheckInterval is how often the auto-save routine checks its save
+// points
+const saveCheckInterval = time.Second
+
 // MemoryEngine implements in-memory storage with optional persistence
 type MemoryEngine struct {
 	data            map[string]*core.TriffValue
 	mu              sync.RWMutex
 	persistencePath string
 	autoSave        bool
-	saveInterval    time.Duration
+	savePoints      []core.SavePoint
+	dirty           int64 // Writes since the last save
+	lastSave        int64 // UnixNano of the last save or load
 	stopChan        chan bool
+	wakeChan        chan struct{}
+	recovery        RecoveryMode
+	keys            *Keyring
+	loadReport      *LoadReport
+	loadErr         error
+	clock           core.Clock
 }
 
-// NewMemoryEngine creates a new memory storage engine
+// NewMemoryEngine creates a new memory storage engine, loading the snapshot
+// at persistencePath strictly. A snapshot that fails to load is moved aside
+// and the engine starts empty; LoadReport tells what happened.
 func NewMemoryEngine(persistencePath string, autoSave bool) *MemoryEngine {
+	engine, _ := OpenMemoryEngine(persistencePath, autoSave, RecoverStrict, nil)
+	return engine
+}
+
+// OpenMemoryEngine creates a memory storage engine, loading the snapshot
+// at persistencePath with the given recovery mode. Snapshots are
+// encrypted with keys unless it is nil. It returns the load error along
+// with an empty, usable engine when the snapshot is damaged beyond what
+// recovery allows or cannot be decrypted.
+func OpenMemoryEngine(persistencePath string, autoSave bool, recovery RecoveryMode, keys *Keyring) (*MemoryEngine, error) {
 	engine := &MemoryEngine{
 		data:            make(map[string]*core.TriffValue),
 		mu:              sync.RWMutex{},
 		persistencePath: persistencePath,
 		autoSave:        autoSave,
-		saveInterval:    30 * time.Second, // Save every 30 seconds
+		savePoints:      []core.SavePoint{{Seconds: 30, Changes: 1}},
 		stopChan:        make(chan bool),
+		wakeChan:        make(chan struct{}),
+		recovery:        recovery,
+		keys:            keys,
+		clock:           core.SystemClock,
 	}
 	
+	engine.lastSave = engine.clock.Now().UnixNano()
+	
 	// Load existing data if available
 	if persistencePath != "" {
-		engine.loadFromDisk()
+		engine.loadErr = engine.loadFromDisk()
 	}
 	
 	// Start auto-save routine if enabled
@@ -40,7 +73,13 @@ func NewMemoryEngine(persistencePath string, autoSave bool) *MemoryEngine {
 		go engine.autoSaveRoutine()
 	}
 	
-	return engine
+	return engine, engine.loadErr
+}
+
+// LoadReport describes how the snapshot was loaded at startup and the
+// error, if it could not be
+func (me *MemoryEngine) LoadReport() (*LoadReport, error) {
+	return me.loadReport, me.loadErr
 }
 
 // Get retrieves a value from memory
@@ -53,9 +92,9 @@ func (me *MemoryEngine) Get(key string) (*core.TriffValue, bool) {
 		return nil, false
 	}
 	
-	// Check if value has expired
-	if value.TTL > 0 && time.Now().Unix() > value.TTL {
-		delete(me.data, key)
+	// Expired values are left for CleanupExpired; reads only hold the
+	// read lock
+	if me.expired(value) {
 		return nil, false
 	}
 	
@@ -67,7 +106,7 @@ func (me *MemoryEngine) Set(key string, value *core.TriffValue) error {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	
-	now := time.Now()
+	now := me.clock.Now()
 	value.UpdatedAt = now
 	
 	if _, exists := me.data[key]; !exists {
@@ -75,19 +114,58 @@ func (me *MemoryEngine) Set(key string, value *core.TriffValue) error {
 	}
 	
 	me.data[key] = value
+	atomic.AddInt64(&me.dirty, 1)
 	return nil
 }
 
-// Delete removes a key from memory
+// Update atomically replaces the value of key with what fn returns for
+// the current one, nil if the key is missing or expired. Returning the
+// current value leaves the key as is, returning nil deletes it, and an
+// error from fn is returned without changing anything.
+func (me *MemoryEngine) Update(key string, fn func(old *core.TriffValue) (*core.TriffValue, error)) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	
+	old, exists := me.data[key]
+	if exists && me.expired(old) {
+		old = nil
+	}
+	
+	value, err := fn(old)
+	if err != nil || (value == old && old != nil) {
+		return err
+	}
+	if value == nil {
+		if exists {
+			delete(me.data, key)
+			atomic.AddInt64(&me.dirty, 1)
+		}
+		return nil
+	}
+	
+	now := me.clock.Now()
+	value.UpdatedAt = now
+	if old == nil {
+		value.CreatedAt = now
+	}
+	me.data[key] = value
+	atomic.AddInt64(&me.dirty, 1)
+	return nil
+}
+
+// Delete removes a key from memory. It reports false for a key that had
+// already expired, dropping it anyway.
 func (me *MemoryEngine) Delete(key string) bool {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	
-	if _, exists := me.data[key]; exists {
-		delete(me.data, key)
-		return true
+	value, exists := me.data[key]
+	if !exists {
+		return false
 	}
-	return false
+	delete(me.data, key)
+	atomic.AddInt64(&me.dirty, 1)
+	return !me.expired(value)
 }
 
 // Exists checks if a key exists in memory
@@ -95,19 +173,18 @@ func (me *MemoryEngine) Exists(key string) bool {
 	me.mu.RLock()
 	defer me.mu.RUnlock()
 	
-	_, exists := me.data[key]
-	return exists
+	value, exists := me.data[key]
+	return exists && !me.expired(value)
 }
 
-// Keys returns all keys matching a pattern
+// Keys returns all live keys matching a glob pattern (see core.MatchPattern)
 func (me *MemoryEngine) Keys(pattern string) []string {
 	me.mu.RLock()
 	defer me.mu.RUnlock()
 	
 	keys := make([]string, 0)
-	for key := range me.data {
-		// Simple pattern matching - can be enhanced with regex
-		if pattern == "*" || key == pattern {
+	for key, value := range me.data {
+		if !me.expired(value) && core.MatchPattern(pattern, key) {
 			keys = append(keys, key)
 		}
 	}
@@ -119,6 +196,7 @@ func (me *MemoryEngine) FlushAll() error {
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	
+	atomic.AddInt64(&me.dirty, int64(len(me.data)))
 	me.data = make(map[string]*core.TriffValue)
 	return nil
 }
@@ -131,12 +209,21 @@ func (me *MemoryEngine) Size() int64 {
 	return int64(len(me.data))
 }
 
+// expired reports whether value's TTL has passed. Callers must hold the
+// lock.
+func (me *MemoryEngine) expired(value *core.TriffValue) bool {
+	return value.TTL > 0 && me.clock.Now().Unix() > value.TTL
+}
+
 // CleanupExpired removes expired keys from memory
 func (me *MemoryEngine) CleanupExpired() int {
+	_, span := tracing.Start(context.Background(), "storage.cleanup_expired", tracing.KindInternal)
+	defer span.Finish()
+	
 	me.mu.Lock()
 	defer me.mu.Unlock()
 	
-	now := time.Now().Unix()
+	now := me.clock.Now().Unix()
 	removed := 0
 	
 	for key, value := range me.data {
@@ -145,55 +232,62 @@ func (me *MemoryEngine) CleanupExpired() int {
 			removed++
 		}
 	}
+	atomic.AddInt64(&me.dirty, int64(removed))
 	
 	return removed
 }
 
 // SaveToDisk saves current data to disk
-func (me *MemoryEngine) SaveToDisk() error {
+func (me *MemoryEngine) SaveToDisk() (err error) {
 	if me.persistencePath == "" {
 		return nil
 	}
 	
+	_, span := tracing.Start(context.Background(), "storage.save", tracing.KindInternal)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+	
 	me.mu.RLock()
 	defer me.mu.RUnlock()
 	
-	// Create a copy of data for serialization
-	dataCopy := make(map[string]*core.TriffValue)
-	for k, v := range me.data {
-		dataCopy[k] = v
-	}
-	
-	// Serialize to JSON
-	jsonData, err := json.MarshalIndent(dataCopy, "", "  ")
-	if err != nil {
+	// Writers are held off, so the count is exactly what this save covers
+	dirty := atomic.LoadInt64(&me.dirty)
+	if err := WriteSnapshot(me.persistencePath, me.data, DefaultGenerations, me.keys); err != nil {
 		return err
 	}
-	
-	// Write to file
-	return os.WriteFile(me.persistencePath, jsonData, 0644)
+	atomic.AddInt64(&me.dirty, -dirty)
+	atomic.StoreInt64(&me.lastSave, me.clock.Now().UnixNano())
+	return nil
 }
 
 // loadFromDisk loads data from disk if file exists
-func (me *MemoryEngine) loadFromDisk() error {
+func (me *MemoryEngine) loadFromDisk() (err error) {
 	if me.persistencePath == "" {
 		return nil
 	}
 	
-	// Check if file exists
-	if _, err := os.Stat(me.persistencePath); os.IsNotExist(err) {
-		return nil // File doesn't exist, nothing to load
-	}
+	_, span := tracing.Start(context.Background(), "storage.load", tracing.KindInternal)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
 	
-	// Read file
-	jsonData, err := os.ReadFile(me.persistencePath)
+	loadedData, report, err := ReadSnapshot(me.persistencePath, me.recovery, me.keys)
+	me.loadReport = report
 	if err != nil {
-		return err
+		report.Problem = err.Error()
 	}
-	
-	// Deserialize JSON
-	var loadedData map[string]*core.TriffValue
-	if err := json.Unmarshal(jsonData, &loadedData); err != nil {
+	damaged := errors.Is(err, ErrCorruptSnapshot) || errors.Is(err, ErrTruncatedSnapshot)
+	if damaged || report.Recovered {
+		// Keep the damaged file for inspection; left in place, the next
+		// save would rotate it over the backup
+		if quarantined, qerr := QuarantineSnapshot(me.persistencePath); qerr == nil {
+			report.Problem = fmt.Sprintf("%s (moved to %s)", report.Problem, quarantined)
+		}
+	}
+	if err != nil {
 		return err
 	}
 	
@@ -204,18 +298,25 @@ func (me *MemoryEngine) loadFromDisk() error {
 	return nil
 }
 
-// autoSaveRoutine runs in background to periodically save data
+// autoSaveRoutine runs in background, saving whenever a save point is
+// reached
 func (me *MemoryEngine) autoSaveRoutine() {
-	ticker := time.NewTicker(me.saveInterval)
-	defer ticker.Stop()
-	
 	for {
+		me.mu.RLock()
+		clock := me.clock
+		me.mu.RUnlock()
+		
 		select {
-		case <-ticker.C:
+		case <-clock.After(saveCheckInterval):
+			if !me.saveDue() {
+				continue
+			}
 			if err := me.SaveToDisk(); err != nil {
 				// Log error but don't stop the routine
 				continue
 			}
+		case <-me.wakeChan:
+			// The clock changed; wait again by the new one
 		case <-me.stopChan:
 			// Final save before stopping
 			me.SaveToDisk()
@@ -224,6 +325,65 @@ func (me *MemoryEngine) autoSaveRoutine() {
 	}
 }
 
+// saveDue reports whether a save point has been reached
+func (me *MemoryEngine) saveDue() bool {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	
+	elapsed := me.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&me.lastSave)))
+	return core.SavePointDue(me.savePoints, elapsed, atomic.LoadInt64(&me.dirty))
+}
+
+// SetSavePoints changes when the auto-save routine saves. Without points
+// it doesn't.
+func (me *MemoryEngine) SetSavePoints(points []core.SavePoint) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	
+	me.savePoints = append([]core.SavePoint(nil), points...)
+}
+
+// SetSaveInterval makes the auto-save routine save every interval,
+// rounded up to a whole second, if anything changed
+func (me *MemoryEngine) SetSaveInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	seconds := int64((interval + time.Second - 1) / time.Second)
+	me.SetSavePoints([]core.SavePoint{{Seconds: seconds, Changes: 1}})
+}
+
+// Dirty returns the number of writes since the last save
+func (me *MemoryEngine) Dirty() int64 {
+	return atomic.LoadInt64(&me.dirty)
+}
+
+// SetClock makes TTLs and auto-saves go by clock, e.g. a core.FakeClock
+// that a test moves forward instead of waiting for a save point. A nil
+// clock restores core.SystemClock.
+func (me *MemoryEngine) SetClock(clock core.Clock) {
+	if clock == nil {
+		clock = core.SystemClock
+	}
+	
+	me.mu.Lock()
+	me.clock = clock
+	atomic.StoreInt64(&me.lastSave, clock.Now().UnixNano())
+	me.mu.Unlock()
+	
+	if me.autoSave && me.persistencePath != "" {
+		me.wakeChan <- struct{}{}
+	}
+}
+
+// Clock returns the clock TTLs and auto-saves go by
+func (me *MemoryEngine) Clock() core.Clock {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	
+	return me.clock
+}
+
 // Stop stops the auto-save routine and saves data
 func (me *MemoryEngine) Stop() error {
 	if me.autoSave {
@@ -237,20 +397,9 @@ func (me *MemoryEngine) GetMemoryUsage() int64 {
 	me.mu.RLock()
 	defer me.mu.RUnlock()
 	
-	// Simple estimation - can be enhanced with proper memory calculation
 	usage := int64(0)
 	for key, value := range me.data {
-		usage += int64(len(key))
-		switch v := value.Data.(type) {
-		case string:
-			usage += int64(len(v))
-		case []interface{}:
-			usage += int64(len(v) * 8) // Rough estimate
-		case map[string]interface{}:
-			usage += int64(len(v) * 16) // Rough estimate
-		default:
-			usage += 8 // Basic type estimate
-		}
+		usage += core.SizeOf(key, value)
 	}
 	
 	return usage
@@ -266,7 +415,8 @@ func (me *MemoryEngine) GetStats() map[string]interface{} {
 		"memory_usage":   me.GetMemoryUsage(),
 		"persistence":    me.persistencePath != "",
 		"auto_save":      me.autoSave,
-		"save_interval":  me.saveInterval.Seconds(),
+		"save":           core.FormatSavePoints(me.savePoints),
+		"changes_since_last_save": atomic.LoadInt64(&me.dirty),
 	}
 	
 	// Count by data type
@@ -283,6 +433,18 @@ func (me *MemoryEngine) GetStats() map[string]interface{} {
 			typeCounts["set"]++
 		case core.ZSET:
 			typeCounts["zset"]++
+		case core.BLOOM:
+			typeCounts["bloom"]++
+		case core.CMS:
+			typeCounts["cms"]++
+		case core.CUCKOO:
+			typeCounts["cuckoo"]++
+		case core.PNCOUNTER:
+			typeCounts["pncounter"]++
+		case core.ORSET:
+			typeCounts["orset"]++
+		case core.NULL:
+			typeCounts["null"]++
 		}
 	}
 	
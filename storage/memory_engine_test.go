@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/storage/enginetest"
+)
+
+func TestMemoryEngine(t *testing.T) {
+	enginetest.Run(t, enginetest.Harness{
+		Open: func(t testing.TB, dir string) core.StorageEngine {
+			return NewMemoryEngine(filepath.Join(dir, "triff.db"), false)
+		},
+		Close: func(engine core.StorageEngine) error {
+			return engine.(*MemoryEngine).Stop()
+		},
+	})
+}
+
+func TestSnapshotStore(t *testing.T) {
+	enginetest.RunPersistence(t, func(t testing.TB, dir string) core.PersistenceEngine {
+		return NewSnapshotStore(filepath.Join(dir, "triff.db"), RecoverStrict, 2)
+	})
+}
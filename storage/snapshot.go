@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// Snapshot files start with a header line naming the format version,
+// followed by one record per line and a trailer:
+//
+//	TRIFF-SNAPSHOT 1
+//	<crc32 of json> {"key":"...","value":{...}}
+//	...
+//	END <record count> <crc32 of every line above>
+//
+// Per-record checksums let a damaged file be loaded minus the damaged
+// records; the trailer detects truncation.
+const (
+	snapshotMagic   = "TRIFF-SNAPSHOT"
+	snapshotVersion = 1
+	snapshotTrailer = "END"
+)
+
+// Snapshot load errors
+var (
+	ErrCorruptSnapshot   = errors.New("snapshot is corrupt")
+	ErrTruncatedSnapshot = errors.New("snapshot is truncated")
+	ErrSnapshotVersion   = errors.New("unsupported snapshot version")
+)
+
+// RecoveryMode decides what loading does with a damaged snapshot
+type RecoveryMode string
+
+const (
+	// RecoverStrict fails the load
+	RecoverStrict RecoveryMode = "strict"
+	// RecoverBackup loads the newest intact older generation
+	RecoverBackup RecoveryMode = "backup"
+	// RecoverSkip loads every intact record and reports the rest
+	RecoverSkip RecoveryMode = "skip"
+)
+
+// ParseRecoveryMode parses a persistence.recovery setting; "" is strict
+func ParseRecoveryMode(s string) (RecoveryMode, error) {
+	switch mode := RecoveryMode(strings.ToLower(s)); mode {
+	case "", RecoverStrict:
+		return RecoverStrict, nil
+	case RecoverBackup, RecoverSkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid recovery mode: %s (must be strict, backup, or skip)", s)
+}
+
+// SkippedRecord is a snapshot line that could not be loaded
+type SkippedRecord struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// LoadReport describes how a snapshot was loaded
+type LoadReport struct {
+	Path      string          `json:"path"`    // File the data came from
+	Version   int             `json:"version"` // 0 for the legacy single JSON document
+	Records   int             `json:"records"` // Records loaded
+	Skipped   []SkippedRecord `json:"skipped,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Recovered bool            `json:"recovered,omitempty"` // Loaded from the backup after the main file failed
+	Problem   string          `json:"problem,omitempty"`   // Why the main file could not be loaded as is
+	KeyID     string          `json:"key_id,omitempty"`    // Key the file was encrypted with
+}
+
+// snapshotRecord is one line of a snapshot. Keys that aren't valid UTF-8
+// are base64-encoded, with KeyEncoding set.
+type snapshotRecord struct {
+	Key         string           `json:"key"`
+	KeyEncoding string           `json:"key_encoding,omitempty"`
+	Value       *core.TriffValue `json:"value"`
+}
+
+// WriteSnapshot atomically replaces the snapshot at path, encrypting it
+// when keys is not nil. The snapshot it replaces becomes a timestamped
+// generation, and only the newest keep generations are kept. The new file
+// is fully written and synced before it is renamed into place, so a crash
+// never leaves a partial snapshot behind.
+func WriteSnapshot(path string, data map[string]*core.TriffValue, keep int, keys *Keyring) error {
+	var buf bytes.Buffer
+	if err := encodeSnapshot(&buf, data); err != nil {
+		return err
+	}
+	content := buf.Bytes()
+	if keys != nil {
+		sealed, err := keys.seal(content)
+		if err != nil {
+			return err
+		}
+		content = sealed
+	}
+
+	tmp, err := writeTemp(path, content, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := rotateSnapshot(path); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return pruneGenerations(path, keep)
+}
+
+// writeTemp writes and syncs content to a temporary file next to path,
+// returning its name
+func writeTemp(path string, content []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".triff-snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// encodeSnapshot writes data in snapshot format, records ordered by key
+func encodeSnapshot(w io.Writer, data map[string]*core.TriffValue) error {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := bufio.NewWriter(w)
+	sum := crc32.NewIEEE()
+	out := io.MultiWriter(buf, sum)
+
+	fmt.Fprintf(out, "%s %d\n", snapshotMagic, snapshotVersion)
+	for _, key := range keys {
+		record := snapshotRecord{Key: key, Value: data[key]}
+		if !utf8.ValidString(key) {
+			record.Key, record.KeyEncoding = base64.StdEncoding.EncodeToString([]byte(key)), "base64"
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", key, err)
+		}
+		fmt.Fprintf(out, "%08x %s\n", crc32.ChecksumIEEE(line), line)
+	}
+	fmt.Fprintf(buf, "%s %d %08x\n", snapshotTrailer, len(keys), sum.Sum32())
+	return buf.Flush()
+}
+
+// ReadSnapshot loads the snapshot at path, decrypting it with keys if it
+// is encrypted. A missing file loads as empty data. A damaged file is
+// handled according to mode; the report says what was loaded from where
+// and what was lost.
+func ReadSnapshot(path string, mode RecoveryMode, keys *Keyring) (map[string]*core.TriffValue, *LoadReport, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return make(map[string]*core.TriffValue), &LoadReport{Path: path}, nil
+	}
+
+	data, report, err := readSnapshotFile(path, mode == RecoverSkip, keys)
+	if err == nil || mode != RecoverBackup {
+		return data, report, err
+	}
+
+	generations, listErr := listGenerations(path)
+	if listErr != nil {
+		return nil, report, fmt.Errorf("%w (listing backups: %v)", err, listErr)
+	}
+	for _, generation := range generations {
+		backup, backupReport, backupErr := readSnapshotFile(generation.path, false, keys)
+		if backupErr != nil {
+			continue
+		}
+		backupReport.Recovered = true
+		backupReport.Problem = err.Error()
+		return backup, backupReport, nil
+	}
+	return nil, report, fmt.Errorf("%w (no intact backup among %d generations)", err, len(generations))
+}
+
+// readSnapshotFile loads one snapshot file, skipping damaged records when
+// skip is set. Encrypted files are authenticated as a whole, so damage
+// anywhere fails the file.
+func readSnapshotFile(path string, skip bool, keys *Keyring) (map[string]*core.TriffValue, *LoadReport, error) {
+	report := &LoadReport{Path: path}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, report, err
+	}
+	if isEncrypted(content) {
+		content, report.KeyID, err = keys.openFile(content)
+		if err != nil {
+			return nil, report, err
+		}
+	}
+
+	if !bytes.HasPrefix(content, []byte(snapshotMagic+" ")) {
+		// Snapshots written before the format had a header are a single
+		// JSON document without checksums
+		var data map[string]*core.TriffValue
+		if err := json.Unmarshal(content, &data); err != nil {
+			return nil, report, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		report.Records = len(data)
+		return data, report, nil
+	}
+
+	data, err := decodeSnapshot(content, report, skip)
+	if err != nil {
+		return nil, report, err
+	}
+	report.Records = len(data)
+	if report.Truncated || len(report.Skipped) > 0 {
+		report.Problem = fmt.Sprintf("%d records skipped", len(report.Skipped))
+		if report.Truncated {
+			report.Problem += ", trailer missing"
+		}
+	}
+	return data, report, nil
+}
+
+// decodeSnapshot parses a versioned snapshot. Damaged records and a
+// missing trailer are errors unless skip is set, in which case they are
+// recorded in report.
+func decodeSnapshot(content []byte, report *LoadReport, skip bool) (map[string]*core.TriffValue, error) {
+	// A file that does not end in a newline was cut off mid-record; the
+	// partial line is dropped and the missing trailer reports truncation
+	complete := bytes.HasSuffix(content, []byte("\n"))
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	if !complete && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	header := strings.Fields(string(lines[0]))
+	version, err := strconv.Atoi(header[len(header)-1])
+	if err != nil || len(header) != 2 {
+		return nil, fmt.Errorf("%w: bad header %q", ErrCorruptSnapshot, lines[0])
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	report.Version = version
+
+	data := make(map[string]*core.TriffValue)
+	sum := crc32.NewIEEE()
+	sum.Write(lines[0])
+	sum.Write([]byte("\n"))
+
+	fail := func(line int, reason string) error {
+		if !skip {
+			return fmt.Errorf("%w: line %d: %s", ErrCorruptSnapshot, line, reason)
+		}
+		report.Skipped = append(report.Skipped, SkippedRecord{Line: line, Reason: reason})
+		return nil
+	}
+
+	for i, line := range lines[1:] {
+		number := i + 2
+		if bytes.HasPrefix(line, []byte(snapshotTrailer+" ")) {
+			err := checkTrailer(line, len(data)+len(report.Skipped), sum.Sum32())
+			if err != nil && len(report.Skipped) > 0 {
+				// Expected once records were skipped; they are reported
+				return data, nil
+			}
+			if err != nil {
+				return data, fail(number, err.Error())
+			}
+			return data, nil
+		}
+		sum.Write(line)
+		sum.Write([]byte("\n"))
+
+		checksum, payload, found := bytes.Cut(line, []byte(" "))
+		want, err := strconv.ParseUint(string(checksum), 16, 32)
+		if !found || err != nil {
+			if err := fail(number, "malformed record"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if crc32.ChecksumIEEE(payload) != uint32(want) {
+			if err := fail(number, "checksum mismatch"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var record snapshotRecord
+		err = json.Unmarshal(payload, &record)
+		if err == nil && record.KeyEncoding == "base64" {
+			var key []byte
+			key, err = base64.StdEncoding.DecodeString(record.Key)
+			record.Key = string(key)
+		}
+		if err != nil || record.Value == nil {
+			if err := fail(number, "undecodable record"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data[record.Key] = record.Value
+	}
+
+	report.Truncated = true
+	if !skip {
+		return nil, fmt.Errorf("%w after %d records", ErrTruncatedSnapshot, len(data))
+	}
+	return data, nil
+}
+
+// checkTrailer verifies the record count and file checksum of the trailer
+func checkTrailer(line []byte, records int, sum uint32) error {
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 {
+		return errors.New("malformed trailer")
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil || count != records {
+		return fmt.Errorf("trailer expects %s records, found %d", fields[1], records)
+	}
+	want, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil || uint32(want) != sum {
+		return errors.New("file checksum mismatch")
+	}
+	return nil
+}
+
+// QuarantineSnapshot moves a snapshot that failed to load out of the way,
+// so the next save neither overwrites it nor rotates it over a good
+// backup. It returns the new path.
+func QuarantineSnapshot(path string) (string, error) {
+	quarantined := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	return quarantined, os.Rename(path, quarantined)
+}
+
+// SnapshotStore persists a database as checksummed snapshot files,
+// keeping older generations as restore points. It implements
+// core.PersistenceEngine and core.SnapshotHistory.
+type SnapshotStore struct {
+	path        string
+	recovery    RecoveryMode
+	generations int
+	keys        *Keyring
+	report      *LoadReport
+}
+
+// NewSnapshotStore creates a store for the snapshot at path that keeps
+// generations older snapshots
+func NewSnapshotStore(path string, recovery RecoveryMode, generations int) *SnapshotStore {
+	return &SnapshotStore{path: path, recovery: recovery, generations: generations}
+}
+
+// Save writes a new snapshot, turning the current one into a generation
+func (s *SnapshotStore) Save(data map[string]*core.TriffValue) error {
+	return WriteSnapshot(s.path, data, s.generations, s.keys)
+}
+
+// Load reads the snapshot using the store's recovery mode
+func (s *SnapshotStore) Load() (map[string]*core.TriffValue, error) {
+	data, report, err := ReadSnapshot(s.path, s.recovery, s.keys)
+	s.report = report
+	return data, err
+}
+
+// SetPath changes where snapshots are written and read
+func (s *SnapshotStore) SetPath(path string) {
+	s.path = path
+}
+
+// SetKeyring makes the store encrypt new snapshots with keys and decrypt
+// with any key in it. A nil keyring writes plaintext.
+func (s *SnapshotStore) SetKeyring(keys *Keyring) {
+	s.keys = keys
+}
+
+// LastReport describes the most recent Load, or nil before one
+func (s *SnapshotStore) LastReport() *LoadReport {
+	return s.report
+}
+
+// Snapshots lists the current snapshot and its generations, newest first
+func (s *SnapshotStore) Snapshots() ([]core.SnapshotInfo, error) {
+	return ListSnapshots(s.path)
+}
+
+// LoadSnapshot strictly reads the snapshot or generation with the given
+// name, as listed by Snapshots
+func (s *SnapshotStore) LoadSnapshot(name string) (map[string]*core.TriffValue, error) {
+	path, err := snapshotPath(s.path, name)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := readSnapshotFile(path, false, s.keys)
+	return data, err
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// binaryData returns values whose keys, strings, hash fields, list items
+// and set members hold NULs, CRLFs and bytes that aren't valid UTF-8
+func binaryData() map[string]*core.TriffValue {
+	return map[string]*core.TriffValue{
+		"plain":           {Type: core.STRING, Data: "nul\x00and\r\ncrlf"},
+		"\xff\xfekey\x00": {Type: core.STRING, Data: "\x80\x81 not utf-8"},
+		"key\r\n":         {Type: core.STRING, Data: ""},
+		"hash\x00": {Type: core.HASH, Data: map[string]string{
+			"field\x00":  "value\r\n",
+			"\xc3\x28":   "\xff",
+			"utf8 field": "caf\xc3\xa9",
+		}},
+		"list\xff": {Type: core.LIST, Data: []string{"a\x00", "\r\n", "\xfe"}},
+		"set\r\n":  {Type: core.SET, Data: map[string]struct{}{"m\x00": {}, "\xff": {}, "ok": {}}},
+	}
+}
+
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeSnapshot(&buf, binaryData()); err != nil {
+		t.Fatalf("encodeSnapshot: %v", err)
+	}
+	got, err := decodeSnapshot(buf.Bytes(), &LoadReport{}, false)
+	if err != nil {
+		t.Fatalf("decodeSnapshot: %v", err)
+	}
+	checkBinaryData(t, got)
+}
+
+func TestSnapshotFileBinaryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "triff.db")
+	if err := WriteSnapshot(path, binaryData(), 0, nil); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+	got, report, err := ReadSnapshot(path, RecoverStrict, nil)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if len(report.Skipped) > 0 {
+		t.Fatalf("ReadSnapshot skipped %v", report.Skipped)
+	}
+	checkBinaryData(t, got)
+}
+
+// checkBinaryData compares loaded data with binaryData, going by what each
+// type holds rather than the Go types it decoded as
+func checkBinaryData(t *testing.T, got map[string]*core.TriffValue) {
+	t.Helper()
+	want := binaryData()
+	if len(got) != len(want) {
+		t.Errorf("loaded %d keys, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		g, ok := got[key]
+		if !ok {
+			t.Errorf("key %q missing", key)
+			continue
+		}
+		if g.Type != w.Type {
+			t.Errorf("key %q has type %v, want %v", key, g.Type, w.Type)
+			continue
+		}
+		var gotData, wantData interface{}
+		switch w.Type {
+		case core.HASH:
+			gotData, wantData = hashStrings(g.Data), hashStrings(w.Data)
+		case core.LIST:
+			gotData, _ = core.ListItems(g.Data)
+			wantData, _ = core.ListItems(w.Data)
+		case core.SET:
+			gotData, _ = core.SetMembers(g.Data)
+			wantData, _ = core.SetMembers(w.Data)
+		default:
+			gotData, wantData = g.Data, w.Data
+		}
+		if !reflect.DeepEqual(gotData, wantData) {
+			t.Errorf("key %q loaded as %q, want %q", key, gotData, wantData)
+		}
+	}
+}
+
+// hashStrings returns hash data as stored or as decoded from JSON as a map
+// of strings
+func hashStrings(data interface{}) map[string]string {
+	if fields, ok := core.StringMap(data); ok {
+		return fields
+	}
+	fields := make(map[string]string)
+	if decoded, ok := data.(map[string]interface{}); ok {
+		for field, value := range decoded {
+			fields[field], _ = value.(string)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,232 @@
+// Package testutil runs triff in-process for integration tests. Start
+// serves an empty in-memory database over TCP and HTTP on random local
+// ports and stops it when the test ends:
+//
+//	srv := testutil.Start(t)
+//	srv.Seed(map[string]string{"user:1": "alice"})
+//	c := srv.Client()
+//	... exercise the code under test against srv.TCPAddr or srv.HTTPURL ...
+//	srv.AssertString("user:1", "alice")
+package testutil
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nitrix4ly/triff/client"
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/server"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+// Options configures a test server
+type Options struct {
+	TCP  bool // Serve the TCP protocol on TCPAddr
+	HTTP bool // Serve the REST API on HTTPURL
+
+	// Config defaults to utils.DefaultConfig with persistence off. The
+	// ports in it are ignored.
+	Config *core.Config
+
+	// Clock, e.g. a core.FakeClock, replaces the real time for TTLs and
+	// expiry
+	Clock core.Clock
+
+	// Logger defaults to one that only logs errors
+	Logger *utils.Logger
+}
+
+// Server is an in-process triff serving an in-memory database
+type Server struct {
+	DB      *core.Database
+	TCPAddr string // host:port, empty unless Options.TCP is set
+	HTTPURL string // Base URL such as http://127.0.0.1:41234, empty unless Options.HTTP is set
+
+	t testing.TB
+}
+
+// Start serves an empty database over TCP and HTTP until the test ends
+func Start(t testing.TB) *Server {
+	return StartWithOptions(t, Options{TCP: true, HTTP: true})
+}
+
+// StartWithOptions serves an empty database as options say until the
+// test ends. It fails the test if the server can't start.
+func StartWithOptions(t testing.TB, options Options) *Server {
+	t.Helper()
+
+	config := options.Config
+	if config == nil {
+		config = utils.DefaultConfig()
+		config.Persistence.Enabled = false
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = utils.NewLogger("error")
+	}
+	db := core.NewDatabase(config)
+	if options.Clock != nil {
+		db.SetClock(options.Clock)
+	}
+	s := &Server{DB: db, t: t}
+
+	if options.TCP {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("testutil: listening for TCP: %v", err)
+		}
+		tcp := server.NewTCPServer(db, 0, logger)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tcp.Serve(listener)
+		}()
+		t.Cleanup(func() {
+			listener.Close()
+			<-done
+			tcp.Stop()
+		})
+		s.TCPAddr = listener.Addr().String()
+	}
+	if options.HTTP {
+		http := httptest.NewServer(server.NewHTTPServer(db, 0, logger).Handler())
+		t.Cleanup(http.Close)
+		s.HTTPURL = http.URL
+	}
+	return s
+}
+
+// Client dials the TCP server, closing the connection when the test ends
+func (s *Server) Client() *client.Client {
+	s.t.Helper()
+	if s.TCPAddr == "" {
+		s.t.Fatalf("testutil: the server was started without TCP")
+	}
+	c, err := client.Dial(s.TCPAddr)
+	if err != nil {
+		s.t.Fatalf("testutil: dialing %s: %v", s.TCPAddr, err)
+	}
+	s.t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// URL returns the address of an HTTP path, e.g. URL("/api/v1/keys/user:1")
+func (s *Server) URL(path string) string {
+	s.t.Helper()
+	if s.HTTPURL == "" {
+		s.t.Fatalf("testutil: the server was started without HTTP")
+	}
+	return s.HTTPURL + path
+}
+
+// Seed stores string values
+func (s *Server) Seed(values map[string]string) {
+	s.t.Helper()
+	for key, value := range values {
+		s.SeedValue(key, &core.TriffValue{Type: core.STRING, Data: value})
+	}
+}
+
+// SeedHash stores a hash
+func (s *Server) SeedHash(key string, fields map[string]string) {
+	s.t.Helper()
+	s.SeedValue(key, &core.TriffValue{Type: core.HASH, Data: fields})
+}
+
+// SeedWithTTL stores a string value that expires after ttl, rounded up
+// to a whole second
+func (s *Server) SeedWithTTL(key, value string, ttl time.Duration) {
+	s.t.Helper()
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	s.SeedValue(key, &core.TriffValue{
+		Type: core.STRING,
+		Data: value,
+		TTL:  s.DB.Clock().Now().Unix() + seconds,
+	})
+}
+
+// SeedValue stores any value, failing the test if it breaks a limit
+func (s *Server) SeedValue(key string, value *core.TriffValue) {
+	s.t.Helper()
+	if err := s.DB.Set(key, value); err != nil {
+		s.t.Fatalf("testutil: seeding %s: %v", key, err)
+	}
+}
+
+// AssertString checks that key holds the string want
+func (s *Server) AssertString(key, want string) {
+	s.t.Helper()
+	value, exists := s.DB.Get(key)
+	switch {
+	case !exists:
+		s.t.Errorf("%s: missing, want %q", key, want)
+	case value.Type != core.STRING:
+		s.t.Errorf("%s: holds a %s, want the string %q", key, value.Type, want)
+	case value.Data != want:
+		s.t.Errorf("%s = %q, want %q", key, value.Data, want)
+	}
+}
+
+// AssertHash checks that key holds a hash with exactly fields
+func (s *Server) AssertHash(key string, want map[string]string) {
+	s.t.Helper()
+	value, exists := s.DB.Get(key)
+	if !exists {
+		s.t.Errorf("%s: missing, want a hash", key)
+		return
+	}
+	got, ok := core.StringMap(value.Data)
+	if value.Type != core.HASH || !ok {
+		s.t.Errorf("%s: holds a %s, want a hash", key, value.Type)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		s.t.Errorf("%s = %v, want %v", key, got, want)
+	}
+}
+
+// AssertMissing checks that key doesn't exist or has expired
+func (s *Server) AssertMissing(key string) {
+	s.t.Helper()
+	if s.DB.Exists(key) {
+		s.t.Errorf("%s: exists, want it missing", key)
+	}
+}
+
+// AssertTTL checks that key expires in min to max from now
+func (s *Server) AssertTTL(key string, min, max time.Duration) {
+	s.t.Helper()
+	ttl := s.DB.GetTTL(key)
+	switch ttl {
+	case -2:
+		s.t.Errorf("%s: missing, want a TTL of %v to %v", key, min, max)
+	case -1:
+		s.t.Errorf("%s: has no TTL, want %v to %v", key, min, max)
+	default:
+		if got := time.Duration(ttl) * time.Second; got < min || got > max {
+			s.t.Errorf("%s: TTL %v, want %v to %v", key, got, min, max)
+		}
+	}
+}
+
+// AssertKeys checks that the keys matching the glob pattern are exactly
+// want, in any order
+func (s *Server) AssertKeys(pattern string, want ...string) {
+	s.t.Helper()
+	var got []string
+	for _, key := range s.DB.Keys("*") {
+		if core.MatchPattern(pattern, key) {
+			got = append(got, key)
+		}
+	}
+	sort.Strings(got)
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+	if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+		s.t.Errorf("keys matching %s = %v, want %v", pattern, got, want)
+	}
+}
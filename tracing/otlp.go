@@ -0,0 +1,257 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP export tuning
+const (
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	maxQueuedSpans       = 8192 // spans beyond this are dropped rather than buffered
+)
+
+// OTLPExporter batches spans and sends them to an OpenTelemetry collector
+// using OTLP/HTTP with JSON encoding
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	queue       []*Span
+	dropped     int64
+	mu          sync.Mutex
+	flushChan   chan struct{}
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+	done        chan struct{}
+	onError     func(error)
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, e.g.
+// http://localhost:4318. The /v1/traces path is added when missing.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	exporter := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flushChan:   make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go exporter.run()
+	return exporter
+}
+
+// OnError registers a callback for failed exports
+func (e *OTLPExporter) OnError(callback func(error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.onError = callback
+}
+
+// Export queues a finished span
+func (e *OTLPExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, span)
+	if len(e.queue) >= defaultBatchSize {
+		select {
+		case e.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns how many spans were discarded because the queue was full
+func (e *OTLPExporter) Dropped() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.dropped
+}
+
+// Shutdown sends queued spans and stops the background sender
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stopChan) })
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return e.flush(ctx)
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flushChan:
+		case <-e.stopChan:
+			return
+		}
+		if err := e.flush(context.Background()); err != nil {
+			e.mu.Lock()
+			onError := e.onError
+			e.mu.Unlock()
+			if onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// flush sends all queued spans in one request
+func (e *OTLPExporter) flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.queue
+	e.queue = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp export: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp export: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON payload, see opentelemetry-proto trace/v1/trace.proto
+type otlpPayload struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 1 ok, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (e *OTLPExporter) encode(spans []*Span) otlpPayload {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if span.Parent.IsValid() {
+			s.ParentSpanID = span.Parent.String()
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttr(key, value))
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Err}
+		}
+		encoded = append(encoded, s)
+	}
+
+	return otlpPayload{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{otlpAttr("service.name", e.serviceName)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/nitrix4ly/triff"},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v map[string]interface{}
+	switch val := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": val}
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,303 @@
+// Package tracing provides lightweight distributed tracing with W3C trace
+// context propagation and OTLP export. It is disabled unless a tracer is
+// installed with SetTracer; all functions are cheap no-ops otherwise.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the lowercase hex encoding
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// String returns the lowercase hex encoding
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the trace ID is not all zeros
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the span ID is not all zeros
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that propagates across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// SpanKind describes the relationship of a span to its callers
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer
+	KindClient
+)
+
+// Span records a timed operation. A nil *Span is valid and ignores all calls.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SetAttribute attaches a key/value pair to the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Err = err.Error()
+}
+
+// Finish ends the span and hands it to the exporter. Calling it more than
+// once has no effect.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// SpanContext returns the propagation context of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Exporter receives finished, sampled spans
+type Exporter interface {
+	Export(span *Span)
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer creates spans for one service
+type Tracer struct {
+	ServiceName string
+	exporter    Exporter
+	sampleRate  float64
+}
+
+// NewTracer creates a tracer that samples a fraction (0-1) of new traces.
+// Traces started remotely follow the caller's sampling decision.
+func NewTracer(serviceName string, exporter Exporter, sampleRate float64) *Tracer {
+	return &Tracer{
+		ServiceName: serviceName,
+		exporter:    exporter,
+		sampleRate:  sampleRate,
+	}
+}
+
+// Start begins a span as a child of the span or remote context in ctx
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:   name,
+		Kind:   kind,
+		Start:  time.Now(),
+		tracer: t,
+	}
+	span.Context.SpanID = newSpanID()
+
+	if parent, ok := parentContext(ctx); ok {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		span.Context.TraceID = newTraceID()
+		span.Context.Sampled = t.sample(span.Context.TraceID)
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Shutdown flushes and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// sample makes a deterministic decision from the trace ID so every
+// service sampling at the same rate keeps the same traces
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRate >= 1 {
+		return true
+	}
+	if t.sampleRate <= 0 {
+		return false
+	}
+	var n uint64
+	for _, b := range id[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>1) < t.sampleRate*float64(math.MaxUint64>>1)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithRemote stores a span context received from a caller so spans
+// started from ctx join the caller's trace
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// FromContext returns the active span in ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if span := FromContext(ctx); span != nil {
+		return span.Context, true
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok && sc.IsValid() {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+var global atomic.Value // holds *Tracer
+
+// SetTracer installs the process-wide tracer. Passing nil disables tracing.
+func SetTracer(t *Tracer) {
+	global.Store(t)
+}
+
+// Global returns the process-wide tracer, nil when tracing is disabled
+func Global() *Tracer {
+	t, _ := global.Load().(*Tracer)
+	return t
+}
+
+// Start begins a span with the process-wide tracer
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return Global().Start(ctx, name, kind)
+}
+
+// Enabled reports whether a process-wide tracer is installed
+func Enabled() bool {
+	return Global() != nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
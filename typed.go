@@ -0,0 +1,104 @@
+// Package triff is a typed convenience API for apps that embed triff. It
+// stores Go values as JSON and reads keys back into the type the caller
+// asks for, instead of type-asserting the interface{} data of a
+// core.TriffValue or a core.Response:
+//
+//	db := core.NewDatabase(config)
+//	err := triff.SetStruct(db, "user:1", User{Name: "alice"}, time.Hour)
+//	user, err := triff.GetAs[User](db, "user:1")
+//
+// The helpers take any core.StorageEngine, so they work the same on a
+// core.Database and a storage.MemoryEngine.
+package triff
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// clocked engines, like core.Database, say what time TTLs go by
+type clocked interface {
+	Clock() core.Clock
+}
+
+// GetAs reads key into a T. A missing, expired or null key gives the zero
+// T with an error matching errs.ErrNotFound, so callers that treat absence
+// as the zero value can check for it and carry on. Strings are returned as
+// they are for a string T and decoded as JSON otherwise; hashes and lists
+// decode as the JSON object and array they stand for, e.g. into a
+// map[string]string, a []string or a struct with string fields. A value
+// that doesn't fit T gives an error matching errs.ErrWrongType.
+func GetAs[T any](engine core.StorageEngine, key string) (T, error) {
+	var result T
+	value, exists := engine.Get(key)
+	if !exists || value == nil || value.Type == core.NULL {
+		return result, errs.Newf(errs.ErrNotFound, "key %q not found", key)
+	}
+
+	var raw []byte
+	switch value.Type {
+	case core.STRING:
+		s, ok := value.Data.(string)
+		if !ok {
+			return result, errs.Newf(errs.ErrWrongType, "key %q holds %T, not a string", key, value.Data)
+		}
+		if str, ok := interface{}(&result).(*string); ok {
+			*str = s
+			return result, nil
+		}
+		raw = []byte(s)
+	case core.HASH:
+		fields, ok := core.StringMap(value.Data)
+		if !ok {
+			return result, errs.Newf(errs.ErrWrongType, "key %q holds %T, not a hash", key, value.Data)
+		}
+		raw, _ = json.Marshal(fields)
+	case core.LIST:
+		encoded, err := json.Marshal(value.Data)
+		if err != nil {
+			return result, errs.Newf(errs.ErrWrongType, "key %q holds %T, not a list", key, value.Data)
+		}
+		raw = encoded
+	default:
+		return result, errs.Newf(errs.ErrWrongType, "key %q holds a %s, which can't be read as %T", key, value.Type, result)
+	}
+
+	if err := json.Unmarshal(raw, &result); err != nil {
+		var zero T
+		return zero, errs.Newf(errs.ErrWrongType, "key %q holds a %s that can't be read as %T: %v", key, value.Type, result, err)
+	}
+	return result, nil
+}
+
+// SetStruct stores value under key as a JSON string, expiring after ttl
+// rounded up to a whole second, or never if ttl is zero. GetAs reads it
+// back. Values that can't be encoded as JSON, such as channels, give an
+// error matching errs.ErrWrongType and leave the key alone.
+func SetStruct(engine core.StorageEngine, key string, value interface{}, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("negative TTL %s for key %q", ttl, key)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return errs.Newf(errs.ErrWrongType, "%T can't be stored as JSON: %v", value, err)
+	}
+
+	triffValue := &core.TriffValue{Type: core.STRING, Data: string(encoded)}
+	if ttl > 0 {
+		triffValue.TTL = now(engine).Unix() + int64((ttl+time.Second-1)/time.Second)
+	}
+	return engine.Set(key, triffValue)
+}
+
+// now is the time by engine's clock, or the real time for engines without
+// one
+func now(engine core.StorageEngine) time.Time {
+	if c, ok := engine.(clocked); ok {
+		return c.Clock().Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminGenesis is the previous hash of the first record in a chain
+var adminGenesis = strings.Repeat("0", 64)
+
+// AdminRecord is one administrative operation in the admin audit log. Each
+// record names the hash of the one before it, and its own hash covers that
+// link, so editing, removing or reordering records breaks the chain.
+type AdminRecord struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Client    string    `json:"client"`
+	Protocol  string    `json:"protocol"`  // "tcp" or "http"
+	Operation string    `json:"operation"` // e.g. "FLUSHALL", "CONFIG SET"
+	Args      []string  `json:"args,omitempty"`
+	Success   bool      `json:"success"`
+	Prev      string    `json:"prev"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// AdminChainStatus is the result of verifying the admin audit log
+type AdminChainStatus struct {
+	Valid    bool   `json:"valid"`
+	Records  uint64 `json:"records"`
+	Head     string `json:"head"`                // Hash of the last record, to anchor elsewhere
+	BrokenAt uint64 `json:"broken_at,omitempty"` // Line of the first bad record
+	Problem  string `json:"problem,omitempty"`
+}
+
+// AdminLog is an append-only, hash-chained log of administrative
+// operations. Unlike the audit log it is never rotated, since rotation
+// would cut the chain.
+type AdminLog struct {
+	path string
+	file *os.File
+	seq  uint64
+	head string
+	mu   sync.Mutex
+}
+
+// NewAdminLog opens the admin audit log at path, continuing the chain of
+// the records already in it
+func NewAdminLog(path string) (*AdminLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	log := &AdminLog{path: path, file: file, head: adminGenesis}
+
+	// Continue from the last readable record; Verify reports any damage
+	var complete, size int64
+	err = log.scan(func(line int, record AdminRecord, raw []byte) bool {
+		if record.Hash != "" {
+			log.seq, log.head = record.Seq, record.Hash
+		}
+		size += int64(len(raw))
+		if raw[len(raw)-1] == '\n' {
+			complete = size
+		}
+		return true
+	})
+	if err == nil && complete < size {
+		// A write was cut off by a crash; the record never completed
+		err = file.Truncate(complete)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Record appends an operation, filling in its sequence number, time and
+// chain hashes
+func (a *AdminLog) Record(record AdminRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record.Seq = a.seq + 1
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	record.Time = record.Time.UTC()
+	record.Prev = a.head
+	hash, err := hashAdminRecord(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	a.seq, a.head = record.Seq, record.Hash
+	return nil
+}
+
+// Records returns up to limit records after seq since, oldest first,
+// optionally only those of one operation. A limit of 0 means no limit.
+func (a *AdminLog) Records(since uint64, limit int, operation string) ([]AdminRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	records := make([]AdminRecord, 0)
+	err := a.scan(func(line int, record AdminRecord, raw []byte) bool {
+		if record.Seq <= since || (operation != "" && !strings.EqualFold(record.Operation, operation)) {
+			return true
+		}
+		records = append(records, record)
+		return limit <= 0 || len(records) < limit
+	})
+	return records, err
+}
+
+// Verify checks every record's hash and link to its predecessor
+func (a *AdminLog) Verify() (AdminChainStatus, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := AdminChainStatus{Valid: true, Head: adminGenesis}
+	err := a.scan(func(line int, record AdminRecord, raw []byte) bool {
+		problem := ""
+		switch hash, err := hashAdminRecord(record); {
+		case record.Hash == "":
+			problem = fmt.Sprintf("unreadable record: %.80s", raw)
+		case err != nil:
+			problem = err.Error()
+		case record.Prev != status.Head:
+			problem = fmt.Sprintf("record %d does not follow the previous record", record.Seq)
+		case record.Seq != status.Records+1:
+			problem = fmt.Sprintf("record %d is out of sequence", record.Seq)
+		case hash != record.Hash:
+			problem = fmt.Sprintf("record %d was modified", record.Seq)
+		}
+		if problem != "" {
+			status.Valid, status.BrokenAt, status.Problem = false, uint64(line), problem
+			return false
+		}
+		status.Records, status.Head = record.Seq, record.Hash
+		return true
+	})
+	return status, err
+}
+
+// Close closes the log file
+func (a *AdminLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Close()
+}
+
+// scan reads the log from the start, calling fn with each line's number,
+// record and raw bytes until fn returns false. Lines that don't decode are
+// passed as records without a hash.
+func (a *AdminLog) scan(fn func(line int, record AdminRecord, raw []byte) bool) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if len(raw) > 0 {
+			var record AdminRecord
+			if json.Unmarshal(raw, &record) != nil {
+				record = AdminRecord{}
+			}
+			if !fn(line, record, raw) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// hashAdminRecord hashes a record's JSON encoding without its own hash
+func hashAdminRecord(record AdminRecord) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single record in the audit log
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Client    string    `json:"client"`
+	Protocol  string    `json:"protocol"` // "tcp" or "http"
+	Command   string    `json:"command"`
+	Args      []string  `json:"args,omitempty"`
+	Success   bool      `json:"success"`
+}
+
+// AuditLog records write commands as JSON lines, separately from the
+// application log
+type AuditLog struct {
+	out io.WriteCloser
+	mu  sync.Mutex
+}
+
+// NewAuditLog creates an audit log writing to a rotating file at path
+func NewAuditLog(path string, opts RotateOptions) (*AuditLog, error) {
+	file, err := NewRotatingFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{out: file}, nil
+}
+
+// Record appends an entry, stamping the time if it's unset
+func (a *AuditLog) Record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err = a.out.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the underlying file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.out.Close()
+}
+
+// NewRequestID returns a random 16 character hex identifier
+func NewRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// maxCircuits bounds the tracked keys, which come from client input;
+// closed circuits without recent timeouts are dropped beyond it
+const maxCircuits = 1024
+
+// CircuitState describes an open or recently tripped circuit, as reported
+// by CIRCUIT LIST
+type CircuitState struct {
+	Key      string    `json:"key"`
+	Timeouts int       `json:"timeouts"` // Timeouts within the current window
+	Open     bool      `json:"open"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+	Trips    int64     `json:"trips"` // Times the circuit has opened
+}
+
+// circuit tracks the recent timeouts of one command and pattern
+type circuit struct {
+	timeouts []time.Time // within the window, oldest first
+	openedAt time.Time   // zero while closed
+	trial    bool        // a trial run is in flight after the cooldown
+	trips    int64
+}
+
+// CircuitBreaker counts command timeouts per key and, once a key times out
+// Threshold times within Window, rejects it until Cooldown has passed. The
+// first run after the cooldown is a trial: it closes the circuit when it
+// finishes in time and reopens it when it times out again. It is safe for
+// concurrent use.
+type CircuitBreaker struct {
+	config   core.CircuitBreakerConfig
+	circuits map[string]*circuit
+	mu       sync.Mutex
+}
+
+// NewCircuitBreaker creates a breaker; a zero threshold disables it
+func NewCircuitBreaker(config core.CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config:   config,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// SetConfig changes the breaker's thresholds. Disabling it closes every
+// circuit.
+func (b *CircuitBreaker) SetConfig(config core.CircuitBreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	if config.Threshold <= 0 {
+		b.circuits = make(map[string]*circuit)
+	}
+}
+
+// Allow reports whether key may run. While its circuit is open it returns
+// false and how long until a trial run is let through.
+func (b *CircuitBreaker) Allow(key string) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.config.Threshold <= 0 {
+		return true, 0
+	}
+	c, exists := b.circuits[key]
+	if !exists || c.openedAt.IsZero() {
+		return true, 0
+	}
+	if c.trial {
+		return false, 0
+	}
+	reopen := c.openedAt.Add(time.Duration(b.config.Cooldown) * time.Second)
+	if wait := time.Until(reopen); wait > 0 {
+		return false, wait
+	}
+	c.trial = true
+	return true, 0
+}
+
+// Success records that key finished within its timeout
+func (b *CircuitBreaker) Success(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, exists := b.circuits[key]; exists && c.trial {
+		delete(b.circuits, key)
+	}
+}
+
+// Timeout records that key timed out and reports whether this opened its
+// circuit
+func (b *CircuitBreaker) Timeout(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.config.Threshold <= 0 {
+		return false
+	}
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(b.config.Window) * time.Second)
+	c, exists := b.circuits[key]
+	if !exists {
+		if len(b.circuits) >= maxCircuits {
+			b.prune(cutoff)
+		}
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	if c.trial {
+		c.trial = false
+		c.openedAt = now
+		c.trips++
+		return true
+	}
+	if !c.openedAt.IsZero() {
+		return false
+	}
+
+	c.timeouts = append(recentTimeouts(c.timeouts, cutoff), now)
+	if len(c.timeouts) < b.config.Threshold {
+		return false
+	}
+	c.timeouts = c.timeouts[:0]
+	c.openedAt = now
+	c.trips++
+	return true
+}
+
+// States lists every key with recent timeouts or an open circuit, ordered
+// by key
+func (b *CircuitBreaker) States() []CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(time.Now().Add(-time.Duration(b.config.Window) * time.Second))
+	states := make([]CircuitState, 0, len(b.circuits))
+	for key, c := range b.circuits {
+		states = append(states, CircuitState{
+			Key:      key,
+			Timeouts: len(c.timeouts),
+			Open:     !c.openedAt.IsZero(),
+			OpenedAt: c.openedAt,
+			Trips:    c.trips,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Key < states[j].Key
+	})
+	return states
+}
+
+// Reset closes the given circuits, or all of them when none are given. It
+// returns the number of circuits that were open.
+func (b *CircuitBreaker) Reset(keys ...string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(b.circuits))
+		for key := range b.circuits {
+			keys = append(keys, key)
+		}
+	}
+	reset := 0
+	for _, key := range keys {
+		if c, exists := b.circuits[key]; exists {
+			if !c.openedAt.IsZero() {
+				reset++
+			}
+			delete(b.circuits, key)
+		}
+	}
+	return reset
+}
+
+// prune forgets timeouts older than cutoff and closed circuits left with
+// none. The caller must hold the lock.
+func (b *CircuitBreaker) prune(cutoff time.Time) {
+	for key, c := range b.circuits {
+		c.timeouts = recentTimeouts(c.timeouts, cutoff)
+		if len(c.timeouts) == 0 && c.openedAt.IsZero() {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+// recentTimeouts filters timeouts, oldest first, to those after cutoff
+func recentTimeouts(timeouts []time.Time, cutoff time.Time) []time.Time {
+	for i, at := range timeouts {
+		if at.After(cutoff) {
+			return timeouts[i:]
+		}
+	}
+	return timeouts[:0]
+}
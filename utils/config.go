@@ -1,25 +1,153 @@
 package utils
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 	"github.com/nitrix4ly/triff/core"
 )
 
-// LoadConfig loads configuration from YAML file
-func LoadConfig(filepath string) (*core.Config, error) {
-	// Default configuration
-	config := &core.Config{
-		Port:            6379,
-		HTTPPort:        8080,
-		MaxMemory:       1024 * 1024 * 1024, // 1GB
-		PersistencePath: "./triff.db",
-		LogLevel:        "info",
-		EnableHTTP:      true,
-		EnableTCP:       true,
+// DefaultConfig returns the built-in configuration
+func DefaultConfig() *core.Config {
+	return &core.Config{
+		Server: core.ServerConfig{
+			Host:       "0.0.0.0",
+			Port:       6379,
+			HTTPPort:   8080,
+			EnableTCP:  true,
+			EnableHTTP: true,
+			Frontend:   "goroutine",
+			SessionWait: 1000,
+			CORS: core.CORSConfig{
+				CORSPolicy: core.CORSPolicy{
+					AllowedOrigins: []string{"*"},
+					AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+					AllowedHeaders: []string{"Content-Type", "Accept", "Authorization", "X-API-Key", "If-Match", "If-None-Match", "If-Modified-Since", "X-Request-ID", "traceparent", "tracestate"},
+					ExposedHeaders: []string{"ETag", "Last-Modified", "Location", "Retry-After", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+				},
+			},
+		},
+		Storage: core.StorageConfig{
+			Engine:          "memory",
+			MaxMemory:       1024 * 1024 * 1024, // 1GB
+			Compact:         core.DefaultCompact,
+			TTLJitter:       10,
+			DependencyDepth: 16,
+		},
+		Persistence: core.PersistenceConfig{
+			Enabled:      true,
+			Path:         "./triff.db",
+			SaveInterval: 30,
+			Generations:  5,
+		},
+		Security: core.SecurityConfig{
+			Flush: core.FlushConfig{
+				ConfirmTTL: 30,
+			},
+		},
+		Limits: core.LimitsConfig{
+			CircuitBreaker: core.CircuitBreakerConfig{
+				Window:   60,
+				Cooldown: 30,
+			},
+		},
+		Logging: core.LoggingConfig{
+			Level:            "info",
+			Format:           "text",
+			SlowlogThreshold: 10000, // 10ms
+			LatencyThreshold: 100,
+		},
+		Tracing: core.TracingConfig{
+			ServiceName: "triff",
+			SampleRate:  1,
+		},
+		Proxy: core.ProxyConfig{
+			Port:        8081,
+			MaxBodySize: 1024 * 1024, // 1MB
+		},
+		CDC: core.CDCConfig{
+			Topic:     "triff.changes",
+			Journal:   "./triff-cdc",
+			BatchSize: 100,
+		},
+		Sync: core.SyncConfig{
+			Dir:      "./triff-sync",
+			Interval: 30,
+			LogSize:  10000,
+		},
+		Usage: core.UsageConfig{
+			Path:           "./triff-usage.json",
+			Retention:      90,
+			SampleInterval: 60,
+		},
+		Debug: core.DebugConfig{
+			Address: "127.0.0.1:6060",
+		},
+	}
+}
+
+// legacyConfig is the flat configuration format used before the schema was
+// split into sections. Pointer fields tell which keys were actually set.
+type legacyConfig struct {
+	Port             *int      `yaml:"port"`
+	HTTPPort         *int      `yaml:"http_port"`
+	MaxMemory        *int64    `yaml:"max_memory"`
+	PersistencePath  *string   `yaml:"persistence_path"`
+	LogLevel         *string   `yaml:"log_level"`
+	EnableHTTP       *bool     `yaml:"enable_http"`
+	EnableTCP        *bool     `yaml:"enable_tcp"`
+	StaleGrace       *int64    `yaml:"stale_grace"`
+	StatsPrefixes    *[]string `yaml:"stats_prefixes"`
+	SaveInterval     *int64    `yaml:"save_interval"`
+	SlowlogThreshold *int64    `yaml:"slowlog_threshold"`
+}
+
+// applyTo copies every flat key that was present onto its new location
+func (l *legacyConfig) applyTo(config *core.Config) {
+	if l.Port != nil {
+		config.Server.Port = *l.Port
+	}
+	if l.HTTPPort != nil {
+		config.Server.HTTPPort = *l.HTTPPort
+	}
+	if l.MaxMemory != nil {
+		config.Storage.MaxMemory = *l.MaxMemory
+	}
+	if l.PersistencePath != nil {
+		config.Persistence.Path = *l.PersistencePath
+	}
+	if l.LogLevel != nil {
+		config.Logging.Level = *l.LogLevel
 	}
+	if l.EnableHTTP != nil {
+		config.Server.EnableHTTP = *l.EnableHTTP
+	}
+	if l.EnableTCP != nil {
+		config.Server.EnableTCP = *l.EnableTCP
+	}
+	if l.StaleGrace != nil {
+		config.Storage.StaleGrace = *l.StaleGrace
+	}
+	if l.StatsPrefixes != nil {
+		config.Storage.StatsPrefixes = *l.StatsPrefixes
+	}
+	if l.SaveInterval != nil {
+		config.Persistence.SaveInterval = *l.SaveInterval
+	}
+	if l.SlowlogThreshold != nil {
+		config.Logging.SlowlogThreshold = *l.SlowlogThreshold
+	}
+}
+
+// LoadConfig loads configuration from YAML file. Both the sectioned format
+// and the old flat format are accepted; flat keys win when both are present.
+func LoadConfig(filepath string) (*core.Config, error) {
+	config := DefaultConfig()
 	
 	// If no config file specified, return default
 	if filepath == "" {
@@ -42,6 +170,12 @@ func LoadConfig(filepath string) (*core.Config, error) {
 		return nil, err
 	}
 	
+	var legacy legacyConfig
+	if err := yaml.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+	legacy.applyTo(config)
+	
 	return config, nil
 }
 
@@ -57,58 +191,274 @@ func SaveConfig(config *core.Config, filepath string) error {
 
 // GetEnvConfig gets configuration from environment variables
 func GetEnvConfig() *core.Config {
-	config := &core.Config{
-		Port:            6379,
-		HTTPPort:        8080,
-		MaxMemory:       1024 * 1024 * 1024, // 1GB
-		PersistencePath: "./triff.db",
-		LogLevel:        "info",
-		EnableHTTP:      true,
-		EnableTCP:       true,
-	}
+	config := DefaultConfig()
 
 	// Override with environment variables if they exist
+	if host := os.Getenv("TRIFF_HOST"); host != "" {
+		config.Server.Host = host
+	}
+
 	if port := os.Getenv("TRIFF_PORT"); port != "" {
 		if p, err := strconv.Atoi(port); err == nil {
-			config.Port = p
+			config.Server.Port = p
 		}
 	}
 
 	if httpPort := os.Getenv("TRIFF_HTTP_PORT"); httpPort != "" {
 		if p, err := strconv.Atoi(httpPort); err == nil {
-			config.HTTPPort = p
+			config.Server.HTTPPort = p
 		}
 	}
 
+	if engine := os.Getenv("TRIFF_ENGINE"); engine != "" {
+		config.Storage.Engine = engine
+	}
+
 	if maxMem := os.Getenv("TRIFF_MAX_MEMORY"); maxMem != "" {
 		if m, err := strconv.ParseInt(maxMem, 10, 64); err == nil {
-			config.MaxMemory = m
+			config.Storage.MaxMemory = m
 		}
 	}
 
 	if persistPath := os.Getenv("TRIFF_PERSISTENCE_PATH"); persistPath != "" {
-		config.PersistencePath = persistPath
+		config.Persistence.Path = persistPath
 	}
 
 	if logLevel := os.Getenv("TRIFF_LOG_LEVEL"); logLevel != "" {
-		config.LogLevel = logLevel
+		config.Logging.Level = logLevel
+	}
+
+	if logFormat := os.Getenv("TRIFF_LOG_FORMAT"); logFormat != "" {
+		config.Logging.Format = logFormat
+	}
+
+	if logFile := os.Getenv("TRIFF_LOG_FILE"); logFile != "" {
+		config.Logging.File = logFile
+	}
+
+	if auditFile := os.Getenv("TRIFF_AUDIT_FILE"); auditFile != "" {
+		config.Logging.AuditFile = auditFile
 	}
 
 	if enableHTTP := os.Getenv("TRIFF_ENABLE_HTTP"); enableHTTP != "" {
 		if b, err := strconv.ParseBool(enableHTTP); err == nil {
-			config.EnableHTTP = b
+			config.Server.EnableHTTP = b
 		}
 	}
 
 	if enableTCP := os.Getenv("TRIFF_ENABLE_TCP"); enableTCP != "" {
 		if b, err := strconv.ParseBool(enableTCP); err == nil {
-			config.EnableTCP = b
+			config.Server.EnableTCP = b
+		}
+	}
+
+	if origins := os.Getenv("TRIFF_CORS_ORIGINS"); origins != "" {
+		config.Server.CORS.AllowedOrigins = strings.Split(origins, ",")
+	}
+
+	if readOnly := os.Getenv("TRIFF_READ_ONLY"); readOnly != "" {
+		if b, err := strconv.ParseBool(readOnly); err == nil {
+			config.Server.ReadOnly = b
+		}
+	}
+
+	if staleGrace := os.Getenv("TRIFF_STALE_GRACE"); staleGrace != "" {
+		if g, err := strconv.ParseInt(staleGrace, 10, 64); err == nil {
+			config.Storage.StaleGrace = g
+		}
+	}
+
+	if softDelete := os.Getenv("TRIFF_SOFT_DELETE"); softDelete != "" {
+		if d, err := strconv.ParseInt(softDelete, 10, 64); err == nil {
+			config.Storage.SoftDelete = d
+		}
+	}
+
+	if statsPrefixes := os.Getenv("TRIFF_STATS_PREFIXES"); statsPrefixes != "" {
+		config.Storage.StatsPrefixes = strings.Split(statsPrefixes, ",")
+	}
+
+	if nodeID := os.Getenv("TRIFF_NODE_ID"); nodeID != "" {
+		config.Storage.NodeID = nodeID
+	}
+
+	if nodeIDFile := os.Getenv("TRIFF_NODE_ID_FILE"); nodeIDFile != "" {
+		config.Storage.NodeIDFile = nodeIDFile
+	}
+
+	if replica := os.Getenv("TRIFF_REPLICA"); replica != "" {
+		if b, err := strconv.ParseBool(replica); err == nil {
+			config.Server.Replica = b
+		}
+	}
+
+	if maxLag := os.Getenv("TRIFF_READY_MAX_LAG"); maxLag != "" {
+		if n, err := strconv.ParseInt(maxLag, 10, 64); err == nil {
+			config.Server.ReadyMaxLag = n
+		}
+	}
+
+	if keysHint := os.Getenv("TRIFF_KEYS_HINT"); keysHint != "" {
+		if n, err := strconv.Atoi(keysHint); err == nil {
+			config.Storage.KeysHint = n
+		}
+	}
+
+	if frontend := os.Getenv("TRIFF_FRONTEND"); frontend != "" {
+		config.Server.Frontend = frontend
+	}
+
+	if workers := os.Getenv("TRIFF_WORKERS"); workers != "" {
+		if n, err := strconv.Atoi(workers); err == nil {
+			config.Server.Workers.Count = n
+		}
+	}
+
+	if jitter := os.Getenv("TRIFF_TTL_JITTER"); jitter != "" {
+		if n, err := strconv.Atoi(jitter); err == nil {
+			config.Storage.TTLJitter = n
+		}
+	}
+
+	if depth := os.Getenv("TRIFF_DEPENDENCY_DEPTH"); depth != "" {
+		if n, err := strconv.Atoi(depth); err == nil {
+			config.Storage.DependencyDepth = n
+		}
+	}
+
+	if arena := os.Getenv("TRIFF_STRING_ARENA"); arena != "" {
+		if b, err := strconv.ParseBool(arena); err == nil {
+			config.Storage.StringArena = b
+		}
+	}
+
+	if saveInterval := os.Getenv("TRIFF_SAVE_INTERVAL"); saveInterval != "" {
+		if i, err := strconv.ParseInt(saveInterval, 10, 64); err == nil {
+			config.Persistence.SaveInterval = i
+		}
+	}
+
+	if save := os.Getenv("TRIFF_SAVE"); save != "" {
+		if points, err := core.ParseSavePoints(save); err == nil {
+			config.Persistence.Save = points
+		}
+	}
+
+	if key := envSecret("TRIFF_ENCRYPTION_KEY"); key != "" {
+		config.Persistence.Encryption.Enabled = true
+		config.Persistence.Encryption.Key = key
+	}
+
+	if oldKeys := os.Getenv("TRIFF_ENCRYPTION_OLD_KEYS"); oldKeys != "" {
+		config.Persistence.Encryption.OldKeys = strings.Split(oldKeys, ",")
+	}
+
+	if adminAuditFile := os.Getenv("TRIFF_ADMIN_AUDIT_FILE"); adminAuditFile != "" {
+		config.Logging.AdminAuditFile = adminAuditFile
+	}
+
+	if usageFile := os.Getenv("TRIFF_USAGE_FILE"); usageFile != "" {
+		config.Usage.Enabled = true
+		config.Usage.Path = usageFile
+	}
+
+	if slowlog := os.Getenv("TRIFF_SLOWLOG_THRESHOLD"); slowlog != "" {
+		if t, err := strconv.ParseInt(slowlog, 10, 64); err == nil {
+			config.Logging.SlowlogThreshold = t
 		}
 	}
 
+	// Standard OpenTelemetry variables
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		config.Tracing.Enabled = true
+		config.Tracing.Endpoint = endpoint
+	}
+
+	if serviceName := os.Getenv("OTEL_SERVICE_NAME"); serviceName != "" {
+		config.Tracing.ServiceName = serviceName
+	}
+
+	if password := envSecret("TRIFF_PASSWORD"); password != "" {
+		config.Security.Password = password
+	}
+
+	if cert := envSecret("TRIFF_TLS_CERT"); cert != "" {
+		config.Security.TLSCert = cert
+	}
+
+	if key := envSecret("TRIFF_TLS_KEY"); key != "" {
+		config.Security.TLSKey = key
+	}
+
+	if enabled := os.Getenv("TRIFF_PERSISTENCE"); enabled != "" {
+		if b, err := strconv.ParseBool(enabled); err == nil {
+			config.Persistence.Enabled = b
+		}
+	}
+
+	if maxClients := os.Getenv("TRIFF_MAX_CLIENTS"); maxClients != "" {
+		if n, err := strconv.Atoi(maxClients); err == nil {
+			config.Limits.MaxClients = n
+		}
+	}
+
+	if timeout := os.Getenv("TRIFF_COMMAND_TIMEOUT"); timeout != "" {
+		if n, err := strconv.ParseInt(timeout, 10, 64); err == nil {
+			config.Limits.CommandTimeout = n
+		}
+	}
+
+	if debugAddr := os.Getenv("TRIFF_DEBUG_ADDR"); debugAddr != "" {
+		config.Debug.Enabled = true
+		config.Debug.Address = debugAddr
+	}
+
+	if debugToken := envSecret("TRIFF_DEBUG_TOKEN"); debugToken != "" {
+		config.Debug.Token = debugToken
+	}
+
 	return config
 }
 
+// secretEnv are the variables that can also be read from the file named
+// by the variable with _FILE appended, as with Docker and Kubernetes
+// secrets mounted as files
+var secretEnv = []string{"TRIFF_PASSWORD", "TRIFF_ENCRYPTION_KEY", "TRIFF_DEBUG_TOKEN", "TRIFF_TLS_CERT", "TRIFF_TLS_KEY"}
+
+// envSecret returns the variable name, or else the contents of the file
+// named by name_FILE without the trailing newline. CheckEnvSecrets
+// reports the files that can't be read.
+func envSecret(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			return strings.TrimRight(string(data), "\r\n")
+		}
+	}
+	return ""
+}
+
+// envSecretSet reports whether name or name_FILE is set
+func envSecretSet(name string) bool {
+	return os.Getenv(name) != "" || os.Getenv(name+"_FILE") != ""
+}
+
+// CheckEnvSecrets fails if a secret's _FILE variable names a file that
+// can't be read, which GetEnvConfig would otherwise treat as the secret
+// not being set
+func CheckEnvSecrets() error {
+	for _, name := range secretEnv {
+		if path := os.Getenv(name + "_FILE"); path != "" && os.Getenv(name) == "" {
+			if _, err := os.ReadFile(path); err != nil {
+				return fmt.Errorf("%s_FILE: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
 // MergeConfigs merges multiple config sources with priority: env > file > default
 func MergeConfigs(filepath string) (*core.Config, error) {
 	// Start with file config (which includes defaults)
@@ -121,26 +471,140 @@ func MergeConfigs(filepath string) (*core.Config, error) {
 	envConfig := GetEnvConfig()
 	
 	// Only override non-default values from env
+	if os.Getenv("TRIFF_HOST") != "" {
+		config.Server.Host = envConfig.Server.Host
+	}
 	if os.Getenv("TRIFF_PORT") != "" {
-		config.Port = envConfig.Port
+		config.Server.Port = envConfig.Server.Port
 	}
 	if os.Getenv("TRIFF_HTTP_PORT") != "" {
-		config.HTTPPort = envConfig.HTTPPort
+		config.Server.HTTPPort = envConfig.Server.HTTPPort
+	}
+	if os.Getenv("TRIFF_ENGINE") != "" {
+		config.Storage.Engine = envConfig.Storage.Engine
 	}
 	if os.Getenv("TRIFF_MAX_MEMORY") != "" {
-		config.MaxMemory = envConfig.MaxMemory
+		config.Storage.MaxMemory = envConfig.Storage.MaxMemory
 	}
 	if os.Getenv("TRIFF_PERSISTENCE_PATH") != "" {
-		config.PersistencePath = envConfig.PersistencePath
+		config.Persistence.Path = envConfig.Persistence.Path
 	}
 	if os.Getenv("TRIFF_LOG_LEVEL") != "" {
-		config.LogLevel = envConfig.LogLevel
+		config.Logging.Level = envConfig.Logging.Level
+	}
+	if os.Getenv("TRIFF_LOG_FORMAT") != "" {
+		config.Logging.Format = envConfig.Logging.Format
+	}
+	if os.Getenv("TRIFF_LOG_FILE") != "" {
+		config.Logging.File = envConfig.Logging.File
+	}
+	if os.Getenv("TRIFF_AUDIT_FILE") != "" {
+		config.Logging.AuditFile = envConfig.Logging.AuditFile
+	}
+	if os.Getenv("TRIFF_ADMIN_AUDIT_FILE") != "" {
+		config.Logging.AdminAuditFile = envConfig.Logging.AdminAuditFile
+	}
+	if os.Getenv("TRIFF_USAGE_FILE") != "" {
+		config.Usage = envConfig.Usage
 	}
 	if os.Getenv("TRIFF_ENABLE_HTTP") != "" {
-		config.EnableHTTP = envConfig.EnableHTTP
+		config.Server.EnableHTTP = envConfig.Server.EnableHTTP
 	}
 	if os.Getenv("TRIFF_ENABLE_TCP") != "" {
-		config.EnableTCP = envConfig.EnableTCP
+		config.Server.EnableTCP = envConfig.Server.EnableTCP
+	}
+	if os.Getenv("TRIFF_CORS_ORIGINS") != "" {
+		config.Server.CORS.AllowedOrigins = envConfig.Server.CORS.AllowedOrigins
+	}
+	if os.Getenv("TRIFF_READ_ONLY") != "" {
+		config.Server.ReadOnly = envConfig.Server.ReadOnly
+	}
+	if os.Getenv("TRIFF_STALE_GRACE") != "" {
+		config.Storage.StaleGrace = envConfig.Storage.StaleGrace
+	}
+	if os.Getenv("TRIFF_SOFT_DELETE") != "" {
+		config.Storage.SoftDelete = envConfig.Storage.SoftDelete
+	}
+	if os.Getenv("TRIFF_STATS_PREFIXES") != "" {
+		config.Storage.StatsPrefixes = envConfig.Storage.StatsPrefixes
+	}
+	if os.Getenv("TRIFF_NODE_ID") != "" {
+		config.Storage.NodeID = envConfig.Storage.NodeID
+	}
+	if os.Getenv("TRIFF_NODE_ID_FILE") != "" {
+		config.Storage.NodeIDFile = envConfig.Storage.NodeIDFile
+	}
+	if os.Getenv("TRIFF_REPLICA") != "" {
+		config.Server.Replica = envConfig.Server.Replica
+	}
+	if os.Getenv("TRIFF_READY_MAX_LAG") != "" {
+		config.Server.ReadyMaxLag = envConfig.Server.ReadyMaxLag
+	}
+	if os.Getenv("TRIFF_KEYS_HINT") != "" {
+		config.Storage.KeysHint = envConfig.Storage.KeysHint
+	}
+	if os.Getenv("TRIFF_FRONTEND") != "" {
+		config.Server.Frontend = envConfig.Server.Frontend
+	}
+	if os.Getenv("TRIFF_WORKERS") != "" {
+		config.Server.Workers.Count = envConfig.Server.Workers.Count
+	}
+	if os.Getenv("TRIFF_TTL_JITTER") != "" {
+		config.Storage.TTLJitter = envConfig.Storage.TTLJitter
+	}
+	if os.Getenv("TRIFF_DEPENDENCY_DEPTH") != "" {
+		config.Storage.DependencyDepth = envConfig.Storage.DependencyDepth
+	}
+	if os.Getenv("TRIFF_STRING_ARENA") != "" {
+		config.Storage.StringArena = envConfig.Storage.StringArena
+	}
+	if os.Getenv("TRIFF_SAVE_INTERVAL") != "" {
+		config.Persistence.SaveInterval = envConfig.Persistence.SaveInterval
+	}
+	if os.Getenv("TRIFF_SAVE") != "" {
+		config.Persistence.Save = envConfig.Persistence.Save
+	}
+	if envSecretSet("TRIFF_ENCRYPTION_KEY") {
+		config.Persistence.Encryption.Enabled = true
+		config.Persistence.Encryption.Key = envConfig.Persistence.Encryption.Key
+	}
+	if os.Getenv("TRIFF_ENCRYPTION_OLD_KEYS") != "" {
+		config.Persistence.Encryption.OldKeys = envConfig.Persistence.Encryption.OldKeys
+	}
+	if os.Getenv("TRIFF_SLOWLOG_THRESHOLD") != "" {
+		config.Logging.SlowlogThreshold = envConfig.Logging.SlowlogThreshold
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		config.Tracing.Enabled = true
+		config.Tracing.Endpoint = envConfig.Tracing.Endpoint
+	}
+	if os.Getenv("OTEL_SERVICE_NAME") != "" {
+		config.Tracing.ServiceName = envConfig.Tracing.ServiceName
+	}
+	if envSecretSet("TRIFF_PASSWORD") {
+		config.Security.Password = envConfig.Security.Password
+	}
+	if envSecretSet("TRIFF_TLS_CERT") {
+		config.Security.TLSCert = envConfig.Security.TLSCert
+	}
+	if envSecretSet("TRIFF_TLS_KEY") {
+		config.Security.TLSKey = envConfig.Security.TLSKey
+	}
+	if os.Getenv("TRIFF_PERSISTENCE") != "" {
+		config.Persistence.Enabled = envConfig.Persistence.Enabled
+	}
+	if os.Getenv("TRIFF_MAX_CLIENTS") != "" {
+		config.Limits.MaxClients = envConfig.Limits.MaxClients
+	}
+	if os.Getenv("TRIFF_COMMAND_TIMEOUT") != "" {
+		config.Limits.CommandTimeout = envConfig.Limits.CommandTimeout
+	}
+	if os.Getenv("TRIFF_DEBUG_ADDR") != "" {
+		config.Debug.Enabled = true
+		config.Debug.Address = envConfig.Debug.Address
+	}
+	if envSecretSet("TRIFF_DEBUG_TOKEN") {
+		config.Debug.Token = envConfig.Debug.Token
 	}
 
 	return config, nil
@@ -148,28 +612,397 @@ func MergeConfigs(filepath string) (*core.Config, error) {
 
 // ValidateConfig validates the configuration values
 func ValidateConfig(config *core.Config) error {
-	if config.Port < 1 || config.Port > 65535 {
-		return fmt.Errorf("invalid port: %d (must be between 1-65535)", config.Port)
+	if config.Server.Port < 1 || config.Server.Port > 65535 {
+		return fmt.Errorf("invalid port: %d (must be between 1-65535)", config.Server.Port)
+	}
+	
+	if config.Server.HTTPPort < 1 || config.Server.HTTPPort > 65535 {
+		return fmt.Errorf("invalid HTTP port: %d (must be between 1-65535)", config.Server.HTTPPort)
+	}
+	
+	if !config.Server.EnableHTTP && !config.Server.EnableTCP {
+		return fmt.Errorf("at least one protocol (HTTP or TCP) must be enabled")
+	}
+	
+	if config.Server.SessionWait < 0 {
+		return fmt.Errorf("invalid session wait: %d (must not be negative)", config.Server.SessionWait)
+	}
+	
+	if config.Server.ReadyMaxLag < 0 {
+		return fmt.Errorf("invalid ready max lag: %d (must not be negative)", config.Server.ReadyMaxLag)
 	}
 	
-	if config.HTTPPort < 1 || config.HTTPPort > 65535 {
-		return fmt.Errorf("invalid HTTP port: %d (must be between 1-65535)", config.HTTPPort)
+	if config.Server.ReadTimeout < 0 || config.Server.WriteTimeout < 0 {
+		return fmt.Errorf("invalid timeouts: read %d, write %d (must not be negative)", config.Server.ReadTimeout, config.Server.WriteTimeout)
 	}
 	
-	if config.MaxMemory < 1024*1024 { // Minimum 1MB
-		return fmt.Errorf("max memory too small: %d (minimum 1MB)", config.MaxMemory)
+	switch config.Server.Frontend {
+	case "", "goroutine", "eventloop":
+	default:
+		return fmt.Errorf("invalid frontend: %s (must be goroutine or eventloop)", config.Server.Frontend)
+	}
+	
+	if workers := config.Server.Workers; workers.Count < 0 || workers.QueueSize < 0 {
+		return fmt.Errorf("invalid workers: count %d, queue size %d (must not be negative)", workers.Count, workers.QueueSize)
+	}
+	for command, limit := range config.Server.Workers.CommandConcurrency {
+		if limit <= 0 {
+			return fmt.Errorf("command concurrency for %s must be positive", command)
+		}
+	}
+	
+	if err := validateCORS(config.Server.CORS); err != nil {
+		return err
+	}
+	
+	if config.Storage.Engine != "memory" && config.Storage.Engine != "disk" {
+		return fmt.Errorf("invalid storage engine: %s (must be memory or disk)", config.Storage.Engine)
+	}
+	
+	if config.Storage.MaxMemory < 1024*1024 { // Minimum 1MB
+		return fmt.Errorf("max memory too small: %d (minimum 1MB)", config.Storage.MaxMemory)
+	}
+	
+	if config.Storage.StaleGrace < 0 {
+		return fmt.Errorf("invalid stale grace: %d (must not be negative)", config.Storage.StaleGrace)
+	}
+	
+	if config.Storage.SoftDelete < 0 {
+		return fmt.Errorf("invalid soft delete window: %d (must not be negative)", config.Storage.SoftDelete)
+	}
+	
+	if config.Storage.TTLJitter < 0 || config.Storage.TTLJitter > 100 {
+		return fmt.Errorf("invalid TTL jitter: %d (must be a percentage from 0 to 100)", config.Storage.TTLJitter)
+	}
+	if config.Storage.DependencyDepth < 0 {
+		return fmt.Errorf("invalid dependency depth: %d (must not be negative)", config.Storage.DependencyDepth)
+	}
+	
+	if config.Storage.KeysHint < 0 {
+		return fmt.Errorf("invalid keys hint: %d (must not be negative)", config.Storage.KeysHint)
+	}
+	
+	if err := validateCompact(config.Storage.Compact); err != nil {
+		return err
+	}
+	
+	if err := validatePartitions(config.Storage.Partitions); err != nil {
+		return err
+	}
+	
+	if config.Persistence.Enabled && config.Persistence.Path == "" {
+		return fmt.Errorf("persistence path is required when persistence is enabled")
+	}
+	
+	if config.Persistence.SaveInterval < 0 {
+		return fmt.Errorf("invalid save interval: %d (must not be negative)", config.Persistence.SaveInterval)
+	}
+	for i, point := range config.Persistence.Save {
+		if point.Seconds <= 0 || point.Changes <= 0 {
+			return fmt.Errorf("invalid persistence.save[%d]: %d seconds, %d changes (must be positive)", i, point.Seconds, point.Changes)
+		}
+	}
+	
+	if (config.Security.TLSCert == "") != (config.Security.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	
+	if config.Security.Flush.RequireAdmin && config.Security.Password == "" {
+		return fmt.Errorf("security.flush.require_admin needs security.password")
+	}
+	if config.Security.Flush.Confirm && config.Security.Flush.ConfirmTTL <= 0 {
+		return fmt.Errorf("invalid flush confirm_ttl: %d (must be positive)", config.Security.Flush.ConfirmTTL)
+	}
+	renamedTo := make(map[string]string, len(config.Security.RenameCommands))
+	for command, name := range config.Security.RenameCommands {
+		if command == "" || strings.ContainsAny(command+name, " \t\r\n") {
+			return fmt.Errorf("invalid rename of command %q to %q", command, name)
+		}
+		if name == "" {
+			continue
+		}
+		if other, taken := renamedTo[strings.ToUpper(name)]; taken {
+			return fmt.Errorf("commands %s and %s are both renamed to %s", other, command, name)
+		}
+		renamedTo[strings.ToUpper(name)] = command
+	}
+	
+	if config.Limits.MaxClients < 0 || config.Limits.MaxKeySize < 0 || config.Limits.MaxValueSize < 0 || config.Limits.MaxKeys < 0 || config.Limits.MaxElements < 0 || config.Limits.CommandTimeout < 0 {
+		return fmt.Errorf("limits must not be negative (use 0 for unlimited)")
+	}
+	for command, timeout := range config.Limits.CommandTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("command timeout for %s must not be negative", command)
+		}
+	}
+	if breaker := config.Limits.CircuitBreaker; breaker.Threshold < 0 {
+		return fmt.Errorf("circuit breaker threshold must not be negative (use 0 to disable)")
+	} else if breaker.Threshold > 0 && (breaker.Window <= 0 || breaker.Cooldown <= 0) {
+		return fmt.Errorf("circuit breaker window and cooldown must be positive")
+	}
+	
+	if bp := config.Limits.Backpressure; bp.MaxQueueDepth < 0 || bp.MaxFlushLatency < 0 || bp.MaxDelay < 0 {
+		return fmt.Errorf("backpressure limits must not be negative (use 0 to disable)")
+	}
+	
+	if config.Persistence.Generations < 0 {
+		return fmt.Errorf("persistence generations must not be negative")
+	}
+	validRecovery := map[string]bool{"": true, "strict": true, "backup": true, "skip": true}
+	if !validRecovery[config.Persistence.Recovery] {
+		return fmt.Errorf("invalid recovery mode: %s (must be strict, backup, or skip)", config.Persistence.Recovery)
+	}
+	if encryption := config.Persistence.Encryption; encryption.Enabled && encryption.Key == "" && encryption.KeyCommand == "" {
+		return fmt.Errorf("persistence encryption needs a key or key_command")
 	}
 	
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true,
 	}
-	if !validLogLevels[config.LogLevel] {
-		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", config.LogLevel)
+	if !validLogLevels[config.Logging.Level] {
+		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", config.Logging.Level)
 	}
 	
-	if !config.EnableHTTP && !config.EnableTCP {
-		return fmt.Errorf("at least one protocol (HTTP or TCP) must be enabled")
+	if config.Logging.Format != "text" && config.Logging.Format != "json" {
+		return fmt.Errorf("invalid log format: %s (must be text or json)", config.Logging.Format)
+	}
+	
+	if config.Logging.SlowlogThreshold < -1 {
+		return fmt.Errorf("invalid slowlog threshold: %d (use -1 to disable)", config.Logging.SlowlogThreshold)
+	}
+	
+	if config.Logging.LatencyThreshold < 0 {
+		return fmt.Errorf("invalid latency threshold: %d (use 0 to disable)", config.Logging.LatencyThreshold)
+	}
+	
+	if config.Tracing.Enabled && config.Tracing.Endpoint == "" {
+		return fmt.Errorf("tracing endpoint is required when tracing is enabled")
 	}
 	
+	if config.Tracing.SampleRate < 0 || config.Tracing.SampleRate > 1 {
+		return fmt.Errorf("invalid trace sample rate: %g (must be between 0 and 1)", config.Tracing.SampleRate)
+	}
+	
+	if config.Proxy.Enabled {
+		if config.Proxy.Port < 1 || config.Proxy.Port > 65535 {
+			return fmt.Errorf("invalid proxy port: %d (must be between 1-65535)", config.Proxy.Port)
+		}
+		if len(config.Proxy.Upstreams) == 0 {
+			return fmt.Errorf("proxy needs at least one upstream")
+		}
+		for prefix, target := range config.Proxy.Upstreams {
+			if !strings.HasPrefix(prefix, "/") {
+				return fmt.Errorf("invalid proxy prefix: %s (must start with /)", prefix)
+			}
+			if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+				return fmt.Errorf("invalid upstream for %s: %s (must be an http or https URL)", prefix, target)
+			}
+		}
+	}
+	
+	if config.Proxy.DefaultTTL < 0 || config.Proxy.MaxBodySize < 0 {
+		return fmt.Errorf("proxy default_ttl and max_body_size must not be negative")
+	}
+	
+	if config.Logging.MaxSize < 0 || config.Logging.MaxAge < 0 || config.Logging.MaxBackups < 0 {
+		return fmt.Errorf("log rotation limits must not be negative (use 0 to disable)")
+	}
+	
+	if config.CDC.Enabled {
+		switch config.CDC.Sink {
+		case "webhook", "kafka", "nats", "file":
+		default:
+			return fmt.Errorf("invalid cdc sink: %s (must be webhook, kafka, nats or file)", config.CDC.Sink)
+		}
+		if config.CDC.URL == "" {
+			return fmt.Errorf("cdc url is required when cdc is enabled")
+		}
+		if config.CDC.Journal == "" {
+			return fmt.Errorf("cdc journal directory is required when cdc is enabled")
+		}
+		if config.CDC.BatchSize < 1 {
+			return fmt.Errorf("invalid cdc batch size: %d (must be positive)", config.CDC.BatchSize)
+		}
+	}
+	
+	if config.Sync.LogSize < 0 {
+		return fmt.Errorf("invalid sync log size: %d (must not be negative)", config.Sync.LogSize)
+	}
+	if config.Sync.Enabled {
+		if config.Sync.URL == "" {
+			return fmt.Errorf("sync url is required when sync is enabled")
+		}
+		if config.Sync.Dir == "" {
+			return fmt.Errorf("sync directory is required when sync is enabled")
+		}
+		if config.Sync.Interval < 1 {
+			return fmt.Errorf("invalid sync interval: %d (must be positive)", config.Sync.Interval)
+		}
+		if len(config.Sync.Scopes) == 0 {
+			return fmt.Errorf("sync needs at least one scope")
+		}
+		for _, scope := range config.Sync.Scopes {
+			switch scope.Mode {
+			case "", "both", "push", "pull":
+			default:
+				return fmt.Errorf("invalid sync mode for %q: %s (must be both, push or pull)", scope.Prefix, scope.Mode)
+			}
+			switch scope.Conflict {
+			case "", "server", "client", "newest":
+			default:
+				return fmt.Errorf("invalid sync conflict policy for %q: %s (must be server, client or newest)", scope.Prefix, scope.Conflict)
+			}
+		}
+	}
+	
+	names, keys := make(map[string]bool), make(map[string]bool)
+	for _, apiKey := range config.Security.APIKeys {
+		if apiKey.Name == "" || apiKey.Key == "" {
+			return fmt.Errorf("api keys need a name and a key")
+		}
+		if names[apiKey.Name] || keys[apiKey.Key] {
+			return fmt.Errorf("api key %s is not unique", apiKey.Name)
+		}
+		if apiKey.Key == config.Security.Password {
+			return fmt.Errorf("api key %s must differ from security.password", apiKey.Name)
+		}
+		if apiKey.DailyCommands < 0 || apiKey.DailyBytes < 0 {
+			return fmt.Errorf("quotas of api key %s must not be negative (use 0 for unlimited)", apiKey.Name)
+		}
+		names[apiKey.Name], keys[apiKey.Key] = true, true
+	}
+	if config.Usage.Enabled {
+		if config.Usage.Path == "" {
+			return fmt.Errorf("usage path is required when usage accounting is enabled")
+		}
+		if config.Usage.Retention < 1 {
+			return fmt.Errorf("invalid usage retention: %d (must be positive)", config.Usage.Retention)
+		}
+		if config.Usage.SampleInterval < 1 {
+			return fmt.Errorf("invalid usage sample interval: %d (must be positive)", config.Usage.SampleInterval)
+		}
+	}
+	
+	if config.Debug.Enabled {
+		host, _, err := net.SplitHostPort(config.Debug.Address)
+		if err != nil {
+			return fmt.Errorf("invalid debug address: %s (must be host:port)", config.Debug.Address)
+		}
+		// Profiles expose memory contents, so only a loopback listener may
+		// run without credentials
+		if config.Debug.Token == "" && config.Security.Password == "" && !isLoopback(host) {
+			return fmt.Errorf("debug listener on %s needs debug.token or security.password (or a loopback address)", config.Debug.Address)
+		}
+		if config.Debug.MutexProfileFraction < 0 || config.Debug.BlockProfileRate < 0 {
+			return fmt.Errorf("debug profile rates must not be negative (use 0 to disable)")
+		}
+	}
+	
+	return nil
+}
+
+// isLoopback reports whether a listen host only accepts local connections
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateCORS checks the global CORS policy and each route's policy as it
+// applies on top of it
+func validateCORS(cors core.CORSConfig) error {
+	if err := validateCORSPolicy("server.cors", cors.CORSPolicy, cors.AllowCredentials); err != nil {
+		return err
+	}
+	for _, route := range cors.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return fmt.Errorf("invalid cors route prefix: %q (must start with /)", route.Prefix)
+		}
+		credentials := cors.AllowCredentials
+		if route.AllowCredentials != nil {
+			credentials = route.AllowCredentials
+		}
+		origins := route.AllowedOrigins
+		if origins == nil {
+			origins = cors.AllowedOrigins
+		}
+		policy := route.CORSPolicy
+		policy.AllowedOrigins = origins
+		if err := validateCORSPolicy("cors route "+route.Prefix, policy, credentials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateCORSPolicy(name string, policy core.CORSPolicy, credentials *bool) error {
+	for _, origin := range policy.AllowedOrigins {
+		if origin == "*" {
+			if credentials != nil && *credentials {
+				return fmt.Errorf("%s: allow_credentials needs explicit allowed_origins, not *", name)
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("%s: invalid allowed origin %q (must be *, or scheme://host[:port] with an optional *. subdomain wildcard)", name, origin)
+		}
+		if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("%s: invalid allowed origin %q (only a leading *. subdomain wildcard is supported)", name, origin)
+		}
+	}
+	for _, method := range policy.AllowedMethods {
+		if method == "" || strings.ContainsAny(method, " \t,") {
+			return fmt.Errorf("%s: invalid allowed method %q", name, method)
+		}
+	}
+	if policy.MaxAge != nil && *policy.MaxAge < 0 {
+		return fmt.Errorf("%s: invalid max_age: %d (must not be negative)", name, *policy.MaxAge)
+	}
+	return nil
+}
+
+// validateCompact rejects negative packing limits
+func validateCompact(compact core.CompactConfig) error {
+	limits := []struct {
+		name  string
+		limit int
+	}{
+		{"hash_max_entries", compact.HashMaxEntries},
+		{"hash_max_value", compact.HashMaxValue},
+		{"list_max_entries", compact.ListMaxEntries},
+		{"list_max_value", compact.ListMaxValue},
+		{"set_max_entries", compact.SetMaxEntries},
+		{"set_max_value", compact.SetMaxValue},
+	}
+	for _, l := range limits {
+		if l.limit < 0 {
+			return fmt.Errorf("invalid storage.compact.%s: %d (must not be negative)", l.name, l.limit)
+		}
+	}
+	return nil
+}
+
+// validatePartitions checks the time partitions, whose prefixes must all
+// differ
+func validatePartitions(partitions []core.PartitionConfig) error {
+	for i, partition := range partitions {
+		if partition.Prefix == "" {
+			return fmt.Errorf("storage.partitions[%d] needs a prefix", i)
+		}
+		if !core.ValidPartitionPeriod(partition.Period) {
+			return fmt.Errorf("invalid storage.partitions[%d].period: %q (must be hour or day)", i, partition.Period)
+		}
+		if partition.Retain <= 0 {
+			return fmt.Errorf("invalid storage.partitions[%d].retain: %d (must be positive)", i, partition.Retain)
+		}
+		for _, other := range partitions[:i] {
+			if other.Prefix == partition.Prefix {
+				return fmt.Errorf("storage.partitions has prefix %q twice", partition.Prefix)
+			}
+		}
+	}
 	return nil
 }
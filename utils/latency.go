@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// LatencyHistoryLen is the number of spikes kept per command, as in
+	// Redis's latency monitor
+	LatencyHistoryLen = 160
+
+	// latencySamples is the number of recent samples kept per command for
+	// percentile summaries
+	latencySamples = 1024
+)
+
+// LatencySpike is a command execution slower than the spike threshold
+type LatencySpike struct {
+	Time    int64 `json:"time"`    // Unix seconds
+	Latency int64 `json:"latency"` // Milliseconds
+}
+
+// LatencyLatest describes the most recent spike of one command, as
+// reported by LATENCY LATEST
+type LatencyLatest struct {
+	Event   string `json:"event"`
+	Time    int64  `json:"time"`
+	Latency int64  `json:"latency"`
+	Max     int64  `json:"max"`
+}
+
+// LatencySummary summarizes recent samples of a command family. Latencies
+// are in microseconds.
+type LatencySummary struct {
+	Count int64 `json:"count"` // Commands recorded since the last reset
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+
+// commandLatency holds one command's samples and spikes
+type commandLatency struct {
+	family  string
+	count   int64
+	samples []time.Duration // ring buffer of the most recent samples
+	next    int
+	spikes  []LatencySpike // oldest first, at most LatencyHistoryLen
+	max     int64          // largest spike in milliseconds
+}
+
+// LatencyMonitor records per-command latency samples and spikes above a
+// threshold. It is safe for concurrent use.
+type LatencyMonitor struct {
+	threshold atomic.Int64 // milliseconds, 0 disables spike recording
+	commands  map[string]*commandLatency
+	mu        sync.Mutex
+}
+
+// NewLatencyMonitor creates a monitor recording spikes of at least
+// threshold milliseconds
+func NewLatencyMonitor(threshold int64) *LatencyMonitor {
+	m := &LatencyMonitor{commands: make(map[string]*commandLatency)}
+	m.threshold.Store(threshold)
+	return m
+}
+
+// SetThreshold changes the spike threshold in milliseconds
+func (m *LatencyMonitor) SetThreshold(threshold int64) {
+	m.threshold.Store(threshold)
+}
+
+// Threshold returns the spike threshold in milliseconds
+func (m *LatencyMonitor) Threshold() int64 {
+	return m.threshold.Load()
+}
+
+// Record adds a sample for command, which belongs to family
+func (m *LatencyMonitor) Record(command, family string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.commands[command]
+	if !exists {
+		entry = &commandLatency{family: family, samples: make([]time.Duration, 0, 16)}
+		m.commands[command] = entry
+	}
+	entry.count++
+	if len(entry.samples) < latencySamples {
+		entry.samples = append(entry.samples, elapsed)
+	} else {
+		entry.samples[entry.next] = elapsed
+		entry.next = (entry.next + 1) % latencySamples
+	}
+
+	threshold := m.threshold.Load()
+	ms := elapsed.Milliseconds()
+	if threshold <= 0 || ms < threshold {
+		return
+	}
+	now := time.Now().Unix()
+	// Spikes within the same second are merged, keeping the worst
+	if n := len(entry.spikes); n > 0 && entry.spikes[n-1].Time == now {
+		if ms > entry.spikes[n-1].Latency {
+			entry.spikes[n-1].Latency = ms
+		}
+	} else {
+		if n == LatencyHistoryLen {
+			entry.spikes = append(entry.spikes[:0], entry.spikes[1:]...)
+		}
+		entry.spikes = append(entry.spikes, LatencySpike{Time: now, Latency: ms})
+	}
+	if ms > entry.max {
+		entry.max = ms
+	}
+}
+
+// Latest returns the most recent spike of every command that has one,
+// ordered by command name
+func (m *LatencyMonitor) Latest() []LatencyLatest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := make([]LatencyLatest, 0)
+	for command, entry := range m.commands {
+		if len(entry.spikes) == 0 {
+			continue
+		}
+		last := entry.spikes[len(entry.spikes)-1]
+		latest = append(latest, LatencyLatest{
+			Event:   command,
+			Time:    last.Time,
+			Latency: last.Latency,
+			Max:     entry.max,
+		})
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].Event < latest[j].Event
+	})
+	return latest
+}
+
+// History returns the recorded spikes of one command, oldest first
+func (m *LatencyMonitor) History(command string) []LatencySpike {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.commands[command]
+	if !exists {
+		return []LatencySpike{}
+	}
+	return append([]LatencySpike(nil), entry.spikes...)
+}
+
+// Reset discards the samples and spikes of the given commands, or of all
+// commands when none are given. It returns the number of commands that
+// had spikes.
+func (m *LatencyMonitor) Reset(commands ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(commands) == 0 {
+		reset := 0
+		for _, entry := range m.commands {
+			if len(entry.spikes) > 0 {
+				reset++
+			}
+		}
+		m.commands = make(map[string]*commandLatency)
+		return reset
+	}
+
+	reset := 0
+	for _, command := range commands {
+		if entry, exists := m.commands[command]; exists {
+			if len(entry.spikes) > 0 {
+				reset++
+			}
+			delete(m.commands, command)
+		}
+	}
+	return reset
+}
+
+// Summaries returns percentile summaries of the recent samples of each
+// command family
+func (m *LatencyMonitor) Summaries() map[string]LatencySummary {
+	m.mu.Lock()
+	samples := make(map[string][]time.Duration)
+	counts := make(map[string]int64)
+	for _, entry := range m.commands {
+		samples[entry.family] = append(samples[entry.family], entry.samples...)
+		counts[entry.family] += entry.count
+	}
+	m.mu.Unlock()
+
+	summaries := make(map[string]LatencySummary, len(samples))
+	for family, durations := range samples {
+		sort.Slice(durations, func(i, j int) bool {
+			return durations[i] < durations[j]
+		})
+		summaries[family] = LatencySummary{
+			Count: counts[family],
+			P50:   percentile(durations, 50),
+			P90:   percentile(durations, 90),
+			P99:   percentile(durations, 99),
+			Max:   durations[len(durations)-1].Microseconds(),
+		}
+	}
+	return summaries
+}
+
+// percentile uses the nearest-rank method on sorted samples
+func percentile(sorted []time.Duration, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Microseconds()
+}
@@ -1,14 +1,19 @@
 package utils
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/nitrix4ly/triff/core"
 	"github.com/sirupsen/logrus"
 )
 
 // Logger wraps logrus for consistent logging across the application
 type Logger struct {
 	*logrus.Logger
+	output io.Closer // log file, nil when logging to stdout
 }
 
 // NewLogger creates a new logger instance
@@ -34,6 +39,84 @@ func NewLogger(level string) *Logger {
 	return &Logger{Logger: logger}
 }
 
+// NewLoggerFromConfig creates a logger using the level, format and output
+// file from the logging configuration
+func NewLoggerFromConfig(config core.LoggingConfig) (*Logger, error) {
+	logger := NewLogger(config.Level)
+	if config.File != "" {
+		if err := logger.SetFile(config.File, RotateOptionsFromConfig(config)); err != nil {
+			return nil, err
+		}
+	}
+	if err := logger.SetFormat(config.Format); err != nil {
+		logger.Close()
+		return nil, err
+	}
+	return logger, nil
+}
+
+// RotateOptionsFromConfig converts the rotation settings of a logging configuration
+func RotateOptionsFromConfig(config core.LoggingConfig) RotateOptions {
+	return RotateOptions{
+		MaxSize:    config.MaxSize * 1024 * 1024,
+		MaxAge:     time.Duration(config.MaxAge) * time.Hour,
+		MaxBackups: config.MaxBackups,
+	}
+}
+
+// SetFormat switches between "text" and "json" output
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case "", "text":
+		l.Logger.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+			ForceColors:   l.output == nil,
+		})
+	case "json":
+		l.Logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
+
+// SetFile redirects output to a rotating log file
+func (l *Logger) SetFile(path string, opts RotateOptions) error {
+	file, err := NewRotatingFile(path, opts)
+	if err != nil {
+		return err
+	}
+	if l.output != nil {
+		l.output.Close()
+	}
+	l.output = file
+	l.Logger.SetOutput(file)
+	return nil
+}
+
+// Close closes the log file, if any
+func (l *Logger) Close() error {
+	if l.output == nil {
+		return nil
+	}
+	l.Logger.SetOutput(os.Stdout)
+	err := l.output.Close()
+	l.output = nil
+	return err
+}
+
+// SetLogLevel changes the log level at runtime
+func (l *Logger) SetLogLevel(level string) error {
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.Logger.SetLevel(logLevel)
+	return nil
+}
+
 // Info logs an info message
 func (l *Logger) Info(message string) {
 	l.Logger.Info(message)
@@ -64,6 +147,11 @@ func (l *Logger) WithField(key string, value interface{}) *logrus.Entry {
 	return l.Logger.WithField(key, value)
 }
 
+// WithRequestID returns an entry tagged with a request ID
+func (l *Logger) WithRequestID(id string) *logrus.Entry {
+	return l.Logger.WithField("request_id", id)
+}
+
 // WithFields adds multiple fields to the logger
 func (l *Logger) WithFields(fields logrus.Fields) *logrus.Entry {
 	return l.Logger.WithFields(fields)
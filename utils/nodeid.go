@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// LoadNodeID gives the instance a node ID that survives restarts, so its
+// CRDT values keep one identity when a pod is rescheduled with its volume.
+// With storage.node_id unset and storage.node_id_file set, it reads the ID
+// from the file, or on first start writes a new random one there, and sets
+// storage.node_id to it. Call it before core.NewDatabase.
+func LoadNodeID(config *core.Config) error {
+	path := config.Storage.NodeIDFile
+	if config.Storage.NodeID != "" || path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if id == "" {
+			return fmt.Errorf("node ID file %s is empty", path)
+		}
+		config.Storage.NodeID = id
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return err
+	}
+	config.Storage.NodeID = id
+	return nil
+}
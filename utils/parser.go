@@ -2,18 +2,161 @@ package utils
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
+// ErrUnbalancedQuotes is returned for a command line with an unterminated
+// quoted argument, or a closing quote not followed by a space
+var ErrUnbalancedQuotes = errors.New("unbalanced quotes in request")
+
 // ParseCommand parses a raw command into name and arguments.
 // Example: "SET key value" → name="SET", args=["key", "value"]
+// Arguments may be quoted as in SplitArgs: `SET greeting "hello world"`.
 func ParseCommand(input string) (string, []string, error) {
-	input = strings.TrimSpace(input)
-	if input == "" {
+	parts, err := SplitArgs(input)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(parts) == 0 {
 		return "", nil, errors.New("empty command")
 	}
-	parts := strings.Fields(input)
 	cmd := strings.ToUpper(parts[0])
 	args := parts[1:]
 	return cmd, args, nil
 }
+
+// SplitArgs splits an inline command line into arguments the way
+// redis-cli does. Arguments are separated by whitespace. Double quotes
+// allow spaces and the escapes \n, \r, \t, \b, \a, \\, \" and \xHH.
+// Single quotes take everything literally except \'.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		var quote byte
+		if line[i] == '"' || line[i] == '\'' {
+			quote = line[i]
+			i++
+		}
+		for {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			if quote == 0 {
+				if isSpace(c) {
+					break
+				}
+				arg = append(arg, c)
+				i++
+				continue
+			}
+			if c == quote {
+				// The closing quote must end the argument
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, ErrUnbalancedQuotes
+				}
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(line) {
+				if quote == '\'' {
+					if line[i+1] == '\'' {
+						arg = append(arg, '\'')
+						i += 2
+						continue
+					}
+				} else if b, n, ok := unescape(line[i+1:]); ok {
+					arg = append(arg, b)
+					i += 1 + n
+					continue
+				}
+			}
+			arg = append(arg, c)
+			i++
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// JoinArgs encodes arguments as an inline command line that SplitArgs
+// splits back into them, double-quoting the ones that are empty or hold
+// spaces, quotes, backslashes or control characters
+func JoinArgs(args []string) string {
+	var line strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		plain := arg != ""
+		for j := 0; j < len(arg) && plain; j++ {
+			c := arg[j]
+			plain = c > ' ' && c != 0x7f && c != '"' && c != '\'' && c != '\\'
+		}
+		if plain {
+			line.WriteString(arg)
+			continue
+		}
+		line.WriteByte('"')
+		for j := 0; j < len(arg); j++ {
+			switch c := arg[j]; {
+			case c == '"' || c == '\\':
+				line.WriteByte('\\')
+				line.WriteByte(c)
+			case c == '\n':
+				line.WriteString(`\n`)
+			case c == '\r':
+				line.WriteString(`\r`)
+			case c < ' ' || c == 0x7f:
+				fmt.Fprintf(&line, `\x%02x`, c)
+			default:
+				line.WriteByte(c)
+			}
+		}
+		line.WriteByte('"')
+	}
+	return line.String()
+}
+
+// unescape decodes the escape after a backslash in a double-quoted
+// argument, returning the byte and how many characters it took
+func unescape(s string) (byte, int, bool) {
+	switch s[0] {
+	case 'n':
+		return '\n', 1, true
+	case 'r':
+		return '\r', 1, true
+	case 't':
+		return '\t', 1, true
+	case 'b':
+		return '\b', 1, true
+	case 'a':
+		return '\a', 1, true
+	case 'x':
+		if len(s) >= 3 {
+			if b, err := strconv.ParseUint(s[1:3], 16, 8); err == nil {
+				return byte(b), 3, true
+			}
+		}
+		return 0, 0, false
+	}
+	// \\, \" and any other escaped character stand for themselves
+	return s[0], 1, true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
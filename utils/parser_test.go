@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"flag"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	seed  = flag.Int64("seed", 0, "seed for the random cases, 0 for a new one")
+	cases = flag.Int("cases", 10000, "random cases per property")
+)
+
+// newRand returns the source of a test's random cases, logging its seed
+// so that a failure can be replayed with -seed
+func newRand(t *testing.T) *rand.Rand {
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", s)
+	return rand.New(rand.NewSource(s))
+}
+
+// argBytes are the bytes random arguments are made of, weighted towards
+// the ones the parser treats specially
+const argBytes = "ab \t\"'\\\r\n\x00\x7f\xff\xc3\xa9x"
+
+func randomArg(rng *rand.Rand) string {
+	b := make([]byte, rng.Intn(12))
+	for i := range b {
+		if rng.Intn(8) == 0 {
+			b[i] = byte(rng.Intn(256))
+		} else {
+			b[i] = argBytes[rng.Intn(len(argBytes))]
+		}
+	}
+	return string(b)
+}
+
+// FuzzSplitArgs checks that no line panics the parser, and that a line
+// it accepts means the same once rejoined
+func FuzzSplitArgs(f *testing.F) {
+	for _, line := range []string{
+		"",
+		"SET key value",
+		`SET greeting "hello world"`,
+		`SET k "a\x00b\r\nc"`,
+		`SET k 'it\'s'`,
+		`SET k "unterminated`,
+		`SET k "closed"glued`,
+		`SET k "\xzz" "\`,
+		"  \t\v\f ",
+		"GET \xff\xfe",
+	} {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		ParseCommand(line)
+		args, err := SplitArgs(line)
+		if err != nil {
+			return
+		}
+		again, err := SplitArgs(JoinArgs(args))
+		if err != nil || !reflect.DeepEqual(again, args) {
+			t.Fatalf("%q split into %q, rejoined into %q (%v)", line, args, again, err)
+		}
+	})
+}
+
+// FuzzJoinArgs checks that any arguments, binary ones included, survive
+// being joined into a command line and split again
+func FuzzJoinArgs(f *testing.F) {
+	f.Add("SET", "key", "value")
+	f.Add("", " ", `"`)
+	f.Add("a\x00b", "\r\n", `\x41`)
+	f.Add("'", "\xff\xc3\xa9", "\x7f\t")
+	f.Fuzz(func(t *testing.T, a, b, c string) {
+		args := []string{a, b, c}
+		line := JoinArgs(args)
+		got, err := SplitArgs(line)
+		if err != nil || !reflect.DeepEqual(got, args) {
+			t.Fatalf("%q from %q split into %q (%v)", line, args, got, err)
+		}
+	})
+}
+
+func TestSplitArgsRandomLines(t *testing.T) {
+	rng := newRand(t)
+	for i := 0; i < *cases; i++ {
+		line := randomArg(rng) + " " + randomArg(rng) + randomArg(rng)
+		ParseCommand(line)
+		args, err := SplitArgs(line)
+		if err != nil || len(args) == 0 {
+			continue
+		}
+		again, err := SplitArgs(JoinArgs(args))
+		if err != nil || !reflect.DeepEqual(again, args) {
+			t.Fatalf("%q split into %q, rejoined into %q (%v)", line, args, again, err)
+		}
+	}
+}
+
+func TestJoinArgsRoundTrip(t *testing.T) {
+	rng := newRand(t)
+	for i := 0; i < *cases; i++ {
+		args := make([]string, 1+rng.Intn(5))
+		for j := range args {
+			args[j] = randomArg(rng)
+		}
+		line := JoinArgs(args)
+		got, err := SplitArgs(line)
+		if err != nil || !reflect.DeepEqual(got, args) {
+			t.Fatalf("%q from %q split into %q (%v)", line, args, got, err)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{"SET key value", []string{"SET", "key", "value"}, nil},
+		{`SET greeting "hello world"`, []string{"SET", "greeting", "hello world"}, nil},
+		{`SET k "a\x00b\r\n"`, []string{"SET", "k", "a\x00b\r\n"}, nil},
+		{`SET k 'it\'s'`, []string{"SET", "k", "it's"}, nil},
+		{`SET k ""`, []string{"SET", "k", ""}, nil},
+		{"   ", nil, nil},
+		{`SET k "open`, nil, ErrUnbalancedQuotes},
+		{`SET k "a"b`, nil, ErrUnbalancedQuotes},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, %v; want %q, %v", tt.line, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import "strconv"
+
+// RESPWriter encodes RESP replies into a buffer that is reused from one
+// reply to the next. Lengths and integers are formatted in place, so once
+// the buffer has grown to fit the replies written, encoding allocates
+// nothing.
+type RESPWriter struct {
+	buf []byte
+}
+
+// NewRESPWriter creates a writer with size bytes of scratch space
+func NewRESPWriter(size int) *RESPWriter {
+	return &RESPWriter{buf: make([]byte, 0, size)}
+}
+
+// Reset empties the buffer, keeping its space
+func (w *RESPWriter) Reset() {
+	w.buf = w.buf[:0]
+}
+
+// Bytes returns the encoded replies. They are only valid until the next
+// write or Reset.
+func (w *RESPWriter) Bytes() []byte {
+	return w.buf
+}
+
+// Len returns the number of bytes encoded
+func (w *RESPWriter) Len() int {
+	return len(w.buf)
+}
+
+// Cap returns the size of the scratch space
+func (w *RESPWriter) Cap() int {
+	return cap(w.buf)
+}
+
+// ArrayHeader starts an array of n elements
+func (w *RESPWriter) ArrayHeader(n int) {
+	w.buf = append(w.buf, '*')
+	w.buf = strconv.AppendInt(w.buf, int64(n), 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Bulk writes a bulk string
+func (w *RESPWriter) Bulk(s string) {
+	w.buf = append(w.buf, '$')
+	w.buf = strconv.AppendInt(w.buf, int64(len(s)), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Null writes a null bulk string, the reply for a missing value
+func (w *RESPWriter) Null() {
+	w.buf = append(w.buf, '$', '-', '1', '\r', '\n')
+}
+
+// Int writes an integer
+func (w *RESPWriter) Int(n int64) {
+	w.buf = append(w.buf, ':')
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Uint writes an unsigned integer
+func (w *RESPWriter) Uint(n uint64) {
+	w.buf = append(w.buf, ':')
+	w.buf = strconv.AppendUint(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Line writes a status or error reply, such as "+OK" or "-ERR message".
+// A line can't hold CR or LF, so they are written as spaces.
+func (w *RESPWriter) Line(s string) {
+	start := len(w.buf)
+	w.buf = append(w.buf, s...)
+	for i := start; i < len(w.buf); i++ {
+		if w.buf[i] == '\r' || w.buf[i] == '\n' {
+			w.buf[i] = ' '
+		}
+	}
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Raw writes an already encoded reply that lacks its final CRLF
+func (w *RESPWriter) Raw(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The benchmarks compare encoding TCP replies with fmt.Sprintf and string
+// concatenation, as the server used to, against a reused RESPWriter:
+//
+//	go test ./utils -run - -bench RESPWriter
+
+func BenchmarkRESPWriterInteger(b *testing.B) {
+	benchmarkReply(b,
+		func() []byte { return []byte(fmt.Sprintf(":%d", int64(1234567)) + "\r\n") },
+		func(w *RESPWriter) { w.Int(1234567) })
+}
+
+func BenchmarkRESPWriterBulk(b *testing.B) {
+	key := "user:0"
+	benchmarkReply(b,
+		func() []byte { return []byte(fmt.Sprintf("$%d\r\n%s", len(key), key) + "\r\n") },
+		func(w *RESPWriter) { w.Bulk(key) })
+}
+
+func BenchmarkRESPWriterStatus(b *testing.B) {
+	benchmarkReply(b,
+		func() []byte { return []byte(strings.NewReplacer("\r", " ", "\n", " ").Replace("+OK") + "\r\n") },
+		func(w *RESPWriter) { w.Line("+OK") })
+}
+
+func BenchmarkRESPWriterArray(b *testing.B) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user:%d", i)
+	}
+	benchmarkReply(b,
+		func() []byte {
+			var sb strings.Builder
+			sb.WriteString(fmt.Sprintf("*%d", len(keys)))
+			for _, key := range keys {
+				sb.WriteString(fmt.Sprintf("\r\n$%d\r\n%s", len(key), key))
+			}
+			return []byte(sb.String() + "\r\n")
+		},
+		func(w *RESPWriter) {
+			w.ArrayHeader(len(keys))
+			for _, key := range keys {
+				w.Bulk(key)
+			}
+		})
+}
+
+// benchmarkReply runs a reply's fmt encoding and its RESPWriter encoding
+// as sub-benchmarks, after checking they agree
+func benchmarkReply(b *testing.B, format func() []byte, write func(w *RESPWriter)) {
+	w := NewRESPWriter(4096)
+	write(w)
+	if want := format(); string(w.Bytes()) != string(want) {
+		b.Fatalf("RESPWriter wrote %q, fmt %q", w.Bytes(), want)
+	}
+
+	b.Run("fmt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			format()
+		}
+	})
+	b.Run("RESPWriter", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			write(w)
+		}
+	})
+}
+
+func TestRESPWriter(t *testing.T) {
+	w := NewRESPWriter(0)
+	w.ArrayHeader(4)
+	w.Bulk("a\r\nb")
+	w.Int(-42)
+	w.Null()
+	w.Uint(7)
+	w.Line("-ERR bad\r\nline")
+	w.Raw("+OK")
+
+	want := "*4\r\n$4\r\na\r\nb\r\n:-42\r\n$-1\r\n:7\r\n-ERR bad  line\r\n+OK\r\n"
+	if got := string(w.Bytes()); got != want {
+		t.Fatalf("wrote %q, want %q", got, want)
+	}
+	w.Reset()
+	if w.Len() != 0 {
+		t.Fatalf("Len after Reset = %d", w.Len())
+	}
+}
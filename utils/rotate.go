@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotateOptions controls when a RotatingFile starts a new file. Zero values
+// disable the corresponding limit.
+type RotateOptions struct {
+	MaxSize    int64         // Bytes written before rotating
+	MaxAge     time.Duration // Age of the current file before rotating
+	MaxBackups int           // Rotated files to keep, oldest are removed first
+}
+
+// RotatingFile is an io.WriteCloser that appends to a file and rotates it
+// by size and age. Rotated files are renamed to <path>.<timestamp>.
+type RotatingFile struct {
+	path   string
+	opts   RotateOptions
+	file   *os.File
+	size   int64
+	opened time.Time
+	mu     sync.Mutex
+}
+
+// NewRotatingFile opens (or creates) path for appending
+func NewRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, opts: opts}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends p, rotating first if a limit has been reached
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate forces a rotation
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.rotate()
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) shouldRotate(next int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.opts.MaxSize > 0 && rf.size+next > rf.opts.MaxSize {
+		return true
+	}
+	return rf.opts.MaxAge > 0 && time.Since(rf.opened) >= rf.opts.MaxAge
+}
+
+func (rf *RotatingFile) open() error {
+	if dir := filepath.Dir(rf.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+// rotate renames the current file and opens a fresh one. Callers must hold rf.mu.
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		if err := rf.file.Close(); err != nil {
+			return err
+		}
+		rf.file = nil
+	}
+
+	backup := fmt.Sprintf("%s.%s", rf.path, time.Now().Format("20060102-150405.000000"))
+	if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.prune()
+	return nil
+}
+
+// prune removes rotated files beyond MaxBackups
+func (rf *RotatingFile) prune() {
+	if rf.opts.MaxBackups <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+	// Timestamps sort lexicographically, so the oldest come first
+	sort.Strings(rotated)
+	for len(rotated) > rf.opts.MaxBackups {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
@@ -0,0 +1,452 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+var (
+	ErrUnknownParameter  = errors.New("unknown configuration parameter")
+	ErrReadOnlyParameter = errors.New("parameter can't be changed at runtime, restart required")
+	ErrNoConfigFile      = errors.New("no configuration file to reload")
+)
+
+// configParam describes a parameter exposed through CONFIG GET/SET
+type configParam struct {
+	get func(c *core.Config) string
+	set func(c *core.Config, value string) error // nil for restart-only parameters
+}
+
+var configParams = map[string]configParam{
+	"host": {
+		get: func(c *core.Config) string { return c.Server.Host },
+	},
+	"port": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Server.Port) },
+	},
+	"http-port": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Server.HTTPPort) },
+	},
+	"enable-http": {
+		get: func(c *core.Config) string { return strconv.FormatBool(c.Server.EnableHTTP) },
+	},
+	"enable-tcp": {
+		get: func(c *core.Config) string { return strconv.FormatBool(c.Server.EnableTCP) },
+	},
+	"engine": {
+		get: func(c *core.Config) string { return c.Storage.Engine },
+	},
+	"persistence-path": {
+		get: func(c *core.Config) string { return c.Persistence.Path },
+	},
+	"maxmemory": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Storage.MaxMemory, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Storage.MaxMemory = n
+			return nil
+		},
+	},
+	"loglevel": {
+		get: func(c *core.Config) string { return c.Logging.Level },
+		set: func(c *core.Config, value string) error {
+			c.Logging.Level = strings.ToLower(value)
+			return nil
+		},
+	},
+	"log-file": {
+		get: func(c *core.Config) string { return c.Logging.File },
+	},
+	"audit-file": {
+		get: func(c *core.Config) string { return c.Logging.AuditFile },
+	},
+	"admin-audit-file": {
+		get: func(c *core.Config) string { return c.Logging.AdminAuditFile },
+	},
+	"log-format": {
+		get: func(c *core.Config) string { return c.Logging.Format },
+		set: func(c *core.Config, value string) error {
+			c.Logging.Format = strings.ToLower(value)
+			return nil
+		},
+	},
+	"maxclients": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Limits.MaxClients) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			c.Limits.MaxClients = n
+			return nil
+		},
+	},
+	"max-keys": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Limits.MaxKeys) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			c.Limits.MaxKeys = n
+			return nil
+		},
+	},
+	"max-key-size": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Limits.MaxKeySize) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			c.Limits.MaxKeySize = n
+			return nil
+		},
+	},
+	"max-elements": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Limits.MaxElements) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			c.Limits.MaxElements = n
+			return nil
+		},
+	},
+	"max-value-size": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Limits.MaxValueSize, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Limits.MaxValueSize = n
+			return nil
+		},
+	},
+	"command-timeout": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Limits.CommandTimeout, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Limits.CommandTimeout = n
+			return nil
+		},
+	},
+	"save-interval": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Persistence.SaveInterval, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Persistence.SaveInterval = n
+			return nil
+		},
+	},
+	"save": {
+		// Redis's "900 1 300 10" form; empty turns auto-saves off
+		get: func(c *core.Config) string { return core.FormatSavePoints(c.Persistence.Save) },
+		set: func(c *core.Config, value string) error {
+			points, err := core.ParseSavePoints(value)
+			if err != nil {
+				return err
+			}
+			c.Persistence.Save = points
+			if len(points) == 0 {
+				c.Persistence.SaveInterval = 0
+			}
+			return nil
+		},
+	},
+	"latency-monitor-threshold": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Logging.LatencyThreshold, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Logging.LatencyThreshold = n
+			return nil
+		},
+	},
+	"slowlog-threshold": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Logging.SlowlogThreshold, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Logging.SlowlogThreshold = n
+			return nil
+		},
+	},
+	"stale-grace": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Storage.StaleGrace, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Storage.StaleGrace = n
+			return nil
+		},
+	},
+	"ttl-jitter": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Storage.TTLJitter) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			if n < 0 || n > 100 {
+				return errors.New("must be a percentage from 0 to 100")
+			}
+			c.Storage.TTLJitter = n
+			return nil
+		},
+	},
+	"dependency-depth": {
+		get: func(c *core.Config) string { return strconv.Itoa(c.Storage.DependencyDepth) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return errors.New("must not be negative")
+			}
+			c.Storage.DependencyDepth = n
+			return nil
+		},
+	},
+	"soft-delete": {
+		get: func(c *core.Config) string { return strconv.FormatInt(c.Storage.SoftDelete, 10) },
+		set: func(c *core.Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return errors.New("must not be negative")
+			}
+			c.Storage.SoftDelete = n
+			return nil
+		},
+	},
+	"zero-copy": {
+		get: func(c *core.Config) string { return yesNo(c.Storage.ZeroCopy) },
+		set: func(c *core.Config, value string) error {
+			return parseYesNo(value, &c.Storage.ZeroCopy)
+		},
+	},
+	"read-only": {
+		get: func(c *core.Config) string { return yesNo(c.Server.ReadOnly) },
+		set: func(c *core.Config, value string) error {
+			return parseYesNo(value, &c.Server.ReadOnly)
+		},
+	},
+	"maintenance": {
+		get: func(c *core.Config) string { return yesNo(c.Server.Maintenance) },
+		set: func(c *core.Config, value string) error {
+			return parseYesNo(value, &c.Server.Maintenance)
+		},
+	},
+	"stats-prefixes": {
+		get: func(c *core.Config) string { return strings.Join(c.Storage.StatsPrefixes, ",") },
+	},
+}
+
+// ConfigManager applies runtime configuration changes to a database.
+// The active configuration lives in the database, so several managers
+// (one per server) stay consistent with each other.
+type ConfigManager struct {
+	db        *core.Database
+	path      string
+	logger    *Logger
+	listeners []func(old, new *core.Config)
+	mu        sync.Mutex
+}
+
+// NewConfigManager creates a manager for db. path is the YAML file used by
+// Reload and may be empty.
+func NewConfigManager(db *core.Database, path string, logger *Logger) *ConfigManager {
+	return &ConfigManager{
+		db:     db,
+		path:   path,
+		logger: logger,
+	}
+}
+
+// OnChange registers a callback invoked after every applied change
+func (cm *ConfigManager) OnChange(listener func(old, new *core.Config)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.listeners = append(cm.listeners, listener)
+}
+
+// Get returns all parameters whose name matches a glob pattern
+func (cm *ConfigManager) Get(pattern string) map[string]string {
+	config := cm.db.Config()
+	result := make(map[string]string)
+	for name, param := range configParams {
+		if core.MatchPattern(strings.ToLower(pattern), name) {
+			result[name] = param.get(config)
+		}
+	}
+	return result
+}
+
+// Set changes a single parameter after validating the resulting configuration
+func (cm *ConfigManager) Set(name, value string) error {
+	param, exists := configParams[strings.ToLower(name)]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownParameter, name)
+	}
+	if param.set == nil {
+		return fmt.Errorf("%w: %s", ErrReadOnlyParameter, name)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	updated := cm.db.Config()
+	if err := param.set(updated, value); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	return cm.apply(updated)
+}
+
+// Reload re-reads the configuration file (and environment overrides),
+// validates it and applies every runtime-changeable parameter, in name
+// order so save is applied before save-interval. Parameters that need a
+// restart keep their current value.
+func (cm *ConfigManager) Reload() error {
+	if cm.path == "" {
+		return ErrNoConfigFile
+	}
+
+	loaded, err := MergeConfigs(cm.path)
+	if err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	current := cm.db.Config()
+	updated := cm.db.Config()
+	for _, name := range cm.Parameters() {
+		param := configParams[name]
+		if param.set == nil {
+			if param.get(loaded) != param.get(current) && cm.logger != nil {
+				cm.logger.Warn(fmt.Sprintf("config reload: %s changed, restart required to apply", name))
+			}
+			continue
+		}
+		if err := param.set(updated, param.get(loaded)); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return cm.apply(updated)
+}
+
+// WatchSignals reloads the configuration whenever the process receives
+// SIGHUP. It returns a function that stops watching.
+func (cm *ConfigManager) WatchSignals() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := cm.Reload(); err != nil {
+					if cm.logger != nil {
+						cm.logger.Error(fmt.Sprintf("config reload failed: %v", err))
+					}
+					continue
+				}
+				if cm.logger != nil {
+					cm.logger.Info("configuration reloaded")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Parameters returns the names of all known parameters
+func (cm *ConfigManager) Parameters() []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply validates and activates a configuration. Callers must hold cm.mu.
+func (cm *ConfigManager) apply(updated *core.Config) error {
+	if err := ValidateConfig(updated); err != nil {
+		return err
+	}
+
+	old := cm.db.Config()
+	cm.db.SetConfig(updated)
+
+	if cm.logger != nil && old.Logging.Level != updated.Logging.Level {
+		cm.logger.SetLogLevel(updated.Logging.Level)
+	}
+	if cm.logger != nil && old.Logging.Format != updated.Logging.Format {
+		cm.logger.SetFormat(updated.Logging.Format)
+	}
+	for _, listener := range cm.listeners {
+		listener(old, updated)
+	}
+	return nil
+}
+
+// yesNo formats a boolean parameter the way Redis does
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseYesNo sets a boolean parameter from "yes" or "no"
+func parseYesNo(value string, target *bool) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*target = true
+	case "no":
+		*target = false
+	default:
+		return errors.New("must be yes or no")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nitrix4ly/triff/core"
+)
+
+// TLSConfig builds the server TLS configuration from security.tls_cert
+// and security.tls_key, each either a file or the PEM itself, so they can
+// come straight from environment variables. It returns nil if TLS isn't
+// configured.
+func TLSConfig(security core.SecurityConfig) (*tls.Config, error) {
+	if security.TLSCert == "" && security.TLSKey == "" {
+		return nil, nil
+	}
+	cert, err := pemMaterial(security.TLSCert)
+	if err != nil {
+		return nil, fmt.Errorf("tls_cert: %v", err)
+	}
+	key, err := pemMaterial(security.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("tls_key: %v", err)
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// pemMaterial returns value if it is PEM, and otherwise reads the file it
+// names
+func pemMaterial(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/tracing"
+)
+
+// SetupTracing installs a process-wide tracer exporting to the configured
+// OTLP endpoint. The returned function flushes pending spans and must be
+// called on shutdown. When tracing is disabled it does nothing.
+func SetupTracing(config core.TracingConfig, logger *Logger) func(ctx context.Context) error {
+	if !config.Enabled {
+		return func(ctx context.Context) error { return nil }
+	}
+
+	exporter := tracing.NewOTLPExporter(config.Endpoint, config.ServiceName)
+	if logger != nil {
+		exporter.OnError(func(err error) {
+			logger.Warn(fmt.Sprintf("trace export failed: %v", err))
+		})
+	}
+	tracer := tracing.NewTracer(config.ServiceName, exporter, config.SampleRate)
+	tracing.SetTracer(tracer)
+
+	return func(ctx context.Context) error {
+		tracing.SetTracer(nil)
+		return tracer.Shutdown(ctx)
+	}
+}
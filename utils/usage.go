@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/core/errs"
+)
+
+// AnonymousUsage is the name the usage of clients without an API key is
+// recorded under
+const AnonymousUsage = "anonymous"
+
+// UsageRecord is one API key's usage on one UTC day
+type UsageRecord struct {
+	Date         string `json:"date"`    // YYYY-MM-DD
+	APIKey       string `json:"api_key"` // Name of the key, or "anonymous"
+	Commands     int64  `json:"commands"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	StorageBytes int64  `json:"storage_bytes"` // Peak sampled memory of the key's namespace
+	Rejected     int64  `json:"rejected"`      // Commands refused for a used up quota
+}
+
+// UsageMeter accounts commands, bandwidth and storage per API key in daily
+// rollups, and enforces the keys' daily quotas. The TCP and HTTP servers
+// share one meter; the rollups are saved to a file every sample interval.
+type UsageMeter struct {
+	db     *core.Database
+	config core.UsageConfig
+	logger *Logger
+
+	records map[string]*UsageRecord // By date and key name
+	changed bool                    // Records differ from the file
+	mu      sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewUsageMeter creates a meter, continuing from the rollups saved at
+// config.Path if there are any
+func NewUsageMeter(db *core.Database, config core.UsageConfig, logger *Logger) (*UsageMeter, error) {
+	m := &UsageMeter{
+		db:      db,
+		config:  config,
+		logger:  logger,
+		records: make(map[string]*UsageRecord),
+	}
+	data, err := os.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*UsageRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("reading usage file %s: %w", config.Path, err)
+	}
+	for _, record := range records {
+		m.records[record.Date+"/"+record.APIKey] = record
+	}
+	return m, nil
+}
+
+// Start samples storage and saves the rollups every sample interval until
+// Stop is called
+func (m *UsageMeter) Start() {
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(time.Duration(m.config.SampleInterval) * time.Second)
+		defer ticker.Stop()
+
+		m.sample()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+				if err := m.Save(); err != nil && m.logger != nil {
+					m.logger.Error(fmt.Sprintf("saving usage failed: %v", err))
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling and saves the rollups
+func (m *UsageMeter) Stop() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return m.Save()
+}
+
+// Allow checks the named API key's daily quotas before a command runs.
+// Anonymous clients have no quota.
+func (m *UsageMeter) Allow(name string) error {
+	var apiKey core.APIKeyConfig
+	for _, configured := range m.db.Config().Security.APIKeys {
+		if configured.Name == name {
+			apiKey = configured
+		}
+	}
+	if apiKey.DailyCommands == 0 && apiKey.DailyBytes == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record := m.today(apiKey.Name)
+	var err error
+	if apiKey.DailyCommands > 0 && record.Commands >= apiKey.DailyCommands {
+		err = errs.Newf(errs.ErrQuota, "api key %s used its daily quota of %d commands", apiKey.Name, apiKey.DailyCommands)
+	} else if apiKey.DailyBytes > 0 && record.BytesIn+record.BytesOut >= apiKey.DailyBytes {
+		err = errs.Newf(errs.ErrQuota, "api key %s used its daily quota of %d bytes", apiKey.Name, apiKey.DailyBytes)
+	}
+	if err != nil {
+		record.Rejected++
+		m.changed = true
+	}
+	return err
+}
+
+// Record accounts one command of the named key with the bytes of its
+// request and response
+func (m *UsageMeter) Record(name string, bytesIn, bytesOut int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record := m.today(name)
+	record.Commands++
+	record.BytesIn += bytesIn
+	record.BytesOut += bytesOut
+	m.changed = true
+}
+
+// Usage returns the rollups of the named key, or of every key if name is
+// empty, from and to are inclusive dates (YYYY-MM-DD), either of which may
+// be empty. Records are sorted by date, then key.
+func (m *UsageMeter) Usage(name, from, to string) []UsageRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := make([]UsageRecord, 0)
+	for _, record := range m.records {
+		if (name != "" && record.APIKey != name) || (from != "" && record.Date < from) || (to != "" && record.Date > to) {
+			continue
+		}
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].APIKey < records[j].APIKey
+	})
+	return records
+}
+
+// Save writes the rollups to the usage file if they changed, dropping the
+// days past the retention
+func (m *UsageMeter) Save() error {
+	m.mu.Lock()
+	cutoff := time.Now().UTC().AddDate(0, 0, -m.config.Retention).Format(usageDateFormat)
+	for id, record := range m.records {
+		if m.config.Retention > 0 && record.Date <= cutoff {
+			delete(m.records, id)
+			m.changed = true
+		}
+	}
+	if !m.changed {
+		m.mu.Unlock()
+		return nil
+	}
+	records := make([]*UsageRecord, 0, len(m.records))
+	for _, record := range m.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+	m.changed = false
+	m.mu.Unlock()
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp := m.config.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.config.Path)
+}
+
+// sample records the memory of every API key's namespace, keeping the
+// day's peak
+func (m *UsageMeter) sample() {
+	apiKeys := m.db.Config().Security.APIKeys
+	prefixes := make([]string, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		if apiKey.Namespace != "" {
+			prefixes = append(prefixes, apiKey.Namespace)
+		}
+	}
+	if len(prefixes) == 0 {
+		return
+	}
+	usage := m.db.MemoryByPrefix(prefixes)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, apiKey := range apiKeys {
+		if apiKey.Namespace == "" {
+			continue
+		}
+		if record := m.today(apiKey.Name); usage[apiKey.Namespace] > record.StorageBytes {
+			record.StorageBytes = usage[apiKey.Namespace]
+			m.changed = true
+		}
+	}
+}
+
+const usageDateFormat = "2006-01-02"
+
+// today returns the named key's record for the current UTC day, creating
+// it if needed. Callers must hold m.mu.
+func (m *UsageMeter) today(name string) *UsageRecord {
+	date := time.Now().UTC().Format(usageDateFormat)
+	record, ok := m.records[date+"/"+name]
+	if !ok {
+		record = &UsageRecord{Date: date, APIKey: name}
+		m.records[date+"/"+name] = record
+	}
+	return record
+}
+
+// QuotaReset is when daily quotas start over: the next UTC midnight
+func QuotaReset(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
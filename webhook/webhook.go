@@ -0,0 +1,311 @@
+// Package webhook notifies external services when keys matching a pattern
+// are set, deleted or expire, by POSTing a JSON payload to their URL.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nitrix4ly/triff/core"
+	"github.com/nitrix4ly/triff/utils"
+)
+
+const (
+	// queueSize bounds deliveries waiting for a worker; further events are
+	// dropped and counted rather than blocking database writes
+	queueSize = 10000
+	workers   = 4
+
+	maxAttempts  = 5
+	firstBackoff = time.Second
+)
+
+// Events a webhook can subscribe to
+var validEvents = map[string]bool{
+	string(core.ChangeSet):    true,
+	string(core.ChangeDelete): true,
+	string(core.ChangeExpire): true,
+}
+
+// ErrNotFound is returned for unknown webhook IDs
+var ErrNotFound = errors.New("webhook not found")
+
+// Webhook is a registered subscription
+type Webhook struct {
+	ID        string    `json:"id"`
+	Pattern   string    `json:"pattern"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"` // Deliveries dropped after the last retry
+}
+
+// Payload is the JSON body POSTed to a webhook
+type Payload struct {
+	WebhookID string      `json:"webhook_id"`
+	Event     string      `json:"event"`
+	Key       string      `json:"key"`
+	Type      string      `json:"type,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	Version   uint64      `json:"version,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type delivery struct {
+	hook    *Webhook
+	payload Payload
+	attempt int
+}
+
+// Manager keeps the registered webhooks and delivers events to them with
+// retries and exponential backoff
+type Manager struct {
+	hooks   map[string]*Webhook
+	mu      sync.RWMutex
+	queue   chan delivery
+	dropped uint64
+	client  *http.Client
+	logger  *utils.Logger
+}
+
+// NewManager creates a manager, registers the webhooks defined in the
+// database configuration and starts watching the database for changes
+func NewManager(db *core.Database, logger *utils.Logger) *Manager {
+	m := &Manager{
+		hooks:  make(map[string]*Webhook),
+		queue:  make(chan delivery, queueSize),
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+	}
+	for _, hook := range db.Config().Webhooks {
+		if _, err := m.Add(hook.Pattern, hook.URL, hook.Events, hook.Secret); err != nil {
+			logger.Error(fmt.Sprintf("webhook: skipping configured webhook for %s: %v", hook.URL, err))
+		}
+	}
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	db.OnChange(m.dispatch)
+	return m
+}
+
+// Add registers a webhook. An empty events list subscribes to all events.
+func (m *Manager) Add(pattern, target string, events []string, secret string) (Webhook, error) {
+	if pattern == "" {
+		return Webhook{}, errors.New("pattern is required")
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, errors.New("url must be an absolute http or https URL")
+	}
+	if len(events) == 0 {
+		events = []string{string(core.ChangeSet), string(core.ChangeDelete), string(core.ChangeExpire)}
+	}
+	for _, event := range events {
+		if !validEvents[event] {
+			return Webhook{}, fmt.Errorf("unknown event %q (must be set, del or expire)", event)
+		}
+	}
+
+	hook := &Webhook{
+		ID:        newID(),
+		Pattern:   pattern,
+		URL:       target,
+		Events:    append([]string(nil), events...),
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	m.mu.Lock()
+	m.hooks[hook.ID] = hook
+	m.mu.Unlock()
+	return hook.snapshot(), nil
+}
+
+// Remove unregisters a webhook
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.hooks[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.hooks, id)
+	return nil
+}
+
+// Get returns a webhook with its delivery counters
+func (m *Manager) Get(id string) (Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hook, exists := m.hooks[id]
+	if !exists {
+		return Webhook{}, ErrNotFound
+	}
+	return hook.snapshot(), nil
+}
+
+// List returns all webhooks, oldest first
+func (m *Manager) List() []Webhook {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hooks := make([]Webhook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		hooks = append(hooks, hook.snapshot())
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+// Dropped returns how many deliveries were discarded because the queue was full
+func (m *Manager) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// dispatch is the database change listener. It runs under the database
+// lock, so matching deliveries are only queued here.
+func (m *Manager) dispatch(event core.ChangeEvent) {
+	if !validEvents[string(event.Op)] {
+		return
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, hook := range m.hooks {
+		if !hook.wants(string(event.Op)) || !core.MatchPattern(hook.Pattern, event.Key) {
+			continue
+		}
+		m.enqueue(delivery{
+			hook: hook,
+			payload: Payload{
+				WebhookID: hook.ID,
+				Event:     string(event.Op),
+				Key:       event.Key,
+				Type:      event.Type,
+				Value:     event.Value,
+				Version:   event.Version,
+				Timestamp: event.Time,
+			},
+		})
+	}
+}
+
+func (m *Manager) enqueue(d delivery) {
+	select {
+	case m.queue <- d:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+func (m *Manager) work() {
+	for d := range m.queue {
+		if !m.registered(d.hook) {
+			// Removed since the event was queued
+			continue
+		}
+		err := m.deliver(d)
+		if err == nil {
+			atomic.AddUint64(&d.hook.Delivered, 1)
+			continue
+		}
+
+		d.attempt++
+		if d.attempt >= maxAttempts {
+			atomic.AddUint64(&d.hook.Failed, 1)
+			m.logger.Warn(fmt.Sprintf("webhook: giving up on %s for key %s after %d attempts: %v", d.hook.URL, d.payload.Key, d.attempt, err))
+			continue
+		}
+		// Retry later without holding up the worker
+		backoff := firstBackoff << (d.attempt - 1)
+		time.AfterFunc(backoff, func() { m.enqueue(d) })
+	}
+}
+
+// deliver POSTs one payload, treating any 2xx response as success
+func (m *Manager) deliver(d delivery) error {
+	body, err := json.Marshal(d.payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "triff-webhook")
+	req.Header.Set("X-Triff-Event", d.payload.Event)
+	if d.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Triff-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("responded %s", resp.Status)
+	}
+	return nil
+}
+
+// registered reports whether a webhook is still registered, so retries
+// stop once it is removed
+func (m *Manager) registered(hook *Webhook) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.hooks[hook.ID] == hook
+}
+
+func (h *Webhook) wants(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Webhook) snapshot() Webhook {
+	return Webhook{
+		ID:        h.ID,
+		Pattern:   h.Pattern,
+		URL:       h.URL,
+		Events:    append([]string(nil), h.Events...),
+		Secret:    h.Secret,
+		CreatedAt: h.CreatedAt,
+		Delivered: atomic.LoadUint64(&h.Delivered),
+		Failed:    atomic.LoadUint64(&h.Failed),
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}